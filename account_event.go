@@ -0,0 +1,77 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AccountEvent is an event from a vendor about an account rather than any one channel, such as a WhatsApp Business
+// Account being reviewed or restricted
+type AccountEvent struct {
+	ChannelType ChannelType     `json:"channel_type"`
+	AccountID   string          `json:"account_id"`
+	EventType   string          `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedOn   time.Time       `json:"created_on"`
+}
+
+// NewAccountEvent creates a new account event for the passed in channel type, account, event type and payload
+func NewAccountEvent(channelType ChannelType, accountID string, eventType string, payload json.RawMessage) *AccountEvent {
+	return &AccountEvent{
+		ChannelType: channelType,
+		AccountID:   accountID,
+		EventType:   eventType,
+		Payload:     payload,
+		CreatedOn:   time.Now().In(time.UTC),
+	}
+}
+
+// addAccountEventRoute registers the account route of the passed in handler if it receives account events
+func (s *server) addAccountEventRoute(handler ChannelHandler) {
+	accountHandler, isAccountHandler := handler.(AccountEventHandler)
+	if !isAccountHandler {
+		return
+	}
+
+	path := fmt.Sprintf("/%s/account", strings.ToLower(string(handler.ChannelType())))
+	s.chanRouter.Post(path, s.accountEventWrapper(handler.ChannelType(), accountHandler))
+	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), "account"))
+}
+
+// accountEventWrapper handles account event requests, writing each event the handler returns
+func (s *server) accountEventWrapper(channelType ChannelType, handler AccountEventHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		baseCtx := context.WithValue(r.Context(), contextRequestURL, r.URL.String())
+		baseCtx = context.WithValue(baseCtx, contextRequestStart, time.Now())
+
+		ctx, cancel := context.WithTimeout(baseCtx, time.Second*30)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		events, err := handler.ReceiveAccountEvents(ctx, w, r)
+		if err != nil {
+			LogRequestError(r, nil, err)
+			WriteError(ctx, w, r, err)
+			return
+		}
+
+		data := make([]interface{}, len(events))
+		for i, event := range events {
+			err := s.backend.WriteAccountEvent(ctx, event)
+			if err != nil {
+				logrus.WithError(err).WithField("channel_type", channelType).WithField("account_id", event.AccountID).Error("error writing account event")
+				WriteError(ctx, w, r, err)
+				return
+			}
+			data[i] = event
+		}
+
+		WriteDataResponse(ctx, w, http.StatusOK, "Account Events Handled", data)
+	}
+}
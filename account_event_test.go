@@ -0,0 +1,68 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// accountHandler is an embedded handler which also receives account events
+type accountHandler struct {
+	embeddedHandler
+}
+
+func (h *accountHandler) ReceiveAccountEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]*AccountEvent, error) {
+	payload := &struct {
+		Account string          `json:"account"`
+		Event   string          `json:"event"`
+		Value   json.RawMessage `json:"value"`
+	}{}
+	err := json.NewDecoder(r.Body).Decode(payload)
+	if err != nil || payload.Account == "" {
+		return nil, fmt.Errorf("invalid account event")
+	}
+	return []*AccountEvent{NewAccountEvent(h.ChannelType(), payload.Account, payload.Event, payload.Value)}, nil
+}
+
+func TestAccountEvents(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+
+	server := NewEmbeddedServer(config, mb)
+	server.AddHandler(&accountHandler{})
+
+	host := httptest.NewServer(server.Router())
+	defer host.Close()
+
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	req, _ := http.NewRequest(http.MethodPost, host.URL+"/c/em/account", strings.NewReader(`{"account": "102290129340398", "event": "account_update", "value": {"event": "DISABLED_UPDATE"}}`))
+	rr, err := utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "Account Events Handled")
+
+	events := mb.AccountEvents()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, ChannelType("EM"), events[0].ChannelType)
+		assert.Equal(t, "102290129340398", events[0].AccountID)
+		assert.Equal(t, "account_update", events[0].EventType)
+		assert.JSONEq(t, `{"event": "DISABLED_UPDATE"}`, string(events[0].Payload))
+	}
+
+	// errors decoding events are returned to the caller
+	req, _ = http.NewRequest(http.MethodPost, host.URL+"/c/em/account", strings.NewReader(`{}`))
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Contains(t, string(rr.Body), "invalid account event")
+	assert.Len(t, mb.AccountEvents(), 1)
+}
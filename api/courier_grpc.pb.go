@@ -0,0 +1,178 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.21.12
+// source: courier.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Courier_SendMsg_FullMethodName        = "/courier.api.v1.Courier/SendMsg"
+	Courier_StreamStatuses_FullMethodName = "/courier.api.v1.Courier/StreamStatuses"
+)
+
+// CourierClient is the client API for Courier service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type CourierClient interface {
+	// SendMsg writes an outgoing message and queues it to be sent on its channel
+	SendMsg(ctx context.Context, in *SendMsgRequest, opts ...grpc.CallOption) (*SendMsgResponse, error)
+	// StreamStatuses streams the statuses of the messages of a channel as they are written, until the caller cancels
+	StreamStatuses(ctx context.Context, in *StreamStatusesRequest, opts ...grpc.CallOption) (Courier_StreamStatusesClient, error)
+}
+
+type courierClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCourierClient(cc grpc.ClientConnInterface) CourierClient {
+	return &courierClient{cc}
+}
+
+func (c *courierClient) SendMsg(ctx context.Context, in *SendMsgRequest, opts ...grpc.CallOption) (*SendMsgResponse, error) {
+	out := new(SendMsgResponse)
+	err := c.cc.Invoke(ctx, Courier_SendMsg_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *courierClient) StreamStatuses(ctx context.Context, in *StreamStatusesRequest, opts ...grpc.CallOption) (Courier_StreamStatusesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Courier_ServiceDesc.Streams[0], Courier_StreamStatuses_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &courierStreamStatusesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Courier_StreamStatusesClient interface {
+	Recv() (*MsgStatus, error)
+	grpc.ClientStream
+}
+
+type courierStreamStatusesClient struct {
+	grpc.ClientStream
+}
+
+func (x *courierStreamStatusesClient) Recv() (*MsgStatus, error) {
+	m := new(MsgStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CourierServer is the server API for Courier service.
+// All implementations must embed UnimplementedCourierServer
+// for forward compatibility
+type CourierServer interface {
+	// SendMsg writes an outgoing message and queues it to be sent on its channel
+	SendMsg(context.Context, *SendMsgRequest) (*SendMsgResponse, error)
+	// StreamStatuses streams the statuses of the messages of a channel as they are written, until the caller cancels
+	StreamStatuses(*StreamStatusesRequest, Courier_StreamStatusesServer) error
+	mustEmbedUnimplementedCourierServer()
+}
+
+// UnimplementedCourierServer must be embedded to have forward compatible implementations.
+type UnimplementedCourierServer struct {
+}
+
+func (UnimplementedCourierServer) SendMsg(context.Context, *SendMsgRequest) (*SendMsgResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMsg not implemented")
+}
+func (UnimplementedCourierServer) StreamStatuses(*StreamStatusesRequest, Courier_StreamStatusesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamStatuses not implemented")
+}
+func (UnimplementedCourierServer) mustEmbedUnimplementedCourierServer() {}
+
+// UnsafeCourierServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CourierServer will
+// result in compilation errors.
+type UnsafeCourierServer interface {
+	mustEmbedUnimplementedCourierServer()
+}
+
+func RegisterCourierServer(s grpc.ServiceRegistrar, srv CourierServer) {
+	s.RegisterService(&Courier_ServiceDesc, srv)
+}
+
+func _Courier_SendMsg_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMsgRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CourierServer).SendMsg(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Courier_SendMsg_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CourierServer).SendMsg(ctx, req.(*SendMsgRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Courier_StreamStatuses_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamStatusesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CourierServer).StreamStatuses(m, &courierStreamStatusesServer{stream})
+}
+
+type Courier_StreamStatusesServer interface {
+	Send(*MsgStatus) error
+	grpc.ServerStream
+}
+
+type courierStreamStatusesServer struct {
+	grpc.ServerStream
+}
+
+func (x *courierStreamStatusesServer) Send(m *MsgStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Courier_ServiceDesc is the grpc.ServiceDesc for Courier service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Courier_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "courier.api.v1.Courier",
+	HandlerType: (*CourierServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SendMsg",
+			Handler:    _Courier_SendMsg_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamStatuses",
+			Handler:       _Courier_StreamStatuses_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "courier.proto",
+}
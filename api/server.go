@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// how many status streams we follow at once, each holding its own Redis connection
+const maxStatusStreams = 100
+
+// how long we wait for calls in progress to finish when stopping before cutting them off
+const stopTimeout = 10 * time.Second
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative courier.proto
+
+// Server serves our gRPC API, which lets internal services submit outgoing msgs and follow their statuses with less
+// overhead than our HTTP endpoints
+type Server struct {
+	UnimplementedCourierServer
+
+	backend  courier.Backend
+	redisURL string
+	token    string
+	grpc     *grpc.Server
+	listener net.Listener
+	streams  chan struct{}
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer creates a new gRPC API server for the passed in backend, which streams statuses from the Redis at the passed
+// in URL, callers must pass the passed in token
+func NewServer(backend courier.Backend, redisURL string, token string) *Server {
+	s := &Server{backend: backend, redisURL: redisURL, token: token, streams: make(chan struct{}, maxStatusStreams), stopping: make(chan struct{})}
+	s.grpc = grpc.NewServer(grpc.UnaryInterceptor(s.authorizeUnary), grpc.StreamInterceptor(s.authorizeStream))
+	RegisterCourierServer(s.grpc, s)
+	return s
+}
+
+// Start starts listening on the passed in address, serving requests in the background
+func (s *Server) Start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.grpc.Serve(listener); err != nil {
+			logrus.WithError(err).WithField("comp", "grpc").Error("error serving gRPC API")
+		}
+	}()
+
+	logrus.WithField("comp", "grpc").WithField("address", listener.Addr().String()).Info("gRPC API listening")
+	return nil
+}
+
+// Addr returns the address we are listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Stop stops serving, closing any open status streams and giving other calls in progress time to finish. Stopping an
+// already stopped server is a noop.
+func (s *Server) Stop() {
+	s.stopOnce.Do(s.stop)
+}
+
+func (s *Server) stop() {
+	close(s.stopping)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(stopTimeout):
+		logrus.WithField("comp", "grpc").Warning("gRPC calls still in progress, stopping anyway")
+		s.grpc.Stop()
+	}
+
+	logrus.WithField("comp", "grpc").Info("gRPC API stopped")
+}
+
+// SendMsg writes an outgoing msg and queues it to be sent on its channel
+func (s *Server) SendMsg(ctx context.Context, req *SendMsgRequest) (*SendMsgResponse, error) {
+	channel, err := s.getChannel(ctx, req.ChannelUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	urn, err := urns.Parse(req.Urn)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid urn: %s", req.Urn)
+	}
+	if req.Text == "" && len(req.Attachments) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "msgs must have text or attachments")
+	}
+
+	var meta json.RawMessage
+	if req.Metadata != "" {
+		if !json.Valid([]byte(req.Metadata)) || !strings.HasPrefix(strings.TrimSpace(req.Metadata), "{") {
+			return nil, status.Error(codes.InvalidArgument, "metadata must be a JSON object")
+		}
+		meta = json.RawMessage(req.Metadata)
+	}
+
+	msg, err := s.backend.QueueOutgoingMsg(ctx, channel, urn, req.Text, req.Attachments, req.QuickReplies, req.HighPriority, meta)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error queueing msg from gRPC API")
+		return nil, status.Errorf(codes.Internal, "error queueing msg: %s", err)
+	}
+
+	return &SendMsgResponse{MsgId: int64(msg.ID()), MsgUuid: msg.UUID().String()}, nil
+}
+
+// StreamStatuses streams the statuses of the msgs of a channel as they are written, until the caller goes away or we stop
+func (s *Server) StreamStatuses(req *StreamStatusesRequest, stream Courier_StreamStatusesServer) error {
+	select {
+	case s.streams <- struct{}{}:
+		defer func() { <-s.streams }()
+	default:
+		return status.Errorf(codes.ResourceExhausted, "too many status streams, at most %d can be followed at once", cap(s.streams))
+	}
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	channel, err := s.getChannel(ctx, req.ChannelUuid)
+	if err != nil {
+		return err
+	}
+
+	err = courier.StreamMsgStatuses(ctx, s.redisURL, channel.UUID(), func(streamed *courier.StreamedMsgStatus) error {
+		return stream.Send(&MsgStatus{
+			ChannelUuid: streamed.ChannelUUID.String(),
+			MsgId:       int64(streamed.MsgID),
+			ExternalId:  streamed.ExternalID,
+			Status:      string(streamed.Status),
+			CreatedOn:   streamed.CreatedOn.UnixNano() / 1000000,
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		return status.Errorf(codes.Unavailable, "error streaming statuses: %s", err)
+	}
+	return nil
+}
+
+// getChannel returns the channel with the passed in UUID or the gRPC error to return if there's no such channel
+func (s *Server) getChannel(ctx context.Context, uuid string) (courier.Channel, error) {
+	channelUUID, err := courier.NewChannelUUID(uuid)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid channel_uuid: %s", uuid)
+	}
+	channel, err := s.backend.GetChannel(ctx, courier.AnyChannelType, channelUUID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "no such channel: %s", uuid)
+	}
+	return channel, nil
+}
+
+// authorize checks the passed in context of a call has our token as its bearer token
+func (s *Server) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token := strings.TrimPrefix(value, "Bearer ")
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing bearer token")
+}
+
+func (s *Server) authorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authorizeStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authorize(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
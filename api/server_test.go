@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer(t *testing.T) {
+	mb := courier.NewMockBackend()
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	server := NewServer(mb, "redis://localhost:6379/0", "sesame")
+	server.streams = make(chan struct{}, 1)
+	require.NoError(t, server.Start("127.0.0.1:0"))
+
+	conn, err := grpc.Dial(server.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := NewCourierClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer sesame")
+
+	// calls need our token
+	_, err = client.SendMsg(ctx, &SendMsgRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", Urn: "whatsapp:5511999999999", Text: "hi"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.SendMsg(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer open"), &SendMsgRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", Urn: "whatsapp:5511999999999", Text: "hi"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// and valid msgs
+	_, err = client.SendMsg(authed, &SendMsgRequest{ChannelUuid: "00000000-5ecb-45ba-b726-3b064e0c56ab", Urn: "whatsapp:5511999999999", Text: "hi"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.SendMsg(authed, &SendMsgRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", Urn: "foo", Text: "hi"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.SendMsg(authed, &SendMsgRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", Urn: "whatsapp:5511999999999"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.SendMsg(authed, &SendMsgRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", Urn: "whatsapp:5511999999999", Text: "hi", Metadata: "[]"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := client.SendMsg(authed, &SendMsgRequest{
+		ChannelUuid:  "8eb23e93-5ecb-45ba-b726-3b064e0c56ab",
+		Urn:          "whatsapp:5511999999999",
+		Text:         "Your order has shipped",
+		Attachments:  []string{"image/jpeg:https://example.com/label.jpg"},
+		QuickReplies: []string{"Track"},
+		HighPriority: true,
+		Metadata:     `{"topic": "purchase"}`,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.MsgId)
+	assert.NotEmpty(t, resp.MsgUuid)
+
+	// which are queued to be sent
+	msg, err := mb.PopNextOutgoingMsg(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, msg)
+	assert.Equal(t, courier.NewMsgID(1), msg.ID())
+	assert.Equal(t, "Your order has shipped", msg.Text())
+	assert.Equal(t, []string{"image/jpeg:https://example.com/label.jpg"}, msg.Attachments())
+	assert.Equal(t, []string{"Track"}, msg.QuickReplies())
+	assert.True(t, msg.HighPriority())
+	assert.JSONEq(t, `{"topic": "purchase"}`, string(msg.Metadata()))
+
+	// statuses of the channel are streamed as they're published
+	stream, err := client.StreamStatuses(authed, &StreamStatusesRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab"})
+	require.NoError(t, err)
+
+	// give our subscription a moment to be set up
+	time.Sleep(100 * time.Millisecond)
+
+	other := courier.NewMockChannel("e4bb1578-29da-4fa5-a214-9da19dd24230", "WAC", "54321", "US", nil)
+	require.NoError(t, courier.PublishMsgStatus(mb.RedisPool(), mb.NewMsgStatusForID(other, courier.NewMsgID(2), courier.MsgWired)))
+	require.NoError(t, courier.PublishMsgStatus(mb.RedisPool(), mb.NewMsgStatusForID(channel, courier.NewMsgID(1), courier.MsgWired)))
+	require.NoError(t, courier.PublishMsgStatus(mb.RedisPool(), mb.NewMsgStatusForExternalID(channel, "wamid.1", courier.MsgDelivered)))
+
+	streamed, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", streamed.ChannelUuid)
+	assert.Equal(t, int64(1), streamed.MsgId)
+	assert.Equal(t, "W", streamed.Status)
+	assert.NotZero(t, streamed.CreatedOn)
+
+	streamed, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "wamid.1", streamed.ExternalId)
+	assert.Equal(t, "D", streamed.Status)
+
+	// only so many streams can be followed at once
+	refused, err := client.StreamStatuses(authed, &StreamStatusesRequest{ChannelUuid: "8eb23e93-5ecb-45ba-b726-3b064e0c56ab"})
+	require.NoError(t, err)
+	_, err = refused.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// and stopping ends those open rather than waiting on them
+	start := time.Now()
+	server.Stop()
+	assert.Less(t, time.Since(start), stopTimeout)
+
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	// stopping again, e.g. from a deferred cleanup, is a noop
+	server.Stop()
+}
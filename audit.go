@@ -0,0 +1,168 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// how many audit records we list by default and at most
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditRecord records an action taken through one of our admin endpoints: who took it, when and what it affected
+type AuditRecord struct {
+	Action      string    `json:"action"`
+	Actor       string    `json:"actor"`
+	Address     string    `json:"address"`
+	ChannelUUID string    `json:"channel_uuid,omitempty"`
+	AffectedIDs []string  `json:"affected_ids"`
+	CreatedOn   time.Time `json:"created_on"`
+}
+
+// NewAuditRecord creates a new audit record for the passed in action taken by the passed in request, on the passed in
+// channel if not nil
+func NewAuditRecord(config *Config, r *http.Request, action string, channel Channel, affectedIDs []string) *AuditRecord {
+	actor, _, _ := r.BasicAuth()
+	if actor == "" {
+		actor = "anonymous"
+	}
+	if affectedIDs == nil {
+		affectedIDs = []string{}
+	}
+	channelUUID := ""
+	if channel != nil {
+		channelUUID = channel.UUID().String()
+	}
+
+	return &AuditRecord{
+		Action:      action,
+		Actor:       actor,
+		Address:     requestAddress(config, r),
+		ChannelUUID: channelUUID,
+		AffectedIDs: affectedIDs,
+		CreatedOn:   time.Now().In(time.UTC),
+	}
+}
+
+// WriteAuditRecord logs the passed in audit record and persists it through the passed in backend
+func WriteAuditRecord(ctx context.Context, backend Backend, record *AuditRecord) error {
+	logrus.WithFields(logrus.Fields{
+		"comp":         "audit",
+		"action":       record.Action,
+		"actor":        record.Actor,
+		"address":      record.Address,
+		"channel_uuid": record.ChannelUUID,
+		"affected_ids": record.AffectedIDs,
+	}).Info("admin action taken")
+
+	return backend.WriteAuditRecord(ctx, record)
+}
+
+// handleAudit lists the most recent audit records, newest first
+func (s *server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	limit := defaultAuditLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		parsed, err := strconv.Atoi(l)
+		if err != nil || parsed <= 0 {
+			WriteError(r.Context(), w, r, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+
+	records, err := s.backend.GetAuditRecords(r.Context(), limit)
+	if err != nil {
+		logrus.WithError(err).Error("error listing audit records")
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	data := make([]interface{}, len(records))
+	for i := range records {
+		data[i] = records[i]
+	}
+	WriteDataResponse(r.Context(), w, http.StatusOK, "Audit Records", data)
+}
+
+// requestAddress returns the address of the client which made the passed in request. The X-Forwarded-For header can be
+// set by anyone so it's only read when the request came from one of our trusted proxies, and then from the right, as
+// the address left of the last proxy we trust is the one which connected to it.
+func requestAddress(config *Config, r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+
+	trusted := parseTrustedProxies(config.TrustedProxies)
+	if !isTrustedProxy(trusted, address) {
+		return address
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		address = hop
+		if !isTrustedProxy(trusted, hop) {
+			break
+		}
+	}
+	return address
+}
+
+// parseTrustedProxies parses the passed in comma separated list of IPs and CIDRs, ignoring any which are invalid
+func parseTrustedProxies(proxies string) []*net.IPNet {
+	trusted := make([]*net.IPNet, 0)
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			logrus.WithField("proxy", proxy).Error("invalid trusted proxy")
+			continue
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted
+}
+
+// isTrustedProxy returns whether the passed in address is one of the passed in trusted proxies
+func isTrustedProxy(trusted []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditRecords(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+	config.TrustedProxies = "192.0.2.0/24, 10.0.0.1"
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/c/wac/onboard", nil)
+	r.SetBasicAuth("admin", "sesame")
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	record := NewAuditRecord(config, r, "onboard", nil, []string{"8eb23e93-5ecb-45ba-b726-3b064e0c56ab"})
+	assert.Equal(t, "admin", record.Actor)
+	assert.Equal(t, "203.0.113.7", record.Address)
+	assert.Equal(t, "", record.ChannelUUID)
+	assert.NoError(t, WriteAuditRecord(context.Background(), mb, record))
+
+	r = httptest.NewRequest(http.MethodPost, "/c/wac/business_profile", nil)
+	record = NewAuditRecord(config, r, "business_profile_update", channel, nil)
+	assert.Equal(t, "anonymous", record.Actor)
+	assert.Equal(t, "192.0.2.1", record.Address)
+	assert.Equal(t, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", record.ChannelUUID)
+	assert.Equal(t, []string{}, record.AffectedIDs)
+	assert.NoError(t, WriteAuditRecord(context.Background(), mb, record))
+
+	// the forwarded address is only taken from the right, skipping our proxies, so clients can't spoof it
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 203.0.113.7")
+	assert.Equal(t, "203.0.113.7", NewAuditRecord(config, r, "onboard", nil, nil).Address)
+
+	r.Header.Set("X-Forwarded-For", "10.0.0.1")
+	assert.Equal(t, "10.0.0.1", NewAuditRecord(config, r, "onboard", nil, nil).Address)
+
+	// and only when the request came from one of our proxies
+	r.RemoteAddr = "198.51.100.9:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, "198.51.100.9", NewAuditRecord(config, r, "onboard", nil, nil).Address)
+
+	config.TrustedProxies = ""
+	r.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "192.0.2.1", NewAuditRecord(config, r, "onboard", nil, nil).Address)
+
+	// records are listed newest first
+	status, body := server.request(http.MethodGet, "/c/audit", "", true)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"message":"Audit Records"`)
+	assert.Regexp(t, `"action":"business_profile_update".*"action":"onboard"`, body)
+
+	_, body = server.request(http.MethodGet, "/c/audit?limit=1", "", true)
+	assert.Contains(t, body, `"action":"business_profile_update"`)
+	assert.NotContains(t, body, `"action":"onboard"`)
+
+	status, body = server.request(http.MethodGet, "/c/audit?limit=foo", "", true)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid limit: foo")
+}
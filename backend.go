@@ -2,6 +2,7 @@ package courier
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -30,9 +31,26 @@ type Backend interface {
 	// GetChannelByAddress returns the channel with the passed in type and address
 	GetChannelByAddress(context.Context, ChannelType, ChannelAddress) (Channel, error)
 
+	// GetChannelByWebhookToken returns the channel with the passed in type and webhook token
+	GetChannelByWebhookToken(context.Context, ChannelType, string) (Channel, error)
+
+	// GetChannelsByConfig returns the active channels with the passed in type whose config has the passed in value for
+	// the passed in key, e.g. the WhatsApp channels of a business account
+	GetChannelsByConfig(ctx context.Context, channelType ChannelType, key string, value string) ([]Channel, error)
+
+	// UpdateChannelConfig merges the passed in values into the config of the passed in channel
+	UpdateChannelConfig(context.Context, Channel, map[string]interface{}) error
+
+	// CreateChannel creates a new active channel with the passed in type, address, name, schemes and config for the org with the passed in id
+	CreateChannel(ctx context.Context, orgID int64, channelType ChannelType, address ChannelAddress, name string, schemes []string, config map[string]interface{}) (Channel, error)
+
 	// GetContact returns (or creates) the contact for the passed in channel and URN
 	GetContact(context context.Context, channel Channel, urn urns.URN, auth string, name string) (Contact, error)
 
+	// LookupContact returns the contact with the passed in URN in the org of the passed in channel without creating it,
+	// or nil if there isn't one
+	LookupContact(ctx context.Context, channel Channel, urn urns.URN) (Contact, error)
+
 	// UpdateContactLastSeenOn updates last seen on (and modified on) on the passed in contact
 	UpdateContactLastSeenOn(ctx context.Context, contactUUID ContactUUID, lastSeenOn time.Time) error
 
@@ -42,15 +60,24 @@ type Backend interface {
 	// RemoveURNFromcontact removes a URN from the passed in contact
 	RemoveURNfromContact(context context.Context, channel Channel, contact Contact, urn urns.URN) (urns.URN, error)
 
-	// DeleteMsgWithExternalID delete a message we receive an event that it should be deleted
-	DeleteMsgWithExternalID(ctx context.Context, channel Channel, externalID string) error
+	// DeleteMsgWithExternalID marks the incoming message with the passed in external ID as deleted, if it was received on
+	// the passed in channel from the passed in URN and isn't deleted already, returning whether a message was deleted
+	DeleteMsgWithExternalID(ctx context.Context, channel Channel, urn urns.URN, externalID string) (bool, error)
 
 	// NewIncomingMsg creates a new message from the given params
 	NewIncomingMsg(channel Channel, urn urns.URN, text string) Msg
 
+	// NewSyncedMsg creates a new message that was sent or received outside of courier (e.g. from a
+	// paired business app), these are stored for history but never queued for handling
+	NewSyncedMsg(channel Channel, urn urns.URN, text string, outgoing bool) Msg
+
 	// WriteMsg writes the passed in message to our backend
 	WriteMsg(context.Context, Msg) error
 
+	// AddMsgMetadata sets the passed in key of the metadata of the written msg with the passed in UUID, for work done
+	// after writing it, returning ErrMsgNotFound if it hasn't been written yet
+	AddMsgMetadata(context.Context, MsgUUID, string, interface{}) error
+
 	// NewMsgStatusForID creates a new Status object for the given message id
 	NewMsgStatusForID(Channel, MsgID, MsgStatusValue) MsgStatus
 
@@ -60,15 +87,64 @@ type Backend interface {
 	// WriteMsgStatus writes the passed in status update to our backend
 	WriteMsgStatus(context.Context, MsgStatus) error
 
+	// WriteMsgCostEstimate records the estimated cost of sending the passed in message
+	WriteMsgCostEstimate(context.Context, Msg, *MsgCostEstimate) error
+
+	// WriteMsgPricing adds the passed in reported pricing to the daily rollups of the channel
+	WriteMsgPricing(context.Context, Channel, *MsgPricing) error
+
 	// NewChannelEvent creates a new channel event for the given channel and event type
 	NewChannelEvent(Channel, ChannelEventType, urns.URN) ChannelEvent
 
 	// WriteChannelEvent writes the passed in channel even returning any error
 	WriteChannelEvent(context.Context, ChannelEvent) error
 
+	// WriteChannelHeartbeat records a success or failure for the passed in channel
+	WriteChannelHeartbeat(context.Context, Channel, ChannelHeartbeatStatus) error
+
+	// GetChannelHealth returns the health of the channel with the passed in UUID
+	GetChannelHealth(context.Context, ChannelUUID) (*ChannelHealth, error)
+
+	// GetChannelAlerts returns the latest alert events of the channel with the passed in UUID, newest first
+	GetChannelAlerts(ctx context.Context, channelUUID ChannelUUID, limit int) ([]ChannelEvent, error)
+
+	// WriteAccountEvent writes the passed in account event, which isn't tied to any channel, for later review
+	WriteAccountEvent(context.Context, *AccountEvent) error
+
+	// WriteAuditRecord persists the passed in record of an admin action
+	WriteAuditRecord(context.Context, *AuditRecord) error
+
+	// GetAuditRecords returns up to the passed in number of the most recent audit records, newest first
+	GetAuditRecords(context.Context, int) ([]*AuditRecord, error)
+
+	// WriteSendStat counts a msg of the passed in segment as sent by the passed in channel on the day of the passed in time
+	WriteSendStat(context.Context, Channel, string, time.Time) error
+
+	// GetSendStats returns the counts of msgs sent on the day of the passed in time, by channel and segment
+	GetSendStats(context.Context, time.Time) ([]*SendStat, error)
+
+	// ExportMsgs returns up to limit msgs of the passed in channel created within the passed in time range, with IDs
+	// greater than the passed in cursor, in order of ID
+	ExportMsgs(ctx context.Context, channel Channel, after time.Time, before time.Time, cursor MsgID, limit int) ([]*ExportedMsg, error)
+
+	// BackfillMedia re-resolves the attachments of incoming msgs created since the passed in time whose handlers can
+	// resolve fresh URLs for their expired media, storing what can still be downloaded and updating those msgs
+	BackfillMedia(ctx context.Context, since time.Time) (*MediaBackfillResult, error)
+
 	// WriteChannelLogs writes the passed in channel logs to our backend
 	WriteChannelLogs(context.Context, []*ChannelLog) error
 
+	// GetChannelLogs returns up to limit of the most recent logs of the passed in channel, only those of the passed in msg
+	// if it isn't NilMsgID, with their bodies as stored
+	GetChannelLogs(ctx context.Context, channel Channel, msgID MsgID, limit int) ([]*StoredChannelLog, error)
+
+	// QueueOutgoingMsg writes a new outgoing msg with the passed in params and queues it to be sent on its channel
+	QueueOutgoingMsg(ctx context.Context, channel Channel, urn urns.URN, text string, attachments []string, quickReplies []string, highPriority bool, metadata json.RawMessage) (Msg, error)
+
+	// NewSimulatedMsg builds an outgoing msg with the passed in params without writing or queueing it, on a copy of the
+	// passed in channel with the passed in config overrides, so its send can be simulated
+	NewSimulatedMsg(channel Channel, config map[string]interface{}, urn urns.URN, text string, attachments []string, quickReplies []string, metadata json.RawMessage) (Msg, error)
+
 	// PopNextOutgoingMsg returns the next message that needs to be sent, callers should call MarkOutgoingMsgComplete with the
 	// returned message when they have dealt with the message (regardless of whether it was sent or not)
 	PopNextOutgoingMsg(context.Context) (Msg, error)
@@ -90,6 +166,14 @@ type Backend interface {
 	// used to determine any sort of deduping of msg sends
 	MarkOutgoingMsgComplete(context.Context, Msg, MsgStatus)
 
+	// DeferOutgoingMsg puts the passed in popped message back to be sent again once the passed in time has passed. It
+	// should be called instead of MarkOutgoingMsgComplete for messages which weren't sent.
+	DeferOutgoingMsg(context.Context, Msg, time.Time) error
+
+	// OutgoingMsgNotifications returns a channel which receives a value whenever new outgoing messages may be waiting, so
+	// callers can pop them without waiting to poll again, or nil if the backend doesn't support notifications
+	OutgoingMsgNotifications() <-chan bool
+
 	// Check if external ID has been seen in a period
 	CheckExternalIDSeen(Msg) Msg
 
@@ -119,7 +203,12 @@ func NewBackend(config *Config) (Backend, error) {
 	if !found {
 		return nil, fmt.Errorf("no such backend type: '%s'", config.Backend)
 	}
-	return backendFunc(config), nil
+
+	backend := backendFunc(config)
+	if config.MirrorDB != "" {
+		backend = NewMirroredBackend(backend, backendFunc(newMirrorConfig(config)), config.MirrorQueueSize)
+	}
+	return backend, nil
 }
 
 // RegisterBackend adds a new backend, called by individual backends in their init() func
@@ -0,0 +1,41 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// list of the latest account events of each channel type, kept for ops to review
+	accountEventsKey = "account_events:%s"
+
+	// how many account events we keep for each channel type
+	accountEventsMax = 1000
+)
+
+// WriteAccountEvent adds the passed in account event to the list of latest events for its channel type
+func (b *backend) WriteAccountEvent(ctx context.Context, event *courier.AccountEvent) error {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(accountEventsKey, strings.ToLower(string(event.ChannelType)))
+	rc.Send("LPUSH", key, eventJSON)
+	rc.Send("LTRIM", key, 0, accountEventsMax-1)
+	_, err = rc.Do("")
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("channel_type", event.ChannelType).WithField("account_id", event.AccountID).WithField("event_type", event.EventType).Info("account event received")
+	return nil
+}
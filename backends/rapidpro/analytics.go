@@ -0,0 +1,125 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/librato"
+	"github.com/pkg/errors"
+)
+
+// the hash where we accumulate pricing counts until our heartbeat flushes them, fields are channel_id|day|category|model|billable
+const pricingRollupsKey = "pricing_rollups"
+
+// the hash being flushed, if a flush fails it is retried on the next heartbeat
+const pricingRollupsFlushingKey = "pricing_rollups:flushing"
+
+// the key we mark the msgs whose pricing we've counted with, by channel and external id
+const pricingCountedKey = "pricing_counted:%s:%s"
+
+// how long we remember counting the pricing of a msg, Meta reports it on every status of the msg so this only needs to
+// outlast the last of those and any retries of them
+const pricingCountedTTL = 60 * 60 * 24 * 7
+
+var luaCountMsgPricing = redis.NewScript(2, `-- KEYS: [CountedKey, RollupsKey] ARGV: [Field, TTL]
+	if not redis.call("set", KEYS[1], 1, "NX", "EX", ARGV[2]) then
+		return 0
+	end
+	redis.call("hincrby", KEYS[2], ARGV[1], 1)
+	return 1
+`)
+
+// WriteMsgPricing adds the passed in reported pricing to the daily rollups of the channel, once for each msg however
+// many of its statuses report it
+func (b *backend) WriteMsgPricing(ctx context.Context, channel courier.Channel, pricing *courier.MsgPricing) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	field := pricingRollupField(channel.(*DBChannel).ID(), pricing)
+
+	// without an external id we can't tell the statuses of a msg apart so count every one
+	if pricing.ExternalID == "" {
+		_, err := rc.Do("hincrby", pricingRollupsKey, field, 1)
+		return err
+	}
+
+	countedKey := fmt.Sprintf(pricingCountedKey, channel.UUID(), pricing.ExternalID)
+	_, err := luaCountMsgPricing.Do(rc, countedKey, pricingRollupsKey, field, pricingCountedTTL)
+	return err
+}
+
+const upsertPricingRollupSQL = `
+INSERT INTO
+	channels_pricingrollup(channel_id, day, category, pricing_model, is_billable, count)
+	VALUES($1, $2, $3, $4, $5, $6)
+ON CONFLICT (channel_id, day, category, pricing_model, is_billable)
+DO UPDATE SET count = channels_pricingrollup.count + EXCLUDED.count
+`
+
+// flushPricingRollups writes our accumulated pricing counts to the database, returning how many rollups were written
+func flushPricingRollups(ctx context.Context, b *backend) (int, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	// only start a new flush if the last one completed
+	flushing, err := redis.Bool(rc.Do("exists", pricingRollupsFlushingKey))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error checking pricing rollups")
+	}
+	if !flushing {
+		_, err := rc.Do("rename", pricingRollupsKey, pricingRollupsFlushingKey)
+		if err != nil {
+			// nothing to flush
+			if strings.Contains(err.Error(), "no such key") {
+				return 0, nil
+			}
+			return 0, errors.Wrapf(err, "error renaming pricing rollups")
+		}
+	}
+
+	counts, err := redis.IntMap(rc.Do("hgetall", pricingRollupsFlushingKey))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading pricing rollups")
+	}
+
+	tx, err := b.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	for field, count := range counts {
+		parts := strings.Split(field, "|")
+		if len(parts) != 5 {
+			continue
+		}
+		billable, _ := strconv.ParseBool(parts[4])
+
+		_, err = tx.ExecContext(ctx, upsertPricingRollupSQL, parts[0], parts[1], parts[2], parts[3], billable, count)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrapf(err, "error writing pricing rollup: %s", field)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = rc.Do("del", pricingRollupsFlushingKey)
+	if err != nil {
+		return len(counts), errors.Wrapf(err, "error clearing flushed pricing rollups")
+	}
+
+	librato.Gauge("courier.pricing_rollups_flushed", float64(len(counts)))
+	return len(counts), nil
+}
+
+// pricingRollupField is the hash field we count the passed in pricing under
+func pricingRollupField(channelID courier.ChannelID, pricing *courier.MsgPricing) string {
+	return fmt.Sprintf("%d|%s|%s|%s|%t", channelID, pricing.OccurredOn.UTC().Format("2006-01-02"), pricing.Category, pricing.PricingModel, pricing.Billable)
+}
@@ -0,0 +1,70 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/null"
+)
+
+const insertAuditRecordSQL = `
+INSERT INTO
+	channels_auditrecord(action, actor, address, channel_uuid, affected_ids, created_on)
+	VALUES($1, $2, $3, $4, $5, $6)
+`
+
+// WriteAuditRecord writes the passed in record to the database, where it outlives our Redis and can be reviewed
+func (b *backend) WriteAuditRecord(ctx context.Context, record *courier.AuditRecord) error {
+	affectedJSON, err := json.Marshal(record.AffectedIDs)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.ExecContext(ctx, insertAuditRecordSQL, record.Action, record.Actor, record.Address, null.String(record.ChannelUUID), string(affectedJSON), record.CreatedOn)
+	return err
+}
+
+const selectAuditRecordsSQL = `
+SELECT action, actor, address, channel_uuid, affected_ids, created_on
+FROM channels_auditrecord
+ORDER BY created_on DESC, id DESC
+LIMIT $1
+`
+
+// dbAuditRecord is an audit record as read from the database
+type dbAuditRecord struct {
+	Action      string      `db:"action"`
+	Actor       string      `db:"actor"`
+	Address     string      `db:"address"`
+	ChannelUUID null.String `db:"channel_uuid"`
+	AffectedIDs string      `db:"affected_ids"`
+	CreatedOn   time.Time   `db:"created_on"`
+}
+
+// GetAuditRecords returns up to the passed in number of the most recent audit records, newest first
+func (b *backend) GetAuditRecords(ctx context.Context, limit int) ([]*courier.AuditRecord, error) {
+	rows := make([]*dbAuditRecord, 0, limit)
+	err := b.db.SelectContext(ctx, &rows, selectAuditRecordsSQL, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*courier.AuditRecord, 0, len(rows))
+	for _, row := range rows {
+		record := &courier.AuditRecord{
+			Action:      row.Action,
+			Actor:       row.Actor,
+			Address:     row.Address,
+			ChannelUUID: string(row.ChannelUUID),
+			CreatedOn:   row.CreatedOn.In(time.UTC),
+		}
+		err := json.Unmarshal([]byte(row.AffectedIDs), &record.AffectedIDs)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -17,13 +17,17 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/batch"
+	"github.com/nyaruka/courier/metadata"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/nyaruka/courier/queue"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/storage"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/librato"
+	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 // the name for our message queue
@@ -58,10 +62,66 @@ func (b *backend) GetChannelByAddress(ctx context.Context, ct courier.ChannelTyp
 	return getChannelByAddress(timeout, b.db, ct, address)
 }
 
+// GetChannelByWebhookToken returns the channel with the passed in type and webhook token
+func (b *backend) GetChannelByWebhookToken(ctx context.Context, ct courier.ChannelType, token string) (courier.Channel, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return getChannelByWebhookToken(timeout, b.db, ct, token)
+}
+
+// GetChannelsByConfig returns the active channels with the passed in type whose config has the passed in value for the
+// passed in key
+func (b *backend) GetChannelsByConfig(ctx context.Context, ct courier.ChannelType, key string, value string) ([]courier.Channel, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	channels, err := getChannelsByConfig(timeout, b.db, ct, key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]courier.Channel, len(channels))
+	for i := range channels {
+		found[i] = channels[i]
+	}
+	return found, nil
+}
+
+// UpdateChannelConfig merges the passed in values into the config of the passed in channel
+func (b *backend) UpdateChannelConfig(ctx context.Context, c courier.Channel, config map[string]interface{}) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return updateChannelConfig(timeout, b.db, c.(*DBChannel), config)
+}
+
+// CreateChannel creates a new channel for the org with the passed in id
+func (b *backend) CreateChannel(ctx context.Context, orgID int64, channelType courier.ChannelType, address courier.ChannelAddress, name string, schemes []string, config map[string]interface{}) (courier.Channel, error) {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return createChannel(timeout, b.db, OrgID(orgID), channelType, address, name, schemes, config)
+}
+
 // GetContact returns the contact for the passed in channel and URN
 func (b *backend) GetContact(ctx context.Context, c courier.Channel, urn urns.URN, auth string, name string) (courier.Contact, error) {
 	dbChannel := c.(*DBChannel)
-	return contactForURN(ctx, b, dbChannel.OrgID_, dbChannel, urn, auth, name)
+	return getContact(ctx, b, dbChannel.OrgID_, dbChannel, urn, auth, name)
+}
+
+// LookupContact returns the contact with the passed in URN in the org of the passed in channel without creating it, or
+// nil if there isn't one
+func (b *backend) LookupContact(ctx context.Context, c courier.Channel, urn urns.URN) (courier.Contact, error) {
+	contact := &DBContact{}
+	err := b.db.GetContext(ctx, contact, lookupContactFromURNSQL, urn.Identity(), c.(*DBChannel).OrgID_)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return contact, nil
 }
 
 // UpdateContactLastSeenOn updates last seen on (and modified on) on the passed in contact
@@ -116,18 +176,28 @@ UPDATE
 SET
 	visibility = 'D'
 WHERE
-	msgs_msg.id = (SELECT m."id" FROM "msgs_msg" m INNER JOIN "channels_channel" c ON (m."channel_id" = c."id") WHERE (c."uuid" = $1 AND m."external_id" = $2 AND m."direction" = 'I')
+	msgs_msg.id = (
+		SELECT m."id" FROM "msgs_msg" m
+		INNER JOIN "channels_channel" c ON (m."channel_id" = c."id")
+		INNER JOIN "contacts_contacturn" u ON (m."contact_urn_id" = u."id")
+		WHERE c."uuid" = $1 AND m."external_id" = $2 AND u."identity" = $3 AND m."direction" = 'I' AND m."visibility" != 'D'
+	)
 RETURNING
 	msgs_msg.id
 `
 
-// DeleteMsgWithExternalID delete a message we receive an event that it should be deleted
-func (b *backend) DeleteMsgWithExternalID(ctx context.Context, channel courier.Channel, externalID string) error {
-	_, err := b.db.ExecContext(ctx, updateMsgVisibilityDeleted, channel.UUID().String(), externalID)
+// DeleteMsgWithExternalID marks the incoming msg with the passed in external ID as deleted, if it was received on the
+// passed in channel from the passed in URN and isn't deleted already
+func (b *backend) DeleteMsgWithExternalID(ctx context.Context, channel courier.Channel, urn urns.URN, externalID string) (bool, error) {
+	var id int64
+	err := b.db.QueryRowContext(ctx, updateMsgVisibilityDeleted, channel.UUID().String(), externalID, urn.Identity().String()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	return true, nil
 }
 
 // NewIncomingMsg creates a new message from the given params
@@ -151,6 +221,24 @@ func (b *backend) NewIncomingMsg(channel courier.Channel, urn urns.URN, text str
 	return msg
 }
 
+// NewSyncedMsg creates a new message which was sent or received outside of courier, it will be written
+// as already handled (or sent) and never queued to mailroom
+func (b *backend) NewSyncedMsg(channel courier.Channel, urn urns.URN, text string, outgoing bool) courier.Msg {
+	text = utils.CleanString(text)
+
+	var msg *DBMsg
+	if outgoing {
+		msg = newMsg(MsgOutgoing, channel, urn, text)
+		msg.Status_ = courier.MsgSent
+	} else {
+		msg = newMsg(MsgIncoming, channel, urn, text)
+		msg.Status_ = msgHandled
+	}
+	msg.Synced_ = true
+	msg.WithReceivedOn(time.Now().UTC())
+	return msg
+}
+
 // NewOutgoingMsg creates a new outgoing message from the given params
 func (b *backend) NewOutgoingMsg(channel courier.Channel, urn urns.URN, text string) courier.Msg {
 	return newMsg(MsgOutgoing, channel, urn, text)
@@ -162,34 +250,78 @@ func (b *backend) PopNextOutgoingMsg(ctx context.Context) (courier.Msg, error) {
 	rc := b.redisPool.Get()
 	defer rc.Close()
 
-	token, msgJSON, err := queue.PopFromQueue(rc, msgQueueName)
-	for token == queue.Retry {
-		token, msgJSON, err = queue.PopFromQueue(rc, msgQueueName)
-	}
+	for {
+		token, msgJSON, err := queue.PopFromQueueSkipping(rc, msgQueueName, b.otherRegionQueues())
+		for token == queue.Retry {
+			token, msgJSON, err = queue.PopFromQueueSkipping(rc, msgQueueName, b.otherRegionQueues())
+		}
+
+		if msgJSON == "" {
+			return nil, err
+		}
 
-	if msgJSON != "" {
 		dbMsg := &DBMsg{}
 		err = json.Unmarshal([]byte(msgJSON), dbMsg)
 		if err != nil {
 			queue.MarkComplete(rc, msgQueueName, token)
 			return nil, fmt.Errorf("unable to unmarshal message '%s': %s", msgJSON, err)
 		}
+		// msgs scheduled for later are parked on their queue until they're due
+		if dbMsg.SendAfter_ != nil && dbMsg.SendAfter_.After(time.Now()) {
+			if err := queue.RequeueAt(rc, msgQueueName, token, msgJSON, queuePriority(dbMsg), *dbMsg.SendAfter_); err != nil {
+				return nil, errors.Wrapf(err, "error parking msg %d until it's due", dbMsg.ID_)
+			}
+			continue
+		}
+
 		// populate the channel on our db msg
 		channel, err := b.GetChannel(ctx, courier.AnyChannelType, dbMsg.ChannelUUID_)
 		if err != nil {
 			queue.MarkComplete(rc, msgQueueName, token)
 			return nil, err
 		}
+
+		// msgs of channels in other regions are put back for instances there to send, and we skip their queue for a while
+		if !courier.ChannelInRegion(channel, b.config.Region) {
+			b.skipOtherRegion(channel.UUID())
+			if err := queue.Requeue(rc, msgQueueName, token, msgJSON, queuePriority(dbMsg)); err != nil {
+				return nil, errors.Wrapf(err, "error requeuing msg %d for its region", dbMsg.ID_)
+			}
+			continue
+		}
+
+		// keep the org and weight of the channel's queue in line with the channel for its next turns
+		b.setQueueOrgWeight(rc, token, channel.(*DBChannel))
+
 		dbMsg.channel = channel.(*DBChannel)
 		dbMsg.workerToken = token
 
 		// clear out our seen incoming messages
 		clearMsgSeen(rc, dbMsg)
 
+		// msgs with invalid metadata can never be sent, so fail them now rather than when a handler gets to them
+		err = metadata.Validate(string(dbMsg.channel.ChannelType()), dbMsg.Metadata_)
+		if err != nil {
+			b.failInvalidMsg(ctx, dbMsg, err)
+			queue.MarkComplete(rc, msgQueueName, token)
+			return nil, errors.Wrapf(err, "msg %d failed", dbMsg.ID_)
+		}
+
 		return dbMsg, nil
 	}
+}
 
-	return nil, nil
+// failInvalidMsg fails the passed in msg, logging why it can't be sent on its channel
+func (b *backend) failInvalidMsg(ctx context.Context, msg *DBMsg, err error) {
+	status := b.NewMsgStatusForID(msg.channel, msg.ID_, courier.MsgFailed)
+	status.AddLog(courier.NewChannelLogFromError("Invalid Metadata", msg.channel, msg.ID_, 0, err))
+
+	if err := b.WriteMsgStatus(ctx, status); err != nil {
+		logrus.WithError(err).WithField("msg_id", msg.ID_.String()).Error("error failing msg with invalid metadata")
+	}
+	if err := b.WriteChannelLogs(ctx, status.Logs()); err != nil {
+		logrus.WithError(err).WithField("msg_id", msg.ID_.String()).Error("error writing logs of msg with invalid metadata")
+	}
 }
 
 var luaSent = redis.NewScript(3,
@@ -304,6 +436,9 @@ func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, msg courier.Msg,
 			logrus.WithError(err).WithField("sent_msgs_key", dateKey).Error("unable to add new unsent message")
 		}
 
+		// remember what we sent so echoes of it can be caught
+		writeLoopFingerprint(rc, dbMsg)
+
 		// if our msg has an associated session and timeout, update that
 		if dbMsg.SessionWaitStartedOn_ != nil {
 			err = updateSessionTimeout(ctx, b, dbMsg.SessionID_, *dbMsg.SessionWaitStartedOn_, dbMsg.SessionTimeout_)
@@ -314,6 +449,19 @@ func (b *backend) MarkOutgoingMsgComplete(ctx context.Context, msg courier.Msg,
 	}
 }
 
+// DeferOutgoingMsg puts the passed in msg back on the queue it was popped from, where it waits until the passed in time
+func (b *backend) DeferOutgoingMsg(ctx context.Context, msg courier.Msg, until time.Time) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	dbMsg := msg.(*DBMsg)
+	msgJSON, err := json.Marshal(dbMsg)
+	if err != nil {
+		return err
+	}
+	return queue.RequeueAt(rc, msgQueueName, dbMsg.workerToken, string(msgJSON), queuePriority(dbMsg), until)
+}
+
 // WriteMsg writes the passed in message to our store
 func (b *backend) WriteMsg(ctx context.Context, m courier.Msg) error {
 	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
@@ -322,6 +470,27 @@ func (b *backend) WriteMsg(ctx context.Context, m courier.Msg) error {
 	return writeMsg(timeout, b, m)
 }
 
+const addMsgMetadataSQL = `
+UPDATE msgs_msg SET metadata = (COALESCE(NULLIF(metadata, ''), '{}')::jsonb || jsonb_build_object($2::text, $3::jsonb))::text, modified_on = NOW() WHERE uuid = $1
+`
+
+// AddMsgMetadata sets the passed in key of the metadata of the written msg with the passed in UUID
+func (b *backend) AddMsgMetadata(ctx context.Context, uuid courier.MsgUUID, key string, value interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	result, err := b.db.ExecContext(ctx, addMsgMetadataSQL, uuid.String(), key, string(encoded))
+	if err != nil {
+		return errors.Wrap(err, "error adding msg metadata")
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return courier.ErrMsgNotFound
+	}
+	return nil
+}
+
 // NewStatusUpdateForID creates a new Status object for the given message id
 func (b *backend) NewMsgStatusForID(channel courier.Channel, id courier.MsgID, status courier.MsgStatusValue) courier.MsgStatus {
 	return newMsgStatus(channel, id, "", status)
@@ -332,6 +501,14 @@ func (b *backend) NewMsgStatusForExternalID(channel courier.Channel, externalID
 	return newMsgStatus(channel, courier.NilMsgID, externalID, status)
 }
 
+// WriteMsgCostEstimate records the estimated cost of sending the passed in message
+func (b *backend) WriteMsgCostEstimate(ctx context.Context, msg courier.Msg, estimate *courier.MsgCostEstimate) error {
+	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
+	defer cancel()
+
+	return writeMsgCostEstimate(timeout, b, msg.ID(), estimate)
+}
+
 // WriteMsgStatus writes the passed in MsgStatus to our store
 func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus) error {
 	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
@@ -343,8 +520,28 @@ func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus)
 			return errors.Wrap(err, "error updating contact URN")
 		}
 	}
-	// if we have an ID, we can have our batch commit for us
-	if status.ID() != courier.NilMsgID {
+	if status.ExternalID() != "" {
+		rc := b.redisPool.Get()
+
+		// remember the external ids of any secondary parts so their statuses can be matched to this msg
+		if err := writeExternalIDAliases(rc, status); err != nil {
+			logrus.WithError(err).WithField("channel_uuid", status.ChannelUUID()).Error("error writing external id aliases")
+		}
+
+		// and write statuses for secondary parts to the msg they were part of
+		if status.ID() == courier.NilMsgID {
+			externalID, err := resolveExternalIDAlias(rc, status.ChannelUUID(), status.ExternalID())
+			if err != nil {
+				logrus.WithError(err).WithField("channel_uuid", status.ChannelUUID()).Error("error resolving external id alias")
+			}
+			status.SetExternalID(externalID)
+		}
+
+		rc.Close()
+	}
+
+	// if we have an ID, we can have our batch commit for us unless batching has been switched off
+	if status.ID() != courier.NilMsgID && courier.FlagEnabled(b.redisPool, courier.FlagBatchStatusWrites, true) {
 		b.statusCommitter.Queue(status.(*DBMsgStatus))
 	} else {
 		// otherwise, write normally (synchronously)
@@ -362,6 +559,14 @@ func (b *backend) WriteMsgStatus(ctx context.Context, status courier.MsgStatus)
 		}
 	}
 
+	// let any status webhook of the channel know about this status
+	channel, err := b.GetChannel(timeout, courier.AnyChannelType, status.ChannelUUID())
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", status.ChannelUUID()).Error("error looking up channel for status webhook")
+	} else {
+		b.postStatusWebhook(channel, status)
+	}
+
 	return nil
 }
 
@@ -436,12 +641,17 @@ func (b *backend) WriteChannelEvent(ctx context.Context, event courier.ChannelEv
 	return writeChannelEvent(timeout, b, event)
 }
 
-// WriteChannelLogs persists the passed in logs to our database, for rapidpro we swallow all errors, logging isn't critical
+// WriteChannelLogs persists the passed in logs to our database, for rapidpro we swallow all errors, logging isn't critical.
+// Successful logs of channels which sample their logs may not be written.
 func (b *backend) WriteChannelLogs(ctx context.Context, logs []*courier.ChannelLog) error {
 	timeout, cancel := context.WithTimeout(ctx, backendTimeout)
 	defer cancel()
 
 	for _, l := range logs {
+		if !courier.SampleChannelLog(l) {
+			continue
+		}
+
 		err := writeChannelLog(timeout, b, l)
 		if err != nil {
 			logrus.WithError(err).Error("error writing channel log")
@@ -526,8 +736,24 @@ func (b *backend) Heartbeat() error {
 	// log our total
 	librato.Gauge("courier.bulk_queue", float64(bulkSize))
 	librato.Gauge("courier.priority_queue", float64(prioritySize))
+	metrics.SetQueueDepth("bulk", bulkSize)
+	metrics.SetQueueDepth("priority", prioritySize)
 	logrus.WithField("bulk_queue", bulkSize).WithField("priority_queue", prioritySize).Info("heartbeat queue sizes calculated")
 
+	// mark any channels that keep failing as unhealthy
+	_, err = markUnhealthyChannels(rc, b.config.Current().ChannelUnhealthyThreshold)
+	if err != nil {
+		return errors.Wrapf(err, "error marking unhealthy channels")
+	}
+
+	// write out any pricing analytics we've accumulated
+	ctx, cancel := context.WithTimeout(context.Background(), backendTimeout)
+	defer cancel()
+	_, err = flushPricingRollups(ctx, b)
+	if err != nil {
+		return errors.Wrapf(err, "error flushing pricing rollups")
+	}
+
 	return nil
 }
 
@@ -594,7 +820,17 @@ func (b *backend) Status() string {
 			return fmt.Sprintf("error reading bulk queue size: %v", err)
 		}
 
-		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s\n", size, bulkSize, int(workers), tps, channelType, uuid))
+		// and whether the vendor has asked the channel to back off
+		backoff := ""
+		remaining, err := courier.GetChannelBackoff(b.redisPool, channelUUID)
+		if err != nil {
+			return fmt.Sprintf("error reading channel backoff: %v", err)
+		}
+		if remaining > 0 {
+			backoff = fmt.Sprintf("   (backoff %s)", remaining.Round(time.Second))
+		}
+
+		status.WriteString(fmt.Sprintf("% 9d   % 9d   % 7d   % 3s   % 4s   %s%s\n", size, bulkSize, int(workers), tps, channelType, uuid, backoff))
 	}
 
 	return status.String()
@@ -640,41 +876,12 @@ func (b *backend) Start() error {
 		log.Info("db ok")
 	}
 
-	// parse and test our redis config
-	redisURL, err := url.Parse(b.config.Redis)
+	// parse and test our redis config, our pool having been created with us
+	_, err = url.Parse(b.config.Redis)
 	if err != nil {
 		return fmt.Errorf("unable to parse Redis URL '%s': %s", b.config.Redis, err)
 	}
-
-	// create our pool
-	redisPool := &redis.Pool{
-		Wait:        true,              // makes callers wait for a connection
-		MaxActive:   8,                 // only open this many concurrent connections at once
-		MaxIdle:     4,                 // only keep up to this many idle
-		IdleTimeout: 240 * time.Second, // how long to wait before reaping a connection
-		Dial: func() (redis.Conn, error) {
-			conn, err := redis.Dial("tcp", fmt.Sprintf("%s", redisURL.Host))
-			if err != nil {
-				return nil, err
-			}
-
-			// send auth if required
-			if redisURL.User != nil {
-				pass, authRequired := redisURL.User.Password()
-				if authRequired {
-					if _, err := conn.Do("AUTH", pass); err != nil {
-						conn.Close()
-						return nil, err
-					}
-				}
-			}
-
-			// switch to the right DB
-			_, err = conn.Do("SELECT", strings.TrimLeft(redisURL.Path, "/"))
-			return conn, err
-		},
-	}
-	b.redisPool = redisPool
+	redisPool := b.redisPool
 
 	// test our redis connection
 	conn := redisPool.Get()
@@ -689,6 +896,16 @@ func (b *backend) Start() error {
 	// start our dethrottler if we are going to be doing some sending
 	if b.config.MaxWorkers > 0 {
 		queue.StartDethrottler(redisPool, b.stopChan, b.waitGroup, msgQueueName)
+
+		// and listen for outgoing messages being queued if we've been configured to
+		if b.config.OutgoingNotifyChannel != "" {
+			err = b.startMsgListener()
+			if err != nil {
+				log.WithError(err).Error("unable to listen for outgoing msg notifications")
+			} else {
+				log.Info("outgoing msg notifications ok")
+			}
+		}
 	}
 
 	// create our storage (S3 or file system)
@@ -752,6 +969,9 @@ func (b *backend) Start() error {
 		})
 	b.logCommitter.Start()
 
+	// start posting statuses to the webhooks of channels which have them
+	b.startStatusWebhookWorkers()
+
 	// register and start our spool flushers
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "msgs"), b.flushMsgFile)
 	courier.RegisterFlusher(path.Join(b.config.SpoolDir, "statuses"), b.flushStatusFile)
@@ -801,10 +1021,61 @@ func (b *backend) RedisPool() *redis.Pool {
 	return b.redisPool
 }
 
+// newRedisPool creates a pool of connections to the passed in Redis URL, connections are only made once used
+func newRedisPool(redisURL *url.URL) *redis.Pool {
+	return &redis.Pool{
+		Wait:        true,              // makes callers wait for a connection
+		MaxActive:   8,                 // only open this many concurrent connections at once
+		MaxIdle:     4,                 // only keep up to this many idle
+		IdleTimeout: 240 * time.Second, // how long to wait before reaping a connection
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", fmt.Sprintf("%s", redisURL.Host))
+			if err != nil {
+				return nil, err
+			}
+
+			// send auth if required
+			if redisURL.User != nil {
+				pass, authRequired := redisURL.User.Password()
+				if authRequired {
+					if _, err := conn.Do("AUTH", pass); err != nil {
+						conn.Close()
+						return nil, err
+					}
+				}
+			}
+
+			// switch to the right DB
+			_, err = conn.Do("SELECT", strings.TrimLeft(redisURL.Path, "/"))
+			return conn, err
+		},
+	}
+}
+
 // NewBackend creates a new RapidPro backend
 func newBackend(config *courier.Config) courier.Backend {
+	// invalid windows are reported when our config is validated
+	dedupWindows, _ := courier.ParseDedupWindows(config.DedupWindows)
+
+	// our redis pool exists before we're started so it can be shared with what's set up before then, an invalid URL
+	// fails our start instead
+	var redisPool *redis.Pool
+	if redisURL, err := url.Parse(config.Redis); err == nil {
+		redisPool = newRedisPool(redisURL)
+	}
+
 	return &backend{
-		config: config,
+		config:       config,
+		dedupWindows: dedupWindows,
+		redisPool:    redisPool,
+
+		msgNotifications: make(chan bool, 1),
+		statusWebhooks:   make(chan *statusWebhookJob, statusWebhookQueueSize),
+
+		contactCache: cache.New(contactCacheTTL, time.Minute),
+		otherRegions: cache.New(otherRegionSkipTTL, time.Minute),
+
+		queueOrgWeights: cache.New(queueOrgWeightTTL, time.Minute),
 
 		stopChan:  make(chan bool),
 		waitGroup: &sync.WaitGroup{},
@@ -827,10 +1098,59 @@ type backend struct {
 
 	popScript *redis.Script
 
+	contactLookups singleflight.Group
+	contactCache   *cache.Cache
+
+	// channels of other regions whose queues we skip when popping msgs
+	otherRegions *cache.Cache
+
+	// the org and weight we last set for each msg queue
+	queueOrgWeights *cache.Cache
+
+	// how long the msgs of each channel type are remembered to drop duplicates, if not our default
+	dedupWindows courier.DedupWindows
+
+	msgNotifications chan bool
+
+	// statuses waiting to be posted to the webhooks of their channels
+	statusWebhooks chan *statusWebhookJob
+
+	// looks up the handlers of the server we were started by
+	handlerLookup courier.HandlerLookup
+
+	// called once we've handled an incoming msg
+	msgHandledHook courier.MsgHandledHook
+
 	stopChan  chan bool
 	waitGroup *sync.WaitGroup
 }
 
+// SetHandlerLookup sets how we look up the handlers of the server we are started by
+func (b *backend) SetHandlerLookup(lookup courier.HandlerLookup) {
+	b.handlerLookup = lookup
+}
+
+// SetMsgHandledHook sets what is called once we've handled an incoming msg
+func (b *backend) SetMsgHandledHook(hook courier.MsgHandledHook) {
+	b.msgHandledHook = hook
+}
+
+// msgHandled calls our msg handled hook, if any, for the passed in msg if it is incoming
+func (b *backend) msgHandled(ctx context.Context, m *DBMsg) {
+	if b.msgHandledHook != nil && m.Direction_ == MsgIncoming {
+		b.msgHandledHook(ctx, m)
+	}
+}
+
+// getHandler returns the handler for the passed in channel type, which is the registered one if we weren't started
+// by a server
+func (b *backend) getHandler(ct courier.ChannelType) courier.ChannelHandler {
+	if b.handlerLookup != nil {
+		return b.handlerLookup(ct)
+	}
+	return courier.GetHandler(ct)
+}
+
 func (b *backend) GetRunEventsByMsgUUIDFromDB(ctx context.Context, msgUUID string) ([]courier.RunEvent, error) {
 	events := []courier.RunEvent{}
 	jsonEvents, err := GetRunEventsJSONByMsgUUIDFromDB(ctx, b.db, msgUUID)
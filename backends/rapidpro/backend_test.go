@@ -2,6 +2,10 @@ package rapidpro
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,14 +19,17 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gofrs/uuid"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/storage"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null"
 
 	"github.com/gomodule/redigo/redis"
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -281,6 +288,153 @@ func (ts *BackendTestSuite) TestContactRace() {
 	ts.Equal(contact1.ID_, contact2.ID_)
 }
 
+func (ts *BackendTestSuite) TestContactBurst() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("12065551520", "US")
+
+	urnSleep = true
+	defer func() { urnSleep = false }()
+
+	ctx := context.Background()
+
+	// resolve the same new contact from a burst of concurrent messages
+	contacts := make([]*DBContact, 10)
+	errs := make([]error, 10)
+	wg := sync.WaitGroup{}
+	for i := range contacts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			contacts[i], errs[i] = getContact(ctx, ts.b, knChannel.OrgID(), knChannel, urn, "", "Ryan Lewis")
+		}(i)
+	}
+	wg.Wait()
+
+	newCount := 0
+	for i, contact := range contacts {
+		ts.NoError(errs[i])
+		ts.Equal(contacts[0].ID_, contact.ID_)
+		if contact.IsNew_ {
+			newCount++
+		}
+	}
+	ts.Equal(1, newCount)
+
+	// subsequent lookups are served from our cache and aren't new
+	contact, err := getContact(ctx, ts.b, knChannel.OrgID(), knChannel, urn, "", "Ryan Lewis")
+	ts.NoError(err)
+	ts.Equal(contacts[0].ID_, contact.ID_)
+	ts.False(contact.IsNew_)
+}
+
+func (ts *BackendTestSuite) TestPossibleDuplicate() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	// this number already belongs to a contact in our testdata as a tel URN
+	urn := urns.URN("whatsapp:12067799192")
+	contact, err := getContact(ctx, ts.b, knChannel.OrgID(), knChannel, urn, "", "")
+	ts.NoError(err)
+	ts.True(contact.IsNew_)
+
+	extra := utils.NullMap{}
+	err = ts.b.db.GetContext(ctx, &extra, `SELECT extra FROM channels_channelevent WHERE event_type = 'possible_dupe' AND contact_id = $1`, contact.ID_)
+	ts.NoError(err)
+	ts.Equal(map[string]interface{}{
+		"contact_uuid":           contact.UUID_.String(),
+		"duplicate_contact_uuid": "a984069d-0008-4d8c-a772-b14a8a6acccc",
+	}, extra.Map)
+
+	// numbers nobody else has aren't flagged
+	contact, err = getContact(ctx, ts.b, knChannel.OrgID(), knChannel, urns.URN("whatsapp:12065550000"), "", "")
+	ts.NoError(err)
+
+	count := 0
+	err = ts.b.db.GetContext(ctx, &count, `SELECT count(*) FROM channels_channelevent WHERE event_type = 'possible_dupe' AND contact_id = $1`, contact.ID_)
+	ts.NoError(err)
+	ts.Equal(0, count)
+}
+
+func (ts *BackendTestSuite) TestMsgLoop() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn := urns.URN("tel:+12065551215")
+
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+
+	// a copy of our channel which flags loops
+	channel := *knChannel
+	channel.Config_ = utils.NewNullMap(map[string]interface{}{courier.ConfigLoopProtection: "flag"})
+
+	sent := newMsg(MsgOutgoing, &channel, urn, "Welcome! ")
+	writeLoopFingerprint(rc, sent)
+
+	// an echo of what we sent is flagged
+	echo := ts.b.NewIncomingMsg(&channel, urn, "Welcome!").(*DBMsg)
+	ts.False(checkMsgLoop(ts.b, echo))
+	ts.JSONEq(`{"possible_loop": true}`, string(echo.Metadata_))
+
+	// but other msgs aren't
+	reply := ts.b.NewIncomingMsg(&channel, urn, "Thanks").(*DBMsg)
+	ts.False(checkMsgLoop(ts.b, reply))
+	ts.Nil(reply.Metadata_)
+
+	// channels which drop loops drop the echo
+	channel.Config_ = utils.NewNullMap(map[string]interface{}{courier.ConfigLoopProtection: "drop"})
+	ts.True(checkMsgLoop(ts.b, ts.b.NewIncomingMsg(&channel, urn, "Welcome!").(*DBMsg)))
+
+	// and channels without protection ignore it
+	ts.False(checkMsgLoop(ts.b, ts.b.NewIncomingMsg(knChannel, urn, "Welcome!").(*DBMsg)))
+
+	// synced history repeating what we sent is still written
+	synced := ts.b.NewSyncedMsg(&channel, urn, "Welcome!", false).(*DBMsg)
+	ts.NoError(ts.b.WriteMsg(context.Background(), synced))
+	ts.NotEqual(courier.NilMsgID, synced.ID())
+	ts.Nil(synced.Metadata_)
+}
+
+func (ts *BackendTestSuite) TestMsgVelocity() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn := urns.URN("tel:+12065551216")
+
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("DEL", fmt.Sprintf(velocityKeyPattern, knChannel.UUID(), urn.Identity(), time.Now().Unix()/60))
+
+	// a copy of our channel which flags contacts sending more than 3 msgs a minute
+	channel := *knChannel
+	channel.Config_ = utils.NewNullMap(map[string]interface{}{courier.ConfigVelocityProtection: "flag", courier.ConfigVelocityThreshold: 3})
+
+	for i := 0; i < 3; i++ {
+		msg := ts.b.NewIncomingMsg(&channel, urn, "hi").(*DBMsg)
+		ts.False(checkMsgVelocity(ts.b, msg))
+		ts.Nil(msg.Metadata_)
+	}
+
+	// the next msg is flagged with the contact's velocity
+	flood := ts.b.NewIncomingMsg(&channel, urn, "hi").(*DBMsg).WithMetadata(json.RawMessage(`{"foo": "bar"}`)).(*DBMsg)
+	ts.False(checkMsgVelocity(ts.b, flood))
+	ts.JSONEq(`{"foo": "bar", "velocity": 4}`, string(flood.Metadata_))
+
+	// other contacts aren't affected
+	ts.False(checkMsgVelocity(ts.b, ts.b.NewIncomingMsg(&channel, urns.URN("tel:+12065551217"), "hi").(*DBMsg)))
+
+	// channels which drop floods drop the msg
+	channel.Config_ = utils.NewNullMap(map[string]interface{}{courier.ConfigVelocityProtection: "drop", courier.ConfigVelocityThreshold: 3})
+	ts.True(checkMsgVelocity(ts.b, ts.b.NewIncomingMsg(&channel, urn, "hi").(*DBMsg)))
+
+	// and channels without protection don't count
+	ts.False(checkMsgVelocity(ts.b, ts.b.NewIncomingMsg(knChannel, urn, "hi").(*DBMsg)))
+
+	// synced msgs arrive in bursts when history is synced, so are never counted
+	for i := 0; i < 5; i++ {
+		msg := ts.b.NewSyncedMsg(&channel, urn, fmt.Sprintf("history %d", i), false).(*DBMsg)
+		ts.NoError(ts.b.WriteMsg(context.Background(), msg))
+		ts.NotEqual(courier.NilMsgID, msg.ID())
+		ts.Nil(msg.Metadata_)
+	}
+}
+
 func (ts *BackendTestSuite) TestAddAndRemoveContactURN() {
 	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
 	ctx := context.Background()
@@ -743,6 +897,158 @@ func (ts *BackendTestSuite) TestDupes() {
 	ts.NotEqual(uuid2, msg.UUID().String())
 }
 
+func (ts *BackendTestSuite) TestMsgCostEstimate() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	msg := ts.b.NewOutgoingMsg(channel, urns.URN("tel:+250788383383"), "test").WithID(courier.NewMsgID(10000))
+
+	ts.b.db.MustExec(`UPDATE msgs_msg SET metadata = '{"topic": "event"}' WHERE id = $1`, 10000)
+
+	err := ts.b.WriteMsgCostEstimate(ctx, msg, &courier.MsgCostEstimate{Cost: 0.05, Currency: "USD", Category: courier.MsgCategorySession})
+	ts.NoError(err)
+
+	var metadata string
+	ts.NoError(ts.b.db.Get(&metadata, `SELECT metadata FROM msgs_msg WHERE id = $1`, 10000))
+	ts.JSONEq(`{"topic": "event", "cost_estimate": {"cost": 0.05, "currency": "USD", "category": "session"}}`, metadata)
+}
+
+func (ts *BackendTestSuite) TestSendStats() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	day := time.Date(2024, 3, 8, 16, 8, 19, 0, time.UTC)
+
+	ts.NoError(ts.b.WriteSendStat(ctx, channel, "text", day))
+	ts.NoError(ts.b.WriteSendStat(ctx, channel, "template:revive_issue", day))
+	ts.NoError(ts.b.WriteSendStat(ctx, channel, "template:revive_issue", day))
+	ts.NoError(ts.b.WriteSendStat(ctx, channel, "text", day.Add(24*time.Hour)))
+
+	stats, err := ts.b.GetSendStats(ctx, day)
+	ts.NoError(err)
+	ts.Equal([]*courier.SendStat{
+		{Day: "2024-03-08", ChannelUUID: channel.UUID(), Segment: "template:revive_issue", Count: 2},
+		{Day: "2024-03-08", ChannelUUID: channel.UUID(), Segment: "text", Count: 1},
+	}, stats)
+
+	stats, err = ts.b.GetSendStats(ctx, day.Add(-24*time.Hour))
+	ts.NoError(err)
+	ts.Len(stats, 0)
+}
+
+func (ts *BackendTestSuite) TestSecondaryExternalIDs() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	ts.b.db.MustExec(`UPDATE msgs_msg SET status = 'Q', sent_on = NULL, external_id = NULL WHERE id = $1`, 10001)
+
+	// send our msg as an attachment and text which each get an external id
+	status := ts.b.NewMsgStatusForID(channel, courier.NewMsgID(10001), courier.MsgWired)
+	status.AddPart("ext-part1", courier.MsgWired)
+	status.AddPart("ext-part2", courier.MsgWired)
+	ts.Equal("ext-part1", status.ExternalID())
+	ts.Equal([]string{"ext-part2"}, courier.SecondaryExternalIDs(status))
+
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	time.Sleep(time.Second)
+
+	m := readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.Equal(null.String("ext-part1"), m.ExternalID_)
+
+	// a status for our second part is written to our msg
+	status = ts.b.NewMsgStatusForExternalID(channel, "ext-part2", courier.MsgDelivered)
+	ts.NoError(ts.b.WriteMsgStatus(ctx, status))
+	ts.Equal("ext-part1", status.ExternalID())
+
+	m = readMsgFromDB(ts.b, courier.NewMsgID(10001))
+	ts.Equal(courier.MsgDelivered, m.Status_)
+
+	// external ids which aren't aliases are left alone
+	status = ts.b.NewMsgStatusForExternalID(channel, "ext-part3", courier.MsgDelivered)
+	ts.Equal(courier.ErrMsgNotFound, ts.b.WriteMsgStatus(ctx, status))
+	ts.Equal("ext-part3", status.ExternalID())
+}
+
+func (ts *BackendTestSuite) TestChannelLogs() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	ts.b.db.MustExec(`DELETE FROM channels_channellog`)
+
+	// compression is off by default
+	defer func(size int) { ts.b.config.LogBodyCompressSize = size }(ts.b.config.LogBodyCompressSize)
+	ts.b.config.LogBodyCompressSize = 16 * 1024
+
+	big := "POST /send HTTP/1.1\r\n\r\n" + strings.Repeat(`{"text": "hello"}`, 2000)
+	log1 := courier.NewChannelLog("Message Sent", channel, courier.NewMsgID(10000), "POST", "https://example.com/send", 200, big, "HTTP/1.1 200 OK\r\n\r\n{}", time.Millisecond*12, nil)
+	log2 := courier.NewChannelLog("Message Received", channel, courier.NilMsgID, "POST", "https://example.com/receive", 400, "POST /receive HTTP/1.1\r\n\r\n{}", "HTTP/1.1 400 Bad Request\r\n\r\n{}", 0, fmt.Errorf("boom"))
+	ts.NoError(ts.b.WriteChannelLogs(ctx, []*courier.ChannelLog{log1, log2}))
+	time.Sleep(time.Second)
+
+	// our big request was stored compressed
+	var stored string
+	ts.NoError(ts.b.db.Get(&stored, `SELECT request FROM channels_channellog WHERE msg_id = 10000`))
+	ts.True(strings.HasPrefix(stored, "gzip:"))
+
+	logs, err := ts.b.GetChannelLogs(ctx, channel, courier.NilMsgID, 10)
+	ts.NoError(err)
+	ts.Len(logs, 2)
+
+	logs, err = ts.b.GetChannelLogs(ctx, channel, courier.NewMsgID(10000), 10)
+	ts.NoError(err)
+	ts.Len(logs, 1)
+	ts.Equal("Message Sent", logs[0].Description)
+	ts.Equal(12, logs[0].RequestTime)
+	ts.Equal(stored, logs[0].Request)
+
+	body, err := courier.DecompressLogBody(logs[0].Request)
+	ts.NoError(err)
+	ts.Equal(big, body)
+}
+
+func (ts *BackendTestSuite) TestPricingRollups() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	day := time.Date(2024, 3, 8, 16, 8, 19, 0, time.UTC)
+
+	ts.b.db.MustExec(`DELETE FROM channels_pricingrollup`)
+
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+	r.Do("DEL", "pricing_counted:dbc126ed-66bc-4e28-b67b-81dc3327c95d:ext1", "pricing_counted:dbc126ed-66bc-4e28-b67b-81dc3327c95d:ext2")
+
+	marketing := &courier.MsgPricing{Category: "marketing", PricingModel: "CBP", Billable: true, OccurredOn: day}
+	service := &courier.MsgPricing{Category: "service", PricingModel: "CBP", Billable: false, OccurredOn: day}
+	marketing1 := &courier.MsgPricing{ExternalID: "ext1", Category: "marketing", PricingModel: "CBP", Billable: true, OccurredOn: day}
+	marketing2 := &courier.MsgPricing{ExternalID: "ext2", Category: "marketing", PricingModel: "CBP", Billable: true, OccurredOn: day}
+
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, marketing1))
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, marketing2))
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, service))
+
+	// the later statuses of a msg report the same pricing, which isn't counted again
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, marketing1))
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, marketing2))
+
+	flushed, err := flushPricingRollups(ctx, ts.b)
+	ts.NoError(err)
+	ts.Equal(2, flushed)
+
+	// nothing left to flush
+	flushed, err = flushPricingRollups(ctx, ts.b)
+	ts.NoError(err)
+	ts.Equal(0, flushed)
+
+	// later counts for the same day are added to the existing rollup
+	ts.NoError(ts.b.WriteMsgPricing(ctx, channel, marketing))
+	_, err = flushPricingRollups(ctx, ts.b)
+	ts.NoError(err)
+
+	var count int
+	ts.NoError(ts.b.db.Get(&count, `SELECT count FROM channels_pricingrollup WHERE channel_id = $1 AND day = '2024-03-08' AND category = 'marketing' AND is_billable = TRUE`, channel.ID()))
+	ts.Equal(3, count)
+	ts.NoError(ts.b.db.Get(&count, `SELECT count FROM channels_pricingrollup WHERE channel_id = $1 AND day = '2024-03-08' AND category = 'service' AND is_billable = FALSE`, channel.ID()))
+	ts.Equal(1, count)
+}
+
 func (ts *BackendTestSuite) TestExternalIDDupes() {
 	r := ts.b.redisPool.Get()
 	defer r.Close()
@@ -761,6 +1067,75 @@ func (ts *BackendTestSuite) TestExternalIDDupes() {
 	checkedMsg = ts.b.CheckExternalIDSeen(msg)
 	m2 := checkedMsg.(*DBMsg)
 	ts.True(m2.alreadyWritten)
+
+	// external ids are remembered for the dedup window of their channel type
+	ttl, err := redis.Int(r.Do("TTL", fmt.Sprintf(externalIDSeenKey, knChannel.UUID(), urn.Identity(), "")))
+	ts.NoError(err)
+	ts.Equal(86400, ttl)
+
+	ts.b.dedupWindows = courier.DedupWindows{"KN": 259200}
+	defer func() { ts.b.dedupWindows = nil }()
+
+	ts.b.WriteExternalIDSeen(msg)
+	ttl, err = redis.Int(r.Do("TTL", fmt.Sprintf(externalIDSeenKey, knChannel.UUID(), urn.Identity(), "")))
+	ts.NoError(err)
+	ts.Equal(259200, ttl)
+
+	// external ids remembered in the daily hashes we used before are still found
+	legacy := newMsg(MsgIncoming, knChannel, urn, "pong").WithExternalID("ext1")
+	day := time.Now().In(time.UTC).Add(time.Hour * -24).Format("2006-01-02")
+	r.Do("HSET", fmt.Sprintf(legacyExternalIDSeenKey, day), fmt.Sprintf("%s:%s|ext1", knChannel.UUID(), urn.Identity()), "0ff6e5a9-6a32-4b1a-9b6a-8c1f6e4d0c2e|pong")
+
+	checkedMsg = ts.b.CheckExternalIDSeen(legacy)
+	ts.True(checkedMsg.(*DBMsg).alreadyWritten)
+	ts.Equal(courier.NewMsgUUIDFromString("0ff6e5a9-6a32-4b1a-9b6a-8c1f6e4d0c2e"), checkedMsg.UUID())
+}
+
+func (ts *BackendTestSuite) TestMsgFingerprintDupes() {
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("12065551216", knChannel.Country())
+	receivedOn := time.Date(2024, 3, 8, 10, 30, 0, 0, time.UTC)
+
+	msg := newMsg(MsgIncoming, knChannel, urn, "fingerprint")
+	msg.WithReceivedOn(receivedOn)
+	ts.Equal(courier.NilMsgUUID, checkMsgFingerprintSeen(ts.b, msgFingerprintKey(msg)))
+
+	writeMsgFingerprintSeen(ts.b, msg, msgFingerprintKey(msg))
+
+	// a retry of the same msg is a duplicate
+	retry := newMsg(MsgIncoming, knChannel, urn, "fingerprint")
+	retry.WithReceivedOn(receivedOn)
+	ts.Equal(msg.UUID(), checkMsgFingerprintSeen(ts.b, msgFingerprintKey(retry)))
+
+	// but one with other text, attachments or a different timestamp isn't
+	other := newMsg(MsgIncoming, knChannel, urn, "fingerprints")
+	other.WithReceivedOn(receivedOn)
+	ts.Equal(courier.NilMsgUUID, checkMsgFingerprintSeen(ts.b, msgFingerprintKey(other)))
+
+	other = newMsg(MsgIncoming, knChannel, urn, "fingerprint")
+	other.WithReceivedOn(receivedOn)
+	other.WithAttachment("image/jpeg:https://example.com/image.jpg")
+	ts.Equal(courier.NilMsgUUID, checkMsgFingerprintSeen(ts.b, msgFingerprintKey(other)))
+
+	other = newMsg(MsgIncoming, knChannel, urn, "fingerprint")
+	other.WithReceivedOn(receivedOn.Add(time.Second))
+	ts.Equal(courier.NilMsgUUID, checkMsgFingerprintSeen(ts.b, msgFingerprintKey(other)))
+
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+
+	ttl, err := redis.Int(r.Do("TTL", msgFingerprintKey(msg)))
+	ts.NoError(err)
+	ts.Equal(86400, ttl)
+
+	// msgs without a timestamp are only remembered long enough to catch retries, as the contact may send the same again
+	untimed := newMsg(MsgIncoming, knChannel, urn, "yes")
+	writeMsgFingerprintSeen(ts.b, untimed, msgFingerprintKey(untimed))
+	ts.Equal(untimed.UUID(), checkMsgFingerprintSeen(ts.b, msgFingerprintKey(newMsg(MsgIncoming, knChannel, urn, "yes"))))
+
+	ttl, err = redis.Int(r.Do("TTL", msgFingerprintKey(untimed)))
+	ts.NoError(err)
+	ts.Equal(untimedFingerprintWindow, ttl)
 }
 
 func (ts *BackendTestSuite) TestLoop() {
@@ -814,6 +1189,119 @@ func (ts *BackendTestSuite) TestStatus() {
 
 	// status should now contain that channel
 	ts.True(strings.Contains(ts.b.Status(), "1           0         0    10     KN   dbc126ed-66bc-4e28-b67b-81dc3327c95d"), ts.b.Status())
+	ts.False(strings.Contains(ts.b.Status(), "backoff"), ts.b.Status())
+
+	// and any backoff the vendor asked it for
+	ts.NoError(courier.SetChannelBackoff(ts.b.redisPool, dbMsg.ChannelUUID_, time.Minute))
+	ts.True(strings.Contains(ts.b.Status(), "dbc126ed-66bc-4e28-b67b-81dc3327c95d   (backoff 1m0s)"), ts.b.Status())
+}
+
+func (ts *BackendTestSuite) TestChannelHealth() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+
+	// never seen channels are healthy
+	health, err := ts.b.GetChannelHealth(ctx, channel.UUID())
+	ts.NoError(err)
+	ts.True(health.Healthy)
+	ts.Equal(0, health.ConsecutiveFailures)
+
+	// record failures up to just under our threshold
+	for i := 0; i < 4; i++ {
+		ts.NoError(ts.b.WriteChannelHeartbeat(ctx, channel, courier.ChannelHeartbeatError))
+	}
+	unhealthy, err := markUnhealthyChannels(r, 5)
+	ts.NoError(err)
+	ts.Equal(0, unhealthy)
+
+	// one more puts us over
+	ts.NoError(ts.b.WriteChannelHeartbeat(ctx, channel, courier.ChannelHeartbeatError))
+	unhealthy, err = markUnhealthyChannels(r, 5)
+	ts.NoError(err)
+	ts.Equal(1, unhealthy)
+
+	health, err = ts.b.GetChannelHealth(ctx, channel.UUID())
+	ts.NoError(err)
+	ts.False(health.Healthy)
+	ts.Equal(5, health.ConsecutiveFailures)
+	ts.NotNil(health.LastFailureOn)
+	ts.Nil(health.LastSuccessOn)
+
+	// a success resets everything
+	ts.NoError(ts.b.WriteChannelHeartbeat(ctx, channel, courier.ChannelHeartbeatOK))
+	health, err = ts.b.GetChannelHealth(ctx, channel.UUID())
+	ts.NoError(err)
+	ts.True(health.Healthy)
+	ts.Equal(0, health.ConsecutiveFailures)
+	ts.NotNil(health.LastSuccessOn)
+}
+
+type countingDescriber struct {
+	calls int
+}
+
+func (d *countingDescriber) DescribeURN(ctx context.Context, c courier.Channel, urn urns.URN) (map[string]string, error) {
+	d.calls++
+	return map[string]string{"name": "Ryan Lewis"}, nil
+}
+
+func (ts *BackendTestSuite) TestDescribeURN() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	describer := &countingDescriber{}
+
+	atts, err := describeURN(ctx, ts.b, describer, channel, urns.URN("tel:+12065551000"))
+	ts.NoError(err)
+	ts.Equal("Ryan Lewis", atts["name"])
+	ts.Equal(1, describer.calls)
+
+	// second time around comes from our cache
+	atts, err = describeURN(ctx, ts.b, describer, channel, urns.URN("tel:+12065551000"))
+	ts.NoError(err)
+	ts.Equal("Ryan Lewis", atts["name"])
+	ts.Equal(1, describer.calls)
+
+	// use up all our slots for this second, we should be told we're rate limited
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+	r.Do("set", fmt.Sprintf(describeRateKey, channel.UUID(), time.Now().Unix()), 1000)
+	r.Do("set", fmt.Sprintf(describeRateKey, channel.UUID(), time.Now().Unix()+1), 1000)
+	r.Do("set", fmt.Sprintf(describeRateKey, channel.UUID(), time.Now().Unix()+2), 1000)
+
+	_, err = describeURN(ctx, ts.b, describer, channel, urns.URN("tel:+12065551001"))
+	ts.Equal(errDescribeRateLimited, err)
+	ts.Equal(1, describer.calls)
+}
+
+func (ts *BackendTestSuite) TestQueueOutgoingMsg() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("0788383383", "RW")
+
+	_, err := ts.b.QueueOutgoingMsg(ctx, channel, urn, "hi", nil, nil, false, json.RawMessage(`{"cta_message": "open"}`))
+	ts.Error(err)
+
+	msg, err := ts.b.QueueOutgoingMsg(ctx, channel, urn, "Your order has shipped", []string{"image/jpeg:https://example.com/label.jpg"}, []string{"Track"}, true, json.RawMessage(`{"topic": "purchase"}`))
+	ts.NoError(err)
+	ts.NotEqual(courier.NilMsgID, msg.ID())
+
+	// our msg is written as queued
+	m := readMsgFromDB(ts.b, msg.ID())
+	ts.Equal(MsgOutgoing, m.Direction_)
+	ts.Equal(courier.MsgQueued, m.Status_)
+	ts.Equal("Your order has shipped", m.Text_)
+	ts.True(m.HighPriority_)
+
+	// and can be popped to be sent
+	popped, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Equal(msg.ID(), popped.ID())
+	ts.Equal([]string{"Track"}, popped.QuickReplies())
+	ts.Equal("purchase", popped.Topic())
+	ts.b.MarkOutgoingMsgComplete(ctx, popped, ts.b.NewMsgStatusForID(channel, popped.ID(), courier.MsgWired))
 }
 
 func (ts *BackendTestSuite) TestOutgoingQueue() {
@@ -873,6 +1361,156 @@ func (ts *BackendTestSuite) TestOutgoingQueue() {
 	ts.False(sent)
 }
 
+func (ts *BackendTestSuite) TestOtherRegionQueues() {
+	ctx := context.Background()
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	dbMsg.ChannelUUID_, _ = courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+
+	msgJSON, err := json.Marshal([]interface{}{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 0, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	// while we think the channel is in another region, its msgs stay queued for instances there
+	ts.b.skipOtherRegion(dbMsg.ChannelUUID_)
+	ts.Equal([]string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d"}, ts.b.otherRegionQueues())
+
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	// channels without a region are sent by every instance
+	ts.b.config.Region = "eu-west-1"
+	ts.b.otherRegions.Flush()
+	defer func() { ts.b.config.Region = "" }()
+
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(dbMsg.ID(), msg.ID())
+	ts.Empty(ts.b.otherRegionQueues())
+
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgWired))
+}
+
+func (ts *BackendTestSuite) TestScheduledMsgs() {
+	ctx := context.Background()
+	r := ts.b.redisPool.Get()
+	defer r.Close()
+
+	dbMsg := readMsgFromDB(ts.b, courier.NewMsgID(10000))
+	dbMsg.ChannelUUID_, _ = courier.NewChannelUUID("dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	sendAfter := time.Now().Add(time.Hour).Round(time.Second)
+	dbMsg.SendAfter_ = &sendAfter
+
+	msgJSON, err := json.Marshal([]interface{}{dbMsg})
+	ts.NoError(err)
+
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 0, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	// msgs scheduled for later are parked on their queue, scored by when they're due
+	msg, err := ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Nil(msg)
+
+	parked, err := redis.Strings(r.Do("ZRANGE", "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|0/1", 0, -1))
+	ts.NoError(err)
+	ts.Require().Len(parked, 1)
+	score, err := redis.Float64(r.Do("ZSCORE", "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|0/1", parked[0]))
+	ts.NoError(err)
+	ts.Equal(float64(sendAfter.Unix()), score)
+
+	// and are sent once they're due
+	_, err = r.Do("DEL", "msgs:dbc126ed-66bc-4e28-b67b-81dc3327c95d|0/1", "msgs:future")
+	ts.NoError(err)
+	dbMsg.SendAfter_ = nil
+	msgJSON, err = json.Marshal([]interface{}{dbMsg})
+	ts.NoError(err)
+	err = queue.PushOntoQueue(r, msgQueueName, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", 0, string(msgJSON), queue.HighPriority)
+	ts.NoError(err)
+
+	msg, err = ts.b.PopNextOutgoingMsg(ctx)
+	ts.NoError(err)
+	ts.Require().NotNil(msg)
+	ts.Equal(dbMsg.ID(), msg.ID())
+
+	ts.b.MarkOutgoingMsgComplete(ctx, msg, ts.b.NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgWired))
+}
+
+func (ts *BackendTestSuite) TestCreateChannel() {
+	ctx := context.Background()
+
+	channel, err := ts.b.CreateChannel(ctx, 1, courier.ChannelType("WAC"), courier.ChannelAddress("102290129340398"), "Weni", []string{"whatsapp"}, map[string]interface{}{courier.ConfigUserToken: "token123"})
+	ts.NoError(err)
+	ts.Equal(courier.ChannelType("WAC"), channel.ChannelType())
+	ts.Equal("102290129340398", channel.Address())
+	ts.Equal("Weni", channel.Name())
+	ts.Equal([]string{"whatsapp"}, channel.Schemes())
+	ts.Equal("token123", channel.StringConfigForKey(courier.ConfigUserToken, ""))
+
+	// and can be looked up by address
+	byAddress, err := ts.b.GetChannelByAddress(ctx, courier.ChannelType("WAC"), courier.ChannelAddress("102290129340398"))
+	ts.NoError(err)
+	ts.Equal(channel.UUID(), byAddress.UUID())
+
+	_, err = ts.b.db.Exec(`DELETE FROM channels_channel WHERE uuid = $1`, channel.UUID().String())
+	ts.NoError(err)
+	clearLocalChannelByAddress(courier.ChannelAddress("102290129340398"))
+}
+
+func (ts *BackendTestSuite) TestExportMsgs() {
+	ctx := context.Background()
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	after, before := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	msgs, err := ts.b.ExportMsgs(ctx, channel, after, before, courier.NilMsgID, 2)
+	ts.NoError(err)
+	ts.Len(msgs, 2)
+	ts.Equal(courier.NewMsgID(10000), msgs[0].ID)
+	ts.Equal("out", msgs[0].Direction)
+	ts.Equal(courier.MsgWired, msgs[0].Status)
+	ts.Equal("tel:+12067799192", msgs[0].URN)
+	ts.Equal("test message", msgs[0].Text)
+	ts.Equal("ext1", msgs[0].ExternalID)
+	ts.Equal([]string{}, msgs[0].Attachments)
+	ts.Equal(courier.NewMsgID(10001), msgs[1].ID)
+
+	// continuing from our cursor
+	msgs, err = ts.b.ExportMsgs(ctx, channel, after, before, courier.NewMsgID(10001), 2)
+	ts.NoError(err)
+	ts.Equal(courier.NewMsgID(10002), msgs[0].ID)
+	ts.Equal("in", msgs[0].Direction)
+
+	// nothing outside our range
+	msgs, err = ts.b.ExportMsgs(ctx, channel, after.Add(-24*time.Hour), after, courier.NilMsgID, 2)
+	ts.NoError(err)
+	ts.Len(msgs, 0)
+}
+
+func (ts *BackendTestSuite) TestBackfillMedia() {
+	ctx := context.Background()
+
+	// none of our test msgs are on channels whose media can be resolved again
+	result, err := ts.b.BackfillMedia(ctx, time.Now().Add(-24*time.Hour))
+	ts.NoError(err)
+	ts.Equal(&courier.MediaBackfillResult{}, result)
+}
+
+func (ts *BackendTestSuite) TestGetChannelByWebhookToken() {
+	ctx := context.Background()
+
+	_, err := ts.b.GetChannelByWebhookToken(ctx, courier.ChannelType("WA"), "unknown")
+	ts.Equal(courier.ErrChannelNotFound, err)
+
+	_, err = ts.b.GetChannelByWebhookToken(ctx, courier.ChannelType("WA"), "")
+	ts.Equal(courier.ErrChannelNotFound, err)
+}
+
 func (ts *BackendTestSuite) TestChannel() {
 	noAddress := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c99a")
 	ts.Equal("US", noAddress.Country())
@@ -938,6 +1576,24 @@ func (ts *BackendTestSuite) TestChannel() {
 	ts.False(exChannel2.HasRole(courier.ChannelRoleCall))
 	ts.False(exChannel2.HasRole(courier.ChannelRoleAnswer))
 
+	// update our config, existing values should be kept
+	err := ts.b.UpdateChannelConfig(context.Background(), knChannel, map[string]interface{}{"display_name": "Weni", "encoding": "unicode"})
+	ts.NoError(err)
+
+	// the updated channel is reloaded on its next lookup
+	updated := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	ts.Equal("Weni", updated.StringConfigForKey("display_name", ""))
+	ts.Equal("unicode", updated.StringConfigForKey("encoding", ""))
+	ts.True(updated.BoolConfigForKey("use_national", false))
+
+	var config string
+	ts.NoError(ts.b.db.Get(&config, `SELECT config FROM channels_channel WHERE uuid = $1`, knChannel.UUID()))
+	ts.Contains(config, `"display_name": "Weni"`)
+	ts.Contains(config, `"use_national": true`)
+
+	// put things back as they were
+	err = ts.b.UpdateChannelConfig(context.Background(), knChannel, map[string]interface{}{"encoding": "smart"})
+	ts.NoError(err)
 }
 
 func (ts *BackendTestSuite) TestChanneLog() {
@@ -1150,6 +1806,37 @@ func (ts *BackendTestSuite) TestWriteMsg() {
 	}, body["task"])
 }
 
+func (ts *BackendTestSuite) TestWriteSyncedMsg() {
+	ctx := context.Background()
+	knChannel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn := urns.URN("tel:+12065551218")
+
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("DEL", "handler:1", "handler:active")
+
+	msg := ts.b.NewSyncedMsg(knChannel, urn, "Sent from the phone", true).WithMetadata(json.RawMessage(`{"coexistence": {"source": "smb_message_echoes"}}`)).(*DBMsg)
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+
+	// its metadata is stored with it
+	var metadata string
+	ts.NoError(ts.b.db.Get(&metadata, `SELECT metadata FROM msgs_msg WHERE id = $1`, msg.ID()))
+	ts.JSONEq(`{"coexistence": {"source": "smb_message_echoes"}}`, metadata)
+
+	// but it isn't queued to mailroom
+	count, err := redis.Int(rc.Do("ZCARD", "handler:1"))
+	ts.NoError(err)
+	ts.Equal(0, count)
+
+	// and those without metadata have none
+	msg = ts.b.NewSyncedMsg(knChannel, urn, "Also from the phone", true).(*DBMsg)
+	ts.NoError(ts.b.WriteMsg(ctx, msg))
+
+	var nullMetadata sql.NullString
+	ts.NoError(ts.b.db.Get(&nullMetadata, `SELECT metadata FROM msgs_msg WHERE id = $1`, msg.ID()))
+	ts.False(nullMetadata.Valid)
+}
+
 func (ts *BackendTestSuite) TestPreferredChannelCheckRole() {
 	exChannel := ts.getChannel("EX", "dbc126ed-66bc-4e28-b67b-81dc3327100a")
 	ctx := context.Background()
@@ -1203,6 +1890,31 @@ func (ts *BackendTestSuite) TestChannelEvent() {
 	ts.Equal(contact.URNID_, dbE.ContactURNID_)
 }
 
+func (ts *BackendTestSuite) TestAuditRecords() {
+	ctx := context.Background()
+	ts.b.db.MustExec(`DELETE FROM channels_auditrecord`)
+
+	createdOn := time.Date(2024, 3, 8, 12, 0, 0, 0, time.UTC)
+	ts.NoError(ts.b.WriteAuditRecord(ctx, &courier.AuditRecord{Action: "onboard", Actor: "admin", Address: "203.0.113.7", AffectedIDs: []string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d"}, CreatedOn: createdOn}))
+	ts.NoError(ts.b.WriteAuditRecord(ctx, &courier.AuditRecord{Action: "channel_pause", Actor: "admin", Address: "203.0.113.7", ChannelUUID: "dbc126ed-66bc-4e28-b67b-81dc3327c95d", AffectedIDs: []string{}, CreatedOn: createdOn.Add(time.Minute)}))
+
+	records, err := ts.b.GetAuditRecords(ctx, 10)
+	ts.NoError(err)
+	if ts.Len(records, 2) {
+		ts.Equal("channel_pause", records[0].Action)
+		ts.Equal("dbc126ed-66bc-4e28-b67b-81dc3327c95d", records[0].ChannelUUID)
+		ts.Equal([]string{}, records[0].AffectedIDs)
+		ts.Equal("onboard", records[1].Action)
+		ts.Equal("", records[1].ChannelUUID)
+		ts.Equal([]string{"dbc126ed-66bc-4e28-b67b-81dc3327c95d"}, records[1].AffectedIDs)
+		ts.Equal(createdOn, records[1].CreatedOn)
+	}
+
+	records, err = ts.b.GetAuditRecords(ctx, 1)
+	ts.NoError(err)
+	ts.Len(records, 1)
+}
+
 func (ts *BackendTestSuite) TestSessionTimeout() {
 	ctx := context.Background()
 
@@ -1276,6 +1988,212 @@ func (ts *BackendTestSuite) TestMailroomEvents() {
 	}, body["task"])
 }
 
+func (ts *BackendTestSuite) TestMailroomOrderPaymentEvents() {
+	ctx := context.Background()
+
+	rc := ts.b.redisPool.Get()
+	defer rc.Close()
+	rc.Do("FLUSHDB")
+
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	urn, _ := urns.NewTelURNForCountry("12065551616", channel.Country())
+	extra := map[string]interface{}{"reference_id": "order-123", "status": "captured", "transaction_id": "tx-1"}
+	event := ts.b.NewChannelEvent(channel, courier.OrderPayment, urn).WithExtra(extra).
+		WithOccurredOn(time.Date(2020, 8, 5, 13, 30, 0, 0, time.UTC))
+	ts.NoError(ts.b.WriteChannelEvent(ctx, event))
+
+	contact, err := contactForURN(ctx, ts.b, channel.OrgID_, channel, urn, "", "")
+	ts.NoError(err)
+
+	// payments are queued to mailroom with their details so flows waiting on them can continue
+	data, err := redis.Bytes(rc.Do("LPOP", fmt.Sprintf("c:1:%d", contact.ID_)))
+	ts.NoError(err)
+
+	var body map[string]interface{}
+	ts.NoError(json.Unmarshal(data, &body))
+	ts.Equal("order_payment", body["type"])
+	ts.Equal(extra, body["task"].(map[string]interface{})["extra"])
+	ts.Equal(float64(contact.URNID_), body["task"].(map[string]interface{})["urn_id"])
+}
+
+func (ts *BackendTestSuite) TestOutgoingMsgNotifications() {
+	notifications := ts.b.OutgoingMsgNotifications()
+	ts.NotNil(notifications)
+
+	// clear any notification left by other tests
+	select {
+	case <-notifications:
+	default:
+	}
+
+	// msgs we queue ourselves wake anybody waiting on messages without listening to the database
+	channel := ts.getChannel("KN", "dbc126ed-66bc-4e28-b67b-81dc3327c95d")
+	_, err := ts.b.QueueOutgoingMsg(context.Background(), channel, urns.URN("tel:+250788383383"), "hi", nil, nil, false, nil)
+	ts.NoError(err)
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		ts.Fail("timed out waiting for notification")
+	}
+
+	ts.b.config.OutgoingNotifyChannel = "courier_msgs"
+	defer func() { ts.b.config.OutgoingNotifyChannel = "" }()
+
+	ts.NoError(ts.b.startMsgListener())
+
+	// notifications from the database wake anybody waiting on messages
+	ts.b.db.MustExec(`NOTIFY courier_msgs`)
+
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		ts.Fail("timed out waiting for notification")
+	}
+
+	// and are coalesced until somebody reads them
+	ts.b.notifyOutgoingMsg()
+	ts.b.notifyOutgoingMsg()
+	ts.Equal(1, len(notifications))
+	<-notifications
+}
+
+func TestPhoneIdentities(t *testing.T) {
+	assert.Equal(t, []string{"whatsapp:12067799192"}, phoneIdentities(urns.URN("tel:+12067799192")))
+	assert.Equal(t, []string{"tel:+12067799192"}, phoneIdentities(urns.URN("whatsapp:12067799192")))
+	assert.Nil(t, phoneIdentities(urns.URN("telegram:12067799192")))
+}
+
+func TestFetchMedia(t *testing.T) {
+	defer func() { mediaDownloadBackoff = time.Second }()
+	mediaDownloadBackoff = time.Millisecond
+
+	media := []byte("media bytes")
+	sum := sha256.Sum256(media)
+	checksum := &courier.MediaChecksum{Size: len(media), SHA256: hex.EncodeToString(sum[:])}
+
+	// the first attempt fails with a server error, the second succeeds
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(media)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/media.jpg", nil)
+	_, body, err := fetchMedia(context.Background(), req, checksum)
+	assert.NoError(t, err)
+	assert.Equal(t, media, body)
+	assert.Equal(t, 2, requests)
+
+	// media which never matches its checksum gives up after our attempts, with an error which can be retried later
+	requests = 0
+	_, _, err = fetchMedia(context.Background(), req, &courier.MediaChecksum{SHA256: "0000"})
+	assert.Error(t, err)
+	assert.True(t, courier.IsMediaDownloadError(err))
+	assert.Contains(t, err.Error(), "doesn't match 0000")
+	assert.Equal(t, 3, requests)
+
+	requests = 1
+	_, _, err = fetchMedia(context.Background(), req, &courier.MediaChecksum{Size: 100})
+	assert.EqualError(t, err, fmt.Sprintf("unable to download media from %s/media.jpg: received 11 bytes of media of size 100", server.URL))
+}
+
+func TestQueueWeight(t *testing.T) {
+	weighted := func(weight interface{}) courier.Channel {
+		return courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "KN", "12345", "RW", map[string]interface{}{configQueueWeight: weight})
+	}
+
+	assert.Equal(t, 1.0, queueWeight(courier.NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "KN", "12345", "RW", nil)))
+	assert.Equal(t, 3.0, queueWeight(weighted(3)))
+	assert.Equal(t, 0.5, queueWeight(weighted(0.5)))
+	assert.Equal(t, 2.0, queueWeight(weighted("2")))
+	assert.Equal(t, 1.0, queueWeight(weighted(-1)))
+	assert.Equal(t, 1.0, queueWeight(weighted("lots")))
+}
+
+func TestStatusWebhook(t *testing.T) {
+	statusWebhookRetryDelay = time.Millisecond
+	defer func() { statusWebhookRetryDelay = 5 * time.Second }()
+
+	bodies := make(chan string, 10)
+	signatures := make(chan string, 10)
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		signatures <- r.Header.Get(statusWebhookSignatureHeader)
+
+		// fail our first attempt
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	b := &backend{stopChan: make(chan bool), waitGroup: &sync.WaitGroup{}, statusWebhooks: make(chan *statusWebhookJob, 1)}
+	b.startStatusWebhookWorkers()
+	defer func() {
+		close(b.stopChan)
+		b.waitGroup.Wait()
+	}()
+
+	channel := &DBChannel{UUID_: courier.ChannelUUID{UUID: uuid.FromStringOrNil("dbc126ed-66bc-4e28-b67b-81dc3327c95d")}, ChannelType_: "WAC", Country_: sql.NullString{String: "BR", Valid: true}, Config_: utils.NullMap{Valid: true, Map: map[string]interface{}{
+		courier.ConfigStatusWebhook: map[string]interface{}{"url": server.URL, "secret": "sesame"},
+		courier.ConfigTimezone:      "America/Sao_Paulo",
+	}}}
+	status := newMsgStatus(channel, courier.NewMsgID(12345), "ext1", courier.MsgFailed)
+	status.AddLog(courier.NewChannelLogFromError("Message Send Error", channel, courier.NewMsgID(12345), 0, fmt.Errorf("message undeliverable")))
+
+	b.postStatusWebhook(channel, status)
+
+	// we retried after our first attempt failed, posting the same body both times
+	first, second := <-bodies, <-bodies
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, first, second)
+
+	payload := &statusWebhookPayload{}
+	assert.NoError(t, json.Unmarshal([]byte(first), payload))
+	assert.Equal(t, channel.UUID(), payload.ChannelUUID)
+	assert.Equal(t, courier.NewMsgID(12345), payload.MsgID)
+	assert.Equal(t, "ext1", payload.ExternalID)
+	assert.Equal(t, "failed", payload.Status)
+	assert.Equal(t, "message undeliverable", payload.Reason)
+	assert.Equal(t, "BR", payload.ChannelCountry)
+	assert.True(t, payload.ModifiedOn.Equal(payload.ModifiedOnLocal))
+	assert.Contains(t, first, `-03:00"`)
+
+	mac := hmac.New(sha256.New, []byte("sesame"))
+	mac.Write([]byte(first))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), <-signatures)
+
+	// channels without a webhook post nothing
+	channel = &DBChannel{UUID_: courier.ChannelUUID{UUID: uuid.FromStringOrNil("8eb23e93-5ecb-45ba-b726-3b064e0c56ab")}, ChannelType_: "WAC"}
+	b.postStatusWebhook(channel, newMsgStatus(channel, courier.NewMsgID(12346), "", courier.MsgDelivered))
+	assert.Equal(t, 0, len(b.statusWebhooks))
+	assert.Equal(t, 2, calls)
+}
+
+func TestStatusWebhookQueueFull(t *testing.T) {
+	// without workers to post them, statuses beyond what our queue holds are dropped
+	b := &backend{stopChan: make(chan bool), waitGroup: &sync.WaitGroup{}, statusWebhooks: make(chan *statusWebhookJob, 2)}
+
+	channel := &DBChannel{UUID_: courier.ChannelUUID{UUID: uuid.FromStringOrNil("dbc126ed-66bc-4e28-b67b-81dc3327c95d")}, ChannelType_: "WAC", Config_: utils.NullMap{Valid: true, Map: map[string]interface{}{
+		courier.ConfigStatusWebhook: map[string]interface{}{"url": "https://example.com/status"},
+	}}}
+	for i := 0; i < 5; i++ {
+		b.postStatusWebhook(channel, newMsgStatus(channel, courier.NewMsgID(int64(12345+i)), "", courier.MsgDelivered))
+	}
+	assert.Equal(t, 2, len(b.statusWebhooks))
+}
+
 func TestMsgSuite(t *testing.T) {
 	suite.Run(t, new(BackendTestSuite))
 }
@@ -3,6 +3,7 @@ package rapidpro
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/uuids"
 )
 
 // getChannel will look up the channel with the passed in UUID and channel type.
@@ -96,6 +98,56 @@ func loadChannelFromDB(ctx context.Context, db *sqlx.DB, channelType courier.Cha
 	return channel, nil
 }
 
+const updateChannelConfigSQL = `
+UPDATE
+	channels_channel
+SET
+	config = COALESCE(config::jsonb, '{}'::jsonb) || $2::jsonb
+WHERE
+	uuid = $1
+`
+
+// updateChannelConfig merges the passed in values into the channel's config in the db, clearing our cached copies of it
+// so they're reloaded rather than changed under anyone reading them
+func updateChannelConfig(ctx context.Context, db *sqlx.DB, channel *DBChannel, config map[string]interface{}) error {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, updateChannelConfigSQL, channel.UUID(), string(configJSON))
+	if err != nil {
+		return err
+	}
+
+	clearLocalChannel(channel.UUID())
+	clearLocalChannelByAddress(channel.ChannelAddress())
+	return nil
+}
+
+const insertChannelSQL = `
+INSERT INTO
+	channels_channel(org_id, is_active, created_on, modified_on, uuid, channel_type, name, schemes, address, config, role)
+	          VALUES($1, TRUE, NOW(), NOW(), $2, $3, $4, $5, $6, $7, 'SR')
+`
+
+// createChannel inserts a new channel for the passed in org, returning it as loaded from the db
+func createChannel(ctx context.Context, db *sqlx.DB, orgID OrgID, channelType courier.ChannelType, address courier.ChannelAddress, name string, schemes []string, config map[string]interface{}) (*DBChannel, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	channelUUID, _ := courier.NewChannelUUID(string(uuids.New()))
+
+	_, err = db.ExecContext(ctx, insertChannelSQL, orgID, channelUUID.String(), channelType, name, pq.StringArray(schemes), address, string(configJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	return loadChannelFromDB(ctx, db, channelType, channelUUID)
+}
+
 // getCachedChannel returns a Channel object for the passed in type and UUID.
 func getCachedChannel(channelType courier.ChannelType, uuid courier.ChannelUUID) (*DBChannel, error) {
 	// first see if the channel exists in our local cache
@@ -268,6 +320,99 @@ func clearLocalChannelByAddress(address courier.ChannelAddress) {
 var cacheByAddressMutex sync.RWMutex
 var channelByAddressCache = make(map[courier.ChannelAddress]*DBChannel)
 
+const lookupChannelUUIDFromWebhookTokenSQL = `
+SELECT
+	uuid
+FROM
+	channels_channel
+WHERE
+	channel_type = $1 AND
+	is_active = true AND
+	org_id IS NOT NULL AND
+	config::jsonb->>'webhook_token' = $2`
+
+// getChannelByWebhookToken will look up the channel with the passed in type and webhook token, it will return an
+// error if there's no active channel with that token
+func getChannelByWebhookToken(ctx context.Context, db *sqlx.DB, channelType courier.ChannelType, token string) (*DBChannel, error) {
+	if token == "" {
+		return nil, courier.ErrChannelNotFound
+	}
+
+	tokenCacheMutex.RLock()
+	uuid, found := channelUUIDByTokenCache[token]
+	tokenCacheMutex.RUnlock()
+
+	if !found {
+		err := db.GetContext(ctx, &uuid, lookupChannelUUIDFromWebhookTokenSQL, channelType, token)
+		if err == sql.ErrNoRows {
+			return nil, courier.ErrChannelNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	channel, err := getChannel(ctx, db, channelType, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens can be changed, so make sure the one we cached is still the channel's
+	if channel.StringConfigForKey(courier.ConfigWebhookToken, "") != token {
+		tokenCacheMutex.Lock()
+		delete(channelUUIDByTokenCache, token)
+		tokenCacheMutex.Unlock()
+		return nil, courier.ErrChannelNotFound
+	}
+
+	tokenCacheMutex.Lock()
+	channelUUIDByTokenCache[token] = uuid
+	tokenCacheMutex.Unlock()
+	return channel, nil
+}
+
+var tokenCacheMutex sync.RWMutex
+var channelUUIDByTokenCache = make(map[string]courier.ChannelUUID)
+
+const lookupChannelUUIDsFromConfigSQL = `
+SELECT
+	uuid
+FROM
+	channels_channel
+WHERE
+	channel_type = $1 AND
+	is_active = true AND
+	org_id IS NOT NULL AND
+	config::jsonb->>$2 = $3
+ORDER BY
+	id`
+
+// getChannelsByConfig looks up the active channels with the passed in type whose config has the passed in value for the
+// passed in key, which may be none
+func getChannelsByConfig(ctx context.Context, db *sqlx.DB, channelType courier.ChannelType, key string, value string) ([]*DBChannel, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	uuids := make([]courier.ChannelUUID, 0)
+	if err := db.SelectContext(ctx, &uuids, lookupChannelUUIDsFromConfigSQL, channelType, key, value); err != nil {
+		return nil, err
+	}
+
+	channels := make([]*DBChannel, 0, len(uuids))
+	for _, uuid := range uuids {
+		channel, err := getChannel(ctx, db, channelType, uuid)
+		if err == courier.ErrChannelNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
 //-----------------------------------------------------------------------------
 // Channel Implementation
 //-----------------------------------------------------------------------------
@@ -294,6 +439,9 @@ type DBChannel struct {
 // OrgID returns the id of the org this channel is for
 func (c *DBChannel) OrgID() OrgID { return c.OrgID_ }
 
+// ChannelOrgID returns the id of the org this channel is for as a plain int
+func (c *DBChannel) ChannelOrgID() int64 { return int64(c.OrgID_) }
+
 // OrgIsAnon returns the org for this channel is anonymous
 func (c *DBChannel) OrgIsAnon() bool { return c.OrgIsAnon_ }
 
@@ -365,6 +513,15 @@ func (c *DBChannel) ConfigForKey(key string, defaultValue interface{}) interface
 	return value
 }
 
+// Config returns a copy of the config of this channel
+func (c *DBChannel) Config() map[string]interface{} {
+	config := make(map[string]interface{}, len(c.Config_.Map))
+	for key, value := range c.Config_.Map {
+		config[key] = value
+	}
+	return config
+}
+
 // OrgConfigForKey returns the org config value for the passed in key, or defaultValue if it isn't found
 func (c *DBChannel) OrgConfigForKey(key string, defaultValue interface{}) interface{} {
 	// no value, return our default value
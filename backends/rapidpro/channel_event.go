@@ -10,7 +10,9 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/null"
+	"github.com/pkg/errors"
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
@@ -73,6 +75,11 @@ func newChannelEvent(channel courier.Channel, eventType courier.ChannelEventType
 func writeChannelEvent(ctx context.Context, b *backend, event courier.ChannelEvent) error {
 	dbEvent := event.(*DBChannelEvent)
 
+	// channel alerts aren't about any contact so don't belong in the database
+	if dbEvent.EventType_ == courier.ChannelAlert {
+		return writeChannelAlert(b, dbEvent)
+	}
+
 	err := writeChannelEventToDB(ctx, b, dbEvent)
 
 	// failed writing, write to our spool instead
@@ -87,6 +94,58 @@ func writeChannelEvent(ctx context.Context, b *backend, event courier.ChannelEve
 	return err
 }
 
+const (
+	// list of the latest alerts of each channel, kept for ops to review
+	channelAlertsKey = "channel_alerts:%s"
+
+	// how many alerts we keep for each channel
+	channelAlertsMax = 100
+)
+
+// writeChannelAlert adds the passed in alert event to the list of latest alerts for its channel
+func writeChannelAlert(b *backend, e *DBChannelEvent) error {
+	eventJSON, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(channelAlertsKey, e.ChannelUUID_)
+	rc.Send("LPUSH", key, eventJSON)
+	rc.Send("LTRIM", key, 0, channelAlertsMax-1)
+	_, err = rc.Do("")
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("channel_uuid", e.ChannelUUID_).WithField("extra", e.Extra()).Warning("channel alert received")
+	return nil
+}
+
+// GetChannelAlerts returns the latest alerts of the channel with the passed in UUID, newest first
+func (b *backend) GetChannelAlerts(ctx context.Context, uuid courier.ChannelUUID, limit int) ([]courier.ChannelEvent, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	values, err := redis.ByteSlices(rc.Do("LRANGE", fmt.Sprintf(channelAlertsKey, uuid), 0, limit-1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading alerts for channel: %s", uuid)
+	}
+
+	alerts := make([]courier.ChannelEvent, 0, len(values))
+	for _, value := range values {
+		alert := &DBChannelEvent{}
+		if err := json.Unmarshal(value, alert); err != nil {
+			logrus.WithError(err).WithField("channel_uuid", uuid).Error("error unmarshalling channel alert")
+			continue
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
 const insertChannelEventSQL = `
 INSERT INTO 
 	channels_channelevent("org_id", "channel_id", "contact_id", "contact_urn_id", "event_type", "extra", "occurred_on", "created_on")
@@ -97,7 +156,7 @@ RETURNING id
 // writeChannelEventToDB writes the passed in msg status to our db
 func writeChannelEventToDB(ctx context.Context, b *backend, e *DBChannelEvent) error {
 	// grab the contact for this event
-	contact, err := contactForURN(ctx, b, e.OrgID_, e.channel, e.URN_, "", e.ContactName_)
+	contact, err := getContact(ctx, b, e.OrgID_, e.channel, e.URN_, "", e.ContactName_)
 	if err != nil {
 		return err
 	}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
+	"fmt"
+	"regexp"
 	"strconv"
 	"time"
 	"unicode/utf8"
 
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/gocommon/uuids"
 	"github.com/nyaruka/librato"
@@ -16,6 +19,7 @@ import (
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/patrickmn/go-cache"
 	"github.com/sirupsen/logrus"
 )
 
@@ -96,6 +100,124 @@ WHERE
 	c.is_active = TRUE
 `
 
+// how long contacts we've resolved are reused for, long enough to cover a burst of messages from the same contact
+const contactCacheTTL = 5 * time.Second
+
+// getContact returns the contact for the passed in URN like contactForURN, but concurrent calls for the same URN share a
+// single lookup and recently resolved contacts are reused, so webhook bursts don't serialize on row locks or race to
+// create duplicate contacts
+func getContact(ctx context.Context, b *backend, org OrgID, channel *DBChannel, urn urns.URN, auth string, name string) (*DBContact, error) {
+	key := fmt.Sprintf("%d|%d|%s|%s", org, channel.ID_, urn.Identity(), auth)
+
+	if cached, found := b.contactCache.Get(key); found {
+		contact := *cached.(*DBContact)
+		contact.IsNew_ = false
+		return &contact, nil
+	}
+
+	resolved := false
+	value, err, _ := b.contactLookups.Do(key, func() (interface{}, error) {
+		resolved = true
+		contact, err := contactForURN(ctx, b, org, channel, urn, auth, name)
+		if err != nil {
+			return nil, err
+		}
+		b.contactCache.Set(key, contact, cache.DefaultExpiration)
+		return contact, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// only the caller which did the lookup can have created the contact
+	contact := *value.(*DBContact)
+	if !resolved {
+		contact.IsNew_ = false
+	}
+
+	if contact.IsNew_ {
+		flagPossibleDuplicate(ctx, b, channel, &contact, urn)
+	}
+	return &contact, nil
+}
+
+var nonDigitRegex = regexp.MustCompile(`[^0-9]`)
+
+// schemes whose paths are phone numbers, contacts with the same number under two of these are likely the same person
+var phoneSchemes = []string{urns.TelScheme, urns.WhatsAppScheme}
+
+const lookupDuplicateContactSQL = `
+SELECT
+	c.uuid
+FROM
+	contacts_contacturn u
+	INNER JOIN contacts_contact c ON u.contact_id = c.id
+WHERE
+	u.org_id = $1 AND
+	u.identity = ANY($2) AND
+	c.id != $3 AND
+	c.is_active = TRUE
+ORDER BY
+	c.id
+LIMIT 1
+`
+
+// phoneIdentities returns the identities the passed in URN's number would have under our other phone schemes
+func phoneIdentities(urn urns.URN) []string {
+	if !utils.StringArrayContains(phoneSchemes, urn.Scheme()) {
+		return nil
+	}
+
+	number := nonDigitRegex.ReplaceAllString(urn.Path(), "")
+	if number == "" {
+		return nil
+	}
+
+	identities := make([]string, 0, len(phoneSchemes)-1)
+	for _, scheme := range phoneSchemes {
+		if scheme == urn.Scheme() {
+			continue
+		}
+
+		path := number
+		if scheme == urns.TelScheme {
+			path = "+" + number
+		}
+		identities = append(identities, fmt.Sprintf("%s:%s", scheme, path))
+	}
+	return identities
+}
+
+// flagPossibleDuplicate writes a possible_dupe event if the passed in new contact has the same phone number as an
+// existing contact under another scheme, so they can be merged upstream. Failures are logged but otherwise ignored.
+func flagPossibleDuplicate(ctx context.Context, b *backend, channel *DBChannel, contact *DBContact, urn urns.URN) {
+	identities := phoneIdentities(urn)
+	if len(identities) == 0 {
+		return
+	}
+
+	log := logrus.WithField("urn", urn.Identity()).WithField("contact_uuid", contact.UUID_)
+
+	var duplicateUUID courier.ContactUUID
+	err := b.db.GetContext(ctx, &duplicateUUID, lookupDuplicateContactSQL, contact.OrgID_, pq.Array(identities), contact.ID_)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("error looking up possible duplicate contact")
+		return
+	}
+
+	event := newChannelEvent(channel, courier.PossibleDuplicate, urn).WithExtra(map[string]interface{}{
+		"contact_uuid":           contact.UUID_.String(),
+		"duplicate_contact_uuid": duplicateUUID.String(),
+	})
+	err = writeChannelEvent(ctx, b, event)
+	if err != nil {
+		log.WithError(err).Error("error writing possible duplicate event")
+	}
+}
+
 // contactForURN first tries to look up a contact for the passed in URN, if not finding one then creating one
 func contactForURN(ctx context.Context, b *backend, org OrgID, channel *DBChannel, urn urns.URN, auth string, name string) (*DBContact, error) {
 	// try to look up our contact by URN
@@ -135,11 +257,11 @@ func contactForURN(ctx context.Context, b *backend, org OrgID, channel *DBChanne
 	if !channel.OrgIsAnon() {
 		// no name was passed in, see if our handler can look up information for this URN
 		if name == "" {
-			handler := courier.GetHandler(channel.ChannelType())
+			handler := b.getHandler(channel.ChannelType())
 			if handler != nil {
 				describer, isDescriber := handler.(courier.URNDescriber)
 				if isDescriber {
-					atts, err := describer.DescribeURN(ctx, channel, urn)
+					atts, err := describeURN(ctx, b, describer, channel, urn)
 
 					// in the case of errors, we log the error but move onwards anyways
 					if err != nil {
@@ -0,0 +1,116 @@
+package rapidpro
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	// the UUID and text of the msg received with an external id, by channel and URN
+	externalIDSeenKey = "seen:externalid:%s:%s|%s"
+
+	// the UUID of the msg received with a fingerprint, for msgs of providers which don't send external ids
+	msgFingerprintSeenKey = "seen:fingerprint:%s:%s|%s|%d"
+
+	// the daily hashes external ids were remembered in before they had their own keys, read until they've all expired
+	legacyExternalIDSeenKey = "seen:externalid:%s"
+
+	// how many seconds we remember msgs without a timestamp from their provider by their fingerprint, which is only long
+	// enough to catch retries of the same webhook as contacts can send the same text again, e.g. "yes"
+	untimedFingerprintWindow = 15
+)
+
+// dedupWindow returns how many seconds the msgs of the passed in channel are remembered for to drop duplicates
+func (b *backend) dedupWindow(channel courier.Channel) int {
+	return b.dedupWindows.Get(channel.ChannelType(), b.config.DedupWindow)
+}
+
+// checkExternalIDSeen returns the UUID of the msg received on the channel and URN of the passed in msg with its external
+// id and text within the dedup window of its channel, or NilMsgUUID if there isn't one
+func checkExternalIDSeen(b *backend, msg courier.Msg) courier.MsgUUID {
+	r := b.redisPool.Get()
+	defer r.Close()
+
+	found, _ := redis.String(r.Do("GET", fmt.Sprintf(externalIDSeenKey, msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())))
+	if found == "" {
+		found = checkLegacyExternalIDSeen(r, msg)
+	}
+
+	// if so, test whether the text it the same
+	if found != "" {
+		prevText := found[37:]
+
+		// if it is the same, return the UUID
+		if prevText == msg.Text() {
+			return courier.NewMsgUUIDFromString(found[:36])
+		}
+	}
+	return courier.NilMsgUUID
+}
+
+// checkLegacyExternalIDSeen looks up the passed in msg in the daily hashes of today and yesterday which external ids were
+// remembered in before, returning its UUID and text if found
+func checkLegacyExternalIDSeen(r redis.Conn, msg courier.Msg) string {
+	field := fmt.Sprintf("%s:%s|%s", msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())
+
+	now := time.Now().In(time.UTC)
+	for _, day := range []time.Time{now, now.Add(time.Hour * -24)} {
+		found, _ := redis.String(r.Do("HGET", fmt.Sprintf(legacyExternalIDSeenKey, day.Format("2006-01-02")), field))
+		if found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+// writeExternalIDSeen remembers the passed in msg by its external id for the dedup window of its channel
+func writeExternalIDSeen(b *backend, msg courier.Msg) {
+	r := b.redisPool.Get()
+	defer r.Close()
+
+	uuidText := fmt.Sprintf("%s|%s", msg.UUID().String(), msg.Text())
+	r.Do("SET", fmt.Sprintf(externalIDSeenKey, msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID()), uuidText, "EX", b.dedupWindow(msg.Channel()))
+}
+
+// msgFingerprintKey returns the key we remember the passed in msg by when it has no external id, from its channel, URN,
+// a hash of its text and attachments, and the timestamp its provider sent it with, if any
+func msgFingerprintKey(msg *DBMsg) string {
+	hash := sha1.Sum([]byte(msg.Text_ + "\n" + strings.Join(msg.Attachments_, "\n")))
+
+	var receivedOn int64
+	if msg.SentOn_ != nil {
+		receivedOn = msg.SentOn_.Unix()
+	}
+	return fmt.Sprintf(msgFingerprintSeenKey, msg.ChannelUUID_, msg.URN_.Identity(), fmt.Sprintf("%x", hash), receivedOn)
+}
+
+// checkMsgFingerprintSeen returns the UUID of the msg received with the passed in fingerprint key within the dedup window
+// of its channel, or NilMsgUUID if there isn't one
+func checkMsgFingerprintSeen(b *backend, fingerprint string) courier.MsgUUID {
+	r := b.redisPool.Get()
+	defer r.Close()
+
+	found, _ := redis.String(r.Do("GET", fingerprint))
+	if found == "" {
+		return courier.NilMsgUUID
+	}
+	return courier.NewMsgUUIDFromString(found)
+}
+
+// writeMsgFingerprintSeen remembers the passed in msg by its fingerprint key for the dedup window of its channel, or only
+// for a few seconds if its provider didn't send a timestamp, as then the same text from the same contact is the same msg
+func writeMsgFingerprintSeen(b *backend, msg *DBMsg, fingerprint string) {
+	r := b.redisPool.Get()
+	defer r.Close()
+
+	window := b.dedupWindow(msg.Channel())
+	if msg.SentOn_ == nil && window > untimedFingerprintWindow {
+		window = untimedFingerprintWindow
+	}
+	r.Do("SET", fingerprint, msg.UUID().String(), "EX", window)
+}
@@ -0,0 +1,117 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/librato"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the key we cache URN descriptions in
+const urnDescriptionKey = "urn_description:%s:%s"
+
+// the key counting describe calls for a channel in the current second
+const describeRateKey = "describe_urn_rate:%s:%d"
+
+// how long we'll wait in line for a free describe slot before giving up
+const describeMaxWait = time.Second * 2
+
+// how often we check for a free slot while waiting
+const describeWaitInterval = time.Millisecond * 100
+
+var luaDescribeRate = redis.NewScript(1, `-- KEYS: [RateKey] ARGV: [Limit]
+	local count = redis.call("incr", KEYS[1])
+	redis.call("expire", KEYS[1], 5)
+
+	if count > tonumber(ARGV[1]) then
+		return 0
+	end
+	return 1
+`)
+
+// errDescribeRateLimited is returned when we couldn't get a describe slot for a channel in time
+var errDescribeRateLimited = errors.New("rate limited describing URN")
+
+// describeURN describes the passed in URN using the passed in describer, results are cached in redis and calls
+// are rate limited per channel, callers waiting on a slot for too long get errDescribeRateLimited. We don't hold a redis
+// connection while waiting or describing so slow vendors can't starve our pool.
+func describeURN(ctx context.Context, b *backend, describer courier.URNDescriber, channel courier.Channel, urn urns.URN) (map[string]string, error) {
+	cacheKey := fmt.Sprintf(urnDescriptionKey, channel.UUID(), urn.Identity())
+
+	// do we have this cached already?
+	rc := b.redisPool.Get()
+	cached, err := redis.Bytes(rc.Do("get", cacheKey))
+	rc.Close()
+	if err == nil {
+		atts := make(map[string]string)
+		if json.Unmarshal(cached, &atts) == nil {
+			librato.Gauge("courier.describe_urn_cache_hit", float64(1))
+			return atts, nil
+		}
+	}
+
+	// describing is a nicety we skip when overloaded, leaving the contact without a name
+	if courier.ShedLoad("describe_urn") {
+		return map[string]string{}, nil
+	}
+
+	// wait in line for a slot in this channel's rate
+	if limit := b.config.Current().DescribeURNRateLimit; limit > 0 {
+		err = waitForDescribeSlot(ctx, b.redisPool, channel, limit)
+		if err != nil {
+			librato.Gauge("courier.describe_urn_rate_limited", float64(1))
+			return nil, err
+		}
+	}
+
+	atts, err := describer.DescribeURN(ctx, channel, urn)
+	if err != nil {
+		return nil, err
+	}
+
+	// failing to cache isn't fatal, we still have our description
+	encoded, _ := json.Marshal(atts)
+	rc = b.redisPool.Get()
+	_, err = rc.Do("setex", cacheKey, b.config.Current().DescribeURNCacheTTL, encoded)
+	rc.Close()
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error caching URN description")
+	}
+
+	return atts, nil
+}
+
+// waitForDescribeSlot blocks until our channel has a free describe slot in the current second
+func waitForDescribeSlot(ctx context.Context, rp *redis.Pool, channel courier.Channel, limit int) error {
+	deadline := time.Now().Add(describeMaxWait)
+
+	for {
+		rateKey := fmt.Sprintf(describeRateKey, channel.UUID(), time.Now().Unix())
+		rc := rp.Get()
+		ok, err := redis.Bool(luaDescribeRate.Do(rc, rateKey, limit))
+		rc.Close()
+		if err != nil {
+			return errors.Wrapf(err, "error checking describe rate")
+		}
+		if ok {
+			return nil
+		}
+
+		if time.Now().Add(describeWaitInterval).After(deadline) {
+			return errDescribeRateLimited
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(describeWaitInterval):
+		}
+	}
+}
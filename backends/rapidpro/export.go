@@ -0,0 +1,97 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/null"
+)
+
+const exportMsgsSQL = `
+SELECT
+	m.id,
+	m.uuid,
+	m.direction,
+	m.status,
+	cu.identity AS urn,
+	m.text,
+	m.attachments,
+	m.external_id,
+	m.error_count,
+	m.created_on,
+	m.modified_on,
+	m.sent_on
+FROM
+	msgs_msg m
+	LEFT JOIN contacts_contacturn cu ON cu.id = m.contact_urn_id
+WHERE
+	m.channel_id = $1 AND
+	m.created_on >= $2 AND
+	m.created_on < $3 AND
+	m.id > $4
+ORDER BY
+	m.id
+LIMIT $5
+`
+
+// exportedMsgRow is a msg as read for export
+type exportedMsgRow struct {
+	ID          courier.MsgID          `db:"id"`
+	UUID        null.String            `db:"uuid"`
+	Direction   MsgDirection           `db:"direction"`
+	Status      courier.MsgStatusValue `db:"status"`
+	URN         null.String            `db:"urn"`
+	Text        string                 `db:"text"`
+	Attachments pq.StringArray         `db:"attachments"`
+	ExternalID  null.String            `db:"external_id"`
+	ErrorCount  int                    `db:"error_count"`
+	CreatedOn   time.Time              `db:"created_on"`
+	ModifiedOn  time.Time              `db:"modified_on"`
+	SentOn      *time.Time             `db:"sent_on"`
+}
+
+// ExportMsgs returns up to limit msgs of the passed in channel created within the passed in time range, with IDs
+// greater than the passed in cursor, in order of ID
+func (b *backend) ExportMsgs(ctx context.Context, channel courier.Channel, after time.Time, before time.Time, cursor courier.MsgID, limit int) ([]*courier.ExportedMsg, error) {
+	dbChannel, isDBChannel := channel.(*DBChannel)
+	if !isDBChannel {
+		return nil, fmt.Errorf("unable to export msgs of channel %s", channel.UUID())
+	}
+
+	rows := make([]*exportedMsgRow, 0, limit)
+	err := b.db.SelectContext(ctx, &rows, exportMsgsSQL, dbChannel.ID(), after, before, int64(cursor), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*courier.ExportedMsg, len(rows))
+	for i, row := range rows {
+		direction := "in"
+		if row.Direction == MsgOutgoing {
+			direction = "out"
+		}
+		attachments := []string(row.Attachments)
+		if attachments == nil {
+			attachments = []string{}
+		}
+
+		msgs[i] = &courier.ExportedMsg{
+			ID:          row.ID,
+			UUID:        courier.NewMsgUUIDFromString(string(row.UUID)),
+			Direction:   direction,
+			Status:      row.Status,
+			URN:         string(row.URN),
+			Text:        row.Text,
+			Attachments: attachments,
+			ExternalID:  string(row.ExternalID),
+			ErrorCount:  row.ErrorCount,
+			CreatedOn:   row.CreatedOn,
+			ModifiedOn:  row.ModifiedOn,
+			SentOn:      row.SentOn,
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,44 @@
+package rapidpro
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	// maps the external id of a secondary part of a sent msg to the external id of the msg, by channel
+	externalIDAliasKey = "courier:external_id_alias:%s:%s"
+
+	// how long we remember aliases, statuses usually arrive within minutes but can be delayed by days
+	externalIDAliasExpiration = 60 * 60 * 24 * 7
+)
+
+// writeExternalIDAliases remembers the external ids of the secondary parts of the passed in status so that statuses
+// for those parts can later be written to the msg they were part of
+func writeExternalIDAliases(rc redis.Conn, status courier.MsgStatus) error {
+	secondaryIDs := courier.SecondaryExternalIDs(status)
+	if status.ExternalID() == "" || len(secondaryIDs) == 0 {
+		return nil
+	}
+
+	for _, id := range secondaryIDs {
+		rc.Send("SET", fmt.Sprintf(externalIDAliasKey, status.ChannelUUID(), id), status.ExternalID(), "EX", externalIDAliasExpiration)
+	}
+	_, err := rc.Do("")
+	return err
+}
+
+// resolveExternalIDAlias returns the external id of the msg which the passed in external id was a secondary part of, or
+// the passed in external id if it isn't an alias
+func resolveExternalIDAlias(rc redis.Conn, channelUUID courier.ChannelUUID, externalID string) (string, error) {
+	primaryID, err := redis.String(rc.Do("GET", fmt.Sprintf(externalIDAliasKey, channelUUID, externalID)))
+	if err == redis.ErrNil {
+		return externalID, nil
+	}
+	if err != nil {
+		return externalID, err
+	}
+	return primaryID, nil
+}
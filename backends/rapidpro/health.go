@@ -0,0 +1,113 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/librato"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the set of channels we have heartbeats for
+const channelHealthSetName = "channel_health"
+
+// the hash holding the health of a single channel
+const channelHealthKey = "channel_health:%s"
+
+// how long we keep the health of a channel around after its last heartbeat
+const channelHealthTTL = 60 * 60 * 24 * 7
+
+var luaChannelHeartbeat = redis.NewScript(2, `-- KEYS: [HealthKey, SetKey] ARGV: [ChannelUUID, Status, Now, TTL]
+	if ARGV[2] == "ok" then
+		redis.call("hset", KEYS[1], "failures", 0, "last_success", ARGV[3], "healthy", 1)
+	else
+		redis.call("hincrby", KEYS[1], "failures", 1)
+		redis.call("hset", KEYS[1], "last_failure", ARGV[3])
+	end
+
+	redis.call("expire", KEYS[1], ARGV[4])
+	redis.call("sadd", KEYS[2], ARGV[1])
+`)
+
+// WriteChannelHeartbeat records a success or failure for the passed in channel, channels are only marked
+// unhealthy by our heartbeat once they pass our failure threshold
+func (b *backend) WriteChannelHeartbeat(ctx context.Context, channel courier.Channel, status courier.ChannelHeartbeatStatus) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(channelHealthKey, channel.UUID())
+	_, err := luaChannelHeartbeat.Do(rc, key, channelHealthSetName, channel.UUID().String(), string(status), time.Now().Unix(), channelHealthTTL)
+	return err
+}
+
+// GetChannelHealth returns the health of the channel with the passed in UUID, channels we have never seen
+// a heartbeat for are considered healthy
+func (b *backend) GetChannelHealth(ctx context.Context, uuid courier.ChannelUUID) (*courier.ChannelHealth, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	values, err := redis.StringMap(rc.Do("hgetall", fmt.Sprintf(channelHealthKey, uuid)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading health for channel: %s", uuid)
+	}
+
+	health := &courier.ChannelHealth{ChannelUUID: uuid, Healthy: values["healthy"] != "0"}
+	health.ConsecutiveFailures, _ = strconv.Atoi(values["failures"])
+	health.LastSuccessOn = parseHealthTime(values["last_success"])
+	health.LastFailureOn = parseHealthTime(values["last_failure"])
+	return health, nil
+}
+
+// markUnhealthyChannels marks every channel which has reached the failure threshold as unhealthy, returning how many are
+func markUnhealthyChannels(rc redis.Conn, threshold int) (int, error) {
+	uuids, err := redis.Strings(rc.Do("smembers", channelHealthSetName))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading channel health set")
+	}
+
+	unhealthy := 0
+	for _, uuid := range uuids {
+		key := fmt.Sprintf(channelHealthKey, uuid)
+		values, err := redis.Strings(rc.Do("hmget", key, "failures", "healthy"))
+		if err != nil {
+			return unhealthy, errors.Wrapf(err, "error reading health for channel: %s", uuid)
+		}
+
+		// our health expired, stop tracking this channel
+		if values[0] == "" && values[1] == "" {
+			rc.Do("srem", channelHealthSetName, uuid)
+			continue
+		}
+
+		failures, _ := strconv.Atoi(values[0])
+		if failures < threshold {
+			continue
+		}
+
+		unhealthy++
+		if values[1] != "0" {
+			_, err = rc.Do("hset", key, "healthy", 0)
+			if err != nil {
+				return unhealthy, errors.Wrapf(err, "error marking channel unhealthy: %s", uuid)
+			}
+			logrus.WithField("channel_uuid", uuid).WithField("failures", failures).Warn("channel marked unhealthy")
+		}
+	}
+
+	librato.Gauge("courier.unhealthy_channels", float64(unhealthy))
+	return unhealthy, nil
+}
+
+func parseHealthTime(value string) *time.Time {
+	epoch, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.Unix(epoch, 0).UTC()
+	return &t
+}
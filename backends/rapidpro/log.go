@@ -8,6 +8,7 @@ import (
 
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/null"
 )
 
 const insertLogSQL = `
@@ -53,6 +54,10 @@ func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) e
 	log.Request = utils.CleanString(log.Request)
 	log.Response = utils.CleanString(log.Response)
 
+	// and truncate and compress them if they're big
+	request := courier.CompressLogBody(log.Request, b.config.LogBodyCompressSize, b.config.LogBodyMaxSize)
+	response := courier.CompressLogBody(log.Response, b.config.LogBodyCompressSize, b.config.LogBodyMaxSize)
+
 	// create our value for committing
 	v := &ChannelLog{
 		ChannelID:      dbChan.ID(),
@@ -61,8 +66,8 @@ func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) e
 		IsError:        log.Error != "",
 		Method:         log.Method,
 		URL:            log.URL,
-		Request:        log.Request,
-		Response:       log.Response,
+		Request:        request,
+		Response:       response,
 		ResponseStatus: log.StatusCode,
 		CreatedOn:      log.CreatedOn,
 		RequestTime:    int(log.Elapsed / time.Millisecond),
@@ -72,3 +77,72 @@ func writeChannelLog(ctx context.Context, b *backend, log *courier.ChannelLog) e
 	b.logCommitter.Queue(v)
 	return nil
 }
+
+const selectChannelLogsSQL = `
+SELECT
+	msg_id,
+	description,
+	is_error,
+	method,
+	url,
+	request,
+	response,
+	response_status,
+	created_on,
+	request_time
+FROM
+	channels_channellog
+WHERE
+	channel_id = $1 AND
+	($2 = 0 OR msg_id = $2)
+ORDER BY
+	created_on DESC,
+	id DESC
+LIMIT $3
+`
+
+// storedLogRow is a channel log as read back from the database
+type storedLogRow struct {
+	MsgID          courier.MsgID `db:"msg_id"`
+	Description    string        `db:"description"`
+	IsError        bool          `db:"is_error"`
+	Method         null.String   `db:"method"`
+	URL            null.String   `db:"url"`
+	Request        null.String   `db:"request"`
+	Response       null.String   `db:"response"`
+	ResponseStatus null.Int      `db:"response_status"`
+	CreatedOn      time.Time     `db:"created_on"`
+	RequestTime    null.Int      `db:"request_time"`
+}
+
+// GetChannelLogs returns up to limit of the most recent logs of the passed in channel, only those of the passed in msg
+// if it isn't NilMsgID
+func (b *backend) GetChannelLogs(ctx context.Context, channel courier.Channel, msgID courier.MsgID, limit int) ([]*courier.StoredChannelLog, error) {
+	dbChannel, isDBChannel := channel.(*DBChannel)
+	if !isDBChannel {
+		return nil, fmt.Errorf("unable to get logs of channel %s", channel.UUID())
+	}
+
+	rows := make([]*storedLogRow, 0, limit)
+	err := b.db.SelectContext(ctx, &rows, selectChannelLogsSQL, dbChannel.ID(), int64(msgID), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*courier.StoredChannelLog, len(rows))
+	for i, row := range rows {
+		logs[i] = &courier.StoredChannelLog{
+			MsgID:          row.MsgID,
+			Description:    row.Description,
+			IsError:        row.IsError,
+			Method:         string(row.Method),
+			URL:            string(row.URL),
+			Request:        string(row.Request),
+			Response:       string(row.Response),
+			ResponseStatus: int(row.ResponseStatus),
+			CreatedOn:      row.CreatedOn,
+			RequestTime:    int(row.RequestTime),
+		}
+	}
+	return logs, nil
+}
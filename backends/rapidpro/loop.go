@@ -0,0 +1,82 @@
+package rapidpro
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	loopProtectionDrop = "drop"
+	loopProtectionFlag = "flag"
+
+	// how many seconds after a send we consider identical incoming msgs echoes, unless the channel configures otherwise
+	defaultLoopWindow = 30
+
+	// key of the fingerprint of a msg we sent
+	loopKeyPattern = "loop:%s:%s"
+)
+
+// loopFingerprint returns the fingerprint of a msg's text and URN on its channel, which is the same in both directions
+func loopFingerprint(msg *DBMsg) string {
+	hash := sha1.Sum([]byte(fmt.Sprintf("%s|%s", msg.URN_.Identity(), strings.TrimSpace(msg.Text_))))
+	return fmt.Sprintf(loopKeyPattern, msg.ChannelUUID_, hex.EncodeToString(hash[:]))
+}
+
+// writeLoopFingerprint records that we just sent the passed in msg, if its channel has loop protection
+func writeLoopFingerprint(rc redis.Conn, msg *DBMsg) {
+	channel := msg.Channel()
+	if channel.StringConfigForKey(courier.ConfigLoopProtection, "") == "" || msg.Text_ == "" {
+		return
+	}
+
+	window := channel.IntConfigForKey(courier.ConfigLoopWindow, defaultLoopWindow)
+	_, err := rc.Do("SET", loopFingerprint(msg), msg.ID().String(), "EX", window)
+	if err != nil {
+		logrus.WithError(err).WithField("msg_id", msg.ID().String()).Error("error writing loop fingerprint")
+	}
+}
+
+// checkMsgLoop looks up whether the passed in incoming msg echoes one we just sent, flagging it in its metadata if so
+// and its channel flags loops. Returns true if the msg should be dropped.
+func checkMsgLoop(b *backend, msg *DBMsg) bool {
+	mode := msg.Channel().StringConfigForKey(courier.ConfigLoopProtection, "")
+	if mode == "" || msg.Text_ == "" {
+		return false
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	sentID, err := redis.String(rc.Do("GET", loopFingerprint(msg)))
+	if err == redis.ErrNil {
+		return false
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("msg_uuid", msg.UUID().String()).Error("error checking loop fingerprint")
+		return false
+	}
+
+	log := logrus.WithField("channel_uuid", msg.ChannelUUID_).WithField("msg_uuid", msg.UUID().String()).WithField("sent_msg_id", sentID)
+
+	if mode == loopProtectionDrop {
+		log.Info("dropping incoming msg which echoes a msg we sent")
+		return true
+	}
+
+	metadata := map[string]interface{}{}
+	if len(msg.Metadata_) > 0 {
+		json.Unmarshal(msg.Metadata_, &metadata)
+	}
+	metadata["possible_loop"] = true
+	msg.Metadata_, _ = json.Marshal(metadata)
+
+	log.Info("flagging incoming msg which echoes a msg we sent")
+	return false
+}
@@ -0,0 +1,94 @@
+package rapidpro
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+)
+
+// how many times we try to download media, and how long we wait before retrying, doubling after each retry
+var mediaDownloadAttempts = 3
+var mediaDownloadBackoff = time.Second
+
+// fetchMedia downloads the media of the passed in request, validating it against the passed in checksum if there is
+// one. Failures, which are often transient, are retried with backoff, and if they persist a MediaDownloadError is
+// returned so that the msg can be retried later rather than written without its media.
+func fetchMedia(ctx context.Context, req *http.Request, checksum *courier.MediaChecksum) (*http.Response, []byte, error) {
+	var err error
+	backoff := mediaDownloadBackoff
+
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		var body []byte
+
+		resp, body, err = fetchMediaOnce(req.Clone(ctx), checksum)
+		if err == nil {
+			return resp, body, nil
+		}
+		if attempt >= mediaDownloadAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, &courier.MediaDownloadError{URL: req.URL.String(), Err: ctx.Err()}
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+
+	return nil, nil, &courier.MediaDownloadError{URL: req.URL.String(), Err: err}
+}
+
+// fetchMediaOnce makes a single attempt at downloading media, failing on errors which are worth retrying
+func fetchMediaOnce(req *http.Request, checksum *courier.MediaChecksum) (*http.Response, []byte, error) {
+	resp, err := utils.GetHTTPClient().Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, nil, fmt.Errorf("received status %d", resp.StatusCode)
+	}
+
+	// a download cut short or corrupted on its way is worth trying again
+	if resp.ContentLength >= 0 && int64(len(body)) != resp.ContentLength {
+		return nil, nil, fmt.Errorf("received %d bytes of content length %d", len(body), resp.ContentLength)
+	}
+	if err := validateMediaChecksum(body, checksum); err != nil {
+		return nil, nil, err
+	}
+
+	return resp, body, nil
+}
+
+// validateMediaChecksum checks the passed in media against what we know of its size and SHA256
+func validateMediaChecksum(body []byte, checksum *courier.MediaChecksum) error {
+	if checksum == nil {
+		return nil
+	}
+	if checksum.Size > 0 && len(body) != checksum.Size {
+		return fmt.Errorf("received %d bytes of media of size %d", len(body), checksum.Size)
+	}
+	if checksum.SHA256 != "" {
+		sum := sha256.Sum256(body)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), checksum.SHA256) {
+			return fmt.Errorf("media SHA256 %s doesn't match %s", hex.EncodeToString(sum[:]), checksum.SHA256)
+		}
+	}
+	return nil
+}
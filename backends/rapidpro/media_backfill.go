@@ -0,0 +1,154 @@
+package rapidpro
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/null"
+	"github.com/sirupsen/logrus"
+)
+
+// how many msgs we scan at a time when backfilling media
+const mediaBackfillBatchSize = 100
+
+const selectMediaBackfillMsgsSQL = `
+SELECT
+	m.id,
+	m.uuid,
+	m.org_id,
+	c.uuid AS channel_uuid,
+	m.attachments
+FROM
+	msgs_msg m
+	JOIN channels_channel c ON c.id = m.channel_id
+WHERE
+	m.direction = 'I' AND
+	m.created_on >= $1 AND
+	m.id > $2 AND
+	c.channel_type = ANY($3) AND
+	array_length(m.attachments, 1) > 0
+ORDER BY
+	m.id
+LIMIT $4
+`
+
+const updateMsgAttachmentsSQL = `
+UPDATE
+	msgs_msg
+SET
+	attachments = $2,
+	modified_on = NOW()
+WHERE
+	id = $1
+`
+
+// mediaBackfillRow is an incoming msg with attachments as read for backfilling its media
+type mediaBackfillRow struct {
+	ID          courier.MsgID       `db:"id"`
+	UUID        null.String         `db:"uuid"`
+	OrgID       OrgID               `db:"org_id"`
+	ChannelUUID courier.ChannelUUID `db:"channel_uuid"`
+	Attachments pq.StringArray      `db:"attachments"`
+}
+
+// BackfillMedia re-resolves the attachments of incoming msgs created since the passed in time whose handlers can
+// resolve fresh URLs for their expired media, storing what can still be downloaded and updating those msgs
+func (b *backend) BackfillMedia(ctx context.Context, since time.Time) (*courier.MediaBackfillResult, error) {
+	result := &courier.MediaBackfillResult{}
+
+	channelTypes := make([]string, 0)
+	for channelType, handler := range courier.RegisteredHandlers() {
+		if _, isResolver := handler.(courier.MediaURLResolver); isResolver {
+			channelTypes = append(channelTypes, string(channelType))
+		}
+	}
+	if len(channelTypes) == 0 {
+		return result, nil
+	}
+
+	cursor := courier.NilMsgID
+	for {
+		rows := make([]*mediaBackfillRow, 0, mediaBackfillBatchSize)
+		err := b.db.SelectContext(ctx, &rows, selectMediaBackfillMsgsSQL, since, int64(cursor), pq.StringArray(channelTypes), mediaBackfillBatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, row := range rows {
+			cursor = row.ID
+			result.Msgs++
+
+			channel, err := b.GetChannel(ctx, courier.AnyChannelType, row.ChannelUUID)
+			if err != nil {
+				// channels which have since been removed have nothing left to resolve with
+				result.Failed += len(row.Attachments)
+				continue
+			}
+
+			attachments, resolved, failed := b.backfillMsgMedia(ctx, channel, row)
+			result.Resolved += resolved
+			result.Failed += failed
+
+			if resolved > 0 {
+				if _, err := b.db.ExecContext(ctx, updateMsgAttachmentsSQL, row.ID, pq.StringArray(attachments)); err != nil {
+					return result, err
+				}
+			}
+		}
+
+		if len(rows) < mediaBackfillBatchSize {
+			return result, nil
+		}
+	}
+}
+
+// backfillMsgMedia re-resolves and stores the attachments of the passed in msg which its channel's handler can resolve,
+// returning its attachments as they should now be and the number resolved and failed
+func (b *backend) backfillMsgMedia(ctx context.Context, channel courier.Channel, row *mediaBackfillRow) ([]string, int, int) {
+	resolver, isResolver := b.getHandler(channel.ChannelType()).(courier.MediaURLResolver)
+	if !isResolver {
+		return row.Attachments, 0, 0
+	}
+
+	log := logrus.WithField("comp", "media_backfill").WithField("channel_uuid", channel.UUID()).WithField("msg_id", row.ID)
+	attachments := make([]string, len(row.Attachments))
+	resolved, failed := 0, 0
+
+	for i, attachment := range row.Attachments {
+		attachments[i] = attachment
+
+		// attachments are usually prefixed by their content type, unless their download failed when they were received
+		mediaURL := attachment
+		if !strings.HasPrefix(attachment, "http") {
+			parts := strings.SplitN(attachment, ":", 2)
+			if len(parts) < 2 {
+				continue
+			}
+			mediaURL = parts[1]
+		}
+
+		freshURL, err := resolver.ResolveMediaURL(ctx, b.config, channel, mediaURL)
+		if err != nil {
+			log.WithField("media_url", mediaURL).WithError(err).Warn("unable to resolve media")
+			failed++
+			continue
+		}
+		if freshURL == "" {
+			continue
+		}
+
+		stored, err := downloadMediaToS3(ctx, b, channel, row.OrgID, courier.NewMsgUUIDFromString(string(row.UUID)), freshURL)
+		if err != nil {
+			log.WithField("media_url", freshURL).WithError(err).Warn("unable to download media")
+			failed++
+			continue
+		}
+
+		attachments[i] = stored
+		resolved++
+	}
+	return attachments, resolved, failed
+}
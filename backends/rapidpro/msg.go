@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
@@ -22,8 +21,8 @@ import (
 	"github.com/gomodule/redigo/redis"
 	"github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/metrics"
 	"github.com/nyaruka/courier/queue"
-	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/nyaruka/null"
 	"github.com/sirupsen/logrus"
@@ -40,6 +39,9 @@ const (
 	NilMsgDirection MsgDirection = ""
 )
 
+// msgHandled is the status mailroom gives incoming messages once handled, synced messages are written with it
+const msgHandled = courier.MsgStatusValue("H")
+
 // MsgVisibility is the visibility of a message
 type MsgVisibility string
 
@@ -59,8 +61,30 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 		return nil
 	}
 
+	// incoming msgs without an external id are deduped by their fingerprint instead, e.g. when their webhook is retried,
+	// which we take before their attachments are swapped for where we download them to
+	fingerprint := ""
+	if m.Direction_ == MsgIncoming && m.ExternalID_ == "" {
+		fingerprint = msgFingerprintKey(m)
+		if prevUUID := checkMsgFingerprintSeen(b, fingerprint); prevUUID != courier.NilMsgUUID {
+			m.UUID_ = prevUUID
+			return nil
+		}
+	}
+
 	channel := m.Channel()
 
+	// incoming msgs which echo our own sends are dropped or flagged depending on the channel, unless they're synced
+	// history which can repeat what we sent
+	if m.Direction_ == MsgIncoming && !m.Synced_ && checkMsgLoop(b, m) {
+		return nil
+	}
+
+	// as are those from contacts flooding the channel, though not those synced in bursts from a business app's history
+	if m.Direction_ == MsgIncoming && !m.Synced_ && checkMsgVelocity(b, m) {
+		return nil
+	}
+
 	// if we have media, go download it to S3
 	for i, attachment := range m.Attachments_ {
 		if strings.HasPrefix(attachment, "http") {
@@ -78,6 +102,8 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 	// fail? log
 	if err != nil {
 		logrus.WithError(err).WithField("msg", m.UUID().String()).Error("error writing to db")
+	} else {
+		b.msgHandled(ctx, m)
 	}
 
 	// if we failed write to spool
@@ -86,6 +112,9 @@ func writeMsg(ctx context.Context, b *backend, msg courier.Msg) error {
 	}
 	// mark this msg as having been seen
 	writeMsgSeen(b, m)
+	if fingerprint != "" {
+		writeMsgFingerprintSeen(b, m, fingerprint)
+	}
 	return err
 }
 
@@ -129,9 +158,19 @@ INSERT INTO
 RETURNING id
 `
 
+// synced msgs are never queued to mailroom, which is what stores the metadata of other msgs, so we insert theirs
+const insertSyncedMsgSQL = `
+INSERT INTO
+	msgs_msg(org_id, uuid, direction, text, attachments, msg_count, error_count, high_priority, status,
+             visibility, external_id, channel_id, contact_id, contact_urn_id, created_on, modified_on, next_attempt, queued_on, sent_on, metadata)
+    VALUES(:org_id, :uuid, :direction, :text, :attachments, :msg_count, :error_count, :high_priority, :status,
+           :visibility, :external_id, :channel_id, :contact_id, :contact_urn_id, :created_on, :modified_on, :next_attempt, :queued_on, :sent_on, NULLIF(:metadata, ''))
+RETURNING id
+`
+
 func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 	// grab the contact for this msg
-	contact, err := contactForURN(ctx, b, m.OrgID_, m.channel, m.URN_, m.URNAuth_, m.ContactName_)
+	contact, err := getContact(ctx, b, m.OrgID_, m.channel, m.URN_, m.URNAuth_, m.ContactName_)
 
 	// our db is down, write to the spool, we will write/queue this later
 	if err != nil {
@@ -142,7 +181,12 @@ func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 	m.ContactID_ = contact.ID_
 	m.ContactURNID_ = contact.URNID_
 
-	rows, err := b.db.NamedQueryContext(ctx, insertMsgSQL, m)
+	insertSQL := insertMsgSQL
+	if m.Synced_ {
+		insertSQL = insertSyncedMsgSQL
+	}
+
+	rows, err := b.db.NamedQueryContext(ctx, insertSQL, m)
 	if err != nil {
 		return err
 	}
@@ -154,6 +198,11 @@ func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 		return err
 	}
 
+	// synced messages are only kept for history, they are never handled
+	if m.Synced_ {
+		return nil
+	}
+
 	// queue this up to be handled by RapidPro
 	rc := b.redisPool.Get()
 	defer rc.Close()
@@ -168,6 +217,25 @@ func writeMsgToDB(ctx context.Context, b *backend, m *DBMsg) error {
 	return nil
 }
 
+const updateMsgCostEstimateSQL = `
+UPDATE
+	msgs_msg
+SET
+	metadata = COALESCE(metadata::jsonb, '{}'::jsonb) || jsonb_build_object('cost_estimate', $2::jsonb)
+WHERE
+	id = $1
+`
+
+// writeMsgCostEstimate stores the passed in cost estimate in the metadata of the msg with the passed in id
+func writeMsgCostEstimate(ctx context.Context, b *backend, id courier.MsgID, estimate *courier.MsgCostEstimate) error {
+	estimateJSON, err := json.Marshal(estimate)
+	if err != nil {
+		return err
+	}
+	_, err = b.db.ExecContext(ctx, updateMsgCostEstimateSQL, id, string(estimateJSON))
+	return err
+}
+
 const selectMsgSQL = `
 SELECT
 	org_id,
@@ -252,7 +320,7 @@ func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel,
 	}
 
 	var req *http.Request
-	handler := courier.GetHandler(channel.ChannelType())
+	handler := b.getHandler(channel.ChannelType())
 	if handler != nil {
 		builder, isBuilder := handler.(courier.MediaDownloadRequestBuilder)
 		if isBuilder {
@@ -281,12 +349,12 @@ func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel,
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.StringConfigForKey("user_token", "")))
 	}
 
-	resp, err := utils.GetHTTPClient().Do(req)
-	if err != nil {
-		return "", err
+	var checksum *courier.MediaChecksum
+	if checksummer, isChecksummer := handler.(courier.MediaChecksummer); isChecksummer {
+		checksum = checksummer.MediaChecksum(channel, mediaURL)
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+
+	resp, body, err := fetchMedia(ctx, req, checksum)
 	if err != nil {
 		return "", err
 	}
@@ -345,6 +413,7 @@ func downloadMediaToS3(ctx context.Context, b *backend, channel courier.Channel,
 	if err != nil {
 		return "", err
 	}
+	metrics.RecordS3Upload(len(body))
 
 	// return our new media URL, which is prefixed by our content type
 	return fmt.Sprintf("%s:%s", mimeType, s3URL), nil
@@ -377,7 +446,11 @@ func (b *backend) flushMsgFile(filename string, contents []byte) error {
 	err = writeMsgToDB(ctx, b, msg)
 
 	// fail? oh well, we'll try again later
-	return err
+	if err != nil {
+		return err
+	}
+	b.msgHandled(ctx, msg)
+	return nil
 }
 
 //-----------------------------------------------------------------------------
@@ -469,63 +542,6 @@ func clearMsgSeen(rc redis.Conn, msg *DBMsg) {
 	rc.Do("hdel", prevWindowKey, urnFingerprint)
 }
 
-var luaExternalIDSeen = redis.NewScript(3, `-- KEYS: [Window, PrevWindow, ExternalID]
-	-- try to look up in window
-	local found = redis.call("hget", KEYS[1], KEYS[3])
-
-	-- didn't find it, try in our previous window
-	if not found then
-		found = redis.call("hget", KEYS[2], KEYS[3])
-	end
-
-	-- return the fingerprint found
-	return found
-`)
-
-func checkExternalIDSeen(b *backend, msg courier.Msg) courier.MsgUUID {
-	r := b.redisPool.Get()
-	defer r.Close()
-
-	urnFingerprint := fmt.Sprintf("%s:%s|%s", msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())
-
-	now := time.Now().In(time.UTC)
-	prev := now.Add(time.Hour * -24)
-	windowKey := fmt.Sprintf("seen:externalid:%s", now.Format("2006-01-02"))
-	prevWindowKey := fmt.Sprintf("seen:externalid:%s", prev.Format("2006-01-02"))
-
-	// see if there were any messages received in the past 24 hours
-	found, _ := redis.String(luaExternalIDSeen.Do(r, windowKey, prevWindowKey, urnFingerprint))
-
-	// if so, test whether the text it the same
-	if found != "" {
-		prevText := found[37:]
-
-		// if it is the same, return the UUID
-		if prevText == msg.Text() {
-			return courier.NewMsgUUIDFromString(found[:36])
-		}
-	}
-	return courier.NilMsgUUID
-}
-
-var luaWriteExternalIDSeen = redis.NewScript(3, `-- KEYS: [Window, ExternalID, Seen]
-	redis.call("hset", KEYS[1], KEYS[2], KEYS[3])
-	redis.call("expire", KEYS[1], 86400)
-`)
-
-func writeExternalIDSeen(b *backend, msg courier.Msg) {
-	r := b.redisPool.Get()
-	defer r.Close()
-
-	urnFingerprint := fmt.Sprintf("%s:%s|%s", msg.Channel().UUID(), msg.URN().Identity(), msg.ExternalID())
-	uuidText := fmt.Sprintf("%s|%s", msg.UUID().String(), msg.Text())
-
-	now := time.Now().In(time.UTC)
-	windowKey := fmt.Sprintf("seen:externalid:%s", now.Format("2006-01-02"))
-
-	luaWriteExternalIDSeen.Do(r, windowKey, urnFingerprint, uuidText)
-}
-
 //-----------------------------------------------------------------------------
 // Our implementation of Msg interface
 //-----------------------------------------------------------------------------
@@ -547,6 +563,7 @@ type DBMsg struct {
 	ResponseToID_         courier.MsgID          `json:"response_to_id"  db:"response_to_id"`
 	ResponseToExternalID_ string                 `json:"response_to_external_id"`
 	IsResend_             bool                   `json:"is_resend,omitempty"`
+	Synced_               bool                   `json:"synced,omitempty"`
 	Metadata_             json.RawMessage        `json:"metadata"        db:"metadata"`
 
 	ChannelID_    courier.ChannelID `json:"channel_id"      db:"channel_id"`
@@ -565,6 +582,9 @@ type DBMsg struct {
 	QueuedOn_    time.Time  `json:"queued_on"     db:"queued_on"`
 	SentOn_      *time.Time `json:"sent_on"       db:"sent_on"`
 
+	// when set and in the future, the msg is parked on its queue until then rather than sent
+	SendAfter_ *time.Time `json:"send_after,omitempty"`
+
 	// fields used to allow courier to update a session's timeout when a message is sent for efficient timeout behavior
 	SessionID_            SessionID  `json:"session_id,omitempty"`
 	SessionTimeout_       int        `json:"session_timeout,omitempty"`
@@ -596,6 +616,7 @@ func (m *DBMsg) SentOn() *time.Time           { return m.SentOn_ }
 func (m *DBMsg) ResponseToID() courier.MsgID  { return m.ResponseToID_ }
 func (m *DBMsg) ResponseToExternalID() string { return m.ResponseToExternalID_ }
 func (m *DBMsg) IsResend() bool               { return m.IsResend_ }
+func (m *DBMsg) IsSynced() bool               { return m.Synced_ }
 
 func (m *DBMsg) Channel() courier.Channel { return m.channel }
 func (m *DBMsg) SessionStatus() string    { return m.SessionStatus_ }
@@ -644,6 +665,11 @@ func (m *DBMsg) Metadata() json.RawMessage {
 	return m.Metadata_
 }
 
+// Priority returns the priority of the msg, from its metadata if upstream set one there or else its high_priority
+func (m *DBMsg) Priority() courier.MsgPriority {
+	return courier.NewMsgPriority(m.Metadata_, m.HighPriority_)
+}
+
 // fingerprint returns a fingerprint for this msg, suitable for figuring out if this is a dupe
 func (m *DBMsg) urnFingerprint() string {
 	return fmt.Sprintf("%s:%s", m.ChannelUUID_, m.URN_.Identity())
@@ -667,6 +693,9 @@ func (m *DBMsg) WithUUID(uuid courier.MsgUUID) courier.Msg { m.UUID_ = uuid; ret
 // WithMetadata can be used to add metadata to a Msg
 func (m *DBMsg) WithMetadata(metadata json.RawMessage) courier.Msg { m.Metadata_ = metadata; return m }
 
+// WithText can be used to replace the text of a msg, e.g. with its translation
+func (m *DBMsg) WithText(text string) courier.Msg { m.Text_ = text; return m }
+
 // WithAttachment can be used to append to the media urls for a message
 func (m *DBMsg) WithAttachment(url string) courier.Msg {
 	m.Attachments_ = append(m.Attachments_, url)
@@ -0,0 +1,65 @@
+package rapidpro
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// how often we ping our listener connection to make sure it is still alive
+const listenerPingInterval = 90 * time.Second
+
+// OutgoingMsgNotifications returns the channel we signal as outgoing messages are queued, by us or, if we are listening
+// on our configured Postgres channel, by whoever notifies it
+func (b *backend) OutgoingMsgNotifications() <-chan bool {
+	return b.msgNotifications
+}
+
+// startMsgListener listens on our configured Postgres channel, which is notified after outgoing messages are queued,
+// and passes those notifications on to our senders. The payload of notifications is ignored.
+func (b *backend) startMsgListener() error {
+	log := logrus.WithField("comp", "msg listener").WithField("channel", b.config.OutgoingNotifyChannel)
+
+	listener := pq.NewListener(b.config.DB, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.WithError(err).Error("error listening for outgoing msg notifications")
+		}
+	})
+
+	err := listener.Listen(b.config.OutgoingNotifyChannel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	b.waitGroup.Add(1)
+	go func() {
+		defer b.waitGroup.Done()
+		defer listener.Close()
+
+		for {
+			select {
+			case <-b.stopChan:
+				return
+
+			// a nil notification means we reconnected and may have missed some, so we wake our senders either way
+			case <-listener.Notify:
+				b.notifyOutgoingMsg()
+
+			case <-time.After(listenerPingInterval):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// notifyOutgoingMsg signals our senders without blocking, notifications that arrive before they wake are coalesced
+func (b *backend) notifyOutgoingMsg() {
+	select {
+	case b.msgNotifications <- true:
+	default:
+	}
+}
@@ -0,0 +1,183 @@
+package rapidpro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/metadata"
+	"github.com/nyaruka/courier/queue"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// channel config key of the most msgs per second a channel can send, as RapidPro queues them
+const configMaxTPS = "max_tps"
+
+// the msgs per second channels without a max_tps can send
+const defaultMaxTPS = 10
+
+// channel config key of how many turns a channel's queue gets for each turn of a queue with the default weight of 1,
+// so busy channels can't starve the others
+const configQueueWeight = "queue_weight"
+
+// how long we remember the org and weight we set for a queue before setting them again
+const queueOrgWeightTTL = 5 * time.Minute
+
+// setQueueOrgWeight sets the org and weight of the passed in msg queue of the passed in channel, if they've changed
+// since we last set them
+func (b *backend) setQueueOrgWeight(rc redis.Conn, token queue.WorkerToken, channel *DBChannel) {
+	org := strconv.FormatInt(int64(channel.OrgID()), 10)
+	weight := queueWeight(channel)
+	value := fmt.Sprintf("%s:%g", org, weight)
+
+	if last, found := b.queueOrgWeights.Get(string(token)); found && last == value {
+		return
+	}
+
+	if err := queue.SetOrgWeight(rc, msgQueueName, token, org, weight); err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error setting queue org and weight")
+		return
+	}
+	b.queueOrgWeights.Set(string(token), value, cache.DefaultExpiration)
+}
+
+// queueWeight returns the weight of the queue of the passed in channel
+func queueWeight(channel courier.Channel) float64 {
+	var weight float64
+	switch value := channel.ConfigForKey(configQueueWeight, nil).(type) {
+	case float64:
+		weight = value
+	case int:
+		weight = float64(value)
+	case string:
+		weight, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if weight <= 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return 1
+	}
+	return weight
+}
+
+const insertOutgoingMsgSQL = `
+INSERT INTO
+	msgs_msg(org_id, uuid, direction, text, attachments, msg_count, error_count, high_priority, status, visibility,
+	         channel_id, contact_id, contact_urn_id, metadata, created_on, modified_on, next_attempt, queued_on)
+    VALUES($1, $2, 'O', $3, $4, 1, 0, $5, 'Q', 'V', $6, $7, $8, $9, $10, $10, $10, $10)
+RETURNING id
+`
+
+// QueueOutgoingMsg writes a new outgoing msg and queues it for our senders the same way RapidPro queues the msgs it sends
+func (b *backend) QueueOutgoingMsg(ctx context.Context, channel courier.Channel, urn urns.URN, text string, attachments []string, quickReplies []string, highPriority bool, meta json.RawMessage) (courier.Msg, error) {
+	meta, err := withQuickReplies(meta, quickReplies)
+	if err != nil {
+		return nil, err
+	}
+	if err := metadata.Validate(string(channel.ChannelType()), meta); err != nil {
+		return nil, err
+	}
+
+	m := newMsg(MsgOutgoing, channel, urn, utils.CleanString(text))
+	m.Status_ = courier.MsgQueued
+	m.HighPriority_ = highPriority
+	m.Attachments_ = attachments
+	m.Metadata_ = meta
+
+	contact, err := getContact(ctx, b, m.OrgID_, m.channel, m.URN_, "", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting contact for outgoing msg")
+	}
+	m.ContactID_ = contact.ID_
+	m.ContactURNID_ = contact.URNID_
+
+	var dbMetadata *string
+	if len(meta) > 0 {
+		s := string(meta)
+		dbMetadata = &s
+	}
+
+	err = b.db.QueryRowContext(ctx, insertOutgoingMsgSQL, m.OrgID_, m.UUID_, m.Text_, pq.StringArray(m.Attachments_), m.HighPriority_,
+		m.ChannelID_, m.ContactID_, m.ContactURNID_, dbMetadata, m.CreatedOn_.In(time.UTC)).Scan(&m.ID_)
+	if err != nil {
+		return nil, errors.Wrap(err, "error inserting outgoing msg")
+	}
+
+	msgJSON, err := json.Marshal([]interface{}{m})
+	if err != nil {
+		return nil, err
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	tps := channel.IntConfigForKey(configMaxTPS, defaultMaxTPS)
+	err = queue.PushOntoQueue(rc, msgQueueName, channel.UUID().String(), tps, string(msgJSON), queuePriority(m))
+	if err != nil {
+		return nil, errors.Wrap(err, "error queueing outgoing msg")
+	}
+
+	b.notifyOutgoingMsg()
+	return m, nil
+}
+
+// NewSimulatedMsg builds an outgoing msg like QueueOutgoingMsg but without writing or queueing it. Its channel is a copy
+// of the passed in channel with the passed in config overrides.
+func (b *backend) NewSimulatedMsg(channel courier.Channel, config map[string]interface{}, urn urns.URN, text string, attachments []string, quickReplies []string, meta json.RawMessage) (courier.Msg, error) {
+	meta, err := withQuickReplies(meta, quickReplies)
+	if err != nil {
+		return nil, err
+	}
+	if err := metadata.Validate(string(channel.ChannelType()), meta); err != nil {
+		return nil, err
+	}
+
+	simulated := *channel.(*DBChannel)
+	simulated.Config_ = utils.NullMap{Map: make(map[string]interface{}, len(config)), Valid: true}
+	for key, value := range channel.(*DBChannel).Config_.Map {
+		simulated.Config_.Map[key] = value
+	}
+	for key, value := range config {
+		simulated.Config_.Map[key] = value
+	}
+
+	m := newMsg(MsgOutgoing, &simulated, urn, utils.CleanString(text))
+	m.Status_ = courier.MsgQueued
+	m.Attachments_ = attachments
+	m.Metadata_ = meta
+	return m, nil
+}
+
+// withQuickReplies returns the passed in metadata with the passed in quick replies added to it
+func withQuickReplies(meta json.RawMessage, quickReplies []string) (json.RawMessage, error) {
+	if len(quickReplies) == 0 {
+		return meta, nil
+	}
+
+	fields := map[string]interface{}{}
+	if len(meta) > 0 {
+		if err := json.Unmarshal(meta, &fields); err != nil {
+			return nil, errors.Wrap(err, "invalid metadata")
+		}
+	}
+	fields["quick_replies"] = quickReplies
+	return json.Marshal(fields)
+}
+
+// queuePriority returns the priority of the queue the passed in msg waits on to be sent, bulk msgs only being sent once
+// there are no transactional msgs waiting
+func queuePriority(m *DBMsg) queue.Priority {
+	if m.Priority() == courier.MsgPriorityTransactional {
+		return queue.HighPriority
+	}
+	return queue.LowPriority
+}
@@ -0,0 +1,28 @@
+package rapidpro
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/patrickmn/go-cache"
+)
+
+// how long we skip the queue of a channel in another region before checking whether it's still there
+const otherRegionSkipTTL = time.Minute
+
+// skipOtherRegion records that the passed in channel is sent by instances in another region
+func (b *backend) skipOtherRegion(channelUUID courier.ChannelUUID) {
+	b.otherRegions.Set(channelUUID.String(), true, cache.DefaultExpiration)
+}
+
+// otherRegionQueues returns the names of the msg queues of channels sent by instances in other regions
+func (b *backend) otherRegionQueues() []string {
+	items := b.otherRegions.Items()
+	queues := make([]string, 0, len(items))
+	for uuid := range items {
+		queues = append(queues, uuid)
+	}
+	sort.Strings(queues)
+	return queues
+}
@@ -120,5 +120,28 @@ CREATE TABLE flows_flowsession (
     wait_started_on timestamp with time zone
 );
 
+DROP TABLE IF EXISTS channels_pricingrollup CASCADE;
+CREATE TABLE channels_pricingrollup (
+    id serial primary key,
+    channel_id integer references channels_channel(id) on delete cascade,
+    day date NOT NULL,
+    category character varying(32) NOT NULL,
+    pricing_model character varying(32) NOT NULL,
+    is_billable boolean NOT NULL,
+    count integer NOT NULL,
+    UNIQUE (channel_id, day, category, pricing_model, is_billable)
+);
+
+DROP TABLE IF EXISTS channels_auditrecord CASCADE;
+CREATE TABLE channels_auditrecord (
+    id bigserial primary key,
+    action character varying(64) NOT NULL,
+    actor character varying(255) NOT NULL,
+    address character varying(64) NOT NULL,
+    channel_uuid character varying(36) NULL,
+    affected_ids text NOT NULL,
+    created_on timestamp with time zone NOT NULL
+);
+
 GRANT ALL PRIVILEGES ON ALL TABLES IN SCHEMA public TO courier;
 GRANT ALL PRIVILEGES ON ALL SEQUENCES IN SCHEMA public TO courier;
\ No newline at end of file
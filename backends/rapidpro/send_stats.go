@@ -0,0 +1,59 @@
+package rapidpro
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+const (
+	// the hash of send counts for a day, fields are channel_uuid|segment
+	sendStatsKey = "courier:send_stats:%s"
+
+	// how long we keep the send counts of a day, they're expected to have been collected for analytics by then
+	sendStatsExpiration = 60 * 60 * 24 * 35
+)
+
+// WriteSendStat counts a msg of the passed in segment as sent by the passed in channel on the day of the passed in time
+func (b *backend) WriteSendStat(ctx context.Context, channel courier.Channel, segment string, sentOn time.Time) error {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(sendStatsKey, sentOn.UTC().Format("2006-01-02"))
+	rc.Send("HINCRBY", key, fmt.Sprintf("%s|%s", channel.UUID(), segment), 1)
+	rc.Send("EXPIRE", key, sendStatsExpiration)
+	_, err := rc.Do("")
+	return err
+}
+
+// GetSendStats returns the counts of msgs sent on the day of the passed in time, by channel and segment
+func (b *backend) GetSendStats(ctx context.Context, day time.Time) ([]*courier.SendStat, error) {
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	dayStr := day.UTC().Format("2006-01-02")
+	counts, err := redis.IntMap(rc.Do("HGETALL", fmt.Sprintf(sendStatsKey, dayStr)))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*courier.SendStat, 0, len(counts))
+	for field, count := range counts {
+		// segments can contain our separator, but channel UUIDs never do
+		parts := strings.SplitN(field, "|", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		channelUUID, err := courier.NewChannelUUID(parts[0])
+		if err != nil {
+			continue
+		}
+		stats = append(stats, &courier.SendStat{Day: dayStr, ChannelUUID: channelUUID, Segment: parts[1], Count: count})
+	}
+	courier.SortSendStats(stats)
+	return stats, nil
+}
@@ -306,8 +306,10 @@ type DBMsgStatus struct {
 	ExternalID_  string                 `json:"external_id,omitempty"    db:"external_id"`
 	Status_      courier.MsgStatusValue `json:"status"                   db:"status"`
 	ModifiedOn_  time.Time              `json:"modified_on"              db:"modified_on"`
+	ErrorClass_  courier.ErrorClass     `json:"error_class,omitempty"`
 
-	logs []*courier.ChannelLog
+	parts []*courier.MsgStatusPart
+	logs  []*courier.ChannelLog
 }
 
 func (s *DBMsgStatus) EventID() int64 { return int64(s.ID_) }
@@ -354,8 +356,19 @@ func (s *DBMsgStatus) HasUpdatedURN() bool {
 func (s *DBMsgStatus) ExternalID() string      { return s.ExternalID_ }
 func (s *DBMsgStatus) SetExternalID(id string) { s.ExternalID_ = id }
 
+func (s *DBMsgStatus) AddPart(externalID string, status courier.MsgStatusValue) {
+	s.parts = append(s.parts, &courier.MsgStatusPart{ExternalID: externalID, Status: status})
+	if s.ExternalID_ == "" {
+		s.ExternalID_ = externalID
+	}
+}
+func (s *DBMsgStatus) Parts() []*courier.MsgStatusPart { return s.parts }
+
 func (s *DBMsgStatus) Logs() []*courier.ChannelLog    { return s.logs }
 func (s *DBMsgStatus) AddLog(log *courier.ChannelLog) { s.logs = append(s.logs, log) }
 
 func (s *DBMsgStatus) Status() courier.MsgStatusValue          { return s.Status_ }
 func (s *DBMsgStatus) SetStatus(status courier.MsgStatusValue) { s.Status_ = status }
+
+func (s *DBMsgStatus) ErrorClass() courier.ErrorClass              { return s.ErrorClass_ }
+func (s *DBMsgStatus) SetErrorClass(errorClass courier.ErrorClass) { s.ErrorClass_ = errorClass }
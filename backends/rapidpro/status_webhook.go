@@ -0,0 +1,201 @@
+package rapidpro
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// header containing the hex encoded HMAC-SHA256 of the body, keyed by the webhook secret
+const statusWebhookSignatureHeader = "X-Courier-Signature"
+
+var (
+	// how many times we retry posting a status before giving up
+	statusWebhookRetries = 3
+
+	// how long we wait before our first retry, doubled for each retry after that
+	statusWebhookRetryDelay = 5 * time.Second
+
+	// how many statuses we post at once, and how many can wait to be posted before more are dropped
+	statusWebhookWorkers   = 10
+	statusWebhookQueueSize = 1000
+)
+
+// statusWebhookJob is the request posting a status, waiting for a worker
+type statusWebhookJob struct {
+	req *http.Request
+	log *logrus.Entry
+}
+
+// the names statuses are posted with
+var statusWebhookNames = map[courier.MsgStatusValue]string{
+	courier.MsgPending:   "pending",
+	courier.MsgQueued:    "queued",
+	courier.MsgSent:      "sent",
+	courier.MsgWired:     "wired",
+	courier.MsgErrored:   "errored",
+	courier.MsgDelivered: "delivered",
+	courier.MsgFailed:    "failed",
+	courier.MsgRead:      "read",
+}
+
+// statusWebhookPayload is what we post for each status
+//
+//	{
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "msg_id": 12345,
+//	  "external_id": "wamid.HBgLMTIwNjU1NTEyMTI=",
+//	  "status": "failed",
+//	  "reason": "(#131026) Message undeliverable",
+//	  "error_class": "invalid_recipient",
+//	  "modified_on": "2022-03-03T15:11:22.876543Z",
+//	  "modified_on_local": "2022-03-03T12:11:22.876543-03:00",
+//	  "channel_country": "BR"
+//	}
+type statusWebhookPayload struct {
+	ChannelUUID     courier.ChannelUUID `json:"channel_uuid"`
+	MsgID           courier.MsgID       `json:"msg_id,omitempty"`
+	ExternalID      string              `json:"external_id,omitempty"`
+	Status          string              `json:"status"`
+	Reason          string              `json:"reason,omitempty"`
+	ErrorClass      courier.ErrorClass  `json:"error_class,omitempty"`
+	ModifiedOn      time.Time           `json:"modified_on"`
+	ModifiedOnLocal time.Time           `json:"modified_on_local"`
+	ChannelCountry  string              `json:"channel_country,omitempty"`
+}
+
+// statusWebhookConfig returns the url and secret of the status webhook configured for the passed in channel, if any
+func statusWebhookConfig(channel courier.Channel) (string, string) {
+	config, ok := channel.ConfigForKey(courier.ConfigStatusWebhook, nil).(map[string]interface{})
+	if !ok {
+		return "", ""
+	}
+	url, _ := config["url"].(string)
+	secret, _ := config["secret"].(string)
+	return url, secret
+}
+
+// statusReason returns the last error logged for the passed in status, which is why it failed
+func statusReason(status courier.MsgStatus) string {
+	reason := ""
+	for _, log := range status.Logs() {
+		if log.Error != "" {
+			reason = log.Error
+		}
+	}
+	return reason
+}
+
+// newStatusWebhookRequest builds the request posting the passed in status of the passed in channel to the passed in url,
+// signing it if we have a secret
+func newStatusWebhookRequest(channel courier.Channel, url string, secret string, status courier.MsgStatus) (*http.Request, error) {
+	now := time.Now()
+	payload := &statusWebhookPayload{
+		ChannelUUID:     status.ChannelUUID(),
+		MsgID:           status.ID(),
+		ExternalID:      status.ExternalID(),
+		Status:          statusWebhookNames[status.Status()],
+		ModifiedOn:      now.In(time.UTC),
+		ModifiedOnLocal: now.In(courier.ChannelLocation(channel)),
+		ChannelCountry:  channel.Country(),
+	}
+	if status.Status() == courier.MsgErrored || status.Status() == courier.MsgFailed {
+		payload.Reason = statusReason(status)
+		payload.ErrorClass = courier.ClassifyMsgStatus(status)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(statusWebhookSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+	}
+	return req, nil
+}
+
+// startStatusWebhookWorkers starts the workers which post the statuses queued by postStatusWebhook
+func (b *backend) startStatusWebhookWorkers() {
+	for i := 0; i < statusWebhookWorkers; i++ {
+		b.waitGroup.Add(1)
+		go func() {
+			defer b.waitGroup.Done()
+
+			for {
+				select {
+				case <-b.stopChan:
+					return
+				case job := <-b.statusWebhooks:
+					b.sendStatusWebhook(job)
+				}
+			}
+		}()
+	}
+}
+
+// postStatusWebhook queues the passed in status to be posted to the webhook of its channel by our workers, dropping it
+// if too many are already waiting
+func (b *backend) postStatusWebhook(channel courier.Channel, status courier.MsgStatus) {
+	url, secret := statusWebhookConfig(channel)
+	if url == "" {
+		return
+	}
+
+	log := logrus.WithField("channel_uuid", channel.UUID()).WithField("msg_id", status.ID().String()).WithField("url", url)
+
+	req, err := newStatusWebhookRequest(channel, url, secret, status)
+	if err != nil {
+		log.WithError(err).Error("error building status webhook request")
+		return
+	}
+
+	select {
+	case b.statusWebhooks <- &statusWebhookJob{req: req, log: log}:
+	default:
+		log.Error("too many status webhooks waiting to be posted, dropping status")
+	}
+}
+
+// sendStatusWebhook posts the request of the passed in job, retrying with backoff until it is accepted, we run out of
+// retries or we are stopped
+func (b *backend) sendStatusWebhook(job *statusWebhookJob) {
+	delay := statusWebhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		_, err := utils.MakeHTTPRequest(job.req)
+		if err == nil {
+			return
+		}
+
+		if attempt >= statusWebhookRetries {
+			job.log.WithError(err).Error(fmt.Sprintf("error posting status webhook after %d attempts", attempt+1))
+			return
+		}
+
+		select {
+		case <-b.stopChan:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		job.req.Body, _ = job.req.GetBody()
+	}
+}
@@ -0,0 +1,79 @@
+package rapidpro
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	velocityProtectionDrop = "drop"
+	velocityProtectionFlag = "flag"
+
+	// how many incoming msgs a contact can send in a minute, unless the channel configures otherwise
+	defaultVelocityThreshold = 30
+
+	// key of the count of incoming msgs from a URN on a channel during a minute
+	velocityKeyPattern = "velocity:%s:%s:%d"
+)
+
+// countMsgVelocity counts the passed in incoming msg against its URN and returns how many msgs that URN has sent on its
+// channel during the current minute
+func countMsgVelocity(rc redis.Conn, msg *DBMsg, now time.Time) (int, error) {
+	key := fmt.Sprintf(velocityKeyPattern, msg.ChannelUUID_, msg.URN_.Identity(), now.Unix()/60)
+
+	rc.Send("MULTI")
+	rc.Send("INCR", key)
+	rc.Send("EXPIRE", key, 120)
+	values, err := redis.Values(rc.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(values[0], nil)
+}
+
+// checkMsgVelocity counts the passed in incoming msg against its contact, and if they've sent more msgs this minute than
+// its channel allows, flags it with that velocity in its metadata if the channel flags floods. Returns true if the msg
+// should be dropped.
+func checkMsgVelocity(b *backend, msg *DBMsg) bool {
+	channel := msg.Channel()
+	mode := channel.StringConfigForKey(courier.ConfigVelocityProtection, "")
+	if mode == "" {
+		return false
+	}
+
+	rc := b.redisPool.Get()
+	defer rc.Close()
+
+	velocity, err := countMsgVelocity(rc, msg, time.Now())
+	if err != nil {
+		logrus.WithError(err).WithField("msg_uuid", msg.UUID().String()).Error("error counting msg velocity")
+		return false
+	}
+
+	threshold := channel.IntConfigForKey(courier.ConfigVelocityThreshold, defaultVelocityThreshold)
+	if velocity <= threshold {
+		return false
+	}
+
+	log := logrus.WithField("channel_uuid", msg.ChannelUUID_).WithField("msg_uuid", msg.UUID().String()).WithField("velocity", velocity)
+
+	if mode == velocityProtectionDrop {
+		log.Info("dropping incoming msg from contact sending too fast")
+		return true
+	}
+
+	metadata := map[string]interface{}{}
+	if len(msg.Metadata_) > 0 {
+		json.Unmarshal(msg.Metadata_, &metadata)
+	}
+	metadata["velocity"] = velocity
+	msg.Metadata_, _ = json.Marshal(metadata)
+
+	log.Info("flagging incoming msg from contact sending too fast")
+	return false
+}
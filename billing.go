@@ -0,0 +1,30 @@
+package courier
+
+import (
+	"github.com/nyaruka/courier/billing"
+)
+
+// SetBillingRouting sets the routing key suffix and extra fields of the passed in billing msg from the config of the
+// passed in channel, falling back to that of its org. Extra fields of the channel replace those of its org.
+func SetBillingRouting(channel Channel, msg *billing.Message) {
+	suffix, _ := channel.ConfigForKey(ConfigBillingRoutingKey, nil).(string)
+	if suffix == "" {
+		suffix, _ = channel.OrgConfigForKey(ConfigBillingRoutingKey, nil).(string)
+	}
+	msg.RoutingKeySuffix = suffix
+
+	orgFields, _ := channel.OrgConfigForKey(ConfigBillingFields, nil).(map[string]interface{})
+	channelFields, _ := channel.ConfigForKey(ConfigBillingFields, nil).(map[string]interface{})
+	if len(orgFields) == 0 && len(channelFields) == 0 {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(orgFields)+len(channelFields))
+	for k, v := range orgFields {
+		fields[k] = v
+	}
+	for k, v := range channelFields {
+		fields[k] = v
+	}
+	msg.ExtraFields = fields
+}
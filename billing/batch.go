@@ -0,0 +1,355 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/furdarius/rabbitroutine"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we wait for a batch to be published and confirmed before giving up on that attempt
+var batchPublishTimeout = 30 * time.Second
+
+// how many batches can be waiting to be published before msgs sent with SendAsync are dropped, as callers sending and
+// receiving msgs must never wait on billing
+const batchBufferBatches = 10
+
+// how long we wait before trying again to publish batches which failed
+var pendingRetryInterval = 5 * time.Second
+
+// how long we keep trying to publish batches which failed when stopping, before giving up on them
+var stopRetryTimeout = 30 * time.Second
+
+// batchPublisher publishes a batch of msgs to a queue, returning once RabbitMQ has confirmed all of them
+type batchPublisher interface {
+	PublishBatch(ctx context.Context, queue string, msgs []amqp.Publishing) error
+}
+
+// confirmBatchPublisher publishes each batch on a single channel in confirm mode, waiting for the batch's confirms
+// rather than each msg's
+type confirmBatchPublisher struct {
+	pool   *rabbitroutine.Pool
+	queues *queueDeclarer
+}
+
+func (p *confirmBatchPublisher) PublishBatch(ctx context.Context, queue string, msgs []amqp.Publishing) error {
+	if err := p.queues.declare(ctx, queue); err != nil {
+		return err
+	}
+
+	k, err := p.pool.ChannelWithConfirm(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to receive channel for publishing")
+	}
+
+	for _, msg := range msgs {
+		err := k.Channel().PublishWithContext(ctx, "", queue, false, false, msg)
+		if err != nil {
+			k.Close()
+			return errors.Wrap(err, "failed to publish message")
+		}
+	}
+
+	nacks := 0
+	for range msgs {
+		select {
+		case <-ctx.Done():
+			k.Close()
+			return ctx.Err()
+		case amqpErr := <-k.Error():
+			k.Close()
+			return errors.Wrap(amqpErr, "channel closed while waiting for confirms")
+		case confirm := <-k.Confirm():
+			if !confirm.Ack {
+				nacks++
+			}
+		}
+	}
+
+	p.pool.Release(k)
+
+	if nacks > 0 {
+		return fmt.Errorf("%d of %d messages not acknowledged", nacks, len(msgs))
+	}
+	return nil
+}
+
+// BatchingClient is a client which publishes async msgs in batches, which must be stopped to publish those still waiting
+type BatchingClient interface {
+	Client
+	Stop()
+}
+
+// asyncMsg is a msg sent with SendAsync waiting to be published
+type asyncMsg struct {
+	msg  Message
+	post func()
+}
+
+// pendingBatch is a batch of msgs with the same routing key waiting to be published
+type pendingBatch struct {
+	key  string
+	msgs []asyncMsg
+}
+
+// rabbitmqBatchingClient publishes msgs sent with SendAsync in batches, flushing a batch once it's full or its first
+// msg has waited for the linger duration. Batches which fail to publish are kept and retried, in the order they were
+// sent, rather than dropped.
+type rabbitmqBatchingClient struct {
+	*rabbitmqRetryClient
+
+	publisher     batchPublisher
+	batchSize     int
+	linger        time.Duration
+	retryAttempts int
+	retryDelay    time.Duration
+
+	queue   chan asyncMsg
+	pending []*pendingBatch
+	mutex   sync.RWMutex
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewRMQBillingBatchingClient creates a new billing service client using RabbitMQ which publishes async msgs in
+// batches of up to the passed in size, waiting at most the passed in linger for a batch to fill
+func NewRMQBillingBatchingClient(url string, retryAttempts int, retryDelay int, batchSize int, linger time.Duration) (BatchingClient, error) {
+	conn, pool, err := connect(url)
+	if err != nil {
+		return nil, err
+	}
+	queues := &queueDeclarer{pool: pool}
+	c := newBatchingClient(newRetryClient(conn, pool, queues, retryAttempts, retryDelay), &confirmBatchPublisher{pool, queues}, batchSize, linger, retryAttempts, time.Duration(retryDelay)*time.Millisecond)
+	c.Start()
+	return c, nil
+}
+
+func newBatchingClient(client *rabbitmqRetryClient, publisher batchPublisher, batchSize int, linger time.Duration, retryAttempts int, retryDelay time.Duration) *rabbitmqBatchingClient {
+	if retryAttempts < 1 {
+		retryAttempts = 1
+	}
+	return &rabbitmqBatchingClient{
+		rabbitmqRetryClient: client,
+		publisher:           publisher,
+		batchSize:           batchSize,
+		linger:              linger,
+		retryAttempts:       retryAttempts,
+		retryDelay:          retryDelay,
+		queue:               make(chan asyncMsg, batchSize*batchBufferBatches),
+		stop:                make(chan struct{}),
+	}
+}
+
+// Start starts publishing the msgs sent with SendAsync
+func (c *rabbitmqBatchingClient) Start() {
+	c.wg.Add(1)
+	go c.run()
+}
+
+// Stop stops accepting async msgs and waits for those already sent to be published in the order they were sent
+func (c *rabbitmqBatchingClient) Stop() {
+	c.mutex.Lock()
+	if c.stopped {
+		c.mutex.Unlock()
+		return
+	}
+	c.stopped = true
+	close(c.queue)
+	close(c.stop)
+	c.mutex.Unlock()
+
+	c.wg.Wait()
+}
+
+// SendAsync queues the passed in msg to be published in a batch, dropping it if the batches waiting to be published are
+// full, e.g. while RabbitMQ is down, rather than waiting for them
+func (c *rabbitmqBatchingClient) SendAsync(msg Message, pre func(), post func()) {
+	if pre != nil {
+		pre()
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.stopped {
+		logrus.WithField("message_id", msg.MessageID).Error("billing client stopped, dropping msg")
+		return
+	}
+
+	select {
+	case c.queue <- asyncMsg{msg: msg, post: post}:
+	default:
+		logrus.WithField("message_id", msg.MessageID).Error("billing msgs waiting to be published are full, dropping msg")
+		recordDropped(1)
+		if post != nil {
+			runPost(post)
+		}
+	}
+}
+
+func (c *rabbitmqBatchingClient) run() {
+	defer c.wg.Done()
+
+	batch := make([]asyncMsg, 0, c.batchSize)
+	var linger, retry <-chan time.Time
+
+	for {
+		// once too many batches are waiting to be retried, stop taking msgs so that those sent with SendAsync are dropped
+		queue := c.queue
+		if len(c.pending) >= batchBufferBatches {
+			queue = nil
+		}
+		if len(c.pending) > 0 && retry == nil {
+			retry = time.After(pendingRetryInterval)
+		}
+
+		select {
+		case m, ok := <-queue:
+			if !ok {
+				c.drain(batch)
+				return
+			}
+
+			batch = append(batch, m)
+			if len(batch) == 1 {
+				linger = time.After(c.linger)
+			}
+			if len(batch) >= c.batchSize {
+				c.flush(batch)
+				batch = make([]asyncMsg, 0, c.batchSize)
+				linger = nil
+			}
+
+		case <-linger:
+			c.flush(batch)
+			batch = make([]asyncMsg, 0, c.batchSize)
+			linger = nil
+
+		case <-retry:
+			retry = nil
+			c.publishPending()
+
+		case <-c.stop:
+			for m := range c.queue {
+				batch = append(batch, m)
+			}
+			c.drain(batch)
+			return
+		}
+	}
+}
+
+// flush queues the passed in batch to be published, grouped by routing key and behind any batches waiting to be
+// retried, and tries to publish them
+func (c *rabbitmqBatchingClient) flush(batch []asyncMsg) {
+	if len(batch) == 0 {
+		return
+	}
+
+	// group our msgs by routing key, keeping the order they were sent in
+	groups := make(map[string]*pendingBatch)
+	for _, m := range batch {
+		key := m.msg.RoutingKey()
+		group, seen := groups[key]
+		if !seen {
+			group = &pendingBatch{key: key}
+			groups[key] = group
+			c.pending = append(c.pending, group)
+		}
+		group.msgs = append(group.msgs, m)
+	}
+
+	c.publishPending()
+}
+
+// publishPending publishes the batches waiting to be published in order, stopping at the first which fails so that it
+// and those after it are retried later
+func (c *rabbitmqBatchingClient) publishPending() {
+	for len(c.pending) > 0 {
+		if err := c.publish(c.pending[0]); err != nil {
+			return
+		}
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+	}
+}
+
+// drain publishes the passed in batch and every batch still waiting to be retried, giving up on those which still
+// fail after our stop timeout
+func (c *rabbitmqBatchingClient) drain(batch []asyncMsg) {
+	c.flush(batch)
+
+	deadline := time.Now().Add(stopRetryTimeout)
+	for len(c.pending) > 0 && time.Now().Add(pendingRetryInterval).Before(deadline) {
+		time.Sleep(pendingRetryInterval)
+		c.publishPending()
+	}
+
+	for _, p := range c.pending {
+		logrus.WithField("batch_size", len(p.msgs)).WithField("routing_key", p.key).Error("billing client stopped, dropping msg batch which couldn't be published")
+		runPosts(p.msgs)
+	}
+	c.pending = nil
+}
+
+// publish publishes the passed in batch, retrying it as a whole if it fails, and runs the post callbacks of its msgs
+// once it's been published
+func (c *rabbitmqBatchingClient) publish(batch *pendingBatch) error {
+	msgs := make([]amqp.Publishing, len(batch.msgs))
+	for i, m := range batch.msgs {
+		body, _ := json.Marshal(m.msg)
+		msgs[i] = amqp.Publishing{ContentType: "application/json", Body: body}
+	}
+
+	start := time.Now()
+	attempts := 0
+	var err error
+	for attempts < c.retryAttempts {
+		if attempts > 0 {
+			time.Sleep(time.Duration(attempts) * c.retryDelay)
+		}
+		attempts++
+
+		ctx, cancel := context.WithTimeout(context.Background(), batchPublishTimeout)
+		err = c.publisher.PublishBatch(ctx, batch.key, msgs)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	recordPublish(len(msgs), attempts, time.Since(start), err)
+
+	if err != nil {
+		logrus.WithError(err).WithField("batch_size", len(msgs)).WithField("routing_key", batch.key).Error("fail to send msg batch to billing service, will retry")
+		return err
+	}
+
+	runPosts(batch.msgs)
+	return nil
+}
+
+// runPosts runs the post callbacks of the passed in async msgs
+func runPosts(msgs []asyncMsg) {
+	for _, m := range msgs {
+		if m.post != nil {
+			runPost(m.post)
+		}
+	}
+}
+
+// runPost runs the passed in post callback of an async msg, recovering if it panics
+func runPost(post func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Error(fmt.Sprintf("Recovering from: %v", r))
+		}
+	}()
+	post()
+}
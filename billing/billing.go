@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/furdarius/rabbitroutine"
@@ -20,7 +21,11 @@ const QUEUE_NAME = "billing_message"
 //		  "contact_uuid": "69625dca-7922-477c-97c6-9dae8ffff46d",
 //		  "channel_uuid": "9d24bce2-145f-4e65-b9ed-72ef19ee81e0",
 //		  "message_id": "54398",
-//		  "message_date": "2024-03-08T16:08:19-03:00"
+//		  "message_date": "2024-03-08T16:08:19-03:00",
+//		  "message_date_utc": "2024-03-08T19:08:19Z",
+//		  "message_date_local": "2024-03-08T16:08:19-03:00",
+//		  "channel_timezone": "America/Sao_Paulo",
+//		  "channel_country": "BR"
 //	 }
 type Message struct {
 	ContactURN   string   `json:"contact_urn,omitempty"`
@@ -33,6 +38,47 @@ type Message struct {
 	Text         string   `json:"text,omitempty"`
 	Attachments  []string `json:"attachments,omitempty"`
 	QuickReplies []string `json:"quick_replies,omitempty"`
+
+	EstimatedCost *float64 `json:"estimated_cost,omitempty"`
+	CostCurrency  string   `json:"cost_currency,omitempty"`
+
+	MessageDateUTC   string `json:"message_date_utc,omitempty"`
+	MessageDateLocal string `json:"message_date_local,omitempty"`
+	ChannelTimezone  string `json:"channel_timezone,omitempty"`
+	ChannelCountry   string `json:"channel_country,omitempty"`
+
+	// RoutingKeySuffix routes the message to the billing_message.<suffix> queue instead of our own, which we declare
+	// the first time we publish to it
+	RoutingKeySuffix string `json:"-"`
+
+	// ExtraFields are static fields added to the message, such as a project UUID, which can't replace our own fields
+	ExtraFields map[string]interface{} `json:"-"`
+}
+
+// RoutingKey returns the routing key this message is published with
+func (m Message) RoutingKey() string {
+	if m.RoutingKeySuffix == "" {
+		return QUEUE_NAME
+	}
+	return QUEUE_NAME + "." + m.RoutingKeySuffix
+}
+
+// MarshalJSON marshals this message along with its extra fields
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	body, err := json.Marshal(message(m))
+	if err != nil || len(m.ExtraFields) == 0 {
+		return body, err
+	}
+
+	fields := make(map[string]interface{}, len(m.ExtraFields))
+	for k, v := range m.ExtraFields {
+		fields[k] = v
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
 }
 
 // Create a new message
@@ -51,6 +97,14 @@ func NewMessage(contactURN, contactUUID, channelUUID, messageID, messageDate, di
 	}
 }
 
+// SetChannelContext sets the date of the message in UTC and in the time zone of its channel, along with the channel's country
+func (m *Message) SetChannelContext(date time.Time, loc *time.Location, country string) {
+	m.MessageDateUTC = date.UTC().Format(time.RFC3339)
+	m.MessageDateLocal = date.In(loc).Format(time.RFC3339)
+	m.ChannelTimezone = loc.String()
+	m.ChannelCountry = country
+}
+
 // Client represents a client interface for billing service
 type Client interface {
 	Send(msg Message) error
@@ -60,32 +114,95 @@ type Client interface {
 // rabbitmqRetryClient represents struct that implements billing service client interface
 type rabbitmqRetryClient struct {
 	publisher rabbitroutine.Publisher
+	queues    *queueDeclarer
 	conn      *rabbitroutine.Connector
 }
 
 // NewRMQBillingResilientClient creates a new billing service client implementation using RabbitMQ with publish retry and reconnect features
 func NewRMQBillingResilientClient(url string, retryAttempts int, retryDelay int) (Client, error) {
-	cconn, err := amqp.Dial(url)
+	conn, pool, err := connect(url)
 	if err != nil {
 		return nil, err
 	}
-	defer cconn.Close()
+	return newRetryClient(conn, pool, &queueDeclarer{pool: pool}, retryAttempts, retryDelay), nil
+}
 
-	ch, err := cconn.Channel()
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open a channel to rabbitmq")
+// newRetryClient creates a client which publishes each msg on its own, retrying failed publishes
+func newRetryClient(conn *rabbitroutine.Connector, pool *rabbitroutine.Pool, queues *queueDeclarer, retryAttempts int, retryDelay int) *rabbitmqRetryClient {
+	ensurePub := &attemptCountingPublisher{rabbitroutine.NewEnsurePublisher(pool)}
+	pub := rabbitroutine.NewRetryPublisher(
+		ensurePub,
+		rabbitroutine.PublishMaxAttemptsSetup(uint(retryAttempts)),
+		rabbitroutine.PublishDelaySetup(
+			rabbitroutine.LinearDelay(time.Duration(retryDelay)*time.Millisecond),
+		),
+	)
+
+	return &rabbitmqRetryClient{
+		publisher: pub,
+		queues:    queues,
+		conn:      conn,
 	}
-	defer ch.Close()
-	_, err = ch.QueueDeclare(
-		QUEUE_NAME,
+}
+
+// declareQueue declares the passed in queue, which is a no-op if it already exists
+func declareQueue(ch *amqp.Channel, queue string) error {
+	_, err := ch.QueueDeclare(
+		queue,
 		false,
 		false,
 		false,
 		false,
 		nil,
 	)
+	return err
+}
+
+// queueDeclarer declares each queue we publish to the first time we publish to it, as msgs with a routing key suffix
+// are published to billing_message.<suffix> queues which nothing else declares, and RabbitMQ silently drops msgs
+// published to a queue which doesn't exist
+type queueDeclarer struct {
+	pool     *rabbitroutine.Pool
+	declared sync.Map
+}
+
+func (d *queueDeclarer) declare(ctx context.Context, queue string) error {
+	if _, ok := d.declared.Load(queue); ok {
+		return nil
+	}
+
+	k, err := d.pool.ChannelWithConfirm(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to receive channel for declaring queue")
+	}
+	if err := declareQueue(k.Channel(), queue); err != nil {
+		// a failed declare closes the channel so it can't go back in the pool
+		k.Close()
+		return errors.Wrapf(err, "failed to declare queue %s", queue)
+	}
+	d.pool.Release(k)
+
+	d.declared.Store(queue, true)
+	return nil
+}
+
+// connect declares our queue and starts a connection to RabbitMQ which reconnects as needed, returning a pool of
+// channels on it
+func connect(url string) (*rabbitroutine.Connector, *rabbitroutine.Pool, error) {
+	cconn, err := amqp.Dial(url)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to declare a queue for billing publisher")
+		return nil, nil, err
+	}
+	defer cconn.Close()
+
+	ch, err := cconn.Channel()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open a channel to rabbitmq")
+	}
+	defer ch.Close()
+	err = declareQueue(ch, QUEUE_NAME)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to declare a queue for billing publisher")
 	}
 
 	conn := rabbitroutine.NewConnector(rabbitroutine.Config{
@@ -106,16 +223,6 @@ func NewRMQBillingResilientClient(url string, retryAttempts int, retryDelay int)
 		logrus.Errorf("RabbitMQ error received: %v", n.Error)
 	})
 
-	pool := rabbitroutine.NewPool(conn)
-	ensurePub := rabbitroutine.NewEnsurePublisher(pool)
-	pub := rabbitroutine.NewRetryPublisher(
-		ensurePub,
-		rabbitroutine.PublishMaxAttemptsSetup(uint(retryAttempts)),
-		rabbitroutine.PublishDelaySetup(
-			rabbitroutine.LinearDelay(time.Duration(retryDelay)*time.Millisecond),
-		),
-	)
-
 	go func() {
 		err := conn.Dial(context.Background(), url)
 		if err != nil {
@@ -123,24 +230,27 @@ func NewRMQBillingResilientClient(url string, retryAttempts int, retryDelay int)
 		}
 	}()
 
-	return &rabbitmqRetryClient{
-		publisher: pub,
-		conn:      conn,
-	}, nil
+	return conn, rabbitroutine.NewPool(conn), nil
 }
 
 func (c *rabbitmqRetryClient) Send(msg Message) error {
 	msgMarshalled, _ := json.Marshal(msg)
-	ctx := context.Background()
-	err := c.publisher.Publish(
-		ctx,
-		"",
-		QUEUE_NAME,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        msgMarshalled,
-		},
-	)
+	attempts := 0
+	ctx := withPublishAttempts(context.Background(), &attempts)
+	start := time.Now()
+	err := c.queues.declare(ctx, msg.RoutingKey())
+	if err == nil {
+		err = c.publisher.Publish(
+			ctx,
+			"",
+			msg.RoutingKey(),
+			amqp.Publishing{
+				ContentType: "application/json",
+				Body:        msgMarshalled,
+			},
+		)
+	}
+	recordPublish(1, attempts, time.Since(start), err)
 	if err != nil {
 		return errors.Wrap(err, "failed to publish msg to billing")
 	}
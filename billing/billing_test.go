@@ -1,12 +1,16 @@
 package billing
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/furdarius/rabbitroutine"
 	"github.com/gofrs/uuid"
 	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -28,6 +32,36 @@ func TestInitialization(t *testing.T) {
 	defer ch.QueueDelete(QUEUE_NAME, false, false, false)
 }
 
+func TestSetChannelContext(t *testing.T) {
+	msg := NewMessage("whatsapp:5582999999999", "", "64a75af3-7e8d-41a5-8ef8-c273056c4fca", "54398", "", "I", "WAC", "hello", nil, nil)
+
+	loc, _ := time.LoadLocation("America/Sao_Paulo")
+	msg.SetChannelContext(time.Date(2024, 3, 8, 19, 8, 19, 0, time.UTC), loc, "BR")
+
+	assert.Equal(t, "2024-03-08T19:08:19Z", msg.MessageDateUTC)
+	assert.Equal(t, "2024-03-08T16:08:19-03:00", msg.MessageDateLocal)
+	assert.Equal(t, "America/Sao_Paulo", msg.ChannelTimezone)
+	assert.Equal(t, "BR", msg.ChannelCountry)
+}
+
+func TestMessageRouting(t *testing.T) {
+	msg := NewMessage("whatsapp:5582999999999", "", "64a75af3-7e8d-41a5-8ef8-c273056c4fca", "54398", "", "I", "WAC", "hello", nil, nil)
+	assert.Equal(t, "billing_message", msg.RoutingKey())
+
+	body, err := json.Marshal(msg)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"contact_urn": "whatsapp:5582999999999", "channel_uuid": "64a75af3-7e8d-41a5-8ef8-c273056c4fca", "message_id": "54398", "direction": "I", "channel_type": "WAC", "text": "hello"}`, string(body))
+
+	// extra fields are added but can't replace our own
+	msg.RoutingKeySuffix = "insights"
+	msg.ExtraFields = map[string]interface{}{"project_uuid": "ef9a3ee0-3a33-4e87-9f2d-0e7dbbc3ff6e", "cost_center": "CC-12", "text": "replaced"}
+	assert.Equal(t, "billing_message.insights", msg.RoutingKey())
+
+	body, err = json.Marshal(msg)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"contact_urn": "whatsapp:5582999999999", "channel_uuid": "64a75af3-7e8d-41a5-8ef8-c273056c4fca", "message_id": "54398", "direction": "I", "channel_type": "WAC", "text": "hello", "project_uuid": "ef9a3ee0-3a33-4e87-9f2d-0e7dbbc3ff6e", "cost_center": "CC-12"}`, string(body))
+}
+
 func TestBillingResilientClient(t *testing.T) {
 	connURL := "amqp://localhost:5672/"
 	conn, err := amqp.Dial(connURL)
@@ -92,6 +126,36 @@ func TestBillingResilientClient(t *testing.T) {
 	assert.Equal(t, cmsg.MessageID, msg.MessageID)
 }
 
+func TestBillingResilientClientRoutingKeySuffix(t *testing.T) {
+	connURL := "amqp://localhost:5672/"
+	conn, err := amqp.Dial(connURL)
+	assert.NoError(t, err)
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(errors.Wrap(err, "failed to declare a channel for consumer"))
+	}
+	defer ch.Close()
+	defer ch.QueueDelete(QUEUE_NAME, false, false, false)
+	defer ch.QueueDelete(QUEUE_NAME+".insights", false, false, false)
+
+	billingClient, err := NewRMQBillingResilientClient(connURL, 3, 1000)
+	time.Sleep(1 * time.Second)
+	assert.NoError(t, err)
+
+	// the suffixed queue doesn't exist until we publish to it
+	msg := Message{MessageID: "54398", RoutingKeySuffix: "insights"}
+	err = billingClient.Send(msg)
+	assert.NoError(t, err)
+
+	delivery, ok, err := ch.Get(QUEUE_NAME+".insights", true)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	var cmsg Message
+	assert.NoError(t, json.Unmarshal(delivery.Body, &cmsg))
+	assert.Equal(t, "54398", cmsg.MessageID)
+}
+
 func TestBillingResilientClientSendAsync(t *testing.T) {
 	connURL := "amqp://localhost:5672/"
 	conn, err := amqp.Dial(connURL)
@@ -220,3 +284,183 @@ func TestBillingResilientClientSendAsyncWithPanic(t *testing.T) {
 	wg.Wait()
 	assert.Equal(t, cmsg.MessageID, msg.MessageID)
 }
+
+type failingPublisher struct {
+	failures int
+}
+
+func (p *failingPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("connection lost")
+	}
+	return nil
+}
+
+func TestAttemptCountingPublisher(t *testing.T) {
+	inner := &failingPublisher{failures: 2}
+	pub := rabbitroutine.NewRetryPublisher(
+		&attemptCountingPublisher{inner},
+		rabbitroutine.PublishMaxAttemptsSetup(5),
+		rabbitroutine.PublishDelaySetup(rabbitroutine.LinearDelay(time.Millisecond)),
+	)
+
+	attempts := 0
+	err := pub.Publish(withPublishAttempts(context.Background(), &attempts), "", QUEUE_NAME, amqp.Publishing{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// publishing without a counter still works
+	err = pub.Publish(context.Background(), "", QUEUE_NAME, amqp.Publishing{})
+	assert.NoError(t, err)
+}
+
+type recordingBatchPublisher struct {
+	mutex    sync.Mutex
+	batches  [][]string
+	queues   []string
+	failures int
+}
+
+func (p *recordingBatchPublisher) PublishBatch(ctx context.Context, queue string, msgs []amqp.Publishing) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("connection lost")
+	}
+
+	batch := make([]string, len(msgs))
+	for i, m := range msgs {
+		msg := &Message{}
+		json.Unmarshal(m.Body, msg)
+		batch[i] = msg.MessageID
+	}
+	p.batches = append(p.batches, batch)
+	p.queues = append(p.queues, queue)
+	return nil
+}
+
+func (p *recordingBatchPublisher) published() [][]string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.batches
+}
+
+func TestBatchingClient(t *testing.T) {
+	publisher := &recordingBatchPublisher{failures: 1}
+	client := newBatchingClient(nil, publisher, 3, time.Hour, 2, time.Millisecond)
+	client.Start()
+
+	posts := 0
+	for i := 1; i <= 7; i++ {
+		client.SendAsync(Message{MessageID: fmt.Sprint(i)}, nil, func() { posts++ })
+	}
+
+	// full batches are published straight away, the first after a retry
+	assert.Eventually(t, func() bool { return len(publisher.published()) == 2 }, time.Second, time.Millisecond)
+
+	// and the rest when we stop, in the order they were sent
+	client.Stop()
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"4", "5", "6"}, {"7"}}, publisher.published())
+	assert.Equal(t, 7, posts)
+
+	// once stopped we drop msgs rather than blocking
+	client.SendAsync(Message{MessageID: "8"}, nil, nil)
+	client.Stop()
+	assert.Len(t, publisher.published(), 3)
+
+	// batches which don't fill are published after lingering
+	publisher = &recordingBatchPublisher{}
+	client = newBatchingClient(nil, publisher, 100, 10*time.Millisecond, 1, 0)
+	client.Start()
+	defer client.Stop()
+
+	client.SendAsync(Message{MessageID: "1"}, nil, nil)
+	client.SendAsync(Message{MessageID: "2"}, nil, nil)
+	assert.Eventually(t, func() bool { return len(publisher.published()) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"1", "2"}}, publisher.published())
+}
+
+func TestBatchingClientRouting(t *testing.T) {
+	publisher := &recordingBatchPublisher{}
+	client := newBatchingClient(nil, publisher, 4, time.Hour, 1, 0)
+	client.Start()
+
+	client.SendAsync(Message{MessageID: "1"}, nil, nil)
+	client.SendAsync(Message{MessageID: "2", RoutingKeySuffix: "insights"}, nil, nil)
+	client.SendAsync(Message{MessageID: "3"}, nil, nil)
+	client.SendAsync(Message{MessageID: "4", RoutingKeySuffix: "insights"}, nil, nil)
+	client.Stop()
+
+	// each routing key's msgs are published together
+	assert.Equal(t, [][]string{{"1", "3"}, {"2", "4"}}, publisher.published())
+	assert.Equal(t, []string{"billing_message", "billing_message.insights"}, publisher.queues)
+}
+
+func TestBatchingClientRetriesFailedBatches(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		pendingRetryInterval, stopRetryTimeout = interval, timeout
+	}(pendingRetryInterval, stopRetryTimeout)
+	pendingRetryInterval = 10 * time.Millisecond
+	stopRetryTimeout = 50 * time.Millisecond
+
+	// batches which fail are kept and published later, in the order they were sent
+	publisher := &recordingBatchPublisher{failures: 3}
+	client := newBatchingClient(nil, publisher, 2, time.Hour, 1, 0)
+	client.Start()
+
+	for i := 1; i <= 4; i++ {
+		client.SendAsync(Message{MessageID: fmt.Sprint(i)}, nil, nil)
+	}
+	assert.Eventually(t, func() bool { return len(publisher.published()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}}, publisher.published())
+	client.Stop()
+
+	// when stopping we keep trying for a while before giving up on them
+	publisher = &recordingBatchPublisher{failures: 1000}
+	client = newBatchingClient(nil, publisher, 2, time.Hour, 1, 0)
+	client.Start()
+
+	posts := 0
+	client.SendAsync(Message{MessageID: "1"}, nil, func() { posts++ })
+	client.Stop()
+	assert.Len(t, publisher.published(), 0)
+	assert.Equal(t, 1, posts)
+	assert.Less(t, publisher.failures, 999)
+}
+
+func TestBatchingClientDropsWhenFull(t *testing.T) {
+	defer func(interval, timeout time.Duration) {
+		pendingRetryInterval, stopRetryTimeout = interval, timeout
+	}(pendingRetryInterval, stopRetryTimeout)
+	pendingRetryInterval = 10 * time.Millisecond
+	stopRetryTimeout = 50 * time.Millisecond
+
+	// while RabbitMQ is down, msgs beyond what we buffer are dropped rather than blocking those sending them
+	publisher := &recordingBatchPublisher{failures: 1000000}
+	client := newBatchingClient(nil, publisher, 1, time.Hour, 1, 0)
+	client.Start()
+
+	var posts int32
+	sent := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			client.SendAsync(Message{MessageID: fmt.Sprint(i)}, nil, func() { atomic.AddInt32(&posts, 1) })
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "sending blocked on billing")
+	}
+	assert.Greater(t, atomic.LoadInt32(&posts), int32(0))
+
+	// and stopping doesn't wait on them either, giving up on those buffered
+	client.Stop()
+	assert.Len(t, publisher.published(), 0)
+	assert.Equal(t, int32(50), atomic.LoadInt32(&posts))
+}
@@ -0,0 +1,53 @@
+package billing
+
+import (
+	"context"
+	"time"
+
+	"github.com/furdarius/rabbitroutine"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/librato"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// context key of the number of attempts made to publish a msg
+type publishAttemptsKey struct{}
+
+// attemptCountingPublisher counts each attempt the retry publisher wrapping it makes, so that we can report retries
+type attemptCountingPublisher struct {
+	rabbitroutine.Publisher
+}
+
+func (p *attemptCountingPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	if attempts, ok := ctx.Value(publishAttemptsKey{}).(*int); ok {
+		*attempts++
+	}
+	return p.Publisher.Publish(ctx, exchange, key, msg)
+}
+
+// withPublishAttempts returns a context which counts publish attempts in the passed in counter
+func withPublishAttempts(ctx context.Context, attempts *int) context.Context {
+	return context.WithValue(ctx, publishAttemptsKey{}, attempts)
+}
+
+// recordPublish records the outcome, retries and latency of publishing the passed in number of msgs to billing, to both
+// librato and Prometheus
+func recordPublish(count int, attempts int, elapsed time.Duration, err error) {
+	metrics.RecordBillingPublish(count, attempts, elapsed, err != nil)
+
+	if attempts > 1 {
+		librato.Gauge("courier.billing_publish_retry", float64(attempts-1))
+	}
+	if err != nil {
+		librato.Gauge("courier.billing_publish_failure", float64(count))
+	} else {
+		librato.Gauge("courier.billing_publish_success", float64(count))
+	}
+	librato.Gauge("courier.billing_publish_latency", elapsed.Seconds())
+}
+
+// recordDropped records the passed in number of msgs dropped without being published to billing
+func recordDropped(count int) {
+	metrics.RecordBillingDropped(count)
+	librato.Gauge("courier.billing_dropped", float64(count))
+}
@@ -0,0 +1,36 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/nyaruka/courier/billing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetBillingRouting(t *testing.T) {
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "BR", map[string]interface{}{})
+
+	// nothing configured, msgs go to our own queue as they are
+	msg := billing.Message{MessageID: "1"}
+	SetBillingRouting(channel, &msg)
+	assert.Equal(t, "billing_message", msg.RoutingKey())
+	assert.Nil(t, msg.ExtraFields)
+
+	// org config applies to all its channels
+	channel.orgConfig = map[string]interface{}{
+		ConfigBillingRoutingKey: "insights",
+		ConfigBillingFields:     map[string]interface{}{"project_uuid": "ef9a3ee0-3a33-4e87-9f2d-0e7dbbc3ff6e", "cost_center": "CC-12"},
+	}
+	msg = billing.Message{MessageID: "1"}
+	SetBillingRouting(channel, &msg)
+	assert.Equal(t, "billing_message.insights", msg.RoutingKey())
+	assert.Equal(t, map[string]interface{}{"project_uuid": "ef9a3ee0-3a33-4e87-9f2d-0e7dbbc3ff6e", "cost_center": "CC-12"}, msg.ExtraFields)
+
+	// but channels can override it
+	channel.SetConfig(ConfigBillingRoutingKey, "retail")
+	channel.SetConfig(ConfigBillingFields, map[string]interface{}{"cost_center": "CC-34"})
+	msg = billing.Message{MessageID: "1"}
+	SetBillingRouting(channel, &msg)
+	assert.Equal(t, "billing_message.retail", msg.RoutingKey())
+	assert.Equal(t, map[string]interface{}{"project_uuid": "ef9a3ee0-3a33-4e87-9f2d-0e7dbbc3ff6e", "cost_center": "CC-34"}, msg.ExtraFields)
+}
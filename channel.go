@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/nyaruka/null"
 
@@ -20,18 +21,60 @@ const (
 	// ConfigBaseURL is a constant key for channel configs
 	ConfigBaseURL = "base_url"
 
+	// ConfigBillingFields are static fields, such as a project UUID or cost center, added to the billing msgs of a channel
+	ConfigBillingFields = "billing_fields"
+
+	// ConfigBillingRoutingKey is the suffix of the routing key the billing msgs of a channel are published with
+	ConfigBillingRoutingKey = "billing_routing_key"
+
 	// ConfigCallbackDomain is the domain that should be used for this channel when registering callbacks
 	ConfigCallbackDomain = "callback_domain"
 
 	// ConfigContentType is a constant key for channel configs
 	ConfigContentType = "content_type"
 
+	// ConfigExtractAttachmentText is whether the text of the image and document attachments of incoming msgs is extracted
+	// into their metadata
+	ConfigExtractAttachmentText = "extract_attachment_text"
+
+	// ConfigGroupMessages is whether a channel handles messages in groups as group messages
+	ConfigGroupMessages = "group_messages"
+
+	// ConfigLogSamplePercent is the percent of the successful channel logs of a channel which are written, errors are
+	// always written
+	ConfigLogSamplePercent = "log_sample_percent"
+
+	// ConfigLoopProtection is whether incoming msgs which echo a msg we just sent are dropped (drop) or flagged (flag)
+	ConfigLoopProtection = "loop_protection"
+
+	// ConfigLoopWindow is how many seconds after sending a msg an identical incoming msg is considered an echo of it
+	ConfigLoopWindow = "loop_window"
+
+	// ConfigVelocityProtection is whether incoming msgs from contacts sending faster than the velocity threshold are dropped
+	// (drop) or flagged (flag)
+	ConfigVelocityProtection = "velocity_protection"
+
+	// ConfigVelocityThreshold is how many incoming msgs a contact can send in a minute before they're dropped or flagged
+	ConfigVelocityThreshold = "velocity_threshold"
+
+	// ConfigMarkAsRead is whether incoming msgs are marked as read with the vendor of a channel once we've handled them
+	ConfigMarkAsRead = "mark_as_read"
+
 	// ConfigMaxLength is the maximum size of a message in characters
 	ConfigMaxLength = "max_length"
 
+	// ConfigMaxMsgsPerSecond is the most msgs per second a channel sends, across all our instances
+	ConfigMaxMsgsPerSecond = "max_msgs_per_second"
+
+	// ConfigMessagingLimit is how many business-initiated conversations a channel can open in 24 hours, as its tier allows
+	ConfigMessagingLimit = "messaging_limit"
+
 	// ConfigPassword is a constant key for channel configs
 	ConfigPassword = "password"
 
+	// ConfigRegion is the region of the courier instances which send the msgs of a channel
+	ConfigRegion = "region"
+
 	// ConfigSecret is the secret used for signing commands by the channel
 	ConfigSecret = "secret"
 
@@ -47,12 +90,34 @@ const (
 	// ConfigSendURL is a constant key for channel configs
 	ConfigSendURL = "send_url"
 
+	// ConfigStatusWebhook is the endpoint, and optional signing secret, every status of a channel's outgoing messages is posted to
+	ConfigStatusWebhook = "status_webhook"
+
+	// ConfigStatusLogSamplePercent is the percent of the successful status request logs of a channel which are written,
+	// defaulting to its log sample percent
+	ConfigStatusLogSamplePercent = "status_log_sample_percent"
+
+	// ConfigTestMode is whether a channel records sends as wired without calling its vendor and accepts injected messages
+	ConfigTestMode = "test_mode"
+
+	// ConfigTimezone is the IANA time zone of a channel, used to localize the timestamps we report for it
+	ConfigTimezone = "timezone"
+
+	// ConfigTranslationLanguage is the language outgoing messages are translated to before sending
+	ConfigTranslationLanguage = "translation_language"
+
+	// ConfigTranslationFlowLanguage is the language incoming messages are translated to, defaulting to eng
+	ConfigTranslationFlowLanguage = "translation_flow_language"
+
 	// ConfigUsername is a constant key for channel configs
 	ConfigUsername = "username"
 
 	// ConfigUseNational is a constant key for channel configs
 	ConfigUseNational = "use_national"
 	ConfigUserToken   = "wa_user_token"
+
+	// ConfigWebhookToken is the secret token requests to channels which share a receive URL are routed by
+	ConfigWebhookToken = "webhook_token"
 )
 
 // ChannelType is our typing of the two char channel types
@@ -165,9 +230,28 @@ type Channel interface {
 	// CallbackDomain returns the domain that should be used for any callbacks the channel registers
 	CallbackDomain(fallbackDomain string) string
 
+	Config() map[string]interface{}
 	ConfigForKey(key string, defaultValue interface{}) interface{}
 	StringConfigForKey(key string, defaultValue string) string
 	BoolConfigForKey(key string, defaultValue bool) bool
 	IntConfigForKey(key string, defaultValue int) int
 	OrgConfigForKey(key string, defaultValue interface{}) interface{}
 }
+
+// OrgChannel is an interface channels can satisfy to say which org they belong to
+type OrgChannel interface {
+	ChannelOrgID() int64
+}
+
+// ChannelLocation returns the time zone configured for the passed in channel, defaulting to UTC if it has none or it is invalid
+func ChannelLocation(channel Channel) *time.Location {
+	tz := channel.StringConfigForKey(ConfigTimezone, "")
+	if tz == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
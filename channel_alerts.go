@@ -0,0 +1,40 @@
+package courier
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+// how many of the latest alerts of a channel we list
+const maxChannelAlerts = 100
+
+// handleChannelAlerts lists the latest alerts of a channel, e.g. WhatsApp telling us its account was banned or its
+// messaging limit lowered, newest first
+func (s *server) handleChannelAlerts(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	alerts, err := s.backend.GetChannelAlerts(ctx, uuid, maxChannelAlerts)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", uuid).Error("error getting channel alerts")
+		WriteDataResponse(ctx, w, http.StatusInternalServerError, "Error", []interface{}{NewErrorData(err.Error())})
+		return
+	}
+
+	data := make([]interface{}, len(alerts))
+	for i, alert := range alerts {
+		data[i] = NewEventReceiveData(alert)
+	}
+	WriteDataResponse(ctx, w, http.StatusOK, "Channel Alerts", data)
+}
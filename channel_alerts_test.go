@@ -0,0 +1,35 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelAlerts(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	writeAlert := func(extra map[string]interface{}) {
+		require.NoError(t, mb.WriteChannelEvent(context.Background(), mb.NewChannelEvent(channel, ChannelAlert, urns.NilURN).WithExtra(extra)))
+	}
+	writeAlert(map[string]interface{}{"field": "account_update", "event": "DISABLED_UPDATE"})
+	writeAlert(map[string]interface{}{"field": "phone_number_quality_update", "event": "DOWNGRADE"})
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	// alerts are listed newest first
+	status, body := server.request(http.MethodGet, "/c/alerts/8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "", true)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"message":"Channel Alerts"`)
+	assert.Regexp(t, `"event":"DOWNGRADE".*"event":"DISABLED_UPDATE"`, body)
+}
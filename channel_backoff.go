@@ -0,0 +1,137 @@
+package courier
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// the backoff vendor asked the sends of a channel to wait out, expiring when it's over
+const channelBackoffKey = "courier:channel_backoff:%s"
+
+var (
+	// the longest backoff we honor, longer hints are assumed to be bogus and capped
+	maxChannelBackoff = time.Hour
+
+	// the longest a sender waits out a backoff before sending, during longer backoffs msgs are put back until it's over
+	maxChannelBackoffWait = 10 * time.Second
+)
+
+// ParseRetryAfter returns the backoff a vendor asked for in the passed in response headers, if any. Besides the standard
+// Retry-After header, in seconds or as an HTTP date, we understand the millisecond and rate limit reset variants some
+// vendors use instead. When several are present the longest backoff wins.
+func ParseRetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	var backoff time.Duration
+	found := false
+
+	use := func(d time.Duration) {
+		if d < 0 {
+			d = 0
+		}
+		if !found || d > backoff {
+			backoff = d
+		}
+		found = true
+	}
+
+	if value := strings.TrimSpace(header.Get("Retry-After")); value != "" {
+		if secs, err := strconv.ParseFloat(value, 64); err == nil {
+			use(time.Duration(secs * float64(time.Second)))
+		} else if date, err := http.ParseTime(value); err == nil {
+			use(date.Sub(now))
+		}
+	}
+
+	for _, name := range []string{"Retry-After-Ms", "X-Retry-After-Ms"} {
+		if ms, err := strconv.ParseInt(strings.TrimSpace(header.Get(name)), 10, 64); err == nil {
+			use(time.Duration(ms) * time.Millisecond)
+		}
+	}
+
+	// reset headers are either the seconds until the limit resets or the epoch it resets at
+	for _, name := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		if secs, err := strconv.ParseInt(strings.TrimSpace(header.Get(name)), 10, 64); err == nil {
+			if secs > 1000000000 {
+				use(time.Unix(secs, 0).Sub(now))
+			} else {
+				use(time.Duration(secs) * time.Second)
+			}
+		}
+	}
+
+	if found && backoff > maxChannelBackoff {
+		backoff = maxChannelBackoff
+	}
+	return backoff, found
+}
+
+// backoffFromStatus returns the backoff asked for by any throttled response logged for the passed in status
+func backoffFromStatus(status MsgStatus, now time.Time) (time.Duration, bool) {
+	var backoff time.Duration
+	found := false
+
+	for _, log := range status.Logs() {
+		if log.StatusCode != http.StatusTooManyRequests && log.StatusCode != http.StatusServiceUnavailable {
+			continue
+		}
+
+		// our logs have the response as it was dumped, we only need its head to read its headers
+		head := strings.SplitN(log.Response, "\r\n\r\n", 2)[0] + "\r\n\r\n"
+		response, err := http.ReadResponse(bufio.NewReader(strings.NewReader(head)), nil)
+		if err != nil {
+			continue
+		}
+
+		if d, ok := ParseRetryAfter(response.Header, now); ok && (!found || d > backoff) {
+			backoff, found = d, true
+		}
+	}
+	return backoff, found
+}
+
+// GetChannelBackoff returns how much longer the sends of the channel with the passed in UUID should back off for
+func GetChannelBackoff(rp *redis.Pool, uuid ChannelUUID) (time.Duration, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	remaining, err := redis.Int64(rc.Do("PTTL", fmt.Sprintf(channelBackoffKey, uuid)))
+	if err != nil || remaining <= 0 {
+		return 0, err
+	}
+	return time.Duration(remaining) * time.Millisecond, nil
+}
+
+// SetChannelBackoff makes the sends of the channel with the passed in UUID back off for the passed in duration
+func SetChannelBackoff(rp *redis.Pool, uuid ChannelUUID, backoff time.Duration) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	if backoff < time.Millisecond {
+		_, err := rc.Do("DEL", fmt.Sprintf(channelBackoffKey, uuid))
+		return err
+	}
+	_, err := rc.Do("SET", fmt.Sprintf(channelBackoffKey, uuid), "1", "PX", backoff.Milliseconds())
+	return err
+}
+
+// trackBackoff honors any backoff the vendor asked for in the responses of the passed in send, making the next sends
+// of its channel wait for it
+func (w *Sender) trackBackoff(msg Msg, status MsgStatus) {
+	backoff, found := backoffFromStatus(status, time.Now())
+	if !found {
+		return
+	}
+
+	log := logrus.WithField("comp", "sender").WithField("channel_uuid", msg.Channel().UUID())
+	if err := SetChannelBackoff(w.foreman.server.Backend().RedisPool(), msg.Channel().UUID(), backoff); err != nil {
+		log.WithError(err).Error("error setting channel backoff")
+		return
+	}
+	log.WithField("backoff", backoff).Warning("channel throttled by vendor, backing off")
+}
@@ -0,0 +1,104 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 10, 5, 15, 4, 5, 0, time.UTC)
+
+	tcs := []struct {
+		header  map[string]string
+		backoff time.Duration
+		found   bool
+	}{
+		{map[string]string{}, 0, false},
+		{map[string]string{"Retry-After": "120"}, 2 * time.Minute, true},
+		{map[string]string{"Retry-After": "1.5"}, 1500 * time.Millisecond, true},
+		{map[string]string{"Retry-After": "Wed, 05 Oct 2022 15:04:35 GMT"}, 30 * time.Second, true},
+		{map[string]string{"Retry-After": "Wed, 05 Oct 2022 15:00:00 GMT"}, 0, true},
+		{map[string]string{"Retry-After": "soon"}, 0, false},
+		{map[string]string{"X-Retry-After-Ms": "250"}, 250 * time.Millisecond, true},
+		{map[string]string{"X-RateLimit-Reset": "20"}, 20 * time.Second, true},
+		{map[string]string{"X-RateLimit-Reset": "1664982845"}, 10 * time.Minute, true},
+		{map[string]string{"Retry-After": "5", "RateLimit-Reset": "8"}, 8 * time.Second, true},
+		{map[string]string{"Retry-After": "86400"}, time.Hour, true},
+	}
+
+	for _, tc := range tcs {
+		header := http.Header{}
+		for k, v := range tc.header {
+			header.Set(k, v)
+		}
+		backoff, found := ParseRetryAfter(header, now)
+		assert.Equal(t, tc.backoff, backoff, "backoff mismatch for %v", tc.header)
+		assert.Equal(t, tc.found, found, "found mismatch for %v", tc.header)
+	}
+}
+
+func TestChannelBackoff(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+	rp := mb.RedisPool()
+
+	newStatus := func(statusCode int, response string) MsgStatus {
+		status := mb.NewMsgStatusForID(channel, NewMsgID(1), MsgErrored)
+		status.AddLog(NewChannelLog("Message Sent", channel, NewMsgID(1), "POST", "https://example.com/send", statusCode, "", response, 0, nil))
+		return status
+	}
+
+	// only throttled responses with a hint ask us to back off
+	_, found := backoffFromStatus(newStatus(500, "HTTP/1.1 500 Internal Server Error\r\nRetry-After: 30\r\n\r\n{}"), time.Now())
+	assert.False(t, found)
+	_, found = backoffFromStatus(newStatus(429, "HTTP/1.1 429 Too Many Requests\r\n\r\n{}"), time.Now())
+	assert.False(t, found)
+
+	backoff, found := backoffFromStatus(newStatus(429, "HTTP/1.1 429 Too Many Requests\r\nRetry-After: 30\r\nContent-Type: application/json\r\n\r\n{}"), time.Now())
+	assert.True(t, found)
+	assert.Equal(t, 30*time.Second, backoff)
+
+	backoff, found = backoffFromStatus(newStatus(503, "HTTP/1.1 503 Service Unavailable\r\nX-Retry-After-Ms: 500\r\n\r\n"), time.Now())
+	assert.True(t, found)
+	assert.Equal(t, 500*time.Millisecond, backoff)
+
+	// backoffs are stored per channel and expire
+	require.NoError(t, SetChannelBackoff(rp, channel.UUID(), 0))
+	remaining, err := GetChannelBackoff(rp, channel.UUID())
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), remaining)
+
+	require.NoError(t, SetChannelBackoff(rp, channel.UUID(), time.Minute))
+	remaining, err = GetChannelBackoff(rp, channel.UUID())
+	assert.NoError(t, err)
+	assert.True(t, remaining > 59*time.Second && remaining <= time.Minute, "unexpected remaining backoff %s", remaining)
+
+	require.NoError(t, SetChannelBackoff(rp, channel.UUID(), 0))
+}
+
+func TestSenderDefersThrottledMsgs(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+	rp := mb.RedisPool()
+
+	require.NoError(t, SetChannelBackoff(rp, channel.UUID(), time.Minute))
+	defer SetChannelBackoff(rp, channel.UUID(), 0)
+
+	// msgs sent while the channel backs off for longer than we wait are put back until it's over
+	msg, err := mb.QueueOutgoingMsg(context.Background(), channel, "whatsapp:5511999999999", "hi", nil, nil, false, nil)
+	require.NoError(t, err)
+
+	sender := NewSender(NewForeman(NewServer(NewConfig(), mb), 1), 0)
+	sender.sendMessage(msg)
+
+	deferredUntil, deferred := mb.DeferredUntil(msg.ID())
+	assert.True(t, deferred)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deferredUntil, 2*time.Second)
+}
@@ -0,0 +1,140 @@
+package courier
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+)
+
+// the channel types whose Graph API version defaults to our own
+var graphAPIChannelTypes = map[ChannelType]bool{"FBA": true, "IG": true, "WAC": true}
+
+// EffectiveChannelConfig is the config a channel is running with, its own over our defaults, with secrets redacted, e.g.
+//
+//	{
+//	  "uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "channel_type": "WAC",
+//	  "config": {"auth_token": "********", "callback_domain": "courier.example.com", "catalog_id": "1234", "graph_api_version": "v18.0"},
+//	  "sources": {"auth_token": "channel", "callback_domain": "default", "catalog_id": "channel", "graph_api_version": "default"},
+//	  "secrets": {"api_key": false, "auth_token": true, "password": false, "secret": false, "wa_user_token": false, "webhook_token": false}
+//	}
+type EffectiveChannelConfig struct {
+	UUID        ChannelUUID            `json:"uuid"`
+	ChannelType ChannelType            `json:"channel_type"`
+	Config      map[string]interface{} `json:"config"`
+	Sources     map[string]string      `json:"sources"`
+	Secrets     map[string]bool        `json:"secrets"`
+}
+
+// channelConfigDefaults returns the config keys of channels of the passed in type whose defaults come from our config
+func channelConfigDefaults(config *Config, channelType ChannelType) map[string]interface{} {
+	defaults := map[string]interface{}{ConfigCallbackDomain: config.Domain}
+	if graphAPIChannelTypes[channelType] && config.GraphAPIVersion != "" {
+		defaults["graph_api_version"] = config.GraphAPIVersion
+	}
+	return defaults
+}
+
+// secretConfigKeys returns the config keys of the passed in channel of the passed in handler which hold secrets, sorted.
+// Besides those we know of, any key which looks like it holds one is treated as one, e.g. send_authorization.
+func secretConfigKeys(handler ChannelHandler, channel Channel) []string {
+	secret := make(map[string]bool)
+	for _, key := range defaultSecretConfigKeys {
+		secret[key] = true
+	}
+	if specifier, isSpecifier := handler.(ConfigSpecifier); isSpecifier {
+		for _, field := range specifier.ConfigSpec() {
+			if field.Secret {
+				secret[field.Key] = true
+			}
+		}
+	}
+	for key := range channel.Config() {
+		if secretHeaderRegex.MatchString(key) {
+			secret[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(secret))
+	for key := range secret {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NewEffectiveChannelConfig returns the effective config of the passed in channel with our passed in config, the passed
+// in handler for the channel may be nil
+func NewEffectiveChannelConfig(config *Config, handler ChannelHandler, channel Channel) *EffectiveChannelConfig {
+	effective := &EffectiveChannelConfig{
+		UUID:        channel.UUID(),
+		ChannelType: channel.ChannelType(),
+		Config:      make(map[string]interface{}),
+		Sources:     make(map[string]string),
+		Secrets:     make(map[string]bool),
+	}
+
+	for key, value := range channelConfigDefaults(config, channel.ChannelType()) {
+		effective.Config[key] = value
+		effective.Sources[key] = "default"
+	}
+	for key, value := range channel.Config() {
+		effective.Config[key] = redactConfigValue(value)
+		effective.Sources[key] = "channel"
+	}
+
+	for _, key := range secretConfigKeys(handler, channel) {
+		value, present := effective.Config[key]
+		effective.Secrets[key] = present && value != ""
+		if present {
+			effective.Config[key] = redactedSecret
+		}
+	}
+	return effective
+}
+
+// redactConfigValue redacts the values of the secret looking keys of a config value, like the secret and headers of a
+// webhook config
+func redactConfigValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if secretHeaderRegex.MatchString(key) {
+				redacted[key] = redactedSecret
+			} else {
+				redacted[key] = redactConfigValue(item)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactConfigValue(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// handleChannelConfig returns the effective config of a channel, so support can see what settings it's running with
+func (s *server) handleChannelConfig(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, uuid)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	WriteDataResponse(ctx, w, http.StatusOK, "Channel Config", []interface{}{NewEffectiveChannelConfig(s.config, s.GetHandler(channel.ChannelType()), channel)})
+}
@@ -0,0 +1,82 @@
+package courier
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveChannelConfig(t *testing.T) {
+	config := NewConfig()
+	config.Domain = "courier.example.com"
+	config.GraphAPIVersion = "v18.0"
+
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", map[string]interface{}{
+		ConfigAuthToken:       "sesame",
+		ConfigSecret:          "",
+		"catalog_id":          "1234",
+		"webhook":             map[string]interface{}{"url": "https://crm.example.com/hook", "secret": "s3cr3t", "headers": map[string]interface{}{"Authorization": "Token 123", "X-Tenant": "acme"}},
+		"graph_api_version":   "v19.0",
+		"send_authorization":  "Bearer 123",
+		"api_secret":          "xyz",
+		"access_token_secret": "abc",
+	})
+
+	effective := NewEffectiveChannelConfig(config, nil, channel)
+	assert.Equal(t, map[string]interface{}{
+		ConfigAuthToken:       redactedSecret,
+		ConfigSecret:          redactedSecret,
+		ConfigCallbackDomain:  "courier.example.com",
+		"catalog_id":          "1234",
+		"webhook":             map[string]interface{}{"url": "https://crm.example.com/hook", "secret": redactedSecret, "headers": map[string]interface{}{"Authorization": redactedSecret, "X-Tenant": "acme"}},
+		"graph_api_version":   "v19.0",
+		"send_authorization":  redactedSecret,
+		"api_secret":          redactedSecret,
+		"access_token_secret": redactedSecret,
+	}, effective.Config)
+	assert.Equal(t, map[string]string{
+		ConfigAuthToken:       "channel",
+		ConfigSecret:          "channel",
+		ConfigCallbackDomain:  "default",
+		"catalog_id":          "channel",
+		"webhook":             "channel",
+		"graph_api_version":   "channel",
+		"send_authorization":  "channel",
+		"api_secret":          "channel",
+		"access_token_secret": "channel",
+	}, effective.Sources)
+	assert.Equal(t, map[string]bool{ConfigAPIKey: false, ConfigAuthToken: true, ConfigPassword: false, ConfigSecret: false, ConfigUserToken: false, ConfigWebhookToken: false,
+		"send_authorization": true, "api_secret": true, "access_token_secret": true}, effective.Secrets)
+
+	// our own Graph API version is the default for Meta channels only
+	effective = NewEffectiveChannelConfig(config, nil, NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "FBA", "12345", "", nil))
+	assert.Equal(t, map[string]interface{}{ConfigCallbackDomain: "courier.example.com", "graph_api_version": "v18.0"}, effective.Config)
+
+	effective = NewEffectiveChannelConfig(config, nil, NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ad", "TG", "12345", "", nil))
+	assert.Equal(t, map[string]interface{}{ConfigCallbackDomain: "courier.example.com"}, effective.Config)
+}
+
+func TestChannelConfigEndpoint(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "12345", "US", map[string]interface{}{ConfigAuthToken: "bot123"}))
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	get := func(uuid string) (int, string) {
+		return server.request(http.MethodGet, "/admin/channels/"+uuid+"/config", "", true)
+	}
+
+	status, body := get("8eb23e93-5ecb-45ba-b726-3b064e0c56ab")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"auth_token":"********"`)
+	assert.Contains(t, body, `"callback_domain":"localhost"`)
+	assert.NotContains(t, body, "bot123")
+
+	status, _ = get("8eb23e93-5ecb-45ba-b726-3b064e0c56ff")
+	assert.Equal(t, http.StatusBadRequest, status)
+}
@@ -15,6 +15,46 @@ const (
 	Referral        ChannelEventType = "referral"
 	StopContact     ChannelEventType = "stop_contact"
 	WelcomeMessage  ChannelEventType = "welcome_message"
+	MsgModerated    ChannelEventType = "msg_moderated"
+	UnsupportedMsg  ChannelEventType = "unsupported_msg"
+
+	// OrderPayment is raised when the payment of an order sent to a contact is captured or fails
+	OrderPayment ChannelEventType = "order_payment"
+
+	// CallConnect and CallTerminate are raised as calls with a contact start and end
+	CallConnect   ChannelEventType = "call_connect"
+	CallTerminate ChannelEventType = "call_terminate"
+
+	// SessionStart and SessionEnd are raised as a contact opens and closes a chat session, with the session id in extra
+	SessionStart ChannelEventType = "session_start"
+	SessionEnd   ChannelEventType = "session_end"
+
+	// PresenceChange is raised as a contact comes online or goes offline, with online or offline as the status in extra
+	PresenceChange ChannelEventType = "presence_change"
+
+	// MsgReaction is raised when a contact reacts to a msg, with the emoji and the external id of the msg in extra
+	MsgReaction ChannelEventType = "msg_reaction"
+
+	// MsgDeleted is raised when a contact unsends a msg, with the external id of the msg in extra
+	MsgDeleted ChannelEventType = "msg_deleted"
+
+	// MsgFeedback is raised when a contact answers a feedback form, with the screen id and the answered questions in extra
+	MsgFeedback ChannelEventType = "msg_feedback"
+
+	// ButtonCallback is raised when a contact presses an inline button of a msg, with the button's data and the external
+	// id of the msg in extra
+	ButtonCallback ChannelEventType = "button_callback"
+
+	// PossibleDuplicate is raised when a new contact has the same phone number as an existing contact under another scheme
+	PossibleDuplicate ChannelEventType = "possible_dupe"
+
+	// ContactMerged is raised when a contact known by a referral URN replies with their real URN, which is merged into
+	// their contact, with the referral in extra
+	ContactMerged ChannelEventType = "contact_merged"
+
+	// ChannelAlert is raised when a vendor warns about the account of a channel, such as it being banned or its messaging
+	// limit changing, with what changed in extra. It isn't about any contact so has no URN.
+	ChannelAlert ChannelEventType = "channel_alert"
 )
 
 //-----------------------------------------------------------------------------
@@ -0,0 +1,21 @@
+package courier
+
+import "time"
+
+// ChannelHeartbeatStatus is the outcome reported by a channel heartbeat
+type ChannelHeartbeatStatus string
+
+// Possible values for ChannelHeartbeatStatus
+const (
+	ChannelHeartbeatOK    ChannelHeartbeatStatus = "ok"
+	ChannelHeartbeatError ChannelHeartbeatStatus = "error"
+)
+
+// ChannelHealth is the health of a channel as built from its heartbeats
+type ChannelHealth struct {
+	ChannelUUID         ChannelUUID `json:"channel_uuid"`
+	Healthy             bool        `json:"healthy"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	LastSuccessOn       *time.Time  `json:"last_success_on,omitempty"`
+	LastFailureOn       *time.Time  `json:"last_failure_on,omitempty"`
+}
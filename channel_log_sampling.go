@@ -0,0 +1,33 @@
+package courier
+
+import (
+	"math/rand"
+
+	"github.com/nyaruka/courier/metrics"
+)
+
+// the description of the logs of status requests, which channels can sample on their own as they're most of our logs at
+// scale
+const statusLogDescription = "Status Updated"
+
+// picks a number in [0, n) to sample logs with, replaced in tests
+var logSampleIntn = rand.Intn
+
+// SampleChannelLog returns whether the passed in log should be written, given the log sampling config of its channel.
+// Logs of errors are always written, as are all logs of channels which don't sample them.
+func SampleChannelLog(log *ChannelLog) bool {
+	if log.Channel == nil || log.Error != "" || log.StatusCode >= 400 {
+		return true
+	}
+
+	percent := log.Channel.IntConfigForKey(ConfigLogSamplePercent, 100)
+	if log.Description == statusLogDescription {
+		percent = log.Channel.IntConfigForKey(ConfigStatusLogSamplePercent, percent)
+	}
+	if percent >= 100 || logSampleIntn(100) < percent {
+		return true
+	}
+
+	metrics.RecordChannelLogSampledOut(string(log.Channel.ChannelType()))
+	return false
+}
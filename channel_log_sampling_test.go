@@ -0,0 +1,54 @@
+package courier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSampleChannelLog(t *testing.T) {
+	defer func(intn func(int) int) { logSampleIntn = intn }(logSampleIntn)
+
+	// sample as if we rolled the passed in number
+	roll := func(n int) { logSampleIntn = func(int) int { return n } }
+
+	unsampled := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EX", "12345", "US", nil)
+	sampled := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "EX", "12345", "US", map[string]interface{}{
+		ConfigLogSamplePercent:       50,
+		ConfigStatusLogSamplePercent: 1,
+	})
+	statusOnly := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ad", "EX", "12345", "US", map[string]interface{}{
+		ConfigStatusLogSamplePercent: 0,
+	})
+
+	status := func(channel Channel, statusCode int, err error) *ChannelLog {
+		return NewChannelLog(statusLogDescription, channel, NilMsgID, "POST", "https://example.com/status", statusCode, "", "", 0, err)
+	}
+	received := func(channel Channel) *ChannelLog {
+		return NewChannelLog("Message Received", channel, NilMsgID, "POST", "https://example.com/receive", 200, "", "", 0, nil)
+	}
+
+	roll(99)
+	assert.True(t, SampleChannelLog(status(unsampled, 200, nil)))
+	assert.True(t, SampleChannelLog(received(unsampled)))
+	assert.False(t, SampleChannelLog(status(sampled, 200, nil)))
+	assert.False(t, SampleChannelLog(received(sampled)))
+	assert.False(t, SampleChannelLog(status(statusOnly, 200, nil)))
+	assert.True(t, SampleChannelLog(received(statusOnly)))
+
+	// errors are always written
+	assert.True(t, SampleChannelLog(status(sampled, 500, nil)))
+	assert.True(t, SampleChannelLog(status(sampled, 200, errors.New("boom"))))
+	assert.True(t, SampleChannelLog(status(statusOnly, 400, nil)))
+
+	// and successful logs are when they're in their percent
+	roll(0)
+	assert.True(t, SampleChannelLog(status(sampled, 200, nil)))
+	assert.True(t, SampleChannelLog(received(sampled)))
+	assert.False(t, SampleChannelLog(status(statusOnly, 200, nil)))
+
+	roll(49)
+	assert.False(t, SampleChannelLog(status(sampled, 200, nil)))
+	assert.True(t, SampleChannelLog(received(sampled)))
+}
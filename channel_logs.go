@@ -0,0 +1,160 @@
+package courier
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+// the prefix of log bodies which are stored gzip compressed and base64 encoded
+const compressedLogBodyPrefix = "gzip:"
+
+// the most logs we return at once for a channel
+const maxChannelLogsLimit = 500
+
+// StoredChannelLog is a channel log as read back from our backend, e.g.
+//
+//	{
+//	  "msg_id": 12345,
+//	  "description": "Message Sent",
+//	  "is_error": false,
+//	  "method": "POST",
+//	  "url": "https://graph.facebook.com/v12.0/12345/messages",
+//	  "request": "POST /v12.0/12345/messages HTTP/1.1\r\n...",
+//	  "response": "HTTP/1.1 200 OK\r\n...",
+//	  "response_status": 200,
+//	  "created_on": "2022-10-05T15:04:05.123Z",
+//	  "request_time": 312
+//	}
+type StoredChannelLog struct {
+	MsgID          MsgID     `json:"msg_id,omitempty"`
+	Description    string    `json:"description"`
+	IsError        bool      `json:"is_error"`
+	Method         string    `json:"method"`
+	URL            string    `json:"url"`
+	Request        string    `json:"request"`
+	Response       string    `json:"response"`
+	ResponseStatus int       `json:"response_status"`
+	CreatedOn      time.Time `json:"created_on"`
+	RequestTime    int       `json:"request_time"`
+}
+
+// CompressLogBody returns the passed in log body as it should be stored. Bodies longer than maxSize bytes are truncated,
+// with a note of how much was cut, and bodies longer than compressSize bytes are gzip compressed. Either size can be 0
+// to disable that step.
+func CompressLogBody(body string, compressSize int, maxSize int) string {
+	if maxSize > 0 && len(body) > maxSize {
+		cut := maxSize
+		for cut > 0 && !utf8.RuneStart(body[cut]) {
+			cut--
+		}
+		body = fmt.Sprintf("%s\n\n[truncated %d bytes]", body[:cut], len(body)-cut)
+	}
+
+	if compressSize <= 0 || len(body) <= compressSize {
+		return body
+	}
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(body))
+	w.Close()
+
+	// we only keep the compressed version if it actually saves space
+	compressed := compressedLogBodyPrefix + base64.StdEncoding.EncodeToString(b.Bytes())
+	if len(compressed) >= len(body) {
+		return body
+	}
+	return compressed
+}
+
+// DecompressLogBody returns the original of a log body as stored by CompressLogBody
+func DecompressLogBody(stored string) (string, error) {
+	if !strings.HasPrefix(stored, compressedLogBodyPrefix) {
+		return stored, nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, compressedLogBodyPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed log body: %s", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed log body: %s", err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("invalid compressed log body: %s", err)
+	}
+	return string(body), nil
+}
+
+// handleChannelLogs returns the most recent logs of a channel, optionally only those of the msg passed in as msg_id,
+// with their bodies decompressed. Log bodies can carry credentials so these always require our status credentials.
+func (s *server) handleChannelLogs(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	channelUUID, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	msgID := NilMsgID
+	if m := query.Get("msg_id"); m != "" {
+		id, err := strconv.ParseInt(m, 10, 64)
+		if err != nil {
+			WriteError(ctx, w, r, fmt.Errorf("invalid msg_id: %s", m))
+			return
+		}
+		msgID = NewMsgID(id)
+	}
+
+	limit := 50
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > maxChannelLogsLimit {
+			WriteError(ctx, w, r, fmt.Errorf("invalid limit, must be between 1 and %d: %s", maxChannelLogsLimit, l))
+			return
+		}
+	}
+
+	logs, err := s.backend.GetChannelLogs(ctx, channel, msgID, limit)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error getting channel logs")
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	data := make([]interface{}, len(logs))
+	for i, log := range logs {
+		if log.Request, err = DecompressLogBody(log.Request); err != nil {
+			log.Request = err.Error()
+		}
+		if log.Response, err = DecompressLogBody(log.Response); err != nil {
+			log.Response = err.Error()
+		}
+		data[i] = log
+	}
+	WriteDataResponse(ctx, w, http.StatusOK, "Channel Logs", data)
+}
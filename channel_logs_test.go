@@ -0,0 +1,77 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressLogBody(t *testing.T) {
+	// small bodies are left alone
+	assert.Equal(t, "POST /send HTTP/1.1\r\n\r\nhi", CompressLogBody("POST /send HTTP/1.1\r\n\r\nhi", 100, 1000))
+
+	// big bodies are compressed
+	big := "POST /send HTTP/1.1\r\n\r\n" + strings.Repeat(`{"text": "hello"}`, 100)
+	stored := CompressLogBody(big, 100, 10000)
+	assert.True(t, strings.HasPrefix(stored, "gzip:"))
+	assert.Less(t, len(stored), len(big))
+
+	body, err := DecompressLogBody(stored)
+	assert.NoError(t, err)
+	assert.Equal(t, big, body)
+
+	// huge bodies are also truncated, without splitting characters
+	stored = CompressLogBody(strings.Repeat("é", 10), 0, 5)
+	assert.Equal(t, "éé\n\n[truncated 16 bytes]", stored)
+
+	body, err = DecompressLogBody(CompressLogBody(big, 100, 1000))
+	assert.NoError(t, err)
+	assert.Equal(t, big[:1000]+"\n\n[truncated 723 bytes]", body)
+
+	// bodies which don't compress well are stored as they are
+	assert.Equal(t, "abcdefghijklmnopqrstuvwxyz", CompressLogBody("abcdefghijklmnopqrstuvwxyz", 10, 0))
+
+	_, err = DecompressLogBody("gzip:notbase64!")
+	assert.EqualError(t, err, "invalid compressed log body: illegal base64 data at input byte 9")
+}
+
+func TestChannelLogs(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	big := "POST /send HTTP/1.1\r\n\r\n" + strings.Repeat(`{"text": "hello"}`, 100)
+	log1 := NewChannelLog("Message Sent", channel, NewMsgID(1), "POST", "https://example.com/send", 200, CompressLogBody(big, 100, 10000), "HTTP/1.1 200 OK\r\n\r\n{}", 0, nil)
+	log2 := NewChannelLog("Message Sent", channel, NewMsgID(2), "POST", "https://example.com/send", 200, "POST /send HTTP/1.1\r\n\r\n{}", "HTTP/1.1 200 OK\r\n\r\n{}", 0, nil)
+	mb.WriteChannelLogs(context.Background(), []*ChannelLog{log1, log2})
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	get := func(query string) (int, string) {
+		return server.request(http.MethodGet, "/c/logs/8eb23e93-5ecb-45ba-b726-3b064e0c56ab?"+query, "", true)
+	}
+
+	// compressed bodies are returned decompressed
+	status, body := get("msg_id=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"message":"Channel Logs"`)
+	assert.Contains(t, body, `"msg_id":1`)
+	assert.Contains(t, body, strings.Repeat(`{\"text\": \"hello\"}`, 100))
+	assert.NotContains(t, body, "gzip:")
+	assert.NotContains(t, body, `"msg_id":2`)
+
+	_, body = get("limit=1")
+	assert.Contains(t, body, `"msg_id":2`)
+	assert.NotContains(t, body, `"msg_id":1`)
+
+	status, body = get("limit=1000")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid limit, must be between 1 and 500: 1000")
+}
@@ -0,0 +1,332 @@
+package courier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// the pause of a channel, if its sends are paused
+	channelPauseKey = "courier:channel_pause:%s"
+
+	// the pause of the bulk msgs of all channels, if they're paused
+	bulkPauseKey = "courier:bulk_pause"
+
+	// the count of consecutive sends of a channel which failed authentication
+	authFailuresKey = "courier:auth_failures:%s"
+)
+
+// ChannelPause is why and since when the sends of a channel, or only its bulk msgs, are paused, e.g.
+//
+//	{
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "reason": "auth_failures",
+//	  "failures": 20,
+//	  "paused_on": "2022-10-05T15:04:05.123Z"
+//	}
+type ChannelPause struct {
+	ChannelUUID ChannelUUID `json:"channel_uuid,omitempty"`
+	Reason      string      `json:"reason"`
+	Failures    int         `json:"failures,omitempty"`
+	BulkOnly    bool        `json:"bulk_only,omitempty"`
+	PausedOn    time.Time   `json:"paused_on"`
+}
+
+// Applies returns whether the pause applies to the passed in msg, pauses of only bulk msgs letting transactional ones through
+func (p *ChannelPause) Applies(msg Msg) bool {
+	return !p.BulkOnly || msg.Priority() == MsgPriorityBulk
+}
+
+// reasons sends of a channel can be paused for
+const (
+	PauseReasonManual       = "manual"
+	PauseReasonAuthFailures = "auth_failures"
+)
+
+// channelAlert is what we post to our alert webhook when a channel needs attention
+type channelAlert struct {
+	Type        string      `json:"type"`
+	ChannelType ChannelType `json:"channel_type"`
+	*ChannelPause
+}
+
+// GetChannelPause returns the pause of the channel with the passed in UUID, or nil if its sends aren't paused
+func GetChannelPause(rp *redis.Pool, uuid ChannelUUID) (*ChannelPause, error) {
+	return getPause(rp, fmt.Sprintf(channelPauseKey, uuid))
+}
+
+// GetBulkPause returns the pause of the bulk msgs of all channels, or nil if they aren't paused
+func GetBulkPause(rp *redis.Pool) (*ChannelPause, error) {
+	return getPause(rp, bulkPauseKey)
+}
+
+// GetMsgPause returns the pause which applies to the passed in msg, that of its channel or for bulk msgs that of all
+// channels, or nil if it can be sent
+func GetMsgPause(rp *redis.Pool, msg Msg) (*ChannelPause, error) {
+	pause, err := GetChannelPause(rp, msg.Channel().UUID())
+	if err != nil {
+		return nil, err
+	}
+	if pause != nil && pause.Applies(msg) {
+		return pause, nil
+	}
+	if msg.Priority() != MsgPriorityBulk {
+		return nil, nil
+	}
+	return GetBulkPause(rp)
+}
+
+// getPause returns the pause stored at the passed in key, or nil if there isn't one
+func getPause(rp *redis.Pool, key string) (*ChannelPause, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	value, err := redis.Bytes(rc.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pause := &ChannelPause{}
+	if err := json.Unmarshal(value, pause); err != nil {
+		return nil, err
+	}
+	return pause, nil
+}
+
+// PauseChannel pauses the sends of a channel until it's resumed
+func PauseChannel(rp *redis.Pool, pause *ChannelPause) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	value, err := json.Marshal(pause)
+	if err != nil {
+		return err
+	}
+	_, err = rc.Do("SET", fmt.Sprintf(channelPauseKey, pause.ChannelUUID), value)
+	return err
+}
+
+// PauseBulk pauses the sends of the bulk msgs of all channels until they're resumed, e.g. during an incident
+func PauseBulk(rp *redis.Pool, pause *ChannelPause) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	pause.BulkOnly = true
+	value, err := json.Marshal(pause)
+	if err != nil {
+		return err
+	}
+	_, err = rc.Do("SET", bulkPauseKey, value)
+	return err
+}
+
+// ResumeBulk resumes the sends of the bulk msgs of all channels
+func ResumeBulk(rp *redis.Pool) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("DEL", bulkPauseKey)
+	return err
+}
+
+// ResumeChannel resumes the sends of the channel with the passed in UUID, forgetting any auth failures
+func ResumeChannel(rp *redis.Pool, uuid ChannelUUID) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err := rc.Do("DEL", fmt.Sprintf(channelPauseKey, uuid), fmt.Sprintf(authFailuresKey, uuid))
+	return err
+}
+
+// IsAuthFailure returns whether the passed in status is of a send rejected because of the channel's credentials, which
+// is only the case when its handler says so or the vendor rejected its last failed request with a 401. Vendors also
+// answer 403 when refusing a single msg or recipient, which doesn't mean the channel can't send.
+func IsAuthFailure(status MsgStatus) bool {
+	if status.Status() != MsgErrored && status.Status() != MsgFailed {
+		return false
+	}
+	if status.ErrorClass() != NilErrorClass {
+		return status.ErrorClass() == ErrorClassAuth
+	}
+
+	log := lastFailedRequest(status)
+	return log != nil && log.StatusCode == http.StatusUnauthorized
+}
+
+// countAuthFailure counts a send of the passed in channel failing authentication, or resets the count if the send
+// didn't, returning the number of consecutive failures within the window
+func countAuthFailure(rc redis.Conn, channel Channel, failed bool, window int) (int, error) {
+	key := fmt.Sprintf(authFailuresKey, channel.UUID())
+	if !failed {
+		_, err := rc.Do("DEL", key)
+		return 0, err
+	}
+
+	// our window starts at the first failure, so the count expires unless failures are sustained
+	count, err := redis.Int(rc.Do("INCR", key))
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		_, err = rc.Do("EXPIRE", key, window)
+	}
+	return count, err
+}
+
+// trackAuthFailures counts whether the passed in status of a send failed authentication, pausing the sends of its
+// channel once it has failed as many times in a row as our config allows
+func (w *Sender) trackAuthFailures(msg Msg, status MsgStatus) {
+	server := w.foreman.server
+	config := server.Config()
+	if config.AuthFailureThreshold <= 0 {
+		return
+	}
+
+	log := logrus.WithField("comp", "sender").WithField("channel_uuid", msg.Channel().UUID())
+	rp := server.Backend().RedisPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	failures, err := countAuthFailure(rc, msg.Channel(), IsAuthFailure(status), config.AuthFailureWindow)
+	if err != nil {
+		log.WithError(err).Error("error counting auth failures")
+		return
+	}
+	if failures < config.AuthFailureThreshold {
+		return
+	}
+
+	pause := &ChannelPause{ChannelUUID: msg.Channel().UUID(), Reason: PauseReasonAuthFailures, Failures: failures, PausedOn: time.Now().UTC()}
+	if err := PauseChannel(rp, pause); err != nil {
+		log.WithError(err).Error("error pausing channel")
+		return
+	}
+	log.WithField("failures", failures).Error("paused channel sends after sustained auth failures")
+
+	if config.AlertWebhookURL != "" {
+		go PostChannelAlert(config.AlertWebhookURL, pause.ChannelUUID, &channelAlert{Type: "channel_paused", ChannelType: msg.Channel().ChannelType(), ChannelPause: pause})
+	}
+}
+
+// PostChannelAlert posts the passed in alert about the channel with the passed in UUID to the passed in URL, failures
+// are logged and returned for callers which retry them
+func PostChannelAlert(url string, uuid ChannelUUID, alert interface{}) error {
+	body, _ := json.Marshal(alert)
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", uuid).Error("error posting channel alert")
+	}
+	return err
+}
+
+// checkPauseAuth checks the credentials of a request to our pause endpoints, which like our other admin endpoints
+// always require them to pause or resume sends
+func (s *server) checkPauseAuth(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return s.checkStatusAuth(w, r)
+	}
+	return s.checkAdminAuth(w, r)
+}
+
+// handleChannelPause reports whether the sends of a channel are paused, pausing them on POST and resuming them on DELETE
+func (s *server) handleChannelPause(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPauseAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, uuid)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	rp := s.backend.RedisPool()
+
+	switch r.Method {
+	case http.MethodPost:
+		bulkOnly := r.URL.Query().Get("bulk_only") == "true"
+		err = PauseChannel(rp, &ChannelPause{ChannelUUID: uuid, Reason: PauseReasonManual, BulkOnly: bulkOnly, PausedOn: time.Now().UTC()})
+	case http.MethodDelete:
+		err = ResumeChannel(rp, uuid)
+	}
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		action := map[string]string{http.MethodPost: "channel_pause", http.MethodDelete: "channel_resume"}[r.Method]
+		if err := WriteAuditRecord(ctx, s.backend, NewAuditRecord(s.config, r, action, channel, nil)); err != nil {
+			logrus.WithError(err).WithField("channel_uuid", uuid).Error("error writing audit record")
+		}
+	}
+
+	pause, err := GetChannelPause(rp, uuid)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	data := []interface{}{}
+	if pause != nil {
+		data = append(data, pause)
+	}
+	WriteDataResponse(ctx, w, http.StatusOK, "Channel Pause", data)
+}
+
+// handleBulkPause reports whether the bulk msgs of all channels are paused, pausing them on POST and resuming them on DELETE
+func (s *server) handleBulkPause(w http.ResponseWriter, r *http.Request) {
+	if !s.checkPauseAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	rp := s.backend.RedisPool()
+
+	var err error
+	switch r.Method {
+	case http.MethodPost:
+		err = PauseBulk(rp, &ChannelPause{Reason: PauseReasonManual, PausedOn: time.Now().UTC()})
+	case http.MethodDelete:
+		err = ResumeBulk(rp)
+	}
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	if r.Method != http.MethodGet {
+		action := map[string]string{http.MethodPost: "bulk_pause", http.MethodDelete: "bulk_resume"}[r.Method]
+		if err := WriteAuditRecord(ctx, s.backend, NewAuditRecord(s.config, r, action, nil, nil)); err != nil {
+			logrus.WithError(err).Error("error writing audit record")
+		}
+	}
+
+	pause, err := GetBulkPause(rp)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	data := []interface{}{}
+	if pause != nil {
+		data = append(data, pause)
+	}
+	WriteDataResponse(ctx, w, http.StatusOK, "Bulk Pause", data)
+}
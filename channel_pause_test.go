@@ -0,0 +1,242 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsAuthFailure(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+
+	newStatus := func(value MsgStatusValue, statusCode int) MsgStatus {
+		status := mb.NewMsgStatusForID(channel, NewMsgID(1), value)
+		status.AddLog(NewChannelLog("Message Sent", channel, NewMsgID(1), "POST", "https://example.com/send", statusCode, "", "", 0, nil))
+		return status
+	}
+
+	assert.True(t, IsAuthFailure(newStatus(MsgErrored, 401)))
+	assert.True(t, IsAuthFailure(newStatus(MsgFailed, 401)))
+	assert.False(t, IsAuthFailure(newStatus(MsgFailed, 403)))
+	assert.False(t, IsAuthFailure(newStatus(MsgErrored, 500)))
+	assert.False(t, IsAuthFailure(newStatus(MsgWired, 401)))
+
+	// the class set by the handler wins
+	status := newStatus(MsgFailed, 400)
+	status.SetErrorClass(ErrorClassAuth)
+	assert.True(t, IsAuthFailure(status))
+
+	status = newStatus(MsgFailed, 401)
+	status.SetErrorClass(ErrorClassContentRejected)
+	assert.False(t, IsAuthFailure(status))
+}
+
+func TestChannelPause(t *testing.T) {
+	alerts := make(chan []byte, 1)
+	alertServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alerts <- body
+	}))
+	defer alertServer.Close()
+
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+	config.AuthFailureThreshold = 3
+	config.AlertWebhookURL = alertServer.URL
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	// the running server sends what's queued on its backend, so we queue the msg we send ourselves on another
+	sendBackend := NewMockBackend()
+	sendBackend.AddChannel(channel)
+
+	rp := mb.RedisPool()
+	require.NoError(t, ResumeChannel(rp, channel.UUID()))
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	sender := NewSender(NewForeman(server.Server, 1), 0)
+	msg := mb.NewOutgoingMsg(channel, NewMsgID(1), urns.URN("whatsapp:5511999999999"), "hi", false, nil, "", 0, "", "")
+	send := func(statusCode int) {
+		value := MsgWired
+		if statusCode >= 400 {
+			value = MsgErrored
+		}
+		status := mb.NewMsgStatusForID(channel, msg.ID(), value)
+		status.AddLog(NewChannelLog("Message Sent", channel, msg.ID(), "POST", "https://example.com/send", statusCode, "", "", 0, nil))
+
+		// classified as our sender does before tracking
+		if errorClass := ClassifyMsgStatus(status); errorClass != NilErrorClass {
+			status.SetErrorClass(errorClass)
+		}
+		sender.trackAuthFailures(msg, status)
+	}
+
+	// failures which aren't sustained, or aren't of our credentials, don't pause our channel
+	send(401)
+	send(401)
+	send(200)
+	send(401)
+	send(401)
+	send(403)
+	send(401)
+	send(500)
+	pause, err := GetChannelPause(rp, channel.UUID())
+	assert.NoError(t, err)
+	assert.Nil(t, pause)
+
+	// nor do vendors refusing msgs or recipients, however many times
+	for i := 0; i < 5; i++ {
+		send(403)
+	}
+	pause, err = GetChannelPause(rp, channel.UUID())
+	assert.NoError(t, err)
+	assert.Nil(t, pause)
+
+	// but enough in a row do
+	send(401)
+	send(401)
+	send(401)
+	pause, err = GetChannelPause(rp, channel.UUID())
+	assert.NoError(t, err)
+	require.NotNil(t, pause)
+	assert.Equal(t, PauseReasonAuthFailures, pause.Reason)
+	assert.Equal(t, 3, pause.Failures)
+
+	// and our alert webhook is told
+	select {
+	case body := <-alerts:
+		alert := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(body, &alert))
+		assert.Equal(t, "channel_paused", alert["type"])
+		assert.Equal(t, "WAC", alert["channel_type"])
+		assert.Equal(t, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", alert["channel_uuid"])
+		assert.Equal(t, "auth_failures", alert["reason"])
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no alert posted")
+	}
+
+	request := func(method string) (int, string) {
+		return server.request(method, "/c/pause/8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "", true)
+	}
+
+	status, body := request(http.MethodGet)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"reason":"auth_failures"`)
+
+	// msgs of our paused channel wait on their queue rather than failing
+	queued, err := sendBackend.QueueOutgoingMsg(context.Background(), channel, urns.URN("whatsapp:5511999999999"), "hi", nil, nil, true, nil)
+	require.NoError(t, err)
+
+	NewSender(NewForeman(NewServer(config, sendBackend), 1), 0).sendMessage(queued)
+	deferredUntil, deferred := sendBackend.DeferredUntil(queued.ID())
+	assert.True(t, deferred)
+	assert.WithinDuration(t, time.Now().Add(pauseRetryDelay), deferredUntil, time.Second)
+
+	// resuming our channel clears its pause
+	_, body = request(http.MethodDelete)
+	assert.Contains(t, body, `"data":[]`)
+	pause, _ = GetChannelPause(rp, channel.UUID())
+	assert.Nil(t, pause)
+
+	// and channels can be paused by hand
+	_, body = request(http.MethodPost)
+	assert.Contains(t, body, `"reason":"manual"`)
+
+	records, _ := mb.GetAuditRecords(context.Background(), 2)
+	assert.Equal(t, "channel_pause", records[0].Action)
+	assert.Equal(t, "channel_resume", records[1].Action)
+
+	require.NoError(t, ResumeChannel(rp, channel.UUID()))
+}
+
+func TestBulkPause(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	// the running server sends what's queued on its backend, so we queue the msg we send ourselves on another
+	sendBackend := NewMockBackend()
+	sendBackend.AddChannel(channel)
+
+	rp := mb.RedisPool()
+	require.NoError(t, ResumeChannel(rp, channel.UUID()))
+	require.NoError(t, ResumeBulk(rp))
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	newMsg := func(highPriority bool, meta json.RawMessage) Msg {
+		msg, err := mb.QueueOutgoingMsg(context.Background(), channel, urns.URN("whatsapp:5511999999999"), "hi", nil, nil, highPriority, meta)
+		require.NoError(t, err)
+		return msg
+	}
+	bulk := newMsg(false, nil)
+	transactional := newMsg(true, nil)
+
+	msgPause := func(msg Msg) *ChannelPause {
+		pause, err := GetMsgPause(rp, msg)
+		require.NoError(t, err)
+		return pause
+	}
+
+	assert.Nil(t, msgPause(bulk))
+	assert.Nil(t, msgPause(transactional))
+
+	// pausing only the bulk msgs of a channel lets its transactional msgs through
+	require.NoError(t, PauseChannel(rp, &ChannelPause{ChannelUUID: channel.UUID(), Reason: PauseReasonManual, BulkOnly: true, PausedOn: time.Now()}))
+	assert.NotNil(t, msgPause(bulk))
+	assert.Nil(t, msgPause(transactional))
+	require.NoError(t, ResumeChannel(rp, channel.UUID()))
+
+	request := func(method string) (int, string) {
+		return server.request(method, "/c/pause/bulk", "", true)
+	}
+
+	status, body := request(http.MethodGet)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"data":[]`)
+
+	// as does pausing the bulk msgs of all channels
+	_, body = request(http.MethodPost)
+	assert.Contains(t, body, `"reason":"manual"`)
+	assert.Contains(t, body, `"bulk_only":true`)
+
+	pause := msgPause(bulk)
+	require.NotNil(t, pause)
+	assert.True(t, pause.BulkOnly)
+	assert.Nil(t, msgPause(transactional))
+
+	// and bulk msgs wait on their queue while paused, rather than failing
+	queued, err := sendBackend.QueueOutgoingMsg(context.Background(), channel, urns.URN("whatsapp:5511999999999"), "hi", nil, nil, false, nil)
+	require.NoError(t, err)
+
+	sender := NewSender(NewForeman(NewServer(config, sendBackend), 1), 0)
+	sender.sendMessage(queued)
+	deferredUntil, deferred := sendBackend.DeferredUntil(queued.ID())
+	assert.True(t, deferred)
+	assert.WithinDuration(t, time.Now().Add(pauseRetryDelay), deferredUntil, time.Second)
+
+	_, body = request(http.MethodDelete)
+	assert.Contains(t, body, `"data":[]`)
+	assert.Nil(t, msgPause(bulk))
+
+	records, _ := mb.GetAuditRecords(context.Background(), 2)
+	assert.Equal(t, "bulk_resume", records[0].Action)
+	assert.Equal(t, "bulk_pause", records[1].Action)
+}
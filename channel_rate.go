@@ -0,0 +1,82 @@
+package courier
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// the token bucket limiting the sends of a channel, shared by all our instances
+const channelRateKey = "courier:channel_rate:%s"
+
+// the longest a sender waits for its turn to send on a rate limited channel, msgs with longer waits are put back until
+// their turn
+var maxChannelRateWait = 500 * time.Millisecond
+
+// our token bucket, refilled at the channel's rate up to a second's worth of sends (or one send for slower channels).
+// Takes are reservations, so the bucket can go negative and each sender waits for the token it reserved.
+var luaTakeChannelToken = redis.NewScript(1, `-- KEYS: [BucketKey] ARGV: [Rate, EpochMS, MaxWaitMS]
+	local rate = tonumber(ARGV[1])
+	local now = tonumber(ARGV[2])
+	local maxWait = tonumber(ARGV[3])
+	local capacity = math.max(1, rate)
+
+	local state = redis.call("hmget", KEYS[1], "tokens", "ts")
+	local tokens = tonumber(state[1]) or capacity
+	local ts = tonumber(state[2]) or now
+
+	-- refill for the time since our last take, clocks of instances can disagree a little so never go backwards
+	if now > ts then
+		tokens = math.min(capacity, tokens + (now - ts) * rate / 1000)
+		ts = now
+	end
+
+	tokens = tokens - 1
+	local wait = 0
+	if tokens < 0 then
+		wait = math.ceil(-tokens * 1000 / rate)
+	end
+
+	-- too long a wait, leave the bucket as it was
+	if wait > maxWait then
+		return {0, wait}
+	end
+
+	redis.call("hmset", KEYS[1], "tokens", tokens, "ts", ts)
+	redis.call("pexpire", KEYS[1], math.ceil((capacity - tokens) * 1000 / rate) + 1000)
+	return {1, wait}
+`)
+
+// ChannelMaxMsgsPerSecond returns the most msgs per second the passed in channel can send, or 0 if it isn't limited
+func ChannelMaxMsgsPerSecond(channel Channel) float64 {
+	var rate float64
+	switch value := channel.ConfigForKey(ConfigMaxMsgsPerSecond, nil).(type) {
+	case float64:
+		rate = value
+	case int:
+		rate = float64(value)
+	case string:
+		rate, _ = strconv.ParseFloat(value, 64)
+	}
+
+	if rate <= 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0
+	}
+	return rate
+}
+
+// TakeChannelToken takes a token from the bucket of the channel with the passed in UUID, which is refilled at the passed
+// in rate. It returns how long to wait before sending, and false without taking a token if that's longer than maxWait.
+func TakeChannelToken(rp *redis.Pool, uuid ChannelUUID, rate float64, now time.Time, maxWait time.Duration) (time.Duration, bool, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	result, err := redis.Int64s(luaTakeChannelToken.Do(rc, fmt.Sprintf(channelRateKey, uuid), rate, now.UnixNano()/int64(time.Millisecond), maxWait.Milliseconds()))
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(result[1]) * time.Millisecond, result[0] == 1, nil
+}
@@ -0,0 +1,103 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelMaxMsgsPerSecond(t *testing.T) {
+	tcs := []struct {
+		config interface{}
+		rate   float64
+	}{
+		{nil, 0},
+		{5, 5},
+		{float64(2.5), 2.5},
+		{"0.5", 0.5},
+		{"lots", 0},
+		{-3, 0},
+	}
+
+	for _, tc := range tcs {
+		config := map[string]interface{}{}
+		if tc.config != nil {
+			config[ConfigMaxMsgsPerSecond] = tc.config
+		}
+		channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", config)
+		assert.Equal(t, tc.rate, ChannelMaxMsgsPerSecond(channel), "rate mismatch for %v", tc.config)
+	}
+}
+
+func TestTakeChannelToken(t *testing.T) {
+	mb := NewMockBackend()
+	rp := mb.RedisPool()
+	uuid := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil).UUID()
+
+	rc := rp.Get()
+	_, err := rc.Do("DEL", fmt.Sprintf(channelRateKey, uuid))
+	rc.Close()
+	require.NoError(t, err)
+
+	now := time.Date(2022, 10, 5, 15, 4, 5, 0, time.UTC)
+	take := func(rate float64, at time.Time) (time.Duration, bool) {
+		wait, taken, err := TakeChannelToken(rp, uuid, rate, at, time.Second)
+		require.NoError(t, err)
+		return wait, taken
+	}
+
+	// a second's worth of sends go straight away, the sends after them wait for their turn
+	for _, expected := range []time.Duration{0, 0, 500 * time.Millisecond, time.Second} {
+		wait, taken := take(2, now)
+		assert.True(t, taken)
+		assert.Equal(t, expected, wait)
+	}
+
+	// until they'd wait too long, which doesn't use up a turn
+	wait, taken := take(2, now)
+	assert.False(t, taken)
+	assert.Equal(t, 1500*time.Millisecond, wait)
+
+	// the bucket refills as time passes
+	wait, taken = take(2, now.Add(time.Second))
+	assert.True(t, taken)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	wait, taken = take(2, now.Add(5*time.Second))
+	assert.True(t, taken)
+	assert.Equal(t, time.Duration(0), wait)
+
+	// channels slower than one msg per second can still send one right away
+	wait, taken = take(0.5, now.Add(10*time.Second))
+	assert.True(t, taken)
+	assert.Equal(t, time.Duration(0), wait)
+	_, taken = take(0.5, now.Add(10*time.Second))
+	assert.False(t, taken)
+}
+
+func TestSenderDefersRateLimitedMsgs(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", map[string]interface{}{ConfigMaxMsgsPerSecond: 0.1})
+	mb.AddChannel(channel)
+
+	// use up the one send the channel can make straight away
+	_, taken, err := TakeChannelToken(mb.RedisPool(), channel.UUID(), 0.1, time.Now(), 0)
+	require.NoError(t, err)
+	require.True(t, taken)
+
+	// msgs whose turn is further off than we wait are put back until it comes, rather than errored
+	msg, err := mb.QueueOutgoingMsg(context.Background(), channel, "whatsapp:5511999999999", "hi", nil, nil, false, nil)
+	require.NoError(t, err)
+
+	sender := NewSender(NewForeman(NewServer(NewConfig(), mb), 1), 0)
+	sender.sendMessage(msg)
+
+	deferredUntil, deferred := mb.DeferredUntil(msg.ID())
+	assert.True(t, deferred)
+	assert.WithinDuration(t, time.Now().Add(10*time.Second), deferredUntil, 2*time.Second)
+	assert.Len(t, mb.msgStatuses, 0)
+}
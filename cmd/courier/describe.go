@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nyaruka/courier"
+)
+
+// the command which prints descriptions of our handlers as JSON for the channel claim UI and exits, i.e. courier handlers describe
+const handlersCommand = "handlers"
+const describeSubcommand = "describe"
+
+// describeHandlers prints descriptions of all our registered handlers as JSON, returning the exit code of the command
+func describeHandlers(out io.Writer) int {
+	descriptions, err := courier.DescribeHandlers(courier.RegisteredHandlers())
+	if err != nil {
+		fmt.Fprintf(out, "error describing handlers: %s\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(descriptions); err != nil {
+		fmt.Fprintf(out, "error writing handler descriptions: %s\n", err)
+		return 1
+	}
+	return 0
+}
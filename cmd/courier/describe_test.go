@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDescribeHandlers(t *testing.T) {
+	out := &bytes.Buffer{}
+	require.Equal(t, 0, describeHandlers(out))
+
+	descriptions := []struct {
+		ChannelType string `json:"channel_type"`
+		Routes      []struct {
+			Method string `json:"method"`
+			Path   string `json:"path"`
+		} `json:"routes"`
+		Config []struct {
+			Key      string `json:"key"`
+			Required bool   `json:"required"`
+		} `json:"config"`
+		Features []string `json:"features"`
+	}{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &descriptions))
+
+	byType := make(map[string]int)
+	for i, d := range descriptions {
+		byType[d.ChannelType] = i
+	}
+
+	tg := descriptions[byType["TG"]]
+	assert.Equal(t, "POST", tg.Routes[0].Method)
+	assert.Equal(t, "/c/tg/{uuid}/receive", tg.Routes[0].Path)
+	assert.Equal(t, "auth_token", tg.Config[0].Key)
+	assert.True(t, tg.Config[0].Required)
+
+	wac := descriptions[byType["WAC"]]
+	assert.Contains(t, wac.Features, "mark_read")
+	assert.Contains(t, wac.Features, "account_events")
+	assert.Greater(t, len(wac.Routes), 2)
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
-	"github.com/evalphobia/logrus_sentry"
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/api"
 	"github.com/sirupsen/logrus"
 
 	// load channel handler packages
 	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/courier/extraction"
+	"github.com/nyaruka/courier/feedback"
 	_ "github.com/nyaruka/courier/handlers/africastalking"
 	_ "github.com/nyaruka/courier/handlers/arabiacell"
 	_ "github.com/nyaruka/courier/handlers/blackmyna"
@@ -46,6 +50,7 @@ import (
 	_ "github.com/nyaruka/courier/handlers/macrokiosk"
 	_ "github.com/nyaruka/courier/handlers/mblox"
 	_ "github.com/nyaruka/courier/handlers/messangi"
+	_ "github.com/nyaruka/courier/handlers/meta"
 	_ "github.com/nyaruka/courier/handlers/mtarget"
 	_ "github.com/nyaruka/courier/handlers/nexmo"
 	_ "github.com/nyaruka/courier/handlers/novo"
@@ -72,6 +77,11 @@ import (
 	_ "github.com/nyaruka/courier/handlers/yo"
 	_ "github.com/nyaruka/courier/handlers/zenvia"
 	_ "github.com/nyaruka/courier/handlers/zenviaold"
+	"github.com/nyaruka/courier/moderation"
+	"github.com/nyaruka/courier/tasks"
+	"github.com/nyaruka/courier/templates"
+	"github.com/nyaruka/courier/translation"
+	"github.com/nyaruka/courier/webhooks"
 
 	// load available backends
 	_ "github.com/nyaruka/courier/backends/rapidpro"
@@ -80,8 +90,34 @@ import (
 var version = "Dev"
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == handlersCommand && os.Args[2] == describeSubcommand {
+		os.Exit(describeHandlers(os.Stdout))
+	}
+
+	// the command to validate our config is the first argument, ahead of any flags the config loader parses
+	validateOnly := len(os.Args) > 1 && os.Args[1] == validateConfigCommand
+	if validateOnly {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	// the command to backfill media is followed by the number of days to scan, ahead of any flags
+	backfillOnly := len(os.Args) > 2 && os.Args[1] == mediaCommand && os.Args[2] == backfillSubcommand
+	backfillDays := 0
+	if backfillOnly {
+		days, args, err := parseBackfillArgs(os.Args[3:])
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		backfillDays = days
+		os.Args = append(os.Args[:1], args...)
+	}
+
 	config := courier.LoadConfig("courier.toml")
 
+	if validateOnly {
+		os.Exit(validateConfig(config, os.Stdout))
+	}
+
 	// if we have a custom version, use it
 	if version != "Dev" {
 		config.Version = version
@@ -95,13 +131,18 @@ func main() {
 	}
 	logrus.SetLevel(level)
 
+	// mask sensitive values, this needs to happen before our Sentry hook sees them
+	if config.LogMask {
+		hook, err := courier.NewLogMaskHook(config.LogMaskPattern)
+		if err != nil {
+			logrus.Fatalf("Error creating log mask: %s", err)
+		}
+		logrus.StandardLogger().Hooks.Add(hook)
+	}
+
 	// if we have a DSN entry, try to initialize it
 	if config.SentryDSN != "" {
-		hook, err := logrus_sentry.NewSentryHook(config.SentryDSN, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel})
-		hook.Timeout = 0
-		hook.StacktraceConfiguration.Enable = true
-		hook.StacktraceConfiguration.Skip = 4
-		hook.StacktraceConfiguration.Context = 5
+		hook, err := courier.NewSentryHook(config)
 		if err != nil {
 			logrus.Fatalf("Invalid sentry DSN: '%s': %s", config.SentryDSN, err)
 		}
@@ -114,13 +155,57 @@ func main() {
 		logrus.Fatalf("Error creating backend: %s", err)
 	}
 
+	if backfillOnly {
+		if err := backend.Start(); err != nil {
+			logrus.Fatalf("Error starting backend: %s", err)
+		}
+		code := backfillMedia(backend, backfillDays, time.Now(), os.Stdout)
+		backend.Stop()
+		os.Exit(code)
+	}
+
 	server := courier.NewServer(config, backend)
+	server.SetConfigLoader(func() (*courier.Config, error) { return courier.ReadConfig("courier.toml") })
+
+	// our senders check msgs with the moderator as soon as they start, so it must be set before we do
+	moderator, err := moderation.New(config.ModerationURL, config.ModerationPattern)
+	if err != nil {
+		logrus.Fatalf("Error creating moderator: %s", err)
+	}
+	server.SetModerator(moderator)
+
+	// as are msgs translated for their contacts, so the translator is set before starting too
+	server.SetTranslator(translation.New(config.TranslationURL))
+	server.SetExtractor(extraction.New(config.ExtractionURL))
+
+	// webhooks forwarded to channels are retried from Redis until delivered, handlers relay them once we start
+	webhookRelay := webhooks.NewRelay(backend.RedisPool())
+	server.SetWebhookRelay(webhookRelay)
+
+	// follow-up work handlers queue while sending is run in the background, so our task queue is set before we start
+	taskQueue := tasks.NewQueue(backend.RedisPool())
+	server.SetTaskQueue(taskQueue)
+
 	err = server.Start()
 	if err != nil {
 		logrus.Fatalf("Error starting server: %s", err)
 	}
 
-	if config.RabbitmqURL != "" {
+	webhookRelay.Start()
+
+	// tasks are only run once the server has registered the funcs which run them
+	taskQueue.Start()
+
+	var batchingBilling billing.BatchingClient
+	if config.RabbitmqURL != "" && config.RabbitmqBatchSize > 0 {
+		batchingBilling, err = billing.NewRMQBillingBatchingClient(
+			config.RabbitmqURL, config.RabbitmqRetryPubAttempts, config.RabbitmqRetryPubDelay,
+			config.RabbitmqBatchSize, time.Duration(config.RabbitmqBatchLinger)*time.Millisecond)
+		if err != nil {
+			logrus.Fatalf("Error creating billing RabbitMQ client: %v", err)
+		}
+		server.SetBilling(batchingBilling)
+	} else if config.RabbitmqURL != "" {
 		billingClient, err := billing.NewRMQBillingResilientClient(
 			config.RabbitmqURL, config.RabbitmqRetryPubAttempts, config.RabbitmqRetryPubDelay)
 		if err != nil {
@@ -131,9 +216,72 @@ func main() {
 		logrus.Error(errors.New("rabbitmq url is not configured"))
 	}
 
+	// feedback contacts give is published for CSAT dashboards
+	if config.RabbitmqURL != "" {
+		feedbackClient, err := feedback.NewRMQFeedbackClient(config.RabbitmqURL, config.RabbitmqRetryPubAttempts, config.RabbitmqRetryPubDelay)
+		if err != nil {
+			logrus.Fatalf("Error creating feedback RabbitMQ client: %v", err)
+		}
+		server.SetFeedback(feedbackClient)
+	}
+
+	// keep the approved WhatsApp templates our sends are validated against in sync
+	var templatesConsumer *templates.Consumer
+	if config.RabbitmqURL != "" && config.RabbitmqTemplatesQueue != "" {
+		templatesConsumer = templates.NewRMQConsumer(config.RabbitmqURL, config.RabbitmqTemplatesQueue, backend.RedisPool())
+		templatesConsumer.Start()
+	}
+
+	// internal services can also submit msgs and follow their statuses over gRPC
+	var grpcServer *api.Server
+	if config.GRPCAddress != "" {
+		grpcServer = api.NewServer(backend, config.Redis, config.GRPCToken)
+		if err := grpcServer.Start(config.GRPCAddress); err != nil {
+			logrus.Fatalf("Error starting gRPC API: %s", err)
+		}
+	}
+
 	ch := make(chan os.Signal)
-	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-	logrus.WithField("comp", "main").WithField("signal", <-ch).Info("stopping")
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGHUP)
+
+	// SIGHUP or POST /admin/config/reload reload the settings which can change without restarting
+	// SIGUSR1 or POST /c/drain start draining, after which we keep serving for our grace period before stopping
+	drainChan := server.DrainChan()
+	var graceOver <-chan time.Time
+	for stopping := false; !stopping; {
+		select {
+		case sig := <-ch:
+			if sig == syscall.SIGHUP {
+				if _, err := server.ReloadConfig(context.Background(), "SIGHUP"); err != nil {
+					logrus.WithError(err).Error("error reloading config")
+				}
+			} else if sig == syscall.SIGUSR1 {
+				server.Drain()
+			} else {
+				logrus.WithField("comp", "main").WithField("signal", sig).Info("stopping")
+				stopping = true
+			}
+		case <-drainChan:
+			drainChan = nil
+			graceOver = time.After(time.Duration(config.DrainGracePeriod) * time.Second)
+		case <-graceOver:
+			logrus.WithField("comp", "main").WithField("grace_period", config.DrainGracePeriod).Info("drain grace period over, stopping")
+			stopping = true
+		}
+	}
 
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
 	server.Stop()
+	webhookRelay.Stop()
+	taskQueue.Stop()
+	if templatesConsumer != nil {
+		templatesConsumer.Stop()
+	}
+
+	// publish any billing msgs still waiting for their batch
+	if batchingBilling != nil {
+		batchingBilling.Stop()
+	}
 }
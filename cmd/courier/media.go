@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// the command which re-resolves the expired media of recent incoming msgs and exits, i.e. courier media backfill [days]
+const mediaCommand = "media"
+const backfillSubcommand = "backfill"
+
+// how many days of msgs we backfill the media of by default, WhatsApp only keeps media for 30 days
+const defaultBackfillDays = 7
+
+// parseBackfillArgs returns the number of days to backfill from the arguments following the backfill command, along
+// with the rest of the arguments for the config loader
+func parseBackfillArgs(args []string) (int, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return defaultBackfillDays, args, nil
+	}
+
+	days, err := strconv.Atoi(args[0])
+	if err != nil || days <= 0 {
+		return 0, nil, fmt.Errorf("invalid number of days: %s", args[0])
+	}
+	return days, args[1:], nil
+}
+
+// backfillMedia re-resolves the media of the incoming msgs of the last number of days and prints the counts of those
+// resolved and failed, returning the exit code of the command
+func backfillMedia(backend courier.Backend, days int, now time.Time, out io.Writer) int {
+	since := now.Add(-time.Duration(days) * 24 * time.Hour)
+	fmt.Fprintf(out, "backfilling media of msgs received since %s\n", since.Format(time.RFC3339))
+
+	result, err := backend.BackfillMedia(context.Background(), since)
+	if result != nil {
+		fmt.Fprintln(out, result)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "error backfilling media: %s\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseBackfillArgs(t *testing.T) {
+	days, args, err := parseBackfillArgs([]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, days)
+	assert.Equal(t, []string{}, args)
+
+	days, args, err = parseBackfillArgs([]string{"14", "-db", "postgres://localhost/temba"})
+	assert.NoError(t, err)
+	assert.Equal(t, 14, days)
+	assert.Equal(t, []string{"-db", "postgres://localhost/temba"}, args)
+
+	days, args, err = parseBackfillArgs([]string{"-db", "postgres://localhost/temba"})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, days)
+	assert.Equal(t, []string{"-db", "postgres://localhost/temba"}, args)
+
+	_, _, err = parseBackfillArgs([]string{"week"})
+	assert.EqualError(t, err, "invalid number of days: week")
+
+	_, _, err = parseBackfillArgs([]string{"0"})
+	assert.EqualError(t, err, "invalid number of days: 0")
+}
+
+func TestBackfillMedia(t *testing.T) {
+	mb := courier.NewMockBackend()
+	mb.SetMediaBackfillResult(&courier.MediaBackfillResult{Msgs: 12, Resolved: 9, Failed: 3})
+
+	now := time.Date(2023, 7, 20, 12, 0, 0, 0, time.UTC)
+	out := &bytes.Buffer{}
+	assert.Equal(t, 0, backfillMedia(mb, 3, now, out))
+	assert.Equal(t, time.Date(2023, 7, 17, 12, 0, 0, 0, time.UTC), mb.LastMediaBackfillSince())
+	assert.Equal(t, "backfilling media of msgs received since 2023-07-17T12:00:00Z\nscanned 12 msg(s), resolved 9 attachment(s), failed 3 attachment(s)\n", out.String())
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// malformedURNTestCase is an identity which handlers are likely to be fed by misbehaving vendors, and how handlers
+// creating URNs of a scheme from it should respond
+type malformedURNTestCase struct {
+	identity string
+	status   int
+	err      string   // the error we reject the identity with
+	urn      urns.URN // the URN of the msg we accept for the identity
+}
+
+// how handlers which create tel URNs for channels in Rwanda deal with malformed identities
+var malformedTelCases = []malformedURNTestCase{
+	{identity: "+", status: 400, err: "scheme or path cannot be empty"},
+	{identity: "++250788383383", status: 200, urn: "tel:+250788383383"},
+	{identity: "250-788-383-383-abc", status: 400, err: "phone number supplied is not a number"},
+	{identity: "(250) 788 383 383 ext. 12", status: 200, urn: "tel:+250788383383"},
+	{identity: strings.Repeat("0", 40), status: 200, urn: "tel:0000000000000000000000000000000000000000"},
+	{identity: "+1234567890123456789012345678901234567890", status: 400, err: "phone number supplied is not a number"},
+	{identity: "😀😃😄", status: 400, err: "scheme or path cannot be empty"},
+	{identity: "user😀123", status: 400, err: "phone number supplied is not a number"},
+	{identity: "\x00\x01\x02", status: 400, err: "scheme or path cannot be empty"},
+	{identity: "<script>alert(1)</script>", status: 400, err: "phone number supplied is not a number"},
+	{identity: strings.Repeat("9", 300), status: 400, err: "invalid tel number: " + strings.Repeat("9", 300)},
+	{identity: strings.Repeat("a", 1000), status: 400, err: "invalid tel number: " + strings.Repeat("a", 1000)},
+}
+
+// how the Discord handler, whose ids are numeric, deals with the same identities
+var malformedDiscordCases = []malformedURNTestCase{
+	{identity: "+", status: 400, err: "invalid discord id: +"},
+	{identity: "++250788383383", status: 400, err: "invalid discord id: ++250788383383"},
+	{identity: "250-788-383-383-abc", status: 400, err: "invalid discord id: 250-788-383-383-abc"},
+	{identity: "(250) 788 383 383 ext. 12", status: 400, err: "invalid discord id: (250) 788 383 383 ext. 12"},
+	{identity: strings.Repeat("0", 40), status: 200, urn: urns.URN("discord:" + strings.Repeat("0", 40))},
+	{identity: "+1234567890123456789012345678901234567890", status: 400, err: "invalid discord id: +1234567890123456789012345678901234567890"},
+	{identity: "😀😃😄", status: 400, err: "invalid discord id: 😀😃😄"},
+	{identity: "user😀123", status: 400, err: "invalid discord id: user😀123"},
+	{identity: "\x00\x01\x02", status: 400, err: "invalid discord id: \x00\x01\x02"},
+	{identity: "<script>alert(1)</script>", status: 400, err: "invalid discord id: <script>alert(1)</script>"},
+	{identity: strings.Repeat("9", 300), status: 200, urn: urns.URN("discord:" + strings.Repeat("9", 300))},
+	{identity: strings.Repeat("a", 1000), status: 400, err: "invalid discord id: " + strings.Repeat("a", 1000)},
+}
+
+// how the Firebase handler, whose ids are registration tokens it doesn't check, deals with the same identities
+var malformedFCMCases = []malformedURNTestCase{
+	{identity: "+", status: 200, urn: "fcm:+"},
+	{identity: "++250788383383", status: 200, urn: "fcm:++250788383383"},
+	{identity: "250-788-383-383-abc", status: 200, urn: "fcm:250-788-383-383-abc"},
+	{identity: "(250) 788 383 383 ext. 12", status: 200, urn: "fcm:(250) 788 383 383 ext. 12"},
+	{identity: strings.Repeat("0", 40), status: 200, urn: urns.URN("fcm:" + strings.Repeat("0", 40))},
+	{identity: "+1234567890123456789012345678901234567890", status: 200, urn: "fcm:+1234567890123456789012345678901234567890"},
+	{identity: "😀😃😄", status: 200, urn: "fcm:😀😃😄"},
+	{identity: "user😀123", status: 200, urn: "fcm:user😀123"},
+	{identity: "\x00\x01\x02", status: 200, urn: "fcm:\x00\x01\x02"},
+	{identity: "<script>alert(1)</script>", status: 200, urn: "fcm:<script>alert(1)</script>"},
+	{identity: strings.Repeat("9", 300), status: 200, urn: urns.URN("fcm:" + strings.Repeat("9", 300))},
+	{identity: strings.Repeat("a", 1000), status: 200, urn: urns.URN("fcm:" + strings.Repeat("a", 1000))},
+}
+
+// a well formed identity, which malformed ones are compared against for handlers that don't create a URN from our
+// payloads
+const wellFormedIdentity = "+250788383383"
+
+// the keys vendors most commonly pass sender identities in
+var identityKeys = []string{"from", "From", "sender", "msisdn", "MSISDN", "mobile", "phone", "number", "source", "id", "user_id", "originator"}
+
+// a receive request our payloads reach URN creation with, i.e. the channel type and how the payload is sent (GET,
+// POST or POST json), and the body the handler accepts a msg with if it doesn't use our standard response
+type urnRequest struct {
+	channelType courier.ChannelType
+	method      string
+	accepted    func(msg courier.Msg) string
+}
+
+func (u urnRequest) String() string { return fmt.Sprintf("%s %s", u.channelType, u.method) }
+
+// the requests which create tel URNs from our payloads
+var telURNRequests = []urnRequest{
+	{channelType: "BS", method: http.MethodGet},
+	{channelType: "CS", method: http.MethodPost},
+	{channelType: "EX", method: http.MethodGet},
+	{channelType: "EX", method: http.MethodPost},
+	{channelType: "I2", method: http.MethodPost, accepted: func(courier.Msg) string { return "" }},
+	{channelType: "M3", method: http.MethodPost, accepted: func(msg courier.Msg) string { return fmt.Sprintf("SMS Accepted: %d", msg.ID()) }},
+	{channelType: "MG", method: http.MethodPost},
+	{channelType: "NV", method: http.MethodPost},
+	{channelType: "SC", method: http.MethodPost},
+	{channelType: "SQ", method: http.MethodPost},
+	{channelType: "YO", method: http.MethodGet},
+}
+
+// the requests which create discord URNs from our payloads
+var discordURNRequests = []urnRequest{
+	{channelType: "DS", method: http.MethodPost},
+}
+
+// the requests which create fcm URNs from our payloads
+var fcmURNRequests = []urnRequest{
+	{channelType: "FCM", method: http.MethodPost},
+}
+
+// noopBilling is a billing client which drops everything sent to it
+type noopBilling struct{}
+
+func (b *noopBilling) Send(msg billing.Message) error                         { return nil }
+func (b *noopBilling) SendAsync(msg billing.Message, pre func(), post func()) {}
+
+// TestMalformedURNs feeds malformed identities through the receive routes of every registered handler, checking the
+// exact response of the handlers which create URNs from them, and that every other handler responds to them exactly as
+// it does to a well formed identity
+func TestMalformedURNs(t *testing.T) {
+	logrus.SetOutput(io.Discard)
+	defer logrus.SetOutput(logrus.StandardLogger().Out)
+
+	config := courier.NewConfig()
+	config.MaxWorkers = 0
+
+	mb := courier.NewMockBackend()
+
+	channelUUIDs := make(map[courier.ChannelType]string)
+	i := 0
+	for channelType := range courier.RegisteredHandlers() {
+		channelUUID := fmt.Sprintf("8eb23e93-5ecb-45ba-b726-%012d", i)
+		channel := courier.NewMockChannel(channelUUID, string(channelType), fmt.Sprintf("2020%d", i), "RW", map[string]interface{}{})
+		mb.AddChannel(channel)
+		channelUUIDs[channelType] = channelUUID
+		i++
+	}
+
+	server := courier.NewEmbeddedServer(config, mb)
+	server.SetBilling(&noopBilling{})
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	// sends the passed in identity to the passed in path in the named request, a GET with a query string or a POST
+	// of a form or of JSON
+	send := func(name string, path string, identity string) *httptest.ResponseRecorder {
+		form := url.Values{"text": []string{"hello"}, "message": []string{"hello"}}
+		jsonBody := map[string]interface{}{"text": "hello", "message": "hello"}
+		for _, key := range identityKeys {
+			form.Set(key, identity)
+			jsonBody[key] = identity
+		}
+
+		var r *http.Request
+		switch name {
+		case "GET":
+			r = httptest.NewRequest(http.MethodGet, path+"?"+form.Encode(), nil)
+		case "POST":
+			r = httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		default:
+			jsonBytes, _ := json.Marshal(jsonBody)
+			r = httptest.NewRequest(http.MethodPost, path, bytes.NewReader(jsonBytes))
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		w := httptest.NewRecorder()
+		server.Router().ServeHTTP(w, r)
+		return w
+	}
+
+	receivePath := func(channelType courier.ChannelType) string {
+		return fmt.Sprintf("/c/%s/%s/receive", strings.ToLower(string(channelType)), channelUUIDs[channelType])
+	}
+
+	// handlers which create URNs from our payloads must respond to each identity exactly as expected
+	urnRequests := make(map[string]bool)
+	for _, tc := range []struct {
+		requests []urnRequest
+		cases    []malformedURNTestCase
+	}{
+		{telURNRequests, malformedTelCases},
+		{discordURNRequests, malformedDiscordCases},
+		{fcmURNRequests, malformedFCMCases},
+	} {
+		for _, request := range tc.requests {
+			urnRequests[request.String()] = true
+
+			for _, c := range tc.cases {
+				mb.ClearQueueMsgs()
+				w := send(request.method, receivePath(request.channelType), c.identity)
+
+				if !assert.Equal(t, c.status, w.Code, "status mismatch for %s with identity %q", request, c.identity) {
+					continue
+				}
+
+				if c.status == http.StatusOK {
+					msg, err := mb.GetLastQueueMsg()
+					if assert.NoError(t, err, "no msg for %s with identity %q", request, c.identity) {
+						assert.Equal(t, c.urn, msg.URN(), "urn mismatch for %s with identity %q", request, c.identity)
+						assert.Equal(t, acceptedBody(request, msg), w.Body.String(), "response mismatch for %s with identity %q", request, c.identity)
+					}
+				} else {
+					assert.Equal(t, errorBody(c.err), w.Body.String(), "response mismatch for %s with identity %q", request, c.identity)
+				}
+			}
+		}
+	}
+
+	// every other handler must not let a malformed identity change how it responds
+	for channelType := range channelUUIDs {
+		for _, path := range []string{receivePath(channelType), fmt.Sprintf("/c/%s/receive", strings.ToLower(string(channelType)))} {
+			for _, name := range []string{"GET", "POST", "POST json"} {
+				if urnRequests[fmt.Sprintf("%s %s", channelType, name)] {
+					continue
+				}
+
+				// there's no such route, so nothing to handle the identity
+				expected := send(name, path, wellFormedIdentity)
+				if expected.Code == http.StatusNotFound {
+					continue
+				}
+
+				for _, c := range malformedTelCases {
+					w := send(name, path, c.identity)
+					assert.Equal(t, expected.Code, w.Code, "status mismatch for %s %s with identity %q", name, path, c.identity)
+					assert.Equal(t, expected.Body.String(), w.Body.String(), "response mismatch for %s %s with identity %q", name, path, c.identity)
+				}
+			}
+		}
+	}
+
+	mb.ClearQueueMsgs()
+}
+
+// acceptedBody returns the body the handler of the passed in request responds with when it accepts the passed in msg
+func acceptedBody(request urnRequest, msg courier.Msg) string {
+	if request.accepted != nil {
+		return request.accepted(msg)
+	}
+	return encodeResponse("Message Accepted", courier.NewMsgReceiveData(msg))
+}
+
+// errorBody returns the body of an error response for the passed in error
+func errorBody(err string) string {
+	return encodeResponse("Error", struct {
+		Type  string `json:"type"`
+		Error string `json:"error"`
+	}{"error", err})
+}
+
+// encodeResponse encodes a response with the passed in message and data as our handlers write them
+func encodeResponse(message string, data interface{}) string {
+	body := &bytes.Buffer{}
+	json.NewEncoder(body).Encode(struct {
+		Message string        `json:"message"`
+		Data    []interface{} `json:"data"`
+	}{message, []interface{}{data}})
+	return body.String()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// the command which validates our config and exits rather than starting the server, e.g. courier validate-config -port 80
+const validateConfigCommand = "validate-config"
+
+// how long we wait to connect to each service our config points to
+const validateConnectTimeout = 5 * time.Second
+
+// validateConfig prints our effective config with its secrets redacted followed by any problems with it, returning
+// the exit code of the command
+func validateConfig(config *courier.Config, out io.Writer) int {
+	fmt.Fprintln(out, config.Redacted())
+	fmt.Fprintln(out)
+
+	problems := append(config.Validate(), config.CheckReachable(validateConnectTimeout)...)
+	if len(problems) == 0 {
+		fmt.Fprintln(out, "config is valid")
+		return 0
+	}
+
+	fmt.Fprintf(out, "found %d problem(s) with config:\n", len(problems))
+	for _, problem := range problems {
+		fmt.Fprintf(out, "  - %s\n", problem)
+	}
+	return 1
+}
@@ -1,11 +1,16 @@
 package courier
 
-import "github.com/nyaruka/ezconf"
+import (
+	"sync/atomic"
+
+	"github.com/nyaruka/ezconf"
+)
 
 // Config is our top level configuration object
 type Config struct {
 	Backend                   string `help:"the backend that will be used by courier (currently only rapidpro is supported)"`
 	SentryDSN                 string `help:"the DSN used for logging errors to Sentry"`
+	SentryRateLimit           int    `help:"the maximum number of events with the same signature sent to Sentry each minute (set to 0 to disable)"`
 	Domain                    string `help:"the domain courier is exposed on"`
 	Address                   string `help:"the network interface address courier will bind to"`
 	Port                      int    `help:"the port courier will listen on"`
@@ -22,18 +27,34 @@ type Config struct {
 	AWSSecretAccessKey        string `help:"the secret access key id to use when authenticating S3"`
 	FacebookApplicationSecret string `help:"the Facebook app secret"`
 	FacebookWebhookSecret     string `help:"the secret for Facebook webhook URL verification"`
+	GraphAPIVersion           string `help:"the version of the Meta Graph API we call, can be overridden per channel with graph_api_version"`
+	InstagramWebhookSecret    string `help:"the secret for Instagram Login webhook URL verification"`
+	MarketingAPIToken         string `help:"the token used to look up the names of the Meta ads contacts were referred from (empty to disable)"`
 	MaxWorkers                int    `help:"the maximum number of go routines that will be used for sending (set to 0 to disable sending)"`
+	OutgoingNotifyChannel     string `help:"the Postgres channel notified as outgoing messages are queued, which wakes senders without waiting to poll (empty to disable)"`
+	Region                    string `help:"the region of this instance, which only sends the msgs of channels in it or without a region (empty to send for all)"`
+	DeferWebhooks             bool   `help:"whether we respond to validated vendor webhooks immediately, queueing them to a Redis stream to be handled later"`
+	DrainGracePeriod          int    `help:"the seconds we keep serving requests after being told to drain (by SIGUSR1 or POST /c/drain) before stopping"`
 	LibratoUsername           string `help:"the username that will be used to authenticate to Librato"`
 	LibratoToken              string `help:"the token that will be used to authenticate to Librato"`
 	StatusUsername            string `help:"the username that is needed to authenticate against the /status endpoint"`
 	StatusPassword            string `help:"the password that is needed to authenticate against the /status endpoint"`
+	PrometheusMetrics         bool   `help:"whether Prometheus metrics are exposed on /metrics, authenticated like /status"`
+	TrustedProxies            string `help:"comma separated IPs or CIDRs of the proxies whose X-Forwarded-For header we trust for the addresses of admin actions (empty to trust none)"`
 	LogLevel                  string `help:"the logging level courier should use"`
+	LogMask                   bool   `help:"whether phone numbers, emails and auth tokens are masked in log lines and Sentry events"`
+	LogMaskPattern            string `help:"a regular expression, matches of which are also masked in log lines and Sentry events"`
+	LogBodyCompressSize       int    `help:"the bytes above which request and response bodies of channel logs are stored gzip compressed (0, the default, disables compression)"`
+	LogBodyMaxSize            int    `help:"the bytes above which request and response bodies of channel logs are truncated (set to 0 to disable)"`
 	Version                   string `help:"the version that will be used in request and response headers"`
 
 	WhatsappAdminSystemUserToken   string `help:"the token of the admin system user for WhatsApp"`
+	WhatsappCloudApplicationID     string `help:"the Whatsapp Cloud app id, used to exchange embedded signup codes"`
 	WhatsappCloudApplicationSecret string `help:"the Whatsapp Cloud app secret"`
+	WhatsappCloudOnboardSecret     string `help:"the secret signing the states embedded signup is started with, which say which org its channels are onboarded for"`
 	WhatsappCloudWebhookSecret     string `help:"the secret for WhatsApp Cloud webhook URL verification"`
 	WhatsappCloudWebhooksUrl       string `help:"the url where all WhatsApp Cloud webhooks will be sent"`
+	WhatsappCloudSendParallelism   int    `help:"how many msgs of each WhatsApp Cloud channel an instance sends at once, can be overridden per channel with send_parallelism (set to 0 to send them like other msgs)"`
 
 	// IncludeChannels is the list of channels to enable, empty means include all
 	IncludeChannels []string
@@ -51,15 +72,55 @@ type Config struct {
 	// Default is WA, WAC, FB, FBA, IG
 	WaitMediaChannels []string
 
+	ChannelUnhealthyThreshold int `help:"the number of consecutive failures after which a channel is marked unhealthy"`
+	AuthFailureThreshold      int `help:"the number of consecutive sends rejected for bad credentials after which the sends of a channel are paused (set to 0 to disable)"`
+	AuthFailureWindow         int `help:"the number of seconds after the first rejected send within which the following ones must fail for a channel to be paused"`
+	DescribeURNRateLimit      int `help:"the maximum number of URN describe calls per second for each channel (set to 0 to disable)"`
+	DescribeURNCacheTTL       int `help:"the number of seconds URN descriptions are cached for"`
+
+	DedupWindow  int    `help:"the number of seconds received msgs are remembered for to drop duplicates, such as webhook retries"`
+	DedupWindows string `help:"JSON object of dedup_window overrides keyed by channel type, e.g. {\"WAC\": 259200}"`
+
+	PricingTable    string `help:"JSON table of estimated message costs keyed by channel type, country and category, use * as a wildcard"`
+	PricingCurrency string `help:"the currency of the costs in the pricing table"`
+
+	ModerationURL     string `help:"the URL of an HTTP endpoint that outgoing messages are checked against before sending"`
+	ModerationPattern string `help:"a regular expression, outgoing messages matching it are blocked (ignored if a moderation URL is set)"`
+
+	TranslationURL string `help:"the URL of an HTTP endpoint used to translate message text for channels with a translation language"`
+
+	ExtractionURL      string `help:"the URL of an HTTP endpoint used to extract the text of attachments for channels with extract_attachment_text set"`
+	ExtractionMaxChars int    `help:"the most characters of extracted attachment text kept in the metadata of a msg"`
+
+	AlertWebhookURL string `help:"the URL of an HTTP endpoint that alerts, such as channels being paused, are posted to (empty to disable)"`
+
+	MirrorDB        string `help:"URL of the database of a secondary backend which received msgs and statuses are also written to (empty to disable)"`
+	MirrorRedis     string `help:"URL of the Redis of the secondary backend, required when mirror_db is set"`
+	MirrorQueueSize int    `help:"the number of writes which can wait to be mirrored to the secondary backend, any beyond which are dropped"`
+
+	GRPCAddress string `help:"the address our gRPC API for internal services listens on, e.g. :8081 (empty to disable)"`
+	GRPCToken   string `help:"the bearer token internal services must pass to use our gRPC API"`
+
+	OverloadMaxGoroutines int `help:"the number of goroutines above which we shed non-critical work (set to 0 to disable)"`
+	OverloadMaxOpenFiles  int `help:"the number of open file descriptors above which we shed non-critical work (set to 0 to disable)"`
+	OverloadMaxMemoryMB   int `help:"the megabytes of heap in use above which we shed non-critical work (set to 0 to disable)"`
+
 	RabbitmqURL              string `help:"rabbitmq url"`
 	RabbitmqRetryPubAttempts int    `help:"rabbitmq retry attempts"`
 	RabbitmqRetryPubDelay    int    `help:"rabbitmq retry delay"`
+	RabbitmqBatchSize        int    `help:"the number of billing msgs published to rabbitmq at once (set to 0 to publish each on its own)"`
+	RabbitmqBatchLinger      int    `help:"the milliseconds a billing msg waits for its batch to fill before the batch is published anyway"`
+	RabbitmqTemplatesQueue   string `help:"the rabbitmq queue WhatsApp template sync msgs are consumed from (empty to disable)"`
+
+	// the latest reload of this config, see Current
+	reloaded *atomic.Value
 }
 
 // NewConfig returns a new default configuration object
 func NewConfig() *Config {
 	return &Config{
 		Backend:                      "rapidpro",
+		SentryRateLimit:              10,
 		Domain:                       "localhost",
 		Address:                      "",
 		Port:                         8080,
@@ -76,15 +137,32 @@ func NewConfig() *Config {
 		AWSSecretAccessKey:           "",
 		FacebookApplicationSecret:    "missing_facebook_app_secret",
 		FacebookWebhookSecret:        "missing_facebook_webhook_secret",
+		GraphAPIVersion:              "v12.0",
 		WhatsappAdminSystemUserToken: "missing_whatsapp_admin_system_user_token",
 		MaxWorkers:                   32,
+		DrainGracePeriod:             30,
 		LogLevel:                     "error",
+		LogMask:                      true,
+		LogBodyMaxSize:               256 * 1024,
 		Version:                      "Dev",
 		WaitMediaCount:               10,
 		WaitMediaSleepDuration:       1000,
 		WaitMediaChannels:            []string{},
+		ChannelUnhealthyThreshold:    5,
+		AuthFailureWindow:            600,
+		DescribeURNRateLimit:         10,
+		DescribeURNCacheTTL:          60 * 60 * 24,
+		DedupWindow:                  60 * 60 * 24,
+		PricingCurrency:              "USD",
+		ExtractionMaxChars:           4000,
+		MirrorQueueSize:              1000,
+		OverloadMaxGoroutines:        10000,
+		OverloadMaxOpenFiles:         8000,
 		RabbitmqRetryPubAttempts:     3,
 		RabbitmqRetryPubDelay:        1000,
+		RabbitmqBatchLinger:          100,
+		WhatsappCloudSendParallelism: 10,
+		reloaded:                     &atomic.Value{},
 	}
 }
 
@@ -0,0 +1,248 @@
+package courier
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/ezconf"
+	"github.com/sirupsen/logrus"
+)
+
+// the placeholder we print in place of secrets
+const redactedValue = "********"
+
+// the names of config fields whose values are secrets, matched as substrings
+var secretFieldNames = []string{"Secret", "Token", "Password", "DSN"}
+
+// the default ports of the schemes of the URLs we connect to
+var defaultSchemePorts = map[string]string{
+	"postgres":   "5432",
+	"postgresql": "5432",
+	"redis":      "6379",
+	"rediss":     "6379",
+	"amqp":       "5672",
+	"amqps":      "5671",
+	"http":       "80",
+	"https":      "443",
+}
+
+// the channel types which need our Facebook app secrets, and those which need our WhatsApp Cloud secrets
+var facebookAppChannelTypes = []string{"FBA", "IG"}
+var whatsappCloudChannelTypes = []string{"WAC"}
+
+// ChannelTypeEnabled returns whether handlers of the passed in channel type are initialized with this config
+func (c *Config) ChannelTypeEnabled(channelType string) bool {
+	return (c.IncludeChannels == nil || utils.StringArrayContains(c.IncludeChannels, channelType)) &&
+		(c.ExcludeChannels == nil || !utils.StringArrayContains(c.ExcludeChannels, channelType))
+}
+
+// Validate checks that our settings parse and that the secrets of the features they enable are present, returning a
+// problem for each that doesn't
+func (c *Config) Validate() []error {
+	problems := make([]error, 0)
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if _, found := registeredBackends[strings.ToLower(c.Backend)]; !found {
+		addProblem("backend: no such backend type '%s'", c.Backend)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		addProblem("port: %d is not a valid port", c.Port)
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		addProblem("log_level: %s", err)
+	}
+
+	checkURL := func(name string, value string, schemes ...string) {
+		if err := validateURL(value, schemes...); err != nil {
+			addProblem("%s: %s", name, err)
+		}
+	}
+	checkURL("db", c.DB, "postgres", "postgresql")
+	checkURL("redis", c.Redis, "redis", "rediss")
+	if c.SentryDSN != "" {
+		checkURL("sentry_dsn", c.SentryDSN, "http", "https")
+	}
+	if c.RabbitmqURL != "" {
+		checkURL("rabbitmq_url", c.RabbitmqURL, "amqp", "amqps")
+	}
+	if c.ModerationURL != "" {
+		checkURL("moderation_url", c.ModerationURL, "http", "https")
+	}
+	if c.TranslationURL != "" {
+		checkURL("translation_url", c.TranslationURL, "http", "https")
+	}
+	if c.ExtractionURL != "" {
+		checkURL("extraction_url", c.ExtractionURL, "http", "https")
+	}
+	if c.MirrorDB != "" {
+		checkURL("mirror_db", c.MirrorDB, "postgres", "postgresql")
+		checkURL("mirror_redis", c.MirrorRedis, "redis", "rediss")
+	}
+	if c.AlertWebhookURL != "" {
+		checkURL("alert_webhook_url", c.AlertWebhookURL, "http", "https")
+	}
+	if c.WhatsappCloudWebhooksUrl != "" {
+		checkURL("whatsapp_cloud_webhooks_url", c.WhatsappCloudWebhooksUrl, "http", "https")
+	}
+
+	if _, err := regexp.Compile(c.LogMaskPattern); err != nil {
+		addProblem("log_mask_pattern: %s", err)
+	}
+	if c.ModerationURL == "" && c.ModerationPattern != "" {
+		if _, err := regexp.Compile(c.ModerationPattern); err != nil {
+			addProblem("moderation_pattern: %s", err)
+		}
+	}
+	if c.DedupWindow <= 0 {
+		addProblem("dedup_window: must be greater than 0")
+	}
+	if _, err := ParseDedupWindows(c.DedupWindows); err != nil {
+		addProblem("dedup_windows: %s", err)
+	}
+	if _, err := ParsePricingTable(c.PricingTable); err != nil {
+		addProblem("pricing_table: %s", err)
+	}
+
+	// settings which only make sense in pairs
+	if c.LibratoUsername != "" && c.LibratoToken == "" {
+		addProblem("librato_token: required when librato_username is set")
+	}
+	if c.StatusUsername != "" && c.StatusPassword == "" {
+		addProblem("status_password: required when status_username is set")
+	}
+	if c.AWSAccessKeyID != "" && c.AWSSecretAccessKey == "" {
+		addProblem("aws_secret_access_key: required when aws_access_key_id is set")
+	}
+	if c.MirrorDB != "" && c.MirrorRedis == c.Redis {
+		addProblem("mirror_redis: must differ from redis as the mirror would see our msgs as already written")
+	}
+	if c.GRPCAddress != "" && c.GRPCToken == "" {
+		addProblem("grpc_token: required when grpc_address is set")
+	}
+	if c.RabbitmqBatchSize > 0 && c.RabbitmqURL == "" {
+		addProblem("rabbitmq_url: required when rabbitmq_batch_size is set")
+	}
+
+	// secrets of the channel types we handle
+	checkSecret := func(name string, value string, channelTypes []string) {
+		if value != "" && !strings.HasPrefix(value, "missing_") {
+			return
+		}
+		for _, ct := range channelTypes {
+			if c.ChannelTypeEnabled(ct) {
+				addProblem("%s: required when %s channels are enabled", name, ct)
+				return
+			}
+		}
+	}
+	checkSecret("facebook_application_secret", c.FacebookApplicationSecret, facebookAppChannelTypes)
+	checkSecret("facebook_webhook_secret", c.FacebookWebhookSecret, facebookAppChannelTypes)
+	checkSecret("whatsapp_admin_system_user_token", c.WhatsappAdminSystemUserToken, whatsappCloudChannelTypes)
+	checkSecret("whatsapp_cloud_application_secret", c.WhatsappCloudApplicationSecret, whatsappCloudChannelTypes)
+	checkSecret("whatsapp_cloud_webhook_secret", c.WhatsappCloudWebhookSecret, whatsappCloudChannelTypes)
+
+	return problems
+}
+
+// CheckReachable checks that we can open a connection to each of the services our settings point to, returning a
+// problem for each we can't
+func (c *Config) CheckReachable(timeout time.Duration) []error {
+	problems := make([]error, 0)
+
+	check := func(name string, value string) {
+		if value == "" {
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Host == "" {
+			return // reported by Validate
+		}
+		address := u.Host
+		if u.Port() == "" {
+			address = net.JoinHostPort(u.Hostname(), defaultSchemePorts[u.Scheme])
+		}
+		conn, err := net.DialTimeout("tcp", address, timeout)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: unable to connect to %s: %s", name, address, err))
+			return
+		}
+		conn.Close()
+	}
+
+	check("db", c.DB)
+	check("redis", c.Redis)
+	check("rabbitmq_url", c.RabbitmqURL)
+	check("moderation_url", c.ModerationURL)
+	check("translation_url", c.TranslationURL)
+	check("extraction_url", c.ExtractionURL)
+	check("alert_webhook_url", c.AlertWebhookURL)
+	check("mirror_db", c.MirrorDB)
+	check("mirror_redis", c.MirrorRedis)
+	check("whatsapp_cloud_webhooks_url", c.WhatsappCloudWebhooksUrl)
+
+	return problems
+}
+
+// Redacted returns our effective settings as TOML-like lines, with secrets and the passwords of URLs redacted
+func (c *Config) Redacted() string {
+	lines := make([]string, 0)
+
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		value := v.Field(i).Interface()
+
+		name := ezconf.CamelToSnake(field.Name)
+		if s, isString := value.(string); isString {
+			lines = append(lines, fmt.Sprintf("%s = %q", name, redactSetting(field.Name, s)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s = %v", name, value))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// redactSetting redacts the passed in value of the setting with the passed in name if it's a secret or a URL with a
+// password
+func redactSetting(name string, value string) string {
+	if value == "" {
+		return value
+	}
+	for _, secret := range secretFieldNames {
+		if strings.Contains(name, secret) {
+			return redactedValue
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// validateURL checks the passed in value is an absolute URL with one of the passed in schemes
+func validateURL(value string, schemes ...string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		// parse errors include the URL, which may have a password in it
+		return fmt.Errorf("not a valid URL")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("'%s' has no host", u.Redacted())
+	}
+	if !utils.StringArrayContains(schemes, u.Scheme) {
+		return fmt.Errorf("'%s' must have scheme %s", u.Redacted(), strings.Join(schemes, " or "))
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nyaruka/ezconf"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the config keys which can be reloaded without restarting, because they're read through Config.Current each time
+// they're used rather than when something is created at startup
+var reloadableConfigKeys = map[string]bool{
+	"alert_webhook_url":               true,
+	"auth_failure_threshold":          true,
+	"auth_failure_window":             true,
+	"channel_unhealthy_threshold":     true,
+	"describe_urn_cache_ttl":          true,
+	"describe_urn_rate_limit":         true,
+	"log_level":                       true,
+	"overload_max_goroutines":         true,
+	"overload_max_memory_mb":          true,
+	"overload_max_open_files":         true,
+	"whatsapp_cloud_send_parallelism": true,
+}
+
+// the config keys we don't compare on reload, because they're set by our build rather than our config
+var unreloadedConfigKeys = map[string]bool{"version": true}
+
+// reloads are applied one at a time
+var configReloadMutex sync.Mutex
+
+// ConfigLoader loads our config again from wherever it was loaded from at startup
+type ConfigLoader func() (*Config, error)
+
+// ConfigChange is a config key whose value was changed by a reload
+type ConfigChange struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// ConfigReload is what a config reload did, e.g.
+//
+//	{
+//	  "changed": [{"key": "log_level", "old": "error", "new": "info"}],
+//	  "restart_required": ["max_workers"]
+//	}
+type ConfigReload struct {
+	Changed         []*ConfigChange `json:"changed"`
+	RestartRequired []string        `json:"restart_required"`
+}
+
+// ReadConfig reads our configuration from the passed in filename like LoadConfig, but returns any error rather than exiting
+func ReadConfig(filename string) (*Config, error) {
+	config := NewConfig()
+	loader := ezconf.NewLoader(
+		config,
+		"courier", "Courier - A fast message broker for SMS and IP messages",
+		[]string{filename},
+	)
+
+	if err := loader.Load(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Current returns the latest reload of this config, or the config itself if it's never been reloaded. Reloads replace
+// the config rather than change it, so what's returned can be read without locking, but shouldn't be kept by things
+// which want to see settings which are reloaded.
+func (c *Config) Current() *Config {
+	if c.reloaded != nil {
+		if current, isConfig := c.reloaded.Load().(*Config); isConfig {
+			return current
+		}
+	}
+	return c
+}
+
+// ApplyConfigReload replaces the current config of the passed in running config with a copy having the reloadable
+// settings of the passed in loaded config, returning what changed and the keys of the other settings which differ and
+// so need a restart to take effect. Nothing is applied if the loaded config has an invalid log level.
+func ApplyConfigReload(running *Config, loaded *Config) (*ConfigReload, error) {
+	if _, err := logrus.ParseLevel(loaded.LogLevel); err != nil {
+		return nil, errors.Errorf("invalid log level '%s'", loaded.LogLevel)
+	}
+	if running.reloaded == nil {
+		return nil, errors.New("config can't be reloaded as it wasn't created by NewConfig")
+	}
+
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	reload := &ConfigReload{Changed: []*ConfigChange{}, RestartRequired: []string{}}
+	next := *running.Current()
+	nextValue, loadedValue := reflect.ValueOf(&next).Elem(), reflect.ValueOf(loaded).Elem()
+
+	for i := 0; i < nextValue.NumField(); i++ {
+		field := nextValue.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := ezconf.CamelToSnake(field.Name)
+		oldValue, newValue := nextValue.Field(i), loadedValue.Field(i)
+		if unreloadedConfigKeys[key] || reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+			continue
+		}
+
+		if reloadableConfigKeys[key] {
+			reload.Changed = append(reload.Changed, &ConfigChange{Key: key, Old: oldValue.Interface(), New: newValue.Interface()})
+			oldValue.Set(newValue)
+		} else {
+			reload.RestartRequired = append(reload.RestartRequired, key)
+		}
+	}
+
+	if len(reload.Changed) > 0 {
+		running.reloaded.Store(&next)
+	}
+
+	sort.Slice(reload.Changed, func(i, j int) bool { return reload.Changed[i].Key < reload.Changed[j].Key })
+	sort.Strings(reload.RestartRequired)
+	return reload, nil
+}
+
+// SetConfigLoader sets how our config is loaded again when reloaded
+func (s *server) SetConfigLoader(loader ConfigLoader) { s.configLoader = loader }
+
+// ReloadConfig loads our config again, applying the settings which can change without restarting, and records what
+// changed in our audit log as taken by the passed in actor, e.g. SIGHUP
+func (s *server) ReloadConfig(ctx context.Context, actor string) (*ConfigReload, error) {
+	return s.reloadConfig(ctx, &AuditRecord{Action: "config_reload", Actor: actor, CreatedOn: time.Now().In(time.UTC)})
+}
+
+// reloadConfig reloads our config, filling in the affected ids of the passed in audit record with what changed
+func (s *server) reloadConfig(ctx context.Context, record *AuditRecord) (*ConfigReload, error) {
+	if s.configLoader == nil {
+		return nil, errors.New("config reloading isn't enabled")
+	}
+
+	loaded, err := s.configLoader()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading config")
+	}
+
+	reload, err := ApplyConfigReload(s.config, loaded)
+	if err != nil {
+		return nil, err
+	}
+
+	// changes to our log level need applying, and feature flags are read again from Redis on their next check
+	level, _ := logrus.ParseLevel(s.Config().LogLevel)
+	logrus.SetLevel(level)
+	resetFlagCache()
+
+	log := logrus.WithField("comp", "server").WithField("restart_required", reload.RestartRequired)
+	if len(reload.Changed) == 0 {
+		log.Info("config reloaded, nothing changed")
+		return reload, nil
+	}
+
+	record.AffectedIDs = make([]string, len(reload.Changed))
+	for i, change := range reload.Changed {
+		record.AffectedIDs[i] = fmt.Sprintf("%s: %v -> %v", change.Key, change.Old, change.New)
+	}
+	if err := WriteAuditRecord(ctx, s.backend, record); err != nil {
+		log.WithError(err).Error("error writing audit record")
+	}
+
+	log.WithField("changed", record.AffectedIDs).Info("config reloaded")
+	return reload, nil
+}
+
+// handleConfigReload reloads our config, so settings like our log level can be changed without restarting
+func (s *server) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	reload, err := s.reloadConfig(r.Context(), NewAuditRecord(s.config, r, "config_reload", nil, nil))
+	if err != nil {
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	WriteDataResponse(r.Context(), w, http.StatusOK, "Config Reloaded", []interface{}{reload})
+}
@@ -0,0 +1,114 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyConfigReload(t *testing.T) {
+	running := NewConfig()
+	running.Version = "1.2.3"
+
+	loaded := NewConfig()
+	loaded.LogLevel = "info"
+	loaded.DescribeURNRateLimit = 5
+	loaded.AlertWebhookURL = "https://alerts.example.com"
+	loaded.MaxWorkers = 64
+	loaded.IncludeChannels = []string{"WAC"}
+
+	reload, err := ApplyConfigReload(running, loaded)
+	require.NoError(t, err)
+	assert.Equal(t, []*ConfigChange{
+		{Key: "alert_webhook_url", Old: "", New: "https://alerts.example.com"},
+		{Key: "describe_urn_rate_limit", Old: 10, New: 5},
+		{Key: "log_level", Old: "error", New: "info"},
+	}, reload.Changed)
+	assert.Equal(t, []string{"include_channels", "max_workers"}, reload.RestartRequired)
+
+	// only reloadable settings are applied, and our version is left alone
+	current := running.Current()
+	assert.Equal(t, "info", current.LogLevel)
+	assert.Equal(t, 5, current.DescribeURNRateLimit)
+	assert.Equal(t, "https://alerts.example.com", current.AlertWebhookURL)
+	assert.Equal(t, 32, current.MaxWorkers)
+	assert.Nil(t, current.IncludeChannels)
+	assert.Equal(t, "1.2.3", current.Version)
+
+	// to a copy of our config, so anything reading the config it had before doesn't see it change
+	assert.Equal(t, "error", running.LogLevel)
+	assert.Equal(t, 10, running.DescribeURNRateLimit)
+
+	// reloading again changes nothing
+	reload, err = ApplyConfigReload(running, loaded)
+	require.NoError(t, err)
+	assert.Equal(t, []*ConfigChange{}, reload.Changed)
+	assert.Same(t, current, running.Current())
+
+	// configs with invalid log levels aren't applied at all
+	loaded.LogLevel = "loud"
+	loaded.DescribeURNRateLimit = 1
+	_, err = ApplyConfigReload(running, loaded)
+	assert.EqualError(t, err, "invalid log level 'loud'")
+	assert.Equal(t, 5, running.Current().DescribeURNRateLimit)
+
+	// configs not created by NewConfig can't be reloaded
+	_, err = ApplyConfigReload(&Config{}, NewConfig())
+	assert.EqualError(t, err, "config can't be reloaded as it wasn't created by NewConfig")
+}
+
+func TestConfigReloadEndpoint(t *testing.T) {
+	defer logrus.SetLevel(logrus.GetLevel())
+
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	reload := func() (int, string) {
+		return server.request(http.MethodPost, "/admin/config/reload", "", true)
+	}
+
+	// reloading needs a loader
+	status, body := reload()
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "config reloading isn't enabled")
+
+	loaded := *config
+	loaded.LogLevel = "debug"
+	loaded.Port = 9090
+	server.SetConfigLoader(func() (*Config, error) { return &loaded, nil })
+
+	status, body = reload()
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"changed":[{"key":"log_level","old":"error","new":"debug"}]`)
+	assert.Contains(t, body, `"restart_required":["port"]`)
+	assert.Equal(t, "debug", server.Config().LogLevel)
+	assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+
+	// what changed is recorded in our audit log
+	records, err := mb.GetAuditRecords(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "config_reload", records[0].Action)
+	assert.Equal(t, "admin", records[0].Actor)
+	assert.Equal(t, []string{"log_level: error -> debug"}, records[0].AffectedIDs)
+
+	// reloads which change nothing aren't
+	_, err = server.ReloadConfig(context.Background(), "SIGHUP")
+	require.NoError(t, err)
+	records, _ = mb.GetAuditRecords(context.Background(), 10)
+	assert.Len(t, records, 1)
+
+	// nor are those which fail to load
+	server.SetConfigLoader(func() (*Config, error) { return nil, errors.New("boom") })
+	_, err = server.ReloadConfig(context.Background(), "SIGHUP")
+	assert.EqualError(t, err, "error loading config: boom")
+}
@@ -0,0 +1,39 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DedupWindows holds how many seconds received msgs are remembered for to drop duplicates, keyed by channel type, e.g.
+//
+//	{"WAC": 259200, "FBA": 172800}
+//
+// Channel types without a window use the dedup_window of our config.
+type DedupWindows map[ChannelType]int
+
+// ParseDedupWindows parses the passed in JSON dedup windows, an empty string is no windows
+func ParseDedupWindows(s string) (DedupWindows, error) {
+	windows := DedupWindows{}
+	if s == "" {
+		return windows, nil
+	}
+	err := json.Unmarshal([]byte(s), &windows)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedup windows: %s", err)
+	}
+	for channelType, window := range windows {
+		if window <= 0 {
+			return nil, fmt.Errorf("%s: %d is not a valid window", channelType, window)
+		}
+	}
+	return windows, nil
+}
+
+// Get returns the dedup window in seconds of the passed in channel type, or the passed in default if it has none
+func (w DedupWindows) Get(channelType ChannelType, def int) int {
+	if window, found := w[channelType]; found {
+		return window
+	}
+	return def
+}
@@ -0,0 +1,25 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupWindows(t *testing.T) {
+	windows, err := ParseDedupWindows("")
+	assert.NoError(t, err)
+	assert.Equal(t, 86400, windows.Get(ChannelType("WAC"), 86400))
+
+	windows, err = ParseDedupWindows(`{"WAC": 259200, "FBA": 172800}`)
+	assert.NoError(t, err)
+	assert.Equal(t, 259200, windows.Get(ChannelType("WAC"), 86400))
+	assert.Equal(t, 172800, windows.Get(ChannelType("FBA"), 86400))
+	assert.Equal(t, 86400, windows.Get(ChannelType("TG"), 86400))
+
+	_, err = ParseDedupWindows(`[]`)
+	assert.EqualError(t, err, "invalid dedup windows: json: cannot unmarshal array into Go value of type courier.DedupWindows")
+
+	_, err = ParseDedupWindows(`{"WAC": -1}`)
+	assert.EqualError(t, err, "WAC: -1 is not a valid window")
+}
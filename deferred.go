@@ -0,0 +1,328 @@
+package courier
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// the Redis stream deferred requests are queued to and the consumer group that processes them
+	deferredStreamName = "courier:deferred_requests"
+	deferredGroupName  = "courier"
+
+	// key set for each deferred request so vendor retries of the same payload are only queued once
+	deferredSeenKey = "courier:deferred_seen:%s"
+	deferredSeenTTL = 24 * 60 * 60
+
+	// how many deferred requests we read at a time
+	deferredBatchSize = 10
+
+	// how many times we try to handle a deferred request before giving up on it and adding it to our dead letters
+	deferredMaxDeliveries = 5
+
+	// the Redis list deferred requests we gave up on are added to, and the most of them it keeps
+	deferredDeadLettersKey = "courier:deferred_dead_letters"
+	deferredMaxDeadLetters = 1000
+)
+
+var (
+	// the most entries our stream keeps, handled requests are deleted as they are acknowledged so this is only reached
+	// if they aren't being handled, in which case the oldest are dropped
+	deferredStreamMaxLen = 100000
+
+	// how long a deferred request can go unacknowledged before another consumer reclaims it
+	deferredClaimIdle = time.Minute
+)
+
+// RequestValidator is the interface handlers which can validate incoming requests before handling them should satisfy,
+// which lets the server respond to them right away when webhooks are deferred. It returns whether the request is a
+// webhook which can be deferred and an error if it isn't valid.
+type RequestValidator interface {
+	ValidateRequest(context.Context, Channel, *http.Request) (bool, error)
+}
+
+// deferredRequest is an incoming request queued to be handled later
+type deferredRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Host   string      `json:"host"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// deferRequest validates the passed in request and queues it to our stream, writing our response to the vendor. It
+// returns false if the request can't be deferred, in which case it should be handled now.
+func (s *server) deferRequest(ctx context.Context, handler ChannelHandler, channel Channel, w http.ResponseWriter, r *http.Request) bool {
+	validator, isValidator := handler.(RequestValidator)
+	if !s.config.DeferWebhooks || !isValidator || r.Method != http.MethodPost || ctx.Value(contextDeferred) != nil {
+		return false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return true
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	deferrable, err := validator.ValidateRequest(ctx, channel, r)
+	if !deferrable {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return false
+	}
+	if err != nil {
+		WriteAndLogUnauthorized(ctx, w, r, channel, err)
+		return true
+	}
+
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	// vendors retrying a payload we already have don't need it queued again
+	hash := sha1.Sum(append([]byte(r.URL.Path), body...))
+	seenKey := fmt.Sprintf(deferredSeenKey, hex.EncodeToString(hash[:]))
+	_, err = redis.String(rc.Do("SET", seenKey, "1", "EX", deferredSeenTTL, "NX"))
+	if err == redis.ErrNil {
+		WriteIgnored(ctx, w, r, "duplicate request ignored")
+		return true
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("url", r.URL.String()).Error("error checking deferred request, handling it now")
+		return false
+	}
+
+	payload, _ := json.Marshal(&deferredRequest{Method: r.Method, URL: r.URL.RequestURI(), Host: r.Host, Header: r.Header, Body: body})
+	_, err = rc.Do("XADD", deferredStreamName, "MAXLEN", "~", deferredStreamMaxLen, "*", "request", payload)
+	if err != nil {
+		logrus.WithError(err).WithField("url", r.URL.String()).Error("error queueing deferred request, handling it now")
+		rc.Do("DEL", seenKey)
+		return false
+	}
+
+	WriteDataResponse(ctx, w, http.StatusOK, "Request Deferred", nil)
+	return true
+}
+
+// startDeferredConsumer starts reading deferred requests from our stream and handling them through our router. Requests
+// are acknowledged once handled without a server error, others are retried once they are reclaimed, until they've been
+// delivered deferredMaxDeliveries times.
+func (s *server) startDeferredConsumer() error {
+	rc := s.backend.RedisPool().Get()
+	_, err := rc.Do("XGROUP", "CREATE", deferredStreamName, deferredGroupName, "0", "MKSTREAM")
+	rc.Close()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	host, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%d", host, os.Getpid())
+	log := logrus.WithField("comp", "deferred consumer").WithField("consumer", consumer)
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+
+		lastClaim := time.Now()
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			default:
+			}
+
+			// reclaim any requests other consumers have failed to handle
+			if time.Since(lastClaim) > deferredClaimIdle {
+				s.claimDeferredRequests(consumer)
+				lastClaim = time.Now()
+			}
+
+			handled, err := s.handleDeferredRequests(consumer, ">")
+			if err != nil {
+				log.WithError(err).Error("error reading deferred requests")
+				time.Sleep(time.Second)
+			} else if handled == 0 {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// claimDeferredRequests takes over requests which have been pending for too long and handles them, giving up on those
+// which have already been delivered too many times
+func (s *server) claimDeferredRequests(consumer string) {
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	pending, err := redis.Values(rc.Do("XPENDING", deferredStreamName, deferredGroupName, "-", "+", deferredBatchSize))
+	if err != nil {
+		logrus.WithError(err).Error("error looking up pending deferred requests")
+		return
+	}
+
+	for _, p := range pending {
+		entry, err := redis.Values(p, nil)
+		if err != nil || len(entry) < 4 {
+			continue
+		}
+		id, _ := redis.String(entry[0], nil)
+		idle, _ := redis.Int64(entry[2], nil)
+		deliveries, _ := redis.Int64(entry[3], nil)
+		if time.Duration(idle)*time.Millisecond < deferredClaimIdle {
+			continue
+		}
+
+		if deliveries >= deferredMaxDeliveries {
+			s.deadLetterDeferredRequest(id, deliveries)
+			continue
+		}
+
+		_, err = rc.Do("XCLAIM", deferredStreamName, deferredGroupName, consumer, deferredClaimIdle.Milliseconds(), id)
+		if err != nil {
+			logrus.WithError(err).WithField("id", id).Error("error claiming deferred request")
+		}
+	}
+
+	// requests we've claimed are now pending for us
+	_, err = s.handleDeferredRequests(consumer, "0")
+	if err != nil {
+		logrus.WithError(err).Error("error handling claimed deferred requests")
+	}
+}
+
+// handleDeferredRequests reads a batch of requests for the passed in consumer, either new ones (>) or those already
+// pending for it (0), and handles them, returning how many were read
+func (s *server) handleDeferredRequests(consumer string, start string) (int, error) {
+	rc := s.backend.RedisPool().Get()
+	streams, err := redis.Values(rc.Do("XREADGROUP", "GROUP", deferredGroupName, consumer, "COUNT", deferredBatchSize, "STREAMS", deferredStreamName, start))
+	rc.Close()
+
+	if err == redis.ErrNil || len(streams) == 0 {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stream, err := redis.Values(streams[0], nil)
+	if err != nil || len(stream) < 2 {
+		return 0, err
+	}
+	entries, err := redis.Values(stream[1], nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// we don't hold a connection while handling requests as they can take a while
+	for _, e := range entries {
+		entry, err := redis.Values(e, nil)
+		if err != nil || len(entry) < 2 {
+			continue
+		}
+		id, _ := redis.String(entry[0], nil)
+		fields, _ := redis.StringMap(entry[1], nil)
+
+		request := &deferredRequest{}
+		err = json.Unmarshal([]byte(fields["request"]), request)
+		if err != nil {
+			// nothing we can do with this, drop it
+			logrus.WithError(err).WithField("id", id).Error("error decoding deferred request")
+			s.ackDeferredRequest(id)
+			continue
+		}
+
+		if s.replayDeferredRequest(request) {
+			s.ackDeferredRequest(id)
+		}
+	}
+
+	return len(entries), nil
+}
+
+// ackDeferredRequest acknowledges the deferred request with the passed in id and deletes it from our stream
+func (s *server) ackDeferredRequest(id string) {
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	rc.Send("XACK", deferredStreamName, deferredGroupName, id)
+	rc.Send("XDEL", deferredStreamName, id)
+	_, err := rc.Do("EXEC")
+	if err != nil {
+		logrus.WithError(err).WithField("id", id).Error("error acknowledging deferred request")
+	}
+}
+
+// deadLetterDeferredRequest gives up on the deferred request with the passed in id, moving it from our stream to our
+// dead letters
+func (s *server) deadLetterDeferredRequest(id string, deliveries int64) {
+	rc := s.backend.RedisPool().Get()
+	defer rc.Close()
+
+	log := logrus.WithField("id", id).WithField("deliveries", deliveries)
+
+	entries, err := redis.Values(rc.Do("XRANGE", deferredStreamName, id, id))
+	if err != nil {
+		log.WithError(err).Error("error reading deferred request to give up on")
+		return
+	}
+
+	// our entry may have been trimmed from our stream, in which case there's nothing left to keep
+	var request string
+	if len(entries) > 0 {
+		entry, _ := redis.Values(entries[0], nil)
+		if len(entry) >= 2 {
+			fields, _ := redis.StringMap(entry[1], nil)
+			request = fields["request"]
+		}
+	}
+
+	rc.Send("MULTI")
+	if request != "" {
+		rc.Send("LPUSH", deferredDeadLettersKey, request)
+		rc.Send("LTRIM", deferredDeadLettersKey, 0, deferredMaxDeadLetters-1)
+	}
+	rc.Send("XACK", deferredStreamName, deferredGroupName, id)
+	rc.Send("XDEL", deferredStreamName, id)
+	if _, err := rc.Do("EXEC"); err != nil {
+		log.WithError(err).Error("error giving up on deferred request")
+		return
+	}
+	log.Error("giving up on deferred request which failed too many times, added to dead letters")
+}
+
+// replayDeferredRequest handles the passed in deferred request through our router, returning whether it was handled
+func (s *server) replayDeferredRequest(request *deferredRequest) bool {
+	ctx := context.WithValue(context.Background(), contextDeferred, true)
+
+	r, err := http.NewRequestWithContext(ctx, request.Method, request.URL, bytes.NewReader(request.Body))
+	if err != nil {
+		logrus.WithError(err).WithField("url", request.URL).Error("error building deferred request")
+		return true
+	}
+	r.Header = request.Header
+	r.Host = request.Host
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+
+	if w.Code >= 500 {
+		logrus.WithField("url", request.URL).WithField("status", w.Code).Error("error handling deferred request, will retry")
+		return false
+	}
+	return true
+}
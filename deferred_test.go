@@ -0,0 +1,172 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+// deferredHandler is an embedded handler which validates the signature of its webhooks
+type deferredHandler struct {
+	embeddedHandler
+
+	// whether handling webhooks fails with a server error
+	failing bool
+}
+
+func (h *deferredHandler) Initialize(s Server) error {
+	h.server = s
+	s.AddHandlerRoute(h, http.MethodPost, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		if h.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return nil, nil
+		}
+		body, _ := io.ReadAll(r.Body)
+		msg := s.Backend().NewIncomingMsg(c, urns.URN("tel:+12065551212"), string(body))
+		err := s.Backend().WriteMsg(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		return []Event{msg}, WriteMsgSuccess(ctx, w, r, []Msg{msg})
+	})
+	return nil
+}
+
+func (h *deferredHandler) ValidateRequest(ctx context.Context, c Channel, r *http.Request) (bool, error) {
+	if r.Header.Get("Signature") != "valid" {
+		return true, fmt.Errorf("invalid signature")
+	}
+	return true, nil
+}
+
+func TestDeferredWebhooks(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+	config.DeferWebhooks = true
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e", "EM", "2020", "US", map[string]interface{}{}))
+
+	server := NewEmbeddedServer(config, mb)
+	server.AddHandler(&deferredHandler{})
+
+	host := httptest.NewServer(server.Router())
+	defer host.Close()
+
+	assert.NoError(t, server.Start())
+
+	post := func(body string, signature string) (*utils.RequestResponse, error) {
+		req, _ := http.NewRequest(http.MethodPost, host.URL+"/c/em/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive", strings.NewReader(body))
+		req.Header.Set("Signature", signature)
+		return utils.MakeHTTPRequest(req)
+	}
+
+	// invalid requests are rejected straight away
+	rr, err := post("Hello", "invalid")
+	assert.Error(t, err)
+	assert.Equal(t, 401, rr.StatusCode)
+
+	// valid ones are accepted before being handled
+	rr, err = post("Hello", "valid")
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "Request Deferred")
+
+	// retries of the same payload are ignored
+	rr, err = post("Hello", "valid")
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "duplicate request ignored")
+
+	// our request is handled in the background
+	var msg Msg
+	for i := 0; i < 50 && msg == nil; i++ {
+		time.Sleep(20 * time.Millisecond)
+		msg, _ = mb.GetLastQueueMsg()
+	}
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "Hello", msg.Text())
+	}
+
+	// and deleted from our stream once acknowledged
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	var length int
+	for i := 0; i < 50; i++ {
+		length, err = redis.Int(rc.Do("XLEN", deferredStreamName))
+		assert.NoError(t, err)
+		if length == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	assert.Equal(t, 0, length)
+
+	// which never grows much past its max length if requests aren't being handled
+	deferredStreamMaxLen = 5
+	defer func() { deferredStreamMaxLen = 100000 }()
+	assert.NoError(t, server.Stop())
+
+	for i := 0; i < 200; i++ {
+		post(fmt.Sprintf("Hello %d", i), "valid")
+	}
+	length, err = redis.Int(rc.Do("XLEN", deferredStreamName))
+	assert.NoError(t, err)
+	assert.True(t, length < 200, "stream length %d", length)
+}
+
+func TestDeferredWebhooksGivenUp(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+	config.DeferWebhooks = true
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e", "EM", "2020", "US", map[string]interface{}{}))
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", deferredStreamName, deferredDeadLettersKey)
+
+	// requests we fail to handle are reclaimed straight away
+	deferredClaimIdle = 10 * time.Millisecond
+	defer func() { deferredClaimIdle = time.Minute }()
+
+	server := NewEmbeddedServer(config, mb)
+	server.AddHandler(&deferredHandler{failing: true})
+
+	host := httptest.NewServer(server.Router())
+	defer host.Close()
+
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	req, _ := http.NewRequest(http.MethodPost, host.URL+"/c/em/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive", strings.NewReader("Never handled"))
+	req.Header.Set("Signature", "valid")
+	rr, err := utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "Request Deferred")
+
+	// until they've been tried too many times, when they're moved from our stream to our dead letters
+	var deadLetters []string
+	for i := 0; i < 100 && len(deadLetters) == 0; i++ {
+		time.Sleep(20 * time.Millisecond)
+		deadLetters, err = redis.Strings(rc.Do("LRANGE", deferredDeadLettersKey, 0, -1))
+		assert.NoError(t, err)
+	}
+	if assert.Len(t, deadLetters, 1) {
+		assert.Contains(t, deadLetters[0], "/c/em/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive")
+	}
+
+	length, err := redis.Int(rc.Do("XLEN", deferredStreamName))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+}
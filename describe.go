@@ -0,0 +1,95 @@
+package courier
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HandlerRoute is a route a handler receives requests from its vendor on, its path is relative to our base URL
+type HandlerRoute struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Action string `json:"action,omitempty"`
+}
+
+// HandlerDescription describes a handler for the UI channels are claimed from, e.g.
+//
+//	{
+//	  "channel_type": "TG",
+//	  "name": "Telegram",
+//	  "routes": [{"method": "POST", "path": "/c/tg/{uuid}/receive", "action": "receive"}],
+//	  "config": [{"key": "auth_token", "label": "Authentication Token", "required": true, "secret": true}],
+//	  "features": ["describe_urn"]
+//	}
+type HandlerDescription struct {
+	ChannelType ChannelType    `json:"channel_type"`
+	Name        string         `json:"name"`
+	Routes      []HandlerRoute `json:"routes"`
+	Config      []ConfigField  `json:"config"`
+	Features    []string       `json:"features"`
+}
+
+// routeRecorder is a server which records the routes handlers add rather than serving them
+type routeRecorder struct {
+	Server
+	routes []HandlerRoute
+}
+
+func (r *routeRecorder) AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) {
+	path := strings.Replace(handlerRoutePath(handler, action), channelRouteUUIDPattern, "{uuid}", 1)
+	r.routes = append(r.routes, HandlerRoute{Method: strings.ToUpper(method), Path: "/c" + path, Action: action})
+}
+
+func (r *routeRecorder) AddHandlerSharedRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) {
+	r.routes = append(r.routes, HandlerRoute{Method: strings.ToUpper(method), Path: "/c" + handlerSharedRoutePath(handler, action), Action: action})
+}
+
+// DescribeHandlers returns descriptions of the passed in handlers, i.e. the routes they add and the config keys and
+// optional features they support, sorted by channel type. Handlers are initialized without a backend to find their
+// routes, so shouldn't use one when initialized.
+func DescribeHandlers(handlers map[ChannelType]ChannelHandler) ([]*HandlerDescription, error) {
+	descriptions := make([]*HandlerDescription, 0, len(handlers))
+
+	for _, handler := range handlers {
+		recorder := &routeRecorder{Server: NewServer(NewConfig(), nil)}
+		if err := handler.Initialize(recorder); err != nil {
+			return nil, fmt.Errorf("error initializing %s handler: %s", handler.ChannelType(), err)
+		}
+
+		d := &HandlerDescription{
+			ChannelType: handler.ChannelType(),
+			Name:        handler.ChannelName(),
+			Routes:      recorder.routes,
+			Config:      []ConfigField{},
+			Features:    []string{},
+		}
+		if specifier, isSpecifier := handler.(ConfigSpecifier); isSpecifier {
+			d.Config = specifier.ConfigSpec()
+		}
+		if _, isAccountHandler := handler.(AccountEventHandler); isAccountHandler {
+			d.Routes = append(d.Routes, HandlerRoute{Method: http.MethodPost, Path: fmt.Sprintf("/c/%s/account", strings.ToLower(string(handler.ChannelType()))), Action: "account"})
+			d.Features = append(d.Features, "account_events")
+		}
+		if _, isDescriber := handler.(URNDescriber); isDescriber {
+			d.Features = append(d.Features, "describe_urn")
+		}
+		if _, isMarker := handler.(MsgReadMarker); isMarker {
+			d.Features = append(d.Features, "mark_read")
+		}
+		if _, isBuilder := handler.(MediaDownloadRequestBuilder); isBuilder {
+			d.Features = append(d.Features, "media_download")
+		}
+		if handler.UseChannelRouteUUID() && CanSimulateSends(handler) {
+			path := strings.Replace(handlerRoutePath(handler, "simulate"), channelRouteUUIDPattern, "{uuid}", 1)
+			d.Routes = append(d.Routes, HandlerRoute{Method: http.MethodPost, Path: "/c" + path, Action: "simulate"})
+			d.Features = append(d.Features, "simulate_send")
+		}
+
+		descriptions = append(descriptions, d)
+	}
+
+	sort.Slice(descriptions, func(i, j int) bool { return descriptions[i].ChannelType < descriptions[j].ChannelType })
+	return descriptions, nil
+}
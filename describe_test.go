@@ -0,0 +1,48 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// specHandler is an embedded handler which also describes its config
+type specHandler struct {
+	readMarkingHandler
+}
+
+func (h *specHandler) ConfigSpec() []ConfigField {
+	return []ConfigField{
+		{Key: ConfigAuthToken, Label: "Authentication Token", Required: true, Secret: true},
+		{Key: ConfigMarkAsRead, Label: "Mark As Read", Help: "Whether received msgs are marked as read"},
+	}
+}
+
+func TestDescribeHandlers(t *testing.T) {
+	descriptions, err := DescribeHandlers(map[ChannelType]ChannelHandler{"EM": &specHandler{}})
+	require.NoError(t, err)
+	assert.Equal(t, []*HandlerDescription{
+		{
+			ChannelType: "EM",
+			Name:        "Embedded Handler",
+			Routes:      []HandlerRoute{{Method: "GET", Path: "/c/em/{uuid}/receive", Action: "receive"}},
+			Config: []ConfigField{
+				{Key: "auth_token", Label: "Authentication Token", Required: true, Secret: true},
+				{Key: "mark_as_read", Label: "Mark As Read", Help: "Whether received msgs are marked as read"},
+			},
+			Features: []string{"mark_read"},
+		},
+	}, descriptions)
+
+	// handlers without a config spec have no config, account handlers have their account route
+	descriptions, err = DescribeHandlers(map[ChannelType]ChannelHandler{"EM": &accountHandler{}})
+	require.NoError(t, err)
+	require.Len(t, descriptions, 1)
+	assert.Equal(t, []HandlerRoute{
+		{Method: "GET", Path: "/c/em/{uuid}/receive", Action: "receive"},
+		{Method: "POST", Path: "/c/em/account", Action: "account"},
+	}, descriptions[0].Routes)
+	assert.Equal(t, []ConfigField{}, descriptions[0].Config)
+	assert.Equal(t, []string{"account_events"}, descriptions[0].Features)
+}
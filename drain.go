@@ -0,0 +1,121 @@
+package courier
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// the header we add to responses while draining so proxies and vendors can tell this instance is going away
+const drainingHeader = "X-Courier-Draining"
+
+// DrainStatus is what we report about draining, e.g.
+//
+//	{
+//	  "draining": true,
+//	  "draining_since": "2022-10-05T15:04:05.123Z",
+//	  "in_flight_requests": 3,
+//	  "in_flight_sends": 1
+//	}
+type DrainStatus struct {
+	Draining         bool       `json:"draining"`
+	DrainingSince    *time.Time `json:"draining_since,omitempty"`
+	InFlightRequests int64      `json:"in_flight_requests"`
+	InFlightSends    int64      `json:"in_flight_sends"`
+}
+
+// drainState tracks whether we're draining and the work we still have in flight
+type drainState struct {
+	requests int64
+
+	once      sync.Once
+	draining  int32
+	since     time.Time
+	drainChan chan bool
+}
+
+func newDrainState() *drainState {
+	return &drainState{drainChan: make(chan bool)}
+}
+
+// Drain starts draining, during which we keep serving requests but ask clients to close their connections, and stop
+// assigning new msgs to our senders, leaving those already assigned to finish
+func (s *server) Drain() {
+	s.drain.once.Do(func() {
+		s.drain.since = time.Now()
+		atomic.StoreInt32(&s.drain.draining, 1)
+		close(s.drain.drainChan)
+
+		logrus.WithFields(logrus.Fields{
+			"comp":               "server",
+			"state":              "draining",
+			"in_flight_requests": atomic.LoadInt64(&s.drain.requests),
+			"in_flight_sends":    s.inFlightSends(),
+		}).Info("draining server")
+	})
+}
+
+// Draining returns whether we've been told to drain
+func (s *server) Draining() bool { return atomic.LoadInt32(&s.drain.draining) == 1 }
+
+// DrainChan returns a channel which is closed when we start draining
+func (s *server) DrainChan() chan bool { return s.drain.drainChan }
+
+// DrainStatus returns whether we're draining and how much work we still have in flight
+func (s *server) DrainStatus() *DrainStatus {
+	status := &DrainStatus{
+		Draining:         s.Draining(),
+		InFlightRequests: atomic.LoadInt64(&s.drain.requests),
+		InFlightSends:    s.inFlightSends(),
+	}
+	if status.Draining {
+		since := s.drain.since.UTC()
+		status.DrainingSince = &since
+	}
+	return status
+}
+
+// inFlightSends returns how many msgs our senders are sending
+func (s *server) inFlightSends() int64 {
+	if s.foreman == nil {
+		return 0
+	}
+	return s.foreman.Sending()
+}
+
+// trackInFlight is middleware which counts the requests we're serving, asking clients to close their connections once
+// we're draining so they reconnect to an instance which isn't going away
+func (s *server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&s.drain.requests, 1)
+		defer atomic.AddInt64(&s.drain.requests, -1)
+
+		if s.Draining() {
+			w.Header().Set("Connection", "close")
+			w.Header().Set(drainingHeader, "true")
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleDrain reports our drain status, starting to drain first if the request is a POST, which like our other admin
+// endpoints requires our status credentials and is audited
+func (s *server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		if !s.checkAdminAuth(w, r) {
+			return
+		}
+		if err := WriteAuditRecord(r.Context(), s.backend, NewAuditRecord(s.config, r, "drain", nil, nil)); err != nil {
+			logrus.WithError(err).Error("error writing audit record")
+		}
+		s.Drain()
+	} else if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	WriteDataResponse(r.Context(), w, http.StatusOK, "Drain Status", []interface{}{s.DrainStatus()})
+}
@@ -0,0 +1,91 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrain(t *testing.T) {
+	mb := NewMockBackend()
+	server := newAdminTestServer(t, NewConfig(), mb)
+	defer server.Close()
+
+	started := make(chan bool)
+	release := make(chan bool)
+	server.Router().Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	drainStatus := func(method string) *DrainStatus {
+		_, body := server.request(method, "/c/drain", "", true)
+		response := &struct {
+			Data []*DrainStatus `json:"data"`
+		}{}
+		require.NoError(t, json.Unmarshal([]byte(body), response))
+		return response.Data[0]
+	}
+
+	// start a request which stays in flight until we release it
+	slowResp := make(chan *http.Response)
+	go func() {
+		resp, err := http.Get(server.host.URL + "/slow")
+		assert.NoError(t, err)
+		slowResp <- resp
+	}()
+	<-started
+
+	status := drainStatus(http.MethodGet)
+	assert.False(t, status.Draining)
+	assert.Nil(t, status.DrainingSince)
+	assert.Equal(t, int64(2), status.InFlightRequests) // our slow request and this one
+	assert.Equal(t, int64(0), status.InFlightSends)
+	assert.False(t, server.Draining())
+
+	// posting starts our drain
+	status = drainStatus(http.MethodPost)
+	assert.True(t, status.Draining)
+	assert.NotNil(t, status.DrainingSince)
+	assert.True(t, server.Draining())
+
+	select {
+	case <-server.DrainChan():
+	default:
+		assert.Fail(t, "drain chan should be closed once draining")
+	}
+
+	// and is audited
+	records, err := mb.GetAuditRecords(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "drain", records[0].Action)
+	assert.Equal(t, "admin", records[0].Actor)
+
+	// draining again is a noop
+	server.Drain()
+
+	// requests are still served while we drain, but clients are told to close their connections
+	close(release)
+	resp := <-slowResp
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "done", string(body))
+
+	req, _ := http.NewRequest(http.MethodGet, server.host.URL+"/c/drain", nil)
+	req.SetBasicAuth(testAdminUsername, testAdminPassword)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, resp.Close)
+	assert.Equal(t, "true", resp.Header.Get(drainingHeader))
+
+	assert.Equal(t, int64(1), drainStatus(http.MethodGet).InFlightRequests)
+}
@@ -0,0 +1,80 @@
+package courier
+
+import "net/http"
+
+// ErrorClass is the class of the error a send failed with, so errors of different vendors can be treated alike
+type ErrorClass string
+
+// the classes of errors sends can fail with
+const (
+	// ErrorClassAuth is the vendor rejecting the credentials of the channel
+	ErrorClassAuth = ErrorClass("auth")
+
+	// ErrorClassRateLimit is the vendor throttling the sends of the channel
+	ErrorClassRateLimit = ErrorClass("rate_limit")
+
+	// ErrorClassInvalidRecipient is the contact being unreachable, such as their number not existing or them blocking us
+	ErrorClassInvalidRecipient = ErrorClass("invalid_recipient")
+
+	// ErrorClassContentRejected is the vendor refusing the msg itself, such as for its policies or an invalid template
+	ErrorClassContentRejected = ErrorClass("content_rejected")
+
+	// ErrorClassNetwork is the request failing without a response from the vendor, such as on a timeout
+	ErrorClassNetwork = ErrorClass("network")
+
+	// ErrorClassVendor5xx is the vendor failing with a server error
+	ErrorClassVendor5xx = ErrorClass("vendor_5xx")
+
+	NilErrorClass = ErrorClass("")
+)
+
+// Retryable returns whether a send which failed with this class of error can succeed if retried as it is
+func (c ErrorClass) Retryable() bool {
+	return c != ErrorClassInvalidRecipient && c != ErrorClassContentRejected
+}
+
+// ErrorClassForHTTPStatus returns the class of the error of a request which got the passed in HTTP status, 0 being a
+// request which got no response, or NilErrorClass if the status alone doesn't tell us. Vendors also answer 403 when
+// refusing a single msg or recipient, so only a 401 tells us the credentials of the channel were rejected.
+func ErrorClassForHTTPStatus(statusCode int) ErrorClass {
+	switch {
+	case statusCode == 0:
+		return ErrorClassNetwork
+	case statusCode == http.StatusUnauthorized:
+		return ErrorClassAuth
+	case statusCode == http.StatusTooManyRequests:
+		return ErrorClassRateLimit
+	case statusCode >= 500:
+		return ErrorClassVendor5xx
+	}
+	return NilErrorClass
+}
+
+// ClassifyMsgStatus returns the class of the error of the passed in status, which is the class its handler set or else
+// that of the HTTP status of its last failed request, or NilErrorClass if it didn't fail or we can't tell why
+func ClassifyMsgStatus(status MsgStatus) ErrorClass {
+	if status.Status() != MsgErrored && status.Status() != MsgFailed {
+		return NilErrorClass
+	}
+	if status.ErrorClass() != NilErrorClass {
+		return status.ErrorClass()
+	}
+
+	if log := lastFailedRequest(status); log != nil {
+		return ErrorClassForHTTPStatus(log.StatusCode)
+	}
+	return NilErrorClass
+}
+
+// lastFailedRequest returns the log of the last request of the passed in status which failed, either with an error
+// status or without a response, or nil if none did
+func lastFailedRequest(status MsgStatus) *ChannelLog {
+	logs := status.Logs()
+	for i := len(logs) - 1; i >= 0; i-- {
+		log := logs[i]
+		if log.StatusCode >= 400 || (log.StatusCode == 0 && log.Error != "" && log.URL != "") {
+			return log
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package courier
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClassForHTTPStatus(t *testing.T) {
+	assert.Equal(t, ErrorClassNetwork, ErrorClassForHTTPStatus(0))
+	assert.Equal(t, ErrorClassAuth, ErrorClassForHTTPStatus(401))
+	assert.Equal(t, NilErrorClass, ErrorClassForHTTPStatus(403))
+	assert.Equal(t, ErrorClassRateLimit, ErrorClassForHTTPStatus(429))
+	assert.Equal(t, ErrorClassVendor5xx, ErrorClassForHTTPStatus(503))
+	assert.Equal(t, NilErrorClass, ErrorClassForHTTPStatus(400))
+	assert.Equal(t, NilErrorClass, ErrorClassForHTTPStatus(200))
+
+	assert.True(t, ErrorClassAuth.Retryable())
+	assert.True(t, ErrorClassNetwork.Retryable())
+	assert.True(t, NilErrorClass.Retryable())
+	assert.False(t, ErrorClassInvalidRecipient.Retryable())
+	assert.False(t, ErrorClassContentRejected.Retryable())
+}
+
+func TestClassifyMsgStatus(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+
+	newStatus := func(value MsgStatusValue, logs ...*ChannelLog) MsgStatus {
+		status := mb.NewMsgStatusForID(channel, NewMsgID(1), value)
+		for _, log := range logs {
+			status.AddLog(log)
+		}
+		return status
+	}
+	newLog := func(statusCode int, err error) *ChannelLog {
+		return NewChannelLog("Message Sent", channel, NewMsgID(1), "POST", "https://example.com/send", statusCode, "", "", 0, err)
+	}
+
+	// the HTTP status of the last failed request is used
+	assert.Equal(t, ErrorClassVendor5xx, ClassifyMsgStatus(newStatus(MsgErrored, newLog(401, nil), newLog(502, nil))))
+	assert.Equal(t, ErrorClassAuth, ClassifyMsgStatus(newStatus(MsgFailed, newLog(401, nil), newLog(200, nil))))
+	assert.Equal(t, NilErrorClass, ClassifyMsgStatus(newStatus(MsgFailed, newLog(403, nil), newLog(200, nil))))
+	assert.Equal(t, ErrorClassNetwork, ClassifyMsgStatus(newStatus(MsgErrored, newLog(0, errors.New("timeout")))))
+	assert.Equal(t, NilErrorClass, ClassifyMsgStatus(newStatus(MsgErrored, newLog(400, nil))))
+	assert.Equal(t, NilErrorClass, ClassifyMsgStatus(newStatus(MsgErrored)))
+
+	// statuses which didn't fail have no class
+	assert.Equal(t, NilErrorClass, ClassifyMsgStatus(newStatus(MsgWired, newLog(401, nil))))
+
+	// the class set by the handler wins
+	status := newStatus(MsgErrored, newLog(500, nil))
+	status.SetErrorClass(ErrorClassInvalidRecipient)
+	assert.Equal(t, ErrorClassInvalidRecipient, ClassifyMsgStatus(status))
+}
@@ -0,0 +1,111 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/sirupsen/logrus"
+)
+
+// how many msgs we read from the backend at a time when exporting
+const exportBatchSize = 500
+
+// ExportedMsg is a msg and its current status as exported for a channel
+type ExportedMsg struct {
+	ID          MsgID          `json:"id"`
+	UUID        MsgUUID        `json:"uuid"`
+	Direction   string         `json:"direction"`
+	Status      MsgStatusValue `json:"status"`
+	URN         string         `json:"urn"`
+	Text        string         `json:"text"`
+	Attachments []string       `json:"attachments"`
+	ExternalID  string         `json:"external_id,omitempty"`
+	ErrorCount  int            `json:"error_count"`
+	CreatedOn   time.Time      `json:"created_on"`
+	ModifiedOn  time.Time      `json:"modified_on"`
+	SentOn      *time.Time     `json:"sent_on,omitempty"`
+}
+
+// handleExport streams the msgs of a channel created within a time range as ND-JSON, one msg per line in order of ID.
+// Exports cut short by our request timeout can be resumed by passing the ID of the last msg received as the cursor.
+func (s *server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	channelUUID, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	after, err := time.Parse(time.RFC3339, query.Get("after"))
+	if err != nil {
+		WriteError(ctx, w, r, fmt.Errorf("invalid after, must be RFC3339: %s", query.Get("after")))
+		return
+	}
+	before, err := time.Parse(time.RFC3339, query.Get("before"))
+	if err != nil {
+		WriteError(ctx, w, r, fmt.Errorf("invalid before, must be RFC3339: %s", query.Get("before")))
+		return
+	}
+	if !before.After(after) {
+		WriteError(ctx, w, r, fmt.Errorf("before must be after after"))
+		return
+	}
+
+	cursor := NilMsgID
+	if c := query.Get("cursor"); c != "" {
+		id, err := strconv.ParseInt(c, 10, 64)
+		if err != nil {
+			WriteError(ctx, w, r, fmt.Errorf("invalid cursor: %s", c))
+			return
+		}
+		cursor = NewMsgID(id)
+	}
+
+	err = WriteAuditRecord(ctx, s.backend, NewAuditRecord(s.config, r, "msgs_export", channel, []string{after.Format(time.RFC3339), before.Format(time.RFC3339)}))
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error writing audit record")
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	for {
+		msgs, err := s.backend.ExportMsgs(ctx, channel, after, before, cursor, exportBatchSize)
+		if err != nil {
+			// we've already started writing our response so all we can do is report the error in the stream
+			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error exporting msgs")
+			encoder.Encode(map[string]interface{}{"error": err.Error(), "cursor": int64(cursor)})
+			return
+		}
+
+		for _, msg := range msgs {
+			encoder.Encode(msg)
+			cursor = msg.ID
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(msgs) < exportBatchSize {
+			return
+		}
+	}
+}
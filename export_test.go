@@ -0,0 +1,60 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportMsgs(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < exportBatchSize+2; i++ {
+		mb.AddExportedMsg(channel, &ExportedMsg{ID: NewMsgID(int64(i + 1)), Direction: "in", Status: MsgDelivered, URN: "whatsapp:5511999999999", Text: "hi", Attachments: []string{}, CreatedOn: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	export := func(query string) (int, []string) {
+		status, body := server.request(http.MethodGet, "/c/export/8eb23e93-5ecb-45ba-b726-3b064e0c56ab?"+query, "", true)
+		return status, strings.Split(strings.TrimSpace(body), "\n")
+	}
+
+	// all msgs in our range are streamed across batches
+	status, lines := export("after=2022-01-01T00:00:00Z&before=2022-01-03T00:00:00Z")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, lines, exportBatchSize+2)
+	assert.JSONEq(t, `{"id": 1, "uuid": "00000000-0000-0000-0000-000000000000", "direction": "in", "status": "D", "urn": "whatsapp:5511999999999", "text": "hi", "attachments": [], "error_count": 0, "created_on": "2022-01-02T03:04:05Z", "modified_on": "0001-01-01T00:00:00Z"}`, lines[0])
+	assert.Contains(t, lines[exportBatchSize+1], `"id":502`)
+
+	// ranges and cursors limit what is exported
+	_, lines = export("after=2022-01-02T03:04:05Z&before=2022-01-02T03:04:08Z")
+	assert.Len(t, lines, 3)
+	_, lines = export("after=2022-01-01T00:00:00Z&before=2022-01-03T00:00:00Z&cursor=500")
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"id":501`)
+
+	// and our export was audited
+	records, _ := mb.GetAuditRecords(context.Background(), 1)
+	assert.Equal(t, "msgs_export", records[0].Action)
+	assert.Equal(t, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", records[0].ChannelUUID)
+
+	status, lines = export("after=2022-01-01&before=2022-01-03T00:00:00Z")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, lines[0], "invalid after, must be RFC3339")
+
+	status, lines = export("after=2022-01-03T00:00:00Z&before=2022-01-01T00:00:00Z")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, lines[0], "before must be after after")
+}
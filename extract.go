@@ -0,0 +1,119 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nyaruka/courier/extraction"
+	"github.com/nyaruka/courier/tasks"
+	"github.com/pkg/errors"
+)
+
+// ExtractedText is the text extracted from one of the attachments of an incoming msg, as added to its metadata
+//
+//	{
+//	  "url": "https://example.com/invoice.pdf",
+//	  "text": "Invoice #1234 ...",
+//	  "truncated": true
+//	}
+type ExtractedText struct {
+	URL       string `json:"url"`
+	Text      string `json:"text"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// ExtractAttachmentTextTask is the type of the task which extracts the text of the attachments of a msg once it's been
+// written, when they've been stored in our own storage
+const ExtractAttachmentTextTask = "extract_attachment_text"
+
+// attachmentTextExtraction is the payload of a task extracting the text of the attachments of a written msg
+type attachmentTextExtraction struct {
+	MsgUUID     MsgUUID  `json:"msg_uuid"`
+	Attachments []string `json:"attachments"`
+}
+
+// NewAttachmentTextTask returns the task which extracts the text of the attachments of the passed in written msg, or nil
+// if there's nothing to extract because its channel doesn't want that or it has no attachments
+func NewAttachmentTextTask(msg Msg) (*tasks.Task, error) {
+	if !msg.Channel().BoolConfigForKey(ConfigExtractAttachmentText, false) || len(msg.Attachments()) == 0 {
+		return nil, nil
+	}
+	return tasks.NewTask(ExtractAttachmentTextTask, msg.Channel().UUID().String(), &attachmentTextExtraction{MsgUUID: msg.UUID(), Attachments: msg.Attachments()})
+}
+
+// ExtractAttachmentText returns the text of the image and document attachments in the passed in list, keeping at most
+// maxChars characters of text across them
+func ExtractAttachmentText(ctx context.Context, extractor extraction.Extractor, maxChars int, attachments []string) ([]ExtractedText, error) {
+	extracted := make([]ExtractedText, 0, len(attachments))
+	remaining := maxChars
+	for _, attachment := range attachments {
+		if remaining <= 0 {
+			break
+		}
+
+		contentType, url := splitAttachment(attachment)
+		if !isExtractable(contentType, url) {
+			continue
+		}
+
+		extraction, err := extractor.Extract(ctx, extraction.Request{URL: url, ContentType: contentType})
+		if err != nil {
+			return nil, err
+		}
+		text := strings.TrimSpace(extraction.Text)
+		if text == "" {
+			continue
+		}
+
+		e := ExtractedText{URL: url, Text: text}
+		if utf8.RuneCountInString(text) > remaining {
+			e.Text = string([]rune(text)[:remaining])
+			e.Truncated = true
+		}
+		remaining -= utf8.RuneCountInString(e.Text)
+		extracted = append(extracted, e)
+	}
+	return extracted, nil
+}
+
+// extractAttachmentText runs a task extracting the text of the attachments of a written msg, adding it to the msg's
+// metadata as `extracted_text`
+func (s *server) extractAttachmentText(ctx context.Context, task *tasks.Task) error {
+	if s.extractor == nil {
+		return nil
+	}
+
+	payload := &attachmentTextExtraction{}
+	if err := json.Unmarshal(task.Payload, payload); err != nil {
+		return errors.Wrap(err, "unable to read attachment text extraction")
+	}
+
+	extracted, err := ExtractAttachmentText(ctx, s.extractor, s.config.ExtractionMaxChars, payload.Attachments)
+	if err != nil {
+		return err
+	}
+	if len(extracted) == 0 {
+		return nil
+	}
+	return s.backend.AddMsgMetadata(ctx, payload.MsgUUID, "extracted_text", extracted)
+}
+
+// splitAttachment splits an attachment into its content type, which is empty if it has none, and its URL
+func splitAttachment(attachment string) (string, string) {
+	parts := strings.SplitN(attachment, ":", 2)
+	if len(parts) == 2 && strings.Contains(parts[0], "/") {
+		return parts[0], parts[1]
+	}
+	return "", attachment
+}
+
+// isExtractable returns whether an attachment could have text we can extract, i.e. isn't audio, video or a location.
+// Attachments without a content type are passed to the extractor to decide.
+func isExtractable(contentType string, url string) bool {
+	if strings.HasPrefix(url, "geo:") {
+		return false
+	}
+	return contentType == "" || strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "application/") || strings.HasPrefix(contentType, "text/")
+}
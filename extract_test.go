@@ -0,0 +1,127 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/extraction"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockExtractor struct {
+	texts    map[string]string
+	err      error
+	requests []extraction.Request
+}
+
+func (e *mockExtractor) Extract(ctx context.Context, req extraction.Request) (*extraction.Extraction, error) {
+	e.requests = append(e.requests, req)
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &extraction.Extraction{Text: e.texts[req.URL]}, nil
+}
+
+func TestExtractAttachmentText(t *testing.T) {
+	attachments := []string{"https://example.com/invoice.pdf", "audio/ogg:https://example.com/voice.ogg", "geo:-2.9,-79.0", "image/jpeg:https://example.com/receipt.jpg", "image/png:https://example.com/blank.png"}
+	extractor := &mockExtractor{texts: map[string]string{
+		"https://example.com/invoice.pdf": " Invoice #1234\n",
+		"https://example.com/receipt.jpg": "Total R$ 99,90",
+	}}
+
+	// audio and locations aren't passed to the extractor, attachments without text aren't returned
+	extracted, err := ExtractAttachmentText(context.Background(), extractor, 4000, attachments)
+	assert.NoError(t, err)
+	assert.Equal(t, []extraction.Request{
+		{URL: "https://example.com/invoice.pdf"},
+		{URL: "https://example.com/receipt.jpg", ContentType: "image/jpeg"},
+		{URL: "https://example.com/blank.png", ContentType: "image/png"},
+	}, extractor.requests)
+	assert.Equal(t, []ExtractedText{
+		{URL: "https://example.com/invoice.pdf", Text: "Invoice #1234"},
+		{URL: "https://example.com/receipt.jpg", Text: "Total R$ 99,90"},
+	}, extracted)
+
+	// extracted text is capped across attachments
+	extractor.requests = nil
+	extractor.texts["https://example.com/invoice.pdf"] = strings.Repeat("ã", 20)
+	extracted, err = ExtractAttachmentText(context.Background(), extractor, 15, attachments)
+	assert.NoError(t, err)
+	assert.Len(t, extractor.requests, 1)
+	assert.Equal(t, []ExtractedText{{URL: "https://example.com/invoice.pdf", Text: "ããããããããããããããã", Truncated: true}}, extracted)
+
+	_, err = ExtractAttachmentText(context.Background(), &mockExtractor{err: errors.New("boom")}, 4000, attachments)
+	assert.EqualError(t, err, "boom")
+}
+
+func TestAttachmentTextTask(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "BR", map[string]interface{}{})
+	s := NewServer(NewConfig(), mb).(*server)
+	ctx := context.Background()
+
+	// the attachments of msgs are extracted from where they're stored once written
+	msg := &mockMsg{
+		channel:     channel,
+		metadata:    json.RawMessage(`{"ad_id": "1234"}`),
+		attachments: []string{"image/jpeg:https://s3.example.com/media/receipt.jpg"},
+	}
+	require.NoError(t, mb.WriteMsg(ctx, msg))
+
+	// channel doesn't want extraction, nothing to do
+	task, err := NewAttachmentTextTask(msg)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	channel.SetConfig(ConfigExtractAttachmentText, true)
+	task, err = NewAttachmentTextTask(msg)
+	assert.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, ExtractAttachmentTextTask, task.Type)
+
+	// nothing is extracted without an extractor
+	assert.NoError(t, s.extractAttachmentText(ctx, task))
+	assert.JSONEq(t, `{"ad_id": "1234"}`, string(msg.Metadata()))
+
+	s.SetExtractor(&mockExtractor{texts: map[string]string{"https://s3.example.com/media/receipt.jpg": "Total R$ 99,90"}})
+	assert.NoError(t, s.extractAttachmentText(ctx, task))
+	assert.JSONEq(t, `{"ad_id": "1234", "extracted_text": [{"url": "https://s3.example.com/media/receipt.jpg", "text": "Total R$ 99,90"}]}`, string(msg.Metadata()))
+
+	// failing to extract is retried
+	s.SetExtractor(&mockExtractor{err: errors.New("boom")})
+	assert.EqualError(t, s.extractAttachmentText(ctx, task), "boom")
+
+	// msgs without attachments have nothing to extract
+	task, err = NewAttachmentTextTask(&mockMsg{channel: channel, text: "Hi"})
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+}
+
+func TestAttachmentTextTaskPerServer(t *testing.T) {
+	ctx := context.Background()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "BR", map[string]interface{}{ConfigExtractAttachmentText: true})
+
+	// two embedded servers, each with their own backend and extractor
+	mb1, mb2 := NewMockBackend(), NewMockBackend()
+	server1, server2 := NewEmbeddedServer(NewConfig(), mb1), NewEmbeddedServer(NewConfig(), mb2)
+	server1.SetExtractor(&mockExtractor{texts: map[string]string{"https://s3.example.com/media/receipt.jpg": "Total R$ 99,90"}})
+	server2.SetExtractor(&mockExtractor{texts: map[string]string{"https://s3.example.com/media/receipt.jpg": "Total R$ 10,00"}})
+
+	assert.NoError(t, server1.Start())
+	defer server1.Stop()
+	assert.NoError(t, server2.Start())
+	defer server2.Stop()
+
+	msg := &mockMsg{channel: channel, attachments: []string{"image/jpeg:https://s3.example.com/media/receipt.jpg"}}
+	require.NoError(t, mb1.WriteMsg(ctx, msg))
+
+	// tasks queued by the first server are run by it, against its own backend, even though the second started last
+	task, err := NewAttachmentTextTask(msg)
+	require.NoError(t, err)
+	assert.NoError(t, server1.TaskQueue().Enqueue(ctx, task))
+	assert.JSONEq(t, `{"extracted_text": [{"url": "https://s3.example.com/media/receipt.jpg", "text": "Total R$ 99,90"}]}`, string(msg.Metadata()))
+}
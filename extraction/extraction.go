@@ -0,0 +1,75 @@
+package extraction
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+// Request is what is passed to an extractor for each attachment, content type is empty if we don't know it
+//
+//	{
+//	  "url": "https://example.com/invoice.pdf",
+//	  "content_type": "application/pdf"
+//	}
+type Request struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Extraction is the text extracted from an attachment, empty if it has none
+type Extraction struct {
+	Text string `json:"text"`
+}
+
+// Extractor is the interface for anything that can extract the text of attachments, e.g. by OCR of images
+type Extractor interface {
+	Extract(ctx context.Context, req Request) (*Extraction, error)
+}
+
+// New creates an extractor for the passed in provider endpoint, returning nil if it isn't set
+func New(endpoint string) Extractor {
+	if endpoint == "" {
+		return nil
+	}
+	return NewHTTPExtractor(endpoint)
+}
+
+// httpExtractor asks an external HTTP service to extract the text of attachments
+type httpExtractor struct {
+	endpoint string
+}
+
+// NewHTTPExtractor creates a new extractor which POSTs each request to the passed in endpoint, expecting an extraction back
+func NewHTTPExtractor(endpoint string) Extractor {
+	return &httpExtractor{endpoint: endpoint}
+}
+
+func (e *httpExtractor) Extract(ctx context.Context, req Request) (*Extraction, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	rr, err := utils.MakeHTTPRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("error calling extraction endpoint: %s", err)
+	}
+
+	extraction := &Extraction{}
+	err = json.Unmarshal(rr.Body, extraction)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse extraction response: %s", err)
+	}
+	return extraction, nil
+}
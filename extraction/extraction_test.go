@@ -0,0 +1,52 @@
+package extraction
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	assert.Nil(t, New(""))
+	assert.IsType(t, &httpExtractor{}, New("http://extraction.example.com"))
+}
+
+func TestHTTPExtractor(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+		json.NewDecoder(r.Body).Decode(req)
+
+		switch req.URL {
+		case "https://example.com/invoice.pdf":
+			assert.Equal(t, "application/pdf", req.ContentType)
+			w.Write([]byte(`{"text": "Invoice #1234"}`))
+		case "https://example.com/blank.jpg":
+			w.Write([]byte(`{"text": ""}`))
+		case "https://example.com/broken.jpg":
+			w.Write([]byte(`not json`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	ex := NewHTTPExtractor(server.URL)
+
+	extraction, err := ex.Extract(context.Background(), Request{URL: "https://example.com/invoice.pdf", ContentType: "application/pdf"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Extraction{Text: "Invoice #1234"}, extraction)
+
+	extraction, err = ex.Extract(context.Background(), Request{URL: "https://example.com/blank.jpg"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Extraction{}, extraction)
+
+	_, err = ex.Extract(context.Background(), Request{URL: "https://example.com/broken.jpg"})
+	assert.Error(t, err)
+
+	_, err = ex.Extract(context.Background(), Request{URL: "https://example.com/error.jpg"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package feedback
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/furdarius/rabbitroutine"
+	"github.com/pkg/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// QueueName is the queue feedback events are published to
+const QueueName = "messaging_feedback"
+
+// Question is the answer a contact gave to a question of a feedback form
+type Question struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Payload  string `json:"payload"`
+	Score    *int   `json:"score,omitempty"`
+	FollowUp string `json:"follow_up,omitempty"`
+}
+
+// NewQuestion creates a new answered question, its score is its payload if that's numeric, as it is for CSAT and NPS
+func NewQuestion(id, typ, payload, followUp string) *Question {
+	question := &Question{ID: id, Type: typ, Payload: payload, FollowUp: followUp}
+	if score, err := strconv.Atoi(payload); err == nil {
+		question.Score = &score
+	}
+	return question
+}
+
+// Event is the feedback a contact gave on a channel, e.g.
+//
+//	{
+//	  "channel_uuid": "64a75af3-7e8d-41a5-8ef8-c273056c4fca",
+//	  "channel_type": "FBA",
+//	  "contact_urn": "facebook:5678",
+//	  "screen_id": 0,
+//	  "questions": [
+//	    {"id": "myquestion1", "type": "csat", "payload": "4", "score": 4, "follow_up": "Quick and helpful"}
+//	  ],
+//	  "occurred_on": "2024-03-08T19:08:19Z"
+//	}
+type Event struct {
+	ChannelUUID string      `json:"channel_uuid"`
+	ChannelType string      `json:"channel_type"`
+	ContactURN  string      `json:"contact_urn"`
+	ScreenID    int         `json:"screen_id"`
+	Questions   []*Question `json:"questions"`
+	OccurredOn  time.Time   `json:"occurred_on"`
+}
+
+// SortQuestions sorts the passed in questions by their id, as vendors send them as maps
+func SortQuestions(questions []*Question) {
+	sort.Slice(questions, func(i, j int) bool { return questions[i].ID < questions[j].ID })
+}
+
+// Client publishes feedback events
+type Client interface {
+	Send(event *Event) error
+	SendAsync(event *Event)
+}
+
+// rabbitmqClient publishes feedback events to RabbitMQ, retrying failed publishes
+type rabbitmqClient struct {
+	publisher rabbitroutine.Publisher
+}
+
+// NewRMQFeedbackClient creates a new feedback client which publishes to RabbitMQ, retrying publishes and reconnecting
+// as needed
+func NewRMQFeedbackClient(url string, retryAttempts int, retryDelay int) (Client, error) {
+	cconn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	defer cconn.Close()
+
+	ch, err := cconn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open a channel to rabbitmq")
+	}
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(QueueName, false, false, false, false, nil); err != nil {
+		return nil, errors.Wrap(err, "failed to declare a queue for feedback publisher")
+	}
+
+	conn := rabbitroutine.NewConnector(rabbitroutine.Config{
+		ReconnectAttempts: 1000,
+		Wait:              2 * time.Second,
+	})
+	conn.AddAMQPNotifiedListener(func(n rabbitroutine.AMQPNotified) {
+		logrus.WithField("comp", "feedback").WithError(n.Error).Error("RabbitMQ error received")
+	})
+
+	go func() {
+		if err := conn.Dial(context.Background(), url); err != nil {
+			logrus.WithField("comp", "feedback").WithError(err).Error("failed to establish RabbitMQ connection")
+		}
+	}()
+
+	pub := rabbitroutine.NewRetryPublisher(
+		rabbitroutine.NewEnsurePublisher(rabbitroutine.NewPool(conn)),
+		rabbitroutine.PublishMaxAttemptsSetup(uint(retryAttempts)),
+		rabbitroutine.PublishDelaySetup(rabbitroutine.LinearDelay(time.Duration(retryDelay)*time.Millisecond)),
+	)
+	return &rabbitmqClient{publisher: pub}, nil
+}
+
+func (c *rabbitmqClient) Send(event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = c.publisher.Publish(context.Background(), "", QueueName, amqp.Publishing{ContentType: "application/json", Body: body})
+	if err != nil {
+		return errors.Wrap(err, "failed to publish feedback event")
+	}
+	return nil
+}
+
+func (c *rabbitmqClient) SendAsync(event *Event) {
+	go func() {
+		if err := c.Send(event); err != nil {
+			logrus.WithField("comp", "feedback").WithField("channel_uuid", event.ChannelUUID).WithError(err).Error("error publishing feedback event")
+		}
+	}()
+}
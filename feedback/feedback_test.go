@@ -0,0 +1,40 @@
+package feedback_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/feedback"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent(t *testing.T) {
+	questions := []*feedback.Question{
+		feedback.NewQuestion("myquestion2", "free_form", "More agents please", ""),
+		feedback.NewQuestion("myquestion1", "csat", "4", "Quick and helpful"),
+	}
+	feedback.SortQuestions(questions)
+
+	event := &feedback.Event{
+		ChannelUUID: "64a75af3-7e8d-41a5-8ef8-c273056c4fca",
+		ChannelType: "FBA",
+		ContactURN:  "facebook:5678",
+		Questions:   questions,
+		OccurredOn:  time.Date(2024, 3, 8, 19, 8, 19, 0, time.UTC),
+	}
+
+	body, err := json.Marshal(event)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"channel_uuid": "64a75af3-7e8d-41a5-8ef8-c273056c4fca",
+		"channel_type": "FBA",
+		"contact_urn": "facebook:5678",
+		"screen_id": 0,
+		"questions": [
+			{"id": "myquestion1", "type": "csat", "payload": "4", "score": 4, "follow_up": "Quick and helpful"},
+			{"id": "myquestion2", "type": "free_form", "payload": "More agents please"}
+		],
+		"occurred_on": "2024-03-08T19:08:19Z"
+	}`, string(body))
+}
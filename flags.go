@@ -0,0 +1,97 @@
+package courier
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// the Redis hash our feature flags are stored in, e.g. HSET courier:flags parallel_webhooks false
+const flagsKey = "courier:flags"
+
+// how long we use the flags we read from Redis before reading them again
+var flagsCacheTTL = 30 * time.Second
+
+// Flag is a feature flag which lets a risky behavior be toggled per deployment without redeploying
+type Flag string
+
+const (
+	// FlagBatchStatusWrites queues msg statuses to be written to the database in batches
+	FlagBatchStatusWrites Flag = "batch_status_writes"
+
+	// FlagParallelWebhooks processes the items of a webhook for different contacts concurrently
+	FlagParallelWebhooks Flag = "parallel_webhooks"
+)
+
+// our cache of the flags in Redis, shared by everything that checks them
+var flagCache = struct {
+	mutex     sync.Mutex
+	values    map[Flag]bool
+	fetchedOn time.Time
+	fetching  bool
+}{}
+
+// FlagEnabled returns whether the passed in flag is enabled, returning the passed in default if it isn't set. When our
+// cache has expired, the first caller reads the flags again while everyone else keeps using what we had.
+func FlagEnabled(rp *redis.Pool, flag Flag, defaultValue bool) bool {
+	flagCache.mutex.Lock()
+	if time.Since(flagCache.fetchedOn) > flagsCacheTTL && !flagCache.fetching {
+		flagCache.fetching = true
+		flagCache.mutex.Unlock()
+
+		// read outside of our lock so a slow Redis doesn't block every other check
+		values, err := readFlags(rp)
+
+		flagCache.mutex.Lock()
+		if err != nil {
+			// keep using what we had, and don't try again until our cache expires so we don't hammer a failing Redis
+			logrus.WithError(err).WithField("comp", "flags").Error("error reading feature flags")
+		} else {
+			flagCache.values = values
+		}
+		flagCache.fetchedOn = time.Now()
+		flagCache.fetching = false
+	}
+	value, found := flagCache.values[flag]
+	flagCache.mutex.Unlock()
+
+	if !found {
+		return defaultValue
+	}
+	return value
+}
+
+// readFlags reads all our flags from Redis, ignoring any whose values aren't booleans
+func readFlags(rp *redis.Pool) (map[Flag]bool, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	raw, err := redis.StringMap(rc.Do("HGETALL", flagsKey))
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[Flag]bool, len(raw))
+	for name, value := range raw {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			logrus.WithField("comp", "flags").WithField("flag", name).WithField("value", value).Warn("ignoring feature flag with invalid value")
+			continue
+		}
+		values[Flag(name)] = enabled
+	}
+	return values, nil
+}
+
+// resetFlagCache forgets the flags we've read so they're read again on the next check
+func resetFlagCache() {
+	flagCache.mutex.Lock()
+	defer flagCache.mutex.Unlock()
+
+	flagCache.values = nil
+	flagCache.fetchedOn = time.Time{}
+	flagCache.fetching = false
+}
@@ -0,0 +1,53 @@
+package courier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlagEnabled(t *testing.T) {
+	defer resetFlagCache()
+	defer func(ttl time.Duration) { flagsCacheTTL = ttl }(flagsCacheTTL)
+
+	mb := NewMockBackend()
+	rp := mb.RedisPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	resetFlagCache()
+
+	// unset flags take their default
+	assert.True(t, FlagEnabled(rp, FlagParallelWebhooks, true))
+	assert.False(t, FlagEnabled(rp, FlagParallelWebhooks, false))
+
+	_, err := rc.Do("HSET", flagsKey, "parallel_webhooks", "false", "batch_status_writes", "1", "broken", "maybe")
+	assert.NoError(t, err)
+
+	// flags are cached so we don't see the change until our cache expires
+	assert.True(t, FlagEnabled(rp, FlagParallelWebhooks, true))
+
+	flagsCacheTTL = 0
+	assert.False(t, FlagEnabled(rp, FlagParallelWebhooks, true))
+	assert.True(t, FlagEnabled(rp, FlagBatchStatusWrites, false))
+	assert.True(t, FlagEnabled(rp, Flag("broken"), true))
+
+	_, err = rc.Do("HDEL", flagsKey, "parallel_webhooks")
+	assert.NoError(t, err)
+	assert.True(t, FlagEnabled(rp, FlagParallelWebhooks, true))
+
+	// while another check is reading the flags, we use what we had rather than waiting on Redis
+	_, err = rc.Do("HSET", flagsKey, "batch_status_writes", "0")
+	assert.NoError(t, err)
+
+	flagCache.mutex.Lock()
+	flagCache.fetching = true
+	flagCache.mutex.Unlock()
+	assert.True(t, FlagEnabled(rp, FlagBatchStatusWrites, false))
+
+	flagCache.mutex.Lock()
+	flagCache.fetching = false
+	flagCache.mutex.Unlock()
+	assert.False(t, FlagEnabled(rp, FlagBatchStatusWrites, true))
+}
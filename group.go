@@ -0,0 +1,69 @@
+package courier
+
+import (
+	"net/url"
+
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// GroupURNParam is the URN query param for the group a contact is talking in, e.g. telegram:3527065?group=-1001234
+const GroupURNParam = "group"
+
+// MsgGroup is the group a message was received in, stored in the msg metadata as `group`
+//
+//	{
+//	  "id": "-1001234",
+//	  "name": "Field Team",
+//	  "sender": {"id": "3527065", "name": "Nic Pottier"}
+//	}
+type MsgGroup struct {
+	ID     string         `json:"id"`
+	Name   string         `json:"name,omitempty"`
+	Sender MsgGroupSender `json:"sender"`
+}
+
+// MsgGroupSender is who sent a message in a group
+type MsgGroupSender struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+}
+
+// NewGroupURN returns the passed in sender URN talking in the passed in group, messages sent to it go to the group. Any
+// query and display the sender URN already has are kept.
+func NewGroupURN(urn urns.URN, groupID string) (urns.URN, error) {
+	scheme, path, rawQuery, display := urn.ToParts()
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return urns.NilURN, err
+	}
+	query.Set(GroupURNParam, groupID)
+
+	return urns.NewURNFromParts(scheme, path, query.Encode(), display)
+}
+
+// GroupIDForURN returns the group the passed in URN is talking in, if any
+func GroupIDForURN(urn urns.URN) string {
+	query, err := urn.Query()
+	if err != nil {
+		return ""
+	}
+	return query.Get(GroupURNParam)
+}
+
+// GroupMessagesEnabled returns whether the passed in channel handles group messages
+func GroupMessagesEnabled(channel Channel) bool {
+	return channel.BoolConfigForKey(ConfigGroupMessages, false)
+}
+
+// MsgRecipient returns the ID the passed in message should be sent to, which is its group if it targets one and
+// its channel handles groups, otherwise the path of its URN
+func MsgRecipient(msg Msg) string {
+	if GroupMessagesEnabled(msg.Channel()) {
+		groupID := GroupIDForURN(msg.URN())
+		if groupID != "" {
+			return groupID
+		}
+	}
+	return msg.URN().Path()
+}
@@ -0,0 +1,39 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupURN(t *testing.T) {
+	urn, err := NewGroupURN(urns.URN("telegram:3527065#nicpottier"), "-1001234")
+	assert.NoError(t, err)
+	assert.Equal(t, urns.URN("telegram:3527065?group=-1001234#nicpottier"), urn)
+	assert.Equal(t, "-1001234", GroupIDForURN(urn))
+	assert.Equal(t, urns.URN("telegram:3527065"), urn.Identity())
+
+	assert.Equal(t, "", GroupIDForURN(urns.URN("telegram:3527065")))
+
+	// the query the sender URN already has is kept, and an existing group replaced
+	urn, err = NewGroupURN(urns.URN("discord:694634743521607802?group=1&id=abc"), "2")
+	assert.NoError(t, err)
+	assert.Equal(t, urns.URN("discord:694634743521607802?group=2&id=abc"), urn)
+
+	_, err = NewGroupURN(urns.URN("telegram:3527065?%zz"), "-1001234")
+	assert.Error(t, err)
+}
+
+func TestMsgRecipient(t *testing.T) {
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "TG", "2020", "US", map[string]interface{}{})
+	msg := &mockMsg{channel: channel, urn: urns.URN("telegram:3527065?group=-1001234")}
+
+	assert.Equal(t, "3527065", MsgRecipient(msg))
+
+	channel.SetConfig(ConfigGroupMessages, true)
+	assert.Equal(t, "-1001234", MsgRecipient(msg))
+
+	msg.urn = urns.URN("telegram:3527065")
+	assert.Equal(t, "3527065", MsgRecipient(msg))
+}
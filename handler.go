@@ -3,6 +3,7 @@ package courier
 import (
 	"context"
 	"net/http"
+	"reflect"
 
 	"github.com/nyaruka/gocommon/urns"
 )
@@ -38,15 +39,100 @@ type MediaDownloadRequestBuilder interface {
 	BuildDownloadMediaRequest(context.Context, Backend, Channel, string) (*http.Request, error)
 }
 
-// RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized
+// MediaURLResolver is the interface handlers whose media URLs expire should satisfy, resolving a fresh URL for an
+// attachment URL of theirs, or returning an empty URL if the passed in one isn't theirs
+type MediaURLResolver interface {
+	ResolveMediaURL(context.Context, *Config, Channel, string) (string, error)
+}
+
+// MediaChecksummer is the interface handlers which learn the size and checksum of the media they resolve should satisfy,
+// returning nil for media URLs they don't know those of
+type MediaChecksummer interface {
+	MediaChecksum(Channel, string) *MediaChecksum
+}
+
+// SendPooler is the interface handlers which send many msgs of a channel at once should satisfy, returning how many
+// msgs of the passed in channel are sent concurrently by a pool of its own, or 0 to send them like any other msgs
+type SendPooler interface {
+	SendParallelism(Channel) int
+}
+
+// MsgReadMarker is the interface handlers which can tell their vendor that incoming msgs have been read should satisfy,
+// returning the log of doing so or nil if they don't do that for the channel of the msg
+type MsgReadMarker interface {
+	MarkMsgRead(context.Context, Msg) (*ChannelLog, error)
+}
+
+// ConfigField is a config key a handler expects the channels it handles to have, as shown on their claim forms
+type ConfigField struct {
+	Key      string `json:"key"`
+	Label    string `json:"label"`
+	Help     string `json:"help,omitempty"`
+	Required bool   `json:"required,omitempty"`
+	Secret   bool   `json:"secret,omitempty"`
+}
+
+// ConfigSpecifier is the interface handlers which describe the config keys of their channels should satisfy
+type ConfigSpecifier interface {
+	ConfigSpec() []ConfigField
+}
+
+// AccountEventHandler is the interface handlers which receive vendor events about an account rather than any one channel
+// should satisfy. These are posted to /c/{type}/account and returned events are written without a channel.
+type AccountEventHandler interface {
+	ReceiveAccountEvents(context.Context, http.ResponseWriter, *http.Request) ([]*AccountEvent, error)
+}
+
+// RegisterHandler adds a new handler for a channel type, this is called by individual handlers when they are initialized.
+// Registered handlers are templates, each new server starts with its own copy of them. To use a handler with a single
+// server use Server.AddHandler instead.
 func RegisterHandler(handler ChannelHandler) {
 	registeredHandlers[handler.ChannelType()] = handler
 }
 
-// GetHandler returns the handler for the passed in channel type, or nil if not found
+// GetHandler returns the registered handler for the passed in channel type, or nil if not found. This handler is never
+// initialized by a server, use Server.GetHandler to get the one a server is running.
 func GetHandler(ct ChannelType) ChannelHandler {
 	return registeredHandlers[ct]
 }
 
+// RegisteredHandlers returns new copies of the registered handlers keyed by channel type
+func RegisteredHandlers() map[ChannelType]ChannelHandler {
+	handlers := make(map[ChannelType]ChannelHandler, len(registeredHandlers))
+	for ct, handler := range registeredHandlers {
+		handlers[ct] = copyHandler(handler)
+	}
+	return handlers
+}
+
+// copyHandler returns a copy of the passed in handler, so that initializing it with a server doesn't change the
+// registered handler it was copied from
+func copyHandler(handler ChannelHandler) ChannelHandler {
+	value := reflect.ValueOf(handler)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return handler
+	}
+	copied := reflect.New(value.Elem().Type())
+	copied.Elem().Set(value.Elem())
+	return copied.Interface().(ChannelHandler)
+}
+
+// HandlerLookup returns the handler a server is running for the passed in channel type, or nil if it isn't running one
+type HandlerLookup func(ChannelType) ChannelHandler
+
+// HandlerLookupSetter is an interface backends can satisfy to look up handlers through the server they are started by
+type HandlerLookupSetter interface {
+	SetHandlerLookup(HandlerLookup)
+}
+
+// MsgHandledHook is called by backends once they've handled an incoming msg, that is written it and queued it to be
+// handled, but not for msgs they drop or have already seen
+type MsgHandledHook func(context.Context, Msg)
+
+// MsgHandledHookSetter is an interface backends can satisfy to let the server they are started by know which incoming
+// msgs they've handled
+type MsgHandledHookSetter interface {
+	SetMsgHandledHook(MsgHandledHook)
+}
+
 var registeredHandlers = make(map[ChannelType]ChannelHandler)
-var activeHandlers = make(map[ChannelType]ChannelHandler)
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/courier/moderation"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -91,7 +92,9 @@ func TestHandling(t *testing.T) {
 
 	// create our backend and server
 	mb := NewMockBackend()
-	s := NewServer(testConfig(), mb)
+	config := testConfig()
+	config.PricingTable = `{"DM": {"*": {"session": 0.01}}}`
+	s := NewServer(config, mb)
 
 	// start everything
 	s.Start()
@@ -143,6 +146,9 @@ func TestHandling(t *testing.T) {
 	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
 	assert.Equal(MsgSent, mb.msgStatuses[0].Status())
 
+	// and have its estimated cost recorded
+	assert.Equal(&MsgCostEstimate{Cost: 0.01, Currency: "USD", Category: MsgCategorySession}, mb.GetMsgCostEstimate(msg.ID()))
+
 	// clear our statuses
 	mb.msgStatuses = nil
 
@@ -155,6 +161,81 @@ func TestHandling(t *testing.T) {
 	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
 	assert.Equal(MsgWired, mb.msgStatuses[0].Status())
 
+	// clear our statuses
+	mb.msgStatuses = nil
+
+	// messages blocked by moderation are failed without being sent
+	moderator, err := moderation.NewRulesModerator("forbidden")
+	assert.NoError(err)
+	s.SetModerator(moderator)
+
+	msg = &mockMsg{
+		channel: dmChannel,
+		id:      NewMsgID(103),
+		uuid:    NilMsgUUID,
+		text:    "some forbidden text",
+		urn:     "tel:+250788383383",
+	}
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Second)
+
+	assert.Equal(1, len(mb.msgStatuses))
+	assert.Equal(msg.ID(), mb.msgStatuses[0].ID())
+	assert.Equal(MsgFailed, mb.msgStatuses[0].Status())
+	assert.Contains(mb.msgStatuses[0].Logs()[0].Error, "moderated: matched rule 'forbidden'")
+
+	event, err := mb.GetLastChannelEvent()
+	assert.NoError(err)
+	assert.Equal(MsgModerated, event.EventType())
+	assert.Equal("moderated", event.Extra()["reason"])
+
+	s.SetModerator(nil)
+
+	// clear our statuses
+	mb.msgStatuses = nil
+
+	// messages on channels with a translation language are translated before sending
+	s.SetTranslator(&mockTranslator{})
+	dmChannel.SetConfig(ConfigTranslationLanguage, "por")
+
+	msg = &mockMsg{
+		channel: dmChannel,
+		id:      NewMsgID(104),
+		uuid:    NilMsgUUID,
+		text:    "hello",
+		urn:     "tel:+250788383383",
+	}
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Second)
+
+	assert.Equal(1, len(mb.msgStatuses))
+	assert.Equal(MsgSent, mb.msgStatuses[0].Status())
+	assert.Equal("por: hello", msg.Text())
+
+	// clear our statuses
+	mb.msgStatuses = nil
+
+	// and what they're translated to is what's moderated
+	s.SetModerator(moderator)
+	s.SetTranslator(&mockTranslator{text: "some forbidden text"})
+
+	msg = &mockMsg{
+		channel: dmChannel,
+		id:      NewMsgID(105),
+		uuid:    NilMsgUUID,
+		text:    "hello",
+		urn:     "tel:+250788383383",
+	}
+	mb.PushOutgoingMsg(msg)
+	time.Sleep(time.Second)
+
+	assert.Equal(1, len(mb.msgStatuses))
+	assert.Equal(MsgFailed, mb.msgStatuses[0].Status())
+	assert.Contains(mb.msgStatuses[0].Logs()[0].Error, "moderated: matched rule 'forbidden'")
+
+	s.SetModerator(nil)
+	s.SetTranslator(nil)
+
 	// try to receive a message instead
 	resp, err := http.Get("http://localhost:8080/c/dm/e4bb1578-29da-4fa5-a214-9da19dd24230/receive")
 	assert.NoError(err)
@@ -72,3 +72,18 @@ func TestSplitMsgByChannel(t *testing.T) {
 	assert.Equal([]string{" "}, SplitMsgByChannel(channelWithMaxLength, " ", 20))
 	assert.Equal([]string{"This is a message", "longer than 10"}, SplitMsgByChannel(channelWithMaxLength, "This is a message   longer than 10", 20))
 }
+
+func TestStrictTelForCountry(t *testing.T) {
+	assert := assert.New(t)
+
+	urn, err := StrictTelForCountry("+250788383383", "RW")
+	assert.NoError(err)
+	assert.Equal("tel:+250788383383", urn.String())
+
+	for _, number := range []string{"++250788383383", "user😀123", "<script>alert(1)</script>"} {
+		urn, err = StrictTelForCountry(number, "")
+		if err == nil {
+			assert.NoError(urn.Validate(), "invalid urn for %s", number)
+		}
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/pkg/errors"
 )
@@ -102,9 +103,23 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
+	// messages in a discord channel are from their sender within that channel if this channel handles groups
+	group := getFormField(r.Form, "group")
+	if group != "" && courier.GroupMessagesEnabled(channel) {
+		urn, err = courier.NewGroupURN(urn, group)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		}
+	}
+
 	// build our msg
 	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date)
 
+	if courier.GroupIDForURN(urn) != "" {
+		groupInfo := &courier.MsgGroup{ID: group, Name: getFormField(r.Form, "group_name"), Sender: courier.MsgGroupSender{ID: from, Name: getFormField(r.Form, "from_name")}}
+		msg.WithMetadata(jsonx.MustMarshal(map[string]interface{}{"group": groupInfo}))
+	}
+
 	for _, attachment := range r.Form["attachments"] {
 		msg.WithAttachment(attachment)
 	}
@@ -180,6 +195,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		Channel      string   `json:"channel"`
 		Attachments  []string `json:"attachments"`
 		QuickReplies []string `json:"quick_replies"`
+		Group        string   `json:"group,omitempty"`
 	}
 
 	ourMessage := OutputMessage{
@@ -191,6 +207,11 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		QuickReplies: msg.QuickReplies(),
 	}
 
+	// messages to a group are sent to the discord channel rather than as a direct message
+	if courier.GroupMessagesEnabled(msg.Channel()) {
+		ourMessage.Group = courier.GroupIDForURN(msg.URN())
+	}
+
 	var body io.Reader
 	marshalled, err := json.Marshal(ourMessage)
 	if err != nil {
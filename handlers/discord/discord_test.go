@@ -11,6 +11,7 @@ import (
 
 func TestHandler(t *testing.T) {
 	RunChannelTestCases(t, testChannels, newHandler(), testCases)
+	RunChannelTestCases(t, testGroupChannels, newHandler(), groupTestCases)
 }
 
 func BenchmarkHandler(b *testing.B) {
@@ -47,4 +48,19 @@ func setSendURL(s *httptest.Server, h courier.ChannelHandler, c courier.Channel,
 func TestSending(t *testing.T) {
 
 	RunChannelSendTestCases(t, testChannels[0], newHandler(), sendTestCases, nil)
+	RunChannelSendTestCases(t, testGroupChannels[0], newHandler(), groupSendTestCases, nil)
+}
+
+var testGroupChannels = []courier.Channel{
+	courier.NewMockChannel("bac782c2-7aeb-4389-92f5-97887744f573", "DS", "discord", "US", map[string]interface{}{courier.ConfigGroupMessages: true}),
+}
+
+var groupTestCases = []ChannelHandleTestCase{
+	{Label: "Recieve Group Message", URL: "/c/ds/bac782c2-7aeb-4389-92f5-97887744f573/receive", Data: `from=694634743521607802&from_name=Bob&group=1012345678901234567&group_name=general&text=hello`, Status: 200, Text: Sp("hello"), URN: Sp("discord:694634743521607802?group=1012345678901234567"),
+		Metadata: Jp(map[string]interface{}{"group": map[string]interface{}{"id": "1012345678901234567", "name": "general", "sender": map[string]interface{}{"id": "694634743521607802", "name": "Bob"}}})},
+	{Label: "Recieve Direct Message", URL: "/c/ds/bac782c2-7aeb-4389-92f5-97887744f573/receive", Data: `from=694634743521607802&text=hello`, Status: 200, Text: Sp("hello"), URN: Sp("discord:694634743521607802")},
+}
+
+var groupSendTestCases = []ChannelSendTestCase{
+	{Label: "Group Send", Text: "Hello World", URN: "discord:694634743521607802?group=1012345678901234567", Path: "/discord/rp/send", ResponseStatus: 200, RequestBody: `{"id":"10","text":"Hello World","to":"694634743521607802","channel":"bac782c2-7aeb-4389-92f5-97887744f573","attachments":[],"quick_replies":null,"group":"1012345678901234567"}`, SendPrep: setSendURL},
 }
@@ -13,18 +13,17 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/tasks"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
-// Endpoints we hit
-var (
-	sendURL      = "https://graph.facebook.com/v3.3/me/messages"
-	subscribeURL = "https://graph.facebook.com/v3.3/me/subscribed_apps"
-	graphURL     = "https://graph.facebook.com/v3.3/"
+// default Graph API endpoint we hit, can be overridden per channel with base_url
+const defaultGraphURL = "https://graph.facebook.com/v3.3/"
 
+var (
 	// How long we want after the subscribe callback to register the page for events
 	subscribeTimeout = time.Second * 2
 
@@ -62,10 +61,17 @@ func init() {
 
 type handler struct {
 	handlers.BaseHandler
+	graphURL string
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("FB"), "Facebook")}
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("FB"), "Facebook"), defaultGraphURL}
+}
+
+// graphBaseURL returns the Graph API base URL to use for the passed in channel
+func (h *handler) graphBaseURL(channel courier.Channel) *url.URL {
+	base, _ := url.Parse(channel.StringConfigForKey(courier.ConfigBaseURL, h.graphURL))
+	return base
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -73,6 +79,7 @@ func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", h.receiveEvent)
 	s.AddHandlerRoute(h, http.MethodGet, "receive", h.receiveVerify)
+	s.RegisterTaskFunc(remapRefURNTask, h.remapRefURN)
 	return nil
 }
 
@@ -105,7 +112,8 @@ func (h *handler) receiveVerify(ctx context.Context, channel courier.Channel, w
 		// subscribe to messaging events for this page
 		form := url.Values{}
 		form.Set("access_token", authToken)
-		req, _ := http.NewRequest(http.MethodPost, subscribeURL, strings.NewReader(form.Encode()))
+		subscribeURL := h.graphBaseURL(channel).ResolveReference(&url.URL{Path: "me/subscribed_apps"})
+		req, _ := http.NewRequest(http.MethodPost, subscribeURL.String(), strings.NewReader(form.Encode()))
 		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 		rr, err := utils.MakeHTTPRequest(req)
 
@@ -497,7 +505,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		payload.Recipient.ID = msg.URN().Path()
 	}
 
-	msgURL, _ := url.Parse(sendURL)
+	msgURL := h.graphBaseURL(msg.Channel()).ResolveReference(&url.URL{Path: "me/messages"})
 	query := url.Values{}
 	query.Set("access_token", accessToken)
 	msgURL.RawQuery = query.Encode()
@@ -577,37 +585,12 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 					return status, nil
 				}
 
-				referralID := msg.URN().FacebookRef()
-
-				realIDURN, err := urns.NewFacebookURN(recipientID)
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to make facebook urn from %s", recipientID))
-				}
-
-				contact, err := h.Backend().GetContact(ctx, msg.Channel(), msg.URN(), "", "")
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to get contact for %s", msg.URN().String()))
-				}
-				realURN, err := h.Backend().AddURNtoContact(ctx, msg.Channel(), contact, realIDURN)
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to add real facebook URN %s to contact with uuid %s", realURN.String(), contact.UUID()))
-				}
-				referralIDExtURN, err := urns.NewURNFromParts(urns.ExternalScheme, referralID, "", "")
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to make ext urn from %s", referralID))
-				}
-				extURN, err := h.Backend().AddURNtoContact(ctx, msg.Channel(), contact, referralIDExtURN)
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to add URN %s to contact with uuid %s", extURN.String(), contact.UUID()))
-				}
-
-				referralFacebookURN, err := h.Backend().RemoveURNfromContact(ctx, msg.Channel(), contact, msg.URN())
-				if err != nil {
-					log.WithError("Message Send Error", errors.Errorf("unable to remove referral facebook URN %s from contact with uuid %s", referralFacebookURN.String(), contact.UUID()))
+				// adding the real URN to the contact is done after we've returned
+				remap := &refURNRemap{URN: msg.URN(), RecipientID: recipientID}
+				if err := handlers.EnqueueTask(ctx, h.Server(), msg.Channel(), remapRefURNTask, remap); err != nil {
+					log.WithError("Message Send Error", errors.Errorf("unable to remap referral facebook URN %s: %s", msg.URN().String(), err))
 				}
-
 			}
-
 		}
 
 		// this was wired successfully
@@ -617,6 +600,56 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 	return status, nil
 }
 
+// the task which adds the real URN of a contact we sent to by referral to them, once we know it
+const remapRefURNTask = "facebook:remap_ref_urn"
+
+type refURNRemap struct {
+	URN         urns.URN `json:"urn"`
+	RecipientID string   `json:"recipient_id"`
+}
+
+// remapRefURN adds the real URN and an ext URN of the referral to the contact of a referral URN, removing that URN
+func (h *handler) remapRefURN(ctx context.Context, task *tasks.Task) error {
+	remap := &refURNRemap{}
+	if err := json.Unmarshal(task.Payload, remap); err != nil {
+		return errors.Wrap(err, "unable to read remap")
+	}
+
+	channelUUID, err := courier.NewChannelUUID(task.ChannelUUID)
+	if err != nil {
+		return err
+	}
+	channel, err := h.Backend().GetChannel(ctx, h.ChannelType(), channelUUID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to get channel %s", task.ChannelUUID)
+	}
+
+	realIDURN, err := urns.NewFacebookURN(remap.RecipientID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to make facebook urn from %s", remap.RecipientID)
+	}
+	referralID := remap.URN.FacebookRef()
+	referralIDExtURN, err := urns.NewURNFromParts(urns.ExternalScheme, referralID, "", "")
+	if err != nil {
+		return errors.Wrapf(err, "unable to make ext urn from %s", referralID)
+	}
+
+	contact, err := h.Backend().GetContact(ctx, channel, remap.URN, "", "")
+	if err != nil {
+		return errors.Wrapf(err, "unable to get contact for %s", remap.URN.String())
+	}
+	if _, err := h.Backend().AddURNtoContact(ctx, channel, contact, realIDURN); err != nil {
+		return errors.Wrapf(err, "unable to add real facebook URN %s to contact with uuid %s", realIDURN.String(), contact.UUID())
+	}
+	if _, err := h.Backend().AddURNtoContact(ctx, channel, contact, referralIDExtURN); err != nil {
+		return errors.Wrapf(err, "unable to add URN %s to contact with uuid %s", referralIDExtURN.String(), contact.UUID())
+	}
+	if _, err := h.Backend().RemoveURNfromContact(ctx, channel, contact, remap.URN); err != nil {
+		return errors.Wrapf(err, "unable to remove referral facebook URN %s from contact with uuid %s", remap.URN.String(), contact.UUID())
+	}
+	return nil
+}
+
 // DescribeURN looks up URN metadata for new contacts
 func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn urns.URN) (map[string]string, error) {
 	// can't do anything with facebook refs, ignore them
@@ -630,7 +663,7 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	}
 
 	// build a request to lookup the stats for this contact
-	base, _ := url.Parse(graphURL)
+	base := h.graphBaseURL(channel)
 	path, _ := url.Parse(fmt.Sprintf("/%s", urn.Path()))
 	u := base.ResolveReference(path)
 
@@ -13,6 +13,7 @@ import (
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/tasks"
 	"github.com/nyaruka/gocommon/urns"
 )
 
@@ -483,16 +484,22 @@ func buildMockFBGraph(testCases []ChannelHandleTestCase) *httptest.Server {
 		// no name
 		w.Write([]byte(`{ "first_name": "", "last_name": ""}`))
 	}))
-	graphURL = server.URL
 
 	return server
 }
 
+// newGraphHandler returns a handler which makes its Graph API calls against the passed in URL
+func newGraphHandler(graphURL string) courier.ChannelHandler {
+	h := newHandler().(*handler)
+	h.graphURL = graphURL
+	return h
+}
+
 func TestDescribe(t *testing.T) {
 	fbGraph := buildMockFBGraph(testCases)
 	defer fbGraph.Close()
 
-	handler := newHandler().(courier.URNDescriber)
+	handler := newGraphHandler(fbGraph.URL).(courier.URNDescriber)
 	tcs := []struct {
 		urn      urns.URN
 		metadata map[string]string
@@ -506,6 +513,19 @@ func TestDescribe(t *testing.T) {
 	}
 }
 
+func TestRemapRefURN(t *testing.T) {
+	mb := courier.NewMockBackend()
+	mb.AddChannel(testChannels[0])
+
+	h := newHandler().(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	// failures say why the remap failed
+	task, err := tasks.NewTask(remapRefURNTask, "8eb23e93-5ecb-45ba-b726-3b064e0c568c", &refURNRemap{URN: "facebook:ref:1337", RecipientID: "abc"})
+	assert.NoError(t, err)
+	assert.EqualError(t, h.remapRefURN(context.Background(), task), "unable to make facebook urn from abc: invalid facebook id: abc")
+}
+
 func TestHandler(t *testing.T) {
 	RunChannelTestCases(t, testChannels, newHandler(), testCases)
 }
@@ -514,7 +534,7 @@ func BenchmarkHandler(b *testing.B) {
 	fbService := buildMockFBGraph(testCases)
 	defer fbService.Close()
 
-	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
+	RunChannelBenchmarks(b, testChannels, newGraphHandler(fbService.URL), testCases)
 }
 
 func TestVerify(t *testing.T) {
@@ -536,10 +556,9 @@ func TestVerify(t *testing.T) {
 		// mark that we were called
 		subscribeCalled = true
 	}))
-	subscribeURL = server.URL
 	subscribeTimeout = time.Millisecond
 
-	RunChannelTestCases(t, testChannels, newHandler(), []ChannelHandleTestCase{
+	RunChannelTestCases(t, testChannels, newGraphHandler(server.URL), []ChannelHandleTestCase{
 		{Label: "Receive Message", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Data: helloMsg, Status: 200},
 		{Label: "Verify No Mode", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive", Status: 400, Response: "unknown request"},
 		{Label: "Verify No Secret", URL: "/c/fb/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive?hub.mode=subscribe", Status: 400, Response: "token does not match secret"},
@@ -555,9 +574,9 @@ func TestVerify(t *testing.T) {
 	}
 }
 
-// setSendURL takes care of setting the send_url to our test server host
+// setSendURL takes care of setting the Graph API URL to our test server host
 func setSendURL(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
-	sendURL = s.URL
+	h.(*handler).graphURL = s.URL
 }
 
 var defaultSendTestCases = []ChannelSendTestCase{
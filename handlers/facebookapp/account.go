@@ -0,0 +1,94 @@
+package facebookapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+)
+
+// accountPayload is a webhook about an account rather than a phone number or page, e.g.
+//
+//	{
+//	  "object": "whatsapp_business_account",
+//	  "entry": [{
+//	    "id": "102290129340398",
+//	    "time": 1678900000,
+//	    "changes": [{
+//	      "field": "account_update",
+//	      "value": {"phone_number": "15550783881", "event": "ACCOUNT_VIOLATION"}
+//	    }]
+//	  }]
+//	}
+type accountPayload struct {
+	Object string `json:"object" validate:"required"`
+	Entry  []struct {
+		ID      string `json:"id"`
+		Changes []struct {
+			Field string          `json:"field"`
+			Value json.RawMessage `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+// ReceiveAccountEvents validates and decodes the changes of an account webhook, each of which is an account event
+func (h *handler) ReceiveAccountEvents(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]*courier.AccountEvent, error) {
+	err := h.validateSignature(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAccountEvents(h.ChannelType(), r)
+}
+
+// receiveAccountWebhook writes the account events of an account webhook posted to our receive route, which is where
+// Meta posts every webhook of a business account
+func (h *handler) receiveAccountWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	events, err := decodeAccountEvents(h.ChannelType(), r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, nil, w, r, err)
+	}
+
+	data := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		if err := h.Backend().WriteAccountEvent(ctx, event); err != nil {
+			return nil, courier.WriteError(ctx, w, r, err)
+		}
+		data = append(data, event)
+
+		// WhatsApp bans, restrictions and limit changes are also alerts for the channels they're about
+		if h.ChannelType() == "WAC" {
+			alerts, err := h.writeAccountEventAlerts(ctx, event)
+			if err != nil {
+				return nil, courier.WriteError(ctx, w, r, err)
+			}
+			for _, alert := range alerts {
+				data = append(data, courier.NewEventReceiveData(alert))
+			}
+		}
+	}
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Account Events Handled", data)
+}
+
+// decodeAccountEvents decodes the changes of an account webhook, each of which is an account event
+func decodeAccountEvents(channelType courier.ChannelType, r *http.Request) ([]*courier.AccountEvent, error) {
+	payload := &accountPayload{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*courier.AccountEvent, 0, len(payload.Entry))
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			events = append(events, courier.NewAccountEvent(channelType, entry.ID, change.Field, change.Value))
+		}
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("no account events found")
+	}
+	return events, nil
+}
@@ -0,0 +1,90 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+)
+
+// the interactive type of address messages, and the name of the flow replies to them come back as
+const addressMessageType = "address_message"
+
+// the countries WhatsApp supports address messages in
+var addressMessageCountries = map[string]bool{"BR": true, "IN": true}
+
+type wacSavedAddress struct {
+	ID    string          `json:"id"`
+	Value json.RawMessage `json:"value"`
+}
+
+type wacAddressParameters struct {
+	Country          string            `json:"country"`
+	Values           json.RawMessage   `json:"values,omitempty"`
+	SavedAddresses   []wacSavedAddress `json:"saved_addresses,omitempty"`
+	ValidationErrors json.RawMessage   `json:"validation_errors,omitempty"`
+}
+
+// wacAddressReply is the reply of a contact to an address message, either as an address_message of its own or as the
+// nfm_reply of a flow named address_message
+type wacAddressReply struct {
+	Body         string `json:"body,omitempty"`
+	ResponseJSON string `json:"response_json"`
+}
+
+// newAddressInteractive builds a message asking the contact for their shipping address in the passed in country
+func newAddressInteractive(body string, params *wacAddressParameters) *wacInteractive {
+	return &wacInteractive{Type: addressMessageType, Body: wacInteractiveBody{Text: body}, Action: &wacInteractiveAction{Name: addressMessageType, Parameters: params}}
+}
+
+// newMsgAddressInteractive builds the address message for the address_message in the metadata of the passed in msg,
+// which can prefill its values, offer saved addresses and show the errors of a previous reply, e.g.
+//
+//	{"interaction_type": "address", "address_message": {"country": "BR", "values": {"name": "Bob"}, "saved_addresses": [{"id": "home", "value": {...}}], "validation_errors": {"city": "Unknown city"}}}
+//
+// Its country defaults to that of the channel.
+func newMsgAddressInteractive(msg courier.Msg, body string) (*wacInteractive, error) {
+	params := &wacAddressParameters{}
+	if raw, dataType, _, _ := jsonparser.Get(msg.Metadata(), "address_message"); dataType == jsonparser.Object {
+		if err := json.Unmarshal(raw, params); err != nil {
+			return nil, fmt.Errorf("invalid address_message: %s", err)
+		}
+	}
+
+	if params.Country == "" {
+		params.Country = msg.Channel().Country()
+	}
+	params.Country = strings.ToUpper(params.Country)
+	if !addressMessageCountries[params.Country] {
+		return nil, fmt.Errorf("address messages are only supported in BR and IN, not '%s'", params.Country)
+	}
+	for _, saved := range params.SavedAddresses {
+		if saved.ID == "" || len(saved.Value) == 0 {
+			return nil, fmt.Errorf("saved addresses require an id and value")
+		}
+	}
+
+	return newAddressInteractive(body, params), nil
+}
+
+// addressReplyMetadata returns the metadata we add to replies to address messages, with the values of the address the
+// contact entered or the id of the saved address they picked, e.g.
+//
+//	{"address": {"values": {"name": "Bob", "city": "São Paulo"}, "context_id": "wamid.HBgLMTU1NTEyMzQ1NjcVAgARGBI"}}
+func addressReplyMetadata(reply *wacAddressReply, contextID string) json.RawMessage {
+	address := map[string]interface{}{}
+	response := []byte(reply.ResponseJSON)
+	if values, dataType, _, _ := jsonparser.Get(response, "values"); dataType == jsonparser.Object {
+		address["values"] = json.RawMessage(values)
+	}
+	if savedID, _ := jsonparser.GetString(response, "saved_address_id"); savedID != "" {
+		address["saved_address_id"] = savedID
+	}
+	if contextID != "" {
+		address["context_id"] = contextID
+	}
+	metadata, _ := json.Marshal(map[string]interface{}{"address": address})
+	return metadata
+}
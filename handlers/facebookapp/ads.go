@@ -0,0 +1,55 @@
+package facebookapp
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/patrickmn/go-cache"
+)
+
+// the source type of referrals from ads which click through to WhatsApp
+const adReferralSourceType = "ad"
+
+// the names of ads rarely change, so we only look them up once in a while
+const adNamesCacheExpiration = time.Hour
+
+// how long we wait before retrying the lookup of an ad whose names we couldn't get
+const adNamesFailureExpiration = 5 * time.Minute
+
+var adNamesCache = cache.New(adNamesCacheExpiration, adNamesCacheExpiration)
+
+// wacAdNames is the subset of an ad node we add to the metadata of referred msgs
+type wacAdNames struct {
+	Name     string `json:"name"`
+	Campaign struct {
+		Name string `json:"name"`
+	} `json:"campaign"`
+}
+
+// lookupAdNames looks up the names of the passed in ad and its campaign through the Marketing API, returning nil if no
+// Marketing API token is configured
+func (h *handler) lookupAdNames(channel courier.Channel, adID string) (*wacAdNames, error) {
+	token := h.Server().Config().MarketingAPIToken
+	if token == "" || adID == "" {
+		return nil, nil
+	}
+
+	if cached, found := adNamesCache.Get(adID); found {
+		return cached.(*wacAdNames), nil
+	}
+
+	u := h.graphBaseURL(channel).ResolveReference(&url.URL{Path: adID, RawQuery: url.Values{"fields": []string{"name,campaign{name}"}}.Encode()})
+
+	names := &wacAdNames{}
+	err := getGraphJSON(u.String(), token, names)
+	if err != nil {
+		// remember we failed so every msg from this ad doesn't wait on the same failing lookup
+		adNamesCache.Set(adID, (*wacAdNames)(nil), adNamesFailureExpiration)
+		return nil, fmt.Errorf("unable to look up names of ad %s: %s", adID, err)
+	}
+
+	adNamesCache.Set(adID, names, cache.DefaultExpiration)
+	return names, nil
+}
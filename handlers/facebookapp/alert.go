@@ -0,0 +1,154 @@
+package facebookapp
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+type wacBanInfo struct {
+	WabaBanState []string `json:"waba_ban_state"`
+	WabaBanDate  string   `json:"waba_ban_date"`
+}
+
+type wacRestriction struct {
+	RestrictionType string `json:"restriction_type"`
+	Expiration      string `json:"expiration"`
+}
+
+// wacAccountAlert is a warning from WhatsApp about the account of a channel, e.g. the change of an account_update like
+//
+//	{"phone_number": "15550783881", "event": "DISABLED_UPDATE", "ban_info": {"waba_ban_state": ["DISABLE"], "waba_ban_date": "2023-03-15"}}
+//
+// or of a phone_number_quality_update like
+//
+//	{"display_phone_number": "15550783881", "event": "DOWNGRADE", "current_limit": "TIER_1K"}
+type wacAccountAlert struct {
+	Field        string           `json:"field"`
+	Event        string           `json:"event,omitempty"`
+	BanState     []string         `json:"ban_state,omitempty"`
+	BanDate      string           `json:"ban_date,omitempty"`
+	CurrentLimit string           `json:"current_limit,omitempty"`
+	Restrictions []wacRestriction `json:"restrictions,omitempty"`
+}
+
+// wacAlertValue is the value of a change of an account webhook which may be an alert. Those of account_update have the
+// phone number they're about, those of phone_number_quality_update have its display number, and neither has one when
+// the change is about the whole business account.
+type wacAlertValue struct {
+	PhoneNumber        string           `json:"phone_number"`
+	DisplayPhoneNumber string           `json:"display_phone_number"`
+	Event              string           `json:"event"`
+	BanInfo            *wacBanInfo      `json:"ban_info"`
+	CurrentLimit       string           `json:"current_limit"`
+	RestrictionInfo    []wacRestriction `json:"restriction_info"`
+}
+
+// newWACAccountAlert returns the alert of the change with the passed in field and values, or nil if it has no ban,
+// messaging limit or restrictions to tell operators about
+func newWACAccountAlert(field string, event string, ban *wacBanInfo, currentLimit string, restrictions []wacRestriction) *wacAccountAlert {
+	if (ban == nil || len(ban.WabaBanState) == 0) && currentLimit == "" && len(restrictions) == 0 {
+		return nil
+	}
+
+	alert := &wacAccountAlert{Field: field, Event: event, CurrentLimit: currentLimit, Restrictions: restrictions}
+	if ban != nil {
+		alert.BanState = ban.WabaBanState
+		alert.BanDate = ban.WabaBanDate
+	}
+	return alert
+}
+
+// extra returns the extra of the channel event we write for this alert
+func (a *wacAccountAlert) extra() map[string]interface{} {
+	extra := map[string]interface{}{"field": a.Field}
+	if a.Event != "" {
+		extra["event"] = a.Event
+	}
+	if len(a.BanState) > 0 {
+		extra["ban_state"] = a.BanState
+		extra["ban_date"] = a.BanDate
+	}
+	if a.CurrentLimit != "" {
+		extra["current_limit"] = a.CurrentLimit
+	}
+	if len(a.Restrictions) > 0 {
+		extra["restrictions"] = a.Restrictions
+	}
+	return extra
+}
+
+// accountAlert is what we post to the alert webhook when WhatsApp warns about the account of a channel
+type accountAlert struct {
+	Type        string              `json:"type"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	ChannelType courier.ChannelType `json:"channel_type"`
+	*wacAccountAlert
+}
+
+// writeAccountAlert writes the passed in alert as a channel alert event of the passed in channel, and posts it to the
+// alert webhook if there is one so operators hear about bans and limit downgrades as they happen
+func (h *handler) writeAccountAlert(ctx context.Context, channel courier.Channel, alert *wacAccountAlert) (courier.ChannelEvent, error) {
+	event := h.Backend().NewChannelEvent(channel, courier.ChannelAlert, urns.NilURN).WithExtra(alert.extra())
+	if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+		return nil, err
+	}
+
+	if url := h.Server().Config().AlertWebhookURL; url != "" {
+		go courier.PostChannelAlert(url, channel.UUID(), &accountAlert{Type: "account_alert", ChannelUUID: channel.UUID(), ChannelType: channel.ChannelType(), wacAccountAlert: alert})
+	}
+	return event, nil
+}
+
+// writeAccountEventAlerts writes the alert of the passed in account event, if it is one, for each channel of its business
+// account which it's about, i.e. the channel of its phone number or all of them if it doesn't have one
+func (h *handler) writeAccountEventAlerts(ctx context.Context, accountEvent *courier.AccountEvent) ([]courier.ChannelEvent, error) {
+	value := &wacAlertValue{}
+	if err := json.Unmarshal(accountEvent.Payload, value); err != nil {
+		return nil, nil
+	}
+
+	alert := newWACAccountAlert(accountEvent.EventType, value.Event, value.BanInfo, value.CurrentLimit, value.RestrictionInfo)
+	if alert == nil {
+		return nil, nil
+	}
+
+	channels, err := h.Backend().GetChannelsByConfig(ctx, courier.ChannelType("WAC"), configWABAID, accountEvent.AccountID)
+	if err != nil {
+		return nil, err
+	}
+
+	phone := phoneDigits(value.PhoneNumber)
+	if phone == "" {
+		phone = phoneDigits(value.DisplayPhoneNumber)
+	}
+
+	events := make([]courier.ChannelEvent, 0, 1)
+	for _, channel := range channels {
+		if phone != "" && phoneDigits(channel.StringConfigForKey(configWANumber, "")) != phone {
+			continue
+		}
+
+		event, err := h.writeAccountAlert(ctx, channel, alert)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// phoneDigits returns the digits of the passed in phone number, so display numbers like +1 555-078-3881 match the
+// numbers of changes like 15550783881
+func phoneDigits(phone string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+}
@@ -0,0 +1,195 @@
+package facebookapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	businessProfileAction = "business_profile"
+
+	configDisplayName     = "display_name"
+	configQualityRating   = "quality_rating"
+	configBusinessProfile = "business_profile"
+
+	businessProfileFields = "about,address,description,email,vertical,websites"
+)
+
+// wacBusinessProfile is the business profile of a WhatsApp Cloud phone number
+type wacBusinessProfile struct {
+	About       string   `json:"about,omitempty"`
+	Address     string   `json:"address,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Email       string   `json:"email,omitempty"`
+	Vertical    string   `json:"vertical,omitempty"`
+	Websites    []string `json:"websites,omitempty"`
+}
+
+// wacPhoneNumberInfo is the subset of the phone number node we store on the channel
+type wacPhoneNumberInfo struct {
+	VerifiedName       string `json:"verified_name"`
+	QualityRating      string `json:"quality_rating"`
+	DisplayPhoneNumber string `json:"display_phone_number"`
+}
+
+// isBusinessProfileRequest returns whether the passed in request targets our business profile routes
+func isBusinessProfileRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/"+businessProfileAction)
+}
+
+// getBusinessProfileChannel looks up the channel for a business profile request, which is passed as a query param
+func (h *handler) getBusinessProfileChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	channelUUID, err := courier.NewChannelUUID(r.URL.Query().Get("channel"))
+	if err != nil {
+		return nil, courier.ErrChannelNotFound
+	}
+	return h.Backend().GetChannel(ctx, h.ChannelType(), channelUUID)
+}
+
+// checkAdminAuth checks the request against our status credentials since these routes aren't called by Meta
+func (h *handler) checkAdminAuth(r *http.Request) bool {
+	return courier.CheckAdminAuth(h.Server().Config(), r)
+}
+
+// writeAuditRecord records an admin action taken through one of our routes, failing to do so doesn't fail the action
+func (h *handler) writeAuditRecord(ctx context.Context, record *courier.AuditRecord) {
+	err := courier.WriteAuditRecord(ctx, h.Backend(), record)
+	if err != nil {
+		logrus.WithError(err).WithField("action", record.Action).Error("error writing audit record")
+	}
+}
+
+// refreshBusinessProfile fetches the display name, quality rating and business profile of the channel and stores them in its config
+func (h *handler) refreshBusinessProfile(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if !h.checkAdminAuth(r) {
+		return nil, courier.WriteAndLogUnauthorized(ctx, w, r, channel, fmt.Errorf("invalid Authorization header"))
+	}
+
+	config, err := h.fetchBusinessProfileConfig(channel)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	err = h.Backend().UpdateChannelConfig(ctx, channel, config)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	h.writeAuditRecord(ctx, courier.NewAuditRecord(h.Server().Config(), r, "business_profile_refresh", channel, nil))
+
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Business profile refreshed", []interface{}{config})
+}
+
+// updateBusinessProfile pushes the passed in business profile to the Graph API and stores it in the channel config
+func (h *handler) updateBusinessProfile(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if !h.checkAdminAuth(r) {
+		return nil, courier.WriteAndLogUnauthorized(ctx, w, r, channel, fmt.Errorf("invalid Authorization header"))
+	}
+
+	profile := &wacBusinessProfile{}
+	err := handlers.DecodeAndValidateJSON(profile, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	payload := struct {
+		MessagingProduct string `json:"messaging_product"`
+		*wacBusinessProfile
+	}{"whatsapp", profile}
+
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, h.phoneNumberURL(channel, "whatsapp_business_profile", "").String(), bytes.NewReader(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.graphToken(channel)))
+
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to update business profile: %s\n%s", err, rr.Response))
+	}
+
+	config := map[string]interface{}{configBusinessProfile: profile}
+	err = h.Backend().UpdateChannelConfig(ctx, channel, config)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	h.writeAuditRecord(ctx, courier.NewAuditRecord(h.Server().Config(), r, "business_profile_update", channel, nil))
+
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Business profile updated", []interface{}{config})
+}
+
+// fetchBusinessProfileConfig looks up the phone number and its business profile, returning the config values to store
+func (h *handler) fetchBusinessProfileConfig(channel courier.Channel) (map[string]interface{}, error) {
+	token := h.graphToken(channel)
+
+	phone := &wacPhoneNumberInfo{}
+	err := getGraphJSON(h.phoneNumberURL(channel, "", "verified_name,quality_rating,display_phone_number").String(), token, phone)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up phone number: %s", err)
+	}
+
+	profiles := &struct {
+		Data []wacBusinessProfile `json:"data"`
+	}{}
+	err = getGraphJSON(h.phoneNumberURL(channel, "whatsapp_business_profile", businessProfileFields).String(), token, profiles)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up business profile: %s", err)
+	}
+
+	profile := wacBusinessProfile{}
+	if len(profiles.Data) > 0 {
+		profile = profiles.Data[0]
+	}
+
+	return map[string]interface{}{
+		configDisplayName:     phone.VerifiedName,
+		configQualityRating:   phone.QualityRating,
+		configBusinessProfile: profile,
+	}, nil
+}
+
+// graphToken returns the token to use for Graph API calls on behalf of the channel
+func (h *handler) graphToken(channel courier.Channel) string {
+	userAccessToken := channel.StringConfigForKey(courier.ConfigUserToken, "")
+	if userAccessToken != "" {
+		return userAccessToken
+	}
+	return h.Server().Config().WhatsappAdminSystemUserToken
+}
+
+// phoneNumberURL builds the Graph URL of the channel's phone number or one of its edges, requesting the passed in fields
+func (h *handler) phoneNumberURL(channel courier.Channel, edge string, fields string) *url.URL {
+	base := h.graphBaseURL(channel)
+	path, _ := url.Parse(strings.TrimSuffix(fmt.Sprintf("%s/%s", channel.Address(), edge), "/"))
+	u := base.ResolveReference(path)
+	if fields != "" {
+		u.RawQuery = url.Values{"fields": []string{fields}}.Encode()
+	}
+	return u
+}
+
+// getGraphJSON makes a GET request against the Graph API and decodes the JSON response into the passed in value
+func getGraphJSON(u string, token string, v interface{}) error {
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return fmt.Errorf("%s\n%s", err, rr.Response)
+	}
+	return json.Unmarshal(rr.Body, v)
+}
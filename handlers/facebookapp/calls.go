@@ -0,0 +1,160 @@
+package facebookapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+const (
+	callsAction = "calls"
+
+	// channel config key to enable the business calling API
+	configCalling = "calling"
+
+	// direction of calls made by the contact to the business
+	userInitiatedCall = "USER_INITIATED"
+)
+
+// the channel event we write for each call event
+var wacCallEventTypes = map[string]courier.ChannelEventType{
+	"connect":   courier.CallConnect,
+	"terminate": courier.CallTerminate,
+}
+
+type wacCallSession struct {
+	SDPType string `json:"sdp_type"`
+	SDP     string `json:"sdp"`
+}
+
+// wacCall is an event of a call made through the business calling API, e.g.
+//
+//	{
+//	  "id": "wacid.ABGGFjFVU2AfAgo6V",
+//	  "to": "12345",
+//	  "from": "5678",
+//	  "event": "terminate",
+//	  "direction": "USER_INITIATED",
+//	  "timestamp": "1671644824",
+//	  "status": "Completed",
+//	  "start_time": "1671644824",
+//	  "end_time": "1671644944",
+//	  "duration": 120
+//	}
+type wacCall struct {
+	ID        string          `json:"id"`
+	To        string          `json:"to"`
+	From      string          `json:"from"`
+	Event     string          `json:"event"`
+	Direction string          `json:"direction"`
+	Timestamp string          `json:"timestamp"`
+	Status    string          `json:"status"`
+	StartTime string          `json:"start_time"`
+	EndTime   string          `json:"end_time"`
+	Duration  int             `json:"duration"`
+	Session   *wacCallSession `json:"session"`
+}
+
+// contact returns the WhatsApp ID of the contact on the other end of the call
+func (c *wacCall) contact() string {
+	if c.Direction == userInitiatedCall {
+		return c.From
+	}
+	return c.To
+}
+
+// wacCallAction is an action taken on a call by the business, e.g. accepting it with the SDP answer of our media server
+type wacCallAction struct {
+	CallID  string          `json:"call_id" validate:"required"`
+	Action  string          `json:"action" validate:"required,oneof=pre_accept accept reject terminate"`
+	Session *wacCallSession `json:"session,omitempty"`
+}
+
+// isCallsRequest returns whether the passed in request targets our calls route
+func isCallsRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/"+callsAction)
+}
+
+// writeCallEvent writes the passed in call event as a channel event, returning nil if it's an event we don't handle
+func (h *handler) writeCallEvent(ctx context.Context, channel courier.Channel, call *wacCall, contactName string) (courier.ChannelEvent, error) {
+	eventType, found := wacCallEventTypes[call.Event]
+	if !found {
+		return nil, nil
+	}
+
+	ts, err := strconv.ParseInt(call.Timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %s", call.Timestamp)
+	}
+
+	urn, err := urns.NewWhatsAppURN(call.contact())
+	if err != nil {
+		return nil, err
+	}
+
+	extra := map[string]interface{}{
+		"call_id":   call.ID,
+		"direction": call.Direction,
+	}
+	if call.Event == "terminate" {
+		extra["status"] = call.Status
+		extra["duration"] = call.Duration
+	}
+	if call.Session != nil {
+		extra["sdp_type"] = call.Session.SDPType
+		extra["sdp"] = call.Session.SDP
+	}
+
+	event := h.Backend().NewChannelEvent(channel, eventType, urn).WithOccurredOn(time.Unix(ts, 0).UTC()).WithContactName(contactName).WithExtra(extra)
+	return event, h.Backend().WriteChannelEvent(ctx, event)
+}
+
+// handleCall accepts, rejects or terminates a call on the channel through the Graph API
+func (h *handler) handleCall(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if !h.checkAdminAuth(r) {
+		return nil, courier.WriteAndLogUnauthorized(ctx, w, r, channel, fmt.Errorf("invalid Authorization header"))
+	}
+
+	if !channel.BoolConfigForKey(configCalling, false) {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("calling not enabled for channel"))
+	}
+
+	action := &wacCallAction{}
+	err := handlers.DecodeAndValidateJSON(action, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	payload := struct {
+		MessagingProduct string `json:"messaging_product"`
+		*wacCallAction
+	}{"whatsapp", action}
+
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, h.phoneNumberURL(channel, callsAction, "").String(), bytes.NewReader(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", h.graphToken(channel)))
+
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unable to %s call: %s\n%s", action.Action, err, rr.Response))
+	}
+
+	h.writeAuditRecord(ctx, courier.NewAuditRecord(h.Server().Config(), r, "call_"+action.Action, channel, []string{action.CallID}))
+
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Call action sent", []interface{}{map[string]string{"call_id": action.CallID, "action": action.Action}})
+}
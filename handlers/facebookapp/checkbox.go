@@ -0,0 +1,77 @@
+package facebookapp
+
+import (
+	"context"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+)
+
+// the source of prior messages sent to contacts who opted in with the checkbox plugin
+const priorMessageCheckboxSource = "checkbox_plugin"
+
+// priorMessage is what Messenger tells us of the msg a contact is replying to when it wasn't sent to their real id, e.g.
+//
+//	{"source": "checkbox_plugin", "identifier": "a9f8c1c0-3e4a-4d0b-9f7e-2b4b1d2d6f3e"}
+type priorMessage struct {
+	Source     string `json:"source"`
+	Identifier string `json:"identifier"`
+}
+
+// checkboxUserRef returns the user_ref of the checkbox plugin opt in a reply is to, or an empty string if it isn't one
+func (m *priorMessage) checkboxUserRef() string {
+	if m == nil || m.Source != priorMessageCheckboxSource {
+		return ""
+	}
+	return m.Identifier
+}
+
+// mergeCheckboxContact merges the real URN of a contact who opted in with the checkbox plugin into the contact of their
+// user_ref URN, along with an ext URN of the user_ref, and removes the user_ref URN so they don't end up as two
+// contacts. It returns the contact_merged event recording the merge, or nil if there's nothing to merge because the
+// user_ref URN has no contact, e.g. as it's been merged already, or the real URN is already theirs.
+func (h *handler) mergeCheckboxContact(ctx context.Context, channel courier.Channel, userRef string, urn urns.URN, date time.Time) (courier.ChannelEvent, error) {
+	refURN, err := urns.NewFacebookURN(urns.FacebookRefPrefix + userRef)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to make facebook ref urn from %s", userRef)
+	}
+	refExtURN, err := urns.NewURNFromParts(urns.ExternalScheme, userRef, "", "")
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to make ext urn from %s", userRef)
+	}
+
+	contact, err := h.Backend().LookupContact(ctx, channel, refURN)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up contact for %s", refURN.String())
+	}
+	if contact == nil {
+		return nil, nil
+	}
+
+	owner, err := h.Backend().LookupContact(ctx, channel, urn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up contact for %s", urn.String())
+	}
+	if owner != nil && owner.UUID() == contact.UUID() {
+		return nil, nil
+	}
+	if _, err := h.Backend().AddURNtoContact(ctx, channel, contact, urn); err != nil {
+		return nil, errors.Wrapf(err, "unable to add facebook URN %s to contact with uuid %s", urn.String(), contact.UUID())
+	}
+	if _, err := h.Backend().AddURNtoContact(ctx, channel, contact, refExtURN); err != nil {
+		return nil, errors.Wrapf(err, "unable to add URN %s to contact with uuid %s", refExtURN.String(), contact.UUID())
+	}
+	if _, err := h.Backend().RemoveURNfromContact(ctx, channel, contact, refURN); err != nil {
+		return nil, errors.Wrapf(err, "unable to remove facebook ref URN %s from contact with uuid %s", refURN.String(), contact.UUID())
+	}
+
+	event := h.Backend().NewChannelEvent(channel, courier.ContactMerged, urn).WithOccurredOn(date)
+	event = event.WithExtra(map[string]interface{}{"user_ref": userRef})
+
+	if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
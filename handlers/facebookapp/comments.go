@@ -0,0 +1,142 @@
+package facebookapp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+)
+
+// the metadata keys of the Instagram comments we receive, replies to msgs with an ig_comment_id are threaded under
+// that comment
+const (
+	igCommentIDKey       = "ig_comment_id"
+	igParentCommentIDKey = "ig_parent_comment_id"
+	igMediaIDKey         = "ig_media_id"
+	igUsernameKey        = "ig_username"
+)
+
+// the most characters Instagram allows in a comment
+const maxCommentLength = 2200
+
+type igCommentFrom struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type igCommentMedia struct {
+	ID               string `json:"id"`
+	MediaProductType string `json:"media_product_type"`
+}
+
+// igComment is a comment on the media of an Instagram account, e.g.
+//
+//	{
+//	  "id": "17865799348089039",
+//	  "parent_id": "17858893269000001",
+//	  "text": "How much is this?",
+//	  "from": {"id": "5678", "username": "bob"},
+//	  "media": {"id": "17866372834052316", "media_product_type": "FEED"}
+//	}
+type igComment struct {
+	ID       string
+	ParentID string
+	Text     string
+	From     *igCommentFrom
+	Media    *igCommentMedia
+}
+
+// metadata returns the metadata of the msg we receive for the passed in comment, which replies can be threaded with
+func (c *igComment) metadata() json.RawMessage {
+	metadata := map[string]string{igCommentIDKey: c.ID, igUsernameKey: c.From.Username}
+	if c.ParentID != "" {
+		metadata[igParentCommentIDKey] = c.ParentID
+	}
+	if c.Media != nil {
+		metadata[igMediaIDKey] = c.Media.ID
+	}
+	encoded, _ := json.Marshal(metadata)
+	return encoded
+}
+
+// receiveComment writes the passed in comment as an incoming msg from its author
+func (h *handler) receiveComment(ctx context.Context, channel courier.Channel, comment *igComment, date time.Time) (courier.Msg, error) {
+	urn, err := urns.NewInstagramURN(comment.From.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ev := h.Backend().NewIncomingMsg(channel, urn, comment.Text).WithExternalID(comment.ID).WithReceivedOn(date).WithContactName(comment.From.Username)
+	ev.WithMetadata(comment.metadata())
+	event := h.Backend().CheckExternalIDSeen(ev)
+
+	handlers.TranslateIncomingMsg(ctx, h.Server(), event)
+
+	if err := h.Backend().WriteMsg(ctx, event); err != nil {
+		return nil, err
+	}
+
+	h.Backend().WriteExternalIDSeen(event)
+	return event, nil
+}
+
+// replyToCommentID returns the id of the Instagram comment the passed in msg replies to, if any
+func replyToCommentID(msg courier.Msg) string {
+	if msg.Metadata() == nil {
+		return ""
+	}
+	commentID, _ := jsonparser.GetString(msg.Metadata(), igCommentIDKey)
+	return commentID
+}
+
+// sendCommentReply sends the passed in msg as replies to the passed in comment, with any attachments as links as
+// comments can only be text
+func (h *handler) sendCommentReply(msg courier.Msg, commentID string, accessToken string) (courier.MsgStatus, error) {
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	replyURL := h.graphBaseURL(msg.Channel()).ResolveReference(&url.URL{Path: url.PathEscape(commentID) + "/replies"})
+	query := url.Values{}
+	query.Set("access_token", accessToken)
+	replyURL.RawQuery = query.Encode()
+
+	for _, part := range handlers.SplitMsgByChannel(msg.Channel(), handlers.GetTextAndAttachments(msg), maxCommentLength) {
+		jsonBody, err := json.Marshal(map[string]string{"message": part})
+		if err != nil {
+			return status, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, replyURL.String(), bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+
+		rr, err := h.makeGraphRequest(msg, status, req)
+
+		log := courier.NewChannelLogFromRR("Comment Reply Sent", msg.Channel(), msg.ID(), rr).WithError("Comment Reply Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		externalID, err := jsonparser.GetString(rr.Body, "id")
+		if err != nil {
+			log.WithError("Comment Reply Error", errors.Errorf("unable to get id from body"))
+			return status, nil
+		}
+
+		status.AddPart(externalID, courier.MsgWired)
+		status.SetStatus(courier.MsgWired)
+	}
+
+	return status, nil
+}
@@ -0,0 +1,141 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fields of the payloads Meta currently sends us which we knowingly don't decode, anything else in a sample which
+// our moPayload doesn't have a field for fails TestPayloadContract so that changes to the webhooks get looked at
+var ignoredPayloadFields = map[string]string{
+	"entry.changes.value.statuses.conversation.expiration_timestamp": "we don't track conversation windows",
+}
+
+func TestPayloadContract(t *testing.T) {
+	known := knownPayloadFields(reflect.TypeOf(moPayload{}), "")
+
+	// fields we've since started decoding shouldn't be ignored
+	for field := range ignoredPayloadFields {
+		assert.False(t, known[field], "%s is decoded, remove it from the ignored fields", field)
+	}
+
+	samples, err := filepath.Glob("./testdata/contract/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, samples)
+
+	for _, sample := range samples {
+		body := courier.ReadFile(sample)
+
+		raw := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(body, &raw), "%s: invalid sample", sample)
+
+		unknown := make([]string, 0)
+		for _, field := range sampleFields(raw, "") {
+			if !isIgnoredField(field) && !isKnownField(known, field) {
+				unknown = append(unknown, field)
+			}
+		}
+		assert.Empty(t, unknown, "%s: fields our payload doesn't decode, handle or ignore them", sample)
+
+		// and the sample must still decode and validate
+		r := httptest.NewRequest(http.MethodPost, "/c/wac/receive", strings.NewReader(string(body)))
+		assert.NoError(t, handlers.DecodeAndValidateJSON(&moPayload{}, r), "%s: doesn't decode", sample)
+	}
+}
+
+// knownPayloadFields returns the paths of the fields of the passed in type, ending in .* if anything below it is decoded
+func knownPayloadFields(t reflect.Type, prefix string) map[string]bool {
+	fields := make(map[string]bool)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		if t.Kind() == reflect.Map || t.Kind() == reflect.Interface {
+			fields[prefix+".*"] = true
+		}
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+		fields[path] = true
+		for sub := range knownPayloadFields(f.Type, path) {
+			fields[sub] = true
+		}
+	}
+	return fields
+}
+
+// sampleFields returns the paths of all the fields in the passed in decoded JSON, sorted
+func sampleFields(value interface{}, prefix string) []string {
+	fields := make([]string, 0)
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			fields = append(fields, path)
+			fields = append(fields, sampleFields(child, path)...)
+		}
+	case []interface{}:
+		for _, child := range v {
+			fields = append(fields, sampleFields(child, prefix)...)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+// isKnownField returns whether the passed in path or one of its parents that decodes anything below it is known
+func isKnownField(known map[string]bool, path string) bool {
+	if known[path] {
+		return true
+	}
+	for i := strings.LastIndex(path, "."); i > 0; i = strings.LastIndex(path[:i], ".") {
+		if known[path[:i]+".*"] {
+			return true
+		}
+	}
+	return false
+}
+
+// isIgnoredField returns whether the passed in path or one of its parents is a field we knowingly don't decode
+func isIgnoredField(path string) bool {
+	for {
+		if _, ignored := ignoredPayloadFields[path]; ignored {
+			return true
+		}
+		i := strings.LastIndex(path, ".")
+		if i < 0 {
+			return false
+		}
+		path = path[:i]
+	}
+}
@@ -0,0 +1,230 @@
+package facebookapp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/librato"
+	"github.com/sirupsen/logrus"
+)
+
+// redis hash of the types of Meta webhook payloads we didn't recognize on a day, so that changes to their API show up
+// before customers notice them, fields are channel_uuid|kind|type and values how many times each was seen
+const unknownTypesKeyPattern = "meta_unknown_types:%s"
+
+// how long we keep the counts of a day
+const unknownTypesExpiration = 7 * 24 * time.Hour
+
+// redis set of the channels whose summary of a day has been posted, so each is only posted once by any of our instances
+// and those which failed are posted on the next check
+const unknownTypesSummarizedKeyPattern = "meta_unknown_types_alerted:%s"
+
+// how often we check whether yesterday's counts need summarizing
+var unknownTypesSummaryInterval = time.Hour
+
+// the kinds of things we count unknown types of
+const (
+	unknownKindMessage   = "message"   // the type of a WhatsApp message
+	unknownKindMessaging = "messaging" // the fields of a Messenger or Instagram messaging entry
+)
+
+// trackUnknownType counts that we received something of a type we don't know on the passed in channel, failures are
+// only logged as this is only used to watch for changes to the webhooks Meta sends us
+func (h *handler) trackUnknownType(channel courier.Channel, kind string, typ string) {
+	librato.Gauge(fmt.Sprintf("courier.meta_unknown_%s_%s", kind, channel.ChannelType()), float64(1))
+
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(unknownTypesKeyPattern, time.Now().UTC().Format("2006-01-02"))
+	rc.Send("MULTI")
+	rc.Send("HINCRBY", key, fmt.Sprintf("%s|%s|%s", channel.UUID(), kind, typ), 1)
+	rc.Send("EXPIRE", key, int(unknownTypesExpiration/time.Second))
+	_, err := rc.Do("EXEC")
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error counting unknown webhook type")
+	}
+}
+
+// unknownMessagingType returns the type of the messaging entry of the passed in entry of a Messenger or Instagram
+// webhook, i.e. its fields other than the sender, recipient and timestamp all entries have
+func unknownMessagingType(r *http.Request, entry int) string {
+	body, err := handlers.ReadBody(r, 1000000)
+	if err != nil {
+		return "unknown"
+	}
+
+	payload := &struct {
+		Entry []struct {
+			Messaging []map[string]json.RawMessage `json:"messaging"`
+		} `json:"entry"`
+	}{}
+	if err := json.Unmarshal(body, payload); err != nil || entry >= len(payload.Entry) || len(payload.Entry[entry].Messaging) == 0 {
+		return "unknown"
+	}
+
+	fields := make([]string, 0, 1)
+	for field := range payload.Entry[entry].Messaging[0] {
+		if field != "sender" && field != "recipient" && field != "timestamp" {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// unknownTypeCount is how many times we received something of a type we don't know on a day
+type unknownTypeCount struct {
+	Kind  string `json:"kind"`
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// unknownTypesAlert is what we post to our alert webhook for each channel which received types we don't know on a day,
+// e.g.
+//
+//	{
+//	  "type": "unknown_types",
+//	  "channel_uuid": "8eb23e93-5ecb-45ba-b726-3b064e0c568c",
+//	  "channel_type": "WAC",
+//	  "day": "2024-03-08",
+//	  "types": [{"kind": "message", "type": "ad", "count": 3}]
+//	}
+type unknownTypesAlert struct {
+	Type        string              `json:"type"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	ChannelType courier.ChannelType `json:"channel_type"`
+	Day         string              `json:"day"`
+	Types       []*unknownTypeCount `json:"types"`
+}
+
+// startUnknownTypesSummarizer starts a goroutine which posts the summaries of the unknown types of each day to our
+// alert webhook once that day is over, until our server is stopped. Our alert webhook can be reloaded, so it's checked
+// each time rather than here.
+func (h *handler) startUnknownTypesSummarizer() {
+	s := h.Server()
+
+	h.summarizer.Do(func() {
+		s.WaitGroup().Add(1)
+		go func() {
+			defer s.WaitGroup().Done()
+
+			for delay := time.Minute; ; delay = unknownTypesSummaryInterval {
+				select {
+				case <-s.StopChan():
+					return
+				case <-time.After(delay):
+					alertURL := s.Config().AlertWebhookURL
+					if alertURL == "" {
+						continue
+					}
+
+					day := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+					if err := h.summarizeUnknownTypes(context.Background(), day, alertURL); err != nil {
+						logrus.WithError(err).WithField("day", day).Error("error summarizing unknown webhook types")
+					}
+				}
+			}
+		}()
+	})
+}
+
+// summarizeUnknownTypes posts an alert to the passed in URL for each channel of our type which received types we don't
+// know on the passed in day, unless they've already been posted
+func (h *handler) summarizeUnknownTypes(ctx context.Context, day string, alertURL string) error {
+	summarizedKey := fmt.Sprintf(unknownTypesSummarizedKeyPattern, day)
+
+	counts, summarized, err := h.unknownTypesToSummarize(day)
+	if err != nil {
+		return err
+	}
+
+	// group our counts by channel
+	byChannel := make(map[string][]*unknownTypeCount)
+	for field, count := range counts {
+		parts := strings.SplitN(field, "|", 3)
+		if len(parts) != 3 || summarized[parts[0]] {
+			continue
+		}
+		byChannel[parts[0]] = append(byChannel[parts[0]], &unknownTypeCount{Kind: parts[1], Type: parts[2], Count: count})
+	}
+
+	for uuid, types := range byChannel {
+		channelUUID, err := courier.NewChannelUUID(uuid)
+		if err != nil {
+			continue
+		}
+		channel, err := h.Backend().GetChannel(ctx, courier.AnyChannelType, channelUUID)
+		if err == courier.ErrChannelNotFound {
+			h.markUnknownTypesSummarized(summarizedKey, uuid)
+			continue
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", uuid).Error("error loading channel to summarize unknown webhook types")
+			continue
+		}
+
+		// each of our handlers summarizes the channels of its own type
+		if channel.ChannelType() != h.ChannelType() {
+			continue
+		}
+
+		sort.Slice(types, func(i, j int) bool {
+			if types[i].Count != types[j].Count {
+				return types[i].Count > types[j].Count
+			}
+			return types[i].Kind+types[i].Type < types[j].Kind+types[j].Type
+		})
+		if err := courier.PostChannelAlert(alertURL, channelUUID, &unknownTypesAlert{Type: "unknown_types", ChannelUUID: channelUUID, ChannelType: channel.ChannelType(), Day: day, Types: types}); err != nil {
+			continue
+		}
+		h.markUnknownTypesSummarized(summarizedKey, uuid)
+	}
+	return nil
+}
+
+// unknownTypesToSummarize returns the counts of the unknown types of the passed in day and the channels whose summary
+// of them has already been posted
+func (h *handler) unknownTypesToSummarize(day string) (map[string]int, map[string]bool, error) {
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	counts, err := redis.IntMap(rc.Do("HGETALL", fmt.Sprintf(unknownTypesKeyPattern, day)))
+	if err != nil {
+		return nil, nil, err
+	}
+	uuids, err := redis.Strings(rc.Do("SMEMBERS", fmt.Sprintf(unknownTypesSummarizedKeyPattern, day)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	summarized := make(map[string]bool, len(uuids))
+	for _, uuid := range uuids {
+		summarized[uuid] = true
+	}
+	return counts, summarized, nil
+}
+
+// markUnknownTypesSummarized records that the summary of the channel with the passed in UUID has been posted
+func (h *handler) markUnknownTypesSummarized(summarizedKey string, uuid string) {
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	rc.Send("SADD", summarizedKey, uuid)
+	rc.Send("EXPIRE", summarizedKey, int(unknownTypesExpiration/time.Second))
+	if _, err := rc.Do("EXEC"); err != nil {
+		logrus.WithError(err).WithField("channel_uuid", uuid).Error("error marking unknown webhook types summarized")
+	}
+}
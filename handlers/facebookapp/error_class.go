@@ -0,0 +1,55 @@
+package facebookapp
+
+import (
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+)
+
+// the classes of the Graph API error codes we know, besides those of throttling which are all rate limits, see
+// https://developers.facebook.com/docs/whatsapp/cloud-api/support/error-codes
+var graphErrorClasses = map[int]courier.ErrorClass{
+	1:      courier.ErrorClassVendor5xx,        // unknown API error
+	2:      courier.ErrorClassVendor5xx,        // temporary API service error
+	10:     courier.ErrorClassAuth,             // permission denied
+	190:    courier.ErrorClassAuth,             // access token expired
+	200:    courier.ErrorClassAuth,             // permission not granted
+	368:    courier.ErrorClassContentRejected,  // temporarily blocked for policy violations
+	131000: courier.ErrorClassVendor5xx,        // something went wrong
+	131005: courier.ErrorClassAuth,             // access denied
+	131008: courier.ErrorClassContentRejected,  // required parameter missing
+	131009: courier.ErrorClassContentRejected,  // parameter value invalid
+	131016: courier.ErrorClassVendor5xx,        // service unavailable
+	131021: courier.ErrorClassInvalidRecipient, // recipient can't be the sender
+	131026: courier.ErrorClassInvalidRecipient, // message undeliverable
+	131030: courier.ErrorClassInvalidRecipient, // recipient not in allowed list
+	131031: courier.ErrorClassAuth,             // business account locked
+	131047: courier.ErrorClassContentRejected,  // re-engagement message outside the 24 hour window
+	131050: courier.ErrorClassInvalidRecipient, // recipient stopped marketing messages
+	131051: courier.ErrorClassContentRejected,  // unsupported message type
+	132000: courier.ErrorClassContentRejected,  // template parameter count mismatch
+	132001: courier.ErrorClassContentRejected,  // template doesn't exist
+	132005: courier.ErrorClassContentRejected,  // template hydrated text too long
+	132007: courier.ErrorClassContentRejected,  // template format character policy violated
+	132012: courier.ErrorClassContentRejected,  // template parameter format mismatch
+	132015: courier.ErrorClassContentRejected,  // template paused
+	132016: courier.ErrorClassContentRejected,  // template disabled
+}
+
+// graphErrorClass returns the class of the passed in Graph API error code, or NilErrorClass if we don't know it
+func graphErrorClass(code int) courier.ErrorClass {
+	if throttleErrorCodes[code] {
+		return courier.ErrorClassRateLimit
+	}
+	return graphErrorClasses[code]
+}
+
+// graphResponseErrorClass returns the class of the error in the passed in Graph API response, or NilErrorClass if it
+// didn't fail or we don't know its error code, in which case its HTTP status is all we have to go on
+func graphResponseErrorClass(rr *utils.RequestResponse, err error) courier.ErrorClass {
+	if err == nil || rr == nil {
+		return courier.NilErrorClass
+	}
+	code, _ := jsonparser.GetInt(rr.Body, "error", "code")
+	return graphErrorClass(int(code))
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
@@ -16,13 +17,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/buger/jsonparser"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/billing"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/metadata"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/rcache"
 	"github.com/nyaruka/gocommon/urns"
@@ -30,11 +34,11 @@ import (
 	"github.com/pkg/errors"
 )
 
-// Endpoints we hit
-var (
-	sendURL  = "https://graph.facebook.com/v12.0/me/messages"
-	graphURL = "https://graph.facebook.com/v12.0/"
+// default Graph API host we hit, the version we call comes from config and the whole URL can be overridden per channel
+// with base_url
+const defaultGraphURL = "https://graph.facebook.com/"
 
+var (
 	signatureHeader = "X-Hub-Signature"
 
 	// max for the body
@@ -85,6 +89,16 @@ const (
 
 var failedMediaCache *cache.Cache
 
+// channel config key to enable storing echoes and history synced from a business app in coexistence mode
+const configCoexistence = "coexistence"
+
+// channel config key for the text we reply with when a contact sends a message type we don't support
+const configUnsupportedReply = "unsupported_msg_reply"
+
+// the key we mark the unsupported msgs we've replied to with, and how long we remember them for
+const unsupportedReplyKey = "unsupported_reply:%s:%s"
+const unsupportedReplyTTL = 60 * 60 * 24
+
 const (
 	InteractiveProductSingleType         = "product"
 	InteractiveProductListType           = "product_list"
@@ -93,7 +107,7 @@ const (
 )
 
 func newHandler(channelType courier.ChannelType, name string, useUUIDRoutes bool) courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandlerWithParams(channelType, name, useUUIDRoutes)}
+	return &handler{BaseHandler: handlers.NewBaseHandlerWithParams(channelType, name, useUUIDRoutes), graphURL: defaultGraphURL}
 }
 
 func init() {
@@ -106,16 +120,59 @@ func init() {
 
 type handler struct {
 	handlers.BaseHandler
+	graphURL string
+
+	// each server's copy of us posts the summaries of the unknown types of the channels of our type
+	summarizer sync.Once
+}
+
+// graphBaseURL returns the Graph API base URL to use for the passed in channel, a base_url set on the channel is used
+// as is and so should include the version
+func (h *handler) graphBaseURL(channel courier.Channel) *url.URL {
+	if baseURL := channel.StringConfigForKey(courier.ConfigBaseURL, ""); baseURL != "" {
+		base, _ := url.Parse(baseURL)
+		return base
+	}
+	return h.graphVersionURL(h.graphAPIVersion(channel))
 }
 
 // Initialize is called by the engine once everything is loaded
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
+	h.warnGraphVersion(time.Now())
+	h.startUnknownTypesSummarizer()
 	s.AddHandlerRoute(h, http.MethodGet, "receive", h.receiveVerify)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", h.receiveEvent)
+	if h.ChannelType() == "WAC" {
+		s.AddHandlerRoute(h, http.MethodPost, businessProfileAction, h.refreshBusinessProfile)
+		s.AddHandlerRoute(h, http.MethodPatch, businessProfileAction, h.updateBusinessProfile)
+		s.AddHandlerRoute(h, http.MethodPost, onboardAction, h.onboard)
+		s.AddHandlerRoute(h, http.MethodPost, callsAction, h.handleCall)
+	}
 	return nil
 }
 
+// ConfigSpec describes the config keys of WhatsApp Cloud, Facebook and Instagram channels
+func (h *handler) ConfigSpec() []courier.ConfigField {
+	if h.ChannelType() != "WAC" {
+		return []courier.ConfigField{
+			{Key: courier.ConfigAuthToken, Label: "Page Access Token", Required: true, Secret: true},
+			{Key: "webhook", Label: "Webhook", Help: "Where received events are mirrored to, with optional filters and secret"},
+		}
+	}
+	return []courier.ConfigField{
+		{Key: courier.ConfigUserToken, Label: "User Access Token", Help: "Used instead of the system user token when set", Secret: true},
+		{Key: configWABAID, Label: "WhatsApp Business Account ID"},
+		{Key: configGraphAPIVersion, Label: "Graph API Version", Help: "Overrides the Graph API version msgs are sent with"},
+		{Key: courier.ConfigBaseURL, Label: "Base URL", Help: "Overrides the Graph API URL msgs are sent to"},
+		{Key: configCoexistence, Label: "Coexistence", Help: "Whether msgs sent from the WhatsApp Business app are synced"},
+		{Key: configCalling, Label: "Calling", Help: "Whether calls are received"},
+		{Key: configUnsupportedReply, Label: "Unsupported Message Reply", Help: "Text sent to contacts who send msg types we can't handle"},
+		{Key: courier.ConfigMarkAsRead, Label: "Mark As Read", Help: "Whether received msgs are marked as read"},
+		{Key: "webhook", Label: "Webhook", Help: "Where received events are mirrored to, with optional filters and secret"},
+	}
+}
+
 type Sender struct {
 	ID      string `json:"id"`
 	UserRef string `json:"user_ref,omitempty"`
@@ -158,6 +215,27 @@ type wacSticker struct {
 	SHA256   string `json:"sha256"`
 }
 
+// wacSyncedMsg is a message synced to us from a business app running in coexistence mode, either
+// an echo of a message sent from the phone or a message from the history sync
+type wacSyncedMsg struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Timestamp string `json:"timestamp"`
+	Type      string `json:"type"`
+	Text      struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Image          *wacMedia   `json:"image"`
+	Audio          *wacMedia   `json:"audio"`
+	Video          *wacMedia   `json:"video"`
+	Document       *wacMedia   `json:"document"`
+	Sticker        *wacSticker `json:"sticker"`
+	HistoryContext *struct {
+		Status string `json:"status"`
+	} `json:"history_context"`
+}
+
 type moPayload struct {
 	Object string `json:"object"`
 	Entry  []struct {
@@ -197,11 +275,16 @@ type moPayload struct {
 					Document *wacMedia   `json:"document"`
 					Voice    *wacMedia   `json:"voice"`
 					Sticker  *wacSticker `json:"sticker"`
+					Reaction *struct {
+						MessageID string `json:"message_id"`
+						Emoji     string `json:"emoji"`
+					} `json:"reaction"`
 					Location *struct {
-						Latitude  float64 `json:"latitude"`
-						Longitude float64 `json:"longitude"`
-						Name      string  `json:"name"`
-						Address   string  `json:"address"`
+						Latitude   float64 `json:"latitude"`
+						Longitude  float64 `json:"longitude"`
+						Name       string  `json:"name"`
+						Address    string  `json:"address"`
+						LivePeriod int     `json:"live_period"`
 					} `json:"location"`
 					Button *struct {
 						Text    string `json:"text"`
@@ -219,8 +302,13 @@ type moPayload struct {
 						} `json:"list_reply,omitempty"`
 						NFMReply struct {
 							Name         string `json:"name,omitempty"`
+							Body         string `json:"body,omitempty"`
 							ResponseJSON string `json:"response_json"`
 						} `json:"nfm_reply"`
+						AddressMessage  *wacAddressReply `json:"address_message,omitempty"`
+						LocationRequest struct {
+							Status string `json:"status"`
+						} `json:"location_request_message"`
 					} `json:"interactive,omitempty"`
 					Contacts []struct {
 						Name struct {
@@ -242,6 +330,10 @@ type moPayload struct {
 						SourceURL  string    `json:"source_url"`
 						Image      *wacMedia `json:"image"`
 						Video      *wacMedia `json:"video"`
+
+						// looked up by us rather than sent by Meta
+						AdName       string `json:"ad_name,omitempty"`
+						CampaignName string `json:"campaign_name,omitempty"`
 					} `json:"referral"`
 					Order struct {
 						CatalogID    string `json:"catalog_id"`
@@ -271,31 +363,49 @@ type moPayload struct {
 						Billable     bool   `json:"billable"`
 						Category     string `json:"category"`
 					} `json:"pricing"`
+					Payment *wacPayment `json:"payment"`
+					Errors  []struct {
+						Code int `json:"code"`
+					} `json:"errors"`
 				} `json:"statuses"`
 				Errors []struct {
 					Code  int    `json:"code"`
 					Title string `json:"title"`
 				} `json:"errors"`
-				BanInfo struct {
-					WabaBanState []string `json:"waba_ban_state"`
-					WabaBanDate  string   `json:"waba_ban_date"`
-				} `json:"ban_info"`
-				CurrentLimit                 string `json:"current_limit"`
-				Decision                     string `json:"decision"`
-				DisplayPhoneNumber           string `json:"display_phone_number"`
-				Event                        string `json:"event"`
-				MaxDailyConversationPerPhone int    `json:"max_daily_conversation_per_phone"`
-				MaxPhoneNumbersPerBusiness   int    `json:"max_phone_numbers_per_business"`
-				MaxPhoneNumbersPerWaba       int    `json:"max_phone_numbers_per_waba"`
-				Reason                       string `json:"reason"`
-				RequestedVerifiedName        string `json:"requested_verified_name"`
-				RestrictionInfo              []struct {
-					RestrictionType string `json:"restriction_type"`
-					Expiration      string `json:"expiration"`
-				} `json:"restriction_info"`
-				MessageTemplateID       int    `json:"message_template_id"`
-				MessageTemplateName     string `json:"message_template_name"`
-				MessageTemplateLanguage string `json:"message_template_language"`
+				BanInfo                      *wacBanInfo      `json:"ban_info"`
+				CurrentLimit                 string           `json:"current_limit"`
+				Decision                     string           `json:"decision"`
+				DisplayPhoneNumber           string           `json:"display_phone_number"`
+				Event                        string           `json:"event"`
+				MaxDailyConversationPerPhone int              `json:"max_daily_conversation_per_phone"`
+				MaxPhoneNumbersPerBusiness   int              `json:"max_phone_numbers_per_business"`
+				MaxPhoneNumbersPerWaba       int              `json:"max_phone_numbers_per_waba"`
+				Reason                       string           `json:"reason"`
+				RequestedVerifiedName        string           `json:"requested_verified_name"`
+				RestrictionInfo              []wacRestriction `json:"restriction_info"`
+				MessageTemplateID            int              `json:"message_template_id"`
+				MessageTemplateName          string           `json:"message_template_name"`
+				MessageTemplateLanguage      string           `json:"message_template_language"`
+				MessageEchoes                []wacSyncedMsg   `json:"message_echoes"`
+				Calls                        []wacCall        `json:"calls"`
+				History                      []struct {
+					Metadata struct {
+						Phase      int `json:"phase"`
+						ChunkOrder int `json:"chunk_order"`
+						Progress   int `json:"progress"`
+					} `json:"metadata"`
+					Threads []struct {
+						ID       string         `json:"id"`
+						Messages []wacSyncedMsg `json:"messages"`
+					} `json:"threads"`
+				} `json:"history"`
+
+				// Instagram comments on the account's media
+				ID       string          `json:"id"`
+				ParentID string          `json:"parent_id"`
+				Text     string          `json:"text"`
+				From     *igCommentFrom  `json:"from"`
+				Media    *igCommentMedia `json:"media"`
 			} `json:"value"`
 		} `json:"changes"`
 		Messaging []struct {
@@ -308,6 +418,8 @@ type moPayload struct {
 				UserRef string `json:"user_ref"`
 			} `json:"optin"`
 
+			PriorMessage *priorMessage `json:"prior_message"`
+
 			Referral *struct {
 				Ref    string `json:"ref"`
 				Source string `json:"source"`
@@ -350,27 +462,29 @@ type moPayload struct {
 				Watermark int64    `json:"watermark"`
 			} `json:"delivery"`
 
-			MessagingFeedback *struct {
-				FeedbackScreens []struct {
-					ScreenID  int                         `json:"screen_id"`
-					Questions map[string]FeedbackQuestion `json:"questions"`
-				} `json:"feedback_screens"`
-			} `json:"messaging_feedback"`
+			Reaction *struct {
+				MID      string `json:"mid"`
+				Action   string `json:"action"`
+				Reaction string `json:"reaction"`
+				Emoji    string `json:"emoji"`
+			} `json:"reaction"`
+
+			MessagingFeedback *messagingFeedback `json:"messaging_feedback"`
 		} `json:"messaging"`
 	} `json:"entry"`
 }
 
-type FeedbackQuestion struct {
-	Type     string `json:"type"`
-	Payload  string `json:"payload"`
-	FollowUp *struct {
-		Type    string `json:"type"`
-		Payload string `json:"payload"`
-	} `json:"follow_up"`
-}
-
 // GetChannel returns the channel
 func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	if isBusinessProfileRequest(r) || isCallsRequest(r) {
+		return h.getBusinessProfileChannel(ctx, r)
+	}
+
+	// onboarding creates channels so there isn't one yet
+	if isOnboardRequest(r) {
+		return nil, nil
+	}
+
 	if r.Method == http.MethodGet {
 		return nil, nil
 	}
@@ -411,7 +525,14 @@ func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Chan
 			}
 			return nil, fmt.Errorf("template update, so ignore")
 		}
-		channelAddress = payload.Entry[0].Changes[0].Value.Metadata.PhoneNumberID
+		// changes about the business account rather than a phone number, e.g. account_update, have no metadata and are
+		// handled without a channel, any alerts among them being written for the channels of the account
+		if payload.Entry[0].Changes[0].Value.Metadata == nil && payload.Entry[0].Changes[0].Field != "messages" {
+			return nil, nil
+		}
+		if metadata := payload.Entry[0].Changes[0].Value.Metadata; metadata != nil {
+			channelAddress = metadata.PhoneNumberID
+		}
 		if channelAddress == "" {
 			return nil, fmt.Errorf("no channel address found")
 		}
@@ -446,14 +567,14 @@ func (h *handler) receiveVerify(ctx context.Context, channel courier.Channel, w
 	return nil, err
 }
 
-func resolveMediaURL(channel courier.Channel, mediaID string, token string) (string, error) {
+func (h *handler) resolveMediaURL(channel courier.Channel, mediaID string, token string) (string, error) {
 
 	if token == "" {
 		return "", fmt.Errorf("missing token for WAC channel")
 	}
 
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s", mediaID))
+	base := h.graphBaseURL(channel)
+	path, _ := url.Parse(mediaID)
 	retreiveURL := base.ResolveReference(path)
 
 	// set the access token as the authorization header
@@ -467,7 +588,12 @@ func resolveMediaURL(channel courier.Channel, mediaID string, token string) (str
 	}
 
 	mediaURL, err := jsonparser.GetString(resp.Body, "url")
-	return mediaURL, err
+	if err != nil {
+		return "", err
+	}
+
+	rememberMediaChecksum(channel, mediaURL, resp.Body)
+	return mediaURL, nil
 }
 
 // receiveEvent is our HTTP handler function for incoming messages and status updates
@@ -477,6 +603,11 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
+	// account webhooks aren't tied to a channel
+	if channel == nil {
+		return h.receiveAccountWebhook(ctx, w, r)
+	}
+
 	payload := &moPayload{}
 	err = handlers.DecodeAndValidateJSON(payload, r)
 	if err != nil {
@@ -490,12 +621,14 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		events, data, err = h.processFacebookInstagramPayload(ctx, channel, payload, w, r)
 	} else {
 		events, data, err = h.processCloudWhatsAppPayload(ctx, channel, payload, w, r)
-		webhook := channel.ConfigForKey("webhook", nil)
-		if webhook != nil {
-			er := handlers.SendWebhooksExternal(r, webhook)
-			if er != nil {
-				courier.LogRequestError(r, channel, fmt.Errorf("could not send webhook: %s", er))
-			}
+	}
+
+	// mirror the events to the channel's own webhook if it has one
+	webhook := channel.ConfigForKey("webhook", nil)
+	if webhook != nil {
+		er := handlers.SendWebhooksExternal(h.Server(), channel, r, webhook, webhookEventTypes(payload)...)
+		if er != nil {
+			courier.LogRequestError(r, channel, fmt.Errorf("could not send webhook: %s", er))
 		}
 	}
 
@@ -506,6 +639,59 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 	return events, courier.WriteDataResponse(ctx, w, http.StatusOK, "Events Handled", data)
 }
 
+// webhookEventTypes returns the types of the events in the passed in payload that webhook filters are matched against,
+// i.e. message and status for WhatsApp, message, echo, delivery, postback, referral, optin, reaction and feedback
+// for Messenger and Instagram and comment for Instagram
+func webhookEventTypes(payload *moPayload) []string {
+	seen := make(map[string]bool)
+	types := make([]string, 0, 2)
+	add := func(t string) {
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
+			if len(change.Value.Messages) > 0 {
+				add("message")
+			}
+			if len(change.Value.Statuses) > 0 {
+				add("status")
+			}
+		}
+		for _, msg := range entry.Messaging {
+			switch {
+			case msg.Message != nil && msg.Message.IsEcho:
+				add("echo")
+			case msg.Message != nil:
+				add("message")
+			case msg.Delivery != nil:
+				add("delivery")
+			case msg.Postback != nil:
+				add("postback")
+			case msg.Referral != nil:
+				add("referral")
+			case msg.OptIn != nil:
+				add("optin")
+			case msg.Reaction != nil:
+				add("reaction")
+			case msg.MessagingFeedback != nil:
+				add("feedback")
+			}
+		}
+		if payload.Object == "instagram" {
+			for _, change := range entry.Changes {
+				if change.Field == "comments" {
+					add("comment")
+				}
+			}
+		}
+	}
+	return types
+}
+
 func (h *handler) processCloudWhatsAppPayload(ctx context.Context, channel courier.Channel, payload *moPayload, w http.ResponseWriter, r *http.Request) ([]courier.Event, []interface{}, error) {
 	// the list of events we deal with
 	events := make([]courier.Event, 0, 2)
@@ -516,205 +702,550 @@ func (h *handler) processCloudWhatsAppPayload(ctx context.Context, channel couri
 	data := make([]interface{}, 0, 2)
 
 	var contactNames = make(map[string]string)
-
-	// for each entry
 	for _, entry := range payload.Entry {
-		if len(entry.Changes) == 0 {
-			continue
-		}
-
 		for _, change := range entry.Changes {
-
 			for _, contact := range change.Value.Contacts {
 				contactNames[contact.WaID] = contact.Profile.Name
 			}
+		}
+	}
 
+	// build the list of items to process, each tied to the contact it concerns
+	items := make([]*wacItem, 0, 2)
+
+	for _, entry := range payload.Entry {
+		for _, change := range entry.Changes {
 			for _, msg := range change.Value.Messages {
-				// create our date from the timestamp
-				ts, err := strconv.ParseInt(msg.Timestamp, 10, 64)
-				if err != nil {
-					return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid timestamp: %s", msg.Timestamp))
-				}
-				date := time.Unix(ts, 0).UTC()
+				msg := msg
+				items = append(items, &wacItem{contact: msg.From, process: func(item *wacItem) error {
+					// create our date from the timestamp
+					ts, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+					if err != nil {
+						return &wacRequestError{fmt.Errorf("invalid timestamp: %s", msg.Timestamp)}
+					}
+					date := time.Unix(ts, 0).UTC()
 
-				urn, err := urns.NewWhatsAppURN(msg.From)
-				if err != nil {
-					return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
-				}
+					urn, err := urns.NewWhatsAppURN(msg.From)
+					if err != nil {
+						return &wacRequestError{err}
+					}
 
-				text := ""
-				mediaURL := ""
-
-				if msg.Type == "text" {
-					text = msg.Text.Body
-				} else if msg.Type == "audio" && msg.Audio != nil {
-					text = msg.Audio.Caption
-					mediaURL, err = resolveMediaURL(channel, msg.Audio.ID, token)
-				} else if msg.Type == "voice" && msg.Voice != nil {
-					text = msg.Voice.Caption
-					mediaURL, err = resolveMediaURL(channel, msg.Voice.ID, token)
-				} else if msg.Type == "button" && msg.Button != nil {
-					text = msg.Button.Text
-				} else if msg.Type == "document" && msg.Document != nil {
-					text = msg.Document.Caption
-					mediaURL, err = resolveMediaURL(channel, msg.Document.ID, token)
-				} else if msg.Type == "image" && msg.Image != nil {
-					text = msg.Image.Caption
-					mediaURL, err = resolveMediaURL(channel, msg.Image.ID, token)
-				} else if msg.Type == "sticker" && msg.Sticker != nil {
-					mediaURL, err = resolveMediaURL(channel, msg.Sticker.ID, token)
-				} else if msg.Type == "video" && msg.Video != nil {
-					text = msg.Video.Caption
-					mediaURL, err = resolveMediaURL(channel, msg.Video.ID, token)
-				} else if msg.Type == "location" && msg.Location != nil {
-					mediaURL = fmt.Sprintf("geo:%f,%f;name:%s;address:%s", msg.Location.Latitude, msg.Location.Longitude, msg.Location.Name, msg.Location.Address)
-				} else if msg.Type == "interactive" && msg.Interactive.Type == "button_reply" {
-					text = msg.Interactive.ButtonReply.Title
-				} else if msg.Type == "interactive" && msg.Interactive.Type == "list_reply" {
-					text = msg.Interactive.ListReply.Title
-				} else if msg.Type == "order" {
-					text = msg.Order.Text
-				} else if msg.Type == "contacts" {
-
-					if len(msg.Contacts) == 0 {
-						return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("no shared contact"))
+					// reactions to msgs aren't msgs themselves, they're events for the msg reacted to, and an empty emoji is a
+					// reaction being removed
+					if msg.Type == "reaction" && msg.Reaction != nil {
+						extra := map[string]interface{}{"emoji": msg.Reaction.Emoji, "msg_external_id": msg.Reaction.MessageID, "external_id": msg.ID}
+						event := h.Backend().NewChannelEvent(channel, courier.MsgReaction, urn).WithOccurredOn(date).WithContactName(contactNames[msg.From]).WithExtra(extra)
+						if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+							return err
+						}
+						item.add(event, courier.NewEventReceiveData(event))
+						return nil
 					}
 
-					// put phones in a comma-separated string
-					var phones []string
-					for _, phone := range msg.Contacts[0].Phones {
-						phones = append(phones, phone.Phone)
+					text := ""
+					mediaURL := ""
+
+					// replies to address messages can also come back as the reply to a flow named after them
+					addressReply := msg.Interactive.AddressMessage
+					if msg.Interactive.Type == "nfm_reply" && msg.Interactive.NFMReply.Name == addressMessageType {
+						addressReply = &wacAddressReply{Body: msg.Interactive.NFMReply.Body, ResponseJSON: msg.Interactive.NFMReply.ResponseJSON}
 					}
-					text = strings.Join(phones, ", ")
-				} else {
-					// we received a message type we do not support.
-					courier.LogRequestError(r, channel, fmt.Errorf("unsupported message type %s", msg.Type))
-				}
 
-				// create our message
-				ev := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date).WithExternalID(msg.ID).WithContactName(contactNames[msg.From])
-				event := h.Backend().CheckExternalIDSeen(ev)
+					if msg.Type == "text" {
+						text = msg.Text.Body
+					} else if msg.Type == "audio" && msg.Audio != nil {
+						text = msg.Audio.Caption
+						mediaURL, err = h.resolveMediaURL(channel, msg.Audio.ID, token)
+					} else if msg.Type == "voice" && msg.Voice != nil {
+						text = msg.Voice.Caption
+						mediaURL, err = h.resolveMediaURL(channel, msg.Voice.ID, token)
+					} else if msg.Type == "button" && msg.Button != nil {
+						text = msg.Button.Text
+					} else if msg.Type == "document" && msg.Document != nil {
+						text = msg.Document.Caption
+						mediaURL, err = h.resolveMediaURL(channel, msg.Document.ID, token)
+					} else if msg.Type == "image" && msg.Image != nil {
+						text = msg.Image.Caption
+						mediaURL, err = h.resolveMediaURL(channel, msg.Image.ID, token)
+					} else if msg.Type == "sticker" && msg.Sticker != nil {
+						text = stickerEmoji(msg.Sticker)
+						mediaURL, err = h.resolveMediaURL(channel, msg.Sticker.ID, token)
+					} else if msg.Type == "video" && msg.Video != nil {
+						text = msg.Video.Caption
+						mediaURL, err = h.resolveMediaURL(channel, msg.Video.ID, token)
+					} else if msg.Type == "location" && msg.Location != nil {
+						mediaURL = fmt.Sprintf("geo:%f,%f;name:%s;address:%s", msg.Location.Latitude, msg.Location.Longitude, msg.Location.Name, msg.Location.Address)
+					} else if msg.Type == "interactive" && msg.Interactive.Type == "button_reply" {
+						text = msg.Interactive.ButtonReply.Title
+					} else if msg.Type == "interactive" && msg.Interactive.Type == "list_reply" {
+						text = msg.Interactive.ListReply.Title
+					} else if msg.Type == "interactive" && msg.Interactive.Type == "location_request_message" {
+						// contacts who decline to share their location reply without one, flows can branch on our metadata
+					} else if msg.Type == "interactive" && addressReply != nil {
+						text = addressReply.Body
+					} else if msg.Type == "order" {
+						text = msg.Order.Text
+					} else if msg.Type == "contacts" {
+
+						if len(msg.Contacts) == 0 {
+							return &wacRequestError{errors.New("no shared contact")}
+						}
 
-				// we had an error downloading media
-				if err != nil {
-					courier.LogRequestError(r, channel, err)
-				}
+						// put phones in a comma-separated string
+						var phones []string
+						for _, phone := range msg.Contacts[0].Phones {
+							phones = append(phones, phone.Phone)
+						}
+						text = strings.Join(phones, ", ")
+					} else {
+						// we received a message type we do not support, let others know and optionally tell the contact
+						courier.LogRequestError(r, channel, fmt.Errorf("unsupported message type %s", msg.Type))
+						h.trackUnknownType(channel, unknownKindMessage, msg.Type)
 
-				if msg.Type == "order" {
-					orderM := map[string]interface{}{"order": msg.Order}
-					orderJSON, err := json.Marshal(orderM)
-					if err != nil {
-						courier.LogRequestError(r, channel, err)
+						unsupported := h.Backend().NewChannelEvent(channel, courier.UnsupportedMsg, urn).WithOccurredOn(date).WithContactName(contactNames[msg.From]).WithExtra(map[string]interface{}{"msg_type": msg.Type, "external_id": msg.ID})
+						err := h.Backend().WriteChannelEvent(ctx, unsupported)
+						if err != nil {
+							return err
+						}
+						item.add(unsupported, courier.NewEventReceiveData(unsupported))
+
+						reply := channel.StringConfigForKey(configUnsupportedReply, "")
+						if reply != "" && h.claimUnsupportedReply(r, channel, msg.ID) {
+							h.sendUnsupportedReply(ctx, channel, r, token, msg.From, reply)
+						}
 					}
-					metadata := json.RawMessage(orderJSON)
-					event.WithMetadata(metadata)
-				}
 
-				if msg.Referral.Headline != "" {
+					// create our message
+					ev := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date).WithExternalID(msg.ID).WithContactName(contactNames[msg.From])
+					event := h.Backend().CheckExternalIDSeen(ev)
 
-					referral, err := json.Marshal(msg.Referral)
+					// we had an error downloading media
 					if err != nil {
 						courier.LogRequestError(r, channel, err)
 					}
-					metadata := json.RawMessage(referral)
-					event.WithMetadata(metadata)
-				}
 
-				if msg.Interactive.Type == "nfm_reply" {
-					nfmReply := map[string]interface{}{"nfm_reply": msg.Interactive.NFMReply}
-					nfmReplyJSON, err := json.Marshal(nfmReply)
-					if err != nil {
-						courier.LogRequestError(r, channel, err)
+					if msg.Type == "order" {
+						orderM := map[string]interface{}{"order": msg.Order}
+						orderJSON, err := json.Marshal(orderM)
+						if err != nil {
+							courier.LogRequestError(r, channel, err)
+						}
+						metadata := json.RawMessage(orderJSON)
+						event.WithMetadata(metadata)
 					}
-					metadata := json.RawMessage(nfmReplyJSON)
-					event.WithMetadata(metadata)
-				}
 
-				if mediaURL != "" {
-					event.WithAttachment(mediaURL)
-				}
+					if msg.Type == "sticker" && msg.Sticker != nil {
+						event.WithMetadata(stickerMetadata(msg.Sticker))
+					}
 
-				err = h.Backend().WriteMsg(ctx, event)
-				if err != nil {
-					return nil, nil, err
-				}
+					// live locations have a live period, and their updates reply to the msg which started them
+					if msg.Type == "location" && msg.Location != nil && msg.Location.LivePeriod > 0 {
+						startedBy, isUpdate := msg.ID, false
+						if msg.Context != nil && msg.Context.ID != "" {
+							startedBy, isUpdate = msg.Context.ID, true
+						}
+						liveLocation, err := handlers.LiveLocationMetadata(h.Backend().RedisPool(), channel, startedBy, msg.Location.LivePeriod, isUpdate)
+						if err != nil {
+							return err
+						}
+						metadata, _ := json.Marshal(map[string]interface{}{"live_location": liveLocation})
+						event.WithMetadata(metadata)
+					}
 
-				h.Backend().WriteExternalIDSeen(event)
+					if msg.Type == "interactive" && msg.Interactive.Type == "location_request_message" {
+						contextID := ""
+						if msg.Context != nil {
+							contextID = msg.Context.ID
+						}
+						event.WithMetadata(locationRequestMetadata(msg.Interactive.LocationRequest.Status, contextID))
+					}
 
-				events = append(events, event)
-				data = append(data, courier.NewMsgReceiveData(event))
+					if msg.Referral.Headline != "" {
+						if msg.Referral.SourceType == adReferralSourceType {
+							names, err := h.lookupAdNames(channel, msg.Referral.SourceID)
+							if err != nil {
+								courier.LogRequestError(r, channel, err)
+							} else if names != nil {
+								msg.Referral.AdName = names.Name
+								msg.Referral.CampaignName = names.Campaign.Name
+							}
+						}
+
+						referral, err := json.Marshal(msg.Referral)
+						if err != nil {
+							courier.LogRequestError(r, channel, err)
+						}
+						metadata := json.RawMessage(referral)
+						event.WithMetadata(metadata)
+					}
+
+					if addressReply != nil {
+						contextID := ""
+						if msg.Context != nil {
+							contextID = msg.Context.ID
+						}
+						event.WithMetadata(addressReplyMetadata(addressReply, contextID))
+					} else if msg.Interactive.Type == "nfm_reply" {
+						nfmReply := map[string]interface{}{"nfm_reply": msg.Interactive.NFMReply}
+
+						// if this completes a flow message we sent, include which and how long it took
+						if completion := h.resolveFlowCompletion(channel, urn, msg.Interactive.NFMReply.ResponseJSON, date); completion != nil {
+							nfmReply["flow_completion"] = completion
+						}
+						nfmReplyJSON, err := json.Marshal(nfmReply)
+						if err != nil {
+							courier.LogRequestError(r, channel, err)
+						}
+						metadata := json.RawMessage(nfmReplyJSON)
+						event.WithMetadata(metadata)
+					}
+
+					if mediaURL != "" {
+						event.WithAttachment(mediaURL)
+					}
+
+					handlers.TranslateIncomingMsg(ctx, h.Server(), event)
 
+					err = h.Backend().WriteMsg(ctx, event)
+					if err != nil {
+						return err
+					}
+					handlers.ExtractAttachmentText(ctx, h.Server(), event)
+
+					h.Backend().WriteExternalIDSeen(event)
+
+					item.add(event, courier.NewMsgReceiveData(event))
+					return nil
+				}})
 			}
 
 			for _, status := range change.Value.Statuses {
-
-				msgStatus, found := waStatusMapping[status.Status]
-				if !found {
-					if waIgnoreStatuses[status.Status] {
-						data = append(data, courier.NewInfoData(fmt.Sprintf("ignoring status: %s", status.Status)))
-					} else {
-						handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unknown status: %s", status.Status))
+				status := status
+				items = append(items, &wacItem{contact: status.RecipientID, process: func(item *wacItem) error {
+					// payment statuses are about the order of a message rather than the message itself
+					if status.Type == wacPaymentStatusType {
+						event, err := h.writePaymentEvent(ctx, channel, status.ID, status.RecipientID, status.Status, status.Timestamp, status.Payment)
+						if err != nil {
+							return &wacRequestError{err}
+						}
+						item.add(event, courier.NewEventReceiveData(event))
+						return nil
 					}
-					continue
-				}
 
-				event := h.Backend().NewMsgStatusForExternalID(channel, status.ID, msgStatus)
-				err := h.Backend().WriteMsgStatus(ctx, event)
+					msgStatus, found := waStatusMapping[status.Status]
+					if !found {
+						if waIgnoreStatuses[status.Status] {
+							item.data = append(item.data, courier.NewInfoData(fmt.Sprintf("ignoring status: %s", status.Status)))
+						} else {
+							item.warnings = append(item.warnings, fmt.Errorf("unknown status: %s", status.Status))
+						}
+						return nil
+					}
 
-				// we don't know about this message, just tell them we ignored it
-				if err == courier.ErrMsgNotFound {
-					data = append(data, courier.NewInfoData(fmt.Sprintf("message id: %s not found, ignored", status.ID)))
-					continue
-				}
+					event := h.Backend().NewMsgStatusForExternalID(channel, status.ID, msgStatus)
+					if msgStatus == courier.MsgFailed && len(status.Errors) > 0 {
+						event.SetErrorClass(graphErrorClass(status.Errors[0].Code))
+					}
+					err := h.Backend().WriteMsgStatus(ctx, event)
 
-				if err != nil {
-					return nil, nil, err
-				}
+					// we don't know about this message, just tell them we ignored it
+					if err == courier.ErrMsgNotFound {
+						item.data = append(item.data, courier.NewInfoData(fmt.Sprintf("message id: %s not found, ignored", status.ID)))
+						return nil
+					}
 
-				if msgStatus == courier.MsgDelivered || msgStatus == courier.MsgRead {
-					urn, err := urns.NewWhatsAppURN(status.RecipientID)
 					if err != nil {
-						handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
-					} else {
-						contactTo, err := h.Backend().GetContact(ctx, channel, urn, "", "")
+						return err
+					}
+
+					if status.Pricing != nil {
+						h.writeMsgPricing(ctx, channel, r, status.ID, status.Pricing.Category, status.Pricing.PricingModel, status.Pricing.Billable, status.Timestamp)
+					}
+
+					if msgStatus == courier.MsgDelivered || msgStatus == courier.MsgRead {
+						urn, err := urns.NewWhatsAppURN(status.RecipientID)
 						if err != nil {
-							handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+							item.warnings = append(item.warnings, err)
 						} else {
-							err = h.Backend().UpdateContactLastSeenOn(ctx, contactTo.UUID(), time.Now())
+							contactTo, err := h.Backend().GetContact(ctx, channel, urn, "", "")
 							if err != nil {
-								handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+								item.warnings = append(item.warnings, err)
 							} else {
-								if h.Server().Billing() != nil {
-									billingMsg := billing.NewMessage(
-										string(urn.Identity()),
-										contactTo.UUID().String(),
-										channel.UUID().String(),
-										status.ID,
-										time.Now().Format(time.RFC3339),
-										"",
-										channel.ChannelType().String(),
-										"",
-										nil,
-										nil,
-									)
-									h.Server().Billing().SendAsync(billingMsg, nil, nil)
+								err = h.Backend().UpdateContactLastSeenOn(ctx, contactTo.UUID(), time.Now())
+								if err != nil {
+									item.warnings = append(item.warnings, err)
+								} else {
+									if h.Server().Billing() != nil {
+										now := time.Now()
+										billingMsg := billing.NewMessage(
+											string(urn.Identity()),
+											contactTo.UUID().String(),
+											channel.UUID().String(),
+											status.ID,
+											now.Format(time.RFC3339),
+											"",
+											channel.ChannelType().String(),
+											"",
+											nil,
+											nil,
+										)
+										billingMsg.SetChannelContext(now, courier.ChannelLocation(channel), channel.Country())
+										courier.SetBillingRouting(channel, &billingMsg)
+										h.Server().Billing().SendAsync(billingMsg, nil, nil)
+									}
 								}
 							}
 						}
 					}
+
+					item.add(event, courier.NewStatusData(event))
+					return nil
+				}})
+			}
+
+			if len(change.Value.MessageEchoes) > 0 || len(change.Value.History) > 0 {
+				if !channel.BoolConfigForKey(configCoexistence, false) {
+					items = append(items, &wacItem{data: []interface{}{courier.NewInfoData(fmt.Sprintf("ignoring %s, coexistence not enabled", change.Field))}})
+					continue
 				}
 
-				events = append(events, event)
-				data = append(data, courier.NewStatusData(event))
+				for _, echo := range change.Value.MessageEchoes {
+					echo := echo
+					items = append(items, &wacItem{contact: echo.To, process: func(item *wacItem) error {
+						event, err := h.writeSyncedMsg(ctx, channel, r, echo, echo.To, true, "smb_message_echoes")
+						if err != nil {
+							return &wacRequestError{err}
+						}
+						item.add(event, courier.NewMsgReceiveData(event))
+						return nil
+					}})
+				}
 
+				for _, history := range change.Value.History {
+					for _, thread := range history.Threads {
+						thread := thread
+						for _, msg := range thread.Messages {
+							msg := msg
+							items = append(items, &wacItem{contact: thread.ID, process: func(item *wacItem) error {
+								// the thread id is the contact, anything not from them was sent by the business
+								event, err := h.writeSyncedMsg(ctx, channel, r, msg, thread.ID, msg.From != thread.ID, "history")
+								if err != nil {
+									return &wacRequestError{err}
+								}
+								item.add(event, courier.NewMsgReceiveData(event))
+								return nil
+							}})
+						}
+					}
+				}
 			}
 
+			if len(change.Value.Calls) > 0 {
+				if !channel.BoolConfigForKey(configCalling, false) {
+					items = append(items, &wacItem{data: []interface{}{courier.NewInfoData(fmt.Sprintf("ignoring %s, calling not enabled", change.Field))}})
+					continue
+				}
+
+				for _, call := range change.Value.Calls {
+					call := call
+					items = append(items, &wacItem{contact: call.contact(), process: func(item *wacItem) error {
+						event, err := h.writeCallEvent(ctx, channel, &call, contactNames[call.contact()])
+						if err != nil {
+							return &wacRequestError{err}
+						}
+						if event == nil {
+							item.data = append(item.data, courier.NewInfoData(fmt.Sprintf("ignoring call event: %s", call.Event)))
+							return nil
+						}
+						item.add(event, courier.NewEventReceiveData(event))
+						return nil
+					}})
+				}
+			}
 		}
+	}
+
+	maxWorkers := maxWebhookWorkers
+	if !courier.FlagEnabled(h.Backend().RedisPool(), courier.FlagParallelWebhooks, true) {
+		maxWorkers = 1
+	}
+	processWACItems(items, maxWorkers)
 
+	for _, item := range items {
+		// skipped items follow a failed one for the same contact, which is what we report
+		if item.skipped {
+			continue
+		}
+		if item.err != nil {
+			if reqErr, isRequestErr := item.err.(*wacRequestError); isRequestErr {
+				return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, reqErr.error)
+			}
+			return nil, nil, item.err
+		}
+		for _, warning := range item.warnings {
+			handlers.WriteAndLogRequestError(ctx, h, channel, w, r, warning)
+		}
+		events = append(events, item.events...)
+		data = append(data, item.data...)
 	}
+
 	return events, data, nil
 }
 
+// claimUnsupportedReply returns whether we should reply to the unsupported msg with the passed in external id, which
+// is only the first time we see it so retried webhooks don't reply again
+func (h *handler) claimUnsupportedReply(r *http.Request, channel courier.Channel, externalID string) bool {
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(unsupportedReplyKey, channel.UUID(), externalID)
+	claimed, err := redis.String(rc.Do("SET", key, "1", "NX", "EX", unsupportedReplyTTL))
+	if err != nil && err != redis.ErrNil {
+		courier.LogRequestError(r, channel, errors.Wrap(err, "unable to claim unsupported msg reply"))
+	}
+	return claimed == "OK"
+}
+
+// sendUnsupportedReply sends the passed in text to a contact who sent us a message type we can't handle
+func (h *handler) sendUnsupportedReply(ctx context.Context, channel courier.Channel, r *http.Request, token string, to string, text string) {
+	userAccessToken := channel.StringConfigForKey(courier.ConfigUserToken, "")
+	if userAccessToken != "" {
+		token = userAccessToken
+	}
+
+	payload := wacMTPayload{MessagingProduct: "whatsapp", RecipientType: "individual", To: to, Type: "text", Text: &wacText{Body: text}}
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		courier.LogRequestError(r, channel, err)
+		return
+	}
+
+	base := h.graphBaseURL(channel)
+	path, _ := url.Parse(fmt.Sprintf("%s/messages", channel.Address()))
+	req, _ := http.NewRequest(http.MethodPost, base.ResolveReference(path).String(), bytes.NewReader(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	rr, err := utils.MakeHTTPRequest(req)
+	log := courier.NewChannelLogFromRR("Unsupported Message Reply", channel, courier.NilMsgID, rr).WithError("Unsupported Message Reply Error", err)
+	err = h.Backend().WriteChannelLogs(ctx, []*courier.ChannelLog{log})
+	if err != nil {
+		courier.LogRequestError(r, channel, err)
+	}
+}
+
+// wacReadPayload marks an incoming msg as read, showing the contact blue ticks
+type wacReadPayload struct {
+	MessagingProduct string `json:"messaging_product"`
+	Status           string `json:"status"`
+	MessageID        string `json:"message_id"`
+}
+
+// MarkMsgRead tells WhatsApp that the passed in incoming msg has been read, only WAC channels support this
+func (h *handler) MarkMsgRead(ctx context.Context, msg courier.Msg) (*courier.ChannelLog, error) {
+	channel := msg.Channel()
+	if channel.ChannelType() != "WAC" {
+		return nil, nil
+	}
+
+	token := channel.StringConfigForKey(courier.ConfigUserToken, "")
+	if token == "" {
+		token = h.Server().Config().WhatsappAdminSystemUserToken
+	}
+
+	jsonBody, err := json.Marshal(&wacReadPayload{MessagingProduct: "whatsapp", Status: "read", MessageID: msg.ExternalID()})
+	if err != nil {
+		return nil, err
+	}
+
+	base := h.graphBaseURL(channel)
+	path, _ := url.Parse(fmt.Sprintf("%s/messages", channel.Address()))
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, base.ResolveReference(path).String(), bytes.NewReader(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	rr, err := utils.MakeHTTPRequest(req)
+	return courier.NewChannelLogFromRR("Message Marked Read", channel, msg.ID(), rr).WithError("Mark Read Error", err), err
+}
+
+// writeMsgPricing adds the pricing reported in a status to our analytics, which only count it for the first status of
+// each msg, failures here shouldn't fail the status
+func (h *handler) writeMsgPricing(ctx context.Context, channel courier.Channel, r *http.Request, externalID string, category string, model string, billable bool, timestamp string) {
+	occurredOn := time.Now().UTC()
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err == nil {
+		occurredOn = time.Unix(ts, 0).UTC()
+	}
+
+	pricing := &courier.MsgPricing{ExternalID: externalID, Category: category, PricingModel: model, Billable: billable, OccurredOn: occurredOn}
+	err = h.Backend().WriteMsgPricing(ctx, channel, pricing)
+	if err != nil {
+		courier.LogRequestError(r, channel, errors.Wrap(err, "unable to write pricing analytics"))
+	}
+}
+
+// writeSyncedMsg writes a message synced from a business app in coexistence mode, these are stored but never handled
+func (h *handler) writeSyncedMsg(ctx context.Context, channel courier.Channel, r *http.Request, msg wacSyncedMsg, contact string, outgoing bool, source string) (courier.Msg, error) {
+	ts, err := strconv.ParseInt(msg.Timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %s", msg.Timestamp)
+	}
+	date := time.Unix(ts, 0).UTC()
+
+	urn, err := urns.NewWhatsAppURN(contact)
+	if err != nil {
+		return nil, err
+	}
+
+	token := h.Server().Config().WhatsappAdminSystemUserToken
+
+	text := ""
+	mediaURL := ""
+
+	if msg.Type == "text" {
+		text = msg.Text.Body
+	} else if msg.Type == "image" && msg.Image != nil {
+		text = msg.Image.Caption
+		mediaURL, err = h.resolveMediaURL(channel, msg.Image.ID, token)
+	} else if msg.Type == "audio" && msg.Audio != nil {
+		mediaURL, err = h.resolveMediaURL(channel, msg.Audio.ID, token)
+	} else if msg.Type == "video" && msg.Video != nil {
+		text = msg.Video.Caption
+		mediaURL, err = h.resolveMediaURL(channel, msg.Video.ID, token)
+	} else if msg.Type == "document" && msg.Document != nil {
+		text = msg.Document.Caption
+		mediaURL, err = h.resolveMediaURL(channel, msg.Document.ID, token)
+	} else if msg.Type == "sticker" && msg.Sticker != nil {
+		mediaURL, err = h.resolveMediaURL(channel, msg.Sticker.ID, token)
+	}
+
+	// we had an error downloading media, store the message without it
+	if err != nil {
+		courier.LogRequestError(r, channel, err)
+	}
+
+	coexistence := map[string]interface{}{"source": source}
+	if msg.HistoryContext != nil {
+		coexistence["status"] = msg.HistoryContext.Status
+	}
+	metadata, _ := json.Marshal(map[string]interface{}{"coexistence": coexistence})
+
+	ev := h.Backend().NewSyncedMsg(channel, urn, text, outgoing).WithReceivedOn(date).WithExternalID(msg.ID).WithMetadata(metadata)
+	event := h.Backend().CheckExternalIDSeen(ev)
+
+	if mediaURL != "" {
+		event.WithAttachment(mediaURL)
+	}
+
+	err = h.Backend().WriteMsg(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Backend().WriteExternalIDSeen(event)
+	return event, nil
+}
+
 func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel courier.Channel, payload *moPayload, w http.ResponseWriter, r *http.Request) ([]courier.Event, []interface{}, error) {
 	var err error
 
@@ -725,7 +1256,31 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 	data := make([]interface{}, 0, 2)
 
 	// for each entry
-	for _, entry := range payload.Entry {
+	for i, entry := range payload.Entry {
+		// comments on the media of Instagram accounts come as changes rather than messaging
+		if payload.Object == "instagram" && entry.ID == channel.Address() {
+			for _, change := range entry.Changes {
+				if change.Field != "comments" || change.Value.From == nil {
+					continue
+				}
+
+				// the account's own comments, i.e. our replies, aren't msgs from contacts
+				if change.Value.From.ID == channel.Address() {
+					data = append(data, courier.NewInfoData("ignoring own comment"))
+					continue
+				}
+
+				comment := &igComment{ID: change.Value.ID, ParentID: change.Value.ParentID, Text: change.Value.Text, From: change.Value.From, Media: change.Value.Media}
+				event, err := h.receiveComment(ctx, channel, comment, time.Unix(entry.Time, 0).UTC())
+				if err != nil {
+					return nil, nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+				}
+
+				events = append(events, event)
+				data = append(data, courier.NewMsgReceiveData(event))
+			}
+		}
+
 		// no entry, ignore
 		if len(entry.Messaging) == 0 {
 			continue
@@ -762,13 +1317,21 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			}
 		}
 
+		// contacts who opted in with the checkbox plugin reply with their real id, which is merged into their contact
+		if userRef := msg.PriorMessage.checkboxUserRef(); userRef != "" && payload.Object != "instagram" && msg.Sender.UserRef == "" && msg.OptIn == nil {
+			event, err := h.mergeCheckboxContact(ctx, channel, userRef, urn, date)
+			if err != nil {
+				return nil, nil, err
+			}
+			if event != nil {
+				events = append(events, event)
+				data = append(data, courier.NewEventReceiveData(event))
+			}
+		}
+
 		if msg.OptIn != nil {
-			// this is an opt in, if we have a user_ref, use that as our URN (this is a checkbox plugin)
-			// TODO:
-			//    We need to deal with the case of them responding and remapping the user_ref in that case:
-			//    https://developers.facebook.com/docs/messenger-platform/discovery/checkbox-plugin
-			//    Right now that we even support this isn't documented and I don't think anybody uses it, so leaving that out.
-			//    (things will still work, we just will have dupe contacts, one with user_ref for the first contact, then with the real id when they reply)
+			// this is an opt in, if we have a user_ref, use that as our URN (this is a checkbox plugin), when they reply
+			// with their real id we merge that into the contact of this URN
 			if msg.OptIn.UserRef != "" {
 				urn, err = urns.NewFacebookURN(urns.FacebookRefPrefix + msg.OptIn.UserRef)
 				if err != nil {
@@ -866,7 +1429,16 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			}
 
 			if msg.Message.IsDeleted {
-				h.Backend().DeleteMsgWithExternalID(ctx, channel, msg.Message.MID)
+				event, err := h.unsendMsg(ctx, channel, urn, msg.Message.MID, date)
+				if err != nil {
+					return nil, nil, err
+				}
+				if event == nil {
+					data = append(data, courier.NewInfoData("ignoring unsend, no msg to delete"))
+					continue
+				}
+
+				events = append(events, event)
 				data = append(data, courier.NewInfoData("msg deleted"))
 				continue
 			}
@@ -913,10 +1485,13 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 				event.WithAttachment(attURL)
 			}
 
+			handlers.TranslateIncomingMsg(ctx, h.Server(), event)
+
 			err := h.Backend().WriteMsg(ctx, event)
 			if err != nil {
 				return nil, nil, err
 			}
+			handlers.ExtractAttachmentText(ctx, h.Server(), event)
 
 			h.Backend().WriteExternalIDSeen(event)
 
@@ -944,30 +1519,26 @@ func (h *handler) processFacebookInstagramPayload(ctx context.Context, channel c
 			}
 
 		} else if msg.MessagingFeedback != nil {
-
-			payloads := []string{}
-			for _, v := range msg.MessagingFeedback.FeedbackScreens[0].Questions {
-				payloads = append(payloads, v.Payload)
-				if v.FollowUp != nil {
-					payloads = append(payloads, v.FollowUp.Payload)
-				}
-			}
-
-			text := strings.Join(payloads[:], "|")
-
-			ev := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date)
-			event := h.Backend().CheckExternalIDSeen(ev)
-
-			err := h.Backend().WriteMsg(ctx, event)
+			feedbackMsg, feedbackEvents, err := h.receiveFeedback(ctx, channel, urn, msg.MessagingFeedback, date)
 			if err != nil {
 				return nil, nil, err
 			}
 
-			h.Backend().WriteExternalIDSeen(event)
-			events = append(events, event)
-			data = append(data, courier.NewMsgReceiveData(event))
+			if feedbackMsg != nil {
+				events = append(events, feedbackMsg)
+				data = append(data, courier.NewMsgReceiveData(feedbackMsg))
+			}
+			for _, event := range feedbackEvents {
+				events = append(events, event)
+				data = append(data, courier.NewEventReceiveData(event))
+			}
+
+		} else if msg.Reaction != nil {
+			// reactions to msgs aren't msgs themselves
+			data = append(data, courier.NewInfoData("ignoring message reaction"))
 
 		} else {
+			h.trackUnknownType(channel, unknownKindMessaging, unknownMessagingType(r, i))
 			data = append(data, courier.NewInfoData("ignoring unknown entry type"))
 		}
 	}
@@ -1036,6 +1607,11 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 		return nil, fmt.Errorf("missing access token")
 	}
 
+	// replies to Instagram comments are threaded under the comment rather than sent as DMs
+	if commentID := replyToCommentID(msg); commentID != "" && msg.Channel().ChannelType() == "IG" {
+		return h.sendCommentReply(msg, commentID, accessToken)
+	}
+
 	topic := msg.Topic()
 	payload := mtPayload{}
 
@@ -1056,7 +1632,7 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 		payload.Recipient.ID = msg.URN().Path()
 	}
 
-	msgURL, _ := url.Parse(sendURL)
+	msgURL := h.graphBaseURL(msg.Channel()).ResolveReference(&url.URL{Path: "me/messages"})
 	query := url.Values{}
 	query.Set("access_token", accessToken)
 	msgURL.RawQuery = query.Encode()
@@ -1155,7 +1731,7 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 				return status, err
 			}
 
-			msgURL, _ := url.Parse("https://graph.facebook.com/v12.0/me/messages")
+			msgURL := h.graphBaseURL(msg.Channel()).ResolveReference(&url.URL{Path: "me/messages"})
 			query := url.Values{}
 			query.Set("access_token", accessToken)
 			msgURL.RawQuery = query.Encode()
@@ -1166,7 +1742,7 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 			}
 			req.Header.Set("Content-Type", "application/json")
 			req.Header.Set("Accept", "application/json")
-			rr, err := utils.MakeHTTPRequest(req)
+			rr, err := h.makeGraphRequest(msg, status, req)
 
 			log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
 			status.AddLog(log)
@@ -1230,7 +1806,7 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Accept", "application/json")
 
-		rr, err := utils.MakeHTTPRequest(req)
+		rr, err := h.makeGraphRequest(msg, status, req)
 
 		// record our status and log
 		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
@@ -1245,9 +1821,10 @@ func (h *handler) sendFacebookInstagramMsg(ctx context.Context, msg courier.Msg)
 			return status, nil
 		}
 
-		// if this is our first message, record the external id
+		// record this part, the first external id becoming the external id of the msg
+		status.AddPart(externalID, courier.MsgWired)
+
 		if i == 0 {
-			status.SetExternalID(externalID)
 			if msg.URN().IsFacebookRef() {
 				recipientID, err := jsonparser.GetString(rr.Body, "recipient_id")
 				if err != nil {
@@ -1325,9 +1902,17 @@ type wacMTButton struct {
 type wacParam struct {
 	Type     string      `json:"type"`
 	Text     string      `json:"text,omitempty"`
+	Payload  string      `json:"payload,omitempty"`
 	Image    *wacMTMedia `json:"image,omitempty"`
 	Document *wacMTMedia `json:"document,omitempty"`
 	Video    *wacMTMedia `json:"video,omitempty"`
+
+	CouponCode       string      `json:"coupon_code,omitempty"`
+	LimitedTimeOffer *wacMTOffer `json:"limited_time_offer,omitempty"`
+}
+
+type wacMTOffer struct {
+	ExpirationTimeMS int64 `json:"expiration_time_ms"`
 }
 
 type wacComponent struct {
@@ -1342,6 +1927,11 @@ type wacText struct {
 	PreviewURL bool   `json:"preview_url,omitempty"`
 }
 
+// newTextPart returns the text of a msg part, previewing any URL it contains
+func newTextPart(body string) *wacText {
+	return &wacText{Body: body, PreviewURL: strings.Contains(body, "https://") || strings.Contains(body, "http://")}
+}
+
 type wacLanguage struct {
 	Policy string `json:"policy"`
 	Code   string `json:"code"`
@@ -1353,37 +1943,14 @@ type wacTemplate struct {
 	Components []*wacComponent `json:"components"`
 }
 
-type wacInteractive struct {
-	Type   string `json:"type"`
-	Header *struct {
-		Type     string     `json:"type"`
-		Text     string     `json:"text,omitempty"`
-		Video    wacMTMedia `json:"video,omitempty"`
-		Image    wacMTMedia `json:"image,omitempty"`
-		Document wacMTMedia `json:"document,omitempty"`
-	} `json:"header,omitempty"`
-	Body struct {
-		Text string `json:"text"`
-	} `json:"body,omitempty"`
-	Footer *struct {
-		Text string `json:"text,omitempty"`
-	} `json:"footer,omitempty"`
-	Action *struct {
-		Button            string         `json:"button,omitempty"`
-		Sections          []wacMTSection `json:"sections,omitempty"`
-		Buttons           []wacMTButton  `json:"buttons,omitempty"`
-		CatalogID         string         `json:"catalog_id,omitempty"`
-		ProductRetailerID string         `json:"product_retailer_id,omitempty"`
-		Name              string         `json:"name,omitempty"`
-	} `json:"action,omitempty"`
-}
-
 type wacMTPayload struct {
 	MessagingProduct string `json:"messaging_product"`
 	RecipientType    string `json:"recipient_type"`
 	To               string `json:"to"`
 	Type             string `json:"type"`
 
+	Context *wacMTContext `json:"context,omitempty"`
+
 	Text *wacText `json:"text,omitempty"`
 
 	Document *wacMTMedia `json:"document,omitempty"`
@@ -1397,6 +1964,26 @@ type wacMTPayload struct {
 	Template *wacTemplate `json:"template,omitempty"`
 }
 
+// the msg a sent msg is a reply to, which WhatsApp shows quoted above it
+type wacMTContext struct {
+	MessageID string `json:"message_id"`
+}
+
+// the key in msg metadata of the external ID of the msg it's a reply to
+const replyToExternalIDKey = "reply_to_external_id"
+
+// newReplyContext returns the context of the passed in msg if it's a reply to another msg
+func newReplyContext(msg courier.Msg) *wacMTContext {
+	if msg.Metadata() == nil {
+		return nil
+	}
+	externalID, _ := jsonparser.GetString(msg.Metadata(), replyToExternalIDKey)
+	if externalID == "" {
+		return nil
+	}
+	return &wacMTContext{MessageID: externalID}
+}
+
 type wacMTResponse struct {
 	Messages []*struct {
 		ID string `json:"id"`
@@ -1430,15 +2017,22 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 	hasNewURN := false
 	hasCaption := false
 
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s/messages", msg.Channel().Address()))
+	base := h.graphBaseURL(msg.Channel())
+	path, _ := url.Parse(fmt.Sprintf("%s/messages", msg.Channel().Address()))
 	wacPhoneURL := base.ResolveReference(path)
 
 	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
 
+	// msgs with templates in languages we can't map will never send, so fail them rather than retrying them
+	if _, err := h.getTemplate(msg); err != nil {
+		if mappingErr, isMappingErr := err.(*languageMappingError); isMappingErr {
+			return h.failUnmappedLanguage(ctx, msg, status, mappingErr), nil
+		}
+	}
+
 	msgParts := make([]string, 0)
 	if msg.Text() != "" {
-		if len(msg.ListMessage().ListItems) > 0 || len(msg.QuickReplies()) > 0 || msg.InteractionType() == "location" {
+		if len(msg.ListMessage().ListItems) > 0 || len(msg.QuickReplies()) > 0 || msg.InteractionType() == "location" || msg.InteractionType() == "address" {
 			msgParts = handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLengthInteractiveWAC)
 		} else {
 			msgParts = handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLengthWAC)
@@ -1451,6 +2045,11 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 	for i := 0; i < len(msgParts)+len(msg.Attachments()); i++ {
 		payload := wacMTPayload{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path()}
 
+		// only the first part of a reply quotes the msg it's replying to
+		if i == 0 {
+			payload.Context = newReplyContext(msg)
+		}
+
 		// do we have a template?
 		var templating *MsgTemplating
 		templating, err := h.getTemplate(msg)
@@ -1472,6 +2071,9 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 						component.Params = append(component.Params, &wacParam{Type: "text", Text: v})
 					}
 					template.Components = append(payload.Template.Components, component)
+				} else if code := templateOTPCode(templating); code != "" {
+					// authentication templates take the code as their only body variable
+					template.Components = append(payload.Template.Components, &wacComponent{Type: "body", Params: []*wacParam{{Type: "text", Text: code}}})
 				}
 
 				if len(msg.Attachments()) > 0 {
@@ -1494,191 +2096,70 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 					if err != nil {
 						return status, err
 					}
-					if attType == "application" {
-						attType = "document"
+
+					param, err := templateHeaderMediaParam(attType, mediaID, parsedURL.String())
+					if err != nil {
+						return nil, err
 					}
+					header.Params = append(header.Params, param)
+					payload.Template.Components = append(payload.Template.Components, header)
+				} else if templating.HeaderMediaURL != "" || templating.HeaderMediaID != "" {
+					// header media can also come from the template definition itself
+					mediaType, mediaID, mediaURL := templating.HeaderMediaType, templating.HeaderMediaID, templating.HeaderMediaURL
+					if mediaID == "" {
+						mimeType := mime.TypeByExtension(filepath.Ext(mediaURL))
+						if mediaType == "" {
+							mediaType = strings.Split(mimeType, "/")[0]
+						}
 
-					media := wacMTMedia{ID: mediaID, Link: parsedURL.String()}
-					if attType == "image" {
-						header.Params = append(header.Params, &wacParam{Type: "image", Image: &media})
-					} else if attType == "video" {
-						header.Params = append(header.Params, &wacParam{Type: "video", Video: &media})
-					} else if attType == "document" {
-						media.Filename, err = utils.BasePathForURL(attURL)
+						fetchedID, mediaLogs, err := h.fetchWACMediaID(msg, mimeType, mediaURL, accessToken)
+						for _, log := range mediaLogs {
+							status.AddLog(log)
+						}
 						if err != nil {
-							return nil, err
+							status.AddLog(courier.NewChannelLogFromError("error on fetch media ID", msg.Channel(), msg.ID(), time.Since(start), err))
+						} else if fetchedID != "" {
+							mediaID = fetchedID
+							mediaURL = ""
 						}
-						header.Params = append(header.Params, &wacParam{Type: "document", Document: &media})
-					} else {
-						return nil, fmt.Errorf("unknown attachment mime type: %s", attType)
 					}
-					payload.Template.Components = append(payload.Template.Components, header)
-				}
 
-			} else {
-				if i < (len(msgParts) + len(msg.Attachments()) - 1) {
-					if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-						text := wacText{}
-						text.PreviewURL = true
-						text.Body = msgParts[i-len(msg.Attachments())]
-						payload.Text = &text
-					} else {
-						payload.Type = "text"
-						payload.Text = &wacText{Body: msgParts[i-len(msg.Attachments())]}
+					param, err := templateHeaderMediaParam(mediaType, mediaID, mediaURL)
+					if err != nil {
+						return nil, err
 					}
-				} else {
-					if len(qrs) > 0 || len(msg.ListMessage().ListItems) > 0 {
-						payload.Type = "interactive"
-						// We can use buttons
-						if len(qrs) > 0 && len(qrs) <= 3 {
-							interactive := wacInteractive{
-								Type: "button",
-								Body: struct {
-									Text string "json:\"text\""
-								}{Text: msgParts[i-len(msg.Attachments())]},
-							}
-
-							if msg.Footer() != "" {
-								interactive.Footer = &struct {
-									Text string "json:\"text,omitempty\""
-								}{Text: msg.Footer()}
-							}
-
-							if msg.HeaderText() != "" {
-								interactive.Header = &struct {
-									Type     string     "json:\"type\""
-									Text     string     "json:\"text,omitempty\""
-									Video    wacMTMedia "json:\"video,omitempty\""
-									Image    wacMTMedia "json:\"image,omitempty\""
-									Document wacMTMedia "json:\"document,omitempty\""
-								}{Type: "text", Text: msg.HeaderText()}
-							}
-
-							btns := make([]wacMTButton, len(qrs))
-							for i, qr := range qrs {
-								btns[i] = wacMTButton{
-									Type: "reply",
-								}
-								btns[i].Reply.ID = fmt.Sprint(i)
-								var text string
-								if strings.Contains(qr, "\\/") {
-									text = strings.Replace(qr, "\\", "", -1)
-								} else if strings.Contains(qr, "\\\\") {
-									text = strings.Replace(qr, "\\\\", "\\", -1)
-								} else {
-									text = qr
-								}
-								btns[i].Reply.Title = text
-							}
-							interactive.Action = &struct {
-								Button            string         "json:\"button,omitempty\""
-								Sections          []wacMTSection "json:\"sections,omitempty\""
-								Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-								CatalogID         string         "json:\"catalog_id,omitempty\""
-								ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-								Name              string         "json:\"name,omitempty\""
-							}{Buttons: btns}
-							payload.Interactive = &interactive
-						} else if len(qrs) <= 10 || len(msg.ListMessage().ListItems) > 0 {
-							interactive := wacInteractive{
-								Type: "list",
-								Body: struct {
-									Text string "json:\"text\""
-								}{Text: msgParts[i-len(msg.Attachments())]},
-							}
-
-							var section wacMTSection
+					payload.Template.Components = append(payload.Template.Components, &wacComponent{Type: "header", Params: []*wacParam{param}})
+				}
 
-							if len(qrs) > 0 {
-								section = wacMTSection{
-									Rows: make([]wacMTSectionRow, len(qrs)),
-								}
-								for i, qr := range qrs {
-									text := parseBacklashes(qr)
-									section.Rows[i] = wacMTSectionRow{
-										ID:    fmt.Sprint(i),
-										Title: text,
-									}
-								}
-							} else if len(msg.ListMessage().ListItems) > 0 {
-								section = wacMTSection{
-									Rows: make([]wacMTSectionRow, len(msg.ListMessage().ListItems)),
-								}
-								for i, listItem := range msg.ListMessage().ListItems {
-									titleText := parseBacklashes(listItem.Title)
-									descriptionText := parseBacklashes(listItem.Description)
-									section.Rows[i] = wacMTSectionRow{
-										ID:          listItem.UUID,
-										Title:       titleText,
-										Description: descriptionText,
-									}
-								}
-								if msg.Footer() != "" {
-									interactive.Footer = &struct {
-										Text string "json:\"text,omitempty\""
-									}{Text: msg.Footer()}
-								}
+				if templating.LimitedTimeOffer != nil {
+					offer := &wacMTOffer{ExpirationTimeMS: templating.LimitedTimeOffer.ExpirationTimeMS}
+					payload.Template.Components = append(payload.Template.Components, &wacComponent{Type: "limited_time_offer", Params: []*wacParam{{Type: "limited_time_offer", LimitedTimeOffer: offer}}})
+				}
 
-								if msg.HeaderText() != "" {
-									interactive.Header = &struct {
-										Type     string     "json:\"type\""
-										Text     string     "json:\"text,omitempty\""
-										Video    wacMTMedia "json:\"video,omitempty\""
-										Image    wacMTMedia "json:\"image,omitempty\""
-										Document wacMTMedia "json:\"document,omitempty\""
-									}{Type: "text", Text: msg.HeaderText()}
-								}
-							}
+				buttons, err := templateButtonComponents(templating.Buttons)
+				if err != nil {
+					return status, errors.Wrapf(err, "unable to build template buttons for channel: %s", msg.Channel().UUID())
+				}
+				payload.Template.Components = append(payload.Template.Components, buttons...)
 
-							interactive.Action = &struct {
-								Button            string         "json:\"button,omitempty\""
-								Sections          []wacMTSection "json:\"sections,omitempty\""
-								Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-								CatalogID         string         "json:\"catalog_id,omitempty\""
-								ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-								Name              string         "json:\"name,omitempty\""
-							}{Button: "Menu", Sections: []wacMTSection{
-								section,
-							}}
-
-							if msg.ListMessage().ButtonText != "" {
-								interactive.Action.Button = msg.ListMessage().ButtonText
-							} else if msg.TextLanguage() != "" {
-								interactive.Action.Button = languageMenuMap[msg.TextLanguage()]
-							}
+				// log sends which don't match our cached definition of the template, they're still sent as it can be stale
+				h.checkTemplate(msg, &template)
 
-							payload.Interactive = &interactive
-						} else {
-							return nil, fmt.Errorf("too many quick replies WAC supports only up to 10 quick replies")
-						}
-					} else if msg.InteractionType() == "location" {
-						payload.Type = "interactive"
-						interactive := wacInteractive{
-							Type: "location_request_message",
-							Body: struct {
-								Text string "json:\"text\""
-							}{Text: msgParts[i-len(msg.Attachments())]},
-							Action: &struct {
-								Button            string         "json:\"button,omitempty\""
-								Sections          []wacMTSection "json:\"sections,omitempty\""
-								Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-								CatalogID         string         "json:\"catalog_id,omitempty\""
-								ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-								Name              string         "json:\"name,omitempty\""
-							}{Name: "send_location"},
-						}
+			} else {
+				part := msgParts[i-len(msg.Attachments())]
+				interactive, err := newMsgInteractive(msg, part)
+				if err != nil {
+					return nil, err
+				}
 
-						payload.Interactive = &interactive
-					} else {
-						// this is still a msg part
-						text := &wacText{}
-						payload.Type = "text"
-						if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-							text.PreviewURL = true
-						}
-						text.Body = msgParts[i-len(msg.Attachments())]
-						payload.Text = text
-					}
+				// only the last part is sent as an interactive message
+				if interactive != nil && i == len(msgParts)+len(msg.Attachments())-1 {
+					h.trackFlowSend(msg, interactive)
+					payload.Type = "interactive"
+					payload.Interactive = interactive.withTextHeader(msg.HeaderText())
+				} else {
+					payload.Type = "text"
+					payload.Text = newTextPart(part)
 				}
 			}
 
@@ -1740,196 +2221,68 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 			}
 			//end
 		} else {
-			if len(qrs) > 0 || len(msg.ListMessage().ListItems) > 0 {
+			// buttons are sent with the first attachment as their header and the first part as their body
+			isButtons := len(qrs) > 0 && len(qrs) <= maxInteractiveButtons && len(msg.ListMessage().ListItems) == 0
+			part := i - len(msg.Attachments())
+			if isButtons {
+				part = i
+			}
+
+			interactive, err := newMsgInteractive(msg, msgParts[part])
+			if err != nil {
+				return nil, err
+			}
+			h.trackFlowSend(msg, interactive)
+
+			if interactive == nil {
+				// this is still a msg part
+				payload.Type = "text"
+				payload.Text = newTextPart(msgParts[part])
+			} else {
 				payload.Type = "interactive"
-				// We can use buttons
-				if len(qrs) <= 3 && len(msg.ListMessage().ListItems) == 0 {
-					hasCaption = true
-					interactive := wacInteractive{
-						Type: "button",
-						Body: struct {
-							Text string "json:\"text\""
-						}{Text: msgParts[i]},
-					}
+				payload.Interactive = interactive
 
-					if len(msg.Attachments()) > 0 {
-						attType, attURL := handlers.SplitAttachment(msg.Attachments()[i])
-						mediaID, mediaLogs, err := h.fetchWACMediaID(msg, attType, attURL, accessToken)
-						for _, log := range mediaLogs {
-							status.AddLog(log)
-						}
-						if err != nil {
-							status.AddLog(courier.NewChannelLogFromError("error on fetch media ID", msg.Channel(), msg.ID(), time.Since(start), err))
-						} else if mediaID != "" {
-							attURL = ""
-						}
-						attType = strings.Split(attType, "/")[0]
-						if attType == "application" {
-							attType = "document"
-						}
-						fileURL := attURL
-						media := wacMTMedia{ID: mediaID, Link: attURL}
-
-						if attType == "image" {
-							interactive.Header = &struct {
-								Type     string     "json:\"type\""
-								Text     string     "json:\"text,omitempty\""
-								Video    wacMTMedia "json:\"video,omitempty\""
-								Image    wacMTMedia "json:\"image,omitempty\""
-								Document wacMTMedia "json:\"document,omitempty\""
-							}{Type: "image", Image: media}
-						} else if attType == "video" {
-							interactive.Header = &struct {
-								Type     string     "json:\"type\""
-								Text     string     "json:\"text,omitempty\""
-								Video    wacMTMedia "json:\"video,omitempty\""
-								Image    wacMTMedia "json:\"image,omitempty\""
-								Document wacMTMedia "json:\"document,omitempty\""
-							}{Type: "video", Video: media}
-						} else if attType == "document" {
-							filename, err := utils.BasePathForURL(fileURL)
-							if err != nil {
-								return nil, err
-							}
-							media.Filename = filename
-							interactive.Header = &struct {
-								Type     string     "json:\"type\""
-								Text     string     "json:\"text,omitempty\""
-								Video    wacMTMedia "json:\"video,omitempty\""
-								Image    wacMTMedia "json:\"image,omitempty\""
-								Document wacMTMedia "json:\"document,omitempty\""
-							}{Type: "document", Document: media}
-						} else if attType == "audio" {
-							var zeroIndex bool
-							if i == 0 {
-								zeroIndex = true
-							}
-							payloadAudio = wacMTPayload{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path(), Type: "audio", Audio: &wacMTMedia{ID: mediaID, Link: attURL}}
-							status, _, err := requestWAC(payloadAudio, token, msg, status, wacPhoneURL, zeroIndex)
-							if err != nil {
-								return status, nil
-							}
-						} else {
-							interactive.Type = "button"
-							interactive.Body.Text = msgParts[i]
-						}
-					}
+				if isButtons {
+					hasCaption = true
 
-					btns := make([]wacMTButton, len(qrs))
-					for i, qr := range qrs {
-						btns[i] = wacMTButton{
-							Type: "reply",
-						}
-						btns[i].Reply.ID = fmt.Sprint(i)
-						text := parseBacklashes(qr)
-						btns[i].Reply.Title = text
+					attType, attURL := handlers.SplitAttachment(msg.Attachments()[i])
+					mediaID, mediaLogs, err := h.fetchWACMediaID(msg, attType, attURL, accessToken)
+					for _, log := range mediaLogs {
+						status.AddLog(log)
 					}
-					interactive.Action = &struct {
-						Button            string         "json:\"button,omitempty\""
-						Sections          []wacMTSection "json:\"sections,omitempty\""
-						Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-						CatalogID         string         "json:\"catalog_id,omitempty\""
-						ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-						Name              string         "json:\"name,omitempty\""
-					}{Buttons: btns}
-					payload.Interactive = &interactive
-					if msg.Footer() != "" {
-						payload.Interactive.Footer = &struct {
-							Text string "json:\"text,omitempty\""
-						}{Text: msg.Footer()}
+					if err != nil {
+						status.AddLog(courier.NewChannelLogFromError("error on fetch media ID", msg.Channel(), msg.ID(), time.Since(start), err))
+					} else if mediaID != "" {
+						attURL = ""
 					}
-				} else if len(qrs) <= 10 || len(msg.ListMessage().ListItems) > 0 {
-					interactive := wacInteractive{
-						Type: "list",
-						Body: struct {
-							Text string "json:\"text\""
-						}{Text: msgParts[i-len(msg.Attachments())]},
+					attType = strings.Split(attType, "/")[0]
+					if attType == "application" {
+						attType = "document"
 					}
+					media := &wacMTMedia{ID: mediaID, Link: attURL}
 
-					var section wacMTSection
-
-					if len(qrs) > 0 {
-						section = wacMTSection{
-							Rows: make([]wacMTSectionRow, len(qrs)),
-						}
-						for i, qr := range qrs {
-							text := parseBacklashes(qr)
-							section.Rows[i] = wacMTSectionRow{
-								ID:    fmt.Sprint(i),
-								Title: text,
-							}
-						}
-					} else {
-						section = wacMTSection{
-							Rows: make([]wacMTSectionRow, len(msg.ListMessage().ListItems)),
-						}
-						for i, listItem := range msg.ListMessage().ListItems {
-							titleText := parseBacklashes(listItem.Title)
-							descriptionText := parseBacklashes(listItem.Description)
-							section.Rows[i] = wacMTSectionRow{
-								ID:          listItem.UUID,
-								Title:       titleText,
-								Description: descriptionText,
-							}
+					if attType == "document" {
+						media.Filename, err = utils.BasePathForURL(attURL)
+						if err != nil {
+							return nil, err
 						}
-						if msg.Footer() != "" {
-							interactive.Footer = &struct {
-								Text string "json:\"text,omitempty\""
-							}{Text: msg.Footer()}
+					} else if attType == "audio" {
+						// audio can't be a header so is sent on its own before the buttons
+						payloadAudio = wacMTPayload{MessagingProduct: "whatsapp", RecipientType: "individual", To: msg.URN().Path(), Type: "audio", Audio: media}
+						status, _, err := h.requestWAC(payloadAudio, token, msg, status, wacPhoneURL)
+						if err != nil {
+							return status, nil
 						}
 					}
-
-					interactive.Action = &struct {
-						Button            string         "json:\"button,omitempty\""
-						Sections          []wacMTSection "json:\"sections,omitempty\""
-						Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-						CatalogID         string         "json:\"catalog_id,omitempty\""
-						ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-						Name              string         "json:\"name,omitempty\""
-					}{Button: "Menu", Sections: []wacMTSection{
-						section,
-					}}
-
-					if msg.ListMessage().ButtonText != "" {
-						interactive.Action.Button = msg.ListMessage().ButtonText
-					} else if msg.TextLanguage() != "" {
-						interactive.Action.Button = languageMenuMap[msg.TextLanguage()]
-					}
-
-					payload.Interactive = &interactive
-				} else {
-					return nil, fmt.Errorf("too many quick replies WAC supports only up to 10 quick replies")
+					interactive.withMediaHeader(attType, media)
 				}
-			} else if msg.InteractionType() == "location" {
-				interactive := wacInteractive{Type: "location_request_message", Body: struct {
-					Text string "json:\"text\""
-				}{Text: msgParts[i-len(msg.Attachments())]}, Action: &struct {
-					Button            string         "json:\"button,omitempty\""
-					Sections          []wacMTSection "json:\"sections,omitempty\""
-					Buttons           []wacMTButton  "json:\"buttons,omitempty\""
-					CatalogID         string         "json:\"catalog_id,omitempty\""
-					ProductRetailerID string         "json:\"product_retailer_id,omitempty\""
-					Name              string         "json:\"name,omitempty\""
-				}{Name: "send_location"}}
-
-				payload.Interactive = &interactive
-			} else {
-				// this is still a msg part
-				text := &wacText{}
-				payload.Type = "text"
-				if strings.Contains(msgParts[i-len(msg.Attachments())], "https://") || strings.Contains(msgParts[i-len(msg.Attachments())], "http://") {
-					text.PreviewURL = true
+
+				if interactive.Header == nil {
+					interactive.withTextHeader(msg.HeaderText())
 				}
-				text.Body = msgParts[i-len(msg.Attachments())]
-				payload.Text = text
 			}
 		}
-		var zeroIndex bool
-		if i == 0 {
-			zeroIndex = true
-		}
-
-		status, respPayload, err := requestWAC(payload, token, msg, status, wacPhoneURL, zeroIndex)
+		status, respPayload, err := h.requestWAC(payload, token, msg, status, wacPhoneURL)
 		if err != nil {
 			return status, err
 		}
@@ -1979,132 +2332,54 @@ func (h *handler) sendCloudAPIWhatsappMsg(ctx context.Context, msg courier.Msg)
 			}
 		}
 
-		var interactiveType string
 		if msg.SendCatalog() {
-			interactiveType = InteractiveProductCatalogMessageType
-		} else if !isUnitaryProduct {
-			interactiveType = InteractiveProductListType
-		} else {
-			interactiveType = InteractiveProductSingleType
-		}
-
-		interactive := wacInteractive{
-			Type: interactiveType,
-		}
-
-		interactive.Body = struct {
-			Text string `json:"text"`
-		}{
-			Text: msg.Body(),
-		}
-
-		if msg.Header() != "" && !isUnitaryProduct && !msg.SendCatalog() {
-			interactive.Header = &struct {
-				Type     string     `json:"type"`
-				Text     string     `json:"text,omitempty"`
-				Video    wacMTMedia `json:"video,omitempty"`
-				Image    wacMTMedia `json:"image,omitempty"`
-				Document wacMTMedia `json:"document,omitempty"`
-			}{
-				Type: "text",
-				Text: msg.Header(),
-			}
-		}
-
-		if msg.Footer() != "" {
-			interactive.Footer = &struct {
-				Text string "json:\"text,omitempty\""
-			}{
-				Text: msg.Footer(),
+			payload.Interactive = newCatalogInteractive(msg.Body()).withFooter(msg.Footer())
+			status, _, err := h.requestWAC(payload, accessToken, msg, status, wacPhoneURL)
+			if err != nil {
+				return status, err
 			}
-		}
-
-		if msg.SendCatalog() {
-			interactive.Action = &struct {
-				Button            string         `json:"button,omitempty"`
-				Sections          []wacMTSection `json:"sections,omitempty"`
-				Buttons           []wacMTButton  `json:"buttons,omitempty"`
-				CatalogID         string         `json:"catalog_id,omitempty"`
-				ProductRetailerID string         `json:"product_retailer_id,omitempty"`
-				Name              string         `json:"name,omitempty"`
-			}{
-				Name: "catalog_message",
-			}
-			payload.Interactive = &interactive
-			status, _, err := requestWAC(payload, accessToken, msg, status, wacPhoneURL, true)
+		} else if len(products) > 0 && isUnitaryProduct {
+			payload.Interactive = newProductInteractive(msg.Body(), catalogID, msg.Action(), unitaryProduct).withFooter(msg.Footer())
+			status, _, err := h.requestWAC(payload, accessToken, msg, status, wacPhoneURL)
 			if err != nil {
 				return status, err
 			}
 		} else if len(products) > 0 {
-			if !isUnitaryProduct {
-				actions := [][]wacMTSection{}
-				sections := []wacMTSection{}
-				i := 0
-
-				for _, product := range products {
-					i++
-					retailerIDs := toStringSlice(product["ProductRetailerIDs"])
-					sproducts := []wacMTProductItem{}
-
-					for _, p := range retailerIDs {
-						sproducts = append(sproducts, wacMTProductItem{
-							ProductRetailerID: p,
-						})
-					}
-
-					title := product["Product"].(string)
-					if title == "product_retailer_id" {
-						title = "items"
-					}
-
-					if len(title) > 24 {
-						title = title[:24]
-					}
+			actions := [][]wacMTSection{}
+			sections := []wacMTSection{}
+			i := 0
+
+			for _, product := range products {
+				i++
+				retailerIDs := toStringSlice(product["ProductRetailerIDs"])
+				sproducts := []wacMTProductItem{}
+
+				for _, p := range retailerIDs {
+					sproducts = append(sproducts, wacMTProductItem{
+						ProductRetailerID: p,
+					})
+				}
 
-					sections = append(sections, wacMTSection{Title: title, ProductItems: sproducts})
+				title := product["Product"].(string)
+				if title == "product_retailer_id" {
+					title = "items"
+				}
 
-					if len(sections) == 6 || i == len(products) {
-						actions = append(actions, sections)
-						sections = []wacMTSection{}
-					}
+				if len(title) > 24 {
+					title = title[:24]
 				}
 
-				for _, sections := range actions {
-					interactive.Action = &struct {
-						Button            string         `json:"button,omitempty"`
-						Sections          []wacMTSection `json:"sections,omitempty"`
-						Buttons           []wacMTButton  `json:"buttons,omitempty"`
-						CatalogID         string         `json:"catalog_id,omitempty"`
-						ProductRetailerID string         `json:"product_retailer_id,omitempty"`
-						Name              string         `json:"name,omitempty"`
-					}{
-						CatalogID: catalogID,
-						Sections:  sections,
-						Name:      msg.Action(),
-					}
+				sections = append(sections, wacMTSection{Title: title, ProductItems: sproducts})
 
-					payload.Interactive = &interactive
-					status, _, err := requestWAC(payload, accessToken, msg, status, wacPhoneURL, true)
-					if err != nil {
-						return status, err
-					}
+				if len(sections) == 6 || i == len(products) {
+					actions = append(actions, sections)
+					sections = []wacMTSection{}
 				}
+			}
 
-			} else {
-				interactive.Action = &struct {
-					Button            string         `json:"button,omitempty"`
-					Sections          []wacMTSection `json:"sections,omitempty"`
-					Buttons           []wacMTButton  `json:"buttons,omitempty"`
-					CatalogID         string         `json:"catalog_id,omitempty"`
-					ProductRetailerID string         `json:"product_retailer_id,omitempty"`
-					Name              string         `json:"name,omitempty"`
-				}{
-					CatalogID:         catalogID,
-					Name:              msg.Action(),
-					ProductRetailerID: unitaryProduct,
-				}
-				payload.Interactive = &interactive
-				status, _, err := requestWAC(payload, accessToken, msg, status, wacPhoneURL, true)
+			for _, sections := range actions {
+				payload.Interactive = newProductListInteractive(msg.Body(), catalogID, msg.Action(), sections).withTextHeader(msg.Header()).withFooter(msg.Footer())
+				status, _, err := h.requestWAC(payload, accessToken, msg, status, wacPhoneURL)
 				if err != nil {
 					return status, err
 				}
@@ -2127,39 +2402,49 @@ func parseBacklashes(baseText string) string {
 	return text
 }
 
-func requestWAC(payload wacMTPayload, accessToken string, msg courier.Msg, status courier.MsgStatus, wacPhoneURL *url.URL, zeroIndex bool) (courier.MsgStatus, *wacMTResponse, error) {
-	jsonBody, err := json.Marshal(payload)
-	if err != nil {
-		return status, &wacMTResponse{}, err
-	}
+func (h *handler) requestWAC(payload wacMTPayload, accessToken string, msg courier.Msg, status courier.MsgStatus, wacPhoneURL *url.URL) (courier.MsgStatus, *wacMTResponse, error) {
+	var rr *utils.RequestResponse
+	var log *courier.ChannelLog
 
-	req, err := http.NewRequest(http.MethodPost, wacPhoneURL.String(), bytes.NewReader(jsonBody))
-	if err != nil {
-		return status, &wacMTResponse{}, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	for attempt := 0; ; attempt++ {
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			return status, &wacMTResponse{}, err
+		}
 
-	rr, err := utils.MakeHTTPRequest(req)
+		req, err := http.NewRequest(http.MethodPost, wacPhoneURL.String(), bytes.NewReader(jsonBody))
+		if err != nil {
+			return status, &wacMTResponse{}, err
+		}
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
 
-	// record our status and log
-	log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
-	status.AddLog(log)
-	if err != nil {
+		rr, err = h.makeGraphRequest(msg, status, req)
+
+		// record our status and log
+		log = courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err == nil {
+			break
+		}
+
+		// media we uploaded earlier may have been purged by Meta, in which case we upload it again and retry once
+		if attempt == 0 && isInvalidMediaError(rr) && h.refreshWACMedia(msg, &payload, accessToken, status) {
+			continue
+		}
+		status.AddPart("", courier.MsgErrored)
 		return status, &wacMTResponse{}, nil
 	}
 
 	respPayload := &wacMTResponse{}
-	err = json.Unmarshal(rr.Body, respPayload)
+	err := json.Unmarshal(rr.Body, respPayload)
 	if err != nil {
 		log.WithError("Message Send Error", errors.Errorf("unable to unmarshal response body"))
 		return status, respPayload, nil
 	}
-	externalID := respPayload.Messages[0].ID
-	if zeroIndex && externalID != "" {
-		status.SetExternalID(externalID)
-	}
+	// record this part, the first external id we get back becoming the external id of the msg
+	status.AddPart(respPayload.Messages[0].ID, courier.MsgWired)
 	// this was wired successfully
 	status.SetStatus(courier.MsgWired)
 
@@ -2184,8 +2469,8 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	}
 
 	// build a request to lookup the stats for this contact
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s", urn.Path()))
+	base := h.graphBaseURL(channel)
+	path, _ := url.Parse(urn.Path())
 	u := base.ResolveReference(path)
 	query := url.Values{}
 
@@ -2217,6 +2502,14 @@ func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn
 	}
 }
 
+// ValidateRequest checks the signature of incoming webhooks so they can be deferred
+func (h *handler) ValidateRequest(ctx context.Context, channel courier.Channel, r *http.Request) (bool, error) {
+	if isBusinessProfileRequest(r) || isOnboardRequest(r) || isCallsRequest(r) {
+		return false, nil
+	}
+	return true, h.validateSignature(r)
+}
+
 // see https://developers.facebook.com/docs/messenger-platform/webhook#security
 func (h *handler) validateSignature(r *http.Request) error {
 	headerSignature := r.Header.Get(signatureHeader)
@@ -2267,25 +2560,11 @@ func fbCalculateSignature(appSecret string, body []byte) (string, error) {
 }
 
 func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
-	mdJSON := msg.Metadata()
-	if len(mdJSON) == 0 {
-		return nil, nil
-	}
-	metadata := &TemplateMetadata{}
-	err := json.Unmarshal(mdJSON, metadata)
-	if err != nil {
+	templating, err := metadata.DecodeTemplating(msg.Metadata())
+	if err != nil || templating == nil {
 		return nil, err
 	}
-	templating := metadata.Templating
-	if templating == nil {
-		return nil, nil
-	}
 
-	// check our template is valid
-	err = handlers.Validate(templating)
-	if err != nil {
-		return nil, errors.Wrapf(err, "invalid templating definition")
-	}
 	// check country
 	if templating.Country != "" {
 		templating.Language = fmt.Sprintf("%s_%s", templating.Language, templating.Country)
@@ -2294,26 +2573,91 @@ func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
 	// map our language from iso639-3_iso3166-2 to the WA country / iso638-2 pair
 	language, found := languageMap[templating.Language]
 	if !found {
-		return nil, fmt.Errorf("unable to find mapping for language: %s", templating.Language)
+		return nil, &languageMappingError{templating.Language}
 	}
 	templating.Language = language
 
-	return templating, err
+	return templating, nil
+}
+
+// MsgTemplating is the template a msg should be sent with, as validated by our metadata schemas
+type MsgTemplating = metadata.Templating
+
+// MsgTemplateLTO is the limited-time offer component of a marketing template
+type MsgTemplateLTO = metadata.TemplateLTO
+
+// MsgTemplateButton is a button of a template which needs a parameter at send time
+type MsgTemplateButton = metadata.TemplateButton
+
+// MsgTemplateOTP is the configuration of the OTP button of an authentication template
+type MsgTemplateOTP = metadata.TemplateOTP
+
+// templateButtonComponents builds the button components of a template, OTP buttons are sent as URL buttons carrying the code
+func templateButtonComponents(buttons []MsgTemplateButton) ([]*wacComponent, error) {
+	components := make([]*wacComponent, 0, len(buttons))
+	for _, button := range buttons {
+		component := &wacComponent{Type: "button", SubType: button.SubType, Index: strconv.Itoa(button.Index)}
+
+		switch button.SubType {
+		case "url":
+			component.Params = []*wacParam{{Type: "text", Text: button.Parameter}}
+		case "quick_reply":
+			component.Params = []*wacParam{{Type: "payload", Payload: button.Parameter}}
+		case "copy_code":
+			if button.Parameter == "" {
+				return nil, fmt.Errorf("missing coupon code for button %d", button.Index)
+			}
+			component.Params = []*wacParam{{Type: "coupon_code", CouponCode: button.Parameter}}
+		case "otp":
+			if button.OTP == nil {
+				return nil, fmt.Errorf("missing otp configuration for button %d", button.Index)
+			}
+			err := handlers.Validate(button.OTP)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid otp configuration for button %d", button.Index)
+			}
+			// the package name and signature hash are part of the template definition, but one-tap can't work without them
+			if button.OTP.Type == "one_tap" && (button.OTP.PackageName == "" || button.OTP.SignatureHash == "") {
+				return nil, fmt.Errorf("one_tap button %d requires a package_name and signature_hash", button.Index)
+			}
+			component.SubType = "url"
+			component.Params = []*wacParam{{Type: "text", Text: button.OTP.Code}}
+		}
+		components = append(components, component)
+	}
+	return components, nil
 }
 
-type TemplateMetadata struct {
-	Templating *MsgTemplating `json:"templating"`
+// templateOTPCode returns the code of the first OTP button of the passed in templating, if any
+func templateOTPCode(templating *MsgTemplating) string {
+	for _, button := range templating.Buttons {
+		if button.SubType == "otp" && button.OTP != nil {
+			return button.OTP.Code
+		}
+	}
+	return ""
 }
 
-type MsgTemplating struct {
-	Template struct {
-		Name string `json:"name" validate:"required"`
-		UUID string `json:"uuid" validate:"required"`
-	} `json:"template" validate:"required,dive"`
-	Language  string   `json:"language" validate:"required"`
-	Country   string   `json:"country"`
-	Namespace string   `json:"namespace"`
-	Variables []string `json:"variables"`
+// templateHeaderMediaParam builds the header parameter of a template for the passed in media type and media id or link
+func templateHeaderMediaParam(mediaType string, mediaID string, link string) (*wacParam, error) {
+	media := wacMTMedia{ID: mediaID, Link: link}
+	switch mediaType {
+	case "image":
+		return &wacParam{Type: "image", Image: &media}, nil
+	case "video":
+		return &wacParam{Type: "video", Video: &media}, nil
+	case "application", "document":
+		if link != "" {
+			filename, err := utils.BasePathForURL(link)
+			if err != nil {
+				return nil, err
+			}
+			media.Filename = filename
+		}
+		return &wacParam{Type: "document", Document: &media}, nil
+	default:
+		return nil, fmt.Errorf("unknown attachment mime type: %s", mediaType)
+	}
 }
 
 // mapping from iso639-3_iso3166-2 to WA language code
@@ -2423,6 +2767,136 @@ var languageMenuMap = map[string]string{
 	"ar-JO": "قائمة",
 }
 
+var (
+	// how many times we retry a media upload which failed with a transient error
+	maxMediaUploadRetries = 2
+
+	// the delay before our first media upload retry, doubled for each retry after that
+	mediaUploadRetryDelay = 500 * time.Millisecond
+
+	// how long we remember transient media failures for, permanent ones are remembered for the cache default
+	transientMediaFailureTTL = time.Minute
+)
+
+// mediaFailure is a failed request to fetch or upload media, which we remember so we don't repeat it for every message
+type mediaFailure struct {
+	StatusCode int // HTTP status of the response, 0 if we didn't get one
+	ErrorCode  int // Graph API error code in the response, if any
+}
+
+// newMediaFailure creates a new media failure from the passed in response
+func newMediaFailure(rr *utils.RequestResponse) *mediaFailure {
+	errorCode, _ := jsonparser.GetInt(rr.Body, "error", "code")
+	return &mediaFailure{StatusCode: rr.StatusCode, ErrorCode: int(errorCode)}
+}
+
+func (f *mediaFailure) Error() string {
+	return fmt.Sprintf("media request failed with status %d and error code %d", f.StatusCode, f.ErrorCode)
+}
+
+// permanent returns whether repeating the request won't help, such as when the media has an unsupported mime type
+func (f *mediaFailure) permanent() bool {
+	if f.StatusCode == http.StatusRequestTimeout || f.StatusCode == http.StatusTooManyRequests || throttleErrorCodes[f.ErrorCode] {
+		return false
+	}
+	return f.StatusCode >= 400 && f.StatusCode < 500
+}
+
+// expiration returns how long we should remember this failure for
+func (f *mediaFailure) expiration() time.Duration {
+	if f.permanent() {
+		return cache.DefaultExpiration
+	}
+	return transientMediaFailureTTL
+}
+
+// Graph API error codes which mean a media ID we sent is no longer valid, such as when Meta has purged the upload
+var invalidMediaErrorCodes = map[int]bool{
+	131052: true, // media download error
+	131053: true, // media upload error
+}
+
+// isInvalidMediaError returns whether the passed in response means a media ID we sent is no longer valid
+func isInvalidMediaError(rr *utils.RequestResponse) bool {
+	errorCode, _ := jsonparser.GetInt(rr.Body, "error", "code")
+	return invalidMediaErrorCodes[int(errorCode)]
+}
+
+// payloadMedia returns all the media in the passed in payload
+func payloadMedia(payload *wacMTPayload) []*wacMTMedia {
+	media := []*wacMTMedia{payload.Document, payload.Image, payload.Audio, payload.Video, payload.Sticker}
+	if payload.Interactive != nil && payload.Interactive.Header != nil {
+		media = append(media, payload.Interactive.Header.Image, payload.Interactive.Header.Video, payload.Interactive.Header.Document)
+	}
+	if payload.Template != nil {
+		for _, component := range payload.Template.Components {
+			for _, param := range component.Params {
+				media = append(media, param.Image, param.Video, param.Document)
+			}
+		}
+	}
+	return media
+}
+
+// refreshWACMedia evicts the cached media IDs used in the passed in payload and replaces them with freshly uploaded
+// media, or links if the upload fails, returning whether any were replaced
+func (h *handler) refreshWACMedia(msg courier.Msg, payload *wacMTPayload, accessToken string, status courier.MsgStatus) bool {
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	// the media of a msg is its attachments or the header media of its template
+	sources := make([][2]string, 0, len(msg.Attachments())+1)
+	for _, attachment := range msg.Attachments() {
+		mimeType, mediaURL := handlers.SplitAttachment(attachment)
+		sources = append(sources, [2]string{mimeType, mediaURL})
+	}
+	if templating, _ := h.getTemplate(msg); templating != nil && templating.HeaderMediaURL != "" {
+		sources = append(sources, [2]string{mime.TypeByExtension(filepath.Ext(templating.HeaderMediaURL)), templating.HeaderMediaURL})
+	}
+
+	cacheKey := fmt.Sprintf(mediaCacheKeyPatternWhatsapp, msg.Channel().UUID().String())
+	refreshed := false
+
+	for _, source := range sources {
+		mimeType, mediaURL := source[0], source[1]
+
+		cachedID, err := rcache.Get(rc, cacheKey, mediaURL)
+		if err != nil || cachedID == "" {
+			continue
+		}
+
+		matches := make([]*wacMTMedia, 0, 1)
+		for _, media := range payloadMedia(payload) {
+			if media != nil && media.ID == cachedID {
+				matches = append(matches, media)
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		rcache.Delete(rc, cacheKey, mediaURL)
+
+		mediaID, logs, err := h.fetchWACMediaID(msg, mimeType, mediaURL, accessToken)
+		for _, log := range logs {
+			status.AddLog(log)
+		}
+		if err != nil {
+			status.AddLog(courier.NewChannelLogFromError("error on refreshing media ID", msg.Channel(), msg.ID(), 0, err))
+		}
+
+		for _, media := range matches {
+			if mediaID != "" {
+				media.ID = mediaID
+			} else {
+				media.ID, media.Link = "", mediaURL
+			}
+		}
+		refreshed = true
+	}
+	return refreshed
+}
+
 func (h *handler) fetchWACMediaID(msg courier.Msg, mimeType, mediaURL string, accessToken string) (string, []*courier.ChannelLog, error) {
 	var logs []*courier.ChannelLog
 
@@ -2453,16 +2927,21 @@ func (h *handler) fetchWACMediaID(msg courier.Msg, mimeType, mediaURL string, ac
 	log := courier.NewChannelLogFromRR("Fetching media", msg.Channel(), msg.ID(), rr).WithError("error fetching media", err)
 	logs = append(logs, log)
 	if err != nil {
-		failedMediaCache.Set(failKey, true, cache.DefaultExpiration)
+		failure := newMediaFailure(rr)
+		failedMediaCache.Set(failKey, failure, failure.expiration())
 		return "", logs, nil
 	}
 
 	// upload media to WhatsAppCloud
-	base, _ := url.Parse(graphURL)
-	path, _ := url.Parse(fmt.Sprintf("/%s/media", msg.Channel().Address()))
+	base := h.graphBaseURL(msg.Channel())
+	path, _ := url.Parse(fmt.Sprintf("%s/media", msg.Channel().Address()))
 	wacPhoneURLMedia := base.ResolveReference(path)
-	mediaID, logs, err = requestWACMediaUpload(rr.Body, mediaURL, wacPhoneURLMedia.String(), mimeType, msg, accessToken)
+	mediaID, uploadLogs, err := requestWACMediaUpload(rr.Body, mediaURL, wacPhoneURLMedia.String(), mimeType, msg, accessToken)
+	logs = append(logs, uploadLogs...)
 	if err != nil {
+		if failure, isFailure := errors.Cause(err).(*mediaFailure); isFailure {
+			failedMediaCache.Set(failKey, failure, failure.expiration())
+		}
 		return "", logs, err
 	}
 
@@ -2514,18 +2993,28 @@ func requestWACMediaUpload(file []byte, mediaURL string, requestUrl string, mime
 		return "", logs, errors.Wrapf(err, "failed to close multipart writer")
 	}
 
-	req, err := http.NewRequest("POST", requestUrl, body)
-	if err != nil {
-		return "", logs, errors.Wrapf(err, "failed to create request")
-	}
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	// the same body is sent for every attempt, a retried upload at worst leaves an unused copy of the media with Meta
+	var resp *utils.RequestResponse
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest("POST", requestUrl, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return "", logs, errors.Wrapf(err, "failed to create request")
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-	resp, err := utils.MakeHTTPRequest(req)
-	log := courier.NewChannelLogFromRR("Uploading media to WhatsApp Cloud", msg.Channel(), msg.ID(), resp).WithError("Error uploading media to WhatsApp Cloud", err)
-	logs = append(logs, log)
-	if err != nil {
-		return "", logs, errors.Wrapf(err, "request failed")
+		resp, err = utils.MakeHTTPRequest(req)
+		log := courier.NewChannelLogFromRR("Uploading media to WhatsApp Cloud", msg.Channel(), msg.ID(), resp).WithError("Error uploading media to WhatsApp Cloud", err)
+		logs = append(logs, log)
+		if err == nil {
+			break
+		}
+
+		failure := newMediaFailure(resp)
+		if failure.permanent() || attempt >= maxMediaUploadRetries {
+			return "", logs, errors.Wrapf(failure, "request failed")
+		}
+		time.Sleep(mediaUploadRetryDelay << uint(attempt))
 	}
 
 	id, err := jsonparser.GetString(resp.Body, "id")
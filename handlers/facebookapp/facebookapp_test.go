@@ -4,17 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gomodule/redigo/redis"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/templates"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/webhooks"
+	"github.com/nyaruka/gocommon/rcache"
 	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testChannelsFBA = []courier.Channel{
@@ -29,6 +44,10 @@ var testChannelsWAC = []courier.Channel{
 	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", "webhook": `{"url": "https://webhook.site", "method": "POST", "headers": {}}`}),
 }
 
+var testChannelsWACCoexistence = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configCoexistence: true}),
+}
+
 var testCasesFBA = []ChannelHandleTestCase{
 	{Label: "Receive Message FBA", URL: "/c/fba/receive", Data: string(courier.ReadFile("./testdata/fba/helloMsgFBA.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp("Hello World"), URN: Sp("facebook:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
@@ -53,6 +72,10 @@ var testCasesFBA = []ChannelHandleTestCase{
 		URN: Sp("facebook:ref:optin_user_ref"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
 		ChannelEvent: Sp(courier.Referral), ChannelEventExtra: map[string]interface{}{"referrer_id": "optin_ref"},
 		PrepRequest: addValidSignature},
+	{Label: "Receive Reply To Checkbox OptIn", URL: "/c/fba/receive", Data: string(courier.ReadFile("./testdata/fba/priorMessageFBA.json")), Status: 200, Response: "Handled",
+		Text: Sp("Hello World"), URN: Sp("facebook:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
+		ChannelEvent: Sp(courier.ContactMerged), ChannelEventExtra: map[string]interface{}{"user_ref": "optin_user_ref"},
+		PrepRequest: addValidSignature},
 	{Label: "Receive OptIn", URL: "/c/fba/receive", Data: string(courier.ReadFile("./testdata/fba/optIn.json")), Status: 200, Response: "Handled",
 		URN: Sp("facebook:5678"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)),
 		ChannelEvent: Sp(courier.Referral), ChannelEventExtra: map[string]interface{}{"referrer_id": "optin_ref"},
@@ -76,6 +99,15 @@ var testCasesFBA = []ChannelHandleTestCase{
 		ChannelEventExtra: map[string]interface{}{"referrer_id": "referral id", "source": "referral source", "type": "referral type", "ad_id": "ad id"},
 		PrepRequest:       addValidSignature},
 
+	{Label: "Receive Messaging Feedback", URL: "/c/fba/receive", Data: string(courier.ReadFile("./testdata/fba/messagingFeedback.json")), Status: 200, Response: `"type":"event"`,
+		Text: Sp("4|Quick and helpful|More agents please"),
+		URN:  Sp("facebook:5678"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)), ChannelEvent: Sp(courier.MsgFeedback),
+		ChannelEventExtra: map[string]interface{}{"screen_id": 0, "questions": []interface{}{
+			map[string]interface{}{"id": "myquestion1", "type": "csat", "payload": "4", "score": 4, "follow_up": "Quick and helpful"},
+			map[string]interface{}{"id": "myquestion2", "type": "free_form", "payload": "More agents please"},
+		}},
+		PrepRequest: addValidSignature},
+
 	{Label: "Receive DLR", URL: "/c/fba/receive", Data: string(courier.ReadFile("./testdata/fba/dlr.json")), Status: 200, Response: "Handled",
 		Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC)), MsgStatus: Sp(courier.MsgDelivered), ExternalID: Sp("mid.1458668856218:ed81099e15d3f4f233"),
 		PrepRequest: addValidSignature},
@@ -121,7 +153,14 @@ var testCasesIG = []ChannelHandleTestCase{
 	{Label: "Not JSON", URL: "/c/ig/receive", Data: "not JSON", Status: 400, Response: "Error", PrepRequest: addValidSignature},
 	{Label: "Invalid URN", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/invalidURNIG.json")), Status: 400, Response: "invalid instagram id", PrepRequest: addValidSignature},
 	{Label: "Story Mention", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/storyMentionIG.json")), Status: 200, Response: `ignoring story_mention`, PrepRequest: addValidSignature},
-	{Label: "Message unsent", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/unsentMsgIG.json")), Status: 200, Response: `msg deleted`, PrepRequest: addValidSignature},
+	{Label: "Receive Comment", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/comment.json")), Status: 200, Response: "Handled",
+		Text: Sp("How much is this?"), URN: Sp("instagram:5678"), Name: Sp("bob"), ExternalID: Sp("17865799348089039"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 0, time.UTC)),
+		Metadata:    Jp(json.RawMessage(`{"ig_comment_id": "17865799348089039", "ig_parent_comment_id": "17858893269000001", "ig_media_id": "17866372834052316", "ig_username": "bob"}`)),
+		PrepRequest: addValidSignature},
+	{Label: "Own Comment", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/ownComment.json")), Status: 200, Response: "ignoring own comment", PrepRequest: addValidSignature},
+	{Label: "Message unsent", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/unsentMsgIG.json")), Status: 200, Response: `msg deleted`,
+		URN: Sp("instagram:5678"), ChannelEvent: Sp(courier.MsgDeleted), ChannelEventExtra: map[string]interface{}{"msg_external_id": "external_id"}, PrepRequest: addValidSignature},
+	{Label: "Message unsent again", URL: "/c/ig/receive", Data: string(courier.ReadFile("./testdata/ig/unsentMsgIG.json")), Status: 200, Response: `ignoring unsend, no msg to delete`, PrepRequest: addValidSignature},
 }
 
 func addValidSignature(r *http.Request) {
@@ -159,11 +198,17 @@ func buildMockFBGraphFBA(testCases []ChannelHandleTestCase) *httptest.Server {
 		// no name
 		w.Write([]byte(`{ "first_name": "", "last_name": ""}`))
 	}))
-	graphURL = server.URL
 
 	return server
 }
 
+// newGraphHandler returns a handler which makes its Graph API calls against the passed in URL
+func newGraphHandler(channelType courier.ChannelType, name string, graphURL string) courier.ChannelHandler {
+	h := newHandler(channelType, name, false).(*handler)
+	h.graphURL = graphURL
+	return h
+}
+
 // mocks the call to the Facebook graph API
 func buildMockFBGraphIG(testCases []ChannelHandleTestCase) *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -184,7 +229,6 @@ func buildMockFBGraphIG(testCases []ChannelHandleTestCase) *httptest.Server {
 		// no name
 		w.Write([]byte(`{ "name": ""}`))
 	}))
-	graphURL = server.URL
 
 	return server
 }
@@ -193,7 +237,7 @@ func TestDescribeFBA(t *testing.T) {
 	fbGraph := buildMockFBGraphFBA(testCasesFBA)
 	defer fbGraph.Close()
 
-	handler := newHandler("FBA", "Facebook", false).(courier.URNDescriber)
+	handler := newGraphHandler("FBA", "Facebook", fbGraph.URL).(courier.URNDescriber)
 	tcs := []struct {
 		urn      urns.URN
 		metadata map[string]string
@@ -211,7 +255,7 @@ func TestDescribeIG(t *testing.T) {
 	fbGraph := buildMockFBGraphIG(testCasesIG)
 	defer fbGraph.Close()
 
-	handler := newHandler("IG", "Instagram", false).(courier.URNDescriber)
+	handler := newGraphHandler("IG", "Instagram", fbGraph.URL).(courier.URNDescriber)
 	tcs := []struct {
 		urn      urns.URN
 		metadata map[string]string
@@ -239,6 +283,73 @@ func TestDescribeWAC(t *testing.T) {
 	}
 }
 
+func TestGraphBaseURL(t *testing.T) {
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+
+	prod := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "", map[string]interface{}{})
+	sandbox := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "WAC", "12345", "", map[string]interface{}{courier.ConfigBaseURL: "https://sandbox.example.com/v12.0/"})
+
+	upgraded := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ad", "WAC", "12345", "", map[string]interface{}{configGraphAPIVersion: "v18.0"})
+
+	assert.Equal(t, "https://graph.facebook.com/v12.0/", h.graphBaseURL(prod).String())
+	assert.Equal(t, "https://sandbox.example.com/v12.0/", h.graphBaseURL(sandbox).String())
+	assert.Equal(t, "https://sandbox.example.com/v12.0/me/messages", h.graphBaseURL(sandbox).ResolveReference(&url.URL{Path: "me/messages"}).String())
+	assert.Equal(t, "https://graph.facebook.com/v18.0/12345/messages", h.graphBaseURL(upgraded).ResolveReference(&url.URL{Path: "12345/messages"}).String())
+
+	// the version in our config is used for channels which don't set their own and calls not made for a channel
+	config := courier.NewConfig()
+	config.GraphAPIVersion = "v17.0"
+	h.SetServer(courier.NewServer(config, courier.NewMockBackend()))
+
+	assert.Equal(t, "https://graph.facebook.com/v17.0/", h.graphBaseURL(prod).String())
+	assert.Equal(t, "https://graph.facebook.com/v18.0/", h.graphBaseURL(upgraded).String())
+	assert.Equal(t, "https://graph.facebook.com/v17.0/debug_token", h.graphRootURL("debug_token", nil))
+}
+
+func TestSimulateSend(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+	assert.True(t, courier.CanSimulateSends(h))
+
+	// WhatsApp Cloud sends can be simulated as their Graph API URL can come from their config
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "", map[string]interface{}{courier.ConfigUserToken: "user_token"})
+	simulation, err := courier.SimulateSend(context.Background(), mb, h, channel, urns.URN("whatsapp:250788123123"), "Pick one", nil, []string{"Yes", "No"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, simulation.Status)
+	require.Len(t, simulation.Requests, 1)
+	assert.Equal(t, "/12345/messages", simulation.Requests[0].Path)
+	assert.Equal(t, "********", simulation.Requests[0].Headers["Authorization"])
+	assert.Contains(t, simulation.Requests[0].Body, `"type":"button"`)
+}
+
+func TestWarnGraphVersion(t *testing.T) {
+	hook := logrustest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+
+	removal, found := graphVersionRemovalDate("v12.0")
+	assert.True(t, found)
+	assert.Equal(t, time.Date(2023, 9, 14, 0, 0, 0, 0, time.UTC), removal)
+
+	_, found = graphVersionRemovalDate("v99.0")
+	assert.False(t, found)
+
+	// well before its removal we don't warn
+	h.warnGraphVersion(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Len(t, hook.AllEntries(), 0)
+
+	h.warnGraphVersion(time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC))
+	assert.Len(t, hook.AllEntries(), 1)
+	assert.Equal(t, "graph API version is approaching its deprecation date, upgrade it with the GraphAPIVersion config", hook.LastEntry().Message)
+	assert.Equal(t, "2023-09-14", hook.LastEntry().Data["removal_date"])
+
+	h.warnGraphVersion(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.Len(t, hook.AllEntries(), 2)
+	assert.Equal(t, "graph API version is past its deprecation date, upgrade it with the GraphAPIVersion config", hook.LastEntry().Message)
+}
+
 func TestResolveMediaURL(t *testing.T) {
 
 	tcs := []struct {
@@ -249,14 +360,112 @@ func TestResolveMediaURL(t *testing.T) {
 	}{{"id_media", "", "", "missing token for WAC channel"},
 		{"id_media", "token", "", `unsupported protocol scheme ""`}}
 
-	graphURL = "url"
+	h := newGraphHandler("WAC", "Cloud API WhatsApp", "url").(*handler)
 
 	for _, tc := range tcs {
-		_, err := resolveMediaURL(testChannelsWAC[0], tc.id, tc.token)
+		_, err := h.resolveMediaURL(testChannelsWAC[0], tc.id, tc.token)
 		assert.Equal(t, err.Error(), tc.err)
 	}
 }
 
+func TestResolveExpiredMediaURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v12.0/1234567890", r.URL.Path)
+		assert.Equal(t, "Bearer sesame", r.Header.Get("Authorization"))
+		w.Write([]byte(`{"url": "https://lookaside.fbsbx.com/whatsapp_business/attachments/?mid=1234567890&ext=1700000000&hash=ATy", "sha256": "a3f4", "file_size": "303833"}`))
+	}))
+	defer server.Close()
+
+	config := courier.NewConfig()
+	config.WhatsappAdminSystemUserToken = "sesame"
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "", map[string]interface{}{courier.ConfigBaseURL: server.URL + "/v12.0/"})
+	expired := "https://lookaside.fbsbx.com/whatsapp_business/attachments/?mid=1234567890&ext=1690000000&hash=ATs"
+
+	fresh, err := h.ResolveMediaURL(context.Background(), config, channel, expired)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://lookaside.fbsbx.com/whatsapp_business/attachments/?mid=1234567890&ext=1700000000&hash=ATy", fresh)
+
+	// we remember what WhatsApp told us of the media to validate its download
+	assert.Equal(t, &courier.MediaChecksum{Size: 303833, SHA256: "a3f4"}, h.MediaChecksum(channel, fresh))
+	assert.Nil(t, h.MediaChecksum(channel, expired))
+
+	// media which isn't WhatsApp's isn't resolved
+	fresh, err = h.ResolveMediaURL(context.Background(), config, channel, "https://s3.amazonaws.com/courier/media/123.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "", fresh)
+
+	fresh, err = h.ResolveMediaURL(context.Background(), config, courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "FBA", "12345", "", nil), expired)
+	assert.NoError(t, err)
+	assert.Equal(t, "", fresh)
+}
+
+func TestMergeCheckboxContact(t *testing.T) {
+	mb := courier.NewMockBackend()
+	h := newHandler("FBA", "Facebook", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	channel := testChannelsFBA[0]
+	refURN, _ := urns.NewFacebookURN(urns.FacebookRefPrefix + "optin_user_ref")
+	realURN, _ := urns.NewFacebookURN("5678")
+	extURN, _ := urns.NewURNFromParts(urns.ExternalScheme, "optin_user_ref", "", "")
+
+	optedIn, _ := mb.GetContact(context.Background(), channel, refURN, "", "")
+
+	event, err := h.mergeCheckboxContact(context.Background(), channel, "optin_user_ref", realURN, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, courier.ContactMerged, event.EventType())
+	assert.Equal(t, realURN, event.URN())
+
+	// their real URN and an ext URN of their referral are now theirs
+	contact, _ := mb.GetContact(context.Background(), channel, realURN, "", "")
+	assert.Equal(t, optedIn.UUID(), contact.UUID())
+	contact, _ = mb.GetContact(context.Background(), channel, extURN, "", "")
+	assert.Equal(t, optedIn.UUID(), contact.UUID())
+
+	// and their referral URN isn't anyone's
+	contact, _ = mb.LookupContact(context.Background(), channel, refURN)
+	assert.Nil(t, contact)
+
+	// so a retried webhook or another reply to the opt in has nothing to merge, and doesn't create a contact
+	event, err = h.mergeCheckboxContact(context.Background(), channel, "optin_user_ref", realURN, time.Now())
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+	contact, _ = mb.LookupContact(context.Background(), channel, refURN)
+	assert.Nil(t, contact)
+	contact, _ = mb.LookupContact(context.Background(), channel, realURN)
+	assert.Equal(t, optedIn.UUID(), contact.UUID())
+
+	// nor is there anything to merge when the real URN is already the contact's
+	optedIn, _ = mb.GetContact(context.Background(), channel, refURN, "", "")
+	mb.AddURNtoContact(context.Background(), channel, optedIn, realURN)
+	event, err = h.mergeCheckboxContact(context.Background(), channel, "optin_user_ref", realURN, time.Now())
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+}
+
+func TestSendParallelism(t *testing.T) {
+	config := courier.NewConfig()
+	config.WhatsappCloudSendParallelism = 2
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(config, courier.NewMockBackend()))
+
+	// WAC channels send as many msgs at once as our config says
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "", nil)
+	assert.Equal(t, 2, h.SendParallelism(channel))
+
+	// unless they set their own parallelism, or none
+	channel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "WAC", "12346", "", map[string]interface{}{configSendParallelism: 5})
+	assert.Equal(t, 5, h.SendParallelism(channel))
+	channel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ad", "WAC", "12347", "", map[string]interface{}{configSendParallelism: 0})
+	assert.Equal(t, 0, h.SendParallelism(channel))
+
+	// and msgs of other channel types are sent like any other
+	assert.Equal(t, 0, h.SendParallelism(testChannelsFBA[0]))
+}
+
 var wacReceiveURL = "/c/wac/receive"
 
 var testCasesWAC = []ChannelHandleTestCase{
@@ -337,6 +546,11 @@ var testCasesWAC = []ChannelHandleTestCase{
 		PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Sticker Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/stickerWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp(""), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Attachment: Sp("https://foo.bar/attachmentURL_Sticker"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"sticker": map[string]interface{}{"id": "id_sticker", "animated": false, "mime_type": "image/webp", "sha256": "29ed500fa64eb55fc19dc4124acb300e5dcc54a0f822a301ae99944db"}}),
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Known Animated Sticker Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/animatedStickerWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
+		Text: Sp("👍"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Attachment: Sp("https://foo.bar/attachmentURL"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"sticker": map[string]interface{}{"id": "369239263222822", "animated": true, "mime_type": "image/webp", "sha256": "29ed500fa64eb55fc19dc4124acb300e5dcc54a0f822a301ae99944db"}}),
 		PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Video Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/videoWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp("Check out my new phone!"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Attachment: Sp("https://foo.bar/attachmentURL_Video"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
@@ -347,12 +561,36 @@ var testCasesWAC = []ChannelHandleTestCase{
 	{Label: "Receive Valid Location Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/locationWAC.json")), Status: 200, Response: `"type":"msg"`,
 		Text: Sp(""), Attachment: Sp("geo:0.000000,1.000000;name:Main Street Beach;address:Main Street Beach, Santa Cruz, CA"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
 		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Live Location", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/liveLocationWAC.json")), Status: 200, Response: `"type":"msg"`,
+		Text: Sp(""), Attachment: Sp("geo:-1.950000,30.060000;name:;address:"), URN: Sp("whatsapp:5678"), ExternalID: Sp("live_location_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"live_location": map[string]interface{}{"message_id": "live_location_id", "sequence": 0, "live_period": 900}}),
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Live Location Update", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/liveLocationUpdateWAC.json")), Status: 200, Response: `"type":"msg"`,
+		Text: Sp(""), Attachment: Sp("geo:-1.951000,30.061000;name:;address:"), URN: Sp("whatsapp:5678"), ExternalID: Sp("live_location_update_id"), Date: Tp(time.Date(2016, 1, 30, 1, 58, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"live_location": map[string]interface{}{"message_id": "live_location_id", "sequence": 1, "live_period": 900}}),
+		PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Interactive Button Reply Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/buttonReplyWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp("Yes"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
 		PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Interactive List Reply Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/listReplyWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp("Yes"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
 		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Reaction", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/contract/wac_reaction.json")), Status: 200, Response: `"type":"event"`, NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
+		URN: Sp("whatsapp:5678"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)), ChannelEvent: Sp(courier.MsgReaction),
+		ChannelEventExtra: map[string]interface{}{"emoji": "👍", "msg_external_id": "wamid.HBgMNTU2MTk4NjU0MzIxFQIAERgSQUM", "external_id": "wamid.HBgMNTU2MTk4NjU0MzIxFQIAEhggRDM"},
+		PrepRequest:       addValidSignatureWAC},
+	{Label: "Receive Location Request Denied", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/locationRequestDeniedWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
+		Text: Sp(""), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"location_request": map[string]interface{}{"status": "denied", "context_id": "wamid.location_request"}}),
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Address Reply", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/addressWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
+		Text: Sp("Bob, Rua Augusta 100, São Paulo"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"address": map[string]interface{}{"values": map[string]interface{}{"name": "Bob", "address": "Rua Augusta 100", "city": "São Paulo"}, "context_id": "wamid.address_request"}}),
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Saved Address Reply", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/addressSavedWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
+		Text: Sp("Home"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"address": map[string]interface{}{"saved_address_id": "home", "context_id": "wamid.address_request"}}),
+		PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Contact Message", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/contactWAC.json")), Status: 200, Response: "Handled", NoQueueErrorCheck: true, NoInvalidChannelCheck: true,
 		Text: Sp("+1 415-858-6273, +1 415-858-6274"), URN: Sp("whatsapp:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)), PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Invalid JSON", URL: wacReceiveURL, Data: "not json", Status: 400, Response: "unable to parse", PrepRequest: addValidSignatureWAC},
@@ -363,14 +601,41 @@ var testCasesWAC = []ChannelHandleTestCase{
 		MsgStatus: Sp("S"), ExternalID: Sp("external_id"), PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Valid Delivered Status", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/validDeliveredStatusWAC.json")), Status: 200, Response: `"type":"status"`,
 		MsgStatus: Sp("D"), ExternalID: Sp("external_id"), PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Failed Status", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/failedStatusWAC.json")), Status: 200, Response: `"error_class":"invalid_recipient"`,
+		MsgStatus: Sp("F"), ExternalID: Sp("external_id"), PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Invalid Status", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/invalidStatusWAC.json")), Status: 400, Response: `"unknown status: in_orbit"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Ignore Status", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/ignoreStatusWAC.json")), Status: 200, Response: `"ignoring status: deleted"`, PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Payment Captured", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/paymentCapturedWAC.json")), Status: 200, Response: `"type":"event"`,
+		URN: Sp("whatsapp:5678"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)), ChannelEvent: Sp(courier.OrderPayment),
+		ChannelEventExtra: map[string]interface{}{"external_id": "external_id", "reference_id": "order_123", "status": "captured", "amount_value": 21000, "amount_offset": 100, "currency": "BRL",
+			"transaction_id": "tx_1", "transaction_type": "pix", "transaction_status": "success", "payment_method": "pix"},
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Payment Failed", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/paymentFailedWAC.json")), Status: 200, Response: `"type":"event"`,
+		URN: Sp("whatsapp:5678"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)), ChannelEvent: Sp(courier.OrderPayment),
+		ChannelEventExtra: map[string]interface{}{"external_id": "external_id", "reference_id": "order_123", "status": "failed", "amount_value": 21000, "amount_offset": 100, "currency": "BRL",
+			"transaction_id": "tx_2", "transaction_type": "pix", "transaction_status": "failed", "payment_method": "pix", "error_code": 500, "error_reason": "payment_expired"},
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive Payment Without Reference", URL: wacReceiveURL, Data: strings.Replace(string(courier.ReadFile("./testdata/wac/paymentCapturedWAC.json")), "order_123", "", 1), Status: 400,
+		Response: `"payment status missing reference_id"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Not Changes", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/notchangesWAC.json")), Status: 400, Response: `"no changes found"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Not Channel Address", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/notchanneladdressWAC.json")), Status: 400, Response: `"no channel address found"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Empty Entry", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/emptyEntryWAC.json")), Status: 400, Response: `"no entries found"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Empty Changes", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/emptyChangesWAC.json")), Status: 200, Response: `"Events Handled"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Empty Contacts", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/emptyContactsWAC.json")), Status: 400, Response: `"no shared contact"`, PrepRequest: addValidSignatureWAC},
 	{Label: "Receive Unsupported Message Type", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/invalidTypeMsgWAC.json")), Status: 200, Response: `"Events Handled"`, PrepRequest: addValidSignatureWAC},
+	{Label: "Ignore Echo Without Coexistence", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/echoWAC.json")), Status: 200, Response: `"ignoring smb_message_echoes, coexistence not enabled"`, PrepRequest: addValidSignatureWAC},
+	{Label: "Ignore History Without Coexistence", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/historyWAC.json")), Status: 200, Response: `"ignoring history, coexistence not enabled"`, PrepRequest: addValidSignatureWAC},
+}
+
+var testCasesWACCoexistence = []ChannelHandleTestCase{
+	{Label: "Receive Echo", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/echoWAC.json")), Status: 200, Response: `"type":"msg"`,
+		Text: Sp("Sent from the phone"), URN: Sp("whatsapp:5678"), ExternalID: Sp("echo_id"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"coexistence": map[string]interface{}{"source": "smb_message_echoes"}}),
+		PrepRequest: addValidSignatureWAC},
+	{Label: "Receive History", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/historyWAC.json")), Status: 200, Response: `"external_id":"history_id_1"`,
+		URN: Sp("whatsapp:5678"), ExternalID: Sp("history_id_2"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata:    Jp(map[string]interface{}{"coexistence": map[string]interface{}{"source": "history", "status": "DELIVERED"}}),
+		PrepRequest: addValidSignatureWAC},
 }
 
 func TestHandler(t *testing.T) {
@@ -418,22 +683,22 @@ func TestHandler(t *testing.T) {
 		w.Write([]byte(`{"url": "https://foo.bar/attachmentURL"}`))
 
 	}))
-	graphURL = server.URL
 
-	RunChannelTestCases(t, testChannelsWAC, newHandler("WAC", "Cloud API WhatsApp", false), testCasesWAC)
-	RunChannelTestCases(t, testChannelsFBA, newHandler("FBA", "Facebook", false), testCasesFBA)
-	RunChannelTestCases(t, testChannelsIG, newHandler("IG", "Instagram", false), testCasesIG)
+	RunChannelTestCases(t, testChannelsWAC, newGraphHandler("WAC", "Cloud API WhatsApp", server.URL), testCasesWAC)
+	RunChannelTestCases(t, testChannelsWACCoexistence, newGraphHandler("WAC", "Cloud API WhatsApp", server.URL), testCasesWACCoexistence)
+	RunChannelTestCases(t, testChannelsFBA, newGraphHandler("FBA", "Facebook", server.URL), testCasesFBA)
+	RunChannelTestCases(t, testChannelsIG, newGraphHandler("IG", "Instagram", server.URL), testCasesIG)
 }
 
 func BenchmarkHandler(b *testing.B) {
 	fbService := buildMockFBGraphFBA(testCasesFBA)
 
-	RunChannelBenchmarks(b, testChannelsFBA, newHandler("FBA", "Facebook", false), testCasesFBA)
+	RunChannelBenchmarks(b, testChannelsFBA, newGraphHandler("FBA", "Facebook", fbService.URL), testCasesFBA)
 	fbService.Close()
 
 	fbServiceIG := buildMockFBGraphIG(testCasesIG)
 
-	RunChannelBenchmarks(b, testChannelsIG, newHandler("IG", "Instagram", false), testCasesIG)
+	RunChannelBenchmarks(b, testChannelsIG, newGraphHandler("IG", "Instagram", fbServiceIG.URL), testCasesIG)
 	fbServiceIG.Close()
 }
 
@@ -467,10 +732,172 @@ func TestVerify(t *testing.T) {
 	})
 }
 
-// setSendURL takes care of setting the send_url to our test server host
+// the status credentials of our test server, which our admin routes require
+var adminHeaders = map[string]string{"Authorization": "Basic YWRtaW46c2VzYW1l"}
+
+func TestBusinessProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer wac_admin_system_user_token" {
+			http.Error(w, "invalid auth token", 403)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v12.0/12345":
+			w.Write([]byte(`{"verified_name": "Weni", "quality_rating": "GREEN", "display_phone_number": "+1 555-0100", "id": "12345"}`))
+		case "/v12.0/12345/whatsapp_business_profile":
+			if r.Method == http.MethodPost {
+				body, _ := io.ReadAll(r.Body)
+				assert.JSONEq(t, `{"messaging_product": "whatsapp", "about": "New about", "vertical": "RETAIL"}`, string(body))
+				w.Write([]byte(`{"success": true}`))
+				return
+			}
+			w.Write([]byte(`{"data": [{"about": "Hello", "address": "Main St", "vertical": "EDU", "messaging_product": "whatsapp"}]}`))
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}))
+	defer server.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{})
+	url := "/c/wac/business_profile?channel=8eb23e93-5ecb-45ba-b726-3b064e0c568c"
+
+	RunChannelTestCases(t, []courier.Channel{channel}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL), []ChannelHandleTestCase{
+		{Label: "Refresh Business Profile", URL: url, Headers: adminHeaders, Data: "{}", Status: 200, Response: `"display_name":"Weni"`, NoQueueErrorCheck: true},
+		{Label: "Refresh Unknown Channel", URL: "/c/wac/business_profile?channel=foo", Data: "{}", Status: 400, Response: "channel not found"},
+		{Label: "Refresh Without Auth", URL: url, Data: "{}", Status: 401, Response: "invalid Authorization header"},
+	})
+
+	assert.Equal(t, "Weni", channel.StringConfigForKey(configDisplayName, ""))
+	assert.Equal(t, "GREEN", channel.StringConfigForKey(configQualityRating, ""))
+	assert.Equal(t, wacBusinessProfile{About: "Hello", Address: "Main St", Vertical: "EDU"}, channel.ConfigForKey(configBusinessProfile, nil))
+
+	RunChannelTestCases(t, []courier.Channel{channel}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL), []ChannelHandleTestCase{
+		{Label: "Update Business Profile", URL: url, Headers: adminHeaders, Data: `{"about": "New about", "vertical": "RETAIL"}`, Status: 200, Response: "Business profile updated", NoQueueErrorCheck: true,
+			PrepRequest: func(r *http.Request) { r.Method = http.MethodPatch }},
+		{Label: "Update Invalid Body", URL: url, Headers: adminHeaders, Data: "not json", Status: 400, Response: "unable to parse request JSON",
+			PrepRequest: func(r *http.Request) { r.Method = http.MethodPatch }},
+	})
+
+	assert.Equal(t, &wacBusinessProfile{About: "New about", Vertical: "RETAIL"}, channel.ConfigForKey(configBusinessProfile, nil))
+}
+
+func TestOnboard(t *testing.T) {
+	subscribed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v12.0/oauth/access_token":
+			assert.Equal(t, "wac_app_id", r.URL.Query().Get("client_id"))
+			assert.Equal(t, "wac_app_secret", r.URL.Query().Get("client_secret"))
+			if r.URL.Query().Get("code") != "valid_code" {
+				http.Error(w, `{"error": {"message": "Invalid verification code format."}}`, 400)
+				return
+			}
+			w.Write([]byte(`{"access_token": "system_user_token", "token_type": "bearer"}`))
+		case "/v12.0/debug_token":
+			assert.Equal(t, "Bearer wac_app_id|wac_app_secret", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"data": {"granular_scopes": [{"scope": "whatsapp_business_messaging", "target_ids": ["111"]}, {"scope": "whatsapp_business_management", "target_ids": ["102290129340398"]}]}}`))
+		case "/v12.0/102290129340398/phone_numbers":
+			assert.Equal(t, "Bearer system_user_token", r.Header.Get("Authorization"))
+			w.Write([]byte(`{"data": [{"id": "12345", "display_phone_number": "+1 555-0100", "verified_name": "Weni"}, {"id": "67890", "display_phone_number": "+1 555-0101", "verified_name": "Weni Support"}]}`))
+		case "/v12.0/102290129340398/subscribed_apps":
+			subscribed = true
+			w.Write([]byte(`{"success": true}`))
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}))
+	defer server.Close()
+
+	existing := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigUserToken: "old_token"})
+	existing.SetOrgID(1)
+
+	state := signOnboardState("wac_onboard_secret", 1, time.Now().Add(time.Hour))
+	onboardData := func(state, code string) string {
+		return fmt.Sprintf(`{"state": %q, "code": %q}`, state, code)
+	}
+
+	RunChannelTestCases(t, []courier.Channel{existing}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL+"/"), []ChannelHandleTestCase{
+		{Label: "Missing Code", URL: "/c/wac/onboard", Headers: adminHeaders, Data: fmt.Sprintf(`{"state": %q}`, state), Status: 400, Response: "Field validation for 'Code' failed",
+			NoQueueErrorCheck: true, NoInvalidChannelCheck: true},
+		{Label: "Without Auth", URL: "/c/wac/onboard", Data: onboardData(state, "valid_code"), Status: 401, Response: "invalid Authorization header"},
+		{Label: "Invalid State", URL: "/c/wac/onboard", Headers: adminHeaders, Data: onboardData("1:9999999999:abc", "valid_code"), Status: 401, Response: "invalid onboard state signature"},
+		{Label: "Expired State", URL: "/c/wac/onboard", Headers: adminHeaders, Data: onboardData(signOnboardState("wac_onboard_secret", 1, time.Now().Add(-time.Minute)), "valid_code"), Status: 401, Response: "onboard state has expired"},
+		{Label: "Invalid Code", URL: "/c/wac/onboard", Headers: adminHeaders, Data: onboardData(state, "invalid_code"), Status: 400, Response: "unable to exchange signup code"},
+		{Label: "Another Org", URL: "/c/wac/onboard", Headers: adminHeaders, Data: onboardData(signOnboardState("wac_onboard_secret", 2, time.Now().Add(time.Hour)), "valid_code"), Status: 400, Response: "phone number +1 555-0100 already has a channel in another org"},
+	})
+
+	// channels of other orgs are left alone
+	assert.False(t, subscribed)
+	assert.Equal(t, "old_token", existing.StringConfigForKey(courier.ConfigUserToken, ""))
+
+	RunChannelTestCases(t, []courier.Channel{existing}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL+"/"), []ChannelHandleTestCase{
+		{Label: "Onboard", URL: "/c/wac/onboard", Headers: adminHeaders, Data: onboardData(state, "valid_code"), Status: 200,
+			Response:          `{"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c568c","address":"12345","name":"Weni","created":false}`,
+			NoQueueErrorCheck: true, NoInvalidChannelCheck: true},
+	})
+
+	// our existing channel was claimed with the new token
+	assert.True(t, subscribed)
+	assert.Equal(t, "system_user_token", existing.StringConfigForKey(courier.ConfigUserToken, ""))
+	assert.Equal(t, "102290129340398", existing.StringConfigForKey(configWABAID, ""))
+	assert.Equal(t, "+1 555-0100", existing.StringConfigForKey(configWANumber, ""))
+}
+
+func TestParseOnboardState(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	state := signOnboardState("sesame", 12, now.Add(time.Hour))
+
+	orgID, err := parseOnboardState("sesame", state, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), orgID)
+
+	_, err = parseOnboardState("other", state, now)
+	assert.EqualError(t, err, "invalid onboard state signature")
+
+	_, err = parseOnboardState("sesame", strings.Replace(state, "12:", "13:", 1), now)
+	assert.EqualError(t, err, "invalid onboard state signature")
+
+	_, err = parseOnboardState("sesame", state, now.Add(2*time.Hour))
+	assert.EqualError(t, err, "onboard state has expired")
+
+	for _, invalid := range []string{"", "12", "12:abc:def", "abc:1700000000:def", "0:1700000000:def"} {
+		_, err = parseOnboardState("sesame", invalid, now)
+		assert.EqualError(t, err, "invalid onboard state", invalid)
+	}
+}
+
+func TestUnsupportedMsgReply(t *testing.T) {
+	var reply string
+	replies := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v12.0/12345/messages", r.URL.Path)
+		assert.Equal(t, "Bearer wac_admin_system_user_token", r.Header.Get("Authorization"))
+
+		body, _ := io.ReadAll(r.Body)
+		reply = string(body)
+		replies++
+		w.Write([]byte(`{ "messages": [{"id": "157b5e14568e8"}] }`))
+	}))
+	defer server.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configUnsupportedReply: "Sorry, I can't read that message type"})
+
+	RunChannelTestCases(t, []courier.Channel{channel}, newGraphHandler("WAC", "Cloud API WhatsApp", server.URL), []ChannelHandleTestCase{
+		{Label: "Receive Unsupported Message Type", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/invalidTypeMsgWAC.json")), Status: 200, Response: `"unsupported_msg"`,
+			NoQueueErrorCheck: true, NoInvalidChannelCheck: true, PrepRequest: addValidSignatureWAC},
+		{Label: "Receive Retried Unsupported Message Type", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/invalidTypeMsgWAC.json")), Status: 200, Response: `"unsupported_msg"`,
+			NoQueueErrorCheck: true, NoInvalidChannelCheck: true, PrepRequest: addValidSignatureWAC},
+	})
+
+	// a retry of the same webhook doesn't reply again
+	assert.JSONEq(t, `{"messaging_product": "whatsapp", "recipient_type": "individual", "to": "5678", "type": "text", "text": {"body": "Sorry, I can't read that message type"}}`, reply)
+	assert.Equal(t, 1, replies)
+}
+
+// setSendURL takes care of setting the Graph API URL to our test server host
 func setSendURL(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
-	sendURL = s.URL
-	graphURL = s.URL
+	h.(*handler).graphURL = s.URL
 }
 
 var SendTestCasesFBA = []ChannelSendTestCase{
@@ -564,6 +991,23 @@ var SendTestCasesIG = []ChannelSendTestCase{
 		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
 		RequestBody: `{"messaging_type":"MESSAGE_TAG","tag":"HUMAN_AGENT","recipient":{"id":"12345"},"message":{"text":"we exceed the max length?","quick_replies":[{"title":"Yes","payload":"Yes","content_type":"text"},{"title":"No","payload":"No","content_type":"text"}]}}`,
 		SendPrep:    setSendURL},
+	{Label: "Comment Reply",
+		Text: "It's $20", URN: "instagram:12345", Metadata: json.RawMessage(`{"ig_comment_id": "17865799348089039"}`),
+		Status: "W", ExternalID: "17870000000000001", Path: "/v12.0/17865799348089039/replies",
+		ResponseBody: `{"id": "17870000000000001"}`, ResponseStatus: 200,
+		RequestBody: `{"message":"It's $20"}`,
+		SendPrep:    setSendURL},
+	{Label: "Comment Reply With Attachment",
+		Text: "See this", URN: "instagram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"}, Metadata: json.RawMessage(`{"ig_comment_id": "17865799348089039"}`),
+		Status: "W", ExternalID: "17870000000000001",
+		ResponseBody: `{"id": "17870000000000001"}`, ResponseStatus: 200,
+		RequestBody: `{"message":"See this\nhttps://foo.bar/image.jpg"}`,
+		SendPrep:    setSendURL},
+	{Label: "Comment Reply Error",
+		Text: "It's $20", URN: "instagram:12345", Metadata: json.RawMessage(`{"ig_comment_id": "17865799348089039"}`),
+		Status:       "E",
+		ResponseBody: `{"error": {"message": "Invalid parameter"}}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
 	{Label: "Send Photo",
 		URN: "instagram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		Status: "W", ExternalID: "mid.133",
@@ -604,17 +1048,49 @@ var SendTestCasesIG = []ChannelSendTestCase{
 
 var SendTestCasesWAC = []ChannelSendTestCase{
 	{Label: "Plain Send",
-		Text: "Simple Message", URN: "whatsapp:250788123123", Path: "/12345_ID/messages",
+		Text: "Simple Message", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
 		Status: "W", ExternalID: "157b5e14568e8",
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"Simple Message"}}`,
 		SendPrep:    setSendURL},
 	{Label: "Unicode Send",
-		Text: "☺", URN: "whatsapp:250788123123", Path: "/12345_ID/messages",
+		Text: "☺", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
 		Status: "W", ExternalID: "157b5e14568e8",
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"☺"}}`,
 		SendPrep:    setSendURL},
+	{Label: "Reply Send",
+		Text: "Simple Reply", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
+		Metadata: json.RawMessage(`{"reply_to_external_id": "wamid.HBgLMjUwNzg4MTIzMTIzFQIAEhgUM0VCMDRBNzYwREQ0RjMwNjgwRjkA"}`),
+		Status:   "W", ExternalID: "157b5e14568e8",
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","context":{"message_id":"wamid.HBgLMjUwNzg4MTIzMTIzFQIAEhgUM0VCMDRBNzYwREQ0RjMwNjgwRjkA"},"text":{"body":"Simple Reply"}}`,
+		SendPrep:    setSendURL},
+	{Label: "Audio Reply Send",
+		Text:     "audio caption",
+		URN:      "whatsapp:250788123123",
+		Metadata: json.RawMessage(`{"reply_to_external_id": "wamid.123"}`),
+		Status:   "W", ExternalID: "157b5e14568e8",
+		Attachments: []string{"audio/mpeg:https://foo.bar/audio.mp3"},
+		Responses: map[MockedRequest]MockedResponse{
+			MockedRequest{
+				Method: "POST",
+				Path:   "/v12.0/12345_ID/messages",
+				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"audio","context":{"message_id":"wamid.123"},"audio":{"link":"https://foo.bar/audio.mp3"}}`,
+			}: MockedResponse{
+				Status: 201,
+				Body:   `{ "messages": [{"id": "157b5e14568e8"}] }`,
+			},
+			MockedRequest{
+				Method: "POST",
+				Path:   "/v12.0/12345_ID/messages",
+				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"audio caption"}}`,
+			}: MockedResponse{
+				Status: 201,
+				Body:   `{ "messages": [{"id": "157b5e14568e8"}] }`,
+			},
+		},
+		SendPrep: setSendURL},
 	{Label: "Audio Send",
 		Text:   "audio caption",
 		URN:    "whatsapp:250788123123",
@@ -623,7 +1099,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		Responses: map[MockedRequest]MockedResponse{
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"audio","audio":{"link":"https://foo.bar/audio.mp3"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -631,7 +1107,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 			},
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"audio caption"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -647,7 +1123,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		Responses: map[MockedRequest]MockedResponse{
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"sticker","sticker":{"link":"https://foo.bar/sticker.webp"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -655,7 +1131,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 			},
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"sticker caption"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -707,7 +1183,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 	},
 	{Label: "Template Invalid Language",
 		Text: "templated message", URN: "whatsapp:250788123123",
-		Error:    `unable to decode template: {"templating": { "template": { "name": "revive_issue", "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "bnt", "variables": ["Chef", "tomorrow"]}} for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: unable to find mapping for language: bnt`,
+		Status:   "F",
 		Metadata: json.RawMessage(`{"templating": { "template": { "name": "revive_issue", "uuid": "8ca114b4-bee2-4d3b-aaf1-9aa6b48d41e8" }, "language": "bnt", "variables": ["Chef", "tomorrow"]}}`),
 	},
 	{Label: "Interactive Button Message Send",
@@ -732,9 +1208,62 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		Text: "Interactive Button Msg", URN: "whatsapp:250788123123", QuickReplies: []string{"BUTTON1"},
 		Status: "W", ExternalID: "157b5e14568e8",
 		Attachments:  []string{"image/jpeg:https://foo.bar/image.jpg"},
-		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"button","header":{"type":"image","video":{},"image":{"link":"https://foo.bar/image.jpg"},"document":{}},"body":{"text":"Interactive Button Msg"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"BUTTON1"}}]}}}`,
+		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"button","header":{"type":"image","image":{"link":"https://foo.bar/image.jpg"}},"body":{"text":"Interactive Button Msg"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"BUTTON1"}}]}}}`,
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		SendPrep: setSendURL},
+	{Label: "Interactive CTA URL Message Send",
+		Text: "Visit our store", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{"interaction_type": "cta_url", "cta_message": {"display_text": "Open store", "url": "https://foo.bar/store"}}`),
+		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"cta_url","body":{"text":"Visit our store"},"action":{"name":"cta_url","parameters":{"display_text":"Open store","url":"https://foo.bar/store"}}}}`,
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		SendPrep: setSendURL},
+	{Label: "Interactive CTA URL Message Send without URL",
+		Text: "Visit our store", URN: "whatsapp:250788123123",
+		Error:    "cta_url messages require a display_text and url",
+		Metadata: json.RawMessage(`{"interaction_type": "cta_url", "cta_message": {"display_text": "Open store"}}`),
+		SendPrep: setSendURL},
+	{Label: "Interactive Flow Message Send",
+		Text: "Tell us about your order", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{"interaction_type": "flow_msg", "flow_message": {"flow_id": "29287124123", "flow_cta": "Start", "flow_screen": "WELCOME", "flow_data": {"name": "Bob"}, "flow_token": "8c9f8c46-20e5-4f11-a4a4-4ea6b3b2b0d4"}}`),
+		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"flow","body":{"text":"Tell us about your order"},"action":{"name":"flow","parameters":{"flow_message_version":"3","flow_token":"8c9f8c46-20e5-4f11-a4a4-4ea6b3b2b0d4","flow_id":"29287124123","flow_cta":"Start","flow_action":"navigate","flow_action_payload":{"screen":"WELCOME","data":{"name":"Bob"}}}}}}`,
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		SendPrep: setSendURL},
+	{Label: "Interactive Flow Message Send without Flow ID",
+		Text: "Tell us about your order", URN: "whatsapp:250788123123",
+		Error:    "flow_msg messages require a flow_id and flow_cta",
+		Metadata: json.RawMessage(`{"interaction_type": "flow_msg", "flow_message": {"flow_cta": "Start"}}`),
+		SendPrep: setSendURL},
+	{Label: "Interactive Address Message Send",
+		Text: "Where should we deliver?", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{"interaction_type": "address", "address_message": {"country": "br", "values": {"name": "Bob"}, "saved_addresses": [{"id": "home", "value": {"name": "Bob", "city": "São Paulo"}}], "validation_errors": {"city": "Unknown city"}}}`),
+		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"address_message","body":{"text":"Where should we deliver?"},"action":{"name":"address_message","parameters":{"country":"BR","values":{"name":"Bob"},"saved_addresses":[{"id":"home","value":{"name":"Bob","city":"São Paulo"}}],"validation_errors":{"city":"Unknown city"}}}}}`,
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		SendPrep: setSendURL},
+	{Label: "Interactive Address Message Send without country",
+		Text: "Where should we deliver?", URN: "whatsapp:250788123123",
+		Error:    "address messages are only supported in BR and IN, not ''",
+		Metadata: json.RawMessage(`{"interaction_type": "address"}`),
+		SendPrep: setSendURL},
+	{Label: "Interactive Address Message Send with invalid saved address",
+		Text: "Where should we deliver?", URN: "whatsapp:250788123123",
+		Error:    "saved addresses require an id and value",
+		Metadata: json.RawMessage(`{"interaction_type": "address", "address_message": {"country": "IN", "saved_addresses": [{"value": {"name": "Bob"}}]}}`),
+		SendPrep: setSendURL},
+	{Label: "Interactive PIX Message Send",
+		Text: "Pay for your order", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{"interaction_type": "pix", "pix_message": {"key": "pix@foo.bar", "key_type": "EMAIL", "merchant_name": "Foo Store", "amount": 12.5, "reference_id": "order_123"}}`),
+		RequestBody:  `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"order_details","body":{"text":"Pay for your order"},"action":{"name":"review_and_pay","parameters":{"reference_id":"order_123","type":"digital-goods","payment_type":"br","payment_settings":[{"type":"pix_dynamic_code","pix_dynamic_code":{"merchant_name":"Foo Store","key":"pix@foo.bar","key_type":"EMAIL"}}],"currency":"BRL","total_amount":{"value":1250,"offset":100}}}}}`,
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		SendPrep: setSendURL},
+	{Label: "Interactive PIX Message Send without amount",
+		Text: "Pay for your order", URN: "whatsapp:250788123123",
+		Error:    "pix messages require a key, merchant_name and positive amount",
+		Metadata: json.RawMessage(`{"interaction_type": "pix", "pix_message": {"key": "pix@foo.bar", "merchant_name": "Foo Store"}}`),
+		SendPrep: setSendURL},
 	{Label: "Interactive List Message Send with attachment",
 		Text: "Interactive List Msg", URN: "whatsapp:250788123123", QuickReplies: []string{"ROW1", "ROW2", "ROW3", "ROW4"},
 		Status: "W", ExternalID: "157b5e14568e8", TextLanguage: "en-US",
@@ -742,7 +1271,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		Responses: map[MockedRequest]MockedResponse{
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"image","image":{"link":"https://foo.bar/image.jpg"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -750,7 +1279,7 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 			},
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"list","body":{"text":"Interactive List Msg"},"action":{"button":"Menu","sections":[{"rows":[{"id":"0","title":"ROW1"},{"id":"1","title":"ROW2"},{"id":"2","title":"ROW3"},{"id":"3","title":"ROW4"}]}]}}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -760,12 +1289,12 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		SendPrep: setSendURL},
 	{Label: "Interactive Button Message Send with audio attachment",
 		Text: "Interactive Button Msg", URN: "whatsapp:250788123123", QuickReplies: []string{"BUTTON0", "BUTTON1", "BUTTON2"},
-		Status: "W", ExternalID: "157b5e14568e8",
+		Status: "W", ExternalID: "157b5e14568e8", SecondaryExternalIDs: []string{"257b5e14568e9"},
 		Attachments: []string{"audio/mp3:https://foo.bar/audio.mp3"},
 		Responses: map[MockedRequest]MockedResponse{
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"audio","audio":{"link":"https://foo.bar/audio.mp3"}}`,
 			}: MockedResponse{
 				Status: 201,
@@ -773,11 +1302,11 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 			},
 			MockedRequest{
 				Method: "POST",
-				Path:   "/12345_ID/messages",
+				Path:   "/v12.0/12345_ID/messages",
 				Body:   `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"button","body":{"text":"Interactive Button Msg"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"BUTTON0"}},{"type":"reply","reply":{"id":"1","title":"BUTTON1"}},{"type":"reply","reply":{"id":"2","title":"BUTTON2"}}]}}}`,
 			}: MockedResponse{
 				Status: 201,
-				Body:   `{ "messages": [{"id": "157b5e14568e8"}] }`,
+				Body:   `{ "messages": [{"id": "257b5e14568e9"}] }`,
 			},
 		},
 		SendPrep: setSendURL},
@@ -805,21 +1334,78 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"revive_issue","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"Chef"},{"type":"text","text":"tomorrow"}]},{"type":"header","parameters":[{"type":"document","document":{"link":"https://foo.bar/document.pdf","filename":"document.pdf"}}]}]}}`,
 		SendPrep:    setSendURL},
+	{Label: "Media Message Template Send - Header Media URL",
+		Text: "Media Message Msg", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "namespace": "wa_template_namespace", "language": "eng", "country": "US", "variables": ["Chef", "tomorrow"], "header_media_url": "https://foo.bar/banner.jpg"}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"revive_issue","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"Chef"},{"type":"text","text":"tomorrow"}]},{"type":"header","parameters":[{"type":"image","image":{"link":"https://foo.bar/banner.jpg"}}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Media Message Template Send - Header Media ID",
+		Text: "Media Message Msg", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "namespace": "wa_template_namespace", "language": "eng", "country": "US", "header_media_id": "1234567890", "header_media_type": "video"}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"revive_issue","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"header","parameters":[{"type":"video","video":{"id":"1234567890"}}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Media Message Template Send - Header Media ID Without Type",
+		Text: "Media Message Msg", URN: "whatsapp:250788123123",
+		Metadata: json.RawMessage(`{ "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "header_media_id": "1234567890"}}`),
+		Error:    `unable to decode template: { "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "header_media_id": "1234567890"}} for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: invalid templating metadata (v1): header_media_type is required with header_media_id`,
+		SendPrep: setSendURL},
+	{Label: "Template Send - URL And Quick Reply Buttons",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "order_update", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "variables": ["Chef"], "buttons": [{"sub_type": "url", "index": 0, "parameter": "order/123"}, {"sub_type": "quick_reply", "index": 1, "parameter": "stop"}]}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"order_update","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"Chef"}]},{"type":"button","sub_type":"url","index":"0","parameters":[{"type":"text","text":"order/123"}]},{"type":"button","sub_type":"quick_reply","index":"1","parameters":[{"type":"payload","payload":"stop"}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Template Send - OTP Copy Code",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "auth_code", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "buttons": [{"sub_type": "otp", "index": 0, "otp": {"type": "copy_code", "code": "483920"}}]}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"auth_code","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"483920"}]},{"type":"button","sub_type":"url","index":"0","parameters":[{"type":"text","text":"483920"}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Template Send - OTP One Tap",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "auth_code", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "buttons": [{"sub_type": "otp", "index": 0, "otp": {"type": "one_tap", "code": "483920", "package_name": "com.example.app", "signature_hash": "K8a/AINcGX7"}}]}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"auth_code","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"483920"}]},{"type":"button","sub_type":"url","index":"0","parameters":[{"type":"text","text":"483920"}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Template Send - OTP One Tap Missing Signature Hash",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Metadata: json.RawMessage(`{ "templating": { "template": { "name": "auth_code", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "buttons": [{"sub_type": "otp", "index": 0, "otp": {"type": "one_tap", "code": "483920", "package_name": "com.example.app"}}]}}`),
+		Error:    `unable to build template buttons for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: one_tap button 0 requires a package_name and signature_hash`,
+		SendPrep: setSendURL},
+	{Label: "Template Send - Limited Time Offer With Coupon Code",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Status: "W", ExternalID: "157b5e14568e8",
+		Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "summer_deal", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "variables": ["Chef"], "limited_time_offer": {"expiration_time_ms": 1209600000}, "buttons": [{"sub_type": "copy_code", "index": 0, "parameter": "DEAL20"}, {"sub_type": "url", "index": 1, "parameter": "deals/summer"}]}}`),
+		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
+		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"template","template":{"name":"summer_deal","language":{"policy":"deterministic","code":"en_US"},"components":[{"type":"body","parameters":[{"type":"text","text":"Chef"}]},{"type":"limited_time_offer","parameters":[{"type":"limited_time_offer","limited_time_offer":{"expiration_time_ms":1209600000}}]},{"type":"button","sub_type":"copy_code","index":"0","parameters":[{"type":"coupon_code","coupon_code":"DEAL20"}]},{"type":"button","sub_type":"url","index":"1","parameters":[{"type":"text","text":"deals/summer"}]}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Template Send - Coupon Code Missing",
+		Text: "templated message", URN: "whatsapp:250788123123",
+		Metadata: json.RawMessage(`{ "templating": { "template": { "name": "summer_deal", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "country": "US", "buttons": [{"sub_type": "copy_code", "index": 0}]}}`),
+		Error:    `unable to build template buttons for channel: 8eb23e93-5ecb-45ba-b726-3b064e0c56ab: missing coupon code for button 0`,
+		SendPrep: setSendURL},
 	{Label: "Link Sending",
-		Text: "Link Sending https://link.com", URN: "whatsapp:250788123123", Path: "/12345_ID/messages",
+		Text: "Link Sending https://link.com", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
 		Status: "W", ExternalID: "157b5e14568e8",
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"text","text":{"body":"Link Sending https://link.com","preview_url":true}}`,
 		SendPrep:    setSendURL},
 	{Label: "Update URN with wa_id returned",
-		Text: "Simple Message", URN: "whatsapp:5511987654321", Path: "/12345_ID/messages",
+		Text: "Simple Message", URN: "whatsapp:5511987654321", Path: "/v12.0/12345_ID/messages",
 		Status: "W", ExternalID: "157b5e14568e8",
 		ResponseBody: `{ "contacts":[{"input":"5511987654321", "wa_id":"551187654321"}], "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"5511987654321","type":"text","text":{"body":"Simple Message"}}`,
 		SendPrep:    setSendURL,
 		NewURN:      "whatsapp:551187654321"},
 	{Label: "Attachment with Caption",
-		Text: "Simple Message", URN: "whatsapp:5511987654321", Path: "/12345_ID/messages",
+		Text: "Simple Message", URN: "whatsapp:5511987654321", Path: "/v12.0/12345_ID/messages",
 		Status: "W", ExternalID: "157b5e14568e8",
 		Attachments:  []string{"image/jpeg:https://foo.bar/image.jpg"},
 		ResponseBody: `{ "contacts":[{"input":"5511987654321", "wa_id":"551187654321"}], "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
@@ -847,6 +1433,16 @@ var SendTestCasesWAC = []ChannelSendTestCase{
 		ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 201,
 		RequestBody: `{"messaging_product":"whatsapp","recipient_type":"individual","to":"250788123123","type":"interactive","interactive":{"type":"catalog_message","body":{"text":"Catalog Body Msg"},"action":{"name":"catalog_message"}}}`,
 		SendPrep:    setSendURL},
+	{Label: "Undeliverable Error",
+		Text: "Error", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
+		Status: "E", ErrorClass: "invalid_recipient",
+		ResponseBody: `{"error": {"message": "(#131026) Message Undeliverable", "code": 131026}}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
+	{Label: "Template Paused Error",
+		Text: "Error", URN: "whatsapp:250788123123", Path: "/v12.0/12345_ID/messages",
+		Status: "E", ErrorClass: "content_rejected",
+		ResponseBody: `{"error": {"message": "(#132015) Template is paused", "code": 132015}}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
 }
 
 func TestSending(t *testing.T) {
@@ -862,6 +1458,713 @@ func TestSending(t *testing.T) {
 	RunChannelSendTestCases(t, ChannelWAC, newHandler("WAC", "Cloud API WhatsApp", false), SendTestCasesWAC, nil)
 }
 
+func TestRateLimitBackoff(t *testing.T) {
+	throttleBaseDelay = time.Millisecond
+	defer func() { throttleBaseDelay = time.Second }()
+
+	calls := 0
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch {
+		case calls < 3:
+			w.WriteHeader(429)
+			w.Write([]byte(`{"error": {"message": "(#130429) Rate limit hit", "code": 130429}}`))
+		case calls == 3:
+			w.Write([]byte(`{ "messages": [{"id": "157b5e14568e8"}] }`))
+		default:
+			w.Header().Set("X-Business-Use-Case-Usage", `{"102290129340398": [{"type": "whatsapp", "call_count": 100, "total_cputime": 20, "total_time": 25, "estimated_time_to_regain_access": 5}]}`)
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error": {"message": "(#80007) Rate limit hit", "code": 80007}}`))
+		}
+	}))
+	defer graph.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345_ID", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configWABAID: "102290129340398"})
+	setGraphURL := func(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
+		h.(*handler).graphURL = graph.URL + "/"
+	}
+
+	var mb *courier.MockBackend
+	RunChannelSendTestCases(t, channel, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelSendTestCase{
+		{Label: "Retried After Rate Limit", Text: "Simple Message", URN: "whatsapp:250788123123",
+			Status: "W", ExternalID: "157b5e14568e8", SendPrep: setGraphURL},
+		{Label: "Long Backoff Not Retried", Text: "Simple Message", URN: "whatsapp:250788123123",
+			Status: "E", ErrorClass: "rate_limit", SendPrep: setGraphURL},
+	}, func(b *courier.MockBackend) { mb = b })
+
+	// we retried twice before succeeding, then gave up straight away
+	assert.Equal(t, 4, calls)
+
+	// and the long backoff is shared by every channel of the account
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	ttl, err := redis.Int(rc.Do("TTL", "graph_backoff:102290129340398"))
+	assert.NoError(t, err)
+	assert.True(t, ttl > 250 && ttl <= 300)
+	rc.Do("DEL", "graph_backoff:102290129340398")
+}
+
+func TestPairRateLimitBackoff(t *testing.T) {
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Business-Use-Case-Usage", `{"102290129340398": [{"type": "whatsapp", "call_count": 10, "total_cputime": 5, "total_time": 5, "estimated_time_to_regain_access": 5}]}`)
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error": {"message": "(#131056) (Business Account, Consumer Account) pair rate limit hit", "code": 131056}}`))
+	}))
+	defer graph.Close()
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345_ID", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configWABAID: "102290129340398"})
+	setGraphURL := func(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
+		h.(*handler).graphURL = graph.URL + "/"
+	}
+
+	var mb *courier.MockBackend
+	RunChannelSendTestCases(t, channel, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelSendTestCase{
+		{Label: "Pair Rate Limited", Text: "Simple Message", URN: "whatsapp:250788123123",
+			Status: "E", ErrorClass: "rate_limit", SendPrep: setGraphURL},
+	}, func(b *courier.MockBackend) { mb = b })
+
+	// only sends to that recipient back off, the rest of the account keeps sending
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	ttl, err := redis.Int(rc.Do("TTL", "graph_backoff:102290129340398:250788123123"))
+	assert.NoError(t, err)
+	assert.True(t, ttl > 250 && ttl <= 300)
+
+	exists, err := redis.Bool(rc.Do("EXISTS", "graph_backoff:102290129340398"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	rc.Do("DEL", "graph_backoff:102290129340398:250788123123")
+}
+
+func TestParseUsage(t *testing.T) {
+	rr := &utils.RequestResponse{Header: http.Header{}}
+	rr.Header.Set("X-Business-Use-Case-Usage", `{"1": [{"type": "whatsapp", "call_count": 95, "total_cputime": 20, "total_time": 25, "estimated_time_to_regain_access": 2}]}`)
+
+	usage, regainAccess := parseUsage(rr)
+	assert.Equal(t, 95, usage)
+	assert.Equal(t, 120, regainAccess)
+
+	usage, regainAccess = parseUsage(&utils.RequestResponse{})
+	assert.Equal(t, 0, usage)
+	assert.Equal(t, 0, regainAccess)
+
+	assert.True(t, isThrottled(&utils.RequestResponse{StatusCode: 400, Body: []byte(`{"error": {"code": 613}}`)}))
+	assert.False(t, isThrottled(&utils.RequestResponse{StatusCode: 400, Body: []byte(`{"error": {"code": 100}}`)}))
+}
+
+func TestInteractiveBuilders(t *testing.T) {
+	tcs := []struct {
+		interactive *wacInteractive
+		expected    string
+	}{
+		{
+			newButtonInteractive("Pick one", []string{"Yes", "No"}),
+			`{"type":"button","body":{"text":"Pick one"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"Yes"}},{"type":"reply","reply":{"id":"1","title":"No"}}]}}`,
+		},
+		{
+			newListInteractive("Pick one", "Menu", quickReplyRows([]string{"Yes", "No"})).withTextHeader("Header").withFooter("Footer"),
+			`{"type":"list","header":{"type":"text","text":"Header"},"body":{"text":"Pick one"},"footer":{"text":"Footer"},"action":{"button":"Menu","sections":[{"rows":[{"id":"0","title":"Yes"},{"id":"1","title":"No"}]}]}}`,
+		},
+		{
+			newListInteractive("Pick one", "Menu", listItemRows([]courier.ListItems{{UUID: "abc", Title: "Yes", Description: "Sure"}})),
+			`{"type":"list","body":{"text":"Pick one"},"action":{"button":"Menu","sections":[{"rows":[{"id":"abc","title":"Yes","description":"Sure"}]}]}}`,
+		},
+		{
+			newLocationRequestInteractive("Where are you?"),
+			`{"type":"location_request_message","body":{"text":"Where are you?"},"action":{"name":"send_location"}}`,
+		},
+		{
+			newCTAInteractive("Visit us", "Open", "https://foo.bar"),
+			`{"type":"cta_url","body":{"text":"Visit us"},"action":{"name":"cta_url","parameters":{"display_text":"Open","url":"https://foo.bar"}}}`,
+		},
+		{
+			newPixInteractive("Pay up", "ref1", &wacPixDynamicCode{Code: "000201", MerchantName: "Foo", Key: "12345678900", KeyType: "CPF"}, 10.1),
+			`{"type":"order_details","body":{"text":"Pay up"},"action":{"name":"review_and_pay","parameters":{"reference_id":"ref1","type":"digital-goods","payment_type":"br","payment_settings":[{"type":"pix_dynamic_code","pix_dynamic_code":{"code":"000201","merchant_name":"Foo","key":"12345678900","key_type":"CPF"}}],"currency":"BRL","total_amount":{"value":1010,"offset":100}}}}`,
+		},
+		{
+			newCatalogInteractive("Our catalog"),
+			`{"type":"catalog_message","body":{"text":"Our catalog"},"action":{"name":"catalog_message"}}`,
+		},
+		{
+			newProductInteractive("Our product", "c123", "View", "p1"),
+			`{"type":"product","body":{"text":"Our product"},"action":{"catalog_id":"c123","product_retailer_id":"p1","name":"View"}}`,
+		},
+		{
+			newProductListInteractive("Our products", "c123", "View", []wacMTSection{{Title: "items", ProductItems: []wacMTProductItem{{ProductRetailerID: "p1"}}}}),
+			`{"type":"product_list","body":{"text":"Our products"},"action":{"sections":[{"title":"items","product_items":[{"product_retailer_id":"p1"}]}],"catalog_id":"c123","name":"View"}}`,
+		},
+		{
+			newButtonInteractive("Pick one", []string{"Yes"}).withMediaHeader("image", &wacMTMedia{Link: "https://foo.bar/image.jpg"}),
+			`{"type":"button","header":{"type":"image","image":{"link":"https://foo.bar/image.jpg"}},"body":{"text":"Pick one"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"Yes"}}]}}`,
+		},
+		{
+			newButtonInteractive("Pick one", []string{"Yes"}).withMediaHeader("audio", &wacMTMedia{Link: "https://foo.bar/audio.mp3"}).withTextHeader("").withFooter(""),
+			`{"type":"button","body":{"text":"Pick one"},"action":{"buttons":[{"type":"reply","reply":{"id":"0","title":"Yes"}}]}}`,
+		},
+	}
+
+	for _, tc := range tcs {
+		actual, err := json.Marshal(tc.interactive)
+		assert.NoError(t, err)
+		assert.JSONEq(t, tc.expected, string(actual))
+	}
+
+	// quick replies and list items decide what kind of interactive a msg is sent as
+	mb := courier.NewMockBackend()
+	channel := testChannelsWAC[0]
+	newMsg := func(qrs []string, metadata string) courier.Msg {
+		msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("whatsapp:250788123123"), "Hi", false, qrs, "", 0, "", "")
+		if metadata != "" {
+			msg.WithMetadata(json.RawMessage(metadata))
+		}
+		return msg
+	}
+
+	interactive, err := newMsgInteractive(newMsg(nil, ""), "Hi")
+	assert.NoError(t, err)
+	assert.Nil(t, interactive)
+
+	interactive, err = newMsgInteractive(newMsg([]string{"1", "2", "3"}, `{"footer": "Footer"}`), "Hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "button", interactive.Type)
+	assert.Equal(t, &wacInteractiveFooter{Text: "Footer"}, interactive.Footer)
+
+	interactive, err = newMsgInteractive(newMsg([]string{"1", "2", "3", "4"}, ""), "Hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "list", interactive.Type)
+	assert.Equal(t, "Menu", interactive.Action.Button)
+
+	_, err = newMsgInteractive(newMsg([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}, ""), "Hi")
+	assert.EqualError(t, err, "too many quick replies WAC supports only up to 10 quick replies")
+
+	interactive, err = newMsgInteractive(newMsg(nil, `{"interaction_type": "location"}`), "Hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "location_request_message", interactive.Type)
+
+	_, err = newMsgInteractive(newMsg(nil, `{"interaction_type": "cta_url", "cta_message": {"url": "https://foo.bar"}}`), "Hi")
+	assert.EqualError(t, err, "cta_url messages require a display_text and url")
+
+	// PIX messages are referenced by the msg UUID unless told otherwise
+	msg := newMsg(nil, `{"interaction_type": "pix", "pix_message": {"key": "12345678900", "merchant_name": "Foo", "amount": 5}}`)
+	interactive, err = newMsgInteractive(msg, "Hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "order_details", interactive.Type)
+	assert.Equal(t, msg.UUID().String(), interactive.Action.Parameters.(*wacOrderDetails).ReferenceID)
+}
+
+func TestLocationRequestMetadata(t *testing.T) {
+	assert.JSONEq(t, `{"location_request": {"status": "denied", "context_id": "wamid.123"}}`, string(locationRequestMetadata("denied", "wamid.123")))
+
+	// replies which don't say why are declines, and not every reply says what it replies to
+	assert.JSONEq(t, `{"location_request": {"status": "denied"}}`, string(locationRequestMetadata("", "")))
+}
+
+func TestFlowCompletion(t *testing.T) {
+	mb := courier.NewMockBackend()
+	channel := testChannelsWAC[0]
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	newMsg := func(metadata string) courier.Msg {
+		return mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("whatsapp:250788123123"), "Hi", false, nil, "", 0, "", "").WithMetadata(json.RawMessage(metadata))
+	}
+
+	// flow messages get a token generated unless they have one
+	interactive, err := newMsgInteractive(newMsg(`{"interaction_type": "flow_msg", "flow_message": {"flow_id": "29287124123", "flow_cta": "Start", "flow_mode": "draft"}}`), "Hi")
+	assert.NoError(t, err)
+	params := interactive.Action.Parameters.(*wacFlowParameters)
+	assert.True(t, uuids.IsV4(params.FlowToken))
+	assert.Equal(t, "draft", params.Mode)
+	assert.Nil(t, params.FlowActionPayload)
+
+	msg := newMsg(`{"interaction_type": "flow_msg", "flow_message": {"flow_id": "29287124123", "flow_cta": "Start", "flow_token": "8c9f8c46-20e5-4f11-a4a4-4ea6b3b2b0d4"}}`)
+	interactive, err = newMsgInteractive(msg, "Hi")
+	assert.NoError(t, err)
+	h.trackFlowSend(msg, interactive)
+
+	// replies with the token of a flow message we sent to the same contact resolve to that msg
+	completion := h.resolveFlowCompletion(channel, urns.URN("whatsapp:250788123123"), `{"flow_token": "8c9f8c46-20e5-4f11-a4a4-4ea6b3b2b0d4", "name": "Bob"}`, time.Now().Add(90*time.Second))
+	if assert.NotNil(t, completion) {
+		assert.Equal(t, msg.UUID(), completion["msg_uuid"])
+		assert.Equal(t, courier.NewMsgID(10), completion["msg_id"])
+		assert.InDelta(t, 90, completion["elapsed_seconds"], 1)
+	}
+
+	// but not those from other contacts or with tokens we don't know
+	assert.Nil(t, h.resolveFlowCompletion(channel, urns.URN("whatsapp:250788999999"), `{"flow_token": "8c9f8c46-20e5-4f11-a4a4-4ea6b3b2b0d4"}`, time.Now()))
+	assert.Nil(t, h.resolveFlowCompletion(channel, urns.URN("whatsapp:250788123123"), `{"flow_token": "<FLOW_TOKEN>"}`, time.Now()))
+	assert.Nil(t, h.resolveFlowCompletion(channel, urns.URN("whatsapp:250788123123"), `{}`, time.Now()))
+}
+
+func TestProcessWACItems(t *testing.T) {
+	var mutex sync.Mutex
+	processed := make(map[string][]int)
+	running, maxRunning := 0, 0
+
+	newItem := func(contact string, n int, fail bool) *wacItem {
+		return &wacItem{contact: contact, process: func(item *wacItem) error {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			running--
+			processed[contact] = append(processed[contact], n)
+			if fail {
+				return fmt.Errorf("failed %s %d", contact, n)
+			}
+			item.data = append(item.data, n)
+			return nil
+		}}
+	}
+
+	items := []*wacItem{}
+	for i := 0; i < 20; i++ {
+		items = append(items, newItem(fmt.Sprintf("contact%d", i%5), i, i == 6))
+	}
+	items = append(items, &wacItem{data: []interface{}{"info"}})
+	items = append(items, &wacItem{contact: "panicky", process: func(item *wacItem) error { panic("boom") }})
+
+	processWACItems(items, 3)
+
+	// items for each contact are processed in order, and stop at the first failure
+	assert.Equal(t, []int{0, 5, 10, 15}, processed["contact0"])
+	assert.Equal(t, []int{1, 6}, processed["contact1"])
+	assert.Equal(t, []int{4, 9, 14, 19}, processed["contact4"])
+	assert.LessOrEqual(t, maxRunning, 3)
+
+	assert.NoError(t, items[1].err)
+	assert.EqualError(t, items[6].err, "failed contact1 6")
+	assert.True(t, items[11].skipped)
+	assert.True(t, items[16].skipped)
+	assert.False(t, items[12].skipped)
+	assert.Equal(t, []interface{}{12}, items[12].data)
+	assert.Equal(t, []interface{}{"info"}, items[20].data)
+	assert.EqualError(t, items[21].err, "panic processing webhook item: boom")
+}
+
+func TestWACMediaUpload(t *testing.T) {
+	defer func(delay time.Duration) { mediaUploadRetryDelay = delay }(mediaUploadRetryDelay)
+	mediaUploadRetryDelay = time.Millisecond
+
+	var responses []string
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(responses[calls], " ", 2)
+		calls++
+		status, _ := strconv.Atoi(parts[0])
+		w.WriteHeader(status)
+		w.Write([]byte(parts[1]))
+	}))
+	defer server.Close()
+
+	mb := courier.NewMockBackend()
+	msg := mb.NewOutgoingMsg(testChannelsWAC[0], courier.NewMsgID(10), urns.URN("whatsapp:250788123123"), "", false, nil, "", 0, "", "")
+	upload := func() (string, []*courier.ChannelLog, error) {
+		calls = 0
+		return requestWACMediaUpload([]byte("hello"), "https://foo.bar/hello.txt", server.URL, "text/plain", msg, "token")
+	}
+
+	// transient failures are retried
+	responses = []string{`500 {"error": {"code": 1}}`, `502 bad gateway`, `200 {"id": "media1"}`}
+	mediaID, logs, err := upload()
+	assert.NoError(t, err)
+	assert.Equal(t, "media1", mediaID)
+	assert.Len(t, logs, 3)
+
+	// permanent failures aren't
+	responses = []string{`400 {"error": {"code": 131053, "message": "Unsupported mime type"}}`}
+	_, logs, err = upload()
+	assert.EqualError(t, err, "request failed: media request failed with status 400 and error code 131053")
+	assert.Len(t, logs, 1)
+	failure := errors.Cause(err).(*mediaFailure)
+	assert.True(t, failure.permanent())
+	assert.Equal(t, cache.DefaultExpiration, failure.expiration())
+
+	// and we give up on transient failures after our max retries
+	responses = []string{`503 {}`, `503 {}`, `503 {}`}
+	_, logs, err = upload()
+	assert.EqualError(t, err, "request failed: media request failed with status 503 and error code 0")
+	assert.Len(t, logs, 3)
+	failure = errors.Cause(err).(*mediaFailure)
+	assert.False(t, failure.permanent())
+	assert.Equal(t, transientMediaFailureTTL, failure.expiration())
+
+	assert.False(t, (&mediaFailure{}).permanent())
+	assert.False(t, (&mediaFailure{StatusCode: 429}).permanent())
+	assert.False(t, (&mediaFailure{StatusCode: 400, ErrorCode: 130429}).permanent())
+	assert.True(t, (&mediaFailure{StatusCode: 404}).permanent())
+}
+
+func TestRefreshInvalidMedia(t *testing.T) {
+	sends := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/image.jpg":
+			w.Write([]byte("imagedata"))
+		case "/v12.0/12345/media":
+			w.Write([]byte(`{"id": "fresh_id"}`))
+		case "/v12.0/12345/messages":
+			sends++
+			if strings.Contains(string(body), `"to":"bad"`) {
+				w.WriteHeader(400)
+				w.Write([]byte(`{"error": {"message": "(#100) Invalid parameter", "code": 100}}`))
+				return
+			}
+			if strings.Contains(string(body), "stale_id") {
+				w.WriteHeader(400)
+				w.Write([]byte(`{"error": {"message": "(#131053) Media upload error", "code": 131053}}`))
+				return
+			}
+			assert.Contains(t, string(body), `"image":{"id":"fresh_id"}`)
+			w.Write([]byte(`{ "messages": [{"id": "157b5e14568e8"}] }`))
+		default:
+			http.Error(w, "not found", 404)
+		}
+	}))
+	defer server.Close()
+
+	mb := courier.NewMockBackend()
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123"})
+	h := newGraphHandler("WAC", "Cloud API WhatsApp", server.URL).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	imageURL := server.URL + "/image.jpg"
+	cacheKey := fmt.Sprintf(mediaCacheKeyPatternWhatsapp, channel.UUID().String())
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	defer rcache.Clear(rc, cacheKey)
+	rcache.Set(rc, cacheKey, imageURL, "stale_id")
+
+	msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("whatsapp:250788123123"), "", false, nil, "", 0, "", "").WithAttachment("image/jpeg:" + imageURL)
+	status := mb.NewMsgStatusForID(channel, msg.ID(), courier.MsgErrored)
+	payload := wacMTPayload{MessagingProduct: "whatsapp", RecipientType: "individual", To: "250788123123", Type: "image", Image: &wacMTMedia{ID: "stale_id"}}
+
+	status, _, err := h.requestWAC(payload, "a123", msg, status, h.graphBaseURL(channel).ResolveReference(&url.URL{Path: "12345/messages"}))
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgWired, status.Status())
+	assert.Equal(t, "157b5e14568e8", status.ExternalID())
+	assert.Equal(t, 2, sends)
+
+	// our cache now has the fresh media ID
+	mediaID, _ := rcache.Get(rc, cacheKey, imageURL)
+	assert.Equal(t, "fresh_id", mediaID)
+
+	// a send which fails for another reason isn't retried
+	sends = 0
+	payload.Image = &wacMTMedia{ID: "fresh_id"}
+	payload.To = "bad"
+	status, _, err = h.requestWAC(payload, "a123", msg, mb.NewMsgStatusForID(channel, msg.ID(), courier.MsgErrored), h.graphBaseURL(channel).ResolveReference(&url.URL{Path: "12345/messages"}))
+	assert.NoError(t, err)
+	assert.Equal(t, courier.MsgErrored, status.Status())
+	assert.Equal(t, []*courier.MsgStatusPart{{ExternalID: "", Status: courier.MsgErrored}}, status.Parts())
+	assert.Equal(t, 1, sends)
+}
+
+func TestReceiveAccountEvents(t *testing.T) {
+	config := courier.NewConfig()
+	config.WhatsappCloudApplicationSecret = "wac_app_secret"
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(config, courier.NewMockBackend()))
+
+	body := `{"object": "whatsapp_business_account", "entry": [{"id": "102290129340398", "time": 1678900000, "changes": [
+		{"field": "account_update", "value": {"phone_number": "15550783881", "event": "ACCOUNT_VIOLATION"}},
+		{"field": "account_review_update", "value": {"decision": "APPROVED"}}
+	]}]}`
+
+	r := httptest.NewRequest(http.MethodPost, "/c/wac/account", strings.NewReader(body))
+	addValidSignatureWAC(r)
+
+	events, err := h.ReceiveAccountEvents(context.Background(), httptest.NewRecorder(), r)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, courier.ChannelType("WAC"), events[0].ChannelType)
+		assert.Equal(t, "102290129340398", events[0].AccountID)
+		assert.Equal(t, "account_update", events[0].EventType)
+		assert.JSONEq(t, `{"phone_number": "15550783881", "event": "ACCOUNT_VIOLATION"}`, string(events[0].Payload))
+		assert.Equal(t, "account_review_update", events[1].EventType)
+	}
+
+	// requests must be signed
+	r = httptest.NewRequest(http.MethodPost, "/c/wac/account", strings.NewReader(body))
+	addInvalidSignature(r)
+	_, err = h.ReceiveAccountEvents(context.Background(), httptest.NewRecorder(), r)
+	assert.Error(t, err)
+}
+
+func TestReceiveAccountWebhook(t *testing.T) {
+	config := courier.NewConfig()
+	config.WhatsappCloudApplicationSecret = "wac_app_secret"
+
+	mb := courier.NewMockBackend()
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(config, mb))
+
+	body := `{"object": "whatsapp_business_account", "entry": [{"id": "102290129340398", "time": 1678900000, "changes": [
+		{"field": "account_update", "value": {"phone_number": "15550783881", "event": "ACCOUNT_VIOLATION"}}
+	]}]}`
+
+	// account webhooks posted to the receive route don't need a channel
+	r := httptest.NewRequest(http.MethodPost, "/c/wac/receive", strings.NewReader(body))
+	addValidSignatureWAC(r)
+	channel, err := h.GetChannel(context.Background(), r)
+	assert.NoError(t, err)
+	assert.Nil(t, channel)
+
+	r = httptest.NewRequest(http.MethodPost, "/c/wac/receive", strings.NewReader(body))
+	addValidSignatureWAC(r)
+	w := httptest.NewRecorder()
+	_, err = h.receiveEvent(context.Background(), nil, w, r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Account Events Handled")
+
+	if assert.Len(t, mb.AccountEvents(), 1) {
+		assert.Equal(t, "102290129340398", mb.AccountEvents()[0].AccountID)
+		assert.Equal(t, "account_update", mb.AccountEvents()[0].EventType)
+	}
+}
+
+func TestMirrorWebhook(t *testing.T) {
+	var mirrored []*http.Request
+	var mirroredBodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mirrored = append(mirrored, r)
+		mirroredBodies = append(mirroredBodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config := courier.NewConfig()
+	config.FacebookApplicationSecret = "fb_app_secret"
+
+	h := newHandler("FBA", "Facebook", false).(*handler)
+	h.SetServer(courier.NewServer(config, courier.NewMockBackend()))
+
+	body := string(courier.ReadFile("./testdata/fba/dlr.json"))
+	receive := func(webhook map[string]interface{}) {
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "FBA", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", "webhook": webhook})
+		r := httptest.NewRequest(http.MethodPost, "/c/fba/receive", strings.NewReader(body))
+		addValidSignature(r)
+		_, err := h.receiveEvent(context.Background(), channel, httptest.NewRecorder(), r)
+		assert.NoError(t, err)
+	}
+
+	// deliveries aren't mirrored to webhooks only wanting messages
+	receive(map[string]interface{}{"url": server.URL, "method": "POST", "headers": map[string]interface{}{}, "filters": []interface{}{"message"}})
+	assert.Len(t, mirrored, 0)
+
+	// but are to those wanting them, signed with the webhook's secret
+	receive(map[string]interface{}{"url": server.URL, "method": "POST", "headers": map[string]interface{}{"X-Tenant": "acme"}, "filters": []interface{}{"message", "delivery"}, "secret": "sesame"})
+	if assert.Len(t, mirrored, 1) {
+		assert.Equal(t, body, mirroredBodies[0])
+		assert.Equal(t, "acme", mirrored[0].Header.Get("X-Tenant"))
+		assert.Equal(t, webhooks.Sign("sesame", []byte(body)), mirrored[0].Header.Get("X-Courier-Signature"))
+	}
+
+	// and to those without filters, unsigned if they have no secret
+	receive(map[string]interface{}{"url": server.URL, "method": "POST", "headers": map[string]interface{}{}})
+	if assert.Len(t, mirrored, 2) {
+		assert.Equal(t, body, mirroredBodies[1])
+		assert.Equal(t, "", mirrored[1].Header.Get("X-Courier-Signature"))
+	}
+
+	payload := &moPayload{}
+	json.Unmarshal(courier.ReadFile("./testdata/fba/echoFBA.json"), payload)
+	assert.Equal(t, []string{"echo"}, webhookEventTypes(payload))
+
+	payload = &moPayload{}
+	json.Unmarshal([]byte(`{"object": "whatsapp_business_account", "entry": [{"changes": [
+		{"field": "messages", "value": {"messages": [{"id": "wamid.1"}], "statuses": [{"id": "wamid.2"}]}},
+		{"field": "messages", "value": {"messages": [{"id": "wamid.3"}]}}
+	]}]}`), payload)
+	assert.Equal(t, []string{"message", "status"}, webhookEventTypes(payload))
+}
+
+func TestUnknownTypes(t *testing.T) {
+	config := courier.NewConfig()
+	config.FacebookApplicationSecret = "fb_app_secret"
+	config.WhatsappCloudApplicationSecret = "wac_app_secret"
+	mb := courier.NewMockBackend()
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	key := fmt.Sprintf(unknownTypesKeyPattern, time.Now().UTC().Format("2006-01-02"))
+	rc.Do("DEL", key)
+
+	receive := func(channelType, body string) []courier.Event {
+		h := newHandler(courier.ChannelType(channelType), channelType, false).(*handler)
+		h.SetServer(courier.NewServer(config, mb))
+		channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", channelType, "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123"})
+
+		r := httptest.NewRequest(http.MethodPost, "/c/receive", strings.NewReader(body))
+		if channelType == "WAC" {
+			addValidSignatureWAC(r)
+		} else {
+			addValidSignature(r)
+		}
+		w := httptest.NewRecorder()
+		events, err := h.receiveEvent(context.Background(), channel, w, r)
+		assert.NoError(t, err)
+		return events
+	}
+
+	// reactions are events or ignored rather than being treated as unsupported msgs
+	assert.Len(t, receive("WAC", string(courier.ReadFile("./testdata/contract/wac_reaction.json"))), 1)
+	assert.Len(t, receive("FBA", string(courier.ReadFile("./testdata/contract/fba_reaction.json"))), 0)
+
+	counts, err := redis.IntMap(rc.Do("HGETALL", key))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 0)
+
+	// but msg types and messaging entries we don't know are counted
+	receive("WAC", string(courier.ReadFile("./testdata/wac/invalidTypeMsgWAC.json")))
+	receive("FBA", `{"object": "page", "entry": [{"id": "12345", "time": 1459991487970, "messaging": [
+		{"sender": {"id": "5678"}, "recipient": {"id": "12345"}, "timestamp": 1459991487970, "read": {"watermark": 1458668856253}}
+	]}]}`)
+	receive("FBA", `{"object": "page", "entry": [{"id": "12345", "time": 1459991487970, "messaging": [
+		{"sender": {"id": "5678"}, "recipient": {"id": "12345"}, "timestamp": 1459991487970, "read": {"watermark": 1458668856254}}
+	]}]}`)
+
+	counts, err = redis.IntMap(rc.Do("HGETALL", key))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{
+		"8eb23e93-5ecb-45ba-b726-3b064e0c568c|message|ad":     1,
+		"8eb23e93-5ecb-45ba-b726-3b064e0c568c|messaging|read": 2,
+	}, counts)
+}
+
+func TestSummarizeUnknownTypes(t *testing.T) {
+	var alerts []string
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		alerts = append(alerts, string(body))
+	}))
+	defer server.Close()
+
+	mb := courier.NewMockBackend()
+	mb.AddChannel(courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", nil))
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+	rc.Do("DEL", "meta_unknown_types_alerted:2024-03-08")
+	rc.Do("HSET", "meta_unknown_types:2024-03-08", "8eb23e93-5ecb-45ba-b726-3b064e0c568c|message|ad", 1, "8eb23e93-5ecb-45ba-b726-3b064e0c568c|message|poll", 3,
+		"b0e7ef60-3a0a-4c52-8f4a-4e7d6a3f0dbf|message|ad", 2)
+
+	// summaries we fail to post aren't marked as posted
+	err := h.summarizeUnknownTypes(context.Background(), "2024-03-08", server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 0)
+	alerted, err := redis.Strings(rc.Do("SMEMBERS", "meta_unknown_types_alerted:2024-03-08"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b0e7ef60-3a0a-4c52-8f4a-4e7d6a3f0dbf"}, alerted)
+
+	// so they're posted on the next check, channels which received types we don't know get a summary of them and
+	// channels we can't find are skipped
+	failing = false
+	err = h.summarizeUnknownTypes(context.Background(), "2024-03-08", server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	assert.JSONEq(t, `{
+		"type": "unknown_types",
+		"channel_uuid": "8eb23e93-5ecb-45ba-b726-3b064e0c568c",
+		"channel_type": "WAC",
+		"day": "2024-03-08",
+		"types": [{"kind": "message", "type": "poll", "count": 3}, {"kind": "message", "type": "ad", "count": 1}]
+	}`, alerts[0])
+
+	// handlers of other types leave them to the handler of their type
+	other := newHandler("FBA", "Facebook", false).(*handler)
+	other.SetServer(courier.NewServer(courier.NewConfig(), mb))
+	rc.Do("SREM", "meta_unknown_types_alerted:2024-03-08", "8eb23e93-5ecb-45ba-b726-3b064e0c568c")
+	err = other.summarizeUnknownTypes(context.Background(), "2024-03-08", server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+	rc.Do("SADD", "meta_unknown_types_alerted:2024-03-08", "8eb23e93-5ecb-45ba-b726-3b064e0c568c")
+
+	// but only once for each day
+	err = h.summarizeUnknownTypes(context.Background(), "2024-03-08", server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+
+	// days without any aren't summarized
+	rc.Do("DEL", "meta_unknown_types_alerted:2024-03-09")
+	err = h.summarizeUnknownTypes(context.Background(), "2024-03-09", server.URL)
+	assert.NoError(t, err)
+	assert.Len(t, alerts, 1)
+}
+
+func TestMarkMsgRead(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		if strings.Contains(string(body), "wamid.bad") {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "Invalid message id", "code": 100}}`))
+			return
+		}
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	config := courier.NewConfig()
+	config.WhatsappAdminSystemUserToken = "system_token"
+	mb := courier.NewMockBackend()
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(config, mb))
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigBaseURL: server.URL + "/", courier.ConfigMarkAsRead: true})
+	msg := mb.NewIncomingMsg(channel, urns.URN("whatsapp:5678"), "hi").WithExternalID("wamid.1")
+
+	log, err := h.MarkMsgRead(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "Message Marked Read", log.Description)
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, "/12345/messages", requests[0].URL.Path)
+		assert.Equal(t, "Bearer system_token", requests[0].Header.Get("Authorization"))
+		assert.JSONEq(t, `{"messaging_product": "whatsapp", "status": "read", "message_id": "wamid.1"}`, bodies[0])
+	}
+
+	// channels with their own token use that
+	channel.SetConfig(courier.ConfigUserToken, "user_token")
+	msg = mb.NewIncomingMsg(channel, urns.URN("whatsapp:5678"), "hi").WithExternalID("wamid.bad")
+	log, err = h.MarkMsgRead(context.Background(), msg)
+	assert.Error(t, err)
+	assert.NotNil(t, log)
+	assert.Equal(t, "Bearer user_token", requests[1].Header.Get("Authorization"))
+
+	// Messenger msgs aren't marked
+	fba := newHandler("FBA", "Facebook", false).(*handler)
+	fba.SetServer(courier.NewServer(config, mb))
+	fbaChannel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "FBA", "12345", "", map[string]interface{}{courier.ConfigMarkAsRead: true})
+	log, err = fba.MarkMsgRead(context.Background(), mb.NewIncomingMsg(fbaChannel, urns.URN("facebook:5678"), "hi").WithExternalID("mid.1"))
+	assert.NoError(t, err)
+	assert.Nil(t, log)
+	assert.Len(t, requests, 2)
+}
+
 func TestSigning(t *testing.T) {
 	tcs := []struct {
 		Body      string
@@ -883,3 +2186,248 @@ func TestSigning(t *testing.T) {
 		assert.Equal(t, tc.Signature, sig, "%d: mismatched signature", i)
 	}
 }
+
+func TestLookupAdNames(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		assert.Equal(t, "Bearer marketing_token", r.Header.Get("Authorization"))
+		assert.Equal(t, "name,campaign{name}", r.URL.Query().Get("fields"))
+		if r.URL.Path == "/v12.0/ad1" {
+			w.Write([]byte(`{"id": "ad1", "name": "Summer Sale", "campaign": {"id": "c1", "name": "Summer"}}`))
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "Unsupported get request", "code": 100}}`))
+		}
+	}))
+	defer server.Close()
+	defer adNamesCache.Flush()
+
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.graphURL = server.URL + "/"
+	channel := testChannelsWAC[0]
+
+	// without a Marketing API token we don't look anything up
+	h.SetServer(courier.NewServer(courier.NewConfig(), courier.NewMockBackend()))
+	names, err := h.lookupAdNames(channel, "ad1")
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+	assert.Equal(t, 0, calls)
+
+	config := courier.NewConfig()
+	config.MarketingAPIToken = "marketing_token"
+	h.SetServer(courier.NewServer(config, courier.NewMockBackend()))
+
+	names, err = h.lookupAdNames(channel, "ad1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Summer Sale", names.Name)
+	assert.Equal(t, "Summer", names.Campaign.Name)
+	assert.Equal(t, 1, calls)
+
+	// names are cached
+	names, err = h.lookupAdNames(channel, "ad1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Summer Sale", names.Name)
+	assert.Equal(t, 1, calls)
+
+	// as are failures, so we don't retry them for every msg
+	_, err = h.lookupAdNames(channel, "ad2")
+	assert.Error(t, err)
+	names, err = h.lookupAdNames(channel, "ad2")
+	assert.NoError(t, err)
+	assert.Nil(t, names)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCalls(t *testing.T) {
+	calling := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configCalling: true})
+	notCalling := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123"})
+
+	RunChannelTestCases(t, []courier.Channel{calling}, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelHandleTestCase{
+		{Label: "Receive Call Connect", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/callConnectWAC.json")), Status: 200, Response: `"type":"event"`,
+			URN: Sp("whatsapp:5678"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)), ChannelEvent: Sp(courier.CallConnect),
+			ChannelEventExtra: map[string]interface{}{"call_id": "wacid.call1", "direction": "USER_INITIATED", "sdp_type": "offer", "sdp": "v=0"},
+			NoQueueErrorCheck: true, PrepRequest: addValidSignatureWAC},
+		{Label: "Receive Call Terminate", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/callTerminateWAC.json")), Status: 200, Response: `"type":"event"`,
+			URN: Sp("whatsapp:5678"), Date: Tp(time.Date(2016, 1, 30, 1, 59, 9, 0, time.UTC)), ChannelEvent: Sp(courier.CallTerminate),
+			ChannelEventExtra: map[string]interface{}{"call_id": "wacid.call1", "direction": "USER_INITIATED", "status": "Completed", "duration": 120},
+			NoQueueErrorCheck: true, PrepRequest: addValidSignatureWAC},
+		{Label: "Ignore Unknown Call Event", URL: wacReceiveURL, Data: strings.Replace(string(courier.ReadFile("./testdata/wac/callConnectWAC.json")), `"connect"`, `"ringing"`, 1), Status: 200,
+			Response: `"ignoring call event: ringing"`, NoQueueErrorCheck: true, PrepRequest: addValidSignatureWAC},
+	})
+
+	RunChannelTestCases(t, []courier.Channel{notCalling}, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelHandleTestCase{
+		{Label: "Ignore Calls Without Calling", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/callConnectWAC.json")), Status: 200,
+			Response: `"ignoring calls, calling not enabled"`, NoQueueErrorCheck: true, PrepRequest: addValidSignatureWAC},
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v12.0/12345/calls", r.URL.Path)
+		body, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(body), "unknown_call") {
+			http.Error(w, `{"error": {"message": "Invalid call id", "code": 138002}}`, 400)
+			return
+		}
+		assert.JSONEq(t, `{"messaging_product": "whatsapp", "call_id": "wacid.call1", "action": "accept", "session": {"sdp_type": "answer", "sdp": "v=0"}}`, string(body))
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	url := "/c/wac/calls?channel=8eb23e93-5ecb-45ba-b726-3b064e0c568c"
+
+	RunChannelTestCases(t, []courier.Channel{calling}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL), []ChannelHandleTestCase{
+		{Label: "Accept Call", URL: url, Headers: adminHeaders, Data: `{"call_id": "wacid.call1", "action": "accept", "session": {"sdp_type": "answer", "sdp": "v=0"}}`, Status: 200, Response: "Call action sent", NoQueueErrorCheck: true},
+		{Label: "Accept Unknown Call", URL: url, Headers: adminHeaders, Data: `{"call_id": "unknown_call", "action": "accept"}`, Status: 400, Response: "unable to accept call"},
+		{Label: "Invalid Call Action", URL: url, Headers: adminHeaders, Data: `{"call_id": "wacid.call1", "action": "hold"}`, Status: 400, Response: "request JSON doesn't match required schema"},
+	})
+
+	RunChannelTestCases(t, []courier.Channel{notCalling}, newGraphHandler("WAC", "WhatsApp Cloud", server.URL), []ChannelHandleTestCase{
+		{Label: "Accept Call Without Calling", URL: url, Headers: adminHeaders, Data: `{"call_id": "wacid.call1", "action": "accept"}`, Status: 400, Response: "calling not enabled for channel", NoQueueErrorCheck: true},
+	})
+}
+
+func TestAccountAlerts(t *testing.T) {
+	// two numbers of the same business account, and a channel of another account
+	channels := []courier.Channel{
+		courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configWABAID: "8856996819413533", configWANumber: "+250 788 123 200"}),
+		courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568d", "WAC", "12346", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configWABAID: "8856996819413533", configWANumber: "+250 788 123 201"}),
+		courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568e", "WAC", "12347", "", map[string]interface{}{courier.ConfigAuthToken: "a123", configWABAID: "1234567890", configWANumber: "+250 788 123 200"}),
+	}
+
+	// alerts are account webhooks, which have no phone number id to find a channel by
+	RunChannelTestCases(t, channels, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelHandleTestCase{
+		{Label: "Receive Ban Alert", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/banAlertWAC.json")), Status: 200, Response: `"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c568c"`,
+			ChannelEvent: Sp("channel_alert"), ChannelEventExtra: map[string]interface{}{"field": "account_update", "event": "DISABLED_UPDATE", "ban_state": []string{"DISABLE"}, "ban_date": "2023-03-15"},
+			PrepRequest: addValidSignatureWAC, NoQueueErrorCheck: true, NoInvalidChannelCheck: true},
+		{Label: "Receive Limit Alert", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/limitAlertWAC.json")), Status: 200, Response: `"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c568c"`,
+			ChannelEvent: Sp("channel_alert"), ChannelEventExtra: map[string]interface{}{"field": "phone_number_quality_update", "event": "DOWNGRADE", "current_limit": "TIER_1K"},
+			PrepRequest: addValidSignatureWAC},
+		{Label: "Receive Restriction Alert", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/restrictionAlertWAC.json")), Status: 200, Response: `"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c568c"`,
+			ChannelEvent: Sp("channel_alert"), ChannelEventExtra: map[string]interface{}{"field": "account_update", "event": "ACCOUNT_RESTRICTION", "restrictions": []wacRestriction{{RestrictionType: "RESTRICTED_BIZ_INITIATED_MESSAGING", Expiration: "2023-03-22"}}},
+			PrepRequest: addValidSignatureWAC},
+		{Label: "Receive Business Account Alert", URL: wacReceiveURL, Data: string(courier.ReadFile("./testdata/wac/wabaAlertWAC.json")), Status: 200, Response: `"channel_uuid":"8eb23e93-5ecb-45ba-b726-3b064e0c568d"`,
+			ChannelEvent: Sp("channel_alert"), ChannelEventExtra: map[string]interface{}{"field": "account_update", "event": "ACCOUNT_RESTRICTION", "restrictions": []wacRestriction{{RestrictionType: "RESTRICTED_ADD_PHONE_NUMBER_ACTION", Expiration: "2023-03-22"}}},
+			PrepRequest: addValidSignatureWAC},
+	})
+
+	mb := courier.NewMockBackend()
+	for _, channel := range channels {
+		mb.AddChannel(channel)
+	}
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	writeAlerts := func(accountID string, field string, value string) []courier.ChannelUUID {
+		events, err := h.writeAccountEventAlerts(context.Background(), courier.NewAccountEvent("WAC", accountID, field, json.RawMessage(value)))
+		require.NoError(t, err)
+
+		uuids := make([]courier.ChannelUUID, len(events))
+		for i, event := range events {
+			uuids[i] = event.ChannelUUID()
+		}
+		return uuids
+	}
+
+	// alerts about a phone number are written for its channel, those about the whole account for all of its channels
+	assert.Equal(t, []courier.ChannelUUID{channels[1].UUID()}, writeAlerts("8856996819413533", "phone_number_quality_update", `{"display_phone_number": "250788123201", "event": "DOWNGRADE", "current_limit": "TIER_250"}`))
+	assert.Equal(t, []courier.ChannelUUID{channels[0].UUID(), channels[1].UUID()}, writeAlerts("8856996819413533", "account_update", `{"event": "ACCOUNT_RESTRICTION", "restriction_info": [{"restriction_type": "RESTRICTED_ADD_PHONE_NUMBER_ACTION", "expiration": "2023-03-22"}]}`))
+
+	// and none are written for numbers or accounts which aren't our channels, or changes which aren't alerts
+	assert.Equal(t, []courier.ChannelUUID{}, writeAlerts("8856996819413533", "account_update", `{"phone_number": "15550783881", "event": "DISABLED_UPDATE", "ban_info": {"waba_ban_state": ["DISABLE"]}}`))
+	assert.Equal(t, []courier.ChannelUUID{}, writeAlerts("999", "account_update", `{"event": "DISABLED_UPDATE", "ban_info": {"waba_ban_state": ["DISABLE"]}}`))
+	assert.Equal(t, []courier.ChannelUUID{}, writeAlerts("8856996819413533", "account_update", `{"phone_number": "250788123200", "event": "VERIFIED_ACCOUNT"}`))
+
+	// alerts can be listed for each channel
+	alerts, err := mb.GetChannelAlerts(context.Background(), channels[1].UUID(), 10)
+	require.NoError(t, err)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, "ACCOUNT_RESTRICTION", alerts[0].Extra()["event"])
+	assert.Equal(t, "DOWNGRADE", alerts[1].Extra()["event"])
+}
+
+func TestFailUnmappedLanguage(t *testing.T) {
+	defer languageFailureCache.Flush()
+
+	mb := courier.NewMockBackend()
+	h := newHandler("WAC", "Cloud API WhatsApp", false).(*handler)
+	h.SetServer(courier.NewServer(courier.NewConfig(), mb))
+
+	newMsg := func(channel courier.Channel) courier.Msg {
+		return mb.NewOutgoingMsg(channel, courier.NewMsgID(10), urns.URN("whatsapp:250788123123"), "Hi", false, nil, "", 0, "", "")
+	}
+	fail := func(msg courier.Msg, language string) courier.MsgStatus {
+		status := mb.NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+		return h.failUnmappedLanguage(context.Background(), msg, status, &languageMappingError{language})
+	}
+
+	status := fail(newMsg(testChannelsWAC[0]), "bnt")
+	assert.Equal(t, courier.MsgFailed, status.Status())
+	assert.Equal(t, "unable to find mapping for language: bnt", status.Logs()[0].Error)
+
+	alert, err := mb.GetLastChannelEvent()
+	assert.NoError(t, err)
+	assert.Equal(t, courier.ChannelAlert, alert.EventType())
+	assert.Equal(t, testChannelsWAC[0].UUID(), alert.ChannelUUID())
+	assert.Equal(t, map[string]interface{}{"alert": "template_language_unmapped", "language": "bnt"}, alert.Extra())
+
+	// we only alert once per channel and language
+	status = fail(newMsg(testChannelsWAC[0]), "bnt")
+	assert.Equal(t, courier.MsgFailed, status.Status())
+	last, _ := mb.GetLastChannelEvent()
+	assert.Same(t, alert, last)
+
+	fail(newMsg(testChannelsWAC[0]), "xyz")
+	last, _ = mb.GetLastChannelEvent()
+	assert.Equal(t, "xyz", last.Extra()["language"])
+}
+
+func TestAllowUnsend(t *testing.T) {
+	mb := courier.NewMockBackend()
+	rc := mb.RedisPool().Get()
+	defer rc.Close()
+
+	defer func(max int) { maxUnsendsPerMinute = max }(maxUnsendsPerMinute)
+	maxUnsendsPerMinute = 2
+
+	// contacts can only unsend so many msgs a minute
+	for _, expected := range []bool{true, true, false} {
+		allowed, err := allowUnsend(rc, testChannelsIG[0], "instagram:5678")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, allowed)
+	}
+
+	// which doesn't limit other contacts
+	allowed, err := allowUnsend(rc, testChannelsIG[0], "instagram:6789")
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+}
+
+func TestValidateTemplateSend(t *testing.T) {
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345_ID", "", map[string]interface{}{courier.ConfigAuthToken: "a123"})
+	definition := &templates.Definition{Name: "revive_issue", Language: "en", Status: templates.StatusApproved, Components: []*templates.Component{
+		{Type: "BODY", Text: "Hi {{1}}, we'll fix it {{2}}"},
+	}}
+
+	RunChannelSendTestCases(t, channel, newHandler("WAC", "Cloud API WhatsApp", false), []ChannelSendTestCase{
+		{Label: "Template Matches Definition",
+			Text: "templated message", URN: "whatsapp:250788123123",
+			Status: "W", ExternalID: "157b5e14568e8",
+			Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "variables": ["Chef", "tomorrow"]}}`),
+			ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 200,
+			SendPrep: setSendURL},
+		{Label: "Template Missing Variable",
+			Text: "templated message", URN: "whatsapp:250788123123",
+			Status: "W", ExternalID: "157b5e14568e8",
+			Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "variables": ["Chef"]}}`),
+			ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 200,
+			SendPrep: setSendURL},
+		{Label: "Template Without Definition",
+			Text: "templated message", URN: "whatsapp:250788123123",
+			Status: "W", ExternalID: "157b5e14568e8",
+			Metadata:     json.RawMessage(`{ "templating": { "template": { "name": "other_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3" }, "language": "eng", "variables": ["Chef"]}}`),
+			ResponseBody: `{ "messages": [{"id": "157b5e14568e8"}] }`, ResponseStatus: 200,
+			SendPrep: setSendURL},
+	}, func(mb *courier.MockBackend) {
+		assert.NoError(t, templates.Apply(mb.RedisPool(), &templates.SyncMessage{ChannelUUID: channel.UUID().String(), Action: templates.ActionUpsert, Template: definition}))
+	})
+}
@@ -0,0 +1,105 @@
+package facebookapp
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/feedback"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// messagingFeedback is the answers a contact gave to a customer feedback template, e.g.
+//
+//	{
+//	  "feedback_screens": [
+//	    {
+//	      "screen_id": 0,
+//	      "questions": {
+//	        "myquestion1": {"type": "csat", "payload": "4", "follow_up": {"type": "free_form", "payload": "Quick and helpful"}}
+//	      }
+//	    }
+//	  ]
+//	}
+type messagingFeedback struct {
+	FeedbackScreens []struct {
+		ScreenID  int                         `json:"screen_id"`
+		Questions map[string]FeedbackQuestion `json:"questions"`
+	} `json:"feedback_screens"`
+}
+
+type FeedbackQuestion struct {
+	Type     string `json:"type"`
+	Payload  string `json:"payload"`
+	FollowUp *struct {
+		Type    string `json:"type"`
+		Payload string `json:"payload"`
+	} `json:"follow_up"`
+}
+
+// receiveFeedback writes the feedback a contact gave as a msg with the answers of its first screen separated by |, as
+// flows expect, and records each screen as a channel event, publishing it for our CSAT dashboards if we have somewhere
+// to publish it
+func (h *handler) receiveFeedback(ctx context.Context, channel courier.Channel, urn urns.URN, fb *messagingFeedback, date time.Time) (courier.Msg, []courier.ChannelEvent, error) {
+	var msg courier.Msg
+	events := make([]courier.ChannelEvent, 0, len(fb.FeedbackScreens))
+
+	for i, screen := range fb.FeedbackScreens {
+		questions := make([]*feedback.Question, 0, len(screen.Questions))
+		for id, q := range screen.Questions {
+			followUp := ""
+			if q.FollowUp != nil {
+				followUp = q.FollowUp.Payload
+			}
+			questions = append(questions, feedback.NewQuestion(id, q.Type, q.Payload, followUp))
+		}
+		feedback.SortQuestions(questions)
+
+		if i == 0 {
+			payloads := make([]string, 0, len(questions))
+			for _, q := range questions {
+				payloads = append(payloads, q.Payload)
+				if q.FollowUp != "" {
+					payloads = append(payloads, q.FollowUp)
+				}
+			}
+
+			msg = h.Backend().CheckExternalIDSeen(h.Backend().NewIncomingMsg(channel, urn, strings.Join(payloads, "|")).WithReceivedOn(date))
+			if err := h.Backend().WriteMsg(ctx, msg); err != nil {
+				return nil, nil, err
+			}
+			h.Backend().WriteExternalIDSeen(msg)
+		}
+
+		extraQuestions := make([]interface{}, len(questions))
+		for i, q := range questions {
+			extra := map[string]interface{}{"id": q.ID, "type": q.Type, "payload": q.Payload}
+			if q.Score != nil {
+				extra["score"] = *q.Score
+			}
+			if q.FollowUp != "" {
+				extra["follow_up"] = q.FollowUp
+			}
+			extraQuestions[i] = extra
+		}
+
+		event := h.Backend().NewChannelEvent(channel, courier.MsgFeedback, urn).WithOccurredOn(date).WithExtra(map[string]interface{}{"screen_id": screen.ScreenID, "questions": extraQuestions})
+		if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+
+		if client := h.Server().Feedback(); client != nil {
+			client.SendAsync(&feedback.Event{
+				ChannelUUID: channel.UUID().String(),
+				ChannelType: string(channel.ChannelType()),
+				ContactURN:  urn.String(),
+				ScreenID:    screen.ScreenID,
+				Questions:   questions,
+				OccurredOn:  date,
+			})
+		}
+	}
+	return msg, events, nil
+}
@@ -0,0 +1,120 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/sirupsen/logrus"
+)
+
+// redis key of the send of a flow message with a given flow token, for its completion to be tracked
+const flowTokenKeyPattern = "wac_flow_token:%s:%s"
+
+// how long after sending a flow message we can still track its completion
+const flowTokenExpiration = 7 * 24 * time.Hour
+
+type wacFlowActionPayload struct {
+	Screen string          `json:"screen"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+type wacFlowParameters struct {
+	FlowMessageVersion string                `json:"flow_message_version"`
+	FlowToken          string                `json:"flow_token"`
+	FlowID             string                `json:"flow_id"`
+	FlowCTA            string                `json:"flow_cta"`
+	FlowAction         string                `json:"flow_action"`
+	FlowActionPayload  *wacFlowActionPayload `json:"flow_action_payload,omitempty"`
+	Mode               string                `json:"mode,omitempty"`
+}
+
+// flowSend is what we remember about the send of a flow message, keyed by its flow token
+type flowSend struct {
+	MsgUUID courier.MsgUUID `json:"msg_uuid"`
+	MsgID   courier.MsgID   `json:"msg_id"`
+	URN     urns.URN        `json:"urn"`
+	SentOn  time.Time       `json:"sent_on"`
+}
+
+// newMsgFlowInteractive builds a message which opens the WhatsApp flow described in the flow_message metadata of the
+// passed in msg, generating a flow token for it unless the msg has one
+func newMsgFlowInteractive(msg courier.Msg, body string) (*wacInteractive, error) {
+	metadata := msg.Metadata()
+	flowID, _ := jsonparser.GetString(metadata, "flow_message", "flow_id")
+	flowCTA, _ := jsonparser.GetString(metadata, "flow_message", "flow_cta")
+	if flowID == "" || flowCTA == "" {
+		return nil, fmt.Errorf("flow_msg messages require a flow_id and flow_cta")
+	}
+
+	params := &wacFlowParameters{FlowMessageVersion: "3", FlowID: flowID, FlowCTA: flowCTA, FlowAction: "navigate"}
+	params.FlowToken, _ = jsonparser.GetString(metadata, "flow_message", "flow_token")
+	if params.FlowToken == "" {
+		params.FlowToken = string(uuids.New())
+	}
+	params.Mode, _ = jsonparser.GetString(metadata, "flow_message", "flow_mode")
+
+	if screen, _ := jsonparser.GetString(metadata, "flow_message", "flow_screen"); screen != "" {
+		params.FlowActionPayload = &wacFlowActionPayload{Screen: screen}
+		if data, dataType, _, _ := jsonparser.Get(metadata, "flow_message", "flow_data"); dataType == jsonparser.Object {
+			params.FlowActionPayload.Data = data
+		}
+	}
+
+	return &wacInteractive{Type: "flow", Body: wacInteractiveBody{Text: body}, Action: &wacInteractiveAction{Name: "flow", Parameters: params}}, nil
+}
+
+// trackFlowSend remembers the send of the passed in msg if it's sent as the passed in flow message, so that we can tell
+// which msg a flow reply completes
+func (h *handler) trackFlowSend(msg courier.Msg, interactive *wacInteractive) {
+	if interactive == nil || interactive.Type != "flow" {
+		return
+	}
+	params := interactive.Action.Parameters.(*wacFlowParameters)
+
+	send, _ := json.Marshal(&flowSend{MsgUUID: msg.UUID(), MsgID: msg.ID(), URN: msg.URN(), SentOn: time.Now().UTC()})
+
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	_, err := rc.Do("SET", fmt.Sprintf(flowTokenKeyPattern, msg.Channel().UUID(), params.FlowToken), send, "EX", int(flowTokenExpiration/time.Second))
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).Error("error tracking flow token")
+	}
+}
+
+// resolveFlowCompletion returns the original msg and how long it took to complete for a flow reply with the passed in
+// response from the passed in contact, or nil if we didn't send a flow message with its token to them
+func (h *handler) resolveFlowCompletion(channel courier.Channel, urn urns.URN, responseJSON string, receivedOn time.Time) map[string]interface{} {
+	token, _ := jsonparser.GetString([]byte(responseJSON), "flow_token")
+	if token == "" {
+		return nil
+	}
+
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	value, err := redis.Bytes(rc.Do("GET", fmt.Sprintf(flowTokenKeyPattern, channel.UUID(), token)))
+	if err != nil {
+		if err != redis.ErrNil {
+			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error resolving flow token")
+		}
+		return nil
+	}
+
+	send := &flowSend{}
+	if err := json.Unmarshal(value, send); err != nil || send.URN.Identity() != urn.Identity() {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"msg_uuid":        send.MsgUUID,
+		"msg_id":          send.MsgID,
+		"elapsed_seconds": int(receivedOn.Sub(send.SentOn) / time.Second),
+	}
+}
@@ -0,0 +1,84 @@
+package facebookapp
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
+)
+
+// channel config key of the Graph API version to call for a channel, overriding the version in our config
+const configGraphAPIVersion = "graph_api_version"
+
+// the Graph API version we call if none is configured
+const defaultGraphAPIVersion = "v12.0"
+
+// Meta keeps each Graph API version available for at least two years from its release
+const graphVersionLifetime = 2 * 365 * 24 * time.Hour
+
+// how long before a version may be removed we start warning about it
+const graphVersionWarningPeriod = 90 * 24 * time.Hour
+
+// release dates of the Graph API versions we know about, used to work out when they may be removed
+var graphVersionReleases = map[string]time.Time{
+	"v12.0": time.Date(2021, 9, 14, 0, 0, 0, 0, time.UTC),
+	"v13.0": time.Date(2022, 2, 8, 0, 0, 0, 0, time.UTC),
+	"v14.0": time.Date(2022, 5, 25, 0, 0, 0, 0, time.UTC),
+	"v15.0": time.Date(2022, 9, 15, 0, 0, 0, 0, time.UTC),
+	"v16.0": time.Date(2023, 2, 2, 0, 0, 0, 0, time.UTC),
+	"v17.0": time.Date(2023, 5, 23, 0, 0, 0, 0, time.UTC),
+	"v18.0": time.Date(2023, 9, 12, 0, 0, 0, 0, time.UTC),
+	"v19.0": time.Date(2024, 1, 23, 0, 0, 0, 0, time.UTC),
+	"v20.0": time.Date(2024, 5, 21, 0, 0, 0, 0, time.UTC),
+	"v21.0": time.Date(2024, 10, 2, 0, 0, 0, 0, time.UTC),
+}
+
+// graphAPIVersion returns the Graph API version to call for the passed in channel, or for calls not made on behalf of a
+// channel if it is nil
+func (h *handler) graphAPIVersion(channel courier.Channel) string {
+	version := defaultGraphAPIVersion
+	if h.Server() != nil && h.Server().Config().GraphAPIVersion != "" {
+		version = h.Server().Config().GraphAPIVersion
+	}
+	if channel != nil {
+		version = channel.StringConfigForKey(configGraphAPIVersion, version)
+	}
+	return version
+}
+
+// graphVersionRemovalDate returns the earliest date the passed in Graph API version may be removed on, returning false if
+// we don't know the version
+func graphVersionRemovalDate(version string) (time.Time, bool) {
+	released, found := graphVersionReleases[version]
+	if !found {
+		return time.Time{}, false
+	}
+	return released.Add(graphVersionLifetime), true
+}
+
+// graphVersionURL returns the Graph API base URL for the passed in version
+func (h *handler) graphVersionURL(version string) *url.URL {
+	base, _ := url.Parse(h.graphURL)
+	return base.ResolveReference(&url.URL{Path: version + "/"})
+}
+
+// warnGraphVersion logs a warning if the configured Graph API version may be removed soon or already has been
+func (h *handler) warnGraphVersion(now time.Time) {
+	version := h.graphAPIVersion(nil)
+	removal, found := graphVersionRemovalDate(version)
+	if !found || now.Before(removal.Add(-graphVersionWarningPeriod)) {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"channel_type":  h.ChannelType(),
+		"graph_version": version,
+		"removal_date":  removal.Format("2006-01-02"),
+	})
+	if now.Before(removal) {
+		log.Warn("graph API version is approaching its deprecation date, upgrade it with the GraphAPIVersion config")
+	} else {
+		log.Warn("graph API version is past its deprecation date, upgrade it with the GraphAPIVersion config")
+	}
+}
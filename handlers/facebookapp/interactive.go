@@ -0,0 +1,237 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+)
+
+// the most quick replies we can send as buttons, more are sent as a list
+const maxInteractiveButtons = 3
+
+// the most quick replies we can send as a list
+const maxInteractiveListRows = 10
+
+type wacInteractiveHeader struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	Video    *wacMTMedia `json:"video,omitempty"`
+	Image    *wacMTMedia `json:"image,omitempty"`
+	Document *wacMTMedia `json:"document,omitempty"`
+}
+
+type wacInteractiveBody struct {
+	Text string `json:"text"`
+}
+
+type wacInteractiveFooter struct {
+	Text string `json:"text,omitempty"`
+}
+
+type wacCTAParameters struct {
+	DisplayText string `json:"display_text"`
+	URL         string `json:"url"`
+}
+
+type wacInteractiveAction struct {
+	Button            string         `json:"button,omitempty"`
+	Sections          []wacMTSection `json:"sections,omitempty"`
+	Buttons           []wacMTButton  `json:"buttons,omitempty"`
+	CatalogID         string         `json:"catalog_id,omitempty"`
+	ProductRetailerID string         `json:"product_retailer_id,omitempty"`
+	Name              string         `json:"name,omitempty"`
+	Parameters        interface{}    `json:"parameters,omitempty"`
+}
+
+type wacInteractive struct {
+	Type   string                `json:"type"`
+	Header *wacInteractiveHeader `json:"header,omitempty"`
+	Body   wacInteractiveBody    `json:"body,omitempty"`
+	Footer *wacInteractiveFooter `json:"footer,omitempty"`
+	Action *wacInteractiveAction `json:"action,omitempty"`
+}
+
+// newButtonInteractive builds a reply buttons message with a button for each of the passed in quick replies
+func newButtonInteractive(body string, qrs []string) *wacInteractive {
+	buttons := make([]wacMTButton, len(qrs))
+	for i, qr := range qrs {
+		buttons[i].Type = "reply"
+		buttons[i].Reply.ID = fmt.Sprint(i)
+		buttons[i].Reply.Title = parseBacklashes(qr)
+	}
+	return &wacInteractive{Type: "button", Body: wacInteractiveBody{Text: body}, Action: &wacInteractiveAction{Buttons: buttons}}
+}
+
+// newListInteractive builds a list message with a single section of the passed in rows, opened by the passed in button
+func newListInteractive(body string, button string, rows []wacMTSectionRow) *wacInteractive {
+	return &wacInteractive{
+		Type:   "list",
+		Body:   wacInteractiveBody{Text: body},
+		Action: &wacInteractiveAction{Button: button, Sections: []wacMTSection{{Rows: rows}}},
+	}
+}
+
+// newLocationRequestInteractive builds a message asking the contact to share their location
+func newLocationRequestInteractive(body string) *wacInteractive {
+	return &wacInteractive{Type: "location_request_message", Body: wacInteractiveBody{Text: body}, Action: &wacInteractiveAction{Name: "send_location"}}
+}
+
+// the status of location requests which contacts declined, when the reply doesn't say
+const locationRequestDenied = "denied"
+
+// locationRequestMetadata returns the metadata we add to msgs which reply to a location request without a location, such
+// as when the contact declines to share it, e.g.
+//
+//	{"location_request": {"status": "denied", "context_id": "wamid.HBgLMTU1NTEyMzQ1NjcVAgARGBI"}}
+func locationRequestMetadata(status string, contextID string) json.RawMessage {
+	if status == "" {
+		status = locationRequestDenied
+	}
+	request := map[string]interface{}{"status": status}
+	if contextID != "" {
+		request["context_id"] = contextID
+	}
+	metadata, _ := json.Marshal(map[string]interface{}{"location_request": request})
+	return metadata
+}
+
+// newCTAInteractive builds a message with a single button which opens the passed in URL
+func newCTAInteractive(body string, displayText string, url string) *wacInteractive {
+	return &wacInteractive{
+		Type:   "cta_url",
+		Body:   wacInteractiveBody{Text: body},
+		Action: &wacInteractiveAction{Name: "cta_url", Parameters: &wacCTAParameters{DisplayText: displayText, URL: url}},
+	}
+}
+
+// newCatalogInteractive builds a message which opens the catalog of the business
+func newCatalogInteractive(body string) *wacInteractive {
+	return &wacInteractive{Type: InteractiveProductCatalogMessageType, Body: wacInteractiveBody{Text: body}, Action: &wacInteractiveAction{Name: "catalog_message"}}
+}
+
+// newProductInteractive builds a message showing a single product of the passed in catalog
+func newProductInteractive(body string, catalogID string, name string, productRetailerID string) *wacInteractive {
+	return &wacInteractive{
+		Type:   InteractiveProductSingleType,
+		Body:   wacInteractiveBody{Text: body},
+		Action: &wacInteractiveAction{CatalogID: catalogID, Name: name, ProductRetailerID: productRetailerID},
+	}
+}
+
+// newProductListInteractive builds a message showing sections of products of the passed in catalog
+func newProductListInteractive(body string, catalogID string, name string, sections []wacMTSection) *wacInteractive {
+	return &wacInteractive{
+		Type:   InteractiveProductListType,
+		Body:   wacInteractiveBody{Text: body},
+		Action: &wacInteractiveAction{CatalogID: catalogID, Name: name, Sections: sections},
+	}
+}
+
+// withTextHeader sets a text header on the interactive message if the passed in text isn't empty
+func (i *wacInteractive) withTextHeader(text string) *wacInteractive {
+	if text != "" {
+		i.Header = &wacInteractiveHeader{Type: "text", Text: text}
+	}
+	return i
+}
+
+// withMediaHeader sets a header of the passed in media on the interactive message, ignoring unsupported media types
+func (i *wacInteractive) withMediaHeader(mediaType string, media *wacMTMedia) *wacInteractive {
+	switch mediaType {
+	case "image":
+		i.Header = &wacInteractiveHeader{Type: mediaType, Image: media}
+	case "video":
+		i.Header = &wacInteractiveHeader{Type: mediaType, Video: media}
+	case "document":
+		i.Header = &wacInteractiveHeader{Type: mediaType, Document: media}
+	}
+	return i
+}
+
+// withFooter sets the footer of the interactive message if the passed in text isn't empty
+func (i *wacInteractive) withFooter(text string) *wacInteractive {
+	if text != "" {
+		i.Footer = &wacInteractiveFooter{Text: text}
+	}
+	return i
+}
+
+// quickReplyRows returns a list row for each of the passed in quick replies
+func quickReplyRows(qrs []string) []wacMTSectionRow {
+	rows := make([]wacMTSectionRow, len(qrs))
+	for i, qr := range qrs {
+		rows[i] = wacMTSectionRow{ID: fmt.Sprint(i), Title: parseBacklashes(qr)}
+	}
+	return rows
+}
+
+// listItemRows returns a list row for each of the passed in list items
+func listItemRows(items []courier.ListItems) []wacMTSectionRow {
+	rows := make([]wacMTSectionRow, len(items))
+	for i, item := range items {
+		rows[i] = wacMTSectionRow{ID: item.UUID, Title: parseBacklashes(item.Title), Description: parseBacklashes(item.Description)}
+	}
+	return rows
+}
+
+// listButtonText returns the text of the button which opens the list of the passed in msg
+func listButtonText(msg courier.Msg) string {
+	if msg.ListMessage().ButtonText != "" {
+		return msg.ListMessage().ButtonText
+	} else if msg.TextLanguage() != "" {
+		return languageMenuMap[msg.TextLanguage()]
+	}
+	return "Menu"
+}
+
+// newMsgInteractive builds the interactive message for the quick replies, list or interaction type of the passed in
+// msg with the passed in body, returning nil if it should be sent as a plain message
+func newMsgInteractive(msg courier.Msg, body string) (*wacInteractive, error) {
+	qrs := msg.QuickReplies()
+	items := msg.ListMessage().ListItems
+
+	var interactive *wacInteractive
+	switch {
+	case len(qrs) > 0 && len(qrs) <= maxInteractiveButtons && len(items) == 0:
+		interactive = newButtonInteractive(body, qrs)
+	case len(qrs) > maxInteractiveListRows:
+		return nil, fmt.Errorf("too many quick replies WAC supports only up to %d quick replies", maxInteractiveListRows)
+	case len(qrs) > 0:
+		interactive = newListInteractive(body, listButtonText(msg), quickReplyRows(qrs))
+	case len(items) > 0:
+		interactive = newListInteractive(body, listButtonText(msg), listItemRows(items))
+	case msg.InteractionType() == "location":
+		interactive = newLocationRequestInteractive(body)
+	case msg.InteractionType() == "cta_url":
+		displayText, _ := jsonparser.GetString(msg.Metadata(), "cta_message", "display_text")
+		url, _ := jsonparser.GetString(msg.Metadata(), "cta_message", "url")
+		if displayText == "" || url == "" {
+			return nil, fmt.Errorf("cta_url messages require a display_text and url")
+		}
+		interactive = newCTAInteractive(body, displayText, url)
+	case msg.InteractionType() == "flow_msg":
+		flow, err := newMsgFlowInteractive(msg, body)
+		if err != nil {
+			return nil, err
+		}
+		interactive = flow
+	case msg.InteractionType() == "address":
+		address, err := newMsgAddressInteractive(msg, body)
+		if err != nil {
+			return nil, err
+		}
+		interactive = address
+	case msg.InteractionType() == "pix":
+		pix, err := newMsgPixInteractive(msg, body)
+		if err != nil {
+			return nil, err
+		}
+		interactive = pix
+	default:
+		return nil, nil
+	}
+
+	return interactive.withFooter(msg.Footer()), nil
+}
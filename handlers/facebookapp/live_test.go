@@ -0,0 +1,47 @@
+//go:build live
+
+package facebookapp
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// TestLiveWAC sends and receives msgs through a WhatsApp Cloud sandbox number, run with:
+//
+//	WAC_LIVE_TOKEN=... WAC_LIVE_PHONE_NUMBER_ID=... WAC_LIVE_TO=... go test -tags=live -run TestLiveWAC ./handlers/facebookapp
+//
+// Setting WAC_LIVE_APP_SECRET and COURIER_LIVE_TUNNEL_URL to a tunnel configured as the webhook of the app also waits
+// for a msg to be sent to the sandbox number.
+func TestLiveWAC(t *testing.T) {
+	env := LiveEnv(t, "WAC_LIVE_TOKEN", "WAC_LIVE_PHONE_NUMBER_ID", "WAC_LIVE_TO")
+
+	channel := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", env["WAC_LIVE_PHONE_NUMBER_ID"], "", map[string]interface{}{courier.ConfigAuthToken: env["WAC_LIVE_TOKEN"]})
+	configure := func(config *courier.Config) {
+		config.WhatsappAdminSystemUserToken = env["WAC_LIVE_TOKEN"]
+		config.WhatsappCloudApplicationSecret = os.Getenv("WAC_LIVE_APP_SECRET")
+	}
+
+	mediaURL := os.Getenv("WAC_LIVE_MEDIA_URL")
+	if mediaURL == "" {
+		mediaURL = "https://www.w3.org/People/mimasa/test/imgformat/img/w3c_home.jpg"
+	}
+
+	RunLiveSendTestCases(t, channel, newHandler("WAC", "Cloud API WhatsApp", false), configure, urns.URN("whatsapp:"+env["WAC_LIVE_TO"]), []LiveSendTestCase{
+		{Label: "Text", Text: "Live test text"},
+		{Label: "Image", Text: "Live test image", Attachments: []string{"image/jpeg:" + mediaURL}},
+		{Label: "Quick Replies", Text: "Live test buttons", QuickReplies: []string{"Yes", "No"}},
+		{Label: "List", Text: "Live test list", QuickReplies: []string{"1", "2", "3", "4"}},
+		{Label: "CTA URL", Text: "Live test link", Metadata: json.RawMessage(`{"interaction_type": "cta_url", "cta_message": {"display_text": "Open", "url": "https://example.com"}}`)},
+	})
+
+	if os.Getenv("WAC_LIVE_APP_SECRET") != "" {
+		RunLiveReceiveTest(t, channel, newHandler("WAC", "Cloud API WhatsApp", false), configure, "/c/wac/receive", 2*time.Minute)
+	}
+}
@@ -0,0 +1,77 @@
+package facebookapp
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/patrickmn/go-cache"
+)
+
+// the host WhatsApp serves the media of incoming msgs from, whose URLs expire after a few minutes, e.g.
+//
+//	https://lookaside.fbsbx.com/whatsapp_business/attachments/?mid=1234567890&ext=1690000000&hash=ATs
+const wacMediaHost = "lookaside.fbsbx.com"
+
+// wacMediaID returns the id of the WhatsApp media the passed in URL is of, or an empty string if it isn't one of theirs
+func wacMediaID(mediaURL string) string {
+	parsed, err := url.Parse(mediaURL)
+	if err != nil || parsed.Host != wacMediaHost || !strings.HasPrefix(parsed.Path, "/whatsapp_business/") {
+		return ""
+	}
+	return parsed.Query().Get("mid")
+}
+
+// ResolveMediaURL resolves a fresh URL for the WhatsApp media the passed in URL is of, which can be done until
+// WhatsApp deletes the media 30 days after it was received. URLs of any other media resolve to an empty string.
+func (h *handler) ResolveMediaURL(ctx context.Context, config *courier.Config, channel courier.Channel, mediaURL string) (string, error) {
+	if channel.ChannelType() != "WAC" {
+		return "", nil
+	}
+	mediaID := wacMediaID(mediaURL)
+	if mediaID == "" {
+		return "", nil
+	}
+	return h.resolveMediaURL(channel, mediaID, config.WhatsappAdminSystemUserToken)
+}
+
+// the size and SHA256 WhatsApp reported for the media URLs we resolved, kept for as long as those URLs are valid
+var mediaChecksums = cache.New(15*time.Minute, 15*time.Minute)
+
+// rememberMediaChecksum remembers the size and SHA256 of the media resolved to the passed in URL from the passed in
+// media metadata, e.g.
+//
+//	{"url": "https://lookaside.fbsbx.com/...", "mime_type": "image/jpeg", "sha256": "a3f4...", "file_size": 303833, "id": "1234"}
+func rememberMediaChecksum(channel courier.Channel, mediaURL string, metadata []byte) {
+	checksum := &courier.MediaChecksum{}
+	checksum.SHA256, _ = jsonparser.GetString(metadata, "sha256")
+
+	// file sizes have been reported as both numbers and strings
+	if size, err := jsonparser.GetInt(metadata, "file_size"); err == nil {
+		checksum.Size = int(size)
+	} else if sizeStr, err := jsonparser.GetString(metadata, "file_size"); err == nil {
+		checksum.Size, _ = strconv.Atoi(sizeStr)
+	}
+
+	if checksum.SHA256 != "" || checksum.Size > 0 {
+		mediaChecksums.Set(mediaChecksumKey(channel, mediaURL), checksum, cache.DefaultExpiration)
+	}
+}
+
+func mediaChecksumKey(channel courier.Channel, mediaURL string) string {
+	return fmt.Sprintf("%s-%s", channel.UUID(), mediaURL)
+}
+
+// MediaChecksum returns the size and SHA256 WhatsApp reported for the media we resolved to the passed in URL, if we did
+func (h *handler) MediaChecksum(channel courier.Channel, mediaURL string) *courier.MediaChecksum {
+	checksum, found := mediaChecksums.Get(mediaChecksumKey(channel, mediaURL))
+	if !found {
+		return nil
+	}
+	return checksum.(*courier.MediaChecksum)
+}
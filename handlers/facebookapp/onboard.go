@@ -0,0 +1,263 @@
+package facebookapp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+)
+
+const (
+	onboardAction = "onboard"
+
+	configWANumber = "wa_number"
+
+	// the permission embedded signup grants on the WhatsApp Business Accounts shared with us
+	wabaManagementScope = "whatsapp_business_management"
+)
+
+// onboardPayload is the body posted to onboard the phone numbers of a WhatsApp Business Account as channels. The state
+// is the one embedded signup was started with, see parseOnboardState.
+//
+//	{
+//	  "state": "1:1700000000:5d41402abc4b2a76b9719d911017c592...",
+//	  "code": "AQBx3B...",
+//	  "waba_id": "102290129340398"
+//	}
+type onboardPayload struct {
+	State  string `json:"state" validate:"required"`
+	Code   string `json:"code" validate:"required"`
+	WABAID string `json:"waba_id"`
+}
+
+// onboardedChannel is what we return for each phone number we onboarded
+type onboardedChannel struct {
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	Address     string              `json:"address"`
+	Name        string              `json:"name"`
+	Created     bool                `json:"created"`
+}
+
+// wacPhoneNumber is a phone number of a WhatsApp Business Account
+type wacPhoneNumber struct {
+	ID                 string `json:"id"`
+	DisplayPhoneNumber string `json:"display_phone_number"`
+	VerifiedName       string `json:"verified_name"`
+}
+
+// isOnboardRequest returns whether the passed in request targets our onboarding route
+func isOnboardRequest(r *http.Request) bool {
+	return strings.HasSuffix(r.URL.Path, "/"+onboardAction)
+}
+
+// signOnboardState returns the state embedded signup is started with for the passed in org, valid until the passed in
+// time, which is the org and expiry signed with our onboard secret: <org_id>:<expires_unix>:<hex HMAC-SHA256>
+func signOnboardState(secret string, orgID int64, expires time.Time) string {
+	signed := fmt.Sprintf("%d:%d", orgID, expires.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return fmt.Sprintf("%s:%s", signed, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// parseOnboardState returns the org the passed in state was signed for, if its signature is valid and it hasn't expired
+func parseOnboardState(secret string, state string, now time.Time) (int64, error) {
+	parts := strings.Split(state, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid onboard state")
+	}
+
+	orgID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || orgID <= 0 {
+		return 0, fmt.Errorf("invalid onboard state")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid onboard state")
+	}
+
+	expected := signOnboardState(secret, orgID, time.Unix(expires, 0))
+	if !hmac.Equal([]byte(expected), []byte(state)) {
+		return 0, fmt.Errorf("invalid onboard state signature")
+	}
+	if now.Unix() > expires {
+		return 0, fmt.Errorf("onboard state has expired")
+	}
+	return orgID, nil
+}
+
+// onboard exchanges an embedded signup code for a system user token and creates a channel for each phone number of
+// the shared WhatsApp Business Account, updating the token of any that already exist. The org channels are onboarded
+// for is the one the signed state embedded signup was started with is for, and phone numbers which already have a
+// channel in another org aren't claimed. There's no channel for these requests so errors are written here rather than
+// returned.
+func (h *handler) onboard(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if !h.checkAdminAuth(r) {
+		return nil, courier.WriteAndLogUnauthorized(ctx, w, r, nil, fmt.Errorf("invalid Authorization header"))
+	}
+
+	secret := h.Server().Config().WhatsappCloudOnboardSecret
+	if secret == "" {
+		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("onboarding isn't configured"))
+	}
+
+	payload := &onboardPayload{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	orgID, err := parseOnboardState(secret, payload.State, time.Now())
+	if err != nil {
+		return nil, courier.WriteAndLogUnauthorized(ctx, w, r, nil, err)
+	}
+
+	token, err := h.exchangeSignupCode(payload.Code)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, err)
+	}
+
+	wabaID := payload.WABAID
+	if wabaID == "" {
+		wabaID, err = h.sharedWABAID(token)
+		if err != nil {
+			return nil, courier.WriteError(ctx, w, r, err)
+		}
+	}
+
+	phones := &struct {
+		Data []wacPhoneNumber `json:"data"`
+	}{}
+	err = getGraphJSON(h.graphRootURL(fmt.Sprintf("%s/phone_numbers", wabaID), url.Values{"fields": []string{"id,display_phone_number,verified_name"}}), token, phones)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("unable to look up phone numbers: %s", err))
+	}
+	if len(phones.Data) == 0 {
+		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("no phone numbers found for WhatsApp Business Account %s", wabaID))
+	}
+
+	// look up the channels of phone numbers we already have, none of which can belong to another org
+	existing := make(map[string]courier.Channel, len(phones.Data))
+	for _, phone := range phones.Data {
+		ch, err := h.Backend().GetChannelByAddress(ctx, h.ChannelType(), courier.ChannelAddress(phone.ID))
+		if err == courier.ErrChannelNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, courier.WriteError(ctx, w, r, err)
+		}
+
+		orgChannel, isOrgChannel := ch.(courier.OrgChannel)
+		if !isOrgChannel || orgChannel.ChannelOrgID() != orgID {
+			return nil, courier.WriteError(ctx, w, r, fmt.Errorf("phone number %s already has a channel in another org", phone.DisplayPhoneNumber))
+		}
+		existing[phone.ID] = ch
+	}
+
+	// subscribe our app to the account so we receive its webhooks
+	req, _ := http.NewRequest(http.MethodPost, h.graphRootURL(fmt.Sprintf("%s/subscribed_apps", wabaID), nil), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return nil, courier.WriteError(ctx, w, r, fmt.Errorf("unable to subscribe app to WhatsApp Business Account: %s\n%s", err, rr.Response))
+	}
+
+	onboarded := make([]interface{}, 0, len(phones.Data))
+	channelUUIDs := make([]string, 0, len(phones.Data))
+	for _, phone := range phones.Data {
+		config := map[string]interface{}{
+			courier.ConfigUserToken: token,
+			configWABAID:            wabaID,
+			configWANumber:          phone.DisplayPhoneNumber,
+			configDisplayName:       phone.VerifiedName,
+		}
+
+		// phone numbers which already have a channel are claimed with the new token
+		if ch := existing[phone.ID]; ch != nil {
+			err = h.Backend().UpdateChannelConfig(ctx, ch, config)
+			if err != nil {
+				return nil, courier.WriteError(ctx, w, r, err)
+			}
+			onboarded = append(onboarded, &onboardedChannel{ch.UUID(), phone.ID, phone.VerifiedName, false})
+			channelUUIDs = append(channelUUIDs, ch.UUID().String())
+			continue
+		}
+
+		created, err := h.Backend().CreateChannel(ctx, orgID, h.ChannelType(), courier.ChannelAddress(phone.ID), phone.VerifiedName, []string{"whatsapp"}, config)
+		if err != nil {
+			return nil, courier.WriteError(ctx, w, r, err)
+		}
+		onboarded = append(onboarded, &onboardedChannel{created.UUID(), phone.ID, phone.VerifiedName, true})
+		channelUUIDs = append(channelUUIDs, created.UUID().String())
+	}
+
+	h.writeAuditRecord(ctx, courier.NewAuditRecord(h.Server().Config(), r, "onboard", nil, channelUUIDs))
+
+	return nil, courier.WriteDataResponse(ctx, w, http.StatusOK, "Channels onboarded", onboarded)
+}
+
+// exchangeSignupCode exchanges the code returned by embedded signup for a system user access token
+func (h *handler) exchangeSignupCode(code string) (string, error) {
+	config := h.Server().Config()
+	query := url.Values{
+		"client_id":     []string{config.WhatsappCloudApplicationID},
+		"client_secret": []string{config.WhatsappCloudApplicationSecret},
+		"code":          []string{code},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, h.graphRootURL("oauth/access_token", query), nil)
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to exchange signup code: %s\n%s", err, rr.Response)
+	}
+
+	token, err := jsonparser.GetString(rr.Body, "access_token")
+	if err != nil || token == "" {
+		return "", fmt.Errorf("no access token in signup code exchange")
+	}
+	return token, nil
+}
+
+// sharedWABAID returns the WhatsApp Business Account the passed in token was granted access to
+func (h *handler) sharedWABAID(token string) (string, error) {
+	config := h.Server().Config()
+	appToken := fmt.Sprintf("%s|%s", config.WhatsappCloudApplicationID, config.WhatsappCloudApplicationSecret)
+
+	debug := &struct {
+		Data struct {
+			GranularScopes []struct {
+				Scope     string   `json:"scope"`
+				TargetIDs []string `json:"target_ids"`
+			} `json:"granular_scopes"`
+		} `json:"data"`
+	}{}
+	err := getGraphJSON(h.graphRootURL("debug_token", url.Values{"input_token": []string{token}}), appToken, debug)
+	if err != nil {
+		return "", fmt.Errorf("unable to look up shared WhatsApp Business Account: %s", err)
+	}
+
+	for _, scope := range debug.Data.GranularScopes {
+		if scope.Scope == wabaManagementScope && len(scope.TargetIDs) > 0 {
+			return scope.TargetIDs[0], nil
+		}
+	}
+	return "", fmt.Errorf("no WhatsApp Business Account shared with signup code")
+}
+
+// graphRootURL builds a Graph URL for the passed in path and query, for calls not made on behalf of a channel
+func (h *handler) graphRootURL(path string, query url.Values) string {
+	base := h.graphVersionURL(h.graphAPIVersion(nil))
+	u := base.ResolveReference(&url.URL{Path: path})
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,85 @@
+package facebookapp
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+)
+
+// the only currency PIX payments can be made in
+const pixCurrency = "BRL"
+
+// amounts are sent to WhatsApp as integers of hundredths
+const wacAmountOffset = 100
+
+type wacAmount struct {
+	Value  int `json:"value"`
+	Offset int `json:"offset"`
+}
+
+type wacPixDynamicCode struct {
+	Code         string `json:"code,omitempty"`
+	MerchantName string `json:"merchant_name"`
+	Key          string `json:"key"`
+	KeyType      string `json:"key_type,omitempty"`
+}
+
+type wacPaymentSetting struct {
+	Type           string             `json:"type"`
+	PixDynamicCode *wacPixDynamicCode `json:"pix_dynamic_code,omitempty"`
+}
+
+type wacOrderDetails struct {
+	ReferenceID     string              `json:"reference_id"`
+	Type            string              `json:"type"`
+	PaymentType     string              `json:"payment_type"`
+	PaymentSettings []wacPaymentSetting `json:"payment_settings"`
+	Currency        string              `json:"currency"`
+	TotalAmount     wacAmount           `json:"total_amount"`
+}
+
+// newWACAmount converts the passed in amount to the integer representation WhatsApp expects
+func newWACAmount(amount float64) wacAmount {
+	return wacAmount{Value: int(math.Round(amount * wacAmountOffset)), Offset: wacAmountOffset}
+}
+
+// newPixInteractive builds a message asking the contact to pay the passed in amount to the passed in PIX key, without
+// the items of an order
+func newPixInteractive(body string, referenceID string, pix *wacPixDynamicCode, amount float64) *wacInteractive {
+	return &wacInteractive{
+		Type: "order_details",
+		Body: wacInteractiveBody{Text: body},
+		Action: &wacInteractiveAction{Name: "review_and_pay", Parameters: &wacOrderDetails{
+			ReferenceID:     referenceID,
+			Type:            "digital-goods",
+			PaymentType:     "br",
+			PaymentSettings: []wacPaymentSetting{{Type: "pix_dynamic_code", PixDynamicCode: pix}},
+			Currency:        pixCurrency,
+			TotalAmount:     newWACAmount(amount),
+		}},
+	}
+}
+
+// newMsgPixInteractive builds the PIX interactive message for the pix_message in the metadata of the passed in msg,
+// using the UUID of the msg as the reference of the payment if none is given
+func newMsgPixInteractive(msg courier.Msg, body string) (*wacInteractive, error) {
+	metadata := msg.Metadata()
+	key, _ := jsonparser.GetString(metadata, "pix_message", "key")
+	merchantName, _ := jsonparser.GetString(metadata, "pix_message", "merchant_name")
+	amount, _ := jsonparser.GetFloat(metadata, "pix_message", "amount")
+	if key == "" || merchantName == "" || amount <= 0 {
+		return nil, fmt.Errorf("pix messages require a key, merchant_name and positive amount")
+	}
+
+	keyType, _ := jsonparser.GetString(metadata, "pix_message", "key_type")
+	code, _ := jsonparser.GetString(metadata, "pix_message", "code")
+	referenceID, _ := jsonparser.GetString(metadata, "pix_message", "reference_id")
+	if referenceID == "" {
+		referenceID = msg.UUID().String()
+	}
+
+	pix := &wacPixDynamicCode{Code: code, MerchantName: merchantName, Key: key, KeyType: keyType}
+	return newPixInteractive(body, referenceID, pix, amount), nil
+}
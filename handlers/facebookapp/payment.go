@@ -0,0 +1,75 @@
+package facebookapp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// the type of the statuses Meta sends us about the payment of an order_details message
+const wacPaymentStatusType = "payment"
+
+// wacPayment is the payment of an order_details message included in a payment status
+type wacPayment struct {
+	ReferenceID string    `json:"reference_id"`
+	Amount      wacAmount `json:"amount"`
+	Currency    string    `json:"currency"`
+	Transaction *struct {
+		ID     string `json:"id"`
+		Type   string `json:"type"`
+		Status string `json:"status"`
+		Method *struct {
+			Type string `json:"type"`
+		} `json:"method"`
+		Error *struct {
+			Code   int    `json:"code"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"transaction"`
+}
+
+// writePaymentEvent writes a payment status of an order_details message sent to the passed in recipient as a channel
+// event, so that flows waiting on the payment of the order with the same reference ID can continue
+func (h *handler) writePaymentEvent(ctx context.Context, channel courier.Channel, externalID string, recipientID string, status string, timestamp string, payment *wacPayment) (courier.ChannelEvent, error) {
+	if payment == nil || payment.ReferenceID == "" {
+		return nil, fmt.Errorf("payment status missing reference_id")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp: %s", timestamp)
+	}
+
+	urn, err := urns.NewWhatsAppURN(recipientID)
+	if err != nil {
+		return nil, err
+	}
+
+	extra := map[string]interface{}{
+		"external_id":   externalID,
+		"reference_id":  payment.ReferenceID,
+		"status":        status,
+		"amount_value":  payment.Amount.Value,
+		"amount_offset": payment.Amount.Offset,
+		"currency":      payment.Currency,
+	}
+	if payment.Transaction != nil {
+		extra["transaction_id"] = payment.Transaction.ID
+		extra["transaction_type"] = payment.Transaction.Type
+		extra["transaction_status"] = payment.Transaction.Status
+		if payment.Transaction.Method != nil {
+			extra["payment_method"] = payment.Transaction.Method.Type
+		}
+		if payment.Transaction.Error != nil {
+			extra["error_code"] = payment.Transaction.Error.Code
+			extra["error_reason"] = payment.Transaction.Error.Reason
+		}
+	}
+
+	event := h.Backend().NewChannelEvent(channel, courier.OrderPayment, urn).WithOccurredOn(time.Unix(ts, 0).UTC()).WithExtra(extra)
+	return event, h.Backend().WriteChannelEvent(ctx, event)
+}
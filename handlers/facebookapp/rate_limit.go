@@ -0,0 +1,210 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/librato"
+	"github.com/sirupsen/logrus"
+)
+
+// channel config key of the WhatsApp Business Account a WAC channel belongs to, which Graph rate limits are applied to
+const configWABAID = "wa_waba_id"
+
+// redis key of the backoff shared by all channels of a rate limited business account
+const backoffKeyPattern = "graph_backoff:%s"
+
+// redis key of the backoff of a single recipient of a business account, for pair rate limits
+const pairBackoffKeyPattern = "graph_backoff:%s:%s"
+
+// Graph API error code which means we've sent too many messages to one recipient, only sends to them are backed off
+const pairRateLimitCode = 131056
+
+// Graph API error codes which mean we are being rate limited
+var throttleErrorCodes = map[int]bool{
+	4:      true, // application request limit reached
+	17:     true, // user request limit reached
+	32:     true, // page request limit reached
+	613:    true, // calls within one hour exceeded
+	80007:  true, // WhatsApp business account rate limit hit
+	130429: true, // cloud API throughput reached
+
+	pairRateLimitCode: true, // too many messages to the same contact
+}
+
+var (
+	// how many times we retry a rate limited request before giving up
+	maxThrottleRetries = 3
+
+	// the backoff after our first rate limited request, doubled for each retry
+	throttleBaseDelay = time.Second
+
+	// the longest we will wait before making a request, longer backoffs fail the message for it to be retried later
+	throttleMaxDelay = 10 * time.Second
+)
+
+// businessUseCaseUsage is the usage Graph reports for a business account in the X-Business-Use-Case-Usage header
+//
+//	{"102290129340398": [{"type": "whatsapp", "call_count": 95, "total_cputime": 20, "total_time": 25, "estimated_time_to_regain_access": 2}]}
+type businessUseCaseUsage struct {
+	Type                        string `json:"type"`
+	CallCount                   int    `json:"call_count"`
+	TotalCPUTime                int    `json:"total_cputime"`
+	TotalTime                   int    `json:"total_time"`
+	EstimatedTimeToRegainAccess int    `json:"estimated_time_to_regain_access"`
+}
+
+// backoffAccount returns the account the Graph rate limits for the passed in channel are applied to
+func backoffAccount(channel courier.Channel) string {
+	if channel.ChannelType() == "WAC" {
+		return channel.StringConfigForKey(configWABAID, channel.Address())
+	}
+	return channel.Address()
+}
+
+// backoffKeys returns the keys of the backoffs which apply to sends of the passed in msg, for its account and recipient
+func backoffKeys(msg courier.Msg) []string {
+	account := backoffAccount(msg.Channel())
+	return []string{fmt.Sprintf(backoffKeyPattern, account), fmt.Sprintf(pairBackoffKeyPattern, account, msg.URN().Path())}
+}
+
+// graphErrorCode returns the Graph API error code of the passed in response, or 0 if it has none
+func graphErrorCode(rr *utils.RequestResponse) int {
+	response := &struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}{}
+	json.Unmarshal(rr.Body, response)
+	return response.Error.Code
+}
+
+// isThrottled returns whether the passed in response means we are being rate limited
+func isThrottled(rr *utils.RequestResponse) bool {
+	return rr.StatusCode == http.StatusTooManyRequests || throttleErrorCodes[graphErrorCode(rr)]
+}
+
+// parseUsage returns the highest usage percentage and the seconds until access is regained reported in the passed in response
+func parseUsage(rr *utils.RequestResponse) (int, int) {
+	header := rr.Header.Get("X-Business-Use-Case-Usage")
+	if header == "" {
+		return 0, 0
+	}
+
+	accounts := map[string][]businessUseCaseUsage{}
+	err := json.Unmarshal([]byte(header), &accounts)
+	if err != nil {
+		return 0, 0
+	}
+
+	maxUsage, regainAccess := 0, 0
+	for _, usages := range accounts {
+		for _, usage := range usages {
+			for _, pct := range []int{usage.CallCount, usage.TotalCPUTime, usage.TotalTime} {
+				if pct > maxUsage {
+					maxUsage = pct
+				}
+			}
+			if usage.EstimatedTimeToRegainAccess*60 > regainAccess {
+				regainAccess = usage.EstimatedTimeToRegainAccess * 60
+			}
+		}
+	}
+	return maxUsage, regainAccess
+}
+
+// throttleDelay returns the exponential backoff with jitter for the passed in retry attempt
+func throttleDelay(attempt int) time.Duration {
+	delay := throttleBaseDelay << uint(attempt)
+	if delay > throttleMaxDelay || delay <= 0 {
+		delay = throttleMaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// waitForBackoff waits out any backoff set for the account or recipient of the passed in msg, up to our max delay
+func (h *handler) waitForBackoff(msg courier.Msg) {
+	rc := h.Backend().RedisPool().Get()
+	remaining := int64(0)
+	for _, key := range backoffKeys(msg) {
+		pttl, err := redis.Int64(rc.Do("PTTL", key))
+		if err == nil && pttl > remaining {
+			remaining = pttl
+		}
+	}
+	rc.Close()
+
+	if remaining <= 0 {
+		return
+	}
+
+	wait := time.Duration(remaining) * time.Millisecond
+	if wait > throttleMaxDelay {
+		wait = throttleMaxDelay
+	}
+	time.Sleep(wait)
+}
+
+// setBackoff makes requests wait for the passed in delay, all those for the account of the passed in msg or, if we hit
+// the pair rate limit, only those to its recipient
+func (h *handler) setBackoff(msg courier.Msg, delay time.Duration, pairLimited bool) {
+	rc := h.Backend().RedisPool().Get()
+	defer rc.Close()
+
+	keys := backoffKeys(msg)
+	key := keys[0]
+	if pairLimited {
+		key = keys[1]
+	}
+
+	_, err := rc.Do("SET", key, "1", "PX", delay.Milliseconds())
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).Error("error setting graph backoff")
+	}
+}
+
+// makeGraphRequest makes the passed in request to the Graph API after waiting out any backoff for the channel's account,
+// retrying with exponential backoff when we are rate limited. Logs for rate limited attempts are added to the passed in
+// status, the response of the last attempt is returned for the caller to log.
+func (h *handler) makeGraphRequest(msg courier.Msg, status courier.MsgStatus, req *http.Request) (*utils.RequestResponse, error) {
+	channel := msg.Channel()
+
+	for attempt := 0; ; attempt++ {
+		h.waitForBackoff(msg)
+
+		rr, err := utils.MakeHTTPRequestWithClient(req, graphClient)
+
+		usage, regainAccess := parseUsage(rr)
+		if usage > 0 {
+			librato.Gauge(fmt.Sprintf("courier.graph_usage_%s", channel.ChannelType()), float64(usage))
+		}
+
+		if err == nil || !isThrottled(rr) {
+			status.SetErrorClass(graphResponseErrorClass(rr, err))
+			return rr, err
+		}
+
+		librato.Gauge(fmt.Sprintf("courier.graph_throttled_%s", channel.ChannelType()), float64(1))
+
+		delay := throttleDelay(attempt)
+		if time.Duration(regainAccess)*time.Second > delay {
+			delay = time.Duration(regainAccess) * time.Second
+		}
+		h.setBackoff(msg, delay, graphErrorCode(rr) == pairRateLimitCode)
+
+		// give up if we've retried enough or Graph wants us to back off for longer than we're willing to wait
+		if attempt >= maxThrottleRetries || delay > throttleMaxDelay || req.GetBody == nil {
+			status.SetErrorClass(courier.ErrorClassRateLimit)
+			return rr, err
+		}
+
+		status.AddLog(courier.NewChannelLogFromRR("Message Rate Limited", channel, msg.ID(), rr).WithError("Message Rate Limited", err))
+		req.Body, _ = req.GetBody()
+	}
+}
@@ -0,0 +1,40 @@
+package facebookapp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nyaruka/courier"
+)
+
+// channel config key for how many msgs of a WAC channel an instance sends at once, overriding our config's default
+const configSendParallelism = "send_parallelism"
+
+// graphClient is the client our sends to the Graph API share. Unlike our shared client, which keeps few idle
+// connections to each host, it keeps enough for the concurrent sends of our channels to reuse them.
+var graphClient = &http.Client{
+	Transport: newGraphTransport(),
+	Timeout:   60 * time.Second,
+}
+
+func newGraphTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 100
+	transport.MaxIdleConnsPerHost = 100
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
+
+// SendParallelism returns how many msgs of the passed in channel an instance sends at once, so broadcasts on WAC
+// channels aren't limited to how many senders we have. Msgs of other channel types are sent like any other.
+func (h *handler) SendParallelism(channel courier.Channel) int {
+	if channel.ChannelType() != "WAC" {
+		return 0
+	}
+
+	parallelism := 0
+	if h.Server() != nil {
+		parallelism = h.Server().Config().WhatsappCloudSendParallelism
+	}
+	return channel.IntConfigForKey(configSendParallelism, parallelism)
+}
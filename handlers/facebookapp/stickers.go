@@ -0,0 +1,23 @@
+package facebookapp
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// stickerEmoji returns the emoji for the passed in sticker if it's one of the Meta stickers we know, or an empty string
+func stickerEmoji(sticker *wacSticker) string {
+	id, err := strconv.ParseInt(sticker.ID, 10, 64)
+	if err != nil {
+		return ""
+	}
+	return stickerIDToEmoji[id]
+}
+
+// stickerMetadata returns the metadata we add to msgs which are the passed in sticker, e.g.
+//
+//	{"sticker": {"id": "369239263222822", "animated": false, "mime_type": "image/webp", "sha256": "29ed500fa64eb5"}}
+func stickerMetadata(sticker *wacSticker) json.RawMessage {
+	metadata, _ := json.Marshal(map[string]interface{}{"sticker": sticker})
+	return metadata
+}
@@ -0,0 +1,58 @@
+package facebookapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we remember a template language we couldn't map, during which msgs using it fail without alerting again
+const languageFailureExpiration = 15 * time.Minute
+
+var languageFailureCache = cache.New(languageFailureExpiration, languageFailureExpiration)
+
+// languageMappingError is returned for templates in a language we have no WhatsApp language code for
+type languageMappingError struct {
+	language string
+}
+
+func (e *languageMappingError) Error() string {
+	return fmt.Sprintf("unable to find mapping for language: %s", e.language)
+}
+
+// templateLanguageAlert is what we post to the alert webhook the first time we fail msgs of a channel for using a
+// template language we can't map
+type templateLanguageAlert struct {
+	Type        string              `json:"type"`
+	ChannelUUID courier.ChannelUUID `json:"channel_uuid"`
+	ChannelType courier.ChannelType `json:"channel_type"`
+	Language    string              `json:"language"`
+}
+
+// failUnmappedLanguage fails the passed in msg, which can never be sent since we can't map the language of its template,
+// alerting about the language only the first time we see it on the channel within our expiration
+func (h *handler) failUnmappedLanguage(ctx context.Context, msg courier.Msg, status courier.MsgStatus, err *languageMappingError) courier.MsgStatus {
+	channel := msg.Channel()
+	key := fmt.Sprintf("%s:%s", channel.UUID(), err.language)
+	if _, found := languageFailureCache.Get(key); !found {
+		languageFailureCache.Set(key, true, cache.DefaultExpiration)
+
+		event := h.Backend().NewChannelEvent(channel, courier.ChannelAlert, urns.NilURN).WithExtra(map[string]interface{}{"alert": "template_language_unmapped", "language": err.language})
+		if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+			logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error writing template language alert")
+		}
+
+		if url := h.Server().Config().AlertWebhookURL; url != "" {
+			go courier.PostChannelAlert(url, channel.UUID(), &templateLanguageAlert{Type: "template_language_unmapped", ChannelUUID: channel.UUID(), ChannelType: channel.ChannelType(), Language: err.language})
+		}
+	}
+
+	status.AddLog(courier.NewChannelLogFromError("Template Language Unmapped", channel, msg.ID(), 0, err))
+	status.SetStatus(courier.MsgFailed)
+	return status
+}
@@ -0,0 +1,48 @@
+package facebookapp
+
+import (
+	"strconv"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/templates"
+	"github.com/sirupsen/logrus"
+)
+
+// checkTemplate logs template sends which don't match the definition of the template synced for their channel. These
+// are still sent, leaving Graph to reject them, as our cached definitions can be stale.
+func (h *handler) checkTemplate(msg courier.Msg, template *wacTemplate) {
+	if err := h.validateTemplate(msg.Channel(), template); err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).WithField("msg_id", msg.ID()).Warn("template send doesn't match its definition")
+	}
+}
+
+// validateTemplate checks the passed in template send against the definition of the template synced for its channel, if
+// we have one. Templates we have no definition for are left for Graph to validate.
+func (h *handler) validateTemplate(channel courier.Channel, template *wacTemplate) error {
+	definition, err := templates.Get(h.Backend().RedisPool(), channel.UUID().String(), template.Name, template.Language.Code)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error looking up template definition")
+		return nil
+	}
+	if definition == nil {
+		return nil
+	}
+
+	bodyParams, headerMedia, buttons := 0, false, make([]int, 0, 2)
+	for _, component := range template.Components {
+		switch component.Type {
+		case "body":
+			bodyParams += len(component.Params)
+		case "header":
+			for _, param := range component.Params {
+				if param.Image != nil || param.Video != nil || param.Document != nil {
+					headerMedia = true
+				}
+			}
+		case "button":
+			index, _ := strconv.Atoi(component.Index)
+			buttons = append(buttons, index)
+		}
+	}
+	return definition.Validate(bodyParams, headerMedia, buttons)
+}
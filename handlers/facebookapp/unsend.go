@@ -0,0 +1,59 @@
+package facebookapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+)
+
+// redis key of how many msgs a contact has unsent from a channel in the current minute
+const unsendsKeyPattern = "unsends:%s:%s"
+
+// the most msgs a contact can unsend from a channel in a minute, unsends beyond that are ignored
+var maxUnsendsPerMinute = 30
+
+// allowUnsend counts an unsend by the passed in contact on the passed in channel, returning whether they're within our limit
+func allowUnsend(rc redis.Conn, channel courier.Channel, urn urns.URN) (bool, error) {
+	key := fmt.Sprintf(unsendsKeyPattern, channel.UUID(), urn.Identity())
+
+	count, err := redis.Int(rc.Do("INCR", key))
+	if err != nil {
+		return false, err
+	}
+	if count == 1 {
+		if _, err := rc.Do("EXPIRE", key, 60); err != nil {
+			return false, err
+		}
+	}
+	return count <= maxUnsendsPerMinute, nil
+}
+
+// unsendMsg marks the msg a contact unsent as deleted and records the unsend as a channel event, returning the event or
+// nil if there was no msg of theirs to delete
+func (h *handler) unsendMsg(ctx context.Context, channel courier.Channel, urn urns.URN, externalID string, date time.Time) (courier.ChannelEvent, error) {
+	rc := h.Backend().RedisPool().Get()
+	allowed, err := allowUnsend(rc, channel, urn)
+	rc.Close()
+	if err != nil {
+		// failing to count isn't a reason to ignore the unsend
+		logrus.WithError(err).WithField("channel_uuid", channel.UUID()).Error("error counting unsends")
+	} else if !allowed {
+		return nil, nil
+	}
+
+	deleted, err := h.Backend().DeleteMsgWithExternalID(ctx, channel, urn, externalID)
+	if err != nil || !deleted {
+		return nil, err
+	}
+
+	event := h.Backend().NewChannelEvent(channel, courier.MsgDeleted, urn).WithOccurredOn(date).WithExtra(map[string]interface{}{"msg_external_id": externalID})
+	if err := h.Backend().WriteChannelEvent(ctx, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
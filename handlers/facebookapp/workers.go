@@ -0,0 +1,96 @@
+package facebookapp
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nyaruka/courier"
+)
+
+// the most goroutines we process the items of a single webhook with
+const maxWebhookWorkers = 8
+
+// wacItem is a single msg, status or synced msg of a webhook. Items for different contacts are processed concurrently
+// but those for the same contact are processed in the order they appear in the webhook.
+type wacItem struct {
+	contact string
+	process func(*wacItem) error
+
+	events   []courier.Event
+	data     []interface{}
+	warnings []error
+	err      error
+	skipped  bool
+}
+
+// add records an event created by this item and the data we return for it
+func (i *wacItem) add(event courier.Event, data interface{}) {
+	i.events = append(i.events, event)
+	i.data = append(i.data, data)
+}
+
+// wacRequestError is an error caused by the content of the webhook rather than by us
+type wacRequestError struct {
+	error
+}
+
+// processWACItems processes the passed in items using at most maxWorkers goroutines. Once an item fails, the items
+// after it for the same contact are skipped so that a retry of the webhook doesn't see them out of order, but the items
+// of other contacts are still processed.
+func processWACItems(items []*wacItem, maxWorkers int) {
+	// group our items by contact, keeping the order they appear in
+	groups := make([][]*wacItem, 0, len(items))
+	groupIndexes := make(map[string]int)
+	for _, item := range items {
+		index, found := groupIndexes[item.contact]
+		if !found || item.contact == "" {
+			index = len(groups)
+			groups = append(groups, nil)
+			groupIndexes[item.contact] = index
+		}
+		groups[index] = append(groups[index], item)
+	}
+
+	workers := make(chan struct{}, maxWorkers)
+	wg := sync.WaitGroup{}
+
+	for _, group := range groups {
+		wg.Add(1)
+		workers <- struct{}{}
+
+		go func(group []*wacItem) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+
+			for i, item := range group {
+				item.err = processWACItem(item)
+				if item.err != nil {
+					for _, rest := range group[i+1:] {
+						rest.skipped = true
+					}
+					return
+				}
+			}
+		}(group)
+	}
+
+	wg.Wait()
+}
+
+// processWACItem processes the passed in item, converting any panic into an error as we are outside of the request
+// goroutine and its recoverer
+func processWACItem(item *wacItem) (err error) {
+	if item.process == nil {
+		return nil
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic processing webhook item: %v", p)
+		}
+	}()
+
+	return item.process(item)
+}
@@ -0,0 +1,127 @@
+//go:build live
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/require"
+)
+
+// env variable of the public URL of a tunnel (e.g. ngrok) forwarding to our live receive server
+const liveTunnelURLEnv = "COURIER_LIVE_TUNNEL_URL"
+
+// env variable of the address our live receive server listens on, which the tunnel forwards to
+const liveListenAddrEnv = "COURIER_LIVE_LISTEN_ADDR"
+
+// how long we give a live sandbox to accept a message
+const liveSendTimeout = 30 * time.Second
+
+// LiveSendTestCase defines a msg sent to a vendor sandbox, which passes if the vendor accepts it
+type LiveSendTestCase struct {
+	Label        string
+	Text         string
+	Attachments  []string
+	QuickReplies []string
+	Metadata     json.RawMessage
+}
+
+// LiveEnv returns the values of the passed in env variables, skipping the test if any of them aren't set
+func LiveEnv(t *testing.T, keys ...string) map[string]string {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value := os.Getenv(key)
+		if value == "" {
+			t.Skipf("%s not set, skipping live test", key)
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// newLiveServer returns a server for live tests, letting the caller configure it with sandbox credentials
+func newLiveServer(channel courier.Channel, handler courier.ChannelHandler, configure func(*courier.Config)) (*courier.MockBackend, courier.Server) {
+	mb := courier.NewMockBackend()
+	mb.AddChannel(channel)
+
+	config := courier.NewConfig()
+	if configure != nil {
+		configure(config)
+	}
+
+	s := courier.NewServer(config, mb)
+	handler.Initialize(s)
+	return mb, s
+}
+
+// RunLiveSendTestCases sends each of the passed in test cases to the passed in URN through the handler, requiring that
+// the vendor sandbox accepts each of them
+func RunLiveSendTestCases(t *testing.T, channel courier.Channel, handler courier.ChannelHandler, configure func(*courier.Config), urn urns.URN, testCases []LiveSendTestCase) {
+	mb, _ := newLiveServer(channel, handler, configure)
+
+	for i, testCase := range testCases {
+		t.Run(testCase.Label, func(t *testing.T) {
+			msg := mb.NewOutgoingMsg(channel, courier.NewMsgID(int64(i+1)), urn, testCase.Text, false, testCase.QuickReplies, "", 0, "", "")
+			for _, a := range testCase.Attachments {
+				msg.WithAttachment(a)
+			}
+			if len(testCase.Metadata) > 0 {
+				msg.WithMetadata(testCase.Metadata)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), liveSendTimeout)
+			defer cancel()
+
+			status, err := handler.SendMsg(ctx, msg)
+			require.NoError(t, err)
+			require.NotNil(t, status)
+
+			for _, log := range status.Logs() {
+				if log.Error != "" {
+					t.Logf("%s %s: %s", log.Method, log.URL, log.Error)
+				}
+			}
+
+			require.Contains(t, []courier.MsgStatusValue{courier.MsgWired, courier.MsgSent, courier.MsgDelivered}, status.Status(), "sandbox didn't accept msg")
+			require.NotEmpty(t, status.ExternalID(), "sandbox didn't return an external id")
+		})
+	}
+}
+
+// RunLiveReceiveTest serves our handler on the address the tunnel in COURIER_LIVE_TUNNEL_URL forwards to and waits for
+// the vendor sandbox to deliver a msg to the passed in path, which the tester triggers by messaging the channel
+func RunLiveReceiveTest(t *testing.T, channel courier.Channel, handler courier.ChannelHandler, configure func(*courier.Config), path string, timeout time.Duration) courier.Msg {
+	env := LiveEnv(t, liveTunnelURLEnv)
+
+	addr := os.Getenv(liveListenAddrEnv)
+	if addr == "" {
+		addr = ":8089"
+	}
+
+	mb, s := newLiveServer(channel, handler, configure)
+
+	server := &http.Server{Addr: addr, Handler: s.Router()}
+	go server.ListenAndServe()
+	defer server.Shutdown(context.Background())
+
+	t.Logf("waiting %s for a msg to be delivered to %s%s", timeout, env[liveTunnelURLEnv], path)
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if msg, err := mb.GetLastQueueMsg(); err == nil {
+			t.Logf("received msg from %s: %s", msg.URN(), msg.Text())
+			return msg
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	require.FailNow(t, "no msg received from sandbox before timeout")
+	return nil
+}
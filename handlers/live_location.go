@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier"
+)
+
+// the key counting the updates we've received of a live location, by channel and the id of the msg which started it
+const liveLocationKey = "live_location:%s:%s"
+
+// how many seconds past the live period we keep counting updates to a live location
+const liveLocationGrace = 300
+
+// LiveLocationMetadata builds the live_location metadata of a msg which starts, or is an update of, the live location
+// started by the msg with the passed in id. The msg starting it is sequence 0 and each update is numbered in the order
+// we receive it.
+func LiveLocationMetadata(rp *redis.Pool, channel courier.Channel, messageID string, livePeriod int, isUpdate bool) (map[string]interface{}, error) {
+	sequence := 0
+	if isUpdate {
+		rc := rp.Get()
+		defer rc.Close()
+
+		key := fmt.Sprintf(liveLocationKey, channel.UUID().String(), messageID)
+		rc.Send("MULTI")
+		rc.Send("INCR", key)
+		rc.Send("EXPIRE", key, livePeriod+liveLocationGrace)
+		values, err := redis.Values(rc.Do("EXEC"))
+		if err != nil {
+			return nil, err
+		}
+		sequence, err = redis.Int(values[0], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{
+		"message_id":  messageID,
+		"sequence":    sequence,
+		"live_period": livePeriod,
+	}, nil
+}
@@ -0,0 +1,414 @@
+package meta
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/pkg/errors"
+)
+
+// default Instagram Graph API endpoint we hit, can be overridden per channel with base_url
+const defaultGraphURL = "https://graph.instagram.com/v21.0/"
+
+const (
+	// the header Instagram signs webhook requests with, using the app secret
+	signatureHeader = "X-Hub-Signature-256"
+
+	// the app secret of the Instagram app the channel's account is connected to
+	configAppSecret = "app_secret"
+)
+
+var (
+	// Instagram says 1000 is max for the text of a msg
+	maxMsgLength = 1000
+
+	// and 13 for the number of quick replies
+	maxQuickReplies = 13
+)
+
+func init() {
+	courier.RegisterHandler(newHandler())
+}
+
+type handler struct {
+	handlers.BaseHandler
+	graphURL string
+}
+
+func newHandler() courier.ChannelHandler {
+	return &handler{handlers.NewBaseHandlerWithParams(courier.ChannelType("IGD"), "Instagram Direct", false), defaultGraphURL}
+}
+
+// graphBaseURL returns the Graph API base URL to use for the passed in channel
+func (h *handler) graphBaseURL(channel courier.Channel) *url.URL {
+	base, _ := url.Parse(channel.StringConfigForKey(courier.ConfigBaseURL, h.graphURL))
+	return base
+}
+
+// Initialize is called by the engine once everything is loaded
+func (h *handler) Initialize(s courier.Server) error {
+	h.SetServer(s)
+	s.AddHandlerRoute(h, http.MethodPost, "receive", h.receiveEvent)
+	s.AddHandlerRoute(h, http.MethodGet, "receive", h.receiveVerify)
+	return nil
+}
+
+// ConfigSpec describes the config keys of IGD channels
+func (h *handler) ConfigSpec() []courier.ConfigField {
+	return []courier.ConfigField{
+		{Key: courier.ConfigAuthToken, Label: "Instagram User Access Token", Required: true, Secret: true},
+		{Key: configAppSecret, Label: "App Secret", Help: "Used to validate the signatures of received webhooks", Required: true, Secret: true},
+		{Key: courier.ConfigBaseURL, Label: "Base URL", Help: "Overrides the Instagram Graph API URL msgs are sent to"},
+	}
+}
+
+// GetChannel returns the channel of the account the entries of a webhook are for, Instagram Login apps having a single
+// webhook URL for all their accounts
+func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	if r.Method == http.MethodGet {
+		return nil, nil
+	}
+
+	payload := &moPayload{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if payload.Object != "instagram" {
+		return nil, fmt.Errorf("object expected 'instagram', found %s", payload.Object)
+	}
+
+	if len(payload.Entry) == 0 {
+		return nil, fmt.Errorf("no entries found")
+	}
+
+	return h.Backend().GetChannelByAddress(ctx, h.ChannelType(), courier.ChannelAddress(payload.Entry[0].ID))
+}
+
+// receiveVerify handles Instagram's webhook verification callback
+func (h *handler) receiveVerify(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	mode := r.URL.Query().Get("hub.mode")
+
+	// this isn't a subscribe verification, that's an error
+	if mode != "subscribe" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("unknown request"))
+	}
+
+	// verify the token against our server instagram webhook secret, if the same return the challenge Instagram sent us
+	secret := r.URL.Query().Get("hub.verify_token")
+	if secret == "" || secret != h.Server().Config().InstagramWebhookSecret {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("token does not match secret"))
+	}
+
+	_, err := fmt.Fprint(w, r.URL.Query().Get("hub.challenge"))
+	return nil, err
+}
+
+type igUser struct {
+	ID string `json:"id"`
+}
+
+//	{
+//	  "object": "instagram",
+//	  "entry": [{
+//	    "id": "17841400000000000",
+//	    "time": 1730000000000,
+//	    "messaging": [{
+//	      "sender": {"id": "5678"},
+//	      "recipient": {"id": "17841400000000000"},
+//	      "timestamp": 1730000000000,
+//	      "message": {
+//	        "mid": "aWdfZAG1faXRlbToxOklH",
+//	        "text": "Hello World",
+//	        "attachments": [{"type": "image", "payload": {"url": "https://lookaside.fbsbx.com/ig_messaging_cdn/?asset_id=1"}}]
+//	      }
+//	    }]
+//	  }]
+//	}
+type moPayload struct {
+	Object string `json:"object"`
+	Entry  []struct {
+		ID        string `json:"id"`
+		Time      int64  `json:"time"`
+		Messaging []struct {
+			Sender    igUser `json:"sender"`
+			Recipient igUser `json:"recipient"`
+			Timestamp int64  `json:"timestamp"`
+
+			Message *struct {
+				IsEcho      bool   `json:"is_echo"`
+				IsDeleted   bool   `json:"is_deleted"`
+				MID         string `json:"mid"`
+				Text        string `json:"text"`
+				Attachments []struct {
+					Type    string `json:"type"`
+					Payload *struct {
+						URL string `json:"url"`
+					} `json:"payload"`
+				} `json:"attachments"`
+			} `json:"message"`
+		} `json:"messaging"`
+	} `json:"entry"`
+}
+
+// receiveEvent is our HTTP handler function for incoming messages
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if err := h.validateSignature(channel, r); err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	payload := &moPayload{}
+	err := handlers.DecodeAndValidateJSON(payload, r)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	events := make([]courier.Event, 0, 2)
+	data := make([]interface{}, 0, 2)
+
+	for _, entry := range payload.Entry {
+		for _, msg := range entry.Messaging {
+			// ignore this entry if it is to another account
+			if channel.Address() != msg.Recipient.ID {
+				continue
+			}
+
+			if msg.Message == nil {
+				data = append(data, courier.NewInfoData("ignoring unknown entry type"))
+				continue
+			}
+
+			// ignore echos of the msgs we send
+			if msg.Message.IsEcho {
+				data = append(data, courier.NewInfoData("ignoring echo"))
+				continue
+			}
+
+			if msg.Message.IsDeleted {
+				data = append(data, courier.NewInfoData("ignoring deleted message"))
+				continue
+			}
+
+			// create our date from the timestamp (they give us millis, arg is nanos)
+			date := time.Unix(0, msg.Timestamp*1000000).UTC()
+
+			urn, err := urns.NewInstagramURN(msg.Sender.ID)
+			if err != nil {
+				return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+			}
+
+			ev := h.Backend().NewIncomingMsg(channel, urn, msg.Message.Text).WithExternalID(msg.Message.MID).WithReceivedOn(date)
+			event := h.Backend().CheckExternalIDSeen(ev)
+
+			for _, att := range msg.Message.Attachments {
+				if att.Payload != nil && att.Payload.URL != "" {
+					event.WithAttachment(att.Payload.URL)
+				}
+			}
+
+			if err := h.Backend().WriteMsg(ctx, event); err != nil {
+				return nil, err
+			}
+
+			h.Backend().WriteExternalIDSeen(event)
+
+			events = append(events, event)
+			data = append(data, courier.NewMsgReceiveData(event))
+		}
+	}
+
+	return events, courier.WriteDataResponse(ctx, w, http.StatusOK, "Events Handled", data)
+}
+
+// validateSignature checks the signature of the passed in request against the app secret of the channel, which channels
+// must have for us to accept their webhooks
+func (h *handler) validateSignature(channel courier.Channel, r *http.Request) error {
+	appSecret := channel.StringConfigForKey(configAppSecret, "")
+	if appSecret == "" {
+		return fmt.Errorf("missing app secret")
+	}
+
+	headerSignature := r.Header.Get(signatureHeader)
+	if headerSignature == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	body, err := handlers.ReadBody(r, 100000)
+	if err != nil {
+		return fmt.Errorf("unable to read request body: %s", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	expectedSignature := hex.EncodeToString(mac.Sum(nil))
+
+	// compare signatures in way that isn't sensitive to a timing attack
+	signature := strings.TrimPrefix(headerSignature, "sha256=")
+	if !hmac.Equal([]byte(expectedSignature), []byte(signature)) {
+		return fmt.Errorf("invalid request signature")
+	}
+
+	return nil
+}
+
+//	{
+//	  "recipient": {"id": "5678"},
+//	  "message": {
+//	    "text": "Are you happy?",
+//	    "quick_replies": [{"content_type": "text", "title": "Yes", "payload": "Yes"}]
+//	  }
+//	}
+type mtPayload struct {
+	Recipient struct {
+		ID string `json:"id"`
+	} `json:"recipient"`
+	Message struct {
+		Text         string         `json:"text,omitempty"`
+		QuickReplies []mtQuickReply `json:"quick_replies,omitempty"`
+		Attachment   *mtAttachment  `json:"attachment,omitempty"`
+	} `json:"message"`
+}
+
+type mtAttachment struct {
+	Type    string `json:"type"`
+	Payload struct {
+		URL string `json:"url"`
+	} `json:"payload"`
+}
+
+type mtQuickReply struct {
+	ContentType string `json:"content_type"`
+	Title       string `json:"title"`
+	Payload     string `json:"payload"`
+}
+
+func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStatus, error) {
+	// can't do anything without an access token
+	accessToken := msg.Channel().StringConfigForKey(courier.ConfigAuthToken, "")
+	if accessToken == "" {
+		return nil, fmt.Errorf("missing access token")
+	}
+
+	payload := mtPayload{}
+	payload.Recipient.ID = msg.URN().Path()
+
+	msgURL := h.graphBaseURL(msg.Channel()).ResolveReference(&url.URL{Path: "me/messages"})
+
+	status := h.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), courier.MsgErrored)
+
+	msgParts := make([]string, 0)
+	if msg.Text() != "" {
+		msgParts = handlers.SplitMsgByChannel(msg.Channel(), msg.Text(), maxMsgLength)
+	}
+
+	// send each part and each attachment separately. we send attachments first as otherwise quick replies
+	// attached to text messages get hidden when media gets delivered
+	for i := 0; i < len(msgParts)+len(msg.Attachments()); i++ {
+		if i < len(msg.Attachments()) {
+			attType, attURL := handlers.SplitAttachment(msg.Attachments()[i])
+			attType = strings.Split(attType, "/")[0]
+			if attType == "application" {
+				attType = "file"
+			}
+			payload.Message.Attachment = &mtAttachment{Type: attType}
+			payload.Message.Attachment.Payload.URL = attURL
+			payload.Message.Text = ""
+		} else {
+			payload.Message.Text = msgParts[i-len(msg.Attachments())]
+			payload.Message.Attachment = nil
+		}
+
+		// include any quick replies on the last piece we send
+		payload.Message.QuickReplies = nil
+		if i == (len(msgParts)+len(msg.Attachments()))-1 {
+			for j, qr := range msg.QuickReplies() {
+				if j == maxQuickReplies {
+					break
+				}
+				payload.Message.QuickReplies = append(payload.Message.QuickReplies, mtQuickReply{"text", qr, qr})
+			}
+		}
+
+		jsonBody, err := json.Marshal(payload)
+		if err != nil {
+			return status, err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, msgURL.String(), bytes.NewReader(jsonBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+		rr, err := utils.MakeHTTPRequest(req)
+
+		// record our status and log
+		log := courier.NewChannelLogFromRR("Message Sent", msg.Channel(), msg.ID(), rr).WithError("Message Send Error", err)
+		status.AddLog(log)
+		if err != nil {
+			return status, nil
+		}
+
+		externalID, err := jsonparser.GetString(rr.Body, "message_id")
+		if err != nil {
+			log.WithError("Message Send Error", errors.Errorf("unable to get message_id from body"))
+			return status, nil
+		}
+
+		// if this is our first message, record the external id
+		if i == 0 {
+			status.SetExternalID(externalID)
+		}
+
+		// this was wired successfully
+		status.SetStatus(courier.MsgWired)
+	}
+
+	return status, nil
+}
+
+// DescribeURN looks up URN metadata for new contacts
+func (h *handler) DescribeURN(ctx context.Context, channel courier.Channel, urn urns.URN) (map[string]string, error) {
+	accessToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if accessToken == "" {
+		return nil, fmt.Errorf("missing access token")
+	}
+
+	// build a request to lookup the profile of this contact
+	u := h.graphBaseURL(channel).ResolveReference(&url.URL{Path: urn.Path()})
+	query := url.Values{}
+	query.Set("fields", "name,username")
+	u.RawQuery = query.Encode()
+
+	req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up contact data:%s\n%s", err, rr.Response)
+	}
+
+	// use their name if they've set one, otherwise their username
+	name, _ := jsonparser.GetString(rr.Body, "name")
+	if name == "" {
+		name, _ = jsonparser.GetString(rr.Body, "username")
+	}
+
+	return map[string]string{"name": name}, nil
+}
@@ -0,0 +1,247 @@
+package meta
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier"
+	. "github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+var testChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "IGD", "17841400000000000", "",
+		map[string]interface{}{courier.ConfigAuthToken: "a123", configAppSecret: "appsecret"}),
+	courier.NewMockChannel("b0e7ef60-3a0a-4c52-8f4a-4e7d6a3f0dbf", "IGD", "17841411111111111", "",
+		map[string]interface{}{courier.ConfigAuthToken: "a456"}),
+}
+
+var helloMsg = `{
+	"object": "instagram",
+	"entry": [{
+		"id": "17841400000000000",
+		"time": 1459991487970,
+		"messaging": [{
+			"sender": {"id": "5678"},
+			"recipient": {"id": "17841400000000000"},
+			"timestamp": 1459991487970,
+			"message": {"mid": "external_id", "text": "Hello World"}
+		}]
+	}]
+}`
+
+var attachmentMsg = `{
+	"object": "instagram",
+	"entry": [{
+		"id": "17841400000000000",
+		"time": 1459991487970,
+		"messaging": [{
+			"sender": {"id": "5678"},
+			"recipient": {"id": "17841400000000000"},
+			"timestamp": 1459991487970,
+			"message": {
+				"mid": "external_id",
+				"attachments": [{"type": "image", "payload": {"url": "https://lookaside.fbsbx.com/ig_messaging_cdn/?asset_id=1"}}]
+			}
+		}]
+	}]
+}`
+
+var echoMsg = `{
+	"object": "instagram",
+	"entry": [{
+		"id": "17841400000000000",
+		"time": 1459991487970,
+		"messaging": [{
+			"sender": {"id": "17841400000000000"},
+			"recipient": {"id": "17841400000000000"},
+			"timestamp": 1459991487970,
+			"message": {"mid": "external_id", "text": "Hello World", "is_echo": true}
+		}]
+	}]
+}`
+
+var otherAccountMsg = `{
+	"object": "instagram",
+	"entry": [{
+		"id": "17841499999999999",
+		"time": 1459991487970,
+		"messaging": [{
+			"sender": {"id": "5678"},
+			"recipient": {"id": "17841499999999999"},
+			"timestamp": 1459991487970,
+			"message": {"mid": "external_id", "text": "Hello World"}
+		}]
+	}]
+}`
+
+var noAppSecretMsg = `{
+	"object": "instagram",
+	"entry": [{
+		"id": "17841411111111111",
+		"time": 1459991487970,
+		"messaging": [{
+			"sender": {"id": "5678"},
+			"recipient": {"id": "17841411111111111"},
+			"timestamp": 1459991487970,
+			"message": {"mid": "external_id", "text": "Hello World"}
+		}]
+	}]
+}`
+
+var notInstagram = `{"object": "page", "entry": [{}]}`
+
+var noEntries = `{"object": "instagram", "entry": []}`
+
+func signature(body string) string {
+	mac := hmac.New(sha256.New, []byte("appsecret"))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func signed(body string) map[string]string {
+	return map[string]string{"X-Hub-Signature-256": signature(body)}
+}
+
+var testCases = []ChannelHandleTestCase{
+	{Label: "Receive Message", URL: "/c/igd/receive", Data: helloMsg, Status: 200, Response: "Handled", Headers: signed(helloMsg),
+		Text: Sp("Hello World"), URN: Sp("instagram:5678"), ExternalID: Sp("external_id"), Date: Tp(time.Date(2016, 4, 7, 1, 11, 27, 970000000, time.UTC))},
+	{Label: "Receive Attachment", URL: "/c/igd/receive", Data: attachmentMsg, Status: 200, Response: "Handled", Headers: signed(attachmentMsg),
+		Text: Sp(""), Attachments: []string{"https://lookaside.fbsbx.com/ig_messaging_cdn/?asset_id=1"}, URN: Sp("instagram:5678"), ExternalID: Sp("external_id")},
+	{Label: "Receive Echo", URL: "/c/igd/receive", Data: echoMsg, Status: 200, Response: "ignoring echo", Headers: signed(echoMsg)},
+	{Label: "Receive For Unknown Account", URL: "/c/igd/receive", Data: otherAccountMsg, Status: 400, Response: "channel not found", Headers: signed(otherAccountMsg)},
+	{Label: "Not Instagram", URL: "/c/igd/receive", Data: notInstagram, Status: 400, Response: "object expected 'instagram', found page"},
+	{Label: "No Entries", URL: "/c/igd/receive", Data: noEntries, Status: 400, Response: "no entries found"},
+}
+
+func TestHandler(t *testing.T) {
+	RunChannelTestCases(t, testChannels, newHandler(), testCases)
+}
+
+func TestSignature(t *testing.T) {
+	RunChannelTestCases(t, testChannels, newHandler(), []ChannelHandleTestCase{
+		{Label: "Valid Signature", URL: "/c/igd/receive", Data: helloMsg, Status: 200, Response: "Handled", Headers: signed(helloMsg),
+			Text: Sp("Hello World"), URN: Sp("instagram:5678"), ExternalID: Sp("external_id")},
+		{Label: "Missing Signature", URL: "/c/igd/receive", Data: helloMsg, Status: 400, Response: "missing request signature"},
+		{Label: "Invalid Signature", URL: "/c/igd/receive", Data: helloMsg, Status: 400, Response: "invalid request signature", Headers: signed(echoMsg)},
+		{Label: "Missing App Secret", URL: "/c/igd/receive", Data: noAppSecretMsg, Status: 400, Response: "missing app secret", Headers: signed(noAppSecretMsg)},
+	})
+}
+
+func TestVerify(t *testing.T) {
+	RunChannelTestCases(t, testChannels, newHandler(), []ChannelHandleTestCase{
+		{Label: "Receive Message", URL: "/c/igd/receive", Data: helloMsg, Status: 200, Headers: signed(helloMsg)},
+		{Label: "Verify No Mode", URL: "/c/igd/receive", Status: 400, Response: "unknown request"},
+		{Label: "Verify No Secret", URL: "/c/igd/receive?hub.mode=subscribe", Status: 400, Response: "token does not match secret"},
+		{Label: "Invalid Secret", URL: "/c/igd/receive?hub.mode=subscribe&hub.verify_token=blah", Status: 400, Response: "token does not match secret"},
+		{Label: "Valid Secret", URL: "/c/igd/receive?hub.mode=subscribe&hub.verify_token=ig_webhook_secret&hub.challenge=yarchallenge", Status: 200, Response: "yarchallenge"},
+	})
+}
+
+func TestDescribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer a123" {
+			http.Error(w, "invalid auth token", 403)
+			return
+		}
+		switch r.URL.Path {
+		case "/1337":
+			w.Write([]byte(`{"name": "John Doe", "username": "johndoe"}`))
+		case "/4567":
+			w.Write([]byte(`{"username": "janedoe"}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	h := newHandler().(*handler)
+	h.graphURL = server.URL
+
+	tcs := []struct {
+		urn      urns.URN
+		metadata map[string]string
+	}{
+		{"instagram:1337", map[string]string{"name": "John Doe"}},
+		{"instagram:4567", map[string]string{"name": "janedoe"}},
+		{"instagram:8910", map[string]string{"name": ""}},
+	}
+
+	for _, tc := range tcs {
+		metadata, _ := h.DescribeURN(context.Background(), testChannels[0], tc.urn)
+		assert.Equal(t, tc.metadata, metadata)
+	}
+}
+
+// setSendURL takes care of setting the Graph API URL to our test server host
+func setSendURL(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
+	h.(*handler).graphURL = s.URL
+}
+
+var defaultSendTestCases = []ChannelSendTestCase{
+	{Label: "Plain Send",
+		Text: "Simple Message", URN: "instagram:12345",
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"recipient_id": "12345", "message_id": "mid.133"}`, ResponseStatus: 200,
+		Headers:     map[string]string{"Authorization": "Bearer access_token"},
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"text":"Simple Message"}}`,
+		Path:        "/me/messages",
+		SendPrep:    setSendURL},
+	{Label: "Quick Reply",
+		Text: "Are you happy?", URN: "instagram:12345", QuickReplies: []string{"Yes", "No"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"text":"Are you happy?","quick_replies":[{"content_type":"text","title":"Yes","payload":"Yes"},{"content_type":"text","title":"No","payload":"No"}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Long Message",
+		Text: "This is a long message which spans more than one part, what will actually be sent in the end if we exceed the max length?",
+		URN:  "instagram:12345", QuickReplies: []string{"Yes", "No"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"text":"we exceed the max length?","quick_replies":[{"content_type":"text","title":"Yes","payload":"Yes"},{"content_type":"text","title":"No","payload":"No"}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Photo",
+		URN: "instagram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"attachment":{"type":"image","payload":{"url":"https://foo.bar/image.jpg"}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "Send caption and photo with Quick Reply",
+		Text: "This is some text.",
+		URN:  "instagram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
+		QuickReplies: []string{"Yes", "No"},
+		Status:       "W", ExternalID: "mid.133",
+		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"text":"This is some text.","quick_replies":[{"content_type":"text","title":"Yes","payload":"Yes"},{"content_type":"text","title":"No","payload":"No"}]}}`,
+		SendPrep:    setSendURL},
+	{Label: "Send Document",
+		URN: "instagram:12345", Attachments: []string{"application/pdf:https://foo.bar/document.pdf"},
+		Status: "W", ExternalID: "mid.133",
+		ResponseBody: `{"message_id": "mid.133"}`, ResponseStatus: 200,
+		RequestBody: `{"recipient":{"id":"12345"},"message":{"attachment":{"type":"file","payload":{"url":"https://foo.bar/document.pdf"}}}}`,
+		SendPrep:    setSendURL},
+	{Label: "ID Error",
+		Text: "ID Error", URN: "instagram:12345",
+		Status:       "E",
+		ResponseBody: `{ "is_error": true }`, ResponseStatus: 200,
+		SendPrep: setSendURL},
+	{Label: "Error",
+		Text: "Error", URN: "instagram:12345",
+		Status:       "E",
+		ResponseBody: `{"error": {"message": "Invalid OAuth access token", "code": 190}}`, ResponseStatus: 400,
+		SendPrep: setSendURL},
+}
+
+func TestSending(t *testing.T) {
+	// shorter max msg length for testing
+	maxMsgLength = 100
+	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "IGD", "17841400000000000", "", map[string]interface{}{courier.ConfigAuthToken: "access_token"})
+	RunChannelSendTestCases(t, defaultChannel, newHandler(), defaultSendTestCases, nil)
+}
@@ -5,10 +5,12 @@ import (
 	"net/http"
 
 	"github.com/nyaruka/courier"
+	"github.com/sirupsen/logrus"
 )
 
 // ResponseWriter interace with response methods for success responses
 type ResponseWriter interface {
+	Server() courier.Server
 	Backend() courier.Backend
 	WriteStatusSuccessResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, statuses []courier.MsgStatus) error
 	WriteMsgSuccessResponse(ctx context.Context, w http.ResponseWriter, r *http.Request, msgs []courier.Msg) error
@@ -20,16 +22,43 @@ type ResponseWriter interface {
 func WriteMsgsAndResponse(ctx context.Context, h ResponseWriter, msgs []courier.Msg, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
 	events := make([]courier.Event, len(msgs), len(msgs))
 	for i, m := range msgs {
+		TranslateIncomingMsg(ctx, h.Server(), m)
+
 		err := h.Backend().WriteMsg(ctx, m)
 		if err != nil {
 			return nil, err
 		}
+		ExtractAttachmentText(ctx, h.Server(), m)
 		events[i] = m
 	}
 
 	return events, h.WriteMsgSuccessResponse(ctx, w, r, msgs)
 }
 
+// TranslateIncomingMsg adds the translation of the passed in msg to its metadata if its channel wants that, failing
+// to translate is logged and the msg is left untranslated
+func TranslateIncomingMsg(ctx context.Context, s courier.Server, msg courier.Msg) {
+	err := courier.TranslateIncomingMsg(ctx, s.Translator(), msg)
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID().String()).Error("error translating msg")
+	}
+}
+
+// ExtractAttachmentText queues the text of the attachments of the passed in written msg to be extracted into its metadata,
+// from where they're stored, if its channel wants that. Failing to queue that is logged and the msg is left as is.
+func ExtractAttachmentText(ctx context.Context, s courier.Server, msg courier.Msg) {
+	if s.Extractor() == nil {
+		return
+	}
+	task, err := courier.NewAttachmentTextTask(msg)
+	if err == nil && task != nil {
+		err = s.TaskQueue().Enqueue(ctx, task)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID().String()).Error("error extracting attachment text")
+	}
+}
+
 // WriteMsgStatusAndResponse write the passed in status to our backend
 func WriteMsgStatusAndResponse(ctx context.Context, h ResponseWriter, channel courier.Channel, status courier.MsgStatus, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
 	err := h.Backend().WriteMsgStatus(ctx, status)
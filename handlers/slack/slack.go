@@ -18,6 +18,7 @@ import (
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/handlers"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/jsonx"
 	"github.com/nyaruka/gocommon/urns"
 	"github.com/pkg/errors"
 )
@@ -150,6 +151,19 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 		}
 
+		// if this channel handles groups, messages in a slack channel are from their sender within that channel
+		var group *courier.MsgGroup
+		if payload.Event.ChannelType == "channel" && courier.GroupMessagesEnabled(channel) {
+			urn, err = urns.NewURNFromParts(urns.SlackScheme, payload.Event.User, "", "")
+			if err == nil {
+				urn, err = courier.NewGroupURN(urn, payload.Event.Channel)
+			}
+			if err != nil {
+				return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+			}
+			group = &courier.MsgGroup{ID: payload.Event.Channel, Sender: courier.MsgGroupSender{ID: payload.Event.User}}
+		}
+
 		attachmentURLs := make([]string, 0)
 		for _, file := range payload.Event.Files {
 			fileURL := file.URLPrivateDownload
@@ -166,6 +180,9 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 		for _, attURL := range attachmentURLs {
 			msg.WithAttachment(attURL)
 		}
+		if group != nil {
+			msg.WithMetadata(jsonx.MustMarshal(map[string]interface{}{"group": group}))
+		}
 
 		return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 	}
@@ -217,7 +234,7 @@ func sendTextMsgPart(msg courier.Msg, token string) (*courier.ChannelLog, error)
 	sendURL := apiURL + "/chat.postMessage"
 
 	msgPayload := &mtPayload{
-		Channel: msg.URN().Path(),
+		Channel: courier.MsgRecipient(msg),
 		Text:    msg.Text(),
 	}
 
@@ -269,7 +286,7 @@ func parseAttachmentToFileParams(msg courier.Msg, attachment string) (*FileParam
 	return &FileParams{
 		File:     resp.Body,
 		FileName: filename,
-		Channels: msg.URN().Path(),
+		Channels: courier.MsgRecipient(msg),
 	}, log, nil
 }
 
@@ -325,7 +342,7 @@ func sendQuickReplies(msg courier.Msg, botToken string) (*courier.ChannelLog, er
 	sendURL := apiURL + "/chat.postMessage"
 
 	payload := &mtPayload{
-		Channel: msg.URN().Path(),
+		Channel: courier.MsgRecipient(msg),
 		Blocks: []Block{
 			{
 				Type: "section",
@@ -360,6 +360,37 @@ var defaultSendTestCases = []ChannelSendTestCase{
 	},
 }
 
+var testGroupChannels = []courier.Channel{
+	courier.NewMockChannel(channelUUID, "SL", "2022", "US", map[string]interface{}{"bot_token": "xoxb-abc123", "verification_token": "one-long-verification-token", courier.ConfigGroupMessages: true}),
+}
+
+var groupHandleTestCases = []ChannelHandleTestCase{
+	{
+		Label:      "Receive Group Msg",
+		URL:        receiveURL,
+		Headers:    map[string]string{},
+		Data:       helloMsg,
+		URN:        Sp("slack:U0123ABCDEF?group=C0123ABCDEF"),
+		Text:       Sp("Hello World!"),
+		Status:     200,
+		Response:   "Accepted",
+		ExternalID: Sp("Ev0PV52K21"),
+		Metadata:   Jp(map[string]interface{}{"group": map[string]interface{}{"id": "C0123ABCDEF", "sender": map[string]interface{}{"id": "U0123ABCDEF"}}}),
+	},
+}
+
+var groupSendTestCases = []ChannelSendTestCase{
+	{
+		Label: "Send Group Text",
+		Text:  "Hello", URN: "slack:U0123ABCDEF?group=C0123ABCDEF",
+		Status:         "W",
+		ResponseBody:   `{"ok":true,"channel":"C0123ABCDEF"}`,
+		ResponseStatus: 200,
+		RequestBody:    `{"channel":"C0123ABCDEF","text":"Hello"}`,
+		SendPrep:       setSendUrl,
+	},
+}
+
 var fileSendTestCases = []ChannelSendTestCase{
 	{
 		Label: "Send Image",
@@ -385,10 +416,12 @@ func TestHandler(t *testing.T) {
 	defer slackServiceMock.Close()
 
 	RunChannelTestCases(t, testChannels, newHandler(), handleTestCases)
+	RunChannelTestCases(t, testGroupChannels, newHandler(), groupHandleTestCases)
 }
 
 func TestSending(t *testing.T) {
 	RunChannelSendTestCases(t, testChannels[0], newHandler(), defaultSendTestCases, nil)
+	RunChannelSendTestCases(t, testGroupChannels[0], newHandler(), groupSendTestCases, nil)
 }
 
 func TestSendFiles(t *testing.T) {
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/nyaruka/courier"
+	"github.com/nyaruka/courier/tasks"
+)
+
+// EnqueueTask queues follow-up work of a type registered with Server.RegisterTaskFunc for the passed in channel, so
+// that sends can return without waiting for it. Servers without a task queue in Redis run it right away.
+func EnqueueTask(ctx context.Context, s courier.Server, channel courier.Channel, typ string, payload interface{}) error {
+	task, err := tasks.NewTask(typ, channel.UUID().String(), payload)
+	if err != nil {
+		return err
+	}
+	return s.TaskQueue().Enqueue(ctx, task)
+}
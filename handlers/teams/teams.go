@@ -119,7 +119,10 @@ func validateToken(channel courier.Channel, w http.ResponseWriter, r *http.Reque
 		return nil, fmt.Errorf("Could not find public key")
 	}
 
-	token, _ := jwt.Parse(tokenHeader, getKey)
+	token, err := jwt.Parse(tokenHeader, getKey)
+	if token == nil {
+		return fmt.Errorf("Unauthorized. Invalid token: %s", err)
+	}
 
 	// Check allowed signing algorithms
 	alg := token.Header["alg"]
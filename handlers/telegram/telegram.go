@@ -18,7 +18,8 @@ import (
 	"github.com/nyaruka/gocommon/urns"
 )
 
-var apiURL = "https://api.telegram.org"
+// default Bot API endpoint we hit, can be overridden per channel with base_url
+const defaultAPIURL = "https://api.telegram.org"
 
 var defaultParseMode = "MarkdownV2"
 
@@ -28,10 +29,16 @@ func init() {
 
 type handler struct {
 	handlers.BaseHandler
+	apiURL string
 }
 
 func newHandler() courier.ChannelHandler {
-	return &handler{handlers.NewBaseHandler(courier.ChannelType("TG"), "Telegram")}
+	return &handler{handlers.NewBaseHandler(courier.ChannelType("TG"), "Telegram"), defaultAPIURL}
+}
+
+// apiBaseURL returns the Bot API base URL to use for the passed in channel
+func (h *handler) apiBaseURL(channel courier.Channel) string {
+	return strings.TrimSuffix(channel.StringConfigForKey(courier.ConfigBaseURL, h.apiURL), "/")
 }
 
 // Initialize is called by the engine once everything is loaded
@@ -41,6 +48,15 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// ConfigSpec describes the config keys of Telegram channels
+func (h *handler) ConfigSpec() []courier.ConfigField {
+	return []courier.ConfigField{
+		{Key: courier.ConfigAuthToken, Label: "Bot Token", Help: "The token BotFather gave the bot", Required: true, Secret: true},
+		{Key: "parse_mode", Label: "Parse Mode", Help: "How the text of msgs is formatted, defaults to MarkdownV2"},
+		{Key: courier.ConfigBaseURL, Label: "Bot API URL", Help: "The URL of a self-hosted Bot API server"},
+	}
+}
+
 // receiveMessage is our HTTP handler function for incoming messages
 func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
 	payload := &moPayload{}
@@ -49,25 +65,54 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
+	// presses of inline keyboard buttons aren't messages
+	if payload.CallbackQuery != nil {
+		return h.receiveCallbackQuery(ctx, channel, w, r, payload.CallbackQuery)
+	}
+
+	// edited messages are only of interest when they are updates to a live location
+	message := &payload.Message
+	isLiveUpdate := false
+	if message.MessageID == 0 && payload.EditedMessage != nil && payload.EditedMessage.Location != nil && payload.EditedMessage.Location.LivePeriod > 0 {
+		message = payload.EditedMessage
+		isLiveUpdate = true
+	}
+
 	// no message? ignore this
-	if payload.Message.MessageID == 0 {
+	if message.MessageID == 0 {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "Ignoring request, no message")
 	}
 
 	// create our date from the timestamp
-	date := time.Unix(payload.Message.Date, 0).UTC()
+	date := time.Unix(message.Date, 0).UTC()
+	if isLiveUpdate && message.EditDate != 0 {
+		date = time.Unix(message.EditDate, 0).UTC()
+	}
 
 	// create our URN
-	urn, err := urns.NewTelegramURN(payload.Message.From.ContactID, strings.ToLower(payload.Message.From.Username))
+	urn, err := urns.NewTelegramURN(message.From.ContactID, strings.ToLower(message.From.Username))
 	if err != nil {
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
 	// build our name from first and last
-	name := handlers.NameFromFirstLastUsername(payload.Message.From.FirstName, payload.Message.From.LastName, payload.Message.From.Username)
+	name := handlers.NameFromFirstLastUsername(message.From.FirstName, message.From.LastName, message.From.Username)
+
+	// messages in groups are from the sender within that group if this channel handles groups
+	metadata := map[string]interface{}{}
+	if (message.Chat.Type == "group" || message.Chat.Type == "supergroup") && courier.GroupMessagesEnabled(channel) {
+		senderID := strconv.FormatInt(message.From.ContactID, 10)
+		groupID := strconv.FormatInt(message.Chat.ID, 10)
+
+		urn, err = courier.NewGroupURN(urn, groupID)
+		if err != nil {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+		}
+		metadata["group"] = &courier.MsgGroup{ID: groupID, Name: message.Chat.Title, Sender: courier.MsgGroupSender{ID: senderID, Name: name}}
+	}
 
 	// our text is either "text" or "caption" (or empty)
-	text := payload.Message.Text
+	text := message.Text
 
 	// this is a start command, trigger a new conversation
 	if text == "/start" {
@@ -80,42 +125,49 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// normal message of some kind
-	if text == "" && payload.Message.Caption != "" {
-		text = payload.Message.Caption
+	if text == "" && message.Caption != "" {
+		text = message.Caption
 	}
 
 	// deal with attachments
 	mediaURL := ""
-	if len(payload.Message.Photo) > 0 {
+	if len(message.Photo) > 0 {
 		// grab the largest photo less than 100k
-		photo := payload.Message.Photo[0]
-		for i := 1; i < len(payload.Message.Photo); i++ {
-			if payload.Message.Photo[i].FileSize > 100000 {
+		photo := message.Photo[0]
+		for i := 1; i < len(message.Photo); i++ {
+			if message.Photo[i].FileSize > 100000 {
 				break
 			}
-			photo = payload.Message.Photo[i]
+			photo = message.Photo[i]
 		}
 		mediaURL, err = h.resolveFileID(ctx, channel, photo.FileID)
-	} else if payload.Message.Video != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Video.FileID)
-	} else if payload.Message.Voice != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Voice.FileID)
-	} else if payload.Message.Sticker != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Sticker.Thumb.FileID)
-	} else if payload.Message.Document != nil {
-		mediaURL, err = h.resolveFileID(ctx, channel, payload.Message.Document.FileID)
-	} else if payload.Message.Venue != nil {
-		text = utils.JoinNonEmpty(", ", payload.Message.Venue.Title, payload.Message.Venue.Address)
-		mediaURL = fmt.Sprintf("geo:%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-	} else if payload.Message.Location != nil {
-		text = fmt.Sprintf("%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-		mediaURL = fmt.Sprintf("geo:%f,%f", payload.Message.Location.Latitude, payload.Message.Location.Longitude)
-	} else if payload.Message.Contact != nil {
+	} else if message.Video != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Video.FileID)
+	} else if message.Voice != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Voice.FileID)
+	} else if message.Sticker != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Sticker.Thumb.FileID)
+	} else if message.Document != nil {
+		mediaURL, err = h.resolveFileID(ctx, channel, message.Document.FileID)
+	} else if message.Venue != nil {
+		text = utils.JoinNonEmpty(", ", message.Venue.Title, message.Venue.Address)
+		mediaURL = fmt.Sprintf("geo:%f,%f", message.Location.Latitude, message.Location.Longitude)
+	} else if message.Location != nil {
+		text = fmt.Sprintf("%f,%f", message.Location.Latitude, message.Location.Longitude)
+		mediaURL = fmt.Sprintf("geo:%f,%f", message.Location.Latitude, message.Location.Longitude)
+
+		if message.Location.LivePeriod > 0 {
+			metadata["live_location"], err = handlers.LiveLocationMetadata(h.Backend().RedisPool(), channel, fmt.Sprintf("%d", message.MessageID), message.Location.LivePeriod, isLiveUpdate)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if message.Contact != nil {
 		phone := ""
-		if payload.Message.Contact.PhoneNumber != "" {
-			phone = fmt.Sprintf("(%s)", payload.Message.Contact.PhoneNumber)
+		if message.Contact.PhoneNumber != "" {
+			phone = fmt.Sprintf("(%s)", message.Contact.PhoneNumber)
 		}
-		text = utils.JoinNonEmpty(" ", payload.Message.Contact.FirstName, payload.Message.Contact.LastName, phone)
+		text = utils.JoinNonEmpty(" ", message.Contact.FirstName, message.Contact.LastName, phone)
 	}
 
 	// we had an error downloading media
@@ -124,15 +176,77 @@ func (h *handler) receiveMessage(ctx context.Context, channel courier.Channel, w
 	}
 
 	// build our msg
-	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date).WithExternalID(fmt.Sprintf("%d", payload.Message.MessageID)).WithContactName(name)
+	msg := h.Backend().NewIncomingMsg(channel, urn, text).WithReceivedOn(date).WithExternalID(fmt.Sprintf("%d", message.MessageID)).WithContactName(name)
 
 	if mediaURL != "" {
 		msg.WithAttachment(mediaURL)
 	}
+	if len(metadata) > 0 {
+		msg.WithMetadata(jsonx.MustMarshal(metadata))
+	}
 	// and finally write our message
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 }
 
+// receiveCallbackQuery writes a button callback event for the press of an inline keyboard button, answering the query so
+// the contact's client stops showing its progress
+func (h *handler) receiveCallbackQuery(ctx context.Context, channel courier.Channel, w http.ResponseWriter, r *http.Request, query *moCallbackQuery) ([]courier.Event, error) {
+	urn, err := urns.NewTelegramURN(query.From.ContactID, strings.ToLower(query.From.Username))
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+	name := handlers.NameFromFirstLastUsername(query.From.FirstName, query.From.LastName, query.From.Username)
+
+	extra := map[string]interface{}{"callback_id": query.ID, "data": query.Data}
+
+	// the msg the button was on, which flows can edit with its id
+	if query.Message != nil && query.Message.MessageID != 0 {
+		extra["message_id"] = strconv.FormatInt(query.Message.MessageID, 10)
+
+		if (query.Message.Chat.Type == "group" || query.Message.Chat.Type == "supergroup") && courier.GroupMessagesEnabled(channel) {
+			urn, err = courier.NewGroupURN(urn, strconv.FormatInt(query.Message.Chat.ID, 10))
+			if err != nil {
+				return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+			}
+		}
+	}
+
+	event := h.Backend().NewChannelEvent(channel, courier.ButtonCallback, urn).WithContactName(name).WithOccurredOn(time.Now().UTC()).WithExtra(extra)
+	err = h.Backend().WriteChannelEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	h.answerCallbackQuery(ctx, channel, query.ID)
+
+	return []courier.Event{event}, courier.WriteChannelEventSuccess(ctx, w, r, event)
+}
+
+// answerCallbackQuery tells Telegram we've received the passed in callback query. The press is recorded either way, so
+// failures are only logged.
+func (h *handler) answerCallbackQuery(ctx context.Context, channel courier.Channel, queryID string) {
+	authToken := channel.StringConfigForKey(courier.ConfigAuthToken, "")
+	if authToken == "" {
+		return
+	}
+
+	form := url.Values{}
+	form.Set("callback_query_id", queryID)
+
+	answerURL := fmt.Sprintf("%s/bot%s/answerCallbackQuery", h.apiBaseURL(channel), authToken)
+	req, err := http.NewRequest(http.MethodPost, answerURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	rr, err := utils.MakeHTTPRequest(req)
+	if err != nil {
+		log := courier.NewChannelLogFromRR("Callback Query Answered", channel, courier.NilMsgID, rr).WithError("Callback Query Answer Error", err)
+		h.Backend().WriteChannelLogs(ctx, []*courier.ChannelLog{log})
+	}
+}
+
 func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form url.Values, keyboard *ReplyKeyboardMarkup) (string, *courier.ChannelLog, error) {
 	// either include or remove our keyboard
 	if keyboard == nil {
@@ -141,7 +255,7 @@ func (h *handler) sendMsgPart(msg courier.Msg, token string, path string, form u
 		form.Add("reply_markup", string(jsonx.MustMarshal(keyboard)))
 	}
 
-	sendURL := fmt.Sprintf("%s/bot%s/%s", apiURL, token, path)
+	sendURL := fmt.Sprintf("%s/bot%s/%s", h.apiBaseURL(msg.Channel()), token, path)
 	req, err := http.NewRequest(http.MethodPost, sendURL, strings.NewReader(form.Encode()))
 	if err != nil {
 		return "", nil, err
@@ -203,7 +317,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		}
 
 		form := url.Values{
-			"chat_id": []string{msg.URN().Path()},
+			"chat_id": []string{courier.MsgRecipient(msg)},
 			"text":    []string{msg.Text()},
 		}
 
@@ -230,7 +344,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		switch strings.Split(mediaType, "/")[0] {
 		case "image":
 			form := url.Values{
-				"chat_id": []string{msg.URN().Path()},
+				"chat_id": []string{courier.MsgRecipient(msg)},
 				"photo":   []string{mediaURL},
 				"caption": []string{caption},
 			}
@@ -241,7 +355,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		case "video":
 			form := url.Values{
-				"chat_id": []string{msg.URN().Path()},
+				"chat_id": []string{courier.MsgRecipient(msg)},
 				"video":   []string{mediaURL},
 				"caption": []string{caption},
 			}
@@ -252,7 +366,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		case "audio":
 			form := url.Values{
-				"chat_id": []string{msg.URN().Path()},
+				"chat_id": []string{courier.MsgRecipient(msg)},
 				"audio":   []string{mediaURL},
 				"caption": []string{caption},
 			}
@@ -263,7 +377,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		case "application":
 			form := url.Values{
-				"chat_id":  []string{msg.URN().Path()},
+				"chat_id":  []string{courier.MsgRecipient(msg)},
 				"document": []string{mediaURL},
 				"caption":  []string{caption},
 			}
@@ -283,11 +397,25 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 	if !hasError {
 		status.SetStatus(courier.MsgWired)
+	} else if logs := status.Logs(); len(logs) > 0 {
+		status.SetErrorClass(sendErrorClass(logs[len(logs)-1]))
 	}
 
 	return status, nil
 }
 
+// sendErrorClass returns the class of the error of the passed in failed send, Telegram telling us with a 403 that the
+// contact blocked our bot rather than that our token is bad, which is a 401
+func sendErrorClass(log *courier.ChannelLog) courier.ErrorClass {
+	switch {
+	case log.StatusCode == http.StatusForbidden || strings.Contains(log.Response, "chat not found"):
+		return courier.ErrorClassInvalidRecipient
+	case log.StatusCode == http.StatusBadRequest:
+		return courier.ErrorClassContentRejected
+	}
+	return courier.NilErrorClass
+}
+
 func (h *handler) resolveFileID(ctx context.Context, channel courier.Channel, fileID string) (string, error) {
 	confAuth := channel.ConfigForKey(courier.ConfigAuthToken, "")
 	authToken, isStr := confAuth.(string)
@@ -295,7 +423,7 @@ func (h *handler) resolveFileID(ctx context.Context, channel courier.Channel, fi
 		return "", fmt.Errorf("invalid auth token config")
 	}
 
-	fileURL := fmt.Sprintf("%s/bot%s/getFile", apiURL, authToken)
+	fileURL := fmt.Sprintf("%s/bot%s/getFile", h.apiBaseURL(channel), authToken)
 
 	form := url.Values{}
 	form.Set("file_id", fileID)
@@ -331,7 +459,7 @@ func (h *handler) resolveFileID(ctx context.Context, channel courier.Channel, fi
 	}
 
 	// return the URL
-	return fmt.Sprintf("%s/file/bot%s/%s", apiURL, authToken, filePath), nil
+	return fmt.Sprintf("%s/file/bot%s/%s", h.apiBaseURL(channel), authToken, filePath), nil
 }
 
 type moFile struct {
@@ -340,8 +468,9 @@ type moFile struct {
 }
 
 type moLocation struct {
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	LivePeriod int     `json:"live_period"`
 }
 
 //	{
@@ -365,35 +494,55 @@ type moLocation struct {
 //		 }
 //	}
 type moPayload struct {
-	UpdateID int64 `json:"update_id" validate:"required"`
-	Message  struct {
-		MessageID int64 `json:"message_id"`
-		From      struct {
-			ContactID int64  `json:"id"`
-			FirstName string `json:"first_name"`
-			LastName  string `json:"last_name"`
-			Username  string `json:"username"`
-		} `json:"from"`
-		Date    int64  `json:"date"`
-		Text    string `json:"text"`
-		Caption string `json:"caption"`
-		Sticker *struct {
-			Thumb moFile `json:"thumb"`
-		} `json:"sticker"`
-		Photo    []moFile    `json:"photo"`
-		Video    *moFile     `json:"video"`
-		Voice    *moFile     `json:"voice"`
-		Document *moFile     `json:"document"`
+	UpdateID      int64            `json:"update_id" validate:"required"`
+	Message       moMessage        `json:"message"`
+	EditedMessage *moMessage       `json:"edited_message"`
+	CallbackQuery *moCallbackQuery `json:"callback_query"`
+}
+
+type moUser struct {
+	ContactID int64  `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+}
+
+// moCallbackQuery is the press of an inline keyboard button, see https://core.telegram.org/bots/api#callbackquery
+type moCallbackQuery struct {
+	ID      string     `json:"id"`
+	From    moUser     `json:"from"`
+	Message *moMessage `json:"message"`
+	Data    string     `json:"data"`
+}
+
+type moMessage struct {
+	MessageID int64  `json:"message_id"`
+	From      moUser `json:"from"`
+	Chat      struct {
+		ID    int64  `json:"id"`
+		Type  string `json:"type"`
+		Title string `json:"title"`
+	} `json:"chat"`
+	Date     int64  `json:"date"`
+	EditDate int64  `json:"edit_date"`
+	Text     string `json:"text"`
+	Caption  string `json:"caption"`
+	Sticker  *struct {
+		Thumb moFile `json:"thumb"`
+	} `json:"sticker"`
+	Photo    []moFile    `json:"photo"`
+	Video    *moFile     `json:"video"`
+	Voice    *moFile     `json:"voice"`
+	Document *moFile     `json:"document"`
+	Location *moLocation `json:"location"`
+	Venue    *struct {
 		Location *moLocation `json:"location"`
-		Venue    *struct {
-			Location *moLocation `json:"location"`
-			Title    string      `json:"title"`
-			Address  string      `json:"address"`
-		}
-		Contact *struct {
-			PhoneNumber string `json:"phone_number"`
-			FirstName   string `json:"first_name"`
-			LastName    string `json:"last_name"`
-		}
-	} `json:"message"`
+		Title    string      `json:"title"`
+		Address  string      `json:"address"`
+	}
+	Contact *struct {
+		PhoneNumber string `json:"phone_number"`
+		FirstName   string `json:"first_name"`
+		LastName    string `json:"last_name"`
+	}
 }
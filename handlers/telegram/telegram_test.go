@@ -10,6 +10,7 @@ import (
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
+	"github.com/stretchr/testify/assert"
 )
 
 var testChannels = []courier.Channel{
@@ -389,6 +390,78 @@ var locationMsg = `
     }
 }`
 
+var liveLocationMsg = `
+{
+    "update_id": 900946535,
+    "message": {
+        "message_id": 97,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier",
+            "type": "private"
+        },
+        "date": 1493845244,
+        "location": {
+            "latitude": -2.890287,
+            "longitude": -79.004333,
+            "live_period": 900
+        }
+    }
+}`
+
+var liveLocationUpdateMsg = `
+{
+    "update_id": 900946536,
+    "edited_message": {
+        "message_id": 97,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier"
+        },
+        "chat": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier",
+            "type": "private"
+        },
+        "date": 1493845244,
+        "edit_date": %d,
+        "location": {
+            "latitude": %f,
+            "longitude": -79.004333,
+            "live_period": 900
+        }
+    }
+}`
+
+var editedTextMsg = `
+{
+    "update_id": 900946537,
+    "edited_message": {
+        "message_id": 41,
+        "from": {
+            "id": 3527065,
+            "first_name": "Nic",
+            "last_name": "Pottier",
+            "username": "Nicpottier"
+        },
+        "date": 1454119029,
+        "edit_date": 1454119089,
+        "text": "Hello World, edited"
+    }
+}`
+
 var venueMsg = `
 {
     "update_id": 900946535,
@@ -450,6 +523,38 @@ var contactMsg = `
     }
 }`
 
+var callbackQueryMsg = `
+{
+	"update_id": 174114372,
+	"callback_query": {
+		"id": "4382bfdwdsb323b2d9",
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"message": {
+			"message_id": 133,
+			"from": {
+				"id": 2020,
+				"first_name": "Bot"
+			},
+			"chat": {
+				"id": 3527065,
+				"type": "private"
+			},
+			"date": 1454119029,
+			"text": "Do you like it?"
+		},
+		"chat_instance": "-5463425134562343",
+		"data": "yes"
+	}
+}`
+
+// the ids of the callback queries our mock Telegram service was asked to answer
+var answeredCallbackQueries []string
+
 var testCases = []ChannelHandleTestCase{
 	{Label: "Receive Valid Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: helloMsg, Status: 200, Response: "Accepted",
 		Name: Sp("Nic Pottier"), Text: Sp("Hello World"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
@@ -457,6 +562,10 @@ var testCases = []ChannelHandleTestCase{
 	{Label: "Receive Start Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: startMsg, Status: 200, Response: "Accepted",
 		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.NewConversation)), URN: Sp("telegram:3527065#nicpottier"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
 
+	{Label: "Receive Callback Query", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: callbackQueryMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), ChannelEvent: Sp(string(courier.ButtonCallback)), URN: Sp("telegram:3527065#nicpottier"),
+		ChannelEventExtra: map[string]interface{}{"callback_id": "4382bfdwdsb323b2d9", "data": "yes", "message_id": "133"}},
+
 	{Label: "Receive No Params", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: emptyMsg, Status: 200, Response: "Ignoring"},
 
 	{Label: "Receive Invalid JSON", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: "foo", Status: 400, Response: "unable to parse"},
@@ -479,6 +588,17 @@ var testCases = []ChannelHandleTestCase{
 	{Label: "Receive Location", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: locationMsg, Status: 200, Response: "Accepted",
 		Name: Sp("Nic Pottier"), Text: Sp("-2.890287,-79.004333"), Attachment: Sp("geo:-2.890287,-79.004333"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("94"), Date: Tp(time.Date(2017, 5, 3, 21, 00, 44, 0, time.UTC))},
 
+	{Label: "Receive Live Location", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: liveLocationMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), Text: Sp("-2.890287,-79.004333"), Attachment: Sp("geo:-2.890287,-79.004333"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("97"), Date: Tp(time.Date(2017, 5, 3, 21, 00, 44, 0, time.UTC)),
+		Metadata: Jp(map[string]interface{}{"live_location": map[string]interface{}{"message_id": "97", "sequence": 0, "live_period": 900}})},
+	{Label: "Receive Live Location Update", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: fmt.Sprintf(liveLocationUpdateMsg, 1493845304, -2.891), Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), Text: Sp("-2.891000,-79.004333"), Attachment: Sp("geo:-2.891000,-79.004333"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("97"), Date: Tp(time.Date(2017, 5, 3, 21, 01, 44, 0, time.UTC)),
+		Metadata: Jp(map[string]interface{}{"live_location": map[string]interface{}{"message_id": "97", "sequence": 1, "live_period": 900}})},
+	{Label: "Receive Second Live Location Update", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: fmt.Sprintf(liveLocationUpdateMsg, 1493845364, -2.892), Status: 200, Response: "Accepted",
+		Text: Sp("-2.892000,-79.004333"), Attachment: Sp("geo:-2.892000,-79.004333"), Date: Tp(time.Date(2017, 5, 3, 21, 02, 44, 0, time.UTC)),
+		Metadata: Jp(map[string]interface{}{"live_location": map[string]interface{}{"message_id": "97", "sequence": 2, "live_period": 900}})},
+	{Label: "Ignore Edited Text Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: editedTextMsg, Status: 200, Response: "Ignoring"},
+
 	{Label: "Receive Venue", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: venueMsg, Status: 200, Response: "Accepted",
 		Name: Sp("Nic Pottier"), Text: Sp("Cuenca, Provincia del Azuay"), Attachment: Sp("geo:-2.898944,-79.006835"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("95"), Date: Tp(time.Date(2017, 5, 3, 21, 05, 20, 0, time.UTC))},
 
@@ -498,9 +618,16 @@ var testCases = []ChannelHandleTestCase{
 
 func buildMockTelegramService(testCases []ChannelHandleTestCase) *httptest.Server {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fileID := r.FormValue("file_id")
 		defer r.Body.Close()
 
+		if strings.HasSuffix(r.URL.Path, "/answerCallbackQuery") {
+			answeredCallbackQueries = append(answeredCallbackQueries, r.FormValue("callback_query_id"))
+			w.Write([]byte(`{ "ok": true, "result": true }`))
+			return
+		}
+
+		fileID := r.FormValue("file_id")
+
 		filePath := ""
 
 		switch fileID {
@@ -532,35 +659,77 @@ func buildMockTelegramService(testCases []ChannelHandleTestCase) *httptest.Serve
 		w.Write([]byte(fmt.Sprintf(`{ "ok": true, "result": { "file_path": "%s" } }`, filePath)))
 	}))
 
-	apiURL = server.URL
-
 	// update our tests media urls
 	for c := range testCases {
 		if testCases[c].Attachment != nil && !strings.HasPrefix(*testCases[c].Attachment, "geo") {
-			testCases[c].Attachment = Sp(fmt.Sprintf("%s%s", apiURL, *testCases[c].Attachment))
+			testCases[c].Attachment = Sp(fmt.Sprintf("%s%s", server.URL, *testCases[c].Attachment))
 		}
 	}
 
 	return server
 }
 
+var groupMsg = `
+{
+	"update_id": 174114371,
+	"message": {
+		"message_id": 42,
+		"from": {
+			"id": 3527065,
+			"first_name": "Nic",
+			"last_name": "Pottier",
+			"username": "nicpottier"
+		},
+		"chat": {
+			"id": -1001234,
+			"title": "Field Team",
+			"type": "supergroup"
+		},
+		"date": 1454119029,
+		"text": "Hello Team"
+	}
+}`
+
+var testGroupChannels = []courier.Channel{
+	courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "TG", "2020", "US", map[string]interface{}{"auth_token": "a123", courier.ConfigGroupMessages: true}),
+}
+
+var groupTestCases = []ChannelHandleTestCase{
+	{Label: "Receive Group Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: groupMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), Text: Sp("Hello Team"), URN: Sp("telegram:3527065?group=-1001234#nicpottier"), ExternalID: Sp("42"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC)),
+		Metadata: Jp(map[string]interface{}{"group": map[string]interface{}{"id": "-1001234", "name": "Field Team", "sender": map[string]interface{}{"id": "3527065", "name": "Nic Pottier"}}})},
+	{Label: "Receive Private Message", URL: "/c/tg/8eb23e93-5ecb-45ba-b726-3b064e0c568c/receive/", Data: helloMsg, Status: 200, Response: "Accepted",
+		Name: Sp("Nic Pottier"), Text: Sp("Hello World"), URN: Sp("telegram:3527065#nicpottier"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
+}
+
 func TestHandler(t *testing.T) {
 	telegramService := buildMockTelegramService(testCases)
 	defer telegramService.Close()
 
-	RunChannelTestCases(t, testChannels, newHandler(), testCases)
+	RunChannelTestCases(t, testChannels, newAPIHandler(telegramService.URL), testCases)
+	RunChannelTestCases(t, testGroupChannels, newAPIHandler(telegramService.URL), groupTestCases)
+
+	// button presses are answered so the contact's client stops waiting
+	assert.Contains(t, answeredCallbackQueries, "4382bfdwdsb323b2d9")
 }
 
 func BenchmarkHandler(b *testing.B) {
 	telegramService := buildMockTelegramService(testCases)
 	defer telegramService.Close()
 
-	RunChannelBenchmarks(b, testChannels, newHandler(), testCases)
+	RunChannelBenchmarks(b, testChannels, newAPIHandler(telegramService.URL), testCases)
+}
+
+// newAPIHandler returns a handler which makes its Bot API calls against the passed in URL
+func newAPIHandler(apiURL string) courier.ChannelHandler {
+	h := newHandler().(*handler)
+	h.apiURL = apiURL
+	return h
 }
 
 // setSendURL takes care of setting the send_url to our test server host
 func setSendURL(s *httptest.Server, h courier.ChannelHandler, c courier.Channel, m courier.Msg) {
-	apiURL = s.URL
+	h.(*handler).apiURL = s.URL
 }
 
 var defaultSendTestCases = []ChannelSendTestCase{
@@ -613,10 +782,22 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		SendPrep:   setSendURL},
 	{Label: "Error",
 		Text: "Error", URN: "telegram:12345",
-		Status:       "E",
+		Status: "E", ErrorClass: "invalid_recipient",
 		ResponseBody: `{ "ok": false }`, ResponseStatus: 403,
 		PostParams: map[string]string{"text": `Error`, "chat_id": "12345"},
 		SendPrep:   setSendURL},
+	{Label: "Chat Not Found",
+		Text: "Error", URN: "telegram:12345",
+		Status: "E", ErrorClass: "invalid_recipient",
+		ResponseBody: `{ "ok": false, "error_code": 400, "description": "Bad Request: chat not found" }`, ResponseStatus: 400,
+		PostParams: map[string]string{"text": `Error`, "chat_id": "12345"},
+		SendPrep:   setSendURL},
+	{Label: "Bad Request",
+		Text: "Error", URN: "telegram:12345",
+		Status: "E", ErrorClass: "content_rejected",
+		ResponseBody: `{ "ok": false, "error_code": 400, "description": "Bad Request: can't parse entities" }`, ResponseStatus: 400,
+		PostParams: map[string]string{"text": `Error`, "chat_id": "12345"},
+		SendPrep:   setSendURL},
 	{Label: "Send Photo",
 		Text: "My pic!", URN: "telegram:12345", Attachments: []string{"image/jpeg:https://foo.bar/image.jpg"},
 		Status:       "W",
@@ -647,6 +828,19 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		SendPrep: setSendURL},
 }
 
+var groupSendTestCases = []ChannelSendTestCase{
+	{Label: "Group Send",
+		Text: "Simple Message", URN: "telegram:12345?group=-1001234",
+		Status: "W", ExternalID: "133",
+		ResponseBody: `{ "ok": true, "result": { "message_id": 133 } }`, ResponseStatus: 200,
+		PostParams: map[string]string{
+			"text":         "Simple Message",
+			"chat_id":      "-1001234",
+			"reply_markup": `{"remove_keyboard":true}`,
+		},
+		SendPrep: setSendURL},
+}
+
 // https://core.telegram.org/bots/api#formatting-options
 var parseModeTestCases = []ChannelSendTestCase{
 	{Label: "Parse Mode MarkdownV2",
@@ -672,4 +866,19 @@ func TestSending(t *testing.T) {
 		map[string]interface{}{courier.ConfigAuthToken: "auth_token", "parse_mode": "MarkdownV2"})
 
 	RunChannelSendTestCases(t, parseModeChannel, newHandler(), parseModeTestCases, nil)
+
+	var groupChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "2020", "US",
+		map[string]interface{}{courier.ConfigAuthToken: "auth_token", courier.ConfigGroupMessages: true})
+
+	RunChannelSendTestCases(t, groupChannel, newHandler(), groupSendTestCases, nil)
+}
+
+func TestAPIBaseURL(t *testing.T) {
+	h := newHandler().(*handler)
+
+	prod := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "TG", "2020", "US", map[string]interface{}{"auth_token": "a123"})
+	local := courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ac", "TG", "2020", "US", map[string]interface{}{"auth_token": "a123", courier.ConfigBaseURL: "http://localhost:8081/"})
+
+	assert.Equal(t, "https://api.telegram.org", h.apiBaseURL(prod))
+	assert.Equal(t, "http://localhost:8081", h.apiBaseURL(local))
 }
@@ -102,9 +102,11 @@ type ChannelSendTestCase struct {
 	RequestBody string
 	Headers     map[string]string
 
-	Error      string
-	Status     string
-	ExternalID string
+	Error                string
+	Status               string
+	ErrorClass           string
+	ExternalID           string
+	SecondaryExternalIDs []string
 
 	Stopped bool
 
@@ -210,9 +212,14 @@ func newServer(backend courier.Backend) courier.Server {
 	config := courier.NewConfig()
 	config.FacebookWebhookSecret = "fb_webhook_secret"
 	config.FacebookApplicationSecret = "fb_app_secret"
+	config.InstagramWebhookSecret = "ig_webhook_secret"
 	config.WhatsappCloudWebhookSecret = "wac_webhook_secret"
+	config.WhatsappCloudApplicationID = "wac_app_id"
 	config.WhatsappCloudApplicationSecret = "wac_app_secret"
+	config.WhatsappCloudOnboardSecret = "wac_onboard_secret"
 	config.WhatsappAdminSystemUserToken = "wac_admin_system_user_token"
+	config.StatusUsername = "admin"
+	config.StatusPassword = "sesame"
 
 	return courier.NewServerWithLogger(config, backend, logger)
 
@@ -331,11 +338,20 @@ func RunChannelSendTestCases(t *testing.T, channel courier.Channel, handler cour
 				require.Equal(testCase.ExternalID, status.ExternalID())
 			}
 
+			if testCase.SecondaryExternalIDs != nil {
+				require.Equal(testCase.SecondaryExternalIDs, courier.SecondaryExternalIDs(status))
+			}
+
 			if testCase.Status != "" {
 				require.NotNil(status, "status should not be nil")
 				require.Equal(testCase.Status, string(status.Status()))
 			}
 
+			if testCase.ErrorClass != "" {
+				require.NotNil(status, "status should not be nil")
+				require.Equal(testCase.ErrorClass, string(status.ErrorClass()))
+			}
+
 			if testCase.Stopped {
 				evt, err := mb.GetLastChannelEvent()
 				require.NoError(err)
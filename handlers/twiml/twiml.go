@@ -46,6 +46,25 @@ var (
 // error code twilio returns when a contact has sent "stop"
 const errorStopped = 21610
 
+// the classes of the Twilio error codes we know, of sends and of the statuses of msgs which then failed, see
+// https://www.twilio.com/docs/api/errors
+var errorClasses = map[int64]courier.ErrorClass{
+	14107: courier.ErrorClassRateLimit,        // send rate limit exceeded
+	20003: courier.ErrorClassAuth,             // authentication error
+	20429: courier.ErrorClassRateLimit,        // too many requests
+	21211: courier.ErrorClassInvalidRecipient, // invalid 'To' phone number
+	21408: courier.ErrorClassInvalidRecipient, // permission to send to region not enabled
+	21610: courier.ErrorClassInvalidRecipient, // recipient unsubscribed
+	21612: courier.ErrorClassInvalidRecipient, // 'To' number not reachable
+	21614: courier.ErrorClassInvalidRecipient, // 'To' number not a mobile number
+	21617: courier.ErrorClassContentRejected,  // body exceeds 1600 characters
+	30003: courier.ErrorClassInvalidRecipient, // unreachable handset
+	30004: courier.ErrorClassInvalidRecipient, // message blocked by recipient
+	30005: courier.ErrorClassInvalidRecipient, // unknown destination handset
+	30006: courier.ErrorClassInvalidRecipient, // landline or unreachable carrier
+	30007: courier.ErrorClassContentRejected,  // filtered by carrier
+}
+
 type handler struct {
 	handlers.BaseHandler
 	validateSignatures bool
@@ -189,6 +208,11 @@ func (h *handler) receiveStatus(ctx context.Context, channel courier.Channel, w
 	if status == nil {
 		status = h.Backend().NewMsgStatusForExternalID(channel, form.MessageSID, msgStatus)
 	}
+
+	if msgStatus == courier.MsgFailed && form.ErrorCode != "" {
+		errorCode, _ := strconv.ParseInt(form.ErrorCode, 10, 64)
+		status.SetErrorClass(errorClasses[errorCode])
+	}
 	return handlers.WriteMsgStatusAndResponse(ctx, h, channel, status, w, r)
 }
 
@@ -269,6 +293,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		if err != nil && rr.Body != nil {
 			errorCode, _ := jsonparser.GetInt([]byte(rr.Body), "code")
 			if errorCode != 0 {
+				status.SetErrorClass(errorClasses[errorCode])
 				if errorCode == errorStopped {
 					status.SetStatus(courier.MsgFailed)
 
@@ -298,10 +323,8 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 
 		status.SetStatus(courier.MsgWired)
 
-		// only save the first external id
-		if i == 0 {
-			status.SetExternalID(externalID)
-		}
+		// record this part, the first external id becoming the external id of the msg
+		status.AddPart(externalID, courier.MsgWired)
 	}
 
 	return status, nil
@@ -62,6 +62,8 @@ var (
 	statusInvalid = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=huh"
 	statusValid   = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=delivered"
 	statusRead    = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=read"
+	statusFailed  = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=undelivered&ErrorCode=30005"
+	statusRegion  = "MessageSid=SMe287d7109a5a925f182f0e07fe5b223b&MessageStatus=failed&ErrorCode=21408"
 
 	tmsStatusExtra  = "SmsStatus=sent&MessageStatus=sent&To=2021&MessagingServiceSid=MGdb23ec0f89ee2632e46e91d8128f5e2b&MessageSid=SM0b6e2697aae04182a9f5b5c7a8994c7f&AccountSid=acctid&From=%2B14133881111&ApiVersion=2010-04-01"
 	tmsReceiveExtra = "ToCountry=US&ToState=&SmsMessageSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&NumMedia=0&ToCity=&FromZip=27609&SmsSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&FromState=NC&SmsStatus=received&FromCity=RALEIGH&Body=John+Cruz&FromCountry=US&To=384387&ToZip=&NumSegments=1&MessageSid=SMbbf29aeb9d380ce2a1c0ae4635ff9dab&AccountSid=acctid&From=%2B14133881111&ApiVersion=2010-04-01"
@@ -98,6 +100,10 @@ var testCases = []ChannelHandleTestCase{
 		PrepRequest: addValidSignature},
 	{Label: "Status ID Valid", URL: statusIDURL, Data: statusValid, Status: 200, Response: `"status":"D"`, ID: 12345,
 		PrepRequest: addValidSignature},
+	{Label: "Status ID Failed", URL: statusIDURL, Data: statusFailed, Status: 200, Response: `"status":"F","msg_id":12345,"error_class":"invalid_recipient"`, ID: 12345,
+		PrepRequest: addValidSignature},
+	{Label: "Status ID Region Not Enabled", URL: statusIDURL, Data: statusRegion, Status: 200, Response: `"status":"F","msg_id":12345,"error_class":"invalid_recipient"`, ID: 12345,
+		PrepRequest: addValidSignature},
 	{Label: "Status ID Invalid", URL: statusInvalidIDURL, Data: statusValid, Status: 200, Response: `"status":"D"`, ExternalID: Sp("SMe287d7109a5a925f182f0e07fe5b223b"),
 		PrepRequest: addValidSignature},
 }
@@ -303,7 +309,7 @@ var defaultSendTestCases = []ChannelSendTestCase{
 		SendPrep:   setSendURL},
 	{Label: "Stopped Contact Code",
 		Text: "Stopped Contact", URN: "tel:+250788383383",
-		Status:       "F",
+		Status: "F", ErrorClass: "invalid_recipient",
 		ResponseBody: `{ "code": 21610 }`, ResponseStatus: 400,
 		PostParams: map[string]string{"Body": "Stopped Contact", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/t/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
 		SendPrep:   setSendURL,
@@ -354,7 +360,7 @@ var tmsDefaultSendTestCases = []ChannelSendTestCase{
 		SendPrep:   setSendURL},
 	{Label: "Stopped Contact Code",
 		Text: "Stopped Contact", URN: "tel:+250788383383",
-		Status:       "F",
+		Status: "F", ErrorClass: "invalid_recipient",
 		ResponseBody: `{ "code": 21610 }`, ResponseStatus: 400,
 		PostParams: map[string]string{"Body": "Stopped Contact", "To": "+250788383383", "MessagingServiceSid": "messageServiceSID", "StatusCallback": "https://localhost/c/tms/8eb23e93-5ecb-45ba-b726-3b064e0c56cd/status?id=10&action=callback"},
 		SendPrep:   setSendURL,
@@ -405,7 +411,7 @@ var twDefaultSendTestCases = []ChannelSendTestCase{
 		SendPrep:   setSendURL},
 	{Label: "Stopped Contact Code",
 		Text: "Stopped Contact", URN: "tel:+250788383383",
-		Status:       "F",
+		Status: "F", ErrorClass: "invalid_recipient",
 		ResponseBody: `{ "code": 21610 }`, ResponseStatus: 400,
 		PostParams: map[string]string{"Body": "Stopped Contact", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/tw/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
 		SendPrep:   setSendURL,
@@ -456,7 +462,7 @@ var swSendTestCases = []ChannelSendTestCase{
 		SendPrep:   setSendURL},
 	{Label: "Stopped Contact Code",
 		Text: "Stopped Contact", URN: "tel:+250788383383",
-		Status:       "F",
+		Status: "F", ErrorClass: "invalid_recipient",
 		ResponseBody: `{ "code": 21610 }`, ResponseStatus: 400,
 		PostParams: map[string]string{"Body": "Stopped Contact", "To": "+250788383383", "From": "2020", "StatusCallback": "https://localhost/c/sw/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/status?id=10&action=callback"},
 		SendPrep:   setSendURL,
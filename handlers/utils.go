@@ -137,7 +137,7 @@ func StrictTelForCountry(number string, country string) (urns.URN, error) {
 	// finally if our original number started with a plus and is the same as our new number, use that
 	// as our URN. This deals with the case where a carrier is handing us an E164 number that
 	// the phonenumbers library doesn't know about yet
-	if fmt.Sprintf("+%s", urn.Path()) == number && len(number) > 7 {
+	if !strings.HasPrefix(urn.Path(), "+") && fmt.Sprintf("+%s", urn.Path()) == number && len(number) > 7 {
 		urn = urns.URN(urns.TelScheme + ":" + number)
 	}
 
@@ -8,10 +8,20 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/webhooks"
 )
 
-func SendWebhooksExternal(r *http.Request, configWebhook interface{}) error {
+// SendWebhooksExternal forwards the body of the passed in request to the webhook of a channel's config. If that webhook
+// has filters, it is only forwarded when one of the passed in event types is in them, and if it has a secret, the body
+// is signed with it so the receiver can check it came from us. Servers with a webhook relay retry failed forwards.
+func SendWebhooksExternal(s courier.Server, channel courier.Channel, r *http.Request, configWebhook interface{}, eventTypes ...string) error {
+	// forwarding webhooks is the first thing we stop doing when overloaded
+	if courier.ShedLoad("webhook_forward") {
+		return nil
+	}
+
 	webhook, ok := configWebhook.(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("conversion error")
@@ -27,25 +37,51 @@ func SendWebhooksExternal(r *http.Request, configWebhook interface{}) error {
 		return fmt.Errorf("invalid url %s", err)
 	}
 
+	if !webhookMatchesFilters(webhook, eventTypes) {
+		return nil
+	}
+
 	method := webhook["method"].(string)
 	if method == "" {
 		method = "POST"
 	}
 
-	req, _ := http.NewRequest(method, webhook["url"].(string), r.Body)
+	body, err := ReadBody(r, 1000000)
+	if err != nil {
+		return err
+	}
 
-	headers := webhook["headers"].(map[string]interface{})
-	for name, value := range headers {
-		req.Header.Set(name, value.(string))
+	headers := make(map[string]string)
+	configHeaders, _ := webhook["headers"].(map[string]interface{})
+	for name, value := range configHeaders {
+		headers[name] = value.(string)
 	}
 
-	resp, err := utils.MakeHTTPRequest(req)
+	secret, _ := webhook["secret"].(string)
+	delivery := webhooks.NewDelivery(channel.UUID().String(), webhook["url"].(string), method, headers, body, secret)
 
-	if resp.StatusCode/100 != 2 {
-		return err
+	if relay := s.WebhookRelay(); relay != nil {
+		return relay.Send(delivery)
 	}
+	return delivery.Attempt()
+}
 
-	return nil
+// webhookMatchesFilters returns whether the passed in event types pass the filters of the passed in webhook config,
+// webhooks without filters get everything
+func webhookMatchesFilters(webhook map[string]interface{}, eventTypes []string) bool {
+	filters, _ := webhook["filters"].([]interface{})
+	if len(filters) == 0 {
+		return true
+	}
+
+	for _, f := range filters {
+		for _, eventType := range eventTypes {
+			if f == eventType {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 type moTemplatesPayload struct {
@@ -82,6 +118,10 @@ type moTemplatesPayload struct {
 }
 
 func SendWebhooksToIntegrations(r *http.Request, url string) error {
+	if courier.ShedLoad("webhook_forward") {
+		return nil
+	}
+
 	moTemplatesPayload := &moTemplatesPayload{}
 
 	body, err := ioutil.ReadAll(r.Body)
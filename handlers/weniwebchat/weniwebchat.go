@@ -36,12 +36,25 @@ func (h *handler) Initialize(s courier.Server) error {
 	return nil
 }
 
+// ConfigSpec describes the config keys of Weni webchat channels
+func (h *handler) ConfigSpec() []courier.ConfigField {
+	return []courier.ConfigField{
+		{Key: courier.ConfigBaseURL, Label: "Socket Server URL", Help: "The URL of the webchat socket server msgs are sent to", Required: true},
+	}
+}
+
 type miPayload struct {
-	Type    string    `json:"type"           validate:"required"`
-	From    string    `json:"from,omitempty" validate:"required"`
-	Message miMessage `json:"message"`
+	Type      string     `json:"type"           validate:"required"`
+	From      string     `json:"from,omitempty" validate:"required"`
+	Message   *miMessage `json:"message,omitempty"`
+	SessionID string     `json:"session_id,omitempty"`
+	Status    string     `json:"status,omitempty"`
+	TimeStamp string     `json:"timestamp,omitempty"`
 }
 
+// the presence statuses of contacts the socket server tells us about
+var presenceStatuses = map[string]bool{"online": true, "offline": true}
+
 type miMessage struct {
 	Type      string `json:"type"          validate:"required"`
 	TimeStamp string `json:"timestamp"     validate:"required"`
@@ -59,8 +72,13 @@ func (h *handler) receiveMsg(ctx context.Context, channel courier.Channel, w htt
 		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
 	}
 
+	// session and presence events from the socket server
+	if payload.Type == "session_start" || payload.Type == "session_end" || payload.Type == "presence" {
+		return h.receiveEvent(ctx, channel, payload, w, r)
+	}
+
 	// check message type
-	if payload.Type != "message" || (payload.Message.Type != "text" && payload.Message.Type != "image" && payload.Message.Type != "video" && payload.Message.Type != "audio" && payload.Message.Type != "file" && payload.Message.Type != "location") {
+	if payload.Type != "message" || payload.Message == nil || (payload.Message.Type != "text" && payload.Message.Type != "image" && payload.Message.Type != "video" && payload.Message.Type != "audio" && payload.Message.Type != "file" && payload.Message.Type != "location") {
 		return nil, handlers.WriteAndLogRequestIgnored(ctx, h, channel, w, r, "ignoring request, unknown message type")
 	}
 
@@ -101,6 +119,44 @@ func (h *handler) receiveMsg(ctx context.Context, channel courier.Channel, w htt
 	return handlers.WriteMsgsAndResponse(ctx, h, []courier.Msg{msg}, w, r)
 }
 
+// receiveEvent writes a channel event for a contact starting or ending a chat session, or coming online or going offline
+func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, payload *miPayload, w http.ResponseWriter, r *http.Request) ([]courier.Event, error) {
+	if payload.SessionID == "" {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, errors.New("missing session_id"))
+	}
+
+	urn, err := urns.NewURNFromParts(urns.ExternalScheme, payload.From, "", "")
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, err)
+	}
+
+	ts, err := strconv.ParseInt(payload.TimeStamp, 10, 64)
+	if err != nil {
+		return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid timestamp: %s", payload.TimeStamp))
+	}
+
+	eventType := courier.SessionStart
+	extra := map[string]interface{}{"session_id": payload.SessionID}
+
+	switch payload.Type {
+	case "session_end":
+		eventType = courier.SessionEnd
+	case "presence":
+		if !presenceStatuses[payload.Status] {
+			return nil, handlers.WriteAndLogRequestError(ctx, h, channel, w, r, fmt.Errorf("invalid presence status: %s", payload.Status))
+		}
+		eventType = courier.PresenceChange
+		extra["status"] = payload.Status
+	}
+
+	event := h.Backend().NewChannelEvent(channel, eventType, urn).WithOccurredOn(time.Unix(ts, 0).UTC()).WithExtra(extra)
+	err = h.Backend().WriteChannelEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	return []courier.Event{event}, courier.WriteChannelEventSuccess(ctx, w, r, event)
+}
+
 var timestamp = ""
 
 type moPayload struct {
@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/nyaruka/courier"
 	. "github.com/nyaruka/courier/handlers"
@@ -132,6 +133,51 @@ var testCases = []ChannelHandleTestCase{
 		Status:   200,
 		Response: "ignoring request, unknown message type",
 	},
+	{
+		Label:             "Receive Session Start",
+		URL:               receiveURL,
+		Data:              `{"type":"session_start","from":"2345678","session_id":"f3b1d9a2","timestamp":"1616586927"}`,
+		URN:               Sp("ext:2345678"),
+		Date:              Tp(time.Date(2021, 3, 24, 11, 55, 27, 0, time.UTC)),
+		ChannelEvent:      Sp("session_start"),
+		ChannelEventExtra: map[string]interface{}{"session_id": "f3b1d9a2"},
+		Status:            200,
+		Response:          "Accepted",
+	},
+	{
+		Label:             "Receive Session End",
+		URL:               receiveURL,
+		Data:              `{"type":"session_end","from":"2345678","session_id":"f3b1d9a2","timestamp":"1616587927"}`,
+		URN:               Sp("ext:2345678"),
+		ChannelEvent:      Sp("session_end"),
+		ChannelEventExtra: map[string]interface{}{"session_id": "f3b1d9a2"},
+		Status:            200,
+		Response:          "Accepted",
+	},
+	{
+		Label:             "Receive Presence",
+		URL:               receiveURL,
+		Data:              `{"type":"presence","from":"2345678","session_id":"f3b1d9a2","status":"offline","timestamp":"1616587927"}`,
+		URN:               Sp("ext:2345678"),
+		ChannelEvent:      Sp("presence_change"),
+		ChannelEventExtra: map[string]interface{}{"session_id": "f3b1d9a2", "status": "offline"},
+		Status:            200,
+		Response:          "Accepted",
+	},
+	{
+		Label:    "Receive Presence With Invalid Status",
+		URL:      receiveURL,
+		Data:     `{"type":"presence","from":"2345678","session_id":"f3b1d9a2","status":"away","timestamp":"1616587927"}`,
+		Status:   400,
+		Response: "invalid presence status: away",
+	},
+	{
+		Label:    "Receive Session Start Without Session",
+		URL:      receiveURL,
+		Data:     `{"type":"session_start","from":"2345678","timestamp":"1616586927"}`,
+		Status:   400,
+		Response: "missing session_id",
+	},
 }
 
 func TestHandler(t *testing.T) {
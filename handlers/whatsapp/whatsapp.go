@@ -3,6 +3,7 @@ package whatsapp
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -13,9 +14,11 @@ import (
 
 	"github.com/buger/jsonparser"
 	"github.com/gabriel-vasile/mimetype"
+	"github.com/go-chi/chi"
 	"github.com/nyaruka/courier"
 	"github.com/nyaruka/courier/backends/rapidpro"
 	"github.com/nyaruka/courier/handlers"
+	"github.com/nyaruka/courier/metadata"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/gocommon/rcache"
 	"github.com/nyaruka/gocommon/urns"
@@ -73,9 +76,54 @@ func newWAHandler(channelType courier.ChannelType, name string) courier.ChannelH
 func (h *handler) Initialize(s courier.Server) error {
 	h.SetServer(s)
 	s.AddHandlerRoute(h, http.MethodPost, "receive", h.receiveEvent)
+
+	// on-premise installs can all post to the same URL, with their channel's webhook token in the path or a header
+	if h.ChannelType() == channelTypeWa {
+		s.AddHandlerSharedRoute(h, http.MethodPost, "receive", h.receiveEvent)
+		s.AddHandlerSharedRoute(h, http.MethodPost, "receive/{token}", h.receiveEvent)
+	}
 	return nil
 }
 
+// the header requests to our shared receive route can pass the webhook token of their channel in
+const webhookTokenHeader = "X-Courier-Token"
+
+// GetChannel returns the channel of the passed in request, found by its UUID or by the webhook token it's passed with
+// on our shared route. Channels with webhook tokens reject requests without theirs on either route.
+func (h *handler) GetChannel(ctx context.Context, r *http.Request) (courier.Channel, error) {
+	token := chi.URLParam(r, "token")
+	if token == "" {
+		token = r.Header.Get(webhookTokenHeader)
+	}
+
+	if chi.URLParam(r, "uuid") == "" {
+		if token == "" {
+			return nil, fmt.Errorf("missing webhook token")
+		}
+		channel, err := h.Backend().GetChannelByWebhookToken(ctx, h.ChannelType(), token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook token")
+		}
+		return channel, nil
+	}
+
+	channel, err := h.BaseHandler.GetChannel(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := channel.StringConfigForKey(courier.ConfigWebhookToken, "")
+	if expected != "" {
+		if token == "" {
+			return nil, fmt.Errorf("missing webhook token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return nil, fmt.Errorf("invalid webhook token")
+		}
+	}
+	return channel, nil
+}
+
 //	{
 //	  "statuses": [{
 //	    "id": "9712A34B4A8B6AD50F",
@@ -406,7 +454,7 @@ func (h *handler) receiveEvent(ctx context.Context, channel courier.Channel, w h
 
 	webhook := channel.ConfigForKey("webhook", nil)
 	if webhook != nil {
-		er := handlers.SendWebhooksExternal(r, webhook)
+		er := handlers.SendWebhooksExternal(h.Server(), channel, r, webhook)
 		if er != nil {
 			courier.LogRequestError(r, channel, fmt.Errorf("could not send webhook: %s", er))
 		}
@@ -679,7 +727,7 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 		status.AddLog(log)
 	}
 
-	for i, payload := range payloads {
+	for _, payload := range payloads {
 		externalID := ""
 
 		wppID, externalID, logs, err = sendWhatsAppMsg(msg, sendPath, payload)
@@ -691,10 +739,8 @@ func (h *handler) SendMsg(ctx context.Context, msg courier.Msg) (courier.MsgStat
 			break
 		}
 
-		// if this is our first message, record the external id
-		if i == 0 {
-			status.SetExternalID(externalID)
-		}
+		// record this part, the first external id becoming the external id of the msg
+		status.AddPart(externalID, courier.MsgWired)
 	}
 
 	// we are wired it there were no errors
@@ -1431,25 +1477,11 @@ func checkWhatsAppContact(channel courier.Channel, baseURL string, urn urns.URN)
 }
 
 func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
-	mdJSON := msg.Metadata()
-	if len(mdJSON) == 0 {
-		return nil, nil
-	}
-	metadata := &TemplateMetadata{}
-	err := json.Unmarshal(mdJSON, metadata)
-	if err != nil {
+	templating, err := metadata.DecodeTemplating(msg.Metadata())
+	if err != nil || templating == nil {
 		return nil, err
 	}
-	templating := metadata.Templating
-	if templating == nil {
-		return nil, nil
-	}
 
-	// check our template is valid
-	err = handlers.Validate(templating)
-	if err != nil {
-		return nil, errors.Wrapf(err, "invalid templating definition")
-	}
 	// check country
 	if templating.Country != "" {
 		templating.Language = fmt.Sprintf("%s_%s", templating.Language, templating.Country)
@@ -1462,23 +1494,11 @@ func (h *handler) getTemplate(msg courier.Msg) (*MsgTemplating, error) {
 	}
 	templating.Language = language
 
-	return templating, err
+	return templating, nil
 }
 
-type TemplateMetadata struct {
-	Templating *MsgTemplating `json:"templating"`
-}
-
-type MsgTemplating struct {
-	Template struct {
-		Name string `json:"name" validate:"required"`
-		UUID string `json:"uuid" validate:"required"`
-	} `json:"template" validate:"required,dive"`
-	Language  string   `json:"language" validate:"required"`
-	Country   string   `json:"country"`
-	Namespace string   `json:"namespace"`
-	Variables []string `json:"variables"`
-}
+// MsgTemplating is the template a msg should be sent with, as validated by our metadata schemas
+type MsgTemplating = metadata.Templating
 
 // mapping from iso639-3_iso3166-2 to WA language code
 var languageMap = map[string]string{
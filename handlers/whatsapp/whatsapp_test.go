@@ -1013,6 +1013,36 @@ func mockAttachmentURLs(mediaServer *httptest.Server, testCases []ChannelSendTes
 	return casesWithMockedUrls
 }
 
+var tokenChannels = []courier.Channel{
+	courier.NewMockChannel(
+		"8eb23e93-5ecb-45ba-b726-3b064e0c568d",
+		"WA",
+		"250788383384",
+		"RW",
+		map[string]interface{}{
+			"auth_token":    "the-auth-token",
+			"base_url":      "https://foo.bar/",
+			"webhook_token": "s3cr3t",
+		}),
+}
+
+var tokenTestCases = []ChannelHandleTestCase{
+	{Label: "Receive By Token In Path", URL: "/c/wa/receive/s3cr3t", Data: helloMsg, Status: 200, Response: `"type":"msg"`, NoInvalidChannelCheck: true,
+		Text: Sp("hello world"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41"), Date: Tp(time.Date(2016, 1, 30, 1, 57, 9, 0, time.UTC))},
+	{Label: "Receive By Token In Header", URL: "/c/wa/receive", Headers: map[string]string{"X-Courier-Token": "s3cr3t"}, Data: helloMsg, Status: 200, Response: `"type":"msg"`,
+		Text: Sp("hello world"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41")},
+	{Label: "Receive Without Token", URL: "/c/wa/receive", Data: helloMsg, Status: 400, Response: "missing webhook token"},
+	{Label: "Receive With Invalid Token", URL: "/c/wa/receive/nope", Data: helloMsg, Status: 400, Response: "invalid webhook token"},
+	{Label: "Receive By UUID With Token", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568d/receive", Headers: map[string]string{"X-Courier-Token": "s3cr3t"}, Data: helloMsg, Status: 200, Response: `"type":"msg"`,
+		Text: Sp("hello world"), URN: Sp("whatsapp:250788123123"), ExternalID: Sp("41")},
+	{Label: "Receive By UUID Without Token", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568d/receive", Data: helloMsg, Status: 400, Response: "missing webhook token"},
+	{Label: "Receive By UUID With Invalid Token", URL: "/c/wa/8eb23e93-5ecb-45ba-b726-3b064e0c568d/receive", Headers: map[string]string{"X-Courier-Token": "nope"}, Data: helloMsg, Status: 400, Response: "invalid webhook token"},
+}
+
+func TestWebhookToken(t *testing.T) {
+	RunChannelTestCases(t, tokenChannels, newWAHandler(courier.ChannelType("WA"), "WhatsApp"), tokenTestCases)
+}
+
 func TestSending(t *testing.T) {
 	var defaultChannel = courier.NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WA", "250788383383", "US",
 		map[string]interface{}{
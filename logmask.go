@@ -0,0 +1,124 @@
+package courier
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how many trailing characters of masked phone numbers we leave visible so log lines can still be correlated
+const maskVisibleSuffix = 4
+
+// default patterns of values we mask in log lines, a first capture group is left unmasked
+var defaultLogMaskPatterns = []*regexp.Regexp{
+	// phone numbers in E164 format or as URN paths
+	regexp.MustCompile(`(?i)\b(?:tel|whatsapp):\+?\d{7,15}\b|\+\d{7,15}\b`),
+
+	// phone numbers in query strings and forms
+	regexp.MustCompile(`(?i)(\b(?:from|to|msisdn|phone|sender|mobile|number)=)\+?\d{7,15}\b`),
+
+	// email addresses
+	regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`),
+
+	// auth tokens in headers
+	regexp.MustCompile(`(?i)(\b(?:bearer|basic|token)\s+)[\w\-.~+/]+=*`),
+
+	// auth tokens, keys and secrets in query strings, forms and JSON
+	regexp.MustCompile(`(?i)((?:access_token|auth_token|token|api_key|apikey|secret|password)"?\s*[=:]\s*"?)[^&\s",]+`),
+}
+
+// LogMaskHook is a logrus hook which masks phone numbers, emails and auth tokens in the message and fields of log
+// entries. It should be added before any hook which sends entries elsewhere, such as to Sentry.
+type LogMaskHook struct {
+	patterns []*regexp.Regexp
+}
+
+// NewLogMaskHook creates a new hook which masks our default patterns and the passed in regular expression if not empty
+func NewLogMaskHook(pattern string) (*LogMaskHook, error) {
+	patterns := make([]*regexp.Regexp, len(defaultLogMaskPatterns), len(defaultLogMaskPatterns)+1)
+	copy(patterns, defaultLogMaskPatterns)
+
+	if pattern != "" {
+		custom, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log mask pattern: %s", err)
+		}
+		patterns = append(patterns, custom)
+	}
+
+	return &LogMaskHook{patterns: patterns}, nil
+}
+
+// Levels returns the levels we mask, which is all of them
+func (h *LogMaskHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire masks the passed in entry. Its fields are shared with the entry it was derived from, so we replace rather than
+// modify them.
+func (h *LogMaskHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.Mask(entry.Message)
+
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = h.maskField(v)
+	}
+	entry.Data = data
+	return nil
+}
+
+// Mask returns the passed in string with all our patterns masked
+func (h *LogMaskHook) Mask(s string) string {
+	for _, pattern := range h.patterns {
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			prefix := ""
+			if groups := pattern.FindStringSubmatch(match); len(groups) > 1 {
+				prefix = groups[1]
+			}
+			return prefix + maskString(match[len(prefix):])
+		})
+	}
+	return s
+}
+
+// maskField masks the passed in field value, values which are left unchanged keep their type
+func (h *LogMaskHook) maskField(v interface{}) interface{} {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case error:
+		s = t.Error()
+	case fmt.Stringer:
+		s = t.String()
+	case []byte:
+		s = string(t)
+	default:
+		if v == nil || reflect.TypeOf(v).Kind() != reflect.String {
+			return v
+		}
+		s = reflect.ValueOf(v).String()
+	}
+
+	masked := h.Mask(s)
+	if masked == s {
+		return v
+	}
+	if _, isError := v.(error); isError {
+		return errors.New(masked)
+	}
+	return masked
+}
+
+// maskString replaces all but the last few characters of the passed in value, values too short to leave any visible
+// are masked entirely
+func maskString(value string) string {
+	if len(value) <= maskVisibleSuffix*2 {
+		return strings.Repeat("*", len(value))
+	}
+	return strings.Repeat("*", len(value)-maskVisibleSuffix) + value[len(value)-maskVisibleSuffix:]
+}
@@ -0,0 +1,56 @@
+package courier
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogMaskHook(t *testing.T) {
+	hook, err := NewLogMaskHook(`secret-\d+`)
+	require.NoError(t, err)
+
+	tcs := []struct {
+		input  string
+		masked string
+	}{
+		{"msg received", "msg received"},
+		{"tel:+250788383383", "*************3383"},
+		{"whatsapp:5511999999999", "******************9999"},
+		{"sending to +12065551212 failed", "sending to ********1212 failed"},
+		{"https://example.com/send?from=250788383383&text=hi", "https://example.com/send?from=********3383&text=hi"},
+		{"contact bob@nyaruka.com", "contact ***********.com"},
+		{"Authorization: Bearer abcdef123456789", "Authorization: Bearer ***********6789"},
+		{`{"access_token": "abcdef123456789"}`, `{"access_token": "***********6789"}`},
+		{"https://example.com/?api_key=abc", "https://example.com/?api_key=***"},
+		{"elapsed 1234 ms, msg 12345678", "elapsed 1234 ms, msg 12345678"},
+		{"reference secret-1234", "reference *******1234"},
+	}
+	for _, tc := range tcs {
+		assert.Equal(t, tc.masked, hook.Mask(tc.input), "mask mismatch for %s", tc.input)
+	}
+
+	_, err = NewLogMaskHook(`[`)
+	assert.EqualError(t, err, "invalid log mask pattern: error parsing regexp: missing closing ]: `[`")
+
+	logger := logrus.New()
+	output := &bytes.Buffer{}
+	logger.SetOutput(output)
+	logger.Hooks.Add(hook)
+
+	// fields are masked without changing the entry they were derived from
+	log := logger.WithField("urn", urns.URN("tel:+250788383383")).WithField("msg_id", 12345678)
+	log.WithError(errors.New("error sending to +250788383383")).Error("send failed for bob@nyaruka.com")
+
+	assert.Contains(t, output.String(), "urn=\"*************3383\"")
+	assert.Contains(t, output.String(), "msg_id=12345678")
+	assert.Contains(t, output.String(), "error=\"error sending to *********3383\"")
+	assert.Contains(t, output.String(), "send failed for ***********.com")
+	assert.NotContains(t, output.String(), "250788383383")
+	assert.Equal(t, urns.URN("tel:+250788383383"), log.Data["urn"])
+}
@@ -0,0 +1,34 @@
+package courier
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MediaChecksum is the size and SHA256 of media as reported by the channel it was received on, which downloads of it
+// are validated against, either can be unknown
+type MediaChecksum struct {
+	Size   int
+	SHA256 string
+}
+
+// MediaDownloadError is returned when the media of an incoming msg can't be downloaded, possibly only for now, so the
+// request which delivered the msg should be retried rather than the msg be written without it
+type MediaDownloadError struct {
+	URL string
+	Err error
+}
+
+func (e *MediaDownloadError) Error() string {
+	return fmt.Sprintf("unable to download media from %s: %s", e.URL, e.Err)
+}
+
+func (e *MediaDownloadError) Unwrap() error {
+	return e.Err
+}
+
+// IsMediaDownloadError returns whether the passed in error is, or wraps, a MediaDownloadError
+func IsMediaDownloadError(err error) bool {
+	var mediaErr *MediaDownloadError
+	return errors.As(err, &mediaErr)
+}
@@ -0,0 +1,14 @@
+package courier
+
+import "fmt"
+
+// MediaBackfillResult is the outcome of re-resolving the media of recent msgs whose attachment URLs may have expired
+type MediaBackfillResult struct {
+	Msgs     int `json:"msgs"`
+	Resolved int `json:"resolved"`
+	Failed   int `json:"failed"`
+}
+
+func (r *MediaBackfillResult) String() string {
+	return fmt.Sprintf("scanned %d msg(s), resolved %d attachment(s), failed %d attachment(s)", r.Msgs, r.Resolved, r.Failed)
+}
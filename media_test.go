@@ -0,0 +1,30 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaDownloadError(t *testing.T) {
+	err := &MediaDownloadError{URL: "https://example.com/media.jpg", Err: errors.New("received status 502")}
+	assert.EqualError(t, err, "unable to download media from https://example.com/media.jpg: received status 502")
+	assert.True(t, IsMediaDownloadError(err))
+	assert.True(t, IsMediaDownloadError(fmt.Errorf("error writing msg: %w", err)))
+	assert.False(t, IsMediaDownloadError(errors.New("boom")))
+
+	// requests whose media couldn't be downloaded are asked to be retried
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/c/wac/receive", nil)
+	WriteError(context.Background(), w, r, err)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	WriteError(context.Background(), w, r, errors.New("boom"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
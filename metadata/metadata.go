@@ -0,0 +1,214 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/buger/jsonparser"
+	validator "gopkg.in/go-playground/validator.v9"
+)
+
+// the version of a blob which doesn't declare one
+const defaultVersion = 1
+
+var validate = validator.New()
+
+func init() {
+	// report fields by their JSON names so errors match what was sent to us
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+}
+
+// the channel types whose handlers read the blobs we have schemas for, i.e. WhatsApp via its on-premise API, which 360Dialog
+// and TextIt channels use too, and WhatsApp Cloud
+var (
+	whatsappTypes      = []string{"WA", "D3", "TXW", "WAC"}
+	whatsappCloudTypes = []string{"WAC"}
+)
+
+// schema is the schema of a blob, by version as functions returning a value to decode into, and the channel types
+// whose msgs we validate it on
+type schema struct {
+	channelTypes []string
+	versions     map[int]func() interface{}
+}
+
+// our registry of the schemas of each blob, by key
+var registry = map[string]*schema{}
+
+// register registers the passed in version of the schema for blobs with the passed in key, which are read by handlers
+// of the passed in channel types
+func register(key string, version int, channelTypes []string, decodeInto func() interface{}) {
+	if registry[key] == nil {
+		registry[key] = &schema{versions: make(map[int]func() interface{})}
+	}
+	registry[key].channelTypes = channelTypes
+	registry[key].versions[version] = decodeInto
+}
+
+func init() {
+	register(KeyTemplating, 1, whatsappTypes, func() interface{} { return &Templating{} })
+	register(KeyCTAMessage, 1, whatsappCloudTypes, func() interface{} { return &CTAMessage{} })
+}
+
+// covers returns whether the passed in channel type reads the blobs of this schema
+func (s *schema) covers(channelType string) bool {
+	for _, t := range s.channelTypes {
+		if t == channelType {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is returned for a blob in msg metadata which doesn't match its schema
+type Error struct {
+	Key     string
+	Version int
+	Field   string
+	Reason  string
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("invalid %s metadata (v%d): %s", e.Key, e.Version, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s metadata (v%d): %s %s", e.Key, e.Version, e.Field, e.Reason)
+}
+
+// Validate validates each blob in the passed in metadata of a msg on a channel of the passed in type, which we have a
+// schema for that channel type, returning an error for the first invalid one. Blobs which the handler of the channel
+// type doesn't read are ignored.
+func Validate(channelType string, raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	blobs := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(raw, &blobs); err != nil {
+		return fmt.Errorf("invalid metadata: %s", err)
+	}
+
+	// check our blobs in a stable order so the same metadata always returns the same error
+	keys := make([]string, 0, len(blobs))
+	for key := range blobs {
+		if schema, found := registry[key]; found && schema.covers(channelType) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := decode(key, blobs[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodeTemplating decodes and validates the templating in the passed in msg metadata, returning nil if it has none
+func DecodeTemplating(raw json.RawMessage) (*Templating, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	blob, dataType, _, err := jsonparser.Get(raw, KeyTemplating)
+	if dataType == jsonparser.NotExist || dataType == jsonparser.Null {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %s", err)
+	}
+
+	templating, err := decode(KeyTemplating, blob)
+	if err != nil {
+		return nil, err
+	}
+	return templating.(*Templating), nil
+}
+
+// decode decodes and validates the passed in blob against the schema of its key and declared version
+func decode(key string, blob json.RawMessage) (interface{}, error) {
+	version := defaultVersion
+	if v, err := jsonparser.GetInt(blob, "version"); err == nil {
+		version = int(v)
+	}
+
+	decodeInto, found := registry[key].versions[version]
+	if !found {
+		return nil, &Error{Key: key, Version: version, Reason: "unsupported version"}
+	}
+
+	value := decodeInto()
+	if err := json.Unmarshal(blob, value); err != nil {
+		return nil, &Error{Key: key, Version: version, Reason: describeJSONError(err)}
+	}
+
+	if err := validate.Struct(value); err != nil {
+		vErrs, isValidation := err.(validator.ValidationErrors)
+		if !isValidation || len(vErrs) == 0 {
+			return nil, &Error{Key: key, Version: version, Reason: err.Error()}
+		}
+		return nil, &Error{Key: key, Version: version, Field: fieldPath(vErrs[0]), Reason: describeFieldError(vErrs[0])}
+	}
+
+	return value, nil
+}
+
+// fieldPath returns the JSON path of the field of the passed in error within its blob, e.g. template.name
+func fieldPath(fe validator.FieldError) string {
+	// the namespace starts with the name of the blob's type which isn't part of the path
+	parts := strings.SplitN(fe.Namespace(), ".", 2)
+	if len(parts) < 2 {
+		return fe.Field()
+	}
+	return parts[1]
+}
+
+// describeFieldError describes why the field of the passed in error is invalid
+func describeFieldError(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "required_with":
+		return fmt.Sprintf("is required with %s", snakeCase(fe.Param()))
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "url":
+		return "must be a valid URL"
+	default:
+		return fmt.Sprintf("failed on the '%s' tag", fe.Tag())
+	}
+}
+
+// snakeCase converts the passed in Go field name to the snake case of its JSON name, e.g. HeaderMediaID to header_media_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// describeJSONError describes why a blob couldn't be decoded, naming the field whose value has the wrong type
+func describeJSONError(err error) string {
+	if typeErr, isType := err.(*json.UnmarshalTypeError); isType && typeErr.Field != "" {
+		return fmt.Sprintf("%s must be %s, got %s", typeErr.Field, typeErr.Type.String(), typeErr.Value)
+	}
+	return err.Error()
+}
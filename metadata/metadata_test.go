@@ -0,0 +1,65 @@
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tcs := []struct {
+		metadata string
+		err      string
+	}{
+		{``, ""},
+		{`{"quick_replies": ["Yes", "No"]}`, ""},
+		{`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng"}}`, ""},
+		{`{"templating": {"version": 1, "template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng"}}`, ""},
+		{`{"templating": {"template": {"name": "revive_issue"}, "language": "eng"}}`, "invalid templating metadata (v1): template.uuid is required"},
+		{`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "header_media_id": "123"}}`, "invalid templating metadata (v1): header_media_type is required with header_media_id"},
+		{`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "buttons": [{"sub_type": "call"}]}}`, "invalid templating metadata (v1): buttons[0].sub_type must be one of: url quick_reply otp copy_code"},
+		{`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "variables": "Chef"}}`, "invalid templating metadata (v1): variables must be []string, got string"},
+		{`{"templating": {"version": 2, "language": "eng"}}`, "invalid templating metadata (v2): unsupported version"},
+		{`{"cta_message": {"display_text": "Visit", "url": "https://example.com"}}`, ""},
+		{`{"cta_message": {"display_text": "Visit", "url": "example"}}`, "invalid cta_message metadata (v1): url must be a valid URL"},
+		{`{"cta_message": {"url": "https://example.com"}, "templating": {"language": "eng"}}`, "invalid cta_message metadata (v1): display_text is required"},
+	}
+
+	for _, tc := range tcs {
+		err := Validate("WAC", json.RawMessage(tc.metadata))
+		if tc.err == "" {
+			assert.NoError(t, err, "unexpected error for %s", tc.metadata)
+		} else {
+			assert.EqualError(t, err, tc.err, "error mismatch for %s", tc.metadata)
+		}
+	}
+
+	err := Validate("WAC", json.RawMessage(`["templating"]`))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid metadata: json: cannot unmarshal array")
+
+	// blobs are only validated on msgs of channel types whose handlers read them
+	assert.EqualError(t, Validate("D3", json.RawMessage(`{"templating": {"language": "eng"}}`)), "invalid templating metadata (v1): template.name is required")
+	assert.NoError(t, Validate("TG", json.RawMessage(`{"templating": {"language": "eng"}}`)))
+	assert.NoError(t, Validate("WA", json.RawMessage(`{"cta_message": {"url": "example"}}`)))
+}
+
+func TestDecodeTemplating(t *testing.T) {
+	templating, err := DecodeTemplating(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, templating)
+
+	templating, err = DecodeTemplating(json.RawMessage(`{"quick_replies": ["Yes"]}`))
+	assert.NoError(t, err)
+	assert.Nil(t, templating)
+
+	templating, err = DecodeTemplating(json.RawMessage(`{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}, "language": "eng", "variables": ["Chef"]}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "revive_issue", templating.Template.Name)
+	assert.Equal(t, "eng", templating.Language)
+	assert.Equal(t, []string{"Chef"}, templating.Variables)
+
+	_, err = DecodeTemplating(json.RawMessage(`{"templating": {"template": {"name": "revive_issue"}}}`))
+	assert.EqualError(t, err, "invalid templating metadata (v1): template.uuid is required")
+}
@@ -0,0 +1,60 @@
+package metadata
+
+// the keys of the blobs in msg metadata we have schemas for
+const (
+	KeyTemplating = "templating"
+	KeyCTAMessage = "cta_message"
+)
+
+// Templating is the template a msg should be sent with, e.g.
+//
+//	{
+//	  "template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"},
+//	  "language": "eng",
+//	  "country": "US",
+//	  "variables": ["Chef", "tomorrow"]
+//	}
+type Templating struct {
+	Template struct {
+		Name string `json:"name" validate:"required"`
+		UUID string `json:"uuid" validate:"required"`
+	} `json:"template" validate:"required,dive"`
+	Language  string   `json:"language" validate:"required"`
+	Country   string   `json:"country"`
+	Namespace string   `json:"namespace"`
+	Variables []string `json:"variables"`
+
+	HeaderMediaURL  string `json:"header_media_url"`
+	HeaderMediaID   string `json:"header_media_id"`
+	HeaderMediaType string `json:"header_media_type" validate:"required_with=HeaderMediaID,omitempty,oneof=image video document"`
+
+	Buttons          []TemplateButton `json:"buttons" validate:"dive"`
+	LimitedTimeOffer *TemplateLTO     `json:"limited_time_offer"`
+}
+
+// TemplateLTO is the limited-time offer component of a marketing template
+type TemplateLTO struct {
+	ExpirationTimeMS int64 `json:"expiration_time_ms" validate:"required"`
+}
+
+// TemplateButton is a button of a template which needs a parameter at send time
+type TemplateButton struct {
+	SubType   string       `json:"sub_type" validate:"required,oneof=url quick_reply otp copy_code"`
+	Index     int          `json:"index"`
+	Parameter string       `json:"parameter"`
+	OTP       *TemplateOTP `json:"otp"`
+}
+
+// TemplateOTP is the configuration of the OTP button of an authentication template
+type TemplateOTP struct {
+	Type          string `json:"type" validate:"required,oneof=copy_code one_tap"`
+	Code          string `json:"code" validate:"required"`
+	PackageName   string `json:"package_name"`
+	SignatureHash string `json:"signature_hash"`
+}
+
+// CTAMessage is a single button which opens a URL, e.g. {"display_text": "Visit", "url": "https://example.com"}
+type CTAMessage struct {
+	DisplayText string `json:"display_text" validate:"required"`
+	URL         string `json:"url" validate:"required,url"`
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// our own registry so that only our metrics and those of the Go runtime are exposed
+var registry = prometheus.NewRegistry()
+
+var (
+	msgsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "msgs_received_total",
+		Help:      "The number of msgs received, by channel type",
+	}, []string{"channel_type"})
+
+	msgsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "msgs_sent_total",
+		Help:      "The number of msgs sent, by channel type and priority",
+	}, []string{"channel_type", "priority"})
+
+	msgsErrored = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "msgs_errored_total",
+		Help:      "The number of msgs whose send errored or failed, by channel type, priority and class of error",
+	}, []string{"channel_type", "priority", "error_class"})
+
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "courier",
+		Name:      "handler_duration_seconds",
+		Help:      "How long handlers took to handle requests and send msgs, by channel type and action",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"channel_type", "action"})
+
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "courier",
+		Name:      "queue_depth",
+		Help:      "The number of msgs waiting to be sent, by priority",
+	}, []string{"priority"})
+
+	channelLogsSampledOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "channel_logs_sampled_out_total",
+		Help:      "The number of channel logs not written because of the log sampling of their channel, by channel type",
+	}, []string{"channel_type"})
+
+	s3UploadSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "courier",
+		Name:      "s3_upload_bytes",
+		Help:      "The size of the attachments uploaded to S3",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 10),
+	})
+
+	billingPublishes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "billing_publishes_total",
+		Help:      "The number of msgs published to billing, or dropped without being, by outcome",
+	}, []string{"outcome"})
+
+	billingPublishRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "courier",
+		Name:      "billing_publish_retries_total",
+		Help:      "The number of times publishing a msg to billing was retried",
+	})
+
+	billingPublishDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "courier",
+		Name:      "billing_publish_duration_seconds",
+		Help:      "How long publishing msgs to billing took, including retries",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// the actions handler durations are recorded for
+const (
+	ActionReceive = "receive"
+	ActionEvent   = "event"
+	ActionStatus  = "status"
+	ActionIgnored = "ignored"
+	ActionError   = "error"
+	ActionSend    = "send"
+)
+
+func init() {
+	registry.MustRegister(msgsReceived, msgsSent, msgsErrored, handlerDuration, queueDepth, channelLogsSampledOut, s3UploadSize)
+	registry.MustRegister(billingPublishes, billingPublishRetries, billingPublishDuration)
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// Handler returns the HTTP handler which exposes our metrics to be scraped by Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RecordMsgReceived records a msg received on a channel of the passed in type
+func RecordMsgReceived(channelType string) {
+	msgsReceived.WithLabelValues(channelType).Inc()
+}
+
+// RecordMsgSent records a msg with the passed in priority sent on a channel of the passed in type
+func RecordMsgSent(channelType string, priority string) {
+	msgsSent.WithLabelValues(channelType, priority).Inc()
+}
+
+// RecordMsgErrored records a msg with the passed in priority whose send on a channel of the passed in type errored with
+// the passed in class of error, empty if we couldn't tell
+func RecordMsgErrored(channelType string, priority string, errorClass string) {
+	if errorClass == "" {
+		errorClass = "unknown"
+	}
+	msgsErrored.WithLabelValues(channelType, priority, errorClass).Inc()
+}
+
+// RecordHandlerDuration records how long a handler of the passed in channel type took for the passed in action
+func RecordHandlerDuration(channelType string, action string, elapsed time.Duration) {
+	handlerDuration.WithLabelValues(channelType, action).Observe(elapsed.Seconds())
+}
+
+// SetQueueDepth sets the number of msgs waiting to be sent with the passed in priority, i.e. bulk or priority
+func SetQueueDepth(priority string, size int) {
+	queueDepth.WithLabelValues(priority).Set(float64(size))
+}
+
+// RecordChannelLogSampledOut records a channel log of a channel of the passed in type not written because of sampling
+func RecordChannelLogSampledOut(channelType string) {
+	channelLogsSampledOut.WithLabelValues(channelType).Inc()
+}
+
+// RecordS3Upload records the size of an attachment uploaded to S3
+func RecordS3Upload(size int) {
+	s3UploadSize.Observe(float64(size))
+}
+
+// RecordBillingPublish records publishing the passed in number of msgs to billing, which took the passed in number of
+// attempts and time, and whether it failed
+func RecordBillingPublish(count int, attempts int, elapsed time.Duration, failed bool) {
+	outcome := "success"
+	if failed {
+		outcome = "failure"
+	}
+	billingPublishes.WithLabelValues(outcome).Add(float64(count))
+	if attempts > 1 {
+		billingPublishRetries.Add(float64(attempts - 1))
+	}
+	billingPublishDuration.Observe(elapsed.Seconds())
+}
+
+// RecordBillingDropped records the passed in number of msgs dropped without being published to billing
+func RecordBillingDropped(count int) {
+	billingPublishes.WithLabelValues("dropped").Add(float64(count))
+}
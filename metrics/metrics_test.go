@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	RecordMsgReceived("TG")
+	RecordMsgReceived("TG")
+	RecordMsgSent("WAC", "transactional")
+	RecordMsgErrored("WAC", "bulk", "rate_limit")
+	RecordMsgErrored("WAC", "bulk", "")
+	RecordHandlerDuration("TG", ActionReceive, 250*time.Millisecond)
+	SetQueueDepth("bulk", 12)
+	RecordChannelLogSampledOut("EX")
+	RecordS3Upload(2048)
+	RecordBillingPublish(5, 3, time.Second, false)
+	RecordBillingPublish(1, 1, time.Second, true)
+	RecordBillingDropped(4)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+
+	body, _ := io.ReadAll(w.Body)
+	assert.Contains(t, string(body), `courier_msgs_received_total{channel_type="TG"} 2`)
+	assert.Contains(t, string(body), `courier_msgs_sent_total{channel_type="WAC",priority="transactional"} 1`)
+	assert.Contains(t, string(body), `courier_msgs_errored_total{channel_type="WAC",error_class="rate_limit",priority="bulk"} 1`)
+	assert.Contains(t, string(body), `courier_msgs_errored_total{channel_type="WAC",error_class="unknown",priority="bulk"} 1`)
+	assert.Contains(t, string(body), `courier_handler_duration_seconds_count{action="receive",channel_type="TG"} 1`)
+	assert.Contains(t, string(body), `courier_queue_depth{priority="bulk"} 12`)
+	assert.Contains(t, string(body), `courier_channel_logs_sampled_out_total{channel_type="EX"} 1`)
+	assert.Contains(t, string(body), `courier_s3_upload_bytes_sum 2048`)
+	assert.Contains(t, string(body), `courier_billing_publishes_total{outcome="success"} 5`)
+	assert.Contains(t, string(body), `courier_billing_publishes_total{outcome="failure"} 1`)
+	assert.Contains(t, string(body), `courier_billing_publishes_total{outcome="dropped"} 4`)
+	assert.Contains(t, string(body), `courier_billing_publish_retries_total 2`)
+	assert.Contains(t, string(body), `courier_billing_publish_duration_seconds_count 2`)
+	assert.Contains(t, string(body), `go_goroutines`)
+}
@@ -0,0 +1,191 @@
+package courier
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how long we give a mirrored write before giving up on it
+const mirrorTimeout = 30 * time.Second
+
+// mirroredBackend is a backend which writes received msgs and statuses to a secondary backend as well as to its
+// primary, which lets a new stack be validated against real traffic before cutting over to it. Mirroring happens in
+// the background and never fails or slows down writes to the primary.
+type mirroredBackend struct {
+	Backend
+
+	mirror Backend
+	writes chan func(context.Context) error
+	wg     sync.WaitGroup
+
+	// whether we've stopped accepting writes to mirror
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewMirroredBackend returns a backend which delegates to the passed in primary backend, mirroring writes of received
+// msgs and statuses to the passed in mirror backend. At most queueSize writes wait to be mirrored, with any beyond that
+// being dropped.
+func NewMirroredBackend(primary Backend, mirror Backend, queueSize int) Backend {
+	return &mirroredBackend{
+		Backend: primary,
+		mirror:  mirror,
+		writes:  make(chan func(context.Context) error, queueSize),
+	}
+}
+
+// newMirrorConfig returns the config of the secondary backend described by the passed in config
+func newMirrorConfig(config *Config) *Config {
+	mirrorConfig := *config
+	mirrorConfig.DB = config.MirrorDB
+	mirrorConfig.Redis = config.MirrorRedis
+	mirrorConfig.MirrorDB = ""
+	mirrorConfig.MirrorRedis = ""
+
+	// the primary flushes everything in its spool to itself so the mirror needs its own
+	mirrorConfig.SpoolDir = filepath.Join(config.SpoolDir, "mirror")
+	return &mirrorConfig
+}
+
+// Start starts both backends, a mirror which fails to start is logged but doesn't stop us starting
+func (b *mirroredBackend) Start() error {
+	if err := b.Backend.Start(); err != nil {
+		return err
+	}
+
+	if err := b.mirror.Start(); err != nil {
+		logrus.WithError(err).WithField("comp", "mirror").Error("error starting mirror backend, writes won't be mirrored")
+		b.closeWrites()
+		return nil
+	}
+
+	b.wg.Add(1)
+	go b.mirrorWrites()
+	return nil
+}
+
+// Stop waits for any queued writes to be mirrored and then stops both backends
+func (b *mirroredBackend) Stop() error {
+	b.closeWrites()
+	b.wg.Wait()
+
+	if err := b.mirror.Stop(); err != nil {
+		logrus.WithError(err).WithField("comp", "mirror").Error("error stopping mirror backend")
+	}
+	return b.Backend.Stop()
+}
+
+// Cleanup closes the connections of both backends
+func (b *mirroredBackend) Cleanup() error {
+	if err := b.mirror.Cleanup(); err != nil {
+		logrus.WithError(err).WithField("comp", "mirror").Error("error cleaning up mirror backend")
+	}
+	return b.Backend.Cleanup()
+}
+
+// WriteMsg writes the passed in msg to our primary, and if that succeeds, queues it to be written to our mirror
+func (b *mirroredBackend) WriteMsg(ctx context.Context, msg Msg) error {
+	if err := b.Backend.WriteMsg(ctx, msg); err != nil {
+		return err
+	}
+
+	// copy what we need now as the primary owns the msg
+	channelType, channelUUID := msg.Channel().ChannelType(), msg.Channel().UUID()
+	uuid, urn, text, urnAuth := msg.UUID(), msg.URN(), msg.Text(), msg.URNAuth()
+	externalID, contactName, metadata := msg.ExternalID(), msg.ContactName(), msg.Metadata()
+	attachments := append([]string(nil), msg.Attachments()...)
+	receivedOn := msg.ReceivedOn()
+
+	b.queueWrite("msg", func(ctx context.Context) error {
+		channel, err := b.mirror.GetChannel(ctx, channelType, channelUUID)
+		if err != nil {
+			return errors.Wrap(err, "error getting channel")
+		}
+
+		mirrored := b.mirror.NewIncomingMsg(channel, urn, text).WithUUID(uuid).WithExternalID(externalID).WithContactName(contactName).WithURNAuth(urnAuth)
+		for _, a := range attachments {
+			mirrored.WithAttachment(a)
+		}
+		if receivedOn != nil {
+			mirrored.WithReceivedOn(*receivedOn)
+		}
+		if len(metadata) > 0 {
+			mirrored.WithMetadata(metadata)
+		}
+		return b.mirror.WriteMsg(ctx, mirrored)
+	})
+	return nil
+}
+
+// WriteMsgStatus writes the passed in status to our primary, and if that succeeds, queues it to be written to our mirror
+func (b *mirroredBackend) WriteMsgStatus(ctx context.Context, status MsgStatus) error {
+	if err := b.Backend.WriteMsgStatus(ctx, status); err != nil {
+		return err
+	}
+
+	channelUUID, id, externalID, value := status.ChannelUUID(), status.ID(), status.ExternalID(), status.Status()
+
+	b.queueWrite("status", func(ctx context.Context) error {
+		channel, err := b.mirror.GetChannel(ctx, AnyChannelType, channelUUID)
+		if err != nil {
+			return errors.Wrap(err, "error getting channel")
+		}
+
+		// msg ids are only the same on both backends if the mirror was seeded from the primary, so we prefer external ids
+		var mirrored MsgStatus
+		if externalID != "" {
+			mirrored = b.mirror.NewMsgStatusForExternalID(channel, externalID, value)
+		} else {
+			mirrored = b.mirror.NewMsgStatusForID(channel, id, value)
+		}
+		return b.mirror.WriteMsgStatus(ctx, mirrored)
+	})
+	return nil
+}
+
+// queueWrite queues the passed in write to be mirrored, dropping it if our queue is full or we're not mirroring
+func (b *mirroredBackend) queueWrite(kind string, write func(context.Context) error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	select {
+	case b.writes <- write:
+	default:
+		logrus.WithField("comp", "mirror").WithField("kind", kind).Error("mirror queue full, dropping write")
+	}
+}
+
+// mirrorWrites makes our queued writes to our mirror until we're stopped
+func (b *mirroredBackend) mirrorWrites() {
+	defer b.wg.Done()
+
+	for write := range b.writes {
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		err := write(ctx)
+		cancel()
+
+		if err != nil {
+			logrus.WithError(err).WithField("comp", "mirror").Error("error mirroring write")
+		}
+	}
+}
+
+// closeWrites stops us accepting writes to mirror, letting those already queued be made
+func (b *mirroredBackend) closeWrites() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		close(b.writes)
+	}
+}
@@ -0,0 +1,109 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+// ReasonModerated is the reason we record on messages that were blocked by moderation
+const ReasonModerated = "moderated"
+
+// Request is what is passed to a moderator for each outgoing message
+//
+//	{
+//	  "channel_uuid": "9d24bce2-145f-4e65-b9ed-72ef19ee81e0",
+//	  "channel_type": "WAC",
+//	  "urn": "whatsapp:5511999999999",
+//	  "text": "Hello world"
+//	}
+type Request struct {
+	ChannelUUID string `json:"channel_uuid"`
+	ChannelType string `json:"channel_type"`
+	URN         string `json:"urn"`
+	Text        string `json:"text"`
+}
+
+// Verdict is the result of moderating a message
+type Verdict struct {
+	Blocked bool   `json:"blocked"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Moderator is the interface for anything that can decide whether an outgoing message should be blocked
+type Moderator interface {
+	Moderate(ctx context.Context, req Request) (*Verdict, error)
+}
+
+// New creates a moderator from the passed in endpoint or pattern, returning nil if neither is set
+func New(endpoint string, pattern string) (Moderator, error) {
+	if endpoint != "" {
+		return NewHTTPModerator(endpoint), nil
+	}
+	if pattern != "" {
+		return NewRulesModerator(pattern)
+	}
+	return nil, nil
+}
+
+// httpModerator asks an external HTTP endpoint whether messages should be blocked
+type httpModerator struct {
+	endpoint string
+}
+
+// NewHTTPModerator creates a new moderator which POSTs each request to the passed in endpoint, expecting a verdict back
+func NewHTTPModerator(endpoint string) Moderator {
+	return &httpModerator{endpoint: endpoint}
+}
+
+func (m *httpModerator) Moderate(ctx context.Context, req Request) (*Verdict, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	rr, err := utils.MakeHTTPRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("error calling moderation endpoint: %s", err)
+	}
+
+	verdict := &Verdict{}
+	err = json.Unmarshal(rr.Body, verdict)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse moderation response: %s", err)
+	}
+	return verdict, nil
+}
+
+// rulesModerator blocks any message whose text matches a local pattern
+type rulesModerator struct {
+	pattern *regexp.Regexp
+}
+
+// NewRulesModerator creates a new moderator which blocks messages matching the passed in pattern, case insensitively
+func NewRulesModerator(pattern string) (Moderator, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid moderation pattern: %s", err)
+	}
+	return &rulesModerator{pattern: re}, nil
+}
+
+func (m *rulesModerator) Moderate(ctx context.Context, req Request) (*Verdict, error) {
+	match := m.pattern.FindString(req.Text)
+	if match == "" {
+		return &Verdict{Blocked: false}, nil
+	}
+	return &Verdict{Blocked: true, Reason: fmt.Sprintf("matched rule '%s'", match)}, nil
+}
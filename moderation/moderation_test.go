@@ -0,0 +1,77 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	m, err := New("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, m)
+
+	m, err = New("http://moderation.example.com", "")
+	assert.NoError(t, err)
+	assert.IsType(t, &httpModerator{}, m)
+
+	m, err = New("", "spam|scam")
+	assert.NoError(t, err)
+	assert.IsType(t, &rulesModerator{}, m)
+
+	_, err = New("", "(unclosed")
+	assert.EqualError(t, err, "invalid moderation pattern: error parsing regexp: missing closing ): `(?i)(unclosed`")
+}
+
+func TestRulesModerator(t *testing.T) {
+	m, err := NewRulesModerator(`\b(spam|scam)\b`)
+	assert.NoError(t, err)
+
+	verdict, err := m.Moderate(context.Background(), Request{Text: "this is not a SCAM"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Verdict{Blocked: true, Reason: "matched rule 'SCAM'"}, verdict)
+
+	verdict, err = m.Moderate(context.Background(), Request{Text: "scampi for dinner"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Verdict{Blocked: false}, verdict)
+}
+
+func TestHTTPModerator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+		json.NewDecoder(r.Body).Decode(req)
+
+		switch req.Text {
+		case "bad words":
+			w.Write([]byte(`{"blocked": true, "reason": "profanity"}`))
+		case "broken":
+			w.Write([]byte(`not json`))
+		case "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			assert.Equal(t, "WAC", req.ChannelType)
+			w.Write([]byte(`{"blocked": false}`))
+		}
+	}))
+	defer server.Close()
+
+	m := NewHTTPModerator(server.URL)
+
+	verdict, err := m.Moderate(context.Background(), Request{ChannelType: "WAC", Text: "hello"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Verdict{Blocked: false}, verdict)
+
+	verdict, err = m.Moderate(context.Background(), Request{Text: "bad words"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Verdict{Blocked: true, Reason: "profanity"}, verdict)
+
+	_, err = m.Moderate(context.Background(), Request{Text: "broken"})
+	assert.Error(t, err)
+
+	_, err = m.Moderate(context.Background(), Request{Text: "error"})
+	assert.Error(t, err)
+}
@@ -98,6 +98,7 @@ type Msg interface {
 	ResponseToID() MsgID
 	ResponseToExternalID() string
 	IsResend() bool
+	IsSynced() bool
 
 	Channel() Channel
 
@@ -105,6 +106,7 @@ type Msg interface {
 	SentOn() *time.Time
 
 	HighPriority() bool
+	Priority() MsgPriority
 
 	WithContactName(name string) Msg
 	WithReceivedOn(date time.Time) Msg
@@ -114,6 +116,7 @@ type Msg interface {
 	WithAttachment(url string) Msg
 	WithURNAuth(auth string) Msg
 	WithMetadata(metadata json.RawMessage) Msg
+	WithText(text string) Msg
 
 	EventID() int64
 	SessionStatus() string
@@ -0,0 +1,36 @@
+package courier
+
+import (
+	"encoding/json"
+
+	"github.com/buger/jsonparser"
+)
+
+// MsgPriority is how urgently an outgoing msg needs sending, transactional msgs like replies and OTPs going ahead of
+// bulk msgs like broadcasts
+type MsgPriority string
+
+// the priorities of outgoing msgs
+const (
+	MsgPriorityTransactional = MsgPriority("transactional")
+	MsgPriorityBulk          = MsgPriority("bulk")
+)
+
+// the key in msg metadata upstream sets the priority of a msg with, e.g. {"priority": "transactional"}
+const metadataPriorityKey = "priority"
+
+// NewMsgPriority returns the priority of a msg with the passed in metadata and high priority flag, a valid priority in
+// its metadata taking precedence over the flag
+func NewMsgPriority(metadata json.RawMessage, highPriority bool) MsgPriority {
+	if len(metadata) > 0 {
+		value, _ := jsonparser.GetString(metadata, metadataPriorityKey)
+		switch MsgPriority(value) {
+		case MsgPriorityTransactional, MsgPriorityBulk:
+			return MsgPriority(value)
+		}
+	}
+	if highPriority {
+		return MsgPriorityTransactional
+	}
+	return MsgPriorityBulk
+}
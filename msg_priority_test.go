@@ -0,0 +1,21 @@
+package courier
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMsgPriority(t *testing.T) {
+	assert.Equal(t, MsgPriorityBulk, NewMsgPriority(nil, false))
+	assert.Equal(t, MsgPriorityTransactional, NewMsgPriority(nil, true))
+
+	// a priority in metadata takes precedence over the flag
+	assert.Equal(t, MsgPriorityTransactional, NewMsgPriority(json.RawMessage(`{"priority": "transactional"}`), false))
+	assert.Equal(t, MsgPriorityBulk, NewMsgPriority(json.RawMessage(`{"priority": "bulk"}`), true))
+
+	// unless it isn't one we know
+	assert.Equal(t, MsgPriorityTransactional, NewMsgPriority(json.RawMessage(`{"priority": "urgent"}`), true))
+	assert.Equal(t, MsgPriorityBulk, NewMsgPriority(json.RawMessage(`{"templating": {}}`), false))
+}
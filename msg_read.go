@@ -0,0 +1,59 @@
+package courier
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// how long we give marking the msgs of a request as read
+const markMsgsReadTimeout = 30 * time.Second
+
+// MarkMsgsRead tells the vendor that the passed in msgs have been read if their channel has mark_as_read set and the
+// passed in handler can do that, writing the logs of doing so. Failures are only logged.
+func MarkMsgsRead(ctx context.Context, backend Backend, handler ChannelHandler, msgs []Msg) {
+	marker, isMarker := handler.(MsgReadMarker)
+	if !isMarker {
+		return
+	}
+
+	logs := make([]*ChannelLog, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.ExternalID() == "" || msg.IsSynced() || !msg.Channel().BoolConfigForKey(ConfigMarkAsRead, false) {
+			continue
+		}
+
+		log, err := marker.MarkMsgRead(ctx, msg)
+		if err != nil {
+			logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).WithField("external_id", msg.ExternalID()).Error("error marking msg as read")
+		}
+		if log != nil {
+			logs = append(logs, log)
+		}
+	}
+
+	if len(logs) > 0 {
+		if err := backend.WriteChannelLogs(ctx, logs); err != nil {
+			logrus.WithError(err).Error("error writing channel log")
+		}
+	}
+}
+
+// msgHandled is called by our backend once it has handled an incoming msg, marking it as read in the background so that
+// neither the vendor nor the backend waits on it
+func (s *server) msgHandled(ctx context.Context, msg Msg) {
+	handler := s.GetHandler(msg.Channel().ChannelType())
+	if handler == nil {
+		return
+	}
+
+	s.waitGroup.Add(1)
+	go func() {
+		defer s.waitGroup.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), markMsgsReadTimeout)
+		defer cancel()
+		MarkMsgsRead(ctx, s.backend, handler, []Msg{msg})
+	}()
+}
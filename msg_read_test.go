@@ -0,0 +1,80 @@
+package courier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+// readMarkingHandler is an embedded handler which can also mark msgs as read
+type readMarkingHandler struct {
+	embeddedHandler
+	marked []string
+}
+
+func (h *readMarkingHandler) MarkMsgRead(ctx context.Context, msg Msg) (*ChannelLog, error) {
+	h.marked = append(h.marked, msg.ExternalID())
+	log := NewChannelLog("Message Marked Read", msg.Channel(), msg.ID(), "POST", "https://api.example.com/read", 200, "", "", time.Millisecond, nil)
+	if msg.ExternalID() == "ext3" {
+		return log, errors.New("boom")
+	}
+	return log, nil
+}
+
+func TestMarkMsgsRead(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "EM", "12345", "US", map[string]interface{}{})
+	urn, _ := urns.NewTelURNForCountry("+12065551212", "US")
+
+	msgs := []Msg{
+		mb.NewIncomingMsg(channel, urn, "hi").WithExternalID("ext1"),
+		mb.NewIncomingMsg(channel, urn, "no external id"),
+		mb.NewSyncedMsg(channel, urn, "synced", false).WithExternalID("ext2"),
+		mb.NewIncomingMsg(channel, urn, "fails").WithExternalID("ext3"),
+	}
+
+	// handlers which can't mark msgs as read are ignored
+	MarkMsgsRead(context.Background(), mb, &embeddedHandler{}, msgs)
+	assert.Len(t, mb.channelLogs, 0)
+
+	// as are channels which don't want it
+	handler := &readMarkingHandler{}
+	MarkMsgsRead(context.Background(), mb, handler, msgs)
+	assert.Len(t, handler.marked, 0)
+	assert.Len(t, mb.channelLogs, 0)
+
+	// otherwise msgs we received with an external id are marked, failures are still logged
+	channel.SetConfig(ConfigMarkAsRead, true)
+	MarkMsgsRead(context.Background(), mb, handler, msgs)
+	assert.Equal(t, []string{"ext1", "ext3"}, handler.marked)
+	assert.Len(t, mb.channelLogs, 2)
+}
+
+func TestMsgHandledMarksRead(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "EM", "12345", "US", map[string]interface{}{ConfigMarkAsRead: true})
+	urn, _ := urns.NewTelURNForCountry("+12065551212", "US")
+
+	handler := &readMarkingHandler{}
+	s := NewServer(NewConfig(), mb).(*server)
+	s.activeHandlers[channel.ChannelType()] = handler
+	mb.SetMsgHandledHook(s.msgHandled)
+
+	// msgs are marked as read once our backend has handled them
+	msg := mb.NewIncomingMsg(channel, urn, "hi").WithExternalID("ext1")
+	assert.NoError(t, mb.WriteMsg(context.Background(), msg))
+	s.waitGroup.Wait()
+	assert.Equal(t, []string{"ext1"}, handler.marked)
+	assert.Len(t, mb.channelLogs, 1)
+
+	// but not duplicates of msgs it already has
+	dupe := mb.NewIncomingMsg(channel, urn, "hi").WithExternalID("ext1")
+	dupe.(*mockMsg).alreadyWritten = true
+	assert.NoError(t, mb.WriteMsg(context.Background(), dupe))
+	s.waitGroup.Wait()
+	assert.Equal(t, []string{"ext1"}, handler.marked)
+}
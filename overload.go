@@ -0,0 +1,127 @@
+package courier
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/nyaruka/librato"
+	"github.com/sirupsen/logrus"
+)
+
+// how often we sample our resource usage
+const overloadCheckInterval = 5 * time.Second
+
+// whether we are currently overloaded, set by our monitor and read by anything which can shed work
+var overloaded int32
+
+// ResourceUsage is a sample of the resources our process is using
+type ResourceUsage struct {
+	Goroutines int
+	OpenFiles  int // -1 if we can't count them on this platform
+	MemoryMB   int
+}
+
+// Overloaded returns whether our process is using more resources than our configured thresholds
+func Overloaded() bool {
+	return atomic.LoadInt32(&overloaded) == 1
+}
+
+// ShedLoad returns whether non-critical work, such as forwarding webhooks or describing URNs, should be skipped because
+// we are overloaded, counting the work we skip under the passed in name
+func ShedLoad(work string) bool {
+	if !Overloaded() {
+		return false
+	}
+	librato.Gauge(fmt.Sprintf("courier.shed_%s", work), float64(1))
+	return true
+}
+
+// SampleResourceUsage samples the resources our process is currently using
+func SampleResourceUsage() ResourceUsage {
+	mem := &runtime.MemStats{}
+	runtime.ReadMemStats(mem)
+
+	return ResourceUsage{
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  countOpenFiles(),
+		MemoryMB:   int(mem.HeapAlloc / (1024 * 1024)),
+	}
+}
+
+// countOpenFiles returns how many file descriptors our process has open, or -1 if we can't tell
+func countOpenFiles() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(entries)
+}
+
+// overloadReasons returns which of the thresholds in the passed in config the passed in usage exceeds, thresholds of 0
+// being disabled
+func overloadReasons(config *Config, usage ResourceUsage) []string {
+	reasons := make([]string, 0, 3)
+	if config.OverloadMaxGoroutines > 0 && usage.Goroutines > config.OverloadMaxGoroutines {
+		reasons = append(reasons, "goroutines")
+	}
+	if config.OverloadMaxOpenFiles > 0 && usage.OpenFiles > config.OverloadMaxOpenFiles {
+		reasons = append(reasons, "open_files")
+	}
+	if config.OverloadMaxMemoryMB > 0 && usage.MemoryMB > config.OverloadMaxMemoryMB {
+		reasons = append(reasons, "memory")
+	}
+	return reasons
+}
+
+// checkOverload samples our resource usage and updates whether we are overloaded, logging when that changes
+func checkOverload(config *Config, usage ResourceUsage) {
+	librato.Gauge("courier.goroutines", float64(usage.Goroutines))
+	librato.Gauge("courier.memory_mb", float64(usage.MemoryMB))
+	if usage.OpenFiles >= 0 {
+		librato.Gauge("courier.open_files", float64(usage.OpenFiles))
+	}
+
+	reasons := overloadReasons(config, usage)
+	isOverloaded := len(reasons) > 0
+
+	var value int32
+	if isOverloaded {
+		value = 1
+	}
+	if atomic.SwapInt32(&overloaded, value) == value {
+		return
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"comp":       "overload",
+		"goroutines": usage.Goroutines,
+		"open_files": usage.OpenFiles,
+		"memory_mb":  usage.MemoryMB,
+	})
+	if isOverloaded {
+		log.WithField("state", "overloaded").WithField("reasons", reasons).Warn("process overloaded, shedding non-critical work")
+	} else {
+		log.WithField("state", "recovered").Info("process recovered, no longer shedding work")
+	}
+}
+
+// startOverloadMonitor starts sampling our resource usage until our server is stopped
+func (s *server) startOverloadMonitor() {
+	s.waitGroup.Add(1)
+
+	go func() {
+		defer s.waitGroup.Done()
+
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-time.After(overloadCheckInterval):
+				checkOverload(s.Config(), SampleResourceUsage())
+			}
+		}
+	}()
+}
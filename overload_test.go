@@ -0,0 +1,52 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverloadReasons(t *testing.T) {
+	config := NewConfig()
+	config.OverloadMaxGoroutines = 100
+	config.OverloadMaxOpenFiles = 50
+	config.OverloadMaxMemoryMB = 0
+
+	assert.Equal(t, []string{}, overloadReasons(config, ResourceUsage{Goroutines: 100, OpenFiles: 50, MemoryMB: 5000}))
+	assert.Equal(t, []string{"goroutines"}, overloadReasons(config, ResourceUsage{Goroutines: 101, OpenFiles: 50}))
+	assert.Equal(t, []string{"goroutines", "open_files"}, overloadReasons(config, ResourceUsage{Goroutines: 101, OpenFiles: 51}))
+	assert.Equal(t, []string{}, overloadReasons(config, ResourceUsage{Goroutines: 10, OpenFiles: -1}))
+
+	config.OverloadMaxMemoryMB = 1000
+	assert.Equal(t, []string{"memory"}, overloadReasons(config, ResourceUsage{Goroutines: 10, OpenFiles: 10, MemoryMB: 1001}))
+}
+
+func TestCheckOverload(t *testing.T) {
+	defer func() { overloaded = 0 }()
+
+	config := NewConfig()
+	config.OverloadMaxGoroutines = 100
+
+	checkOverload(config, ResourceUsage{Goroutines: 10})
+	assert.False(t, Overloaded())
+	assert.False(t, ShedLoad("test"))
+
+	checkOverload(config, ResourceUsage{Goroutines: 200})
+	assert.True(t, Overloaded())
+	assert.True(t, ShedLoad("test"))
+
+	// staying overloaded doesn't change anything
+	checkOverload(config, ResourceUsage{Goroutines: 300})
+	assert.True(t, Overloaded())
+
+	checkOverload(config, ResourceUsage{Goroutines: 50})
+	assert.False(t, Overloaded())
+	assert.False(t, ShedLoad("test"))
+}
+
+func TestSampleResourceUsage(t *testing.T) {
+	usage := SampleResourceUsage()
+	assert.True(t, usage.Goroutines > 0)
+	assert.True(t, usage.OpenFiles == -1 || usage.OpenFiles > 0)
+	assert.True(t, usage.MemoryMB >= 0)
+}
@@ -0,0 +1,92 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MsgCategory is the pricing category of an outgoing message
+type MsgCategory string
+
+// Possible values for MsgCategory
+const (
+	MsgCategoryTemplate MsgCategory = "template"
+	MsgCategorySession  MsgCategory = "session"
+)
+
+// PricingWildcard matches any channel type, country or category in a pricing table
+const PricingWildcard = "*"
+
+// PricingTable holds the estimated cost of sending a message, keyed by channel type, country and category, e.g.
+//
+//	{
+//	  "WAC": {"BR": {"template": 0.0625, "session": 0}, "*": {"*": 0.05}},
+//	  "*": {"*": {"*": 0.01}}
+//	}
+type PricingTable map[string]map[string]map[MsgCategory]float64
+
+// MsgCostEstimate is the estimated cost of sending a message
+type MsgCostEstimate struct {
+	Cost     float64     `json:"cost"`
+	Currency string      `json:"currency"`
+	Category MsgCategory `json:"category"`
+}
+
+// ParsePricingTable parses the passed in JSON pricing table, an empty string is an empty table
+func ParsePricingTable(s string) (PricingTable, error) {
+	table := PricingTable{}
+	if s == "" {
+		return table, nil
+	}
+	err := json.Unmarshal([]byte(s), &table)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing table: %s", err)
+	}
+	return table, nil
+}
+
+// Estimate returns the cost for the passed in channel type, country and category, preferring exact matches over wildcards
+func (t PricingTable) Estimate(channelType ChannelType, country string, category MsgCategory) (float64, bool) {
+	for _, ct := range []string{string(channelType), PricingWildcard} {
+		countries, found := t[ct]
+		if !found {
+			continue
+		}
+		for _, c := range []string{country, PricingWildcard} {
+			categories, found := countries[c]
+			if !found {
+				continue
+			}
+			for _, cat := range []MsgCategory{category, PricingWildcard} {
+				cost, found := categories[cat]
+				if found {
+					return cost, true
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// CategoryForMsg returns the pricing category of the passed in outgoing message
+func CategoryForMsg(msg Msg) MsgCategory {
+	metadata := struct {
+		Templating json.RawMessage `json:"templating"`
+	}{}
+	json.Unmarshal(msg.Metadata(), &metadata)
+
+	if len(metadata.Templating) > 0 && string(metadata.Templating) != "null" {
+		return MsgCategoryTemplate
+	}
+	return MsgCategorySession
+}
+
+// MsgPricing is the pricing a channel reported for a message in one of its status updates
+type MsgPricing struct {
+	ExternalID   string
+	Category     string
+	PricingModel string
+	Billable     bool
+	OccurredOn   time.Time
+}
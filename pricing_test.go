@@ -0,0 +1,54 @@
+package courier
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPricingTable(t *testing.T) {
+	table, err := ParsePricingTable("")
+	assert.NoError(t, err)
+	_, found := table.Estimate("WAC", "BR", MsgCategoryTemplate)
+	assert.False(t, found)
+
+	_, err = ParsePricingTable(`{"WAC": 1}`)
+	assert.Error(t, err)
+
+	table, err = ParsePricingTable(`{
+		"WAC": {"BR": {"template": 0.0625, "session": 0}, "*": {"*": 0.05}},
+		"*": {"*": {"*": 0.01}}
+	}`)
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		channelType ChannelType
+		country     string
+		category    MsgCategory
+		cost        float64
+	}{
+		{"WAC", "BR", MsgCategoryTemplate, 0.0625},
+		{"WAC", "BR", MsgCategorySession, 0},
+		{"WAC", "US", MsgCategoryTemplate, 0.05},
+		{"WAC", "", MsgCategorySession, 0.05},
+		{"TG", "BR", MsgCategorySession, 0.01},
+	}
+
+	for _, tc := range tcs {
+		cost, found := table.Estimate(tc.channelType, tc.country, tc.category)
+		assert.True(t, found)
+		assert.Equal(t, tc.cost, cost, "cost mismatch for %s/%s/%s", tc.channelType, tc.country, tc.category)
+	}
+}
+
+func TestCategoryForMsg(t *testing.T) {
+	msg := &mockMsg{}
+	assert.Equal(t, MsgCategorySession, CategoryForMsg(msg))
+
+	msg.metadata = json.RawMessage(`{"quick_replies": ["yes"]}`)
+	assert.Equal(t, MsgCategorySession, CategoryForMsg(msg))
+
+	msg.metadata = json.RawMessage(`{"templating": {"template": {"name": "welcome", "uuid": "a3e4fbd8-1ba0-4c96-8f29-1f5a1a0b6c7d"}, "language": "eng"}}`)
+	assert.Equal(t, MsgCategoryTemplate, CategoryForMsg(msg))
+}
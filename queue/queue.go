@@ -32,7 +32,51 @@ const (
 	Retry = WorkerToken("retry")
 )
 
-var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS, Priority, Value]
+// how many active queues each pop looks at for queues we haven't given turns yet, e.g. those pushed by other processes,
+// taking the next window on each pop so that every active queue is looked at eventually
+const activeScanWindow = 10
+
+// Orgs take turns popping, and the queues of each org take turns in proportion to their weights. Each org has a virtual
+// time which advances by 1 every time we pop from it, each queue a virtual time within its org which advances by
+// 1/weight every time we pop from it, and we pop from the queue with the earliest in the org with the earliest. The
+// virtual times of orgs are kept in the :vtimes sorted set and those of the queues of each org in :vtimes:<org>.
+//
+// This activates a queue to take turns if it isn't already, starting it at the virtual time of its org and its org at
+// the virtual time of all orgs so that queues which have been idle don't get to catch up on the turns they missed.
+// Queues whose org we don't know are their own org.
+const luaActivate = `
+local function queueName(queue)
+	local delim = string.find(queue, "|")
+	if delim then
+		return string.sub(queue, 1, delim-1)
+	end
+	return queue
+end
+
+local function queueOrg(qType, queue)
+	local name = queueName(queue)
+	return redis.call("hget", qType .. ":orgs", name) or name
+end
+
+local function activate(qType, queue)
+	local org = queueOrg(qType, queue)
+	local vclock = tonumber(redis.call("hget", qType .. ":vclocks", org) or 0)
+	if redis.call("zadd", qType .. ":vtimes:" .. org, "NX", vclock, queue) == 1 then
+		redis.call("zadd", qType .. ":vtimes", "NX", tonumber(redis.call("get", qType .. ":vclock") or 0), org)
+	end
+end
+
+local function deactivate(qType, queue)
+	local org = queueOrg(qType, queue)
+	redis.call("zrem", qType .. ":vtimes:" .. org, queue)
+	if redis.call("zcard", qType .. ":vtimes:" .. org) == 0 then
+		redis.call("zrem", qType .. ":vtimes", org)
+		redis.call("hdel", qType .. ":vclocks", org)
+	end
+end
+`
+
+var luaPush = redis.NewScript(6, luaActivate+`-- KEYS: [EpochMS, QueueType, QueueName, TPS, Priority, Value]
 	-- first push onto our specific queue
 	-- our queue name is built from the type, name and tps, usually something like: "msgs:uuid1-uuid2-uuid3-uuid4|tps"
 	local queueKey = KEYS[2] .. ":" .. KEYS[3] .. "|" .. KEYS[4]
@@ -53,6 +97,7 @@ var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS,
 	-- if we aren't then add to our active
 	if not curr or curr < tps then
 	  redis.call("zincrby", KEYS[2] .. ":active", 0, queueKey)
+	  activate(KEYS[2], queueKey)
 	  return 1
 	else 
 	  return 0
@@ -63,16 +108,85 @@ var luaPush = redis.NewScript(6, `-- KEYS: [EpochMS, QueueType, QueueName, TPS,
 // specified transactions per second are popped off at a time. A tps value of 0 means there is no
 // limit to the rate that messages can be consumed
 func PushOntoQueue(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority) error {
-	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	return PushOntoQueueAt(conn, qType, queue, tps, value, priority, time.Now())
+}
+
+// PushOntoQueueAt pushes the passed in value to the passed in queue like PushOntoQueue, but it is parked there and
+// can't be popped until the passed in time, e.g. for msgs scheduled to be sent later.
+func PushOntoQueueAt(conn redis.Conn, qType string, queue string, tps int, value string, priority Priority, at time.Time) error {
+	epochMS := strconv.FormatFloat(float64(at.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
 	_, err := redis.Int(luaPush.Do(conn, epochMS, qType, queue, tps, priority, value))
 	return err
 }
 
-var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
-	-- get the first key off our active list
-	local result = redis.call("zrange", KEYS[2] .. ":active", 0, 0, "WITHSCORES")
-	local queue = result[1]
-	local workers = result[2]
+var luaPop = redis.NewScript(2, luaActivate+`-- KEYS: [EpochMS QueueType] ARGV: [SkippedQueue...]
+	local skipped = {}
+	for i=1,#ARGV do
+		skipped[ARGV[i]] = true
+	end
+
+	-- gives turns to the next window of active queues which don't have them yet, returning how many it looked at
+	local cursorKey = KEYS[2] .. ":vcursor"
+	local function activateWindow()
+		local cursor = tonumber(redis.call("get", cursorKey) or 0)
+		local window = redis.call("zrange", KEYS[2] .. ":active", cursor, cursor + `+strconv.Itoa(activeScanWindow-1)+`)
+		for i=1,#window do
+			activate(KEYS[2], window[i])
+		end
+		if #window < `+strconv.Itoa(activeScanWindow)+` then
+			redis.call("set", cursorKey, 0)
+		else
+			redis.call("set", cursorKey, cursor + #window)
+		end
+		return #window
+	end
+
+	-- finds the queue with the earliest virtual time in the org with the earliest, ignoring any queues we've been told to
+	-- skip and deactivating those which are no longer active as we come across them
+	local function findQueue()
+		local orgIndex = 0
+		while true do
+			local orgs = redis.call("zrange", KEYS[2] .. ":vtimes", orgIndex, orgIndex, "WITHSCORES")
+			if #orgs == 0 then
+				return nil
+			end
+
+			local queueIndex = 0
+			while true do
+				local orgQueues = redis.call("zrange", KEYS[2] .. ":vtimes:" .. orgs[1], queueIndex, queueIndex, "WITHSCORES")
+				if #orgQueues == 0 then
+					break
+				end
+
+				if skipped[queueName(orgQueues[1])] then
+					queueIndex = queueIndex + 1
+				else
+					local workers = redis.call("zscore", KEYS[2] .. ":active", orgQueues[1])
+					if workers then
+						return orgQueues[1], workers, tonumber(orgQueues[2]), orgs[1], tonumber(orgs[2])
+					end
+
+					-- no longer active, it'll get turns again once it is
+					deactivate(KEYS[2], orgQueues[1])
+					redis.call("zrem", KEYS[2] .. ":vtimes:" .. orgs[1], orgQueues[1])
+				end
+			end
+
+			-- orgs left without queues were removed by deactivating them, otherwise move on to the next
+			if redis.call("zscore", KEYS[2] .. ":vtimes", orgs[1]) then
+				orgIndex = orgIndex + 1
+			end
+		end
+	end
+
+	-- keep giving turns to windows of active queues until we find one, or we've looked at them all
+	local total = redis.call("zcard", KEYS[2] .. ":active")
+	local scanned = activateWindow()
+	local queue, workers, start, org, orgStart = findQueue()
+	while not queue and scanned < total do
+		scanned = scanned + activateWindow()
+		queue, workers, start, org, orgStart = findQueue()
+	end
 
 	-- nothing? return nothing
 	if not queue then
@@ -96,6 +210,7 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
 		if curr and tonumber(curr) >= tps then 
 			redis.call("zincrby", KEYS[2] .. ":throttled", workers, queue)
 			redis.call("zrem", KEYS[2] .. ":active", queue)
+			deactivate(KEYS[2], queue)
 			return {"retry", ""}
   	    end
 	end
@@ -136,6 +251,14 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
 		-- and add a worker to this queue
 		redis.call("zincrby", KEYS[2] .. ":active", 1, queue)
 
+		-- advance the virtual clocks of all orgs and of this org to this turn, and the virtual times of the org and the
+		-- queue past it
+		local weight = tonumber(redis.call("hget", KEYS[2] .. ":weights", queueName(queue)) or 1)
+		redis.call("set", KEYS[2] .. ":vclock", orgStart)
+		redis.call("zadd", KEYS[2] .. ":vtimes", orgStart + 1, org)
+		redis.call("hset", KEYS[2] .. ":vclocks", org, start)
+		redis.call("zadd", KEYS[2] .. ":vtimes:" .. org, start + 1 / weight, queue)
+
 		-- parse it as JSON to get the first element out
 		local valueList = cjson.decode(result[1])
 		local popValue = cjson.encode(valueList[1])
@@ -162,11 +285,13 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
 	elseif isFutureResult then
 	    redis.call("zincrby", KEYS[2] .. ":future", 0, queue)
 	    redis.call("zrem", KEYS[2] .. ":active", queue)
+		deactivate(KEYS[2], queue)
 		return {"retry", ""}
 	
 	-- otherwise, the queue is empty, remove it from active
 	else
 		redis.call("zrem", KEYS[2] .. ":active", queue)
+		deactivate(KEYS[2], queue)
 		return {"retry", ""}
 	end
 `)
@@ -176,8 +301,20 @@ var luaPop = redis.NewScript(2, `-- KEYS: [EpochMS QueueType]
 // worker token of EmptyQueue will be returned if there are no more items to retrive.
 // Otherwise the WorkerToken should be saved in order to mark the task as complete later.
 func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
+	return PopFromQueueSkipping(conn, qType, nil)
+}
+
+// PopFromQueueSkipping pops the next available message like PopFromQueue, but never from the queues with the passed
+// in names, e.g. those of channels another instance sends for.
+func PopFromQueueSkipping(conn redis.Conn, qType string, skipped []string) (WorkerToken, string, error) {
 	epochMS := strconv.FormatFloat(float64(time.Now().UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
-	values, err := redis.Strings(luaPop.Do(conn, epochMS, qType))
+
+	args := []interface{}{epochMS, qType}
+	for _, queue := range skipped {
+		args = append(args, qType+":"+queue)
+	}
+
+	values, err := redis.Strings(luaPop.Do(conn, args...))
 	if err != nil {
 		logrus.Error(err)
 		return "", "", err
@@ -185,6 +322,60 @@ func PopFromQueue(conn redis.Conn, qType string) (WorkerToken, string, error) {
 	return WorkerToken(values[0]), values[1], nil
 }
 
+var luaSetOrgWeight = redis.NewScript(2, luaActivate+`-- KEYS: [QueueType, Queue] ARGV: [Org, Weight]
+	local name = queueName(KEYS[2])
+
+	-- move the queue to its new org, taking turns there from its virtual time
+	if queueOrg(KEYS[1], KEYS[2]) ~= ARGV[1] then
+		local active = redis.call("zscore", KEYS[1] .. ":vtimes:" .. queueOrg(KEYS[1], KEYS[2]), KEYS[2])
+		if active then
+			deactivate(KEYS[1], KEYS[2])
+		end
+		redis.call("hset", KEYS[1] .. ":orgs", name, ARGV[1])
+		if active then
+			activate(KEYS[1], KEYS[2])
+		end
+	end
+
+	local weight = tonumber(ARGV[2])
+	if weight <= 0 or weight == 1 then
+		redis.call("hdel", KEYS[1] .. ":weights", name)
+	else
+		redis.call("hset", KEYS[1] .. ":weights", name, weight)
+	end
+`)
+
+// SetOrgWeight sets the org of the queue of the passed in worker token and the weight of the queue within it. Orgs take
+// turns popping, and the queues of an org take turns in proportion to their weights when they all have values waiting.
+// Queues have a weight of 1 unless set to some other positive weight.
+func SetOrgWeight(conn redis.Conn, qType string, token WorkerToken, org string, weight float64) error {
+	_, err := luaSetOrgWeight.Do(conn, qType, token, org, weight)
+	return err
+}
+
+var luaRequeue = redis.NewScript(2, luaActivate+`-- KEYS: [QueueType, Queue] ARGV: [EpochMS, Priority, Value]
+	-- put the value back on the queue of its priority it was popped from, behind what's already waiting there
+	redis.call("zadd", KEYS[2] .. "/" .. ARGV[2], ARGV[1], "[" .. ARGV[3] .. "]")
+	redis.call("zincrby", KEYS[1] .. ":active", 0, KEYS[2])
+	activate(KEYS[1], KEYS[2])
+`)
+
+// Requeue puts a value popped with the passed in worker token back on the queue it came from with the passed in
+// priority, marking the task as complete, for when the popping worker shouldn't be the one to handle it.
+func Requeue(conn redis.Conn, qType string, token WorkerToken, value string, priority Priority) error {
+	return RequeueAt(conn, qType, token, value, priority, time.Now())
+}
+
+// RequeueAt puts a value popped with the passed in worker token back on the queue it came from like Requeue, but it
+// can't be popped again until the passed in time.
+func RequeueAt(conn redis.Conn, qType string, token WorkerToken, value string, priority Priority, at time.Time) error {
+	epochMS := strconv.FormatFloat(float64(at.UnixNano()/int64(time.Microsecond))/float64(1000000), 'f', 6, 64)
+	if _, err := luaRequeue.Do(conn, qType, token, epochMS, priority, value); err != nil {
+		return err
+	}
+	return MarkComplete(conn, qType, token)
+}
+
 var luaComplete = redis.NewScript(2, `-- KEYS: [QueueType, Queue]
 	-- decrement throttled if present
 	local throttled = tonumber(redis.call("zadd", KEYS[1] .. ":throttled", "XX", "CH", "INCR", -1, KEYS[2]))
@@ -208,7 +399,7 @@ func MarkComplete(conn redis.Conn, qType string, token WorkerToken) error {
 	return err
 }
 
-var luaDethrottle = redis.NewScript(1, `-- KEYS: [QueueType]
+var luaDethrottle = redis.NewScript(1, luaActivate+`-- KEYS: [QueueType]
 	-- get all the keys from our throttle list
 	local throttled = redis.call("zrange", KEYS[1] .. ":throttled", 0, -1, "WITHSCORES")
 
@@ -217,6 +408,7 @@ var luaDethrottle = redis.NewScript(1, `-- KEYS: [QueueType]
 		local activeKey = KEYS[1] .. ":active"
 		for i=1,#throttled,2 do
 			redis.call("zincrby", activeKey, throttled[i+1], throttled[i])
+			activate(KEYS[1], throttled[i])
 		end
 		redis.call("del", KEYS[1] .. ":throttled")
 	end
@@ -229,6 +421,7 @@ var luaDethrottle = redis.NewScript(1, `-- KEYS: [QueueType]
 		local activeKey = KEYS[1] .. ":active"
 		for i=1,#future,2 do
 			redis.call("zincrby", activeKey, future[i+1], future[i])
+			activate(KEYS[1], future[i])
 		end
 		redis.call("del", KEYS[1] .. ":future")
 	end
@@ -248,3 +248,212 @@ func BenchmarkQueue(b *testing.B) {
 		assert.NoError(err)
 	}
 }
+
+func TestPopSkipping(t *testing.T) {
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	PushOntoQueue(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority)
+	PushOntoQueue(conn, "msgs", "chan2", 0, `[{"id":2}]`, HighPriority)
+
+	// skipping both queues, there's nothing for us
+	token, value, err := PopFromQueueSkipping(conn, "msgs", []string{"chan1", "chan2"})
+	assert.NoError(t, err)
+	assert.Equal(t, EmptyQueue, token)
+	assert.Equal(t, "", value)
+
+	token, value, err = PopFromQueueSkipping(conn, "msgs", []string{"chan1"})
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan2|0"), token)
+	assert.Equal(t, `{"id":2}`, value)
+
+	token, value, err = PopFromQueueSkipping(conn, "msgs", []string{"chan2"})
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":1}`, value)
+
+	// requeued values can be popped again
+	err = Requeue(conn, "msgs", token, value, HighPriority)
+	assert.NoError(t, err)
+
+	token, value, err = PopFromQueue(conn, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":1}`, value)
+
+	workers, err := redis.Int(conn.Do("zscore", "msgs:active", "msgs:chan1|0"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, workers)
+
+	// values requeued for later can't be popped until then
+	err = RequeueAt(conn, "msgs", token, value, HighPriority, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	for token = Retry; token == Retry; {
+		token, value, err = PopFromQueue(conn, "msgs")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, EmptyQueue, token)
+	assert.Equal(t, "", value)
+
+	size, err := redis.Int(conn.Do("zcard", "msgs:chan1|0/1"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestPushAt(t *testing.T) {
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// values pushed for later are parked on their queue and can't be popped until then
+	err := PushOntoQueueAt(conn, "msgs", "chan1", 0, `[{"id":1}]`, HighPriority, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	err = PushOntoQueueAt(conn, "msgs", "chan1", 0, `[{"id":2}]`, HighPriority, time.Now().Add(-time.Second))
+	assert.NoError(t, err)
+
+	token, value, err := PopFromQueue(conn, "msgs")
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":2}`, value)
+	MarkComplete(conn, "msgs", token)
+
+	for token = Retry; token == Retry; {
+		token, value, err = PopFromQueue(conn, "msgs")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, EmptyQueue, token)
+	assert.Equal(t, "", value)
+
+	// once they're due they can be popped
+	_, err = conn.Do("zadd", "msgs:chan1|0/1", "XX", 1, `[{"id":1}]`)
+	assert.NoError(t, err)
+	_, err = luaDethrottle.Do(conn, "msgs")
+	assert.NoError(t, err)
+
+	for token = Retry; token == Retry; {
+		token, value, err = PopFromQueue(conn, "msgs")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, WorkerToken("msgs:chan1|0"), token)
+	assert.Equal(t, `{"id":1}`, value)
+}
+
+func TestFairness(t *testing.T) {
+	pool := getPool()
+	conn := pool.Get()
+	defer conn.Close()
+
+	// pops the passed in number of values, returning the queues they came from
+	pop := func(n int) []string {
+		popped := make([]string, 0, n)
+		for len(popped) < n {
+			token, _, err := PopFromQueue(conn, "msgs")
+			assert.NoError(t, err)
+			if token == EmptyQueue {
+				break
+			}
+			if token != Retry {
+				popped = append(popped, string(token))
+				MarkComplete(conn, "msgs", token)
+			}
+		}
+		return popped
+	}
+
+	// a busy channel with lots of msgs waiting doesn't stop a quiet one from taking its turns
+	for i := 0; i < 100; i++ {
+		PushOntoQueue(conn, "msgs", "busy", 0, fmt.Sprintf(`[{"id":%d}]`, i), HighPriority)
+	}
+	pop(10)
+	for i := 0; i < 3; i++ {
+		PushOntoQueue(conn, "msgs", "quiet", 0, fmt.Sprintf(`[{"id":%d}]`, 100+i), HighPriority)
+	}
+	assert.Equal(t, []string{"msgs:quiet|0", "msgs:busy|0", "msgs:quiet|0", "msgs:busy|0", "msgs:quiet|0", "msgs:busy|0", "msgs:busy|0"}, pop(7))
+
+	// with weights, queues of an org take turns in proportion to them
+	err := SetOrgWeight(conn, "msgs", "msgs:busy|0", "1", 3)
+	assert.NoError(t, err)
+	err = SetOrgWeight(conn, "msgs", "msgs:quiet|0", "1", 1)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		PushOntoQueue(conn, "msgs", "quiet", 0, fmt.Sprintf(`[{"id":%d}]`, 200+i), HighPriority)
+	}
+
+	// while the quiet queue has msgs waiting, it gets a turn for every 3 of the busy one
+	quiet, sinceQuiet := 0, 0
+	for _, queue := range pop(20) {
+		if queue == "msgs:quiet|0" {
+			quiet++
+			sinceQuiet = 0
+		} else {
+			sinceQuiet++
+			assert.LessOrEqual(t, sinceQuiet, 3, "quiet queue waited more than its weight allows")
+		}
+	}
+	assert.InDelta(t, 5, quiet, 1)
+
+	// orgs take turns regardless of how many queues they have or their weights
+	for i := 0; i < 10; i++ {
+		PushOntoQueue(conn, "msgs", "quiet", 0, fmt.Sprintf(`[{"id":%d}]`, 300+i), HighPriority)
+		PushOntoQueue(conn, "msgs", "other", 0, fmt.Sprintf(`[{"id":%d}]`, 400+i), HighPriority)
+	}
+	err = SetOrgWeight(conn, "msgs", "msgs:other|0", "2", 1)
+	assert.NoError(t, err)
+
+	other, sinceOther := 0, 0
+	for _, queue := range pop(20) {
+		if queue == "msgs:other|0" {
+			other++
+			sinceOther = 0
+		} else {
+			sinceOther++
+			assert.LessOrEqual(t, sinceOther, 1, "other org waited more than its turn")
+		}
+	}
+	assert.InDelta(t, 10, other, 1)
+
+	// queues pushed by other processes, which don't give them turns, get them once a pop comes across them
+	pop(200)
+	for i := 0; i < 20; i++ {
+		conn.Do("ZADD", fmt.Sprintf("msgs:external%d|0/1", i), 0, fmt.Sprintf(`[{"id":%d}]`, 500+i))
+		conn.Do("ZINCRBY", "msgs:active", 0, fmt.Sprintf("msgs:external%d|0", i))
+	}
+	assert.Len(t, pop(30), 20)
+
+	// and without one it goes back to the default
+	err = SetOrgWeight(conn, "msgs", "msgs:busy|0", "1", 1)
+	assert.NoError(t, err)
+	exists, err := redis.Bool(conn.Do("HEXISTS", "msgs:weights", "msgs:busy"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// emptied queues are forgotten
+	pop(100)
+	size, err := redis.Int(conn.Do("ZCARD", "msgs:vtimes"))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	// more stale queues than a pop gives turns to, and skipped queues, don't stop it finding one which is active
+	skipped := make([]string, 0, activeScanWindow+5)
+	for i := 0; i < activeScanWindow+5; i++ {
+		conn.Do("ZADD", "msgs:vtimes", -1, fmt.Sprintf("msgs:stale%d", i))
+		conn.Do("ZADD", fmt.Sprintf("msgs:vtimes:msgs:stale%d", i), -1, fmt.Sprintf("msgs:stale%d|0", i))
+
+		conn.Do("ZADD", fmt.Sprintf("msgs:skipped%d|0/1", i), 0, fmt.Sprintf(`[{"id":%d}]`, 600+i))
+		conn.Do("ZINCRBY", "msgs:active", 0, fmt.Sprintf("msgs:skipped%d|0", i))
+		skipped = append(skipped, fmt.Sprintf("skipped%d", i))
+	}
+	conn.Do("ZADD", "msgs:waiting|0/1", 0, `[{"id":700}]`)
+	conn.Do("ZINCRBY", "msgs:active", 0, "msgs:waiting|0")
+
+	token, value, err := PopFromQueueSkipping(conn, "msgs", skipped)
+	assert.NoError(t, err)
+	assert.Equal(t, WorkerToken("msgs:waiting|0"), token)
+	assert.Equal(t, `{"id":700}`, value)
+
+	// and the stale queues are forgotten
+	_, err = redis.Float64(conn.Do("ZSCORE", "msgs:vtimes", "msgs:stale0"))
+	assert.Equal(t, redis.ErrNil, err)
+}
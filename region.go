@@ -0,0 +1,8 @@
+package courier
+
+// ChannelInRegion returns whether the msgs of the passed in channel are sent by instances in the passed in region.
+// Channels without a region are sent by every instance, as is every channel by instances without a region.
+func ChannelInRegion(channel Channel, region string) bool {
+	channelRegion := channel.StringConfigForKey(ConfigRegion, "")
+	return region == "" || channelRegion == "" || channelRegion == region
+}
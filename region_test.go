@@ -0,0 +1,18 @@
+package courier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelInRegion(t *testing.T) {
+	unset := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	europe := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "WAC", "12346", "DE", map[string]interface{}{ConfigRegion: "eu-west-1"})
+
+	assert.True(t, ChannelInRegion(unset, ""))
+	assert.True(t, ChannelInRegion(unset, "us-east-1"))
+	assert.True(t, ChannelInRegion(europe, ""))
+	assert.True(t, ChannelInRegion(europe, "eu-west-1"))
+	assert.False(t, ChannelInRegion(europe, "us-east-1"))
+}
@@ -30,7 +30,13 @@ func WriteError(ctx context.Context, w http.ResponseWriter, r *http.Request, err
 			errors = append(errors, NewErrorData(fmt.Sprintf("field '%s' %s", strings.ToLower(vErrs[i].Field()), vErrs[i].Tag())))
 		}
 	}
-	return WriteDataResponse(ctx, w, http.StatusBadRequest, "Error", errors)
+
+	// media which couldn't be downloaded may be downloadable later, so tell the sender to retry
+	status := http.StatusBadRequest
+	if IsMediaDownloadError(err) {
+		status = http.StatusServiceUnavailable
+	}
+	return WriteDataResponse(ctx, w, status, "Error", errors)
 }
 
 // WriteIgnored writes a JSON response indicating that we ignored the request
@@ -129,6 +135,7 @@ type StatusData struct {
 	Status      MsgStatusValue `json:"status"`
 	MsgID       MsgID          `json:"msg_id,omitempty"`
 	ExternalID  string         `json:"external_id,omitempty"`
+	ErrorClass  ErrorClass     `json:"error_class,omitempty"`
 }
 
 // NewStatusData creates a new status data object for the passed in status
@@ -139,6 +146,7 @@ func NewStatusData(status MsgStatus) StatusData {
 		status.Status(),
 		status.ID(),
 		status.ExternalID(),
+		ClassifyMsgStatus(status),
 	}
 }
 
@@ -0,0 +1,289 @@
+package retries
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// how many of the items we gave up on we keep
+	maxDeadLetters = 1000
+
+	// how often we look for items to retry and the most we retry at a time
+	pollInterval   = time.Second
+	claimBatchSize = 100
+
+	// how long a queue has to record the outcome of an item it has claimed before it is due again, e.g. if it crashes
+	claimLease = 5 * time.Minute
+)
+
+// State is how an item has fared so far, embedded in items so that it's queued with them
+type State struct {
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (s *State) state() *State { return s }
+
+// Item is something queued to be retried, which must embed State
+type Item interface {
+	ItemID() string
+	state() *State
+}
+
+// Handler attempts the items of a queue
+type Handler interface {
+	// NewItem returns an empty item for a queued one to be unmarshalled into
+	NewItem() Item
+
+	// Attempt makes a single attempt of the passed in item, returning an error if it should be retried
+	Attempt(item Item) error
+
+	// GiveUp is called once we give up on the passed in item, e.g. to log it
+	GiveUp(item Item)
+}
+
+// Options are the keys and backoff of a queue
+type Options struct {
+	// the name of what's queued, used in logs
+	Name string
+
+	// the hash of items waiting to be retried keyed by their id, the sorted set of when each is due, and the list of
+	// items we gave up on, newest first
+	ItemsKey       string
+	PendingKey     string
+	DeadLettersKey string
+
+	// how many times we try an item before giving up on it
+	MaxAttempts int
+
+	// how long we wait before the first retry of an item, doubling for each retry after that
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+// Backoff returns how long we wait to retry an item which has failed the passed in number of attempts
+func (o *Options) Backoff(attempts int) time.Duration {
+	backoff := o.BaseBackoff
+	for i := 1; i < attempts && backoff < o.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > o.MaxBackoff {
+		return o.MaxBackoff
+	}
+	return backoff
+}
+
+// Queue is a durable queue of items in Redis, which are retried in the background with exponential backoff until they
+// succeed or we give up on them and add them to our dead letters
+type Queue struct {
+	rp      *redis.Pool
+	opts    *Options
+	handler Handler
+	stop    chan bool
+	wg      sync.WaitGroup
+}
+
+// NewQueue creates a new queue in the passed in Redis whose items are attempted by the passed in handler
+func NewQueue(rp *redis.Pool, opts *Options, handler Handler) *Queue {
+	return &Queue{rp: rp, opts: opts, handler: handler, stop: make(chan bool)}
+}
+
+// Add queues the passed in item to be attempted at the passed in time
+func (q *Queue) Add(item Item, due time.Time) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	rc.Send("HSET", q.opts.ItemsKey, item.ItemID(), value)
+	rc.Send("ZADD", q.opts.PendingKey, due.Unix(), item.ItemID())
+	if _, err := rc.Do("EXEC"); err != nil {
+		return errors.Wrapf(err, "error queuing %s", q.opts.Name)
+	}
+	return nil
+}
+
+// Start starts retrying queued items in the background
+func (q *Queue) Start() {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-q.stop:
+				return
+			case <-ticker.C:
+				if _, err := q.RetryDue(time.Now()); err != nil {
+					logrus.WithError(err).WithField("comp", q.opts.Name).Errorf("error retrying %s", q.opts.Name)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops retrying items, waiting for any being retried
+func (q *Queue) Stop() {
+	close(q.stop)
+	q.wg.Wait()
+}
+
+// claims a pending item which is due by pushing back when it's due by our lease rather than removing it, so that it's
+// still retried if we never record the outcome of our attempt
+var luaClaim = redis.NewScript(1, `-- KEYS: [Pending] ARGV: [ID, Now, LeaseUntil]
+local due = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not due or tonumber(due) > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// RetryDue retries the items which are due at the passed in time, returning how many were retried. We don't hold a
+// connection while attempting items, each step takes its own.
+func (q *Queue) RetryDue(now time.Time) (int, error) {
+	rc := q.rp.Get()
+	due, err := redis.Strings(rc.Do("ZRANGEBYSCORE", q.opts.PendingKey, "-inf", now.Unix(), "LIMIT", 0, claimBatchSize))
+	rc.Close()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error reading due %s", q.opts.Name)
+	}
+
+	retried := 0
+	for _, id := range due {
+		item, err := q.claim(id, now)
+		if err != nil {
+			return retried, err
+		}
+		if item == nil {
+			continue
+		}
+
+		retried++
+		if err := q.handler.Attempt(item); err != nil {
+			if err := q.Failed(item, err, now); err != nil {
+				return retried, err
+			}
+			continue
+		}
+		if err := q.done(item); err != nil {
+			return retried, err
+		}
+	}
+	return retried, nil
+}
+
+// claim claims the pending item with the passed in id if it's still due, returning nil if another queue got to it
+// first or there's nothing to attempt
+func (q *Queue) claim(id string, now time.Time) (Item, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	claimed, err := redis.Int(luaClaim.Do(rc, q.opts.PendingKey, id, now.Unix(), now.Add(claimLease).Unix()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error claiming %s", q.opts.Name)
+	}
+	if claimed == 0 {
+		return nil, nil
+	}
+
+	value, err := redis.Bytes(rc.Do("HGET", q.opts.ItemsKey, id))
+	if err == redis.ErrNil {
+		rc.Do("ZREM", q.opts.PendingKey, id)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", q.opts.Name)
+	}
+
+	item := q.handler.NewItem()
+	if err := json.Unmarshal(value, item); err != nil {
+		rc.Send("MULTI")
+		rc.Send("HDEL", q.opts.ItemsKey, id)
+		rc.Send("ZREM", q.opts.PendingKey, id)
+		rc.Do("EXEC")
+		return nil, nil
+	}
+	return item, nil
+}
+
+// done removes the passed in item once it has succeeded
+func (q *Queue) done(item Item) error {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	rc.Send("HDEL", q.opts.ItemsKey, item.ItemID())
+	rc.Send("ZREM", q.opts.PendingKey, item.ItemID())
+	if _, err := rc.Do("EXEC"); err != nil {
+		return errors.Wrapf(err, "error removing completed %s", q.opts.Name)
+	}
+	return nil
+}
+
+// Failed records a failed attempt of the passed in item, queuing it to be retried or adding it to our dead letters once
+// it has had all its attempts
+func (q *Queue) Failed(item Item, err error, now time.Time) error {
+	state := item.state()
+	state.Attempts++
+	state.LastError = err.Error()
+
+	value, merr := json.Marshal(item)
+	if merr != nil {
+		return merr
+	}
+
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	rc.Send("MULTI")
+	if state.Attempts >= q.opts.MaxAttempts {
+		q.handler.GiveUp(item)
+		rc.Send("HDEL", q.opts.ItemsKey, item.ItemID())
+		rc.Send("ZREM", q.opts.PendingKey, item.ItemID())
+		rc.Send("LPUSH", q.opts.DeadLettersKey, value)
+		rc.Send("LTRIM", q.opts.DeadLettersKey, 0, maxDeadLetters-1)
+	} else {
+		rc.Send("HSET", q.opts.ItemsKey, item.ItemID(), value)
+		rc.Send("ZADD", q.opts.PendingKey, now.Add(q.opts.Backoff(state.Attempts)).Unix(), item.ItemID())
+	}
+	_, err = rc.Do("EXEC")
+	if err != nil {
+		return fmt.Errorf("error recording failed %s: %s", q.opts.Name, err)
+	}
+	return nil
+}
+
+// DeadLetters returns the most recent items we gave up on, newest first
+func (q *Queue) DeadLetters(limit int) ([]Item, error) {
+	rc := q.rp.Get()
+	defer rc.Close()
+
+	values, err := redis.ByteSlices(rc.Do("LRANGE", q.opts.DeadLettersKey, 0, limit-1))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(values))
+	for _, value := range values {
+		item := q.handler.NewItem()
+		if err := json.Unmarshal(value, item); err == nil {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,180 @@
+package retries
+
+import (
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPool() *redis.Pool {
+	redisPool := &redis.Pool{
+		Wait:        true,
+		MaxActive:   5,
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Do("SELECT", 10)
+			return conn, err
+		},
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("FLUSHDB")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return redisPool
+}
+
+type testItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	State
+}
+
+func (i *testItem) ItemID() string { return i.ID }
+
+type testHandler struct {
+	attempted []string
+	failing   bool
+	gaveUp    []string
+}
+
+func (h *testHandler) NewItem() Item { return &testItem{} }
+
+func (h *testHandler) Attempt(item Item) error {
+	h.attempted = append(h.attempted, item.(*testItem).Name)
+	if h.failing {
+		return errors.New("boom")
+	}
+	return nil
+}
+
+func (h *testHandler) GiveUp(item Item) { h.gaveUp = append(h.gaveUp, item.(*testItem).Name) }
+
+var testOptions = &Options{
+	Name:           "test items",
+	ItemsKey:       "test:items",
+	PendingKey:     "test:pending",
+	DeadLettersKey: "test:dead_letters",
+	MaxAttempts:    3,
+	BaseBackoff:    10 * time.Second,
+	MaxBackoff:     time.Minute,
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, testOptions.Backoff(1))
+	assert.Equal(t, 20*time.Second, testOptions.Backoff(2))
+	assert.Equal(t, 40*time.Second, testOptions.Backoff(3))
+	assert.Equal(t, time.Minute, testOptions.Backoff(4))
+	assert.Equal(t, time.Minute, testOptions.Backoff(100))
+}
+
+func TestQueue(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	h := &testHandler{failing: true}
+	q := NewQueue(rp, testOptions, h)
+	now := time.Now()
+
+	// items aren't attempted until they're due
+	require.NoError(t, q.Add(&testItem{ID: "1", Name: "Bob"}, now.Add(time.Second)))
+	retried, err := q.RetryDue(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retried)
+
+	// failed attempts are recorded with the item, which is retried after its backoff
+	retried, err = q.RetryDue(now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retried)
+	assert.Equal(t, []string{"Bob"}, h.attempted)
+
+	due, err := redis.Int64(rc.Do("ZSCORE", "test:pending", "1"))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(time.Second+testOptions.Backoff(1)).Unix(), due)
+
+	// once it succeeds, it's removed
+	h.failing = false
+	retried, err = q.RetryDue(now.Add(time.Second + testOptions.Backoff(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retried)
+
+	count, _ := redis.Int(rc.Do("HLEN", "test:items"))
+	assert.Equal(t, 0, count)
+	count, _ = redis.Int(rc.Do("ZCARD", "test:pending"))
+	assert.Equal(t, 0, count)
+
+	// items which keep failing end up in our dead letters
+	h.failing = true
+	require.NoError(t, q.Failed(&testItem{ID: "2", Name: "Ann"}, errors.New("boom"), now))
+	for i := 1; i < testOptions.MaxAttempts; i++ {
+		retried, err = q.RetryDue(now.Add(time.Duration(i) * time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, retried)
+	}
+	assert.Equal(t, []string{"Ann"}, h.gaveUp)
+
+	count, _ = redis.Int(rc.Do("HLEN", "test:items"))
+	assert.Equal(t, 0, count)
+	count, _ = redis.Int(rc.Do("ZCARD", "test:pending"))
+	assert.Equal(t, 0, count)
+
+	dead, err := q.DeadLetters(10)
+	assert.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, "Ann", dead[0].(*testItem).Name)
+	assert.Equal(t, 3, dead[0].(*testItem).Attempts)
+	assert.Equal(t, "boom", dead[0].(*testItem).LastError)
+}
+
+func TestClaimLease(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	q := NewQueue(rp, testOptions, &testHandler{failing: true})
+	now := time.Now()
+	require.NoError(t, q.Add(&testItem{ID: "1", Name: "Bob"}, now))
+
+	// a claimed item stays queued, it just isn't due again until its lease is over
+	claimed, err := q.claim("1", now)
+	assert.NoError(t, err)
+	require.NotNil(t, claimed)
+	assert.Equal(t, "Bob", claimed.(*testItem).Name)
+
+	claimed, err = q.claim("1", now)
+	assert.NoError(t, err)
+	assert.Nil(t, claimed)
+
+	pending, _ := redis.Int(rc.Do("ZCARD", "test:pending"))
+	assert.Equal(t, 1, pending)
+
+	// so if we never record how our attempt went, it's retried once the lease is over
+	retried, err := q.RetryDue(now.Add(claimLease - time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retried)
+
+	retried, err = q.RetryDue(now.Add(claimLease + time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retried)
+}
+
+func TestStartStop(t *testing.T) {
+	q := NewQueue(getPool(), testOptions, &testHandler{})
+	q.Start()
+	q.Stop()
+}
@@ -0,0 +1,115 @@
+package courier
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how long the senders of a pool wait for another msg of their channel before stopping
+const sendPoolIdleTimeout = time.Minute
+
+// sendPool sends the msgs of one channel concurrently, starting senders as msgs come in up to the parallelism of the
+// channel. Our senders hand msgs to the pool and go back to popping, so a channel can have more msgs in flight than we
+// have senders.
+type sendPool struct {
+	foreman *Foreman
+	jobs    chan Msg
+
+	mutex   sync.Mutex
+	senders int
+}
+
+func newSendPool(foreman *Foreman) *sendPool {
+	return &sendPool{foreman: foreman, jobs: make(chan Msg)}
+}
+
+// poolMsg hands the passed in msg to the pool of its channel if its handler sends many msgs of the channel at once,
+// returning whether it did so. Otherwise the msg is left for the caller to send.
+func (f *Foreman) poolMsg(msg Msg) bool {
+	pooler, isPooler := f.server.GetHandler(msg.Channel().ChannelType()).(SendPooler)
+	if !isPooler {
+		return false
+	}
+	parallelism := pooler.SendParallelism(msg.Channel())
+	if parallelism <= 0 {
+		return false
+	}
+
+	f.poolsMutex.Lock()
+	pool, found := f.pools[msg.Channel().UUID()]
+	if !found {
+		pool = newSendPool(f)
+		f.pools[msg.Channel().UUID()] = pool
+	}
+	f.poolsMutex.Unlock()
+
+	return pool.submit(msg, parallelism)
+}
+
+// submit hands the passed in msg to an idle sender of the pool, starting one if we have fewer than the passed in
+// parallelism, or else waits for one to be free. It returns false if we're stopped before one is.
+func (p *sendPool) submit(msg Msg, parallelism int) bool {
+	for {
+		select {
+		case p.jobs <- msg:
+			return true
+		default:
+		}
+
+		p.mutex.Lock()
+		if p.senders < parallelism {
+			p.senders++
+			p.mutex.Unlock()
+			p.startSender(msg)
+			return true
+		}
+		p.mutex.Unlock()
+
+		// senders which were idle for too long may stop while we wait, so every so often check whether to start one
+		select {
+		case p.jobs <- msg:
+			return true
+		case <-p.foreman.quit:
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// startSender starts a sender of the pool with the passed in msg, which keeps sending the msgs handed to the pool until
+// it's been idle for a while or we're stopped
+func (p *sendPool) startSender(msg Msg) {
+	f := p.foreman
+	sender := NewSender(f, len(f.senders)+int(atomic.AddInt64(&f.poolSeq, 1)))
+
+	f.server.WaitGroup().Add(1)
+	go func() {
+		defer f.server.WaitGroup().Done()
+
+		for {
+			atomic.AddInt64(&f.sending, 1)
+			sender.sendMessage(msg)
+			atomic.AddInt64(&f.sending, -1)
+
+			select {
+			case msg = <-p.jobs:
+				continue
+			case <-f.quit:
+			case <-time.After(sendPoolIdleTimeout):
+			}
+
+			// take any msg handed to us while we were deciding to stop
+			p.mutex.Lock()
+			select {
+			case msg = <-p.jobs:
+				p.mutex.Unlock()
+				continue
+			default:
+				p.senders--
+				p.mutex.Unlock()
+				return
+			}
+		}
+	}()
+}
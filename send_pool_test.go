@@ -0,0 +1,103 @@
+package courier
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pooledHandler is a dummy handler which sends msgs from a pool, each send waiting until it's told to finish
+type pooledHandler struct {
+	dummyHandler
+	parallelism int
+	inFlight    int64
+	started     chan Msg
+	finish      chan bool
+}
+
+func (h *pooledHandler) ChannelName() string      { return "Pooled Handler" }
+func (h *pooledHandler) ChannelType() ChannelType { return ChannelType("PL") }
+
+func (h *pooledHandler) SendParallelism(channel Channel) int { return h.parallelism }
+
+func (h *pooledHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	atomic.AddInt64(&h.inFlight, 1)
+	defer atomic.AddInt64(&h.inFlight, -1)
+
+	h.started <- msg
+	<-h.finish
+	return h.backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired), nil
+}
+
+// noopBilling is a billing client which drops the msgs it's sent
+type noopBilling struct{}
+
+func (b *noopBilling) Send(msg billing.Message) error                         { return nil }
+func (b *noopBilling) SendAsync(msg billing.Message, pre func(), post func()) {}
+
+func TestSendPool(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "PL", "12345", "US", nil)
+	mb.AddChannel(channel)
+
+	// senders look up the handler the server is running
+	server := NewServer(NewConfig(), mb).(*server)
+	handler := &pooledHandler{dummyHandler: dummyHandler{server: server, backend: mb}, parallelism: 2, started: make(chan Msg, 10), finish: make(chan bool)}
+	server.activeHandlers[handler.ChannelType()] = handler
+	server.SetBilling(&noopBilling{})
+	foreman := NewForeman(server, 1)
+
+	queueMsg := func(urn urns.URN) Msg {
+		msg, err := mb.QueueOutgoingMsg(context.Background(), channel, urn, "hi", nil, nil, false, nil)
+		require.NoError(t, err)
+		return msg
+	}
+	waitStarted := func() {
+		select {
+		case <-handler.started:
+		case <-time.After(time.Second):
+			assert.Fail(t, "send never started")
+		}
+	}
+
+	// msgs are handed to the pool of their channel, which sends as many at once as its parallelism
+	assert.True(t, foreman.poolMsg(queueMsg("tel:+250788383381")))
+	assert.True(t, foreman.poolMsg(queueMsg("tel:+250788383382")))
+	waitStarted()
+	waitStarted()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&handler.inFlight))
+	assert.Equal(t, int64(2), foreman.Sending())
+
+	// the next waits for one of those to finish
+	submitted := make(chan bool)
+	go func() { submitted <- foreman.poolMsg(queueMsg("tel:+250788383383")) }()
+
+	select {
+	case <-submitted:
+		assert.Fail(t, "msg handed to a pool already sending all it can")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	handler.finish <- true
+	assert.True(t, <-submitted)
+	waitStarted()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&handler.inFlight))
+
+	handler.finish <- true
+	handler.finish <- true
+
+	// msgs of channels without parallelism are left to our senders
+	handler.parallelism = 0
+	assert.False(t, foreman.poolMsg(queueMsg("tel:+250788383384")))
+
+	// once stopped, the senders of the pool stop too
+	foreman.Stop()
+	server.WaitGroup().Wait()
+	assert.Equal(t, int64(0), foreman.Sending())
+}
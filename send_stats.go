@@ -0,0 +1,114 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/sirupsen/logrus"
+)
+
+// the format of the days we count sends on
+const sendStatsDayFormat = "2006-01-02"
+
+// SendStat is the number of msgs of a segment sent by a channel on a day, e.g.
+//
+//	{
+//	  "day": "2022-10-05",
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "segment": "template:revive_issue",
+//	  "count": 12
+//	}
+type SendStat struct {
+	Day         string      `json:"day"`
+	ChannelUUID ChannelUUID `json:"channel_uuid"`
+	Segment     string      `json:"segment"`
+	Count       int         `json:"count"`
+}
+
+// SendSegment returns the segment we count the passed in msg under, which is one of text, template:<name>,
+// interactive:<kind> or media:<type>
+func SendSegment(msg Msg) string {
+	metadata := msg.Metadata()
+	if len(metadata) > 0 {
+		if name, err := jsonparser.GetString(metadata, "templating", "template", "name"); err == nil && name != "" {
+			return "template:" + name
+		}
+	}
+
+	switch {
+	case msg.InteractionType() != "":
+		return "interactive:" + msg.InteractionType()
+	case len(msg.ListMessage().ListItems) > 0:
+		return "interactive:list"
+	case msg.SendCatalog():
+		return "interactive:catalog"
+	case len(msg.Products()) > 0:
+		return "interactive:product"
+	case len(msg.QuickReplies()) > 0:
+		return "interactive:quick_replies"
+	}
+
+	if len(msg.Attachments()) > 0 {
+		// attachments are content type and URL pairs, e.g. image/jpeg:https://example.com/foo.jpg
+		mediaType := strings.SplitN(msg.Attachments()[0], "/", 2)[0]
+		if mediaType == "" || strings.Contains(mediaType, ":") {
+			mediaType = "unknown"
+		}
+		return "media:" + mediaType
+	}
+
+	return "text"
+}
+
+// SortSendStats sorts the passed in stats by channel and then segment
+func SortSendStats(stats []*SendStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].ChannelUUID != stats[j].ChannelUUID {
+			return stats[i].ChannelUUID.String() < stats[j].ChannelUUID.String()
+		}
+		return stats[i].Segment < stats[j].Segment
+	})
+}
+
+// writeSendStat counts the passed in msg as sent, failing to do so is logged but doesn't affect the send
+func writeSendStat(ctx context.Context, b Backend, msg Msg) {
+	err := b.WriteSendStat(ctx, msg.Channel(), SendSegment(msg), time.Now())
+	if err != nil {
+		logrus.WithError(err).WithField("channel_uuid", msg.Channel().UUID()).Error("error writing send stat")
+	}
+}
+
+// handleSendStats returns the counts of sends on the day passed in as date, defaulting to today
+func (s *server) handleSendStats(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	day := time.Now().UTC()
+	if d := r.URL.Query().Get("date"); d != "" {
+		parsed, err := time.Parse(sendStatsDayFormat, d)
+		if err != nil {
+			WriteError(r.Context(), w, r, fmt.Errorf("invalid date: %s", d))
+			return
+		}
+		day = parsed
+	}
+
+	stats, err := s.backend.GetSendStats(r.Context(), day)
+	if err != nil {
+		logrus.WithError(err).Error("error getting send stats")
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	data := make([]interface{}, len(stats))
+	for i := range stats {
+		data[i] = stats[i]
+	}
+	WriteDataResponse(r.Context(), w, http.StatusOK, "Send Stats", data)
+}
@@ -0,0 +1,77 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendSegment(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+
+	newMsg := func(quickReplies []string, metadata string, attachments ...string) Msg {
+		msg := mb.NewOutgoingMsg(channel, MsgID(1), urns.URN("whatsapp:5511999999999"), "hi", false, quickReplies, "", 0, "", "")
+		if metadata != "" {
+			msg.WithMetadata(json.RawMessage(metadata))
+		}
+		for _, a := range attachments {
+			msg.WithAttachment(a)
+		}
+		return msg
+	}
+
+	assert.Equal(t, "text", SendSegment(newMsg(nil, "")))
+	assert.Equal(t, "template:revive_issue", SendSegment(newMsg([]string{"Yes"}, `{"templating": {"template": {"name": "revive_issue", "uuid": "171f8a4d-f725-46d7-85a6-11aceff0bfe3"}}}`)))
+	assert.Equal(t, "interactive:cta_url", SendSegment(newMsg(nil, `{"interaction_type": "cta_url"}`)))
+	assert.Equal(t, "interactive:catalog", SendSegment(newMsg(nil, `{"send_catalog": true}`)))
+	assert.Equal(t, "interactive:quick_replies", SendSegment(newMsg([]string{"Yes", "No"}, "")))
+	assert.Equal(t, "media:image", SendSegment(newMsg(nil, "", "image/jpeg:https://example.com/foo.jpg")))
+	assert.Equal(t, "media:unknown", SendSegment(newMsg(nil, "", "https://example.com/foo.jpg")))
+}
+
+func TestSendStats(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	channel1 := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+	channel2 := NewMockChannel("1e2da7b6-b6d6-4d60-a1b0-f2f4d8c8c4b1", "TG", "2020", "US", nil)
+
+	server := newAdminTestServer(t, config, mb)
+	defer server.Close()
+
+	ctx := context.Background()
+	today := time.Now()
+	yesterday := today.Add(-24 * time.Hour)
+	assert.NoError(t, mb.WriteSendStat(ctx, channel2, "text", today))
+	assert.NoError(t, mb.WriteSendStat(ctx, channel1, "text", today))
+	assert.NoError(t, mb.WriteSendStat(ctx, channel1, "template:revive_issue", today))
+	assert.NoError(t, mb.WriteSendStat(ctx, channel1, "template:revive_issue", today))
+	assert.NoError(t, mb.WriteSendStat(ctx, channel1, "text", yesterday))
+
+	stats, err := mb.GetSendStats(ctx, today)
+	assert.NoError(t, err)
+	day := today.UTC().Format("2006-01-02")
+	assert.Equal(t, []*SendStat{
+		{Day: day, ChannelUUID: channel2.UUID(), Segment: "text", Count: 1},
+		{Day: day, ChannelUUID: channel1.UUID(), Segment: "template:revive_issue", Count: 2},
+		{Day: day, ChannelUUID: channel1.UUID(), Segment: "text", Count: 1},
+	}, stats)
+
+	// stats can be listed for a day
+	status, body := server.request(http.MethodGet, "/c/stats/sends?date="+yesterday.UTC().Format("2006-01-02"), "", true)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"message":"Send Stats"`)
+	assert.Contains(t, body, `"segment":"text","count":1`)
+	assert.NotContains(t, body, "template")
+
+	status, body = server.request(http.MethodGet, "/c/stats/sends?date=yesterday", "", true)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, body, "invalid date: yesterday")
+}
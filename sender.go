@@ -3,21 +3,34 @@ package courier
 import (
 	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/courier/moderation"
 	"github.com/nyaruka/courier/utils"
 	"github.com/nyaruka/librato"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// how long msgs are put back for while the sends of their channel, or bulk sends, are paused
+const pauseRetryDelay = time.Minute
+
 // Foreman takes care of managing our set of sending workers and assigns msgs for each to send
 type Foreman struct {
 	server           Server
 	senders          []*Sender
 	availableSenders chan *Sender
 	quit             chan bool
+	pricing          PricingTable
+	sending          int64
+
+	pools      map[ChannelUUID]*sendPool
+	poolsMutex sync.Mutex
+	poolSeq    int64
 }
 
 // NewForeman creates a new Foreman for the passed in server with the number of max senders
@@ -27,6 +40,7 @@ func NewForeman(server Server, maxSenders int) *Foreman {
 		senders:          make([]*Sender, maxSenders),
 		availableSenders: make(chan *Sender, maxSenders),
 		quit:             make(chan bool),
+		pools:            make(map[ChannelUUID]*sendPool),
 	}
 
 	for i := 0; i < maxSenders; i++ {
@@ -53,6 +67,11 @@ func (f *Foreman) Stop() {
 	logrus.WithField("comp", "foreman").WithField("state", "stopping").Info("foreman stopping")
 }
 
+// Sending returns how many msgs our senders are currently sending
+func (f *Foreman) Sending() int64 {
+	return atomic.LoadInt64(&f.sending)
+}
+
 // Assign is our main loop for the Foreman, it takes care of popping the next outgoing messages from our
 // backend and assigning them to workers
 func (f *Foreman) Assign() {
@@ -66,6 +85,7 @@ func (f *Foreman) Assign() {
 	}).Info("senders started and waiting")
 
 	backend := f.server.Backend()
+	notifications := backend.OutgoingMsgNotifications()
 	lastSleep := false
 
 	for true {
@@ -77,6 +97,13 @@ func (f *Foreman) Assign() {
 
 		// otherwise, grab the next msg and assign it to a sender
 		case sender := <-f.availableSenders:
+			// if we're draining, leave new msgs for other instances and let our senders finish what they have
+			if f.server.Draining() {
+				f.availableSenders <- sender
+				time.Sleep(250 * time.Millisecond)
+				continue
+			}
+
 			// see if we have a message to work on
 			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 			msg, err := backend.PopNextOutgoingMsg(ctx)
@@ -98,7 +125,12 @@ func (f *Foreman) Assign() {
 					lastSleep = true
 				}
 				f.availableSenders <- sender
-				time.Sleep(250 * time.Millisecond)
+
+				// but wake up early if our backend tells us new messages were queued
+				select {
+				case <-notifications:
+				case <-time.After(250 * time.Millisecond):
+				}
 			}
 		}
 	}
@@ -144,7 +176,14 @@ func (w *Sender) Start() {
 				return
 			}
 
+			// msgs of channels which send many at once are handed to the pool of their channel, freeing us for the next
+			if w.foreman.poolMsg(msg) {
+				continue
+			}
+
+			atomic.AddInt64(&w.foreman.sending, 1)
 			w.sendMessage(msg)
+			atomic.AddInt64(&w.foreman.sending, -1)
 		}
 	}()
 }
@@ -155,7 +194,7 @@ func (w *Sender) Stop() {
 }
 
 func (w *Sender) sendMessage(msg Msg) {
-	log := logrus.WithField("comp", "sender").WithField("sender_id", w.id).WithField("channel_uuid", msg.Channel().UUID())
+	log := logrus.WithField("comp", "sender").WithField("sender_id", w.id).WithField("channel_uuid", msg.Channel().UUID()).WithField("channel_type", msg.Channel().ChannelType()).WithField("step", "send")
 
 	var status MsgStatus
 	server := w.foreman.server
@@ -200,6 +239,98 @@ func (w *Sender) sendMessage(msg Msg) {
 		log.WithError(err).Error("error looking up msg loop")
 	}
 
+	// have the sends of this channel, or of bulk msgs like this one, been paused?
+	var pause *ChannelPause
+	if !sent && !loop {
+		pause, err = GetMsgPause(backend.RedisPool(), msg)
+		if err != nil {
+			log.WithError(err).Error("error looking up channel pause")
+		}
+	}
+
+	// msgs wait on their queue while paused, rather than failing, so they're sent once resumed
+	if pause != nil {
+		err = backend.DeferOutgoingMsg(sendCTX, msg, time.Now().Add(pauseRetryDelay))
+		if err == nil {
+			log.WithField("reason", pause.Reason).WithField("bulk_only", pause.BulkOnly).Info("sends paused, deferring msg")
+			return
+		}
+		log.WithError(err).Error("error deferring paused msg, erroring it")
+	}
+
+	// has the vendor asked the sends of this channel to back off?
+	var backoff time.Duration
+	if !sent && !loop && pause == nil {
+		backoff, err = GetChannelBackoff(backend.RedisPool(), msg.Channel().UUID())
+		if err != nil {
+			log.WithError(err).Error("error looking up channel backoff")
+		}
+	}
+
+	// msgs sent while the channel backs off for longer than we're willing to wait are put back until it's over
+	if backoff > maxChannelBackoffWait {
+		until := time.Now().Add(backoff)
+		err = backend.DeferOutgoingMsg(sendCTX, msg, until)
+		if err == nil {
+			log.WithField("until", until).Info("channel throttled by vendor, deferring msg")
+			return
+		}
+		log.WithError(err).Error("error deferring throttled msg, erroring it")
+	}
+
+	// should this msg be blocked by moderation? no point asking if we won't be sending it anyway
+	var verdict *moderation.Verdict
+	if !sent && !loop && pause == nil && backoff <= maxChannelBackoffWait {
+		verdict = w.moderateMessage(sendCTX, msg)
+	}
+
+	// templates beyond the messaging limit of the channel are put back to be sent once it can open more conversations
+	if !sent && !loop && pause == nil && backoff <= maxChannelBackoffWait && (verdict == nil || !verdict.Blocked) {
+		if until := w.paceTemplate(msg); !until.IsZero() {
+			err = backend.DeferOutgoingMsg(sendCTX, msg, until)
+			if err == nil {
+				log.WithField("until", until).Info("channel at its messaging limit, deferring template")
+				return
+			}
+			log.WithError(err).Error("error deferring template, sending it now")
+		}
+	}
+
+	// if the channel is rate limited, take our turn to send, shared with our other instances. Msgs whose turn isn't
+	// coming up shortly are put back until it does rather than holding up this sender.
+	rateWait, rateTaken := time.Duration(0), true
+	if !sent && !loop && pause == nil && backoff <= maxChannelBackoffWait && (verdict == nil || !verdict.Blocked) {
+		if rate := ChannelMaxMsgsPerSecond(msg.Channel()); rate > 0 {
+			rateWait, rateTaken, err = TakeChannelToken(backend.RedisPool(), msg.Channel().UUID(), rate, time.Now(), maxChannelRateWait)
+			if err != nil {
+				log.WithError(err).Error("error taking channel rate token")
+				rateWait, rateTaken = 0, true
+			}
+		}
+	}
+	if !rateTaken {
+		until := time.Now().Add(rateWait)
+		err = backend.DeferOutgoingMsg(sendCTX, msg, until)
+		if err == nil {
+			log.WithField("until", until).Debug("channel rate limited, deferring msg")
+			return
+		}
+		log.WithError(err).Error("error deferring rate limited msg, erroring it")
+	}
+
+	// translate our text if our channel wants that, failing to do so doesn't block sending. A translation can reintroduce
+	// what moderation blocks so it's moderated again, as that's the text we'll actually send.
+	if !sent && !loop && pause == nil && backoff <= maxChannelBackoffWait && rateTaken && (verdict == nil || !verdict.Blocked) {
+		original := msg.Text()
+		err = TranslateOutgoingMsg(sendCTX, server.Translator(), msg)
+		if err != nil {
+			log.WithError(err).Error("error translating msg")
+		}
+		if msg.Text() != original {
+			verdict = w.moderateMessage(sendCTX, msg)
+		}
+	}
+
 	if sent {
 		// if this message was already sent, create a wired status for it
 		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
@@ -209,6 +340,39 @@ func (w *Sender) sendMessage(msg Msg) {
 		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
 		status.AddLog(NewChannelLogFromError("Message Loop", msg.Channel(), msg.ID(), 0, fmt.Errorf("message loop detected, failing message without send")))
 		log.Error("message loop detected, failing message")
+	} else if pause != nil {
+		// if we couldn't put a paused message back, error it without sending so it's retried later
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+		status.AddLog(NewChannelLogFromError("Channel Paused", msg.Channel(), msg.ID(), 0, fmt.Errorf("sends of channel paused since %s (%s)", pause.PausedOn.Format(time.RFC3339), pause.Reason)))
+		log.WithField("reason", pause.Reason).Warning("channel sends paused, erroring message")
+	} else if backoff > maxChannelBackoffWait {
+		// if we couldn't put back a message the vendor asked us to back off from, error it to be retried later
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+		status.SetErrorClass(ErrorClassRateLimit)
+		status.AddLog(NewChannelLogFromError("Channel Throttled", msg.Channel(), msg.ID(), 0, fmt.Errorf("sends of channel backing off for %s as asked by vendor", backoff.Round(time.Second))))
+		log.WithField("backoff", backoff).Warning("channel throttled by vendor, erroring message")
+	} else if !rateTaken {
+		// if we couldn't put back a message the channel's rate limit wouldn't let us send yet, error it to be retried later
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+		status.SetErrorClass(ErrorClassRateLimit)
+		status.AddLog(NewChannelLogFromError("Channel Rate Limited", msg.Channel(), msg.ID(), 0, fmt.Errorf("sends of channel limited to %g per second, next send in %s", ChannelMaxMsgsPerSecond(msg.Channel()), rateWait.Round(time.Millisecond))))
+		log.WithField("wait", rateWait).Warning("channel rate limited, erroring message")
+	} else if verdict != nil && verdict.Blocked {
+		// if moderation blocked this message, fail it without sending and let others know why
+		status = backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgFailed)
+		status.SetErrorClass(ErrorClassContentRejected)
+		status.AddLog(NewChannelLogFromError("Message Moderated", msg.Channel(), msg.ID(), 0, fmt.Errorf("%s: %s", moderation.ReasonModerated, verdict.Reason)))
+		log.WithField("reason", verdict.Reason).Warning("message blocked by moderation, failing message")
+
+		event := backend.NewChannelEvent(msg.Channel(), MsgModerated, msg.URN()).WithExtra(map[string]interface{}{
+			"msg_id": msg.ID().String(),
+			"reason": moderation.ReasonModerated,
+			"detail": verdict.Reason,
+		})
+		err = backend.WriteChannelEvent(sendCTX, event)
+		if err != nil {
+			log.WithError(err).Error("error writing moderation event")
+		}
 	} else {
 
 		waitMediaChannels := w.foreman.server.Config().WaitMediaChannels
@@ -270,6 +434,17 @@ func (w *Sender) sendMessage(msg Msg) {
 
 		nsendCTX, ncancel := context.WithTimeout(context.Background(), time.Second*35)
 		defer ncancel()
+
+		// wait out any short backoff the vendor asked for
+		if backoff > 0 {
+			time.Sleep(backoff)
+		}
+
+		// and for our turn to send if the channel is rate limited
+		if rateWait > 0 {
+			time.Sleep(rateWait)
+		}
+
 		// send our message
 		status, err = server.SendMsg(nsendCTX, msg)
 		duration := time.Now().Sub(start)
@@ -283,17 +458,48 @@ func (w *Sender) sendMessage(msg Msg) {
 			}
 		}
 
+		// classify why the send failed, failing rather than retrying msgs which can't succeed as they are
+		errorClass := ClassifyMsgStatus(status)
+		if errorClass != NilErrorClass {
+			status.SetErrorClass(errorClass)
+			log = log.WithField("error_class", errorClass)
+
+			if status.Status() == MsgErrored && !errorClass.Retryable() {
+				status.SetStatus(MsgFailed)
+			}
+		}
+
 		// report to librato and log locally
+		heartbeat := ChannelHeartbeatOK
 		if status.Status() == MsgErrored || status.Status() == MsgFailed {
 			log.WithField("elapsed", duration).Warning("msg errored")
 			librato.Gauge(fmt.Sprintf("courier.msg_send_error_%s", msg.Channel().ChannelType()), secondDuration)
+			metrics.RecordMsgErrored(string(msg.Channel().ChannelType()), string(msg.Priority()), string(errorClass))
+			heartbeat = ChannelHeartbeatError
 		} else {
 			log.WithField("elapsed", duration).Info("msg sent")
 			librato.Gauge(fmt.Sprintf("courier.msg_send_%s", msg.Channel().ChannelType()), secondDuration)
+			metrics.RecordMsgSent(string(msg.Channel().ChannelType()), string(msg.Priority()))
 		}
+		metrics.RecordHandlerDuration(string(msg.Channel().ChannelType()), metrics.ActionSend, duration)
+
+		// record how this channel is doing
+		err = backend.WriteChannelHeartbeat(sendCTX, msg.Channel(), heartbeat)
+		if err != nil {
+			log.WithError(err).Info("error writing channel heartbeat")
+		}
+
+		// and pause it if its credentials keep being rejected
+		w.trackAuthFailures(msg, status)
+
+		// and back off if the vendor asked us to
+		w.trackBackoff(msg, status)
 
 		// update last seen on if message is no error and no fail
 		if status.Status() != MsgErrored && status.Status() != MsgFailed {
+			estimate := w.estimateCost(sendCTX, msg)
+			writeSendStat(sendCTX, backend, msg)
+
 			if msg.Channel().ChannelType() != "WAC" {
 				ctt, err := w.foreman.server.Backend().GetContact(context.Background(), msg.Channel(), msg.URN(), "", "")
 				if err != nil {
@@ -304,18 +510,25 @@ func (w *Sender) sendMessage(msg Msg) {
 					if err != nil {
 						log.WithError(err).Info("error updating contact last seen on")
 					}
+					now := time.Now()
 					billingMsg := billing.NewMessage(
 						string(msg.URN().Identity()),
 						ctt.UUID().String(),
 						msg.Channel().UUID().String(),
 						msg.ExternalID(),
-						time.Now().Format(time.RFC3339),
+						now.Format(time.RFC3339),
 						"O",
 						msg.Channel().ChannelType().String(),
 						msg.Text(),
 						msg.Attachments(),
 						msg.QuickReplies(),
 					)
+					if estimate != nil {
+						billingMsg.EstimatedCost = &estimate.Cost
+						billingMsg.CostCurrency = estimate.Currency
+					}
+					billingMsg.SetChannelContext(now, ChannelLocation(msg.Channel()), msg.Channel().Country())
+					SetBillingRouting(msg.Channel(), &billingMsg)
 					w.foreman.server.Billing().SendAsync(billingMsg, nil, nil)
 				}
 			}
@@ -330,6 +543,7 @@ func (w *Sender) sendMessage(msg Msg) {
 	if err != nil {
 		log.WithError(err).Info("error writing msg status")
 	}
+	publishStatus(server, status)
 
 	// write our logs as well
 	err = backend.WriteChannelLogs(writeCTX, status.Logs())
@@ -340,3 +554,39 @@ func (w *Sender) sendMessage(msg Msg) {
 	// mark our send task as complete
 	backend.MarkOutgoingMsgComplete(writeCTX, msg, status)
 }
+
+// moderateMessage checks the passed in msg against our moderator, if any. Moderation errors don't block sending.
+func (w *Sender) moderateMessage(ctx context.Context, msg Msg) *moderation.Verdict {
+	moderator := w.foreman.server.Moderator()
+	if moderator == nil || msg.Text() == "" {
+		return nil
+	}
+
+	verdict, err := moderator.Moderate(ctx, moderation.Request{
+		ChannelUUID: msg.Channel().UUID().String(),
+		ChannelType: msg.Channel().ChannelType().String(),
+		URN:         msg.URN().Identity().String(),
+		Text:        msg.Text(),
+	})
+	if err != nil {
+		logrus.WithError(err).WithField("comp", "sender").WithField("msg_id", msg.ID().String()).Error("error moderating msg")
+		return nil
+	}
+	return verdict
+}
+
+// estimateCost looks up the estimated cost of the passed in sent msg in our pricing table and records it
+func (w *Sender) estimateCost(ctx context.Context, msg Msg) *MsgCostEstimate {
+	category := CategoryForMsg(msg)
+	cost, found := w.foreman.pricing.Estimate(msg.Channel().ChannelType(), msg.Channel().Country(), category)
+	if !found {
+		return nil
+	}
+
+	estimate := &MsgCostEstimate{Cost: cost, Currency: w.foreman.server.Config().PricingCurrency, Category: category}
+	err := w.foreman.server.Backend().WriteMsgCostEstimate(ctx, msg, estimate)
+	if err != nil {
+		logrus.WithError(err).WithField("comp", "sender").WithField("msg_id", msg.ID().String()).Error("error writing msg cost estimate")
+	}
+	return estimate
+}
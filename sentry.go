@@ -0,0 +1,141 @@
+package courier
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/evalphobia/logrus_sentry"
+	"github.com/getsentry/raven-go"
+	"github.com/sirupsen/logrus"
+)
+
+// log fields which are sent to Sentry as tags rather than extra data, so events can be searched and grouped by them
+var sentryTagFields = []string{"channel_uuid", "channel_type", "msg_id", "step", "comp"}
+
+// how long the window our per signature Sentry rate limit is applied over is
+const sentryRateWindow = time.Minute
+
+// numbers in error messages are usually IDs which would otherwise make every error its own signature
+var sentrySignatureNumbers = regexp.MustCompile(`\d+`)
+
+// sentryWindow tracks the events sent for a signature in the current window
+type sentryWindow struct {
+	start      time.Time
+	sent       int
+	suppressed int
+}
+
+// SentryHook is a logrus hook which tags entries with the channel and msg they relate to before passing them on to
+// Sentry, dropping entries once too many with the same signature have been sent within a minute
+type SentryHook struct {
+	hook  logrus.Hook
+	limit int
+
+	mutex   sync.Mutex
+	windows map[string]*sentryWindow
+}
+
+// NewSentryHook creates a new hook reporting to the Sentry DSN in the passed in config
+func NewSentryHook(config *Config) (*SentryHook, error) {
+	hook, err := logrus_sentry.NewSentryHook(config.SentryDSN, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel})
+	if err != nil {
+		return nil, err
+	}
+	hook.Timeout = 0
+	hook.StacktraceConfiguration.Enable = true
+	hook.StacktraceConfiguration.Skip = 5
+	hook.StacktraceConfiguration.Context = 5
+
+	return newSentryHook(hook, config.SentryRateLimit), nil
+}
+
+// newSentryHook wraps the passed in hook, sending at most limit entries per signature per minute, 0 meaning no limit
+func newSentryHook(hook logrus.Hook, limit int) *SentryHook {
+	return &SentryHook{hook: hook, limit: limit, windows: make(map[string]*sentryWindow)}
+}
+
+// Levels returns the levels of the hook we wrap
+func (h *SentryHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+// Fire tags the passed in entry and passes it on if we are under our rate limit for its signature
+func (h *SentryHook) Fire(entry *logrus.Entry) error {
+	send, suppressed := h.allow(sentrySignature(entry), entry.Time)
+	if !send {
+		return nil
+	}
+
+	// the fields are shared with the entry this was derived from so we build our own
+	data := make(logrus.Fields, len(entry.Data)+2)
+	tags := raven.Tags{}
+	for k, v := range entry.Data {
+		data[k] = v
+	}
+	for _, field := range sentryTagFields {
+		if v, found := entry.Data[field]; found {
+			tags = append(tags, raven.Tag{Key: field, Value: fmt.Sprint(v)})
+			delete(data, field)
+		}
+	}
+	if len(tags) > 0 {
+		data["tags"] = tags
+	}
+	if suppressed > 0 {
+		data["suppressed"] = suppressed
+	}
+
+	tagged := *entry
+	tagged.Data = data
+	return h.hook.Fire(&tagged)
+}
+
+// allow returns whether an entry with the passed in signature should be sent, and how many with that signature were
+// dropped in the previous window
+func (h *SentryHook) allow(signature string, now time.Time) (bool, int) {
+	if h.limit <= 0 {
+		return true, 0
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	window, found := h.windows[signature]
+	if !found || now.Sub(window.start) >= sentryRateWindow {
+		suppressed := 0
+		if found {
+			suppressed = window.suppressed
+		}
+		h.expireWindows(now)
+		h.windows[signature] = &sentryWindow{start: now, sent: 1}
+		return true, suppressed
+	}
+
+	if window.sent >= h.limit {
+		window.suppressed++
+		return false, 0
+	}
+	window.sent++
+	return true, 0
+}
+
+// expireWindows removes windows which have ended, dropping their suppressed counts
+func (h *SentryHook) expireWindows(now time.Time) {
+	for signature, window := range h.windows {
+		if now.Sub(window.start) >= 2*sentryRateWindow {
+			delete(h.windows, signature)
+		}
+	}
+}
+
+// sentrySignature returns the signature of the passed in entry, entries with the same signature are rate limited together
+func sentrySignature(entry *logrus.Entry) string {
+	errText := ""
+	if err, isErr := entry.Data[logrus.ErrorKey].(error); isErr {
+		errText = err.Error()
+	}
+	signature := fmt.Sprintf("%s|%v|%s|%s", entry.Level, entry.Data["channel_type"], entry.Message, errText)
+	return sentrySignatureNumbers.ReplaceAllString(signature, "#")
+}
@@ -0,0 +1,77 @@
+package courier
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/getsentry/raven-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// capturingHook records the entries fired at it
+type capturingHook struct {
+	entries []*logrus.Entry
+}
+
+func (h *capturingHook) Levels() []logrus.Level { return logrus.AllLevels }
+func (h *capturingHook) Fire(entry *logrus.Entry) error {
+	h.entries = append(h.entries, entry)
+	return nil
+}
+
+func TestSentryHook(t *testing.T) {
+	captured := &capturingHook{}
+	hook := newSentryHook(captured, 2)
+
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	newEntry := func(msgID int, channelType string, err error) *logrus.Entry {
+		entry := logrus.WithFields(logrus.Fields{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "channel_type": channelType, "msg_id": msgID, "step": "send", "url": "https://example.com"}).WithError(err)
+		entry.Time = now
+		entry.Level = logrus.ErrorLevel
+		entry.Message = "error sending message"
+		return entry
+	}
+
+	// entries are tagged with the channel and msg they relate to
+	entry := newEntry(1001, "WAC", errors.New("error sending msg 1001"))
+	assert.NoError(t, hook.Fire(entry))
+	assert.Len(t, captured.entries, 1)
+	assert.Equal(t, raven.Tags{
+		{Key: "channel_uuid", Value: "dbc126ed-66bc-4e28-b67b-81dc3327c95d"},
+		{Key: "channel_type", Value: "WAC"},
+		{Key: "msg_id", Value: "1001"},
+		{Key: "step", Value: "send"},
+	}, captured.entries[0].Data["tags"])
+	assert.Equal(t, "https://example.com", captured.entries[0].Data["url"])
+	assert.NotContains(t, captured.entries[0].Data, "channel_uuid")
+
+	// without modifying the entry passed in
+	assert.Equal(t, "WAC", entry.Data["channel_type"])
+	assert.NotContains(t, entry.Data, "tags")
+
+	// errors which only differ by IDs share a signature, so after our limit they are dropped
+	hook.Fire(newEntry(1002, "WAC", errors.New("error sending msg 1002")))
+	hook.Fire(newEntry(1003, "WAC", errors.New("error sending msg 1003")))
+	hook.Fire(newEntry(1004, "WAC", errors.New("error sending msg 1004")))
+	assert.Len(t, captured.entries, 2)
+
+	// other channel types have their own signature
+	hook.Fire(newEntry(1005, "FBA", errors.New("error sending msg 1005")))
+	assert.Len(t, captured.entries, 3)
+
+	// once our window has passed they are sent again, with the number we dropped
+	now = now.Add(sentryRateWindow)
+	hook.Fire(newEntry(1006, "WAC", errors.New("error sending msg 1006")))
+	assert.Len(t, captured.entries, 4)
+	assert.Equal(t, 2, captured.entries[3].Data["suppressed"])
+
+	// no limit means everything is sent
+	captured = &capturingHook{}
+	hook = newSentryHook(captured, 0)
+	for i := 0; i < 5; i++ {
+		hook.Fire(newEntry(i, "WAC", errors.New("error sending msg")))
+	}
+	assert.Len(t, captured.entries, 5)
+}
@@ -21,7 +21,14 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/jmoiron/sqlx"
 	"github.com/nyaruka/courier/billing"
+	"github.com/nyaruka/courier/extraction"
+	"github.com/nyaruka/courier/feedback"
+	"github.com/nyaruka/courier/metrics"
+	"github.com/nyaruka/courier/moderation"
+	"github.com/nyaruka/courier/tasks"
+	"github.com/nyaruka/courier/translation"
 	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/courier/webhooks"
 	"github.com/nyaruka/gocommon/storage"
 	"github.com/nyaruka/librato"
 	"github.com/pkg/errors"
@@ -33,7 +40,10 @@ import (
 type Server interface {
 	Config() *Config
 
+	AddHandler(handler ChannelHandler)
+	GetHandler(ChannelType) ChannelHandler
 	AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc)
+	AddHandlerSharedRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc)
 
 	SendMsg(context.Context, Msg) (MsgStatus, error)
 
@@ -48,8 +58,37 @@ type Server interface {
 	Start() error
 	Stop() error
 
+	Drain()
+	Draining() bool
+	DrainChan() chan bool
+
 	SetBilling(billing.Client)
 	Billing() billing.Client
+
+	SetFeedback(feedback.Client)
+	Feedback() feedback.Client
+
+	// SetModerator must be called before Start as senders read the moderator without locking
+	SetModerator(moderation.Moderator)
+	Moderator() moderation.Moderator
+
+	// SetTranslator must be called before Start for the same reason
+	SetTranslator(translation.Translator)
+	Translator() translation.Translator
+
+	SetExtractor(extraction.Extractor)
+	Extractor() extraction.Extractor
+
+	SetWebhookRelay(*webhooks.Relay)
+	WebhookRelay() *webhooks.Relay
+
+	// SetTaskQueue must be called before Start as the funcs which run tasks are registered on it then
+	SetTaskQueue(*tasks.Queue)
+	TaskQueue() *tasks.Queue
+	RegisterTaskFunc(string, tasks.Func)
+
+	SetConfigLoader(ConfigLoader)
+	ReloadConfig(context.Context, string) (*ConfigReload, error)
 }
 
 // NewServer creates a new Server for the passed in configuration. The server will have to be started
@@ -60,10 +99,35 @@ func NewServer(config *Config, backend Backend) Server {
 	return NewServerWithLogger(config, backend, logger)
 }
 
+// NewEmbeddedServer creates a new Server for running courier inside another process. It doesn't listen for HTTP
+// requests itself, instead its Router should be mounted on the HTTP server of that process.
+func NewEmbeddedServer(config *Config, backend Backend) Server {
+	s := NewServerWithLogger(config, backend, logrus.New()).(*server)
+	s.embedded = true
+	return s
+}
+
 // NewServerWithLogger creates a new Server for the passed in configuration. The server will have to be started
 // afterwards, which is when configuration options are checked.
 func NewServerWithLogger(config *Config, backend Backend, logger *logrus.Logger) Server {
+	s := &server{
+		config:  config,
+		backend: backend,
+
+		handlers:       RegisteredHandlers(),
+		activeHandlers: make(map[ChannelType]ChannelHandler),
+
+		stopChan:  make(chan bool),
+		waitGroup: &sync.WaitGroup{},
+		stopped:   false,
+		drain:     newDrainState(),
+
+		// until we're given one in Redis, our tasks are run as soon as they're queued
+		taskQueue: tasks.NewQueue(nil),
+	}
+
 	router := chi.NewRouter()
+	router.Use(s.trackInFlight)
 	router.Use(middleware.Compress(flate.DefaultCompression))
 	router.Use(middleware.StripSlashes)
 	router.Use(middleware.RequestID)
@@ -74,17 +138,9 @@ func NewServerWithLogger(config *Config, backend Backend, logger *logrus.Logger)
 	chanRouter := chi.NewRouter()
 	router.Mount("/c/", chanRouter)
 
-	return &server{
-		config:  config,
-		backend: backend,
-
-		router:     router,
-		chanRouter: chanRouter,
-
-		stopChan:  make(chan bool),
-		waitGroup: &sync.WaitGroup{},
-		stopped:   false,
-	}
+	s.router = router
+	s.chanRouter = chanRouter
+	return s
 }
 
 // Start starts the Server listening for incoming requests and sending messages. It will return an error
@@ -94,6 +150,12 @@ func (s *server) Start() error {
 	// set our user agent, needs to happen before we do anything so we don't change have threading issues
 	utils.HTTPUserAgent = fmt.Sprintf("Courier/%s", s.config.Version)
 
+	// make sure our pricing table is valid before we start sending with it
+	pricing, err := ParsePricingTable(s.config.PricingTable)
+	if err != nil {
+		return err
+	}
+
 	// configure librato if we have configuration options for it
 	host, _ := os.Hostname()
 	if s.config.LibratoUsername != "" {
@@ -101,8 +163,18 @@ func (s *server) Start() error {
 		librato.Start()
 	}
 
+	// our backend looks up the handlers of this server rather than the registered ones
+	if setter, isSetter := s.backend.(HandlerLookupSetter); isSetter {
+		setter.SetHandlerLookup(s.GetHandler)
+	}
+
+	// and lets us know which msgs it has handled so we can mark them as read
+	if setter, isSetter := s.backend.(MsgHandledHookSetter); isSetter {
+		setter.SetMsgHandledHook(s.msgHandled)
+	}
+
 	// start our backend
-	err := s.backend.Start()
+	err = s.backend.Start()
 	if err != nil {
 		return err
 	}
@@ -110,37 +182,71 @@ func (s *server) Start() error {
 	// start our spool flushers
 	startSpoolFlushers(s)
 
+	// the text of the attachments of msgs is extracted once they're written, outside of their webhooks
+	s.RegisterTaskFunc(ExtractAttachmentTextTask, s.extractAttachmentText)
+
 	// wire up our main pages
 	s.router.NotFound(s.handle404)
 	s.router.MethodNotAllowed(s.handle405)
 	s.router.Get("/", s.handleIndex)
 	s.router.Get("/status", s.handleStatus)
+	s.router.Get("/c/audit", s.handleAudit)
+	s.router.Get("/c/export/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleExport)
+	s.router.Get("/c/logs/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelLogs)
+	s.router.Get("/c/drain", s.handleDrain)
+	s.router.Post("/c/drain", s.handleDrain)
 	s.router.Get("/c/health", s.handleCHealth)
+	if s.config.PrometheusMetrics {
+		s.router.Get("/metrics", s.handleMetrics)
+	}
+	s.router.Get("/c/stats/sends", s.handleSendStats)
+	s.router.Get("/c/pause/bulk", s.handleBulkPause)
+	s.router.Post("/c/pause/bulk", s.handleBulkPause)
+	s.router.Delete("/c/pause/bulk", s.handleBulkPause)
+	s.router.Get("/c/pause/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelPause)
+	s.router.Post("/c/pause/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelPause)
+	s.router.Delete("/c/pause/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelPause)
+	s.router.Get("/c/health/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelHealth)
+	s.router.Get("/c/alerts/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}", s.handleChannelAlerts)
+	s.router.Get("/admin/channels/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}/config", s.handleChannelConfig)
+	s.router.Post("/admin/config/reload", s.handleConfigReload)
+	s.router.Post("/c/test/{uuid:[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}}/receive", s.handleTestReceive)
 
 	// initialize our handlers
 	s.initializeChannelHandlers()
 
-	// configure timeouts on our server
-	s.httpServer = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", s.config.Address, s.config.Port),
-		Handler:      s.router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	// start handling any webhooks we defer
+	if s.config.DeferWebhooks {
+		err = s.startDeferredConsumer()
+		if err != nil {
+			return err
+		}
 	}
 
-	// and start serving HTTP
-	go func() {
-		s.waitGroup.Add(1)
-		defer s.waitGroup.Done()
-		err := s.httpServer.ListenAndServe()
-		if err != nil && err != http.ErrServerClosed {
-			logrus.WithFields(logrus.Fields{
-				"comp":  "server",
-				"state": "stopping",
-				"err":   err,
-			}).Error()
+	// when embedded, the process we're running in serves our router
+	if !s.embedded {
+		// configure timeouts on our server
+		s.httpServer = &http.Server{
+			Addr:         fmt.Sprintf("%s:%d", s.config.Address, s.config.Port),
+			Handler:      s.router,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
 		}
-	}()
+
+		// and start serving HTTP
+		go func() {
+			s.waitGroup.Add(1)
+			defer s.waitGroup.Done()
+			err := s.httpServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logrus.WithFields(logrus.Fields{
+					"comp":  "server",
+					"state": "stopping",
+					"err":   err,
+				}).Error()
+			}
+		}()
+	}
 
 	// start our heartbeat
 	go func() {
@@ -160,6 +266,9 @@ func (s *server) Start() error {
 		}
 	}()
 
+	// start watching for us using too many resources
+	s.startOverloadMonitor()
+
 	logrus.WithFields(logrus.Fields{
 		"comp":    "server",
 		"port":    s.config.Port,
@@ -169,6 +278,7 @@ func (s *server) Start() error {
 
 	// start our foreman for outgoing messages
 	s.foreman = NewForeman(s, s.config.MaxWorkers)
+	s.foreman.pricing = pricing
 	s.foreman.Start()
 
 	return nil
@@ -183,8 +293,10 @@ func (s *server) Stop() error {
 	s.foreman.Stop()
 
 	// shut down our HTTP server
-	if err := s.httpServer.Shutdown(context.Background()); err != nil {
-		log.WithField("state", "stopping").WithError(err).Error("error shutting down server")
+	if s.httpServer != nil {
+		if err := s.httpServer.Shutdown(context.Background()); err != nil {
+			log.WithField("state", "stopping").WithError(err).Error("error shutting down server")
+		}
 	}
 
 	// stop everything
@@ -212,18 +324,23 @@ func (s *server) Stop() error {
 
 func (s *server) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
 	// find the handler for this message type
-	handler, found := activeHandlers[msg.Channel().ChannelType()]
+	handler, found := s.activeHandlers[msg.Channel().ChannelType()]
 	if !found {
 		return nil, fmt.Errorf("unable to find handler for channel type: %s", msg.Channel().ChannelType())
 	}
 
+	// channels in test mode never reach their vendor
+	if TestModeEnabled(msg.Channel()) {
+		return sendTestMsg(s.backend, msg), nil
+	}
+
 	// have the handler send it
 	return handler.SendMsg(ctx, msg)
 }
 
 func (s *server) WaitGroup() *sync.WaitGroup { return s.waitGroup }
 func (s *server) StopChan() chan bool        { return s.stopChan }
-func (s *server) Config() *Config            { return s.config }
+func (s *server) Config() *Config            { return s.config.Current() }
 func (s *server) Stopped() bool              { return s.stopped }
 
 func (s *server) Backend() Backend   { return s.backend }
@@ -232,6 +349,27 @@ func (s *server) Router() chi.Router { return s.router }
 func (s *server) Billing() billing.Client          { return s.billing }
 func (s *server) SetBilling(client billing.Client) { s.billing = client }
 
+func (s *server) Feedback() feedback.Client          { return s.feedback }
+func (s *server) SetFeedback(client feedback.Client) { s.feedback = client }
+
+func (s *server) Moderator() moderation.Moderator             { return s.moderator }
+func (s *server) SetModerator(moderator moderation.Moderator) { s.moderator = moderator }
+
+func (s *server) Translator() translation.Translator              { return s.translator }
+func (s *server) SetTranslator(translator translation.Translator) { s.translator = translator }
+
+func (s *server) Extractor() extraction.Extractor             { return s.extractor }
+func (s *server) SetExtractor(extractor extraction.Extractor) { s.extractor = extractor }
+
+func (s *server) WebhookRelay() *webhooks.Relay         { return s.webhookRelay }
+func (s *server) SetWebhookRelay(relay *webhooks.Relay) { s.webhookRelay = relay }
+
+func (s *server) TaskQueue() *tasks.Queue         { return s.taskQueue }
+func (s *server) SetTaskQueue(queue *tasks.Queue) { s.taskQueue = queue }
+
+// RegisterTaskFunc registers the func which runs tasks of the passed in type queued by this server
+func (s *server) RegisterTaskFunc(typ string, fn tasks.Func) { s.taskQueue.RegisterFunc(typ, fn) }
+
 type server struct {
 	backend Backend
 
@@ -241,15 +379,38 @@ type server struct {
 
 	foreman *Foreman
 
-	config *Config
+	config       *Config
+	configLoader ConfigLoader
 
 	waitGroup *sync.WaitGroup
 	stopChan  chan bool
 	stopped   bool
+	drain     *drainState
 
 	routes []string
 
-	billing billing.Client
+	handlers       map[ChannelType]ChannelHandler
+	activeHandlers map[ChannelType]ChannelHandler
+	embedded       bool
+
+	billing      billing.Client
+	feedback     feedback.Client
+	moderator    moderation.Moderator
+	translator   translation.Translator
+	extractor    extraction.Extractor
+	webhookRelay *webhooks.Relay
+	taskQueue    *tasks.Queue
+}
+
+// AddHandler adds a handler for a channel type to this server, replacing any registered handler for that type. Handlers
+// must be added before the server is started.
+func (s *server) AddHandler(handler ChannelHandler) {
+	s.handlers[handler.ChannelType()] = handler
+}
+
+// GetHandler returns the handler this server is running for the passed in channel type, or nil if it isn't running one
+func (s *server) GetHandler(ct ChannelType) ChannelHandler {
+	return s.activeHandlers[ct]
 }
 
 func (s *server) initializeChannelHandlers() {
@@ -257,14 +418,16 @@ func (s *server) initializeChannelHandlers() {
 	excludes := s.config.ExcludeChannels
 
 	// initialize handlers which are included/not-excluded in the config
-	for _, handler := range registeredHandlers {
+	for _, handler := range s.handlers {
 		channelType := string(handler.ChannelType())
 		if (includes == nil || utils.StringArrayContains(includes, channelType)) && (excludes == nil || !utils.StringArrayContains(excludes, channelType)) {
 			err := handler.Initialize(s)
 			if err != nil {
 				log.Fatal(err)
 			}
-			activeHandlers[handler.ChannelType()] = handler
+			s.activeHandlers[handler.ChannelType()] = handler
+			s.addAccountEventRoute(handler)
+			s.addSimulateRoute(handler)
 
 			logrus.WithField("comp", "server").WithField("handler", handler.ChannelName()).WithField("handler_type", channelType).Info("handler initialized")
 		}
@@ -298,6 +461,11 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 
 		r = r.WithContext(ctx)
 
+		// when deferring webhooks, validated requests are queued to be handled later
+		if s.deferRequest(ctx, handler, channel, w, r) {
+			return
+		}
+
 		// read the bytes from our body so we can create a channel log for this request
 		response := &bytes.Buffer{}
 
@@ -320,7 +488,7 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			panicLog := recover()
 			if panicLog != nil {
 				debug.PrintStack()
-				logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", url).WithField("request", string(request)).WithField("trace", panicLog).Error("panic handling request")
+				logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("step", "receive").WithField("url", url).WithField("request", string(request)).WithField("trace", panicLog).Error("panic handling request")
 				writeAndLogRequestError(ctx, ww, r, channel, errors.New("panic handling msg"))
 			}
 		}()
@@ -333,7 +501,7 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 		if err != nil {
 			// if error is from blocked contact message or invalid json received from too large message dont write it
 			if !(err.Error() == "blocked contact sending message" || strings.Contains(err.Error(), "too large body")) {
-				logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("url", url).WithField("request", string(request)).Error("error handling request")
+				logrus.WithError(err).WithField("channel_uuid", channel.UUID()).WithField("channel_type", channel.ChannelType()).WithField("step", "receive").WithField("url", url).WithField("request", string(request)).Error("error handling request")
 				writeAndLogRequestError(ctx, ww, r, channel, err)
 			}
 		}
@@ -347,10 +515,12 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 				} else {
 					logs = append(logs, NewChannelLog("Channel Error", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
 					librato.Gauge(fmt.Sprintf("courier.channel_error_%s", channel.ChannelType()), secondDuration)
+					metrics.RecordHandlerDuration(string(channel.ChannelType()), metrics.ActionError, duration)
 				}
 			} else {
 				logs = append(logs, NewChannelLog("Request Ignored", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
 				librato.Gauge(fmt.Sprintf("courier.channel_ignored_%s", channel.ChannelType()), secondDuration)
+				metrics.RecordHandlerDuration(string(channel.ChannelType()), metrics.ActionIgnored, duration)
 			}
 		}
 
@@ -360,20 +530,28 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 			case Msg:
 				logs = append(logs, NewChannelLog("Message Received", channel, e.ID(), r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
 				librato.Gauge(fmt.Sprintf("courier.msg_receive_%s", channel.ChannelType()), secondDuration)
+				metrics.RecordHandlerDuration(string(channel.ChannelType()), metrics.ActionReceive, duration)
+				metrics.RecordMsgReceived(string(channel.ChannelType()))
 				LogMsgReceived(r, e)
 
-				if err := handleBilling(s, e); err != nil {
-					logrus.WithError(err).Info("Error handle billing on receive msg")
+				// synced messages weren't received by us, so they aren't billed
+				if !e.IsSynced() {
+					if err := handleBilling(s, e); err != nil {
+						logrus.WithError(err).Info("Error handle billing on receive msg")
+					}
 				}
 
 			case ChannelEvent:
 				logs = append(logs, NewChannelLog("Event Received", channel, NilMsgID, r.Method, url, ww.Status(), string(request), prependHeaders(response.String(), ww.Status(), w), duration, err))
 				librato.Gauge(fmt.Sprintf("courier.evt_receive_%s", channel.ChannelType()), secondDuration)
+				metrics.RecordHandlerDuration(string(channel.ChannelType()), metrics.ActionEvent, duration)
 				LogChannelEventReceived(r, e)
 			case MsgStatus:
-				logs = append(logs, NewChannelLog("Status Updated", channel, e.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err))
+				logs = append(logs, NewChannelLog(statusLogDescription, channel, e.ID(), r.Method, url, ww.Status(), string(request), response.String(), duration, err))
 				librato.Gauge(fmt.Sprintf("courier.msg_status_%s", channel.ChannelType()), secondDuration)
+				metrics.RecordHandlerDuration(string(channel.ChannelType()), metrics.ActionStatus, duration)
 				LogMsgStatusReceived(r, e)
+				publishStatus(s, e)
 			}
 		}
 
@@ -389,9 +567,28 @@ func (s *server) channelHandleWrapper(handler ChannelHandler, handlerFunc Channe
 
 func (s *server) AddHandlerRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) {
 	method = strings.ToLower(method)
+	path := handlerRoutePath(handler, action)
+	s.chanRouter.Method(method, path, s.channelHandleWrapper(handler, handlerFunc))
+	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), action))
+}
+
+// AddHandlerSharedRoute adds a route for the passed in action of the passed in handler which doesn't have a channel UUID
+// in its path even if the handler's other routes do, for handlers which can find channels by other means
+func (s *server) AddHandlerSharedRoute(handler ChannelHandler, method string, action string, handlerFunc ChannelHandleFunc) {
+	method = strings.ToLower(method)
+	path := handlerSharedRoutePath(handler, action)
+	s.chanRouter.Method(method, path, s.channelHandleWrapper(handler, handlerFunc))
+	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), action))
+}
+
+// the part of the routes of handlers which use channel UUIDs in them that matches the UUID
+const channelRouteUUIDPattern = "{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}"
+
+// handlerRoutePath returns the path, relative to /c, of the route for the passed in action of the passed in handler
+func handlerRoutePath(handler ChannelHandler, action string) string {
 	channelType := strings.ToLower(string(handler.ChannelType()))
 
-	path := fmt.Sprintf("/%s/{uuid:[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}}", channelType)
+	path := fmt.Sprintf("/%s/%s", channelType, channelRouteUUIDPattern)
 	if !handler.UseChannelRouteUUID() {
 		path = fmt.Sprintf("/%s", channelType)
 	}
@@ -399,8 +596,13 @@ func (s *server) AddHandlerRoute(handler ChannelHandler, method string, action s
 	if action != "" {
 		path = fmt.Sprintf("%s/%s", path, action)
 	}
-	s.chanRouter.Method(method, path, s.channelHandleWrapper(handler, handlerFunc))
-	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), action))
+	return path
+}
+
+// handlerSharedRoutePath returns the path, relative to /c, of the shared route for the passed in action of the passed in
+// handler
+func handlerSharedRoutePath(handler ChannelHandler, action string) string {
+	return fmt.Sprintf("/%s/%s", strings.ToLower(string(handler.ChannelType())), action)
 }
 
 func prependHeaders(body string, statusCode int, resp http.ResponseWriter) string {
@@ -445,16 +647,53 @@ func (s *server) handle405(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+// checkStatusAuth checks the request against our status credentials, writing a 401 and returning false if they don't match
+func (s *server) checkStatusAuth(w http.ResponseWriter, r *http.Request) bool {
 	if s.config.StatusUsername != "" {
 		user, pass, ok := r.BasicAuth()
 		if !ok || user != s.config.StatusUsername || pass != s.config.StatusPassword {
 			w.Header().Set("WWW-Authenticate", `Basic realm="Authenticate"`)
 			w.WriteHeader(401)
 			w.Write([]byte("Unauthorised.\n"))
-			return
+			return false
 		}
 	}
+	return true
+}
+
+// CheckAdminAuth returns whether the passed in request carries our status credentials. Admin endpoints change things so
+// unlike the status endpoints they're refused rather than left open when no credentials are configured.
+func CheckAdminAuth(config *Config, r *http.Request) bool {
+	if config.StatusUsername == "" {
+		return false
+	}
+	user, pass, ok := r.BasicAuth()
+	return ok && user == config.StatusUsername && pass == config.StatusPassword
+}
+
+// checkAdminAuth checks the request with CheckAdminAuth, writing a 401 and returning false if it fails
+func (s *server) checkAdminAuth(w http.ResponseWriter, r *http.Request) bool {
+	if !CheckAdminAuth(s.config, r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Authenticate"`)
+		w.WriteHeader(401)
+		w.Write([]byte("Unauthorised.\n"))
+		return false
+	}
+	return true
+}
+
+// handleMetrics exposes our metrics to be scraped by Prometheus
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
 
 	var buf bytes.Buffer
 	buf.WriteString("<title>courier</title><body><pre>\n")
@@ -487,12 +726,37 @@ func (s *server) handleCHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write(hsJSON)
 }
 
+func (s *server) handleChannelHealth(w http.ResponseWriter, r *http.Request) {
+	if !s.checkStatusAuth(w, r) {
+		return
+	}
+
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(r.Context(), w, r, err)
+		return
+	}
+
+	health, err := s.backend.GetChannelHealth(r.Context(), uuid)
+	if err != nil {
+		WriteDataResponse(r.Context(), w, http.StatusInternalServerError, "Error", []interface{}{NewErrorData(err.Error())})
+		return
+	}
+
+	status := http.StatusOK
+	if !health.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	WriteDataResponse(r.Context(), w, status, "Channel Health", []interface{}{health})
+}
+
 // for use in request.Context
 type contextKey int
 
 const (
 	contextRequestURL contextKey = iota
 	contextRequestStart
+	contextDeferred
 )
 
 var splash = `
@@ -563,12 +827,13 @@ func (s *server) CheckS3() error {
 }
 
 func handleBilling(s *server, msg Msg) error {
+	now := time.Now()
 	billingMsg := billing.NewMessage(
 		string(msg.URN().Identity()),
 		"",
 		msg.Channel().UUID().String(),
 		msg.ExternalID(),
-		time.Now().Format(time.RFC3339),
+		now.Format(time.RFC3339),
 		"I",
 		msg.Channel().ChannelType().String(),
 		msg.Text(),
@@ -579,6 +844,8 @@ func handleBilling(s *server, msg Msg) error {
 	billingMsg.Text = msg.Text()
 	billingMsg.Attachments = msg.Attachments()
 	billingMsg.QuickReplies = msg.QuickReplies()
+	billingMsg.SetChannelContext(now, ChannelLocation(msg.Channel()), msg.Channel().Country())
+	SetBillingRouting(msg.Channel(), &billingMsg)
 	s.Billing().SendAsync(billingMsg, nil, nil)
 
 	return nil
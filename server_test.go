@@ -1,14 +1,21 @@
 package courier
 
 import (
+	"context"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
+
 	"github.com/nyaruka/courier/billing"
 	"github.com/nyaruka/courier/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServer(t *testing.T) {
@@ -70,6 +77,205 @@ func TestServer(t *testing.T) {
 	rr, err = utils.MakeHTTPRequest(req)
 	assert.NoError(t, err)
 	assert.Equal(t, 200, rr.StatusCode)
+
+	// channel health without auth
+	req, _ = http.NewRequest("GET", "http://localhost:8080/c/health/dbc126ed-66bc-4e28-b67b-81dc3327c95d", nil)
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+	assert.Equal(t, 401, rr.StatusCode)
+
+	// channel health with auth, channels without heartbeats are healthy
+	req, _ = http.NewRequest("GET", "http://localhost:8080/c/health/dbc126ed-66bc-4e28-b67b-81dc3327c95d", nil)
+	req.SetBasicAuth("admin", "password123")
+	rr, err = utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), `"healthy":true`)
+}
+
+type embeddedHandler struct {
+	server Server
+}
+
+func (h *embeddedHandler) ChannelName() string       { return "Embedded Handler" }
+func (h *embeddedHandler) ChannelType() ChannelType  { return ChannelType("EM") }
+func (h *embeddedHandler) UseChannelRouteUUID() bool { return true }
+
+func (h *embeddedHandler) Initialize(s Server) error {
+	h.server = s
+	s.AddHandlerRoute(h, http.MethodGet, "receive", func(ctx context.Context, c Channel, w http.ResponseWriter, r *http.Request) ([]Event, error) {
+		return nil, WriteIgnored(ctx, w, r, "received")
+	})
+	return nil
+}
+
+func (h *embeddedHandler) GetChannel(ctx context.Context, r *http.Request) (Channel, error) {
+	uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		return nil, err
+	}
+	return h.server.Backend().GetChannel(ctx, h.ChannelType(), uuid)
+}
+
+func (h *embeddedHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	return h.server.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), MsgSent), nil
+}
+
+func TestEmbeddedServer(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e", "EM", "2020", "US", map[string]interface{}{}))
+
+	server := NewEmbeddedServer(config, mb)
+	server.AddHandler(&embeddedHandler{})
+
+	// mount our router in the HTTP server of the embedding process
+	host := httptest.NewServer(server.Router())
+	defer host.Close()
+
+	assert.NoError(t, server.Start())
+	defer server.Stop()
+
+	req, _ := http.NewRequest("GET", host.URL+"/c/em/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive", nil)
+	rr, err := utils.MakeHTTPRequest(req)
+	assert.NoError(t, err)
+	assert.Contains(t, string(rr.Body), "received")
+
+	// handlers are looked up through the server running them
+	assert.Equal(t, ChannelType("EM"), server.GetHandler(ChannelType("EM")).ChannelType())
+	assert.Nil(t, server.GetHandler(ChannelType("XX")))
+
+	// added handlers don't leak into other servers
+	assert.Nil(t, GetHandler(ChannelType("EM")))
+	_, found := RegisteredHandlers()[ChannelType("EM")]
+	assert.False(t, found)
+
+	// and we aren't listening ourselves
+	req, _ = http.NewRequest("GET", "http://localhost:8080/", nil)
+	_, err = utils.MakeHTTPRequest(req)
+	assert.Error(t, err)
+}
+
+// the admin credentials our admin test servers are configured with
+const (
+	testAdminUsername = "admin"
+	testAdminPassword = "sesame"
+)
+
+// adminTestServer is a started embedded server with our test admin credentials configured, for testing its admin
+// endpoints
+type adminTestServer struct {
+	Server
+	host *httptest.Server
+}
+
+// newAdminTestServer sets our test admin credentials on the passed in config and starts an embedded server with it,
+// the passed in backend and handlers, mounted on a test HTTP server
+func newAdminTestServer(t *testing.T, config *Config, mb *MockBackend, handlers ...ChannelHandler) *adminTestServer {
+	config.StatusUsername = testAdminUsername
+	config.StatusPassword = testAdminPassword
+
+	server := NewEmbeddedServer(config, mb)
+	for _, handler := range handlers {
+		server.AddHandler(handler)
+	}
+	host := httptest.NewServer(server.Router())
+
+	require.NoError(t, server.Start())
+	return &adminTestServer{Server: server, host: host}
+}
+
+// Close stops our server and the test HTTP server it's mounted on
+func (s *adminTestServer) Close() {
+	s.Stop()
+	s.host.Close()
+}
+
+// request makes a request to the passed in path of our server, with our test admin credentials if auth is set, and
+// returns the status code and body of the response
+func (s *adminTestServer) request(method string, path string, body string, auth bool) (int, string) {
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, _ := http.NewRequest(method, s.host.URL+path, reader)
+	if auth {
+		req.SetBasicAuth(testAdminUsername, testAdminPassword)
+	}
+	rr, _ := utils.MakeHTTPRequest(req)
+	return rr.StatusCode, string(rr.Body)
+}
+
+func TestAdminAuth(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "EM", "2020", "US", nil))
+
+	server := newAdminTestServer(t, config, mb, &vendorHandler{})
+	defer server.Close()
+
+	adminEndpoints := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/c/audit"},
+		{http.MethodGet, "/c/export/8eb23e93-5ecb-45ba-b726-3b064e0c56ab"},
+		{http.MethodGet, "/c/logs/8eb23e93-5ecb-45ba-b726-3b064e0c56ab"},
+		{http.MethodGet, "/c/alerts/8eb23e93-5ecb-45ba-b726-3b064e0c56ab"},
+		{http.MethodPost, "/c/drain"},
+		{http.MethodPost, "/c/pause/8eb23e93-5ecb-45ba-b726-3b064e0c56ab"},
+		{http.MethodDelete, "/c/pause/8eb23e93-5ecb-45ba-b726-3b064e0c56ab"},
+		{http.MethodPost, "/c/pause/bulk"},
+		{http.MethodDelete, "/c/pause/bulk"},
+		{http.MethodPost, "/c/test/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/receive"},
+		{http.MethodPost, "/c/em/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/simulate"},
+		{http.MethodGet, "/admin/channels/8eb23e93-5ecb-45ba-b726-3b064e0c56ab/config"},
+		{http.MethodPost, "/admin/config/reload"},
+	}
+	statusEndpoints := []string{"/c/drain", "/c/stats/sends", "/c/pause/8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "/c/pause/bulk"}
+
+	// all of our admin and status endpoints require our credentials
+	for _, e := range adminEndpoints {
+		status, _ := server.request(e.method, e.path, "", false)
+		assert.Equal(t, http.StatusUnauthorized, status, "%s %s", e.method, e.path)
+	}
+	for _, path := range statusEndpoints {
+		status, _ := server.request(http.MethodGet, path, "", false)
+		assert.Equal(t, http.StatusUnauthorized, status, "GET %s", path)
+	}
+
+	// admin endpoints change things so are refused rather than left open when no credentials are configured, unlike
+	// our status endpoints
+	config.StatusUsername = ""
+	config.StatusPassword = ""
+
+	for _, e := range adminEndpoints {
+		status, _ := server.request(e.method, e.path, "", false)
+		assert.Equal(t, http.StatusUnauthorized, status, "%s %s", e.method, e.path)
+	}
+	for _, path := range statusEndpoints {
+		status, _ := server.request(http.MethodGet, path, "", false)
+		assert.Equal(t, http.StatusOK, status, "GET %s", path)
+	}
+}
+
+func TestRegisteredHandlersAreCopied(t *testing.T) {
+	RegisterHandler(&embeddedHandler{})
+	defer delete(registeredHandlers, ChannelType("EM"))
+
+	// each server initializes its own copy of a registered handler
+	handler1 := RegisteredHandlers()[ChannelType("EM")].(*embeddedHandler)
+	handler2 := RegisteredHandlers()[ChannelType("EM")].(*embeddedHandler)
+	assert.NotSame(t, handler1, handler2)
+
+	server := NewServer(NewConfig(), NewMockBackend())
+	handler1.Initialize(server)
+	assert.Equal(t, server, handler1.server)
+	assert.Nil(t, handler2.server)
+	assert.Nil(t, GetHandler(ChannelType("EM")).(*embeddedHandler).server)
 }
 
 func TestSanitizeBody(t *testing.T) {
@@ -0,0 +1,263 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// the config keys of the vendor URLs which simulated sends are pointed at our recorder with, handlers whose config
+// doesn't have one of them can't have their sends simulated
+var simulatedURLConfigKeys = []string{ConfigBaseURL, ConfigSendURL}
+
+// the config keys which hold secrets for every channel type, on top of the secret fields handlers describe
+var defaultSecretConfigKeys = []string{ConfigAPIKey, ConfigAuthToken, ConfigPassword, ConfigSecret, ConfigUserToken, ConfigWebhookToken}
+
+// the headers of simulated requests whose values we redact
+var secretHeaderRegex = regexp.MustCompile(`(?i)auth|token|key|secret|signature|password`)
+
+// what secrets are replaced with in simulated requests
+const redactedSecret = "********"
+
+// the response our recorder gives every simulated request, with the ids of sent msgs where vendors put them so that
+// handlers treat the send as a success and carry on with any other parts
+const simulatedResponse = `{"ok": true, "result": {"message_id": 1}, "messages": [{"id": "simulated"}], "message_id": "simulated", "id": "simulated"}`
+
+// SimulatedRequest is a request a handler made to its vendor while simulating a send, with secrets redacted. Its path is
+// relative to the vendor URL of the channel.
+type SimulatedRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// SendSimulation is the result of simulating the send of a msg, e.g.
+//
+//	{
+//	  "status": "W",
+//	  "requests": [
+//	    {
+//	      "method": "POST",
+//	      "path": "/bot********/sendMessage",
+//	      "headers": {"Content-Type": "application/x-www-form-urlencoded"},
+//	      "body": "chat_id=12345&text=Pick+one"
+//	    }
+//	  ]
+//	}
+type SendSimulation struct {
+	Status   MsgStatusValue      `json:"status"`
+	Requests []*SimulatedRequest `json:"requests"`
+}
+
+// simulatedSendPayload is the msg posted to have its send simulated
+//
+//	{
+//	  "urn": "telegram:12345",
+//	  "text": "Pick one",
+//	  "quick_replies": ["Yes", "No"],
+//	  "attachments": ["image/jpeg:https://example.com/image.jpg"],
+//	  "metadata": {"header_text": "Survey"}
+//	}
+type simulatedSendPayload struct {
+	URN          string          `json:"urn"`
+	Text         string          `json:"text"`
+	QuickReplies []string        `json:"quick_replies"`
+	Attachments  []string        `json:"attachments"`
+	Metadata     json.RawMessage `json:"metadata"`
+}
+
+// simulatedURLKeys returns the config keys of the vendor URLs of the passed in handler's channels
+func simulatedURLKeys(handler ChannelHandler) []string {
+	specifier, isSpecifier := handler.(ConfigSpecifier)
+	if !isSpecifier {
+		return nil
+	}
+
+	keys := make([]string, 0, len(simulatedURLConfigKeys))
+	for _, field := range specifier.ConfigSpec() {
+		for _, key := range simulatedURLConfigKeys {
+			if field.Key == key {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// CanSimulateSends returns whether the sends of the passed in handler can be simulated, which needs the vendor URL its
+// sends go to to be in the config of its channels
+func CanSimulateSends(handler ChannelHandler) bool {
+	return len(simulatedURLKeys(handler)) > 0
+}
+
+// SimulateSend sends the passed in msg with the passed in handler, pointing it at a recorder instead of the vendor, and
+// returns the requests the handler made with their secrets redacted
+func SimulateSend(ctx context.Context, backend Backend, handler ChannelHandler, channel Channel, urn urns.URN, text string, attachments []string, quickReplies []string, metadata json.RawMessage) (*SendSimulation, error) {
+	keys := simulatedURLKeys(handler)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("sends of %s channels can't be simulated", handler.ChannelType())
+	}
+
+	recorder := &sendRecorder{secrets: channelSecrets(handler, channel)}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: recorder}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// point the vendor URLs of the channel at our recorder, keeping their paths
+	recorderURL := fmt.Sprintf("http://%s", listener.Addr().String())
+	overrides := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		overrides[key] = recorderURL
+		if u, err := url.Parse(channel.StringConfigForKey(key, "")); err == nil && u.Host != "" {
+			overrides[key] = strings.TrimSuffix(recorderURL+u.RequestURI(), "/")
+		}
+	}
+
+	msg, err := backend.NewSimulatedMsg(channel, overrides, urn, text, attachments, quickReplies, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := handler.SendMsg(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SendSimulation{Status: status.Status(), Requests: recorder.recorded()}, nil
+}
+
+// channelSecrets returns the secret config values of the passed in channel, longest first so that secrets containing
+// others are redacted whole
+func channelSecrets(handler ChannelHandler, channel Channel) []string {
+	keys := secretConfigKeys(handler, channel)
+
+	secrets := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if secret := channel.StringConfigForKey(key, ""); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets
+}
+
+// sendRecorder records the requests of a simulated send, responding to each as if the send had succeeded
+type sendRecorder struct {
+	secrets []string
+
+	mutex    sync.Mutex
+	requests []*SimulatedRequest
+}
+
+func (r *sendRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(req.Body, 1000000))
+
+	headers := make(map[string]string, len(req.Header))
+	for name := range req.Header {
+		if secretHeaderRegex.MatchString(name) {
+			headers[name] = redactedSecret
+		} else {
+			headers[name] = r.redact(req.Header.Get(name))
+		}
+	}
+	delete(headers, "Accept-Encoding")
+	delete(headers, "Content-Length")
+	delete(headers, "User-Agent")
+
+	r.mutex.Lock()
+	r.requests = append(r.requests, &SimulatedRequest{Method: req.Method, Path: r.redact(req.URL.RequestURI()), Headers: headers, Body: r.redact(string(body))})
+	r.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(simulatedResponse))
+}
+
+func (r *sendRecorder) recorded() []*SimulatedRequest {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*SimulatedRequest{}, r.requests...)
+}
+
+// redact replaces the secrets of our channel in the passed in value, including where they've been URL encoded
+func (r *sendRecorder) redact(value string) string {
+	for _, secret := range r.secrets {
+		value = strings.ReplaceAll(value, secret, redactedSecret)
+		value = strings.ReplaceAll(value, url.QueryEscape(secret), redactedSecret)
+	}
+	return value
+}
+
+// addSimulateRoute registers the simulate route of the passed in handler if its sends can be simulated
+func (s *server) addSimulateRoute(handler ChannelHandler) {
+	if !handler.UseChannelRouteUUID() || !CanSimulateSends(handler) {
+		return
+	}
+
+	path := handlerRoutePath(handler, "simulate")
+	s.chanRouter.Post(path, s.simulateWrapper(handler))
+	s.routes = append(s.routes, fmt.Sprintf("%-20s - %s %s", "/c"+path, handler.ChannelName(), "simulate"))
+}
+
+// simulateWrapper handles requests to simulate sends, which need our admin credentials
+func (s *server) simulateWrapper(handler ChannelHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.checkAdminAuth(w, r) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+		defer cancel()
+
+		uuid, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+		if err != nil {
+			WriteError(ctx, w, r, err)
+			return
+		}
+		channel, err := s.backend.GetChannel(ctx, handler.ChannelType(), uuid)
+		if err != nil {
+			WriteError(ctx, w, r, err)
+			return
+		}
+
+		payload := &simulatedSendPayload{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 100000)).Decode(payload); err != nil {
+			WriteError(ctx, w, r, fmt.Errorf("unable to parse request JSON: %s", err))
+			return
+		}
+
+		urn, err := urns.Parse(payload.URN)
+		if err == nil {
+			err = urn.Validate()
+		}
+		if err != nil {
+			WriteError(ctx, w, r, fmt.Errorf("invalid urn: %s", payload.URN))
+			return
+		}
+
+		simulation, err := SimulateSend(ctx, s.backend, handler, channel, urn, payload.Text, payload.Attachments, payload.QuickReplies, payload.Metadata)
+		if err != nil {
+			WriteError(ctx, w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(simulation)
+	}
+}
@@ -0,0 +1,105 @@
+package courier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// vendorHandler is an embedded handler which sends msgs to the vendor URL in its channel config
+type vendorHandler struct {
+	embeddedHandler
+}
+
+func (h *vendorHandler) ConfigSpec() []ConfigField {
+	return []ConfigField{
+		{Key: ConfigBaseURL, Label: "API URL"},
+		{Key: "api_token", Label: "API Token", Secret: true},
+	}
+}
+
+func (h *vendorHandler) SendMsg(ctx context.Context, msg Msg) (MsgStatus, error) {
+	status := h.server.Backend().NewMsgStatusForID(msg.Channel(), msg.ID(), MsgErrored)
+	token := msg.Channel().StringConfigForKey("api_token", "")
+
+	for _, part := range append([]string{msg.Text()}, msg.QuickReplies()...) {
+		form := url.Values{"to": []string{msg.URN().Path()}, "text": []string{part}, "token": []string{token}}
+		req, _ := http.NewRequest(http.MethodPost, msg.Channel().StringConfigForKey(ConfigBaseURL, "https://api.example.com")+"/send?token="+token, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		if _, err := utils.MakeHTTPRequest(req); err != nil {
+			return status, nil
+		}
+	}
+
+	status.SetStatus(MsgWired)
+	return status, nil
+}
+
+func TestSimulateSend(t *testing.T) {
+	ctx := context.Background()
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "EM", "12345", "US", map[string]interface{}{
+		ConfigBaseURL: "https://api.example.com/v2",
+		"api_token":   "sesame/123",
+	})
+	urn := urns.URN("tel:+12065551212")
+
+	handler := &vendorHandler{}
+	handler.Initialize(NewServer(NewConfig(), mb))
+
+	simulation, err := SimulateSend(ctx, mb, handler, channel, urn, "Pick one", nil, []string{"Yes"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, MsgWired, simulation.Status)
+	require.Len(t, simulation.Requests, 2)
+
+	// requests keep the path of the vendor URL, and secrets are redacted wherever they appear
+	assert.Equal(t, &SimulatedRequest{
+		Method:  "POST",
+		Path:    "/v2/send?token=********",
+		Headers: map[string]string{"Authorization": "********", "Content-Type": "application/x-www-form-urlencoded"},
+		Body:    "text=Pick+one&to=%2B12065551212&token=********",
+	}, simulation.Requests[0])
+	assert.Equal(t, "text=Yes&to=%2B12065551212&token=********", simulation.Requests[1].Body)
+
+	// the channel itself is untouched
+	assert.Equal(t, "https://api.example.com/v2", channel.StringConfigForKey(ConfigBaseURL, ""))
+
+	// handlers whose vendor URL isn't in their config can't have their sends simulated
+	assert.False(t, CanSimulateSends(&embeddedHandler{}))
+	_, err = SimulateSend(ctx, mb, &embeddedHandler{}, channel, urn, "Hi", nil, nil, nil)
+	assert.EqualError(t, err, "sends of EM channels can't be simulated")
+}
+
+func TestSimulateRoute(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	mb := NewMockBackend()
+	mb.AddChannel(NewMockChannel("1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e", "EM", "2020", "US", map[string]interface{}{"api_token": "sesame"}))
+
+	server := newAdminTestServer(t, config, mb, &vendorHandler{})
+	defer server.Close()
+
+	simulate := func(body string) (int, string) {
+		return server.request(http.MethodPost, "/c/em/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/simulate", body, true)
+	}
+
+	status, body := simulate(`{"urn": "tel:+12065551212", "text": "Hi"}`)
+	assert.Equal(t, 200, status)
+	assert.JSONEq(t, fmt.Sprintf(`{"status": "W", "requests": [{"method": "POST", "path": "/send?token=********", "headers": %s, "body": "text=Hi&to=%%2B12065551212&token=********"}]}`,
+		`{"Authorization": "********", "Content-Type": "application/x-www-form-urlencoded"}`), body)
+
+	status, body = simulate(`{"urn": "xyz", "text": "Hi"}`)
+	assert.Equal(t, 400, status)
+	assert.Contains(t, body, "invalid urn")
+}
@@ -18,6 +18,13 @@ const (
 	NilMsgStatus MsgStatusValue = ""
 )
 
+// MsgStatusPart is the outcome of one of the requests made to send a msg, e.g. the text and each attachment of a msg
+// might be sent as separate requests which each get their own external id
+type MsgStatusPart struct {
+	ExternalID string
+	Status     MsgStatusValue
+}
+
 //-----------------------------------------------------------------------------
 // MsgStatusUpdate Interface
 //-----------------------------------------------------------------------------
@@ -36,9 +43,29 @@ type MsgStatus interface {
 	ExternalID() string
 	SetExternalID(string)
 
+	// AddPart records the outcome of one of the requests made to send the msg, the external id of the first part which
+	// has one also becoming our external id if we don't have one yet
+	AddPart(externalID string, status MsgStatusValue)
+	Parts() []*MsgStatusPart
+
 	Status() MsgStatusValue
 	SetStatus(MsgStatusValue)
 
+	// ErrorClass is the class of the error the send failed with, if its handler could tell, see ClassifyMsgStatus
+	ErrorClass() ErrorClass
+	SetErrorClass(ErrorClass)
+
 	Logs() []*ChannelLog
 	AddLog(log *ChannelLog)
 }
+
+// SecondaryExternalIDs returns the external ids of the parts of the passed in status other than its own external id
+func SecondaryExternalIDs(status MsgStatus) []string {
+	ids := make([]string, 0, len(status.Parts()))
+	for _, part := range status.Parts() {
+		if part.ExternalID != "" && part.ExternalID != status.ExternalID() {
+			ids = append(ids, part.ExternalID)
+		}
+	}
+	return ids
+}
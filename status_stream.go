@@ -0,0 +1,107 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// the redis channel the statuses of the msgs of a channel are published to
+const statusStreamKey = "courier:msg_statuses:%s"
+
+// StreamedMsgStatus is a msg status as published to those following the statuses of its channel, e.g.
+//
+//	{
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "msg_id": 12345,
+//	  "external_id": "wamid.HBgMNTU",
+//	  "status": "D",
+//	  "created_on": "2022-10-05T15:04:05.123Z"
+//	}
+type StreamedMsgStatus struct {
+	ChannelUUID ChannelUUID    `json:"channel_uuid"`
+	MsgID       MsgID          `json:"msg_id,omitempty"`
+	ExternalID  string         `json:"external_id,omitempty"`
+	Status      MsgStatusValue `json:"status"`
+	CreatedOn   time.Time      `json:"created_on"`
+}
+
+// PublishMsgStatus publishes the passed in status to anyone following the statuses of its channel
+func PublishMsgStatus(rp *redis.Pool, status MsgStatus) error {
+	rc := rp.Get()
+	defer rc.Close()
+
+	streamed, err := json.Marshal(&StreamedMsgStatus{
+		ChannelUUID: status.ChannelUUID(),
+		MsgID:       status.ID(),
+		ExternalID:  status.ExternalID(),
+		Status:      status.Status(),
+		CreatedOn:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = rc.Do("PUBLISH", fmt.Sprintf(statusStreamKey, status.ChannelUUID()), streamed)
+	return err
+}
+
+// publishStatus publishes the passed in status if the passed in server has our gRPC API enabled, failures are only logged
+func publishStatus(server Server, status MsgStatus) {
+	if server.Config().GRPCAddress == "" {
+		return
+	}
+	if err := PublishMsgStatus(server.Backend().RedisPool(), status); err != nil {
+		logrus.WithError(err).WithField("channel_uuid", status.ChannelUUID()).Error("error publishing msg status")
+	}
+}
+
+// StreamMsgStatuses calls the passed in function with each status published for the channel with the passed in UUID
+// until the passed in context is done or the function returns an error. Streams can be followed for a long time, so
+// each subscribes on its own connection to the Redis at the passed in URL rather than holding one of our pool's.
+func StreamMsgStatuses(ctx context.Context, redisURL string, uuid ChannelUUID, fn func(*StreamedMsgStatus) error) error {
+	rc, err := redis.DialURL(redisURL)
+	if err != nil {
+		return err
+	}
+	conn := redis.PubSubConn{Conn: rc}
+	defer conn.Close()
+
+	if err := conn.Subscribe(fmt.Sprintf(statusStreamKey, uuid)); err != nil {
+		return err
+	}
+
+	// unsubscribing once we're done makes our blocked receive below return
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Unsubscribe()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch v := conn.Receive().(type) {
+		case redis.Message:
+			status := &StreamedMsgStatus{}
+			if err := json.Unmarshal(v.Data, status); err != nil {
+				return err
+			}
+			if err := fn(status); err != nil {
+				return err
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return ctx.Err()
+			}
+		case error:
+			return v
+		}
+	}
+}
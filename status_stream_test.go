@@ -0,0 +1,59 @@
+package courier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamMsgStatuses(t *testing.T) {
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	streamed := make(chan *StreamedMsgStatus, 10)
+	done := make(chan error)
+	go func() {
+		done <- StreamMsgStatuses(ctx, "redis://localhost:6379/0", channel.UUID(), func(s *StreamedMsgStatus) error {
+			streamed <- s
+			return nil
+		})
+	}()
+
+	// give our subscription a moment to be set up
+	time.Sleep(100 * time.Millisecond)
+
+	// which doesn't hold a connection of our pool
+	stats := mb.RedisPool().Stats()
+	assert.Equal(t, stats.IdleCount, stats.ActiveCount)
+
+	// statuses are only published by servers with our gRPC API enabled
+	config := NewConfig()
+	server := NewServer(config, mb)
+	publishStatus(server, mb.NewMsgStatusForID(channel, NewMsgID(1), MsgWired))
+
+	config.GRPCAddress = ":8081"
+	publishStatus(server, mb.NewMsgStatusForID(channel, NewMsgID(2), MsgSent))
+
+	select {
+	case s := <-streamed:
+		assert.Equal(t, channel.UUID(), s.ChannelUUID)
+		assert.Equal(t, NewMsgID(2), s.MsgID)
+		assert.Equal(t, MsgSent, s.Status)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no status streamed")
+	}
+
+	// cancelling our context ends the stream
+	cancel()
+	select {
+	case err := <-done:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "stream didn't end")
+	}
+	assert.Len(t, streamed, 0)
+}
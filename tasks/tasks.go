@@ -0,0 +1,184 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/retries"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// the hash of tasks waiting to be run keyed by their UUID, and the sorted set of when each is due
+	tasksKey   = "tasks:tasks"
+	pendingKey = "tasks:pending"
+
+	// the list of tasks we gave up on, newest first
+	deadLettersKey = "tasks:dead_letters"
+
+	// how many times we try a task before giving up on it
+	maxAttempts = 5
+
+	// how long we wait before the first retry of a task, doubling for each retry after that
+	baseBackoff = 5 * time.Second
+	maxBackoff  = 10 * time.Minute
+
+	// how long each run of a task can take
+	taskTimeout = 30 * time.Second
+)
+
+// Task is follow-up work queued by a handler to be done outside of its send, e.g.
+//
+//	{
+//	  "uuid": "e7187099-7d38-4f60-955c-325957214c42",
+//	  "type": "facebook:remap_ref_urn",
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "payload": {"urn": "facebook:ref:67890", "recipient_id": "12345"},
+//	  "attempts": 1,
+//	  "last_error": "unable to get contact",
+//	  "created_on": "2022-10-05T15:04:05.123Z"
+//	}
+type Task struct {
+	UUID        uuids.UUID      `json:"uuid"`
+	Type        string          `json:"type"`
+	ChannelUUID string          `json:"channel_uuid,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedOn   time.Time       `json:"created_on"`
+
+	retries.State
+}
+
+// ItemID returns the id of this task in our queue
+func (t *Task) ItemID() string { return string(t.UUID) }
+
+// NewTask creates a new task of the passed in type for the passed in channel, with its payload marshalled to JSON
+func NewTask(typ string, channelUUID string, payload interface{}) (*Task, error) {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshalling task payload")
+	}
+	return &Task{UUID: uuids.New(), Type: typ, ChannelUUID: channelUUID, Payload: value, CreatedOn: time.Now().UTC()}, nil
+}
+
+// Func does the work of a task, returning an error if it should be retried
+type Func func(ctx context.Context, task *Task) error
+
+// the queue of tasks waiting to be run
+var retryOptions = &retries.Options{
+	Name:           "tasks",
+	ItemsKey:       tasksKey,
+	PendingKey:     pendingKey,
+	DeadLettersKey: deadLettersKey,
+	MaxAttempts:    maxAttempts,
+	BaseBackoff:    baseBackoff,
+	MaxBackoff:     maxBackoff,
+}
+
+// Backoff returns how long we wait to retry a task which has failed the passed in number of attempts
+func Backoff(attempts int) time.Duration {
+	return retryOptions.Backoff(attempts)
+}
+
+// runs the tasks of a queue with the funcs registered on it for their types
+type taskHandler struct {
+	queue *Queue
+}
+
+func (h taskHandler) NewItem() retries.Item { return &Task{} }
+
+func (h taskHandler) Attempt(item retries.Item) error {
+	ctx, cancel := context.WithTimeout(context.Background(), taskTimeout)
+	defer cancel()
+
+	return h.queue.Run(ctx, item.(*Task))
+}
+
+func (h taskHandler) GiveUp(item retries.Item) {
+	task := item.(*Task)
+	logrus.WithField("type", task.Type).WithField("channel_uuid", task.ChannelUUID).WithField("attempts", task.Attempts).WithField("error", task.LastError).Error("giving up on task")
+}
+
+// Queue runs tasks from a queue in Redis in the background, retrying failed tasks with exponential backoff until they
+// succeed or we give up on them and add them to our dead letters. Each server has its own queue which the funcs that
+// run tasks are registered on, so tasks are always run by the server which queued them.
+type Queue struct {
+	queue *retries.Queue
+	funcs map[string]Func
+	mutex sync.RWMutex
+}
+
+// NewQueue creates a new queue of tasks in the passed in Redis, or if that's nil, a queue which runs tasks as soon as
+// they are enqueued
+func NewQueue(rp *redis.Pool) *Queue {
+	q := &Queue{funcs: make(map[string]Func)}
+	if rp != nil {
+		q.queue = retries.NewQueue(rp, retryOptions, taskHandler{q})
+	}
+	return q
+}
+
+// RegisterFunc registers the func which runs tasks of the passed in type
+func (q *Queue) RegisterFunc(typ string, fn Func) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.funcs[typ] = fn
+}
+
+// Run runs the passed in task with the func registered for its type
+func (q *Queue) Run(ctx context.Context, task *Task) error {
+	q.mutex.RLock()
+	fn := q.funcs[task.Type]
+	q.mutex.RUnlock()
+
+	if fn == nil {
+		return fmt.Errorf("no func registered for task type: %s", task.Type)
+	}
+	return fn(ctx, task)
+}
+
+// Enqueue queues the passed in task to be run as soon as possible, or runs it right away if we have no Redis
+func (q *Queue) Enqueue(ctx context.Context, task *Task) error {
+	if q.queue == nil {
+		return q.Run(ctx, task)
+	}
+	return q.queue.Add(task, time.Now())
+}
+
+// Start starts running queued tasks in the background
+func (q *Queue) Start() {
+	if q.queue != nil {
+		q.queue.Start()
+	}
+}
+
+// Stop stops running tasks, waiting for any being run
+func (q *Queue) Stop() {
+	if q.queue != nil {
+		q.queue.Stop()
+	}
+}
+
+// runDue runs the tasks which are due at the passed in time, returning how many were run
+func (q *Queue) runDue(now time.Time) (int, error) {
+	return q.queue.RetryDue(now)
+}
+
+// DeadLetters returns the most recent tasks we gave up on, newest first
+func DeadLetters(rp *redis.Pool, limit int) ([]*Task, error) {
+	items, err := retries.NewQueue(rp, retryOptions, taskHandler{}).DeadLetters(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, len(items))
+	for i, item := range items {
+		tasks[i] = item.(*Task)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,159 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPool() *redis.Pool {
+	redisPool := &redis.Pool{
+		Wait:        true,
+		MaxActive:   5,
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Do("SELECT", 13)
+			return conn, err
+		},
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("FLUSHDB")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return redisPool
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, Backoff(1))
+	assert.Equal(t, 10*time.Second, Backoff(2))
+	assert.Equal(t, 40*time.Second, Backoff(4))
+	assert.Equal(t, 10*time.Minute, Backoff(10))
+	assert.Equal(t, 10*time.Minute, Backoff(100))
+}
+
+func TestRun(t *testing.T) {
+	q := NewQueue(nil)
+
+	var received string
+	q.RegisterFunc("test:run", func(ctx context.Context, task *Task) error {
+		payload := struct {
+			Name string `json:"name"`
+		}{}
+		json.Unmarshal(task.Payload, &payload)
+		received = payload.Name
+		return nil
+	})
+
+	task, err := NewTask("test:run", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", map[string]string{"name": "Bob"})
+	require.NoError(t, err)
+	assert.NoError(t, q.Run(context.Background(), task))
+	assert.Equal(t, "Bob", received)
+
+	task, _ = NewTask("test:unknown", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", nil)
+	assert.EqualError(t, q.Run(context.Background(), task), "no func registered for task type: test:unknown")
+
+	// queues without Redis run tasks as soon as they're enqueued
+	received = ""
+	task, _ = NewTask("test:run", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", map[string]string{"name": "Ann"})
+	assert.NoError(t, q.Enqueue(context.Background(), task))
+	assert.Equal(t, "Ann", received)
+
+	// and funcs are only registered on the queue they're registered on
+	assert.EqualError(t, NewQueue(nil).Run(context.Background(), task), "no func registered for task type: test:run")
+}
+
+func TestQueue(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	calls := 0
+	failing := true
+	q := NewQueue(rp)
+	q.RegisterFunc("test:queue", func(ctx context.Context, task *Task) error {
+		calls++
+		if failing {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	task, _ := NewTask("test:queue", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", map[string]string{"urn": "facebook:12345"})
+	require.NoError(t, q.Enqueue(context.Background(), task))
+
+	// our task is due right away, fails and is queued to be retried after our backoff
+	now := time.Now()
+	run, err := q.runDue(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, run)
+	assert.Equal(t, 1, calls)
+
+	score, err := redis.Int64(rc.Do("ZSCORE", pendingKey, task.UUID))
+	assert.NoError(t, err)
+	assert.Equal(t, now.Add(Backoff(1)).Unix(), score)
+
+	value, _ := redis.Bytes(rc.Do("HGET", tasksKey, task.UUID))
+	queued := &Task{}
+	json.Unmarshal(value, queued)
+	assert.Equal(t, 1, queued.Attempts)
+	assert.Equal(t, "boom", queued.LastError)
+
+	// nothing to run until then
+	run, _ = q.runDue(now)
+	assert.Equal(t, 0, run)
+
+	// once it succeeds, it's removed
+	failing = false
+	run, _ = q.runDue(now.Add(Backoff(1)))
+	assert.Equal(t, 1, run)
+	assert.Equal(t, 2, calls)
+
+	count, _ := redis.Int(rc.Do("HLEN", tasksKey))
+	assert.Equal(t, 0, count)
+	count, _ = redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.Equal(t, 0, count)
+
+	// tasks which keep failing are given up on
+	failing = true
+	task, _ = NewTask("test:queue", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", nil)
+	require.NoError(t, q.Enqueue(context.Background(), task))
+
+	for i := 0; i < maxAttempts; i++ {
+		run, _ := q.runDue(now.Add(time.Hour * time.Duration(i)))
+		assert.Equal(t, 1, run)
+	}
+
+	count, _ = redis.Int(rc.Do("HLEN", tasksKey))
+	assert.Equal(t, 0, count)
+
+	dead, err := DeadLetters(rp, 10)
+	assert.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, task.UUID, dead[0].UUID)
+	assert.Equal(t, maxAttempts, dead[0].Attempts)
+
+	// tasks of types without a func fail like any other
+	task, _ = NewTask("test:unregistered", "dbc126ed-66bc-4e28-b67b-81dc3327c95d", nil)
+	require.NoError(t, q.Enqueue(context.Background(), task))
+	q.runDue(now)
+
+	value, _ = redis.Bytes(rc.Do("HGET", tasksKey, task.UUID))
+	json.Unmarshal(value, queued)
+	assert.Equal(t, "no func registered for task type: test:unregistered", queued.LastError)
+}
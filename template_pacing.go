@@ -0,0 +1,122 @@
+package courier
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/nyaruka/librato"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// the contacts a channel opened business-initiated conversations with, scored by when they were opened
+	templateConversationsKey = "courier:template_conversations:%s"
+
+	// set while a channel is at its messaging limit, so that we only alert once each time it's reached
+	messagingLimitAlertKey = "courier:messaging_limit_alerted:%s"
+)
+
+// how long a business-initiated conversation stays open, templates sent to a contact during it don't open another
+var templateConversationWindow = 24 * time.Hour
+
+var luaOpenTemplateConversation = redis.NewScript(1, `-- KEYS: [ConversationsKey] ARGV: [EpochMS, WindowMS, Limit, Contact]
+	local now = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+
+	-- forget conversations which have closed
+	redis.call("zremrangebyscore", KEYS[1], "-inf", now - window)
+
+	-- templates to contacts we already have a conversation open with are free
+	if redis.call("zscore", KEYS[1], ARGV[4]) then
+		return {1, 0}
+	end
+
+	-- at our limit, the next conversation can be opened once our oldest one closes
+	if redis.call("zcard", KEYS[1]) >= tonumber(ARGV[3]) then
+		local oldest = redis.call("zrange", KEYS[1], 0, 0, "WITHSCORES")
+		return {0, tonumber(oldest[2]) + window}
+	end
+
+	redis.call("zadd", KEYS[1], now, ARGV[4])
+	redis.call("pexpire", KEYS[1], window)
+	return {1, 0}
+`)
+
+// messagingLimitAlert is what we post to our alert webhook when a channel reaches its messaging limit, e.g.
+//
+//	{
+//	  "type": "messaging_limit_reached",
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "channel_type": "WAC",
+//	  "limit": 1000,
+//	  "deferred_until": "2022-10-06T15:04:05.123Z"
+//	}
+type messagingLimitAlert struct {
+	Type          string      `json:"type"`
+	ChannelUUID   ChannelUUID `json:"channel_uuid"`
+	ChannelType   ChannelType `json:"channel_type"`
+	Limit         int         `json:"limit"`
+	DeferredUntil time.Time   `json:"deferred_until"`
+}
+
+// OpenTemplateConversation counts a template sent by the channel with the passed in UUID to the passed in URN as a
+// business-initiated conversation, unless one is already open with them. If the channel has opened as many conversations
+// as the passed in limit in the last 24 hours, it returns false and when its oldest conversation closes instead.
+func OpenTemplateConversation(rp *redis.Pool, uuid ChannelUUID, urn urns.URN, limit int, now time.Time) (bool, time.Time, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	result, err := redis.Int64s(luaOpenTemplateConversation.Do(rc, fmt.Sprintf(templateConversationsKey, uuid), now.UnixNano()/int64(time.Millisecond), templateConversationWindow.Milliseconds(), limit, urn.Identity().String()))
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	if result[0] == 1 {
+		return true, time.Time{}, nil
+	}
+	return false, time.Unix(0, result[1]*int64(time.Millisecond)), nil
+}
+
+// paceTemplate checks whether the passed in msg, if it's a template, would take its channel beyond its messaging limit,
+// returning when it can be sent if so or the zero time if it can be sent now
+func (w *Sender) paceTemplate(msg Msg) time.Time {
+	channel := msg.Channel()
+	limit := channel.IntConfigForKey(ConfigMessagingLimit, 0)
+	if limit <= 0 || CategoryForMsg(msg) != MsgCategoryTemplate {
+		return time.Time{}
+	}
+
+	server := w.foreman.server
+	rp := server.Backend().RedisPool()
+	log := logrus.WithField("comp", "sender").WithField("channel_uuid", channel.UUID())
+
+	opened, until, err := OpenTemplateConversation(rp, channel.UUID(), msg.URN(), limit, time.Now())
+	if err != nil {
+		log.WithError(err).Error("error counting template conversations")
+		return time.Time{}
+	}
+	if opened {
+		return time.Time{}
+	}
+
+	librato.Gauge(fmt.Sprintf("courier.messaging_limit_reached_%s", channel.ChannelType()), float64(1))
+
+	// alert the first time we're held back until our oldest conversation closes
+	rc := rp.Get()
+	defer rc.Close()
+
+	_, err = redis.String(rc.Do("SET", fmt.Sprintf(messagingLimitAlertKey, channel.UUID()), "1", "PX", time.Until(until).Milliseconds()+1, "NX"))
+	if err == nil {
+		log.WithField("limit", limit).Warning("channel reached its messaging limit, deferring templates")
+
+		if server.Config().AlertWebhookURL != "" {
+			alert := &messagingLimitAlert{Type: "messaging_limit_reached", ChannelUUID: channel.UUID(), ChannelType: channel.ChannelType(), Limit: limit, DeferredUntil: until.UTC()}
+			go PostChannelAlert(server.Config().AlertWebhookURL, channel.UUID(), alert)
+		}
+	} else if err != redis.ErrNil {
+		log.WithError(err).Error("error checking messaging limit alert")
+	}
+
+	return until
+}
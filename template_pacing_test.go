@@ -0,0 +1,119 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenTemplateConversation(t *testing.T) {
+	mb := NewMockBackend()
+	rp := mb.RedisPool()
+	uuid := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", nil).UUID()
+
+	rc := rp.Get()
+	_, err := rc.Do("DEL", fmt.Sprintf(templateConversationsKey, uuid))
+	rc.Close()
+	require.NoError(t, err)
+
+	now := time.Date(2022, 10, 5, 15, 4, 5, 0, time.UTC)
+	open := func(urn urns.URN, at time.Time) (bool, time.Time) {
+		opened, until, err := OpenTemplateConversation(rp, uuid, urn, 2, at)
+		require.NoError(t, err)
+		return opened, until
+	}
+
+	opened, _ := open("whatsapp:5511999999991", now)
+	assert.True(t, opened)
+	opened, _ = open("whatsapp:5511999999992", now.Add(time.Hour))
+	assert.True(t, opened)
+
+	// templates to contacts we have a conversation open with don't count
+	opened, _ = open("whatsapp:5511999999991", now.Add(2*time.Hour))
+	assert.True(t, opened)
+
+	// but new conversations wait until the oldest one closes
+	opened, until := open("whatsapp:5511999999993", now.Add(2*time.Hour))
+	assert.False(t, opened)
+	assert.Equal(t, now.Add(24*time.Hour), until.UTC())
+
+	opened, _ = open("whatsapp:5511999999993", now.Add(24*time.Hour+time.Second))
+	assert.True(t, opened)
+	opened, until = open("whatsapp:5511999999994", now.Add(24*time.Hour+time.Second))
+	assert.False(t, opened)
+	assert.Equal(t, now.Add(25*time.Hour), until.UTC())
+}
+
+func TestPaceTemplate(t *testing.T) {
+	alerts := make(chan []byte, 2)
+	alertServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alerts <- body
+	}))
+	defer alertServer.Close()
+
+	config := NewConfig()
+	config.AlertWebhookURL = alertServer.URL
+
+	mb := NewMockBackend()
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "WAC", "12345", "US", map[string]interface{}{ConfigMessagingLimit: 1})
+	mb.AddChannel(channel)
+	rp := mb.RedisPool()
+
+	rc := rp.Get()
+	_, err := rc.Do("DEL", fmt.Sprintf(templateConversationsKey, channel.UUID()), fmt.Sprintf(messagingLimitAlertKey, channel.UUID()))
+	rc.Close()
+	require.NoError(t, err)
+
+	sender := NewSender(NewForeman(NewServer(config, mb), 1), 0)
+	templating := json.RawMessage(`{"templating": {"template": {"uuid": "4ed5000f-5c94-4143-9697-b7cbd230a381", "name": "revive_issue"}, "language": "eng", "variables": []}}`)
+	newMsg := func(urn urns.URN, meta json.RawMessage) Msg {
+		msg, err := mb.QueueOutgoingMsg(context.Background(), channel, urn, "hi", nil, nil, false, meta)
+		require.NoError(t, err)
+		return msg
+	}
+
+	// the first template opens the channel's only conversation
+	assert.True(t, sender.paceTemplate(newMsg("whatsapp:5511999999991", templating)).IsZero())
+
+	// msgs which aren't templates aren't paced
+	assert.True(t, sender.paceTemplate(newMsg("whatsapp:5511999999992", nil)).IsZero())
+
+	// templates to other contacts are deferred until it closes, and sending one defers it
+	msg := newMsg("whatsapp:5511999999992", templating)
+	until := sender.paceTemplate(msg)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), until, time.Minute)
+
+	sender.sendMessage(msg)
+	deferredUntil, deferred := mb.DeferredUntil(msg.ID())
+	assert.True(t, deferred)
+	assert.WithinDuration(t, until, deferredUntil, time.Second)
+	sent, _ := mb.WasMsgSent(context.Background(), msg.ID())
+	assert.False(t, sent)
+
+	// our alert webhook is only told once
+	select {
+	case body := <-alerts:
+		alert := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(body, &alert))
+		assert.Equal(t, "messaging_limit_reached", alert["type"])
+		assert.Equal(t, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", alert["channel_uuid"])
+		assert.Equal(t, float64(1), alert["limit"])
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "no alert posted")
+	}
+	select {
+	case <-alerts:
+		assert.Fail(t, "alert posted twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
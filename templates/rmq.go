@@ -0,0 +1,108 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/furdarius/rabbitroutine"
+	"github.com/gomodule/redigo/redis"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Consumer consumes template sync messages from a RabbitMQ queue and applies them to our cache
+type Consumer struct {
+	url   string
+	queue string
+	rp    *redis.Pool
+
+	conn   *rabbitroutine.Connector
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+// NewRMQConsumer creates a new consumer of the template sync messages of the passed in queue
+func NewRMQConsumer(url string, queue string, rp *redis.Pool) *Consumer {
+	conn := rabbitroutine.NewConnector(rabbitroutine.Config{
+		ReconnectAttempts: 1000,
+		Wait:              2 * time.Second,
+	})
+
+	conn.AddRetriedListener(func(r rabbitroutine.Retried) {
+		logrus.WithField("comp", "templates").WithField("attempt", r.ReconnectAttempt).WithError(r.Error).Info("trying to connect to RabbitMQ")
+	})
+	conn.AddAMQPNotifiedListener(func(n rabbitroutine.AMQPNotified) {
+		logrus.WithField("comp", "templates").WithError(n.Error).Error("RabbitMQ error received")
+	})
+
+	return &Consumer{url: url, queue: queue, rp: rp, conn: conn, done: make(chan bool)}
+}
+
+// Start connects to RabbitMQ and starts consuming in the background, reconnecting as needed
+func (c *Consumer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	go func() {
+		if err := c.conn.Dial(ctx, c.url); err != nil && ctx.Err() == nil {
+			logrus.WithField("comp", "templates").WithError(err).Error("failed to establish RabbitMQ connection")
+		}
+	}()
+
+	go func() {
+		defer close(c.done)
+
+		if err := c.conn.StartConsumer(ctx, c); err != nil && ctx.Err() == nil {
+			logrus.WithField("comp", "templates").WithError(err).Error("template sync consumer stopped")
+		}
+	}()
+}
+
+// Stop stops consuming, waiting for the message being applied if any
+func (c *Consumer) Stop() {
+	c.cancel()
+	<-c.done
+}
+
+// Declare declares our queue, as required by rabbitroutine.Consumer
+func (c *Consumer) Declare(ctx context.Context, ch *amqp.Channel) error {
+	_, err := ch.QueueDeclare(c.queue, true, false, false, false, nil)
+	return err
+}
+
+// Consume applies the messages of our queue until our context is done, as required by rabbitroutine.Consumer
+func (c *Consumer) Consume(ctx context.Context, ch *amqp.Channel) error {
+	deliveries, err := ch.Consume(c.queue, "courier", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			c.handle(delivery.Body)
+			delivery.Ack(false)
+		}
+	}
+}
+
+// handle applies the passed in sync message, messages which can't be applied are logged and dropped
+func (c *Consumer) handle(body []byte) {
+	log := logrus.WithField("comp", "templates")
+
+	msg := &SyncMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		log.WithError(err).Error("error decoding template sync message")
+		return
+	}
+
+	if err := Apply(c.rp, msg); err != nil {
+		log.WithError(err).WithField("channel_uuid", msg.ChannelUUID).Error("error applying template sync message")
+	}
+}
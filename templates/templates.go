@@ -0,0 +1,194 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// the Redis hash of the approved templates of a channel, keyed by name and language
+const cacheKeyPattern = "templates:%s"
+
+// actions of sync messages
+const (
+	ActionUpsert = "upsert"
+	ActionDelete = "delete"
+)
+
+// StatusApproved is the status of templates which can be sent, templates in any other status are removed from our cache
+const StatusApproved = "APPROVED"
+
+// matches the variables of template texts, e.g. {{1}} or {{customer_name}}, and those which are positional
+var variableRegex = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+var positionalRegex = regexp.MustCompile(`^\d+$`)
+
+// Definition is a WhatsApp template as synced from the templates service, e.g.
+//
+//	{
+//	  "name": "order_update",
+//	  "language": "pt_BR",
+//	  "status": "APPROVED",
+//	  "category": "UTILITY",
+//	  "components": [
+//	    {"type": "HEADER", "format": "IMAGE"},
+//	    {"type": "BODY", "text": "Hi {{1}}, your order {{2}} has shipped"},
+//	    {"type": "BUTTONS", "buttons": [{"type": "URL", "text": "Track", "url": "https://example.com/{{1}}"}]}
+//	  ]
+//	}
+type Definition struct {
+	Name       string       `json:"name"`
+	Language   string       `json:"language"`
+	Status     string       `json:"status"`
+	Category   string       `json:"category"`
+	Components []*Component `json:"components"`
+}
+
+// Component is a header, body, footer or the buttons of a template
+type Component struct {
+	Type    string    `json:"type"`
+	Format  string    `json:"format,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Buttons []*Button `json:"buttons,omitempty"`
+}
+
+// Button is a button of a template
+type Button struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	URL  string `json:"url,omitempty"`
+}
+
+// SyncMessage is a change to the templates of a channel published by the templates service, e.g.
+//
+//	{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "action": "upsert", "template": {"name": "order_update", ...}}
+type SyncMessage struct {
+	ChannelUUID string      `json:"channel_uuid"`
+	Action      string      `json:"action"`
+	Template    *Definition `json:"template"`
+}
+
+// component returns the component of the passed in type, if the template has one
+func (d *Definition) component(typ string) *Component {
+	for _, c := range d.Components {
+		if strings.EqualFold(c.Type, typ) {
+			return c
+		}
+	}
+	return nil
+}
+
+// countVariables returns how many distinct variables the passed in text has, or false if they aren't all positional,
+// e.g. named variables like {{customer_name}}, as then we can't tell how many params a send needs
+func countVariables(text string) (int, bool) {
+	indexes := make(map[int]bool)
+	for _, match := range variableRegex.FindAllStringSubmatch(text, -1) {
+		if !positionalRegex.MatchString(match[1]) {
+			return 0, false
+		}
+		index, _ := strconv.Atoi(match[1])
+		indexes[index] = true
+	}
+	return len(indexes), true
+}
+
+// Validate checks that a send of this template with the passed in number of body parameters, header media or not and
+// buttons at the passed in indexes matches its definition. Parts of the definition we don't know how to check sends
+// against, such as named variables or header formats we don't know, are skipped.
+func (d *Definition) Validate(bodyParams int, headerMedia bool, buttonIndexes []int) error {
+	variables, countable := 0, true
+	if body := d.component("BODY"); body != nil {
+		variables, countable = countVariables(body.Text)
+	}
+	if countable && bodyParams != variables {
+		return fmt.Errorf("template %s (%s) has %d body variables but was sent %d", d.Name, d.Language, variables, bodyParams)
+	}
+
+	needsMedia, knownFormat := false, true
+	if header := d.component("HEADER"); header != nil {
+		switch strings.ToUpper(header.Format) {
+		case "IMAGE", "VIDEO", "DOCUMENT":
+			needsMedia = true
+		case "", "TEXT":
+		default:
+			knownFormat = false
+		}
+	}
+	if knownFormat && needsMedia && !headerMedia {
+		return fmt.Errorf("template %s (%s) has a media header but was sent without media", d.Name, d.Language)
+	}
+	if knownFormat && headerMedia && !needsMedia {
+		return fmt.Errorf("template %s (%s) has no media header but was sent with media", d.Name, d.Language)
+	}
+
+	buttons := 0
+	if c := d.component("BUTTONS"); c != nil {
+		buttons = len(c.Buttons)
+	}
+	for _, index := range buttonIndexes {
+		if index < 0 || index >= buttons {
+			return fmt.Errorf("template %s (%s) has no button %d", d.Name, d.Language, index)
+		}
+	}
+	return nil
+}
+
+// cacheField returns the field of the passed in template in the cache hash of its channel
+func cacheField(name, language string) string {
+	return name + "/" + language
+}
+
+// Apply applies the passed in sync message to our cache, approved templates are cached and all others removed
+func Apply(rp *redis.Pool, msg *SyncMessage) error {
+	if msg.ChannelUUID == "" || msg.Template == nil || msg.Template.Name == "" || msg.Template.Language == "" {
+		return fmt.Errorf("sync message missing channel or template")
+	}
+
+	rc := rp.Get()
+	defer rc.Close()
+
+	key := fmt.Sprintf(cacheKeyPattern, msg.ChannelUUID)
+	field := cacheField(msg.Template.Name, msg.Template.Language)
+
+	switch msg.Action {
+	case ActionUpsert:
+		if strings.EqualFold(msg.Template.Status, StatusApproved) {
+			value, err := json.Marshal(msg.Template)
+			if err != nil {
+				return err
+			}
+			_, err = rc.Do("HSET", key, field, value)
+			return err
+		}
+		_, err := rc.Do("HDEL", key, field)
+		return err
+	case ActionDelete:
+		_, err := rc.Do("HDEL", key, field)
+		return err
+	}
+	return fmt.Errorf("unknown sync action: %s", msg.Action)
+}
+
+// Get returns the cached definition of the approved template of the passed in channel with the passed in name and
+// language, or nil if we don't have it
+func Get(rp *redis.Pool, channelUUID, name, language string) (*Definition, error) {
+	rc := rp.Get()
+	defer rc.Close()
+
+	value, err := redis.Bytes(rc.Do("HGET", fmt.Sprintf(cacheKeyPattern, channelUUID), cacheField(name, language)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	definition := &Definition{}
+	if err := json.Unmarshal(value, definition); err != nil {
+		return nil, err
+	}
+	return definition, nil
+}
@@ -0,0 +1,125 @@
+package templates
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPool() *redis.Pool {
+	redisPool := &redis.Pool{
+		Wait:        true,
+		MaxActive:   5,
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Do("SELECT", 12)
+			return conn, err
+		},
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("FLUSHDB")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return redisPool
+}
+
+func newDefinition(status string) *Definition {
+	return &Definition{
+		Name:     "order_update",
+		Language: "pt_BR",
+		Status:   status,
+		Category: "UTILITY",
+		Components: []*Component{
+			{Type: "HEADER", Format: "IMAGE"},
+			{Type: "BODY", Text: "Hi {{1}}, your order {{ 2 }} has shipped"},
+			{Type: "BUTTONS", Buttons: []*Button{{Type: "URL", Text: "Track", URL: "https://example.com/{{1}}"}}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	definition := newDefinition(StatusApproved)
+
+	assert.NoError(t, definition.Validate(2, true, []int{0}))
+	assert.NoError(t, definition.Validate(2, true, nil))
+	assert.EqualError(t, definition.Validate(1, true, nil), "template order_update (pt_BR) has 2 body variables but was sent 1")
+	assert.EqualError(t, definition.Validate(2, false, nil), "template order_update (pt_BR) has a media header but was sent without media")
+	assert.EqualError(t, definition.Validate(2, true, []int{1}), "template order_update (pt_BR) has no button 1")
+
+	plain := &Definition{Name: "hello", Language: "en", Components: []*Component{{Type: "BODY", Text: "Hello!"}}}
+	assert.NoError(t, plain.Validate(0, false, nil))
+	assert.EqualError(t, plain.Validate(0, true, nil), "template hello (en) has no media header but was sent with media")
+
+	// variables used more than once are only sent once
+	repeated := &Definition{Name: "reminder", Language: "en", Components: []*Component{{Type: "BODY", Text: "Hi {{1}}, {{2}} is due. Thanks {{1}}!"}}}
+	assert.NoError(t, repeated.Validate(2, false, nil))
+	assert.EqualError(t, repeated.Validate(3, false, nil), "template reminder (en) has 2 body variables but was sent 3")
+
+	// we can't tell how many params named variables need, or whether headers of formats we don't know need media
+	named := &Definition{Name: "greeting", Language: "en", Components: []*Component{
+		{Type: "HEADER", Format: "LOCATION"},
+		{Type: "BODY", Text: "Hi {{customer_name}}, your order {{order_id}} has shipped"},
+	}}
+	assert.NoError(t, named.Validate(2, false, nil))
+	assert.NoError(t, named.Validate(1, true, nil))
+}
+
+func TestApply(t *testing.T) {
+	rp := getPool()
+	channelUUID := "dbc126ed-66bc-4e28-b67b-81dc3327c95d"
+
+	// approved templates are cached
+	require.NoError(t, Apply(rp, &SyncMessage{ChannelUUID: channelUUID, Action: ActionUpsert, Template: newDefinition("APPROVED")}))
+	definition, err := Get(rp, channelUUID, "order_update", "pt_BR")
+	assert.NoError(t, err)
+	require.NotNil(t, definition)
+	assert.Equal(t, "Hi {{1}}, your order {{ 2 }} has shipped", definition.Components[1].Text)
+
+	// but only for their channel and language
+	definition, err = Get(rp, "8eb23e93-5ecb-45ba-b726-3b064e0c56ab", "order_update", "pt_BR")
+	assert.NoError(t, err)
+	assert.Nil(t, definition)
+	definition, err = Get(rp, channelUUID, "order_update", "en")
+	assert.NoError(t, err)
+	assert.Nil(t, definition)
+
+	// templates which are no longer approved are removed
+	require.NoError(t, Apply(rp, &SyncMessage{ChannelUUID: channelUUID, Action: ActionUpsert, Template: newDefinition("PAUSED")}))
+	definition, _ = Get(rp, channelUUID, "order_update", "pt_BR")
+	assert.Nil(t, definition)
+
+	// as are deleted ones
+	require.NoError(t, Apply(rp, &SyncMessage{ChannelUUID: channelUUID, Action: ActionUpsert, Template: newDefinition("APPROVED")}))
+	require.NoError(t, Apply(rp, &SyncMessage{ChannelUUID: channelUUID, Action: ActionDelete, Template: newDefinition("APPROVED")}))
+	definition, _ = Get(rp, channelUUID, "order_update", "pt_BR")
+	assert.Nil(t, definition)
+
+	assert.EqualError(t, Apply(rp, &SyncMessage{ChannelUUID: channelUUID, Action: "rename", Template: newDefinition("APPROVED")}), "unknown sync action: rename")
+	assert.EqualError(t, Apply(rp, &SyncMessage{Action: ActionUpsert}), "sync message missing channel or template")
+}
+
+func TestHandle(t *testing.T) {
+	rp := getPool()
+	consumer := NewRMQConsumer("amqp://localhost", "templates_sync", rp)
+
+	consumer.handle([]byte(`{"channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "action": "upsert", "template": {"name": "hello", "language": "en", "status": "APPROVED", "components": [{"type": "BODY", "text": "Hello {{1}}"}]}}`))
+	consumer.handle([]byte(`not json`))
+
+	definition, err := Get(rp, "dbc126ed-66bc-4e28-b67b-81dc3327c95d", "hello", "en")
+	assert.NoError(t, err)
+	require.NotNil(t, definition)
+	assert.NoError(t, definition.Validate(1, false, nil))
+}
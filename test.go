@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -34,15 +35,30 @@ type MockBackend struct {
 
 	mutex           sync.RWMutex
 	outgoingMsgs    []Msg
+	lastOutgoingID  int64
 	msgStatuses     []MsgStatus
 	channelEvents   []ChannelEvent
+	accountEvents   []*AccountEvent
+	auditRecords    []*AuditRecord
+	sendStats       map[SendStat]int
+	exportedMsgs    map[ChannelUUID][]*ExportedMsg
+	mediaBackfill   *MediaBackfillResult
+	mediaSince      time.Time
 	channelLogs     []*ChannelLog
 	lastContactName string
 
-	sentMsgs  map[MsgID]bool
-	redisPool *redis.Pool
+	sentMsgs     map[MsgID]bool
+	deferredMsgs map[MsgID]time.Time
+	deletedMsgs  map[string]bool
+	redisPool    *redis.Pool
 
 	seenExternalIDs []string
+
+	msgHandledHook MsgHandledHook
+
+	channelHealth map[ChannelUUID]*ChannelHealth
+	costEstimates map[MsgID]*MsgCostEstimate
+	msgPricings   []*MsgPricing
 }
 
 // NewMockBackend returns a new mock backend suitable for testing
@@ -74,7 +90,12 @@ func NewMockBackend() *MockBackend {
 		channelsByAddress: make(map[ChannelAddress]Channel),
 		contacts:          make(map[urns.URN]Contact),
 		sentMsgs:          make(map[MsgID]bool),
+		deferredMsgs:      make(map[MsgID]time.Time),
+		deletedMsgs:       make(map[string]bool),
 		redisPool:         redisPool,
+		channelHealth:     make(map[ChannelUUID]*ChannelHealth),
+		costEstimates:     make(map[MsgID]*MsgCostEstimate),
+		sendStats:         make(map[SendStat]int),
 	}
 }
 
@@ -115,9 +136,17 @@ func (mb *MockBackend) GetLastContactName() string {
 	return mb.lastContactName
 }
 
-// DeleteMsgWithExternalID delete a message we receive an event that it should be deleted
-func (mb *MockBackend) DeleteMsgWithExternalID(ctx context.Context, channel Channel, externalID string) error {
-	return nil
+// DeleteMsgWithExternalID marks the msg with the passed in external ID as deleted, returning false if it already was
+func (mb *MockBackend) DeleteMsgWithExternalID(ctx context.Context, channel Channel, urn urns.URN, externalID string) (bool, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	key := fmt.Sprintf("%s|%s", channel.UUID(), externalID)
+	if mb.deletedMsgs[key] {
+		return false, nil
+	}
+	mb.deletedMsgs[key] = true
+	return true, nil
 }
 
 // NewIncomingMsg creates a new message from the given params
@@ -125,6 +154,11 @@ func (mb *MockBackend) NewIncomingMsg(channel Channel, urn urns.URN, text string
 	return &mockMsg{channel: channel, urn: urn, text: text}
 }
 
+// NewSyncedMsg creates a new synced message from the given params
+func (mb *MockBackend) NewSyncedMsg(channel Channel, urn urns.URN, text string, outgoing bool) Msg {
+	return &mockMsg{channel: channel, urn: urn, text: text, synced: true, outgoing: outgoing}
+}
+
 // NewOutgoingMsg creates a new outgoing message from the given params
 func (mb *MockBackend) NewOutgoingMsg(channel Channel, id MsgID, urn urns.URN, text string, highPriority bool, quickReplies []string, topic string, responseToID int64, responseToExternalID string, textLanguage string) Msg {
 	msgResponseToID := NilMsgID
@@ -143,6 +177,31 @@ func (mb *MockBackend) PushOutgoingMsg(msg Msg) {
 	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
 }
 
+// QueueOutgoingMsg creates a new outgoing message and adds it to our queue of messages to send
+func (mb *MockBackend) QueueOutgoingMsg(ctx context.Context, channel Channel, urn urns.URN, text string, attachments []string, quickReplies []string, highPriority bool, metadata json.RawMessage) (Msg, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.lastOutgoingID++
+	msg := &mockMsg{channel: channel, id: NewMsgID(mb.lastOutgoingID), uuid: NewMsgUUID(), urn: urn, text: text, attachments: attachments, quickReplies: quickReplies, highPriority: highPriority, metadata: metadata}
+	mb.outgoingMsgs = append(mb.outgoingMsgs, msg)
+	return msg, nil
+}
+
+// NewSimulatedMsg builds an outgoing msg without queueing it, on a copy of the passed in channel with the config overrides
+func (mb *MockBackend) NewSimulatedMsg(channel Channel, config map[string]interface{}, urn urns.URN, text string, attachments []string, quickReplies []string, metadata json.RawMessage) (Msg, error) {
+	simulated := *channel.(*MockChannel)
+	simulated.config = make(map[string]interface{}, len(simulated.config)+len(config))
+	for key, value := range channel.(*MockChannel).config {
+		simulated.config[key] = value
+	}
+	for key, value := range config {
+		simulated.config[key] = value
+	}
+
+	return &mockMsg{channel: &simulated, uuid: NewMsgUUID(), urn: urn, text: text, attachments: attachments, quickReplies: quickReplies, metadata: metadata}, nil
+}
+
 // PopNextOutgoingMsg returns the next message that should be sent, or nil if there are none to send
 func (mb *MockBackend) PopNextOutgoingMsg(ctx context.Context) (Msg, error) {
 	mb.mutex.Lock()
@@ -186,6 +245,27 @@ func (mb *MockBackend) MarkOutgoingMsgComplete(ctx context.Context, msg Msg, s M
 	mb.sentMsgs[msg.ID()] = true
 }
 
+// DeferOutgoingMsg records the passed in msg as deferred until the passed in time
+func (mb *MockBackend) DeferOutgoingMsg(ctx context.Context, msg Msg, until time.Time) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.deferredMsgs[msg.ID()] = until
+	return nil
+}
+
+// DeferredUntil returns when the msg with the passed in id was deferred until, if it was deferred
+func (mb *MockBackend) DeferredUntil(id MsgID) (time.Time, bool) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	until, found := mb.deferredMsgs[id]
+	return until, found
+}
+
+// OutgoingMsgNotifications returns nil, callers just poll our queue
+func (mb *MockBackend) OutgoingMsgNotifications() <-chan bool { return nil }
+
 // WriteChannelLogs writes the passed in channel logs to the DB
 func (mb *MockBackend) WriteChannelLogs(ctx context.Context, logs []*ChannelLog) error {
 	mb.mutex.Lock()
@@ -197,6 +277,125 @@ func (mb *MockBackend) WriteChannelLogs(ctx context.Context, logs []*ChannelLog)
 	return nil
 }
 
+// GetChannelLogs returns the most recent logs written for the passed in channel and msg
+func (mb *MockBackend) GetChannelLogs(ctx context.Context, channel Channel, msgID MsgID, limit int) ([]*StoredChannelLog, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	logs := make([]*StoredChannelLog, 0, limit)
+	for i := len(mb.channelLogs) - 1; i >= 0 && len(logs) < limit; i-- {
+		log := mb.channelLogs[i]
+		if log.Channel.UUID() != channel.UUID() || (msgID != NilMsgID && log.MsgID != msgID) {
+			continue
+		}
+		logs = append(logs, &StoredChannelLog{
+			MsgID:          log.MsgID,
+			Description:    log.Description,
+			IsError:        log.Error != "",
+			Method:         log.Method,
+			URL:            log.URL,
+			Request:        log.Request,
+			Response:       log.Response,
+			ResponseStatus: log.StatusCode,
+			CreatedOn:      log.CreatedOn,
+			RequestTime:    int(log.Elapsed / time.Millisecond),
+		})
+	}
+	return logs, nil
+}
+
+// WriteChannelHeartbeat records the passed in heartbeat, the mock never marks channels unhealthy on its own
+func (mb *MockBackend) WriteChannelHeartbeat(ctx context.Context, channel Channel, status ChannelHeartbeatStatus) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	health, found := mb.channelHealth[channel.UUID()]
+	if !found {
+		health = &ChannelHealth{ChannelUUID: channel.UUID(), Healthy: true}
+		mb.channelHealth[channel.UUID()] = health
+	}
+
+	now := time.Now()
+	if status == ChannelHeartbeatOK {
+		health.ConsecutiveFailures = 0
+		health.LastSuccessOn = &now
+	} else {
+		health.ConsecutiveFailures++
+		health.LastFailureOn = &now
+	}
+	return nil
+}
+
+// GetChannelHealth returns the health of the passed in channel
+func (mb *MockBackend) GetChannelHealth(ctx context.Context, uuid ChannelUUID) (*ChannelHealth, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	health, found := mb.channelHealth[uuid]
+	if !found {
+		return &ChannelHealth{ChannelUUID: uuid, Healthy: true}, nil
+	}
+	return health, nil
+}
+
+// GetChannelAlerts returns the alert events written for the passed in channel, newest first
+func (mb *MockBackend) GetChannelAlerts(ctx context.Context, uuid ChannelUUID, limit int) ([]ChannelEvent, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	alerts := make([]ChannelEvent, 0)
+	for i := len(mb.channelEvents) - 1; i >= 0 && len(alerts) < limit; i-- {
+		if event := mb.channelEvents[i]; event.EventType() == ChannelAlert && event.ChannelUUID() == uuid {
+			alerts = append(alerts, event)
+		}
+	}
+	return alerts, nil
+}
+
+// WriteMsgCostEstimate records the estimated cost of the passed in message
+func (mb *MockBackend) WriteMsgCostEstimate(ctx context.Context, msg Msg, estimate *MsgCostEstimate) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.costEstimates[msg.ID()] = estimate
+	return nil
+}
+
+// WriteMsgPricing records the passed in pricing, once for each external id
+func (mb *MockBackend) WriteMsgPricing(ctx context.Context, channel Channel, pricing *MsgPricing) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if pricing.ExternalID != "" {
+		for _, p := range mb.msgPricings {
+			if p.ExternalID == pricing.ExternalID {
+				return nil
+			}
+		}
+	}
+	mb.msgPricings = append(mb.msgPricings, pricing)
+	return nil
+}
+
+// GetLastMsgPricing returns the last pricing written to the backend
+func (mb *MockBackend) GetLastMsgPricing() *MsgPricing {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	if len(mb.msgPricings) == 0 {
+		return nil
+	}
+	return mb.msgPricings[len(mb.msgPricings)-1]
+}
+
+// GetMsgCostEstimate returns the estimated cost recorded for the passed in message
+func (mb *MockBackend) GetMsgCostEstimate(id MsgID) *MsgCostEstimate {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	return mb.costEstimates[id]
+}
+
 // SetErrorOnQueue is a mock method which makes the QueueMsg call throw the passed in error on next call
 func (mb *MockBackend) SetErrorOnQueue(shouldError bool) {
 	mb.errorOnQueue = shouldError
@@ -217,9 +416,46 @@ func (mb *MockBackend) WriteMsg(ctx context.Context, m Msg) error {
 
 	mb.queueMsgs = append(mb.queueMsgs, m)
 	mb.lastContactName = m.(*mockMsg).contactName
+
+	if mb.msgHandledHook != nil {
+		mb.msgHandledHook(ctx, m)
+	}
 	return nil
 }
 
+// SetMsgHandledHook sets what is called once a msg has been written
+func (mb *MockBackend) SetMsgHandledHook(hook MsgHandledHook) {
+	mb.msgHandledHook = hook
+}
+
+// AddMsgMetadata sets the passed in key of the metadata of the written msg with the passed in UUID
+func (mb *MockBackend) AddMsgMetadata(ctx context.Context, uuid MsgUUID, key string, value interface{}) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	// newest first, as our msgs don't all have UUIDs
+	for i := len(mb.queueMsgs) - 1; i >= 0; i-- {
+		m := mb.queueMsgs[i]
+		if m.UUID() != uuid {
+			continue
+		}
+		metadata := map[string]interface{}{}
+		if len(m.Metadata()) > 0 {
+			if err := json.Unmarshal(m.Metadata(), &metadata); err != nil {
+				return err
+			}
+		}
+		metadata[key] = value
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		m.WithMetadata(encoded)
+		return nil
+	}
+	return ErrMsgNotFound
+}
+
 // NewMsgStatusForID creates a new Status object for the given message id
 func (mb *MockBackend) NewMsgStatusForID(channel Channel, id MsgID, status MsgStatusValue) MsgStatus {
 	return &mockMsgStatus{
@@ -265,9 +501,133 @@ func (mb *MockBackend) WriteChannelEvent(ctx context.Context, event ChannelEvent
 
 	mb.channelEvents = append(mb.channelEvents, event)
 	mb.lastContactName = event.(*mockChannelEvent).contactName
+
+	// like our real backend, writing an event about a contact creates them if they don't exist
+	if urn := event.URN(); urn != urns.NilURN {
+		if _, found := mb.contacts[urn]; !found {
+			uuid, _ := NewContactUUID(string(uuids.New()))
+			mb.contacts[urn] = &mockContact{event.(*mockChannelEvent).channel, urn, "", uuid}
+		}
+	}
+	return nil
+}
+
+// WriteAccountEvent writes the passed in account event
+func (mb *MockBackend) WriteAccountEvent(ctx context.Context, event *AccountEvent) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.accountEvents = append(mb.accountEvents, event)
+	return nil
+}
+
+// AccountEvents returns the account events written to this backend
+func (mb *MockBackend) AccountEvents() []*AccountEvent {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	return mb.accountEvents
+}
+
+// WriteAuditRecord writes the passed in audit record
+func (mb *MockBackend) WriteAuditRecord(ctx context.Context, record *AuditRecord) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.auditRecords = append(mb.auditRecords, record)
+	return nil
+}
+
+// GetAuditRecords returns up to limit of the audit records written to this backend, newest first
+func (mb *MockBackend) GetAuditRecords(ctx context.Context, limit int) ([]*AuditRecord, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	records := make([]*AuditRecord, 0, limit)
+	for i := len(mb.auditRecords) - 1; i >= 0 && len(records) < limit; i-- {
+		records = append(records, mb.auditRecords[i])
+	}
+	return records, nil
+}
+
+// WriteSendStat counts a msg of the passed in segment as sent by the passed in channel
+func (mb *MockBackend) WriteSendStat(ctx context.Context, channel Channel, segment string, sentOn time.Time) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	key := SendStat{Day: sentOn.UTC().Format(sendStatsDayFormat), ChannelUUID: channel.UUID(), Segment: segment}
+	mb.sendStats[key]++
 	return nil
 }
 
+// GetSendStats returns the counts of msgs sent on the passed in day, ordered by channel and segment
+func (mb *MockBackend) GetSendStats(ctx context.Context, day time.Time) ([]*SendStat, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	stats := make([]*SendStat, 0)
+	for key, count := range mb.sendStats {
+		if key.Day == day.UTC().Format(sendStatsDayFormat) {
+			stat := key
+			stat.Count = count
+			stats = append(stats, &stat)
+		}
+	}
+	SortSendStats(stats)
+	return stats, nil
+}
+
+// AddExportedMsg adds a msg to be exported for the passed in channel, msgs should be added in order of ID
+func (mb *MockBackend) AddExportedMsg(channel Channel, msg *ExportedMsg) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if mb.exportedMsgs == nil {
+		mb.exportedMsgs = make(map[ChannelUUID][]*ExportedMsg)
+	}
+	mb.exportedMsgs[channel.UUID()] = append(mb.exportedMsgs[channel.UUID()], msg)
+}
+
+// ExportMsgs returns up to limit of the msgs added for the passed in channel within the passed in range and after the cursor
+func (mb *MockBackend) ExportMsgs(ctx context.Context, channel Channel, after time.Time, before time.Time, cursor MsgID, limit int) ([]*ExportedMsg, error) {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+
+	msgs := make([]*ExportedMsg, 0, limit)
+	for _, msg := range mb.exportedMsgs[channel.UUID()] {
+		if msg.ID > cursor && !msg.CreatedOn.Before(after) && msg.CreatedOn.Before(before) && len(msgs) < limit {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+
+// SetMediaBackfillResult sets the result our media backfills return
+func (mb *MockBackend) SetMediaBackfillResult(result *MediaBackfillResult) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.mediaBackfill = result
+}
+
+// LastMediaBackfillSince returns the time our last media backfill scanned msgs since
+func (mb *MockBackend) LastMediaBackfillSince() time.Time {
+	mb.mutex.RLock()
+	defer mb.mutex.RUnlock()
+	return mb.mediaSince
+}
+
+// BackfillMedia records the time msgs were to be scanned since and returns the result set on us
+func (mb *MockBackend) BackfillMedia(ctx context.Context, since time.Time) (*MediaBackfillResult, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	mb.mediaSince = since
+	if mb.mediaBackfill == nil {
+		return &MediaBackfillResult{}, nil
+	}
+	return mb.mediaBackfill, nil
+}
+
 // GetChannel returns the channel with the passed in type and channel uuid
 func (mb *MockBackend) GetChannel(ctx context.Context, cType ChannelType, uuid ChannelUUID) (Channel, error) {
 	channel, found := mb.channels[uuid]
@@ -286,6 +646,49 @@ func (mb *MockBackend) GetChannelByAddress(ctx context.Context, cType ChannelTyp
 	return channel, nil
 }
 
+// GetChannelByWebhookToken returns the channel with the passed in type and webhook token
+func (mb *MockBackend) GetChannelByWebhookToken(ctx context.Context, cType ChannelType, token string) (Channel, error) {
+	for _, channel := range mb.channels {
+		if channel.ChannelType() == cType && token != "" && channel.StringConfigForKey(ConfigWebhookToken, "") == token {
+			return channel, nil
+		}
+	}
+	return nil, ErrChannelNotFound
+}
+
+// GetChannelsByConfig returns the channels with the passed in type whose config has the passed in value for the passed in key
+func (mb *MockBackend) GetChannelsByConfig(ctx context.Context, cType ChannelType, key string, value string) ([]Channel, error) {
+	channels := make([]Channel, 0)
+	for _, channel := range mb.channels {
+		if channel.ChannelType() == cType && value != "" && channel.StringConfigForKey(key, "") == value {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].UUID().String() < channels[j].UUID().String() })
+	return channels, nil
+}
+
+// UpdateChannelConfig merges the passed in values into the config of the passed in channel
+func (mb *MockBackend) UpdateChannelConfig(ctx context.Context, channel Channel, config map[string]interface{}) error {
+	mc, isMock := channel.(*MockChannel)
+	if !isMock {
+		return ErrChannelNotFound
+	}
+	for k, v := range config {
+		mc.SetConfig(k, v)
+	}
+	return nil
+}
+
+// CreateChannel creates a new mock channel and adds it to our channels
+func (mb *MockBackend) CreateChannel(ctx context.Context, orgID int64, channelType ChannelType, address ChannelAddress, name string, schemes []string, config map[string]interface{}) (Channel, error) {
+	channel := NewMockChannel(string(uuids.New()), string(channelType), string(address), "", config)
+	channel.schemes = schemes
+	channel.orgID = orgID
+	mb.AddChannel(channel)
+	return channel, nil
+}
+
 // GetContact creates a new contact with the passed in channel and URN
 func (mb *MockBackend) GetContact(ctx context.Context, channel Channel, urn urns.URN, auth string, name string) (Contact, error) {
 	contact, found := mb.contacts[urn]
@@ -297,6 +700,15 @@ func (mb *MockBackend) GetContact(ctx context.Context, channel Channel, urn urns
 	return contact, nil
 }
 
+// LookupContact returns the contact with the passed in URN without creating it, or nil if there isn't one
+func (mb *MockBackend) LookupContact(ctx context.Context, channel Channel, urn urns.URN) (Contact, error) {
+	contact, found := mb.contacts[urn]
+	if !found {
+		return nil, nil
+	}
+	return contact, nil
+}
+
 // UpdateContactLastSeenOn updates last seen on (and modified on) on the passed in contact
 func (mb *MockBackend) UpdateContactLastSeenOn(ctx context.Context, contactUUID ContactUUID, lastSeenOn time.Time) error {
 	return nil
@@ -421,6 +833,7 @@ type MockChannel struct {
 	role        string
 	config      map[string]interface{}
 	orgConfig   map[string]interface{}
+	orgID       int64
 }
 
 // UUID returns the uuid for this channel
@@ -432,6 +845,12 @@ func (c *MockChannel) Name() string { return fmt.Sprintf("Channel: %s", c.uuid.S
 // ChannelType returns the type of this channel
 func (c *MockChannel) ChannelType() ChannelType { return c.channelType }
 
+// ChannelOrgID returns the id of the org this channel is for
+func (c *MockChannel) ChannelOrgID() int64 { return c.orgID }
+
+// SetOrgID sets the id of the org this channel is for
+func (c *MockChannel) SetOrgID(orgID int64) { c.orgID = orgID }
+
 // SetScheme sets the scheme for this channel
 func (c *MockChannel) SetScheme(scheme string) { c.schemes = []string{scheme} }
 
@@ -466,6 +885,15 @@ func (c *MockChannel) CallbackDomain(fallbackDomain string) string {
 	return value.(string)
 }
 
+// Config returns a copy of the config of this channel
+func (c *MockChannel) Config() map[string]interface{} {
+	config := make(map[string]interface{}, len(c.config))
+	for key, value := range c.config {
+		config[key] = value
+	}
+	return config
+}
+
 // ConfigForKey returns the config value for the passed in key
 func (c *MockChannel) ConfigForKey(key string, defaultValue interface{}) interface{} {
 	value, found := c.config[key]
@@ -594,6 +1022,8 @@ type mockMsg struct {
 	alreadyWritten       bool
 	isResend             bool
 	textLanguage         string
+	synced               bool
+	outgoing             bool
 
 	receivedOn *time.Time
 	sentOn     *time.Time
@@ -616,12 +1046,14 @@ func (m *mockMsg) URN() urns.URN                { return m.urn }
 func (m *mockMsg) URNAuth() string              { return m.urnAuth }
 func (m *mockMsg) ContactName() string          { return m.contactName }
 func (m *mockMsg) HighPriority() bool           { return m.highPriority }
+func (m *mockMsg) Priority() MsgPriority        { return NewMsgPriority(m.metadata, m.highPriority) }
 func (m *mockMsg) QuickReplies() []string       { return m.quickReplies }
 func (m *mockMsg) Topic() string                { return m.topic }
 func (m *mockMsg) ResponseToID() MsgID          { return m.responseToID }
 func (m *mockMsg) ResponseToExternalID() string { return m.responseToExternalID }
 func (m *mockMsg) Metadata() json.RawMessage    { return m.metadata }
 func (m *mockMsg) IsResend() bool               { return m.isResend }
+func (m *mockMsg) IsSynced() bool               { return m.synced }
 func (m *mockMsg) TextLanguage() string         { return m.textLanguage }
 
 func (m *mockMsg) ReceivedOn() *time.Time { return m.receivedOn }
@@ -639,6 +1071,7 @@ func (m *mockMsg) WithAttachment(url string) Msg {
 	return m
 }
 func (m *mockMsg) WithMetadata(metadata json.RawMessage) Msg { m.metadata = metadata; return m }
+func (m *mockMsg) WithText(text string) Msg                  { m.text = text; return m }
 func (m *mockMsg) Status() MsgStatusValue                    { return "" }
 
 func (m *mockMsg) Header() string {
@@ -773,9 +1206,11 @@ type mockMsgStatus struct {
 	newURN     urns.URN
 	externalID string
 	status     MsgStatusValue
+	errorClass ErrorClass
 	createdOn  time.Time
 
-	logs []*ChannelLog
+	parts []*MsgStatusPart
+	logs  []*ChannelLog
 }
 
 func (m *mockMsgStatus) ChannelUUID() ChannelUUID { return m.channel.UUID() }
@@ -800,9 +1235,20 @@ func (m *mockMsgStatus) HasUpdatedURN() bool {
 func (m *mockMsgStatus) ExternalID() string      { return m.externalID }
 func (m *mockMsgStatus) SetExternalID(id string) { m.externalID = id }
 
+func (m *mockMsgStatus) AddPart(externalID string, status MsgStatusValue) {
+	m.parts = append(m.parts, &MsgStatusPart{ExternalID: externalID, Status: status})
+	if m.externalID == "" {
+		m.externalID = externalID
+	}
+}
+func (m *mockMsgStatus) Parts() []*MsgStatusPart { return m.parts }
+
 func (m *mockMsgStatus) Status() MsgStatusValue          { return m.status }
 func (m *mockMsgStatus) SetStatus(status MsgStatusValue) { m.status = status }
 
+func (m *mockMsgStatus) ErrorClass() ErrorClass              { return m.errorClass }
+func (m *mockMsgStatus) SetErrorClass(errorClass ErrorClass) { m.errorClass = errorClass }
+
 func (m *mockMsgStatus) Logs() []*ChannelLog    { return m.logs }
 func (m *mockMsgStatus) AddLog(log *ChannelLog) { m.logs = append(m.logs, log) }
 
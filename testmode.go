@@ -0,0 +1,103 @@
+package courier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gofrs/uuid"
+	"github.com/nyaruka/gocommon/urns"
+)
+
+// TestModeEnabled returns whether the passed in channel is in test mode, in which case sends never reach the vendor
+func TestModeEnabled(channel Channel) bool {
+	return channel.BoolConfigForKey(ConfigTestMode, false)
+}
+
+// sendTestMsg records the passed in msg as wired with a synthetic external ID instead of sending it
+func sendTestMsg(backend Backend, msg Msg) MsgStatus {
+	externalID := fmt.Sprintf("test-%s", uuid.Must(uuid.NewV4()))
+
+	status := backend.NewMsgStatusForID(msg.Channel(), msg.ID(), MsgWired)
+	status.SetExternalID(externalID)
+
+	request, _ := json.Marshal(map[string]interface{}{"urn": msg.URN().String(), "text": msg.Text(), "attachments": msg.Attachments()})
+	status.AddLog(NewChannelLog("Test Mode Send", msg.Channel(), msg.ID(), "", "", http.StatusOK, string(request), externalID, 0, nil))
+	return status
+}
+
+// testMsgPayload is the body posted to inject an incoming message into a channel in test mode
+//
+//	{
+//	  "urn": "whatsapp:5511999999999",
+//	  "text": "Hello",
+//	  "name": "Bob",
+//	  "attachments": ["image/jpeg:https://example.com/image.jpg"]
+//	}
+type testMsgPayload struct {
+	URN         string   `json:"urn"`
+	Text        string   `json:"text"`
+	Name        string   `json:"name"`
+	Attachments []string `json:"attachments"`
+}
+
+// handleTestReceive writes the posted message as if the vendor had delivered it to the channel, which must be in test mode
+func (s *server) handleTestReceive(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAdminAuth(w, r) {
+		return
+	}
+	ctx := r.Context()
+
+	channelUUID, err := NewChannelUUID(chi.URLParam(r, "uuid"))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	channel, err := s.backend.GetChannel(ctx, AnyChannelType, channelUUID)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	if !TestModeEnabled(channel) {
+		WriteError(ctx, w, r, fmt.Errorf("channel is not in test mode"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 100000))
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+
+	payload := &testMsgPayload{}
+	err = json.Unmarshal(body, payload)
+	if err != nil {
+		WriteError(ctx, w, r, fmt.Errorf("unable to parse request JSON: %s", err))
+		return
+	}
+
+	urn, err := urns.Parse(payload.URN)
+	if err == nil {
+		err = urn.Validate()
+	}
+	if err != nil {
+		WriteError(ctx, w, r, fmt.Errorf("invalid urn: %s", payload.URN))
+		return
+	}
+
+	msg := s.backend.NewIncomingMsg(channel, urn, payload.Text).WithReceivedOn(time.Now().UTC()).WithContactName(payload.Name)
+	for _, attachment := range payload.Attachments {
+		msg.WithAttachment(attachment)
+	}
+
+	err = s.backend.WriteMsg(ctx, msg)
+	if err != nil {
+		WriteError(ctx, w, r, err)
+		return
+	}
+	WriteMsgSuccess(ctx, w, r, []Msg{msg})
+}
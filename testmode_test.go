@@ -0,0 +1,64 @@
+package courier
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/nyaruka/gocommon/urns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTestMode(t *testing.T) {
+	config := NewConfig()
+	config.IncludeChannels = []string{"EM"}
+
+	testChannel := NewMockChannel("1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e", "EM", "2020", "US", map[string]interface{}{ConfigTestMode: true})
+	liveChannel := NewMockChannel("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "EM", "2021", "US", map[string]interface{}{})
+
+	mb := NewMockBackend()
+	mb.AddChannel(testChannel)
+	mb.AddChannel(liveChannel)
+
+	server := newAdminTestServer(t, config, mb, &embeddedHandler{})
+	defer server.Close()
+
+	assert.True(t, TestModeEnabled(testChannel))
+	assert.False(t, TestModeEnabled(liveChannel))
+
+	// sends on channels in test mode are wired without reaching the handler
+	msg := mb.NewOutgoingMsg(testChannel, MsgID(10), urns.URN("tel:+12065551212"), "Hi", false, nil, "", 0, "", "")
+	status, err := server.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MsgWired, status.Status())
+	assert.True(t, strings.HasPrefix(status.ExternalID(), "test-"))
+	assert.Equal(t, "Test Mode Send", status.Logs()[0].Description)
+
+	// others are sent by their handler
+	msg = mb.NewOutgoingMsg(liveChannel, MsgID(11), urns.URN("tel:+12065551212"), "Hi", false, nil, "", 0, "", "")
+	status, err = server.SendMsg(context.Background(), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, MsgSent, status.Status())
+
+	// incoming messages can be injected
+	code, body := server.request(http.MethodPost, "/c/test/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive", `{"urn": "tel:+12065551212", "text": "Hello", "name": "Bob", "attachments": ["image/jpeg:https://example.com/image.jpg"]}`, true)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "Message Accepted")
+
+	received, err := mb.GetLastQueueMsg()
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", received.Text())
+	assert.Equal(t, urns.URN("tel:+12065551212"), received.URN())
+	assert.Equal(t, []string{"image/jpeg:https://example.com/image.jpg"}, received.Attachments())
+
+	// invalid URN
+	code, body = server.request(http.MethodPost, "/c/test/1c6b3a3f-9c6d-4bbe-8a6b-0b9bb1e10a0e/receive", `{"urn": "foo", "text": "Hello"}`, true)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "invalid urn")
+
+	// channels not in test mode can't have messages injected
+	code, body = server.request(http.MethodPost, "/c/test/dbc126ed-66bc-4e28-b67b-81dc3327c95d/receive", `{"urn": "tel:+12065551212", "text": "Hello"}`, true)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "channel is not in test mode")
+}
@@ -0,0 +1,57 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/nyaruka/courier/translation"
+)
+
+// the language incoming messages are translated to if the channel doesn't specify one
+const defaultFlowLanguage = "eng"
+
+// TranslateOutgoingMsg replaces the text of the passed in msg with its translation if its channel has a translation language
+func TranslateOutgoingMsg(ctx context.Context, translator translation.Translator, msg Msg) error {
+	target := msg.Channel().StringConfigForKey(ConfigTranslationLanguage, "")
+	if translator == nil || target == "" || msg.Text() == "" {
+		return nil
+	}
+
+	translated, err := translator.Translate(ctx, translation.Request{Text: msg.Text(), Target: target})
+	if err != nil {
+		return err
+	}
+	msg.WithText(translated.Text)
+	return nil
+}
+
+// TranslateIncomingMsg adds the translation of the passed in msg to its metadata as `translation` if its channel has a
+// translation language, leaving its text unchanged
+func TranslateIncomingMsg(ctx context.Context, translator translation.Translator, msg Msg) error {
+	source := msg.Channel().StringConfigForKey(ConfigTranslationLanguage, "")
+	if translator == nil || source == "" || msg.Text() == "" {
+		return nil
+	}
+
+	target := msg.Channel().StringConfigForKey(ConfigTranslationFlowLanguage, defaultFlowLanguage)
+	translated, err := translator.Translate(ctx, translation.Request{Text: msg.Text(), Target: target})
+	if err != nil {
+		return err
+	}
+
+	metadata := map[string]interface{}{}
+	if len(msg.Metadata()) > 0 {
+		err = json.Unmarshal(msg.Metadata(), &metadata)
+		if err != nil {
+			return err
+		}
+	}
+	metadata["translation"] = translated
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	msg.WithMetadata(encoded)
+	return nil
+}
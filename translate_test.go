@@ -0,0 +1,66 @@
+package courier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/nyaruka/courier/translation"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTranslator struct {
+	text string
+	err  error
+}
+
+func (t *mockTranslator) Translate(ctx context.Context, req translation.Request) (*translation.Translation, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.text != "" {
+		return &translation.Translation{Text: t.text, Source: "spa", Target: req.Target}, nil
+	}
+	return &translation.Translation{Text: req.Target + ": " + req.Text, Source: "spa", Target: req.Target}, nil
+}
+
+func TestTranslateOutgoingMsg(t *testing.T) {
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "BR", map[string]interface{}{})
+	msg := &mockMsg{channel: channel, text: "Hello"}
+	translator := &mockTranslator{}
+
+	// no translation language, no translation
+	assert.NoError(t, TranslateOutgoingMsg(context.Background(), translator, msg))
+	assert.Equal(t, "Hello", msg.Text())
+
+	channel.SetConfig(ConfigTranslationLanguage, "por")
+	assert.NoError(t, TranslateOutgoingMsg(context.Background(), nil, msg))
+	assert.Equal(t, "Hello", msg.Text())
+
+	assert.NoError(t, TranslateOutgoingMsg(context.Background(), translator, msg))
+	assert.Equal(t, "por: Hello", msg.Text())
+
+	msg.text = "Hello"
+	assert.EqualError(t, TranslateOutgoingMsg(context.Background(), &mockTranslator{err: errors.New("boom")}, msg), "boom")
+	assert.Equal(t, "Hello", msg.Text())
+}
+
+func TestTranslateIncomingMsg(t *testing.T) {
+	channel := NewMockChannel("8eb23e93-5ecb-45ba-b726-3b064e0c568c", "WAC", "12345", "BR", map[string]interface{}{})
+	msg := &mockMsg{channel: channel, text: "Hola", metadata: json.RawMessage(`{"live_location": {"sequence": 1}}`)}
+	translator := &mockTranslator{}
+
+	assert.NoError(t, TranslateIncomingMsg(context.Background(), translator, msg))
+	assert.JSONEq(t, `{"live_location": {"sequence": 1}}`, string(msg.Metadata()))
+
+	channel.SetConfig(ConfigTranslationLanguage, "spa")
+	assert.NoError(t, TranslateIncomingMsg(context.Background(), translator, msg))
+	assert.Equal(t, "Hola", msg.Text())
+	assert.JSONEq(t, `{"live_location": {"sequence": 1}, "translation": {"text": "eng: Hola", "source": "spa", "target": "eng"}}`, string(msg.Metadata()))
+
+	channel.SetConfig(ConfigTranslationFlowLanguage, "fra")
+	msg.metadata = nil
+	assert.NoError(t, TranslateIncomingMsg(context.Background(), translator, msg))
+	assert.JSONEq(t, `{"translation": {"text": "fra: Hola", "source": "spa", "target": "fra"}}`, string(msg.Metadata()))
+}
@@ -0,0 +1,84 @@
+package translation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nyaruka/courier/utils"
+)
+
+// Request is what is passed to a translator for each piece of text, source is empty if it should be detected
+//
+//	{
+//	  "text": "Hola mundo",
+//	  "source": "",
+//	  "target": "eng"
+//	}
+type Request struct {
+	Text   string `json:"text"`
+	Source string `json:"source,omitempty"`
+	Target string `json:"target"`
+}
+
+// Translation is the result of translating some text
+type Translation struct {
+	Text   string `json:"text"`
+	Source string `json:"source,omitempty"`
+	Target string `json:"target"`
+}
+
+// Translator is the interface for anything that can translate message text
+type Translator interface {
+	Translate(ctx context.Context, req Request) (*Translation, error)
+}
+
+// New creates a translator for the passed in provider endpoint, returning nil if it isn't set
+func New(endpoint string) Translator {
+	if endpoint == "" {
+		return nil
+	}
+	return NewHTTPTranslator(endpoint)
+}
+
+// httpTranslator asks an external HTTP provider to translate text
+type httpTranslator struct {
+	endpoint string
+}
+
+// NewHTTPTranslator creates a new translator which POSTs each request to the passed in endpoint, expecting a translation back
+func NewHTTPTranslator(endpoint string) Translator {
+	return &httpTranslator{endpoint: endpoint}
+}
+
+func (t *httpTranslator) Translate(ctx context.Context, req Request) (*Translation, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	rr, err := utils.MakeHTTPRequest(r)
+	if err != nil {
+		return nil, fmt.Errorf("error calling translation endpoint: %s", err)
+	}
+
+	translation := &Translation{}
+	err = json.Unmarshal(rr.Body, translation)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse translation response: %s", err)
+	}
+	if translation.Text == "" {
+		return nil, fmt.Errorf("translation response has no text")
+	}
+
+	translation.Target = req.Target
+	return translation, nil
+}
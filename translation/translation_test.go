@@ -0,0 +1,51 @@
+package translation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	assert.Nil(t, New(""))
+	assert.IsType(t, &httpTranslator{}, New("http://translation.example.com"))
+}
+
+func TestHTTPTranslator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &Request{}
+		json.NewDecoder(r.Body).Decode(req)
+
+		switch req.Text {
+		case "Hola mundo":
+			assert.Equal(t, "eng", req.Target)
+			w.Write([]byte(`{"text": "Hello world", "source": "spa"}`))
+		case "broken":
+			w.Write([]byte(`not json`))
+		case "empty":
+			w.Write([]byte(`{"text": ""}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	tr := NewHTTPTranslator(server.URL)
+
+	translation, err := tr.Translate(context.Background(), Request{Text: "Hola mundo", Target: "eng"})
+	assert.NoError(t, err)
+	assert.Equal(t, &Translation{Text: "Hello world", Source: "spa", Target: "eng"}, translation)
+
+	_, err = tr.Translate(context.Background(), Request{Text: "broken", Target: "eng"})
+	assert.Error(t, err)
+
+	_, err = tr.Translate(context.Background(), Request{Text: "empty", Target: "eng"})
+	assert.EqualError(t, err, "translation response has no text")
+
+	_, err = tr.Translate(context.Background(), Request{Text: "error", Target: "eng"})
+	assert.Error(t, err)
+}
@@ -21,6 +21,7 @@ type RequestResponse struct {
 	URL           string
 	Status        RequestResponseStatus
 	StatusCode    int
+	Header        http.Header
 	Request       string
 	Response      string
 	Body          []byte
@@ -95,6 +96,7 @@ func newRRFromResponse(method string, requestTrace string, r *http.Response) (*R
 	rr.Method = method
 	rr.URL = r.Request.URL.String()
 	rr.StatusCode = r.StatusCode
+	rr.Header = r.Header
 
 	// set our content length if we have its header
 
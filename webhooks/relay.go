@@ -0,0 +1,181 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/nyaruka/courier/retries"
+	"github.com/nyaruka/courier/utils"
+	"github.com/nyaruka/gocommon/uuids"
+	"github.com/sirupsen/logrus"
+)
+
+// SignatureHeader is the header deliveries to webhooks with a secret are signed in
+const SignatureHeader = "X-Courier-Signature"
+
+const (
+	// the hash of deliveries waiting to be retried keyed by their UUID, and the sorted set of when each is due
+	deliveriesKey = "webhooks:deliveries"
+	pendingKey    = "webhooks:pending"
+
+	// the list of deliveries we gave up on, newest first
+	deadLettersKey = "webhooks:dead_letters"
+
+	// how many times we try a delivery before giving up on it
+	maxAttempts = 8
+
+	// how long we wait before the first retry of a delivery, doubling for each retry after that
+	baseBackoff = 10 * time.Second
+	maxBackoff  = time.Hour
+)
+
+// Delivery is an event being delivered to a webhook, e.g.
+//
+//	{
+//	  "uuid": "e7187099-7d38-4f60-955c-325957214c42",
+//	  "channel_uuid": "dbc126ed-66bc-4e28-b67b-81dc3327c95d",
+//	  "url": "https://crm.example.com/hooks/whatsapp",
+//	  "method": "POST",
+//	  "headers": {"X-Courier-Signature": "sha256=..."},
+//	  "body": "eyJvYmplY3QiOiAid2hhdHNhcHBfYnVzaW5lc3NfYWNjb3VudCJ9",
+//	  "attempts": 3,
+//	  "last_error": "received non 200 status: 503",
+//	  "created_on": "2022-10-05T15:04:05.123Z"
+//	}
+type Delivery struct {
+	UUID        uuids.UUID        `json:"uuid"`
+	ChannelUUID string            `json:"channel_uuid,omitempty"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Body        []byte            `json:"body"`
+	CreatedOn   time.Time         `json:"created_on"`
+
+	retries.State
+}
+
+// ItemID returns the id of this delivery in our retry queue
+func (d *Delivery) ItemID() string { return string(d.UUID) }
+
+// NewDelivery creates a new delivery of the passed in body, signed with the passed in secret if it isn't empty
+func NewDelivery(channelUUID string, url string, method string, headers map[string]string, body []byte, secret string) *Delivery {
+	d := &Delivery{
+		UUID:        uuids.New(),
+		ChannelUUID: channelUUID,
+		URL:         url,
+		Method:      method,
+		Headers:     make(map[string]string, len(headers)+1),
+		Body:        body,
+		CreatedOn:   time.Now().UTC(),
+	}
+	for name, value := range headers {
+		d.Headers[name] = value
+	}
+	if secret != "" {
+		d.Headers[SignatureHeader] = Sign(secret, body)
+	}
+	return d
+}
+
+// Attempt makes a single attempt to deliver this delivery
+func (d *Delivery) Attempt() error {
+	req, err := http.NewRequest(d.Method, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	for name, value := range d.Headers {
+		req.Header.Set(name, value)
+	}
+
+	_, err = utils.MakeHTTPRequest(req)
+	return err
+}
+
+// Sign returns the signature of a delivered body, i.e. the hex HMAC-SHA256 of it with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// the queue of deliveries waiting to be retried
+var retryOptions = &retries.Options{
+	Name:           "webhook deliveries",
+	ItemsKey:       deliveriesKey,
+	PendingKey:     pendingKey,
+	DeadLettersKey: deadLettersKey,
+	MaxAttempts:    maxAttempts,
+	BaseBackoff:    baseBackoff,
+	MaxBackoff:     maxBackoff,
+}
+
+// Backoff returns how long we wait to retry a delivery which has failed the passed in number of attempts
+func Backoff(attempts int) time.Duration {
+	return retryOptions.Backoff(attempts)
+}
+
+// attempts the deliveries of our retry queue
+type deliveryHandler struct{}
+
+func (deliveryHandler) NewItem() retries.Item           { return &Delivery{} }
+func (deliveryHandler) Attempt(item retries.Item) error { return item.(*Delivery).Attempt() }
+func (deliveryHandler) GiveUp(item retries.Item) {
+	d := item.(*Delivery)
+	logrus.WithField("url", d.URL).WithField("channel_uuid", d.ChannelUUID).WithField("attempts", d.Attempts).WithField("error", d.LastError).Error("giving up on webhook delivery")
+}
+
+// Relay delivers webhooks, retrying failed deliveries from a queue in Redis with exponential backoff until they
+// succeed or we give up on them and add them to our dead letters
+type Relay struct {
+	queue *retries.Queue
+}
+
+// NewRelay creates a new relay which queues deliveries to retry in the passed in Redis
+func NewRelay(rp *redis.Pool) *Relay {
+	return &Relay{queue: retries.NewQueue(rp, retryOptions, deliveryHandler{})}
+}
+
+// Send attempts the passed in delivery, queuing it to be retried if that fails. The error of the attempt is returned.
+func (r *Relay) Send(d *Delivery) error {
+	err := d.Attempt()
+	if err != nil {
+		if qerr := r.queue.Failed(d, err, time.Now()); qerr != nil {
+			logrus.WithError(qerr).WithField("url", d.URL).Error("error queuing webhook delivery for retry")
+		}
+	}
+	return err
+}
+
+// Start starts retrying queued deliveries in the background
+func (r *Relay) Start() {
+	r.queue.Start()
+}
+
+// Stop stops retrying deliveries, waiting for any being retried
+func (r *Relay) Stop() {
+	r.queue.Stop()
+}
+
+// retryDue retries the deliveries which are due at the passed in time, returning how many were retried
+func (r *Relay) retryDue(now time.Time) (int, error) {
+	return r.queue.RetryDue(now)
+}
+
+// DeadLetters returns the most recent deliveries we gave up on, newest first
+func DeadLetters(rp *redis.Pool, limit int) ([]*Delivery, error) {
+	items, err := retries.NewQueue(rp, retryOptions, deliveryHandler{}).DeadLetters(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*Delivery, len(items))
+	for i, item := range items {
+		deliveries[i] = item.(*Delivery)
+	}
+	return deliveries, nil
+}
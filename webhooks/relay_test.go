@@ -0,0 +1,139 @@
+package webhooks
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getPool() *redis.Pool {
+	redisPool := &redis.Pool{
+		Wait:        true,
+		MaxActive:   5,
+		MaxIdle:     2,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", "localhost:6379")
+			if err != nil {
+				return nil, err
+			}
+			_, err = conn.Do("SELECT", 11)
+			return conn, err
+		},
+	}
+	conn := redisPool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("FLUSHDB")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return redisPool
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=5d5d139563c95b5967b9bd9a8c9b233a9dedb45072794cd232dc1b74832607d0", Sign("key", []byte("")))
+
+	d := NewDelivery("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "https://example.com", "POST", map[string]string{"Authorization": "Token 123"}, []byte(`{}`), "sesame")
+	assert.Equal(t, map[string]string{"Authorization": "Token 123", SignatureHeader: Sign("sesame", []byte(`{}`))}, d.Headers)
+
+	d = NewDelivery("dbc126ed-66bc-4e28-b67b-81dc3327c95d", "https://example.com", "POST", nil, []byte(`{}`), "")
+	assert.Equal(t, map[string]string{}, d.Headers)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 10*time.Second, Backoff(1))
+	assert.Equal(t, 20*time.Second, Backoff(2))
+	assert.Equal(t, 80*time.Second, Backoff(4))
+	assert.Equal(t, time.Hour, Backoff(10))
+	assert.Equal(t, time.Hour, Backoff(100))
+}
+
+func TestRelay(t *testing.T) {
+	rp := getPool()
+	rc := rp.Get()
+	defer rc.Close()
+
+	failing := true
+	var received []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, string(body))
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	relay := NewRelay(rp)
+	now := time.Now()
+
+	// deliveries which succeed aren't queued
+	err := relay.Send(NewDelivery("dbc126ed-66bc-4e28-b67b-81dc3327c95d", server.URL, "POST", nil, []byte(`{"id": 1}`), "sesame"))
+	assert.Error(t, err)
+	failing = false
+	err = relay.Send(NewDelivery("dbc126ed-66bc-4e28-b67b-81dc3327c95d", server.URL, "POST", nil, []byte(`{"id": 2}`), ""))
+	assert.NoError(t, err)
+	assert.Len(t, received, 2)
+
+	// only the failed one is waiting to be retried
+	pending, err := redis.Int(rc.Do("ZCARD", pendingKey))
+	require.NoError(t, err)
+	assert.Equal(t, 1, pending)
+
+	// and it isn't retried until its backoff is over
+	retried, err := relay.retryDue(now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, retried)
+
+	retried, err = relay.retryDue(now.Add(Backoff(1) + time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retried)
+	assert.Len(t, received, 3)
+	assert.Equal(t, `{"id": 1}`, bodies[2])
+	assert.Equal(t, Sign("sesame", []byte(`{"id": 1}`)), received[2].Header.Get(SignatureHeader))
+
+	// once delivered, it's gone
+	pending, _ = redis.Int(rc.Do("ZCARD", pendingKey))
+	assert.Equal(t, 0, pending)
+	stored, _ := redis.Int(rc.Do("HLEN", deliveriesKey))
+	assert.Equal(t, 0, stored)
+
+	// deliveries which keep failing end up in our dead letters
+	failing = true
+	relay.Send(NewDelivery("dbc126ed-66bc-4e28-b67b-81dc3327c95d", server.URL, "PUT", nil, []byte(`{"id": 3}`), ""))
+	for i := 1; i < maxAttempts; i++ {
+		retried, err = relay.retryDue(now.Add(time.Duration(i) * maxBackoff * 2))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, retried)
+	}
+	retried, _ = relay.retryDue(now.Add(maxAttempts * maxBackoff * 2))
+	assert.Equal(t, 0, retried)
+
+	dead, err := DeadLetters(rp, 10)
+	assert.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, []byte(`{"id": 3}`), dead[0].Body)
+	assert.Equal(t, "PUT", dead[0].Method)
+	assert.Equal(t, maxAttempts, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "503")
+
+	stored, _ = redis.Int(rc.Do("HLEN", deliveriesKey))
+	assert.Equal(t, 0, stored)
+}
+
+func TestRelayStartStop(t *testing.T) {
+	relay := NewRelay(getPool())
+	relay.Start()
+	relay.Stop()
+}